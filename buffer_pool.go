@@ -2,6 +2,7 @@ package quic
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 )
@@ -9,17 +10,19 @@ import (
 type packetBuffer struct {
 	Slice []byte
 
-	// refCount counts how many packets the Slice is used in.
-	// It doesn't support concurrent use.
-	// It is > 1 when used for coalesced packet.
-	refCount int
+	// refCount counts how many packets and STREAM frames the Slice is used in.
+	// It is > 1 when used for coalesced packet,
+	// or when STREAM frames referencing the Slice are queued in a receive stream.
+	// It is modified atomically, since receive streams release the buffer on the application's go routine.
+	refCount int32
 }
 
 // Split increases the refCount.
 // It must be called when a packet buffer is used for more than one packet,
-// e.g. when splitting coalesced packets.
+// e.g. when splitting coalesced packets,
+// or when a STREAM frame referencing the packet buffer is retained.
 func (b *packetBuffer) Split() {
-	b.refCount++
+	atomic.AddInt32(&b.refCount, 1)
 }
 
 // Release decreases the refCount.
@@ -29,12 +32,12 @@ func (b *packetBuffer) Release() {
 	if cap(b.Slice) != int(protocol.MaxReceivePacketSize) {
 		panic("putPacketBuffer called with packet of wrong size!")
 	}
-	b.refCount--
-	if b.refCount < 0 {
+	refCount := atomic.AddInt32(&b.refCount, -1)
+	if refCount < 0 {
 		panic("negative packetBuffer refCount")
 	}
 	// only put the packetBuffer back if it's not used any more
-	if b.refCount == 0 {
+	if refCount == 0 {
 		bufferPool.Put(b)
 	}
 }
@@ -9,7 +9,6 @@ import (
 
 	"github.com/lucas-clemente/quic-go/internal/handshake"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
-	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 )
@@ -22,9 +21,19 @@ type client struct {
 	// If it is started with Dial, we take a packet conn as a parameter.
 	createdPacketConn bool
 
-	packetHandlers packetHandlerManager
+	// packetHandlersMutex protects packetHandlers, migrationHandlers and resetToken.
+	// The session might migrate to a new packet conn, which uses a different packet handler manager.
+	packetHandlersMutex sync.Mutex
+	packetHandlers      packetHandlerManager
+	// the packet handler managers of the packet conns the session is trying to migrate to
+	migrationHandlers map[connection]packetHandlerManager
+	// the stateless reset token sent by the server
+	resetToken *[16]byte
 
-	token []byte
+	// the token sent in Initial packets
+	// It is either taken from the TokenStore, or it was received in a Retry packet.
+	token         []byte
+	receivedRetry bool
 
 	versionNegotiated                utils.AtomicBool // has the server accepted our version
 	receivedVersionNegotiationPacket bool
@@ -59,7 +68,8 @@ var (
 
 // DialAddr establishes a new QUIC connection to a server.
 // It uses a new UDP connection and closes this connection when the QUIC session is closed.
-// The hostname for SNI is taken from the given address.
+// The hostname for SNI and for the verification of the server's certificate is taken from the given address,
+// unless tls.Config.ServerName is set. Certificate verification can be customized using tls.Config.VerifyPeerCertificate.
 func DialAddr(
 	addr string,
 	tlsConf *tls.Config,
@@ -69,6 +79,8 @@ func DialAddr(
 }
 
 // DialAddrContext establishes a new QUIC connection to a server using the provided context.
+// If the context is canceled before the handshake completes, the handshake is aborted,
+// the UDP connection is closed, and the context's error is returned.
 // See DialAddr for details.
 func DialAddrContext(
 	ctx context.Context,
@@ -84,13 +96,27 @@ func DialAddrContext(
 	if err != nil {
 		return nil, err
 	}
-	return dialContext(ctx, udpConn, udpAddr, addr, tlsConf, config, true)
+	if config != nil {
+		if err := setDiffServCodePoint(udpConn, config.DiffServCodePoint); err != nil {
+			udpConn.Close()
+			return nil, err
+		}
+	}
+	sess, err := dialContext(ctx, udpConn, udpAddr, addr, tlsConf, config, true)
+	if err != nil {
+		// If the session was already started, it closes the UDP connection itself.
+		// Dialing might also have failed before that.
+		udpConn.Close()
+		return nil, err
+	}
+	return sess, nil
 }
 
 // Dial establishes a new QUIC connection to a server using a net.PacketConn.
 // The same PacketConn can be used for multiple calls to Dial and Listen,
 // QUIC connection IDs are used for demultiplexing the different connections.
-// The host parameter is used for SNI.
+// The host parameter is used for SNI and for the verification of the server's certificate,
+// unless tls.Config.ServerName is set.
 func Dial(
 	pconn net.PacketConn,
 	remoteAddr net.Addr,
@@ -102,6 +128,8 @@ func Dial(
 }
 
 // DialContext establishes a new QUIC connection to a server using a net.PacketConn using the provided context.
+// If the context is canceled before the handshake completes, the handshake is aborted, and the context's error is returned.
+// The net.PacketConn is not closed.
 // See Dial for details.
 func DialContext(
 	ctx context.Context,
@@ -124,10 +152,13 @@ func dialContext(
 	createdPacketConn bool,
 ) (Session, error) {
 	config = populateClientConfig(config, createdPacketConn)
-	packetHandlers, err := getMultiplexer().AddConn(pconn, config.ConnectionIDLength)
+	packetHandlers, err := getMultiplexer().AddConn(pconn, config.ConnectionIDLength, config.StatelessResetKey)
 	if err != nil {
 		return nil, err
 	}
+	if config.NonQUICPacketHandler != nil {
+		packetHandlers.SetNonQUICPacketHandler(config.NonQUICPacketHandler)
+	}
 	c, err := newClient(pconn, remoteAddr, config, tlsConf, host, createdPacketConn)
 	if err != nil {
 		return nil, err
@@ -149,6 +180,10 @@ func newClient(
 ) (*client, error) {
 	if tlsConf == nil {
 		tlsConf = &tls.Config{}
+	} else {
+		// The tls.Config might be used for multiple Dial calls,
+		// so the ServerName must only be set on a copy.
+		tlsConf = tlsConf.Clone()
 	}
 	if tlsConf.ServerName == "" {
 		var err error
@@ -166,6 +201,15 @@ func newClient(
 			}
 		}
 	}
+	if err := validateFlowControlWindows(config); err != nil {
+		return nil, err
+	}
+	if err := validateCongestionWindows(config); err != nil {
+		return nil, err
+	}
+	if err := validateAckSettings(config); err != nil {
+		return nil, err
+	}
 
 	srcConnID, err := generateConnectionID(config.ConnectionIDLength)
 	if err != nil {
@@ -175,16 +219,27 @@ func newClient(
 	if err != nil {
 		return nil, err
 	}
+	cn := &conn{pconn: pconn, currentAddr: remoteAddr}
+	// Only use ECN on packet conns that we created, since it changes the socket options.
+	if udpConn, ok := pconn.(*net.UDPConn); ok && createdPacketConn {
+		cn.ecnOOB = enableECN(udpConn, config.DiffServCodePoint)
+	}
 	c := &client{
 		srcConnID:         srcConnID,
 		destConnID:        destConnID,
-		conn:              &conn{pconn: pconn, currentAddr: remoteAddr},
+		conn:              cn,
 		createdPacketConn: createdPacketConn,
 		tlsConf:           tlsConf,
 		config:            config,
 		version:           config.Versions[0],
 		handshakeChan:     make(chan struct{}),
-		logger:            utils.DefaultLogger.WithPrefix("client"),
+		logger:            getLogger(config).WithPrefix("client"),
+	}
+	if config.TestVersionNegotiation {
+		c.version = protocol.GenerateReservedVersion()
+	}
+	if config.TokenStore != nil {
+		c.token = config.TokenStore.Pop(c.tlsConf.ServerName)
 	}
 	return c, nil
 }
@@ -229,6 +284,26 @@ func populateClientConfig(config *Config, createdPacketConn bool) *Config {
 	} else if maxIncomingUniStreams < 0 {
 		maxIncomingUniStreams = 0
 	}
+	initialCongestionWindow := config.InitialCongestionWindow
+	if initialCongestionWindow == 0 {
+		initialCongestionWindow = uint32(protocol.InitialCongestionWindow / protocol.DefaultTCPMSS)
+	}
+	minCongestionWindow := config.MinCongestionWindow
+	if minCongestionWindow == 0 {
+		minCongestionWindow = uint32(protocol.DefaultMinCongestionWindow / protocol.DefaultTCPMSS)
+		// The default minimum must not exceed a small initial congestion window.
+		if minCongestionWindow > initialCongestionWindow {
+			minCongestionWindow = initialCongestionWindow
+		}
+	}
+	maxAckDelay := config.MaxAckDelay
+	if maxAckDelay == 0 {
+		maxAckDelay = protocol.DefaultMaxAckDelay
+	}
+	ackFrequency := config.AckFrequency
+	if ackFrequency == 0 {
+		ackFrequency = protocol.DefaultAckFrequency
+	}
 	connIDLen := config.ConnectionIDLength
 	if connIDLen == 0 && !createdPacketConn {
 		connIDLen = protocol.DefaultConnectionIDLength
@@ -236,14 +311,34 @@ func populateClientConfig(config *Config, createdPacketConn bool) *Config {
 
 	return &Config{
 		Versions:                              versions,
+		VersionNegotiationCallback:            config.VersionNegotiationCallback,
+		TestVersionNegotiation:                config.TestVersionNegotiation,
 		HandshakeTimeout:                      handshakeTimeout,
 		IdleTimeout:                           idleTimeout,
 		ConnectionIDLength:                    connIDLen,
 		MaxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
 		MaxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
+		MaxStreamSendBuffer:                   config.MaxStreamSendBuffer,
 		MaxIncomingStreams:                    maxIncomingStreams,
 		MaxIncomingUniStreams:                 maxIncomingUniStreams,
+		TokenStore:                            config.TokenStore,
 		KeepAlive:                             config.KeepAlive,
+		KeepAlivePeriod:                       config.KeepAlivePeriod,
+		EnableDatagrams:                       config.EnableDatagrams,
+		DisableSpinBit:                        config.DisableSpinBit,
+		DisableMigration:                      config.DisableMigration,
+		StatelessResetKey:                     config.StatelessResetKey,
+		NonQUICPacketHandler:                  config.NonQUICPacketHandler,
+		DiffServCodePoint:                     config.DiffServCodePoint,
+		CongestionControl:                     config.CongestionControl,
+		InitialCongestionWindow:               initialCongestionWindow,
+		MinCongestionWindow:                   minCongestionWindow,
+		MaxAckDelay:                           maxAckDelay,
+		AckFrequency:                          ackFrequency,
+		Tracer:                                config.Tracer,
+		GetLogWriter:                          config.GetLogWriter,
+		Logger:                                config.Logger,
+		Clock:                                 config.Clock,
 	}
 }
 
@@ -284,6 +379,12 @@ func (c *client) establishSecureConnection(ctx context.Context) error {
 	case err := <-errorChan:
 		return err
 	case <-c.handshakeChan:
+		// The context might have been canceled at the same time the handshake completed.
+		// Don't return a session that the caller already gave up on.
+		if err := ctx.Err(); err != nil {
+			c.session.Close()
+			return err
+		}
 		// handshake successfully completed
 		return nil
 	}
@@ -328,9 +429,12 @@ func (c *client) handleVersionNegotiationPacket(hdr *wire.Header) {
 	}
 
 	c.logger.Infof("Received a Version Negotiation packet. Supported Versions: %s", hdr.SupportedVersions)
-	newVersion, ok := protocol.ChooseSupportedVersion(c.config.Versions, hdr.SupportedVersions)
+	newVersion, ok := chooseVersion(c.config, hdr.SupportedVersions)
 	if !ok {
-		c.session.destroy(qerr.InvalidVersion)
+		c.session.destroy(&VersionNegotiationError{
+			Ours:   c.config.Versions,
+			Theirs: protocol.StripGreasedVersions(hdr.SupportedVersions),
+		})
 		c.logger.Debugf("No compatible version found.")
 		return
 	}
@@ -345,6 +449,19 @@ func (c *client) handleVersionNegotiationPacket(hdr *wire.Header) {
 	c.initialPacketNumber = c.session.closeForRecreating()
 }
 
+// chooseVersion chooses the version to use from the versions supported by the server,
+// using the VersionNegotiationCallback if it is set.
+func chooseVersion(config *Config, theirs []protocol.VersionNumber) (protocol.VersionNumber, bool) {
+	if config.VersionNegotiationCallback == nil {
+		return protocol.ChooseSupportedVersion(config.Versions, theirs)
+	}
+	v, ok := config.VersionNegotiationCallback(protocol.StripGreasedVersions(theirs))
+	if !ok || !protocol.IsSupportedVersion(config.Versions, v) || !protocol.IsSupportedVersion(theirs, v) {
+		return 0, false
+	}
+	return v, true
+}
+
 func (c *client) handleRetryPacket(hdr *wire.Header) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -359,12 +476,13 @@ func (c *client) handleRetryPacket(hdr *wire.Header) {
 		c.logger.Debugf("Ignoring Retry, since the server didn't change the Source Connection ID.")
 		return
 	}
-	// If a token is already set, this means that we already received a Retry from the server.
-	// Ignore this Retry packet.
-	if len(c.token) > 0 {
+	if c.receivedRetry {
 		c.logger.Debugf("Ignoring Retry, since a Retry was already received.")
 		return
 	}
+	// If the server sends a Retry although we sent a token from the TokenStore,
+	// the token was not accepted (e.g. because it expired). Use the token from the Retry instead.
+	c.receivedRetry = true
 	c.origDestConnID = c.destConnID
 	c.destConnID = hdr.SrcConnectionID
 	c.token = hdr.Token
@@ -373,22 +491,48 @@ func (c *client) handleRetryPacket(hdr *wire.Header) {
 
 func (c *client) createNewTLSSession(version protocol.VersionNumber) error {
 	params := &handshake.TransportParameters{
-		InitialMaxStreamDataBidiRemote: protocol.InitialMaxStreamData,
-		InitialMaxStreamDataBidiLocal:  protocol.InitialMaxStreamData,
-		InitialMaxStreamDataUni:        protocol.InitialMaxStreamData,
-		InitialMaxData:                 protocol.InitialMaxData,
+		InitialMaxStreamDataBidiRemote: initialMaxStreamData(c.config),
+		InitialMaxStreamDataBidiLocal:  initialMaxStreamData(c.config),
+		InitialMaxStreamDataUni:        initialMaxStreamData(c.config),
+		InitialMaxData:                 initialMaxData(c.config),
 		IdleTimeout:                    c.config.IdleTimeout,
 		MaxBidiStreams:                 uint64(c.config.MaxIncomingStreams),
 		MaxUniStreams:                  uint64(c.config.MaxIncomingUniStreams),
 		DisableMigration:               true,
+		MaxDatagramFrameSize:           maxDatagramFrameSize(c.config),
+		MaxAckDelay:                    c.config.MaxAckDelay,
 	}
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 	runner := &runner{
-		onHandshakeCompleteImpl: func(_ Session) { close(c.handshakeChan) },
-		retireConnectionIDImpl:  c.packetHandlers.Retire,
-		removeConnectionIDImpl:  c.packetHandlers.Remove,
+		onHandshakeCompleteImpl: func(_ quicSession) { close(c.handshakeChan) },
+		addConnectionIDImpl: func(id protocol.ConnectionID, _ quicSession) bool {
+			return c.getPacketHandlers().AddIfNotTaken(id, c)
+		},
+		getStatelessResetTokenImpl: func(id protocol.ConnectionID) [16]byte {
+			return c.getPacketHandlers().GetStatelessResetToken(id)
+		},
+		retireConnectionIDImpl: func(id protocol.ConnectionID) { c.getPacketHandlers().Retire(id) },
+		removeConnectionIDImpl: func(id protocol.ConnectionID) { c.getPacketHandlers().Remove(id) },
+		addResetTokenImpl:      c.addResetToken,
+		addTokenImpl:           c.addToken,
+		addPacketConnImpl:      c.addPacketConn,
+		switchPacketConnImpl:   c.switchPacketConn,
+		removePacketConnImpl:   c.removePacketConn,
+	}
+	// The packet conn might be shared with other sessions.
+	// Make sure that the source connection ID is not used by any of them.
+	for i := 1; !c.packetHandlers.AddIfNotTaken(c.srcConnID, c); i++ {
+		if i >= protocol.MaxConnectionIDGenerationAttempts {
+			return fmt.Errorf("failed to generate an unused connection ID after %d attempts", i)
+		}
+		srcConnID, err := generateConnectionID(c.config.ConnectionIDLength)
+		if err != nil {
+			return err
+		}
+		c.logger.Debugf("Source connection ID %s is already in use. Switching to %s.", c.srcConnID, srcConnID)
+		c.srcConnID = srcConnID
 	}
 	sess, err := newClientSession(
 		c.conn,
@@ -406,13 +550,90 @@ func (c *client) createNewTLSSession(version protocol.VersionNumber) error {
 		c.version,
 	)
 	if err != nil {
+		c.packetHandlers.Remove(c.srcConnID)
 		return err
 	}
 	c.session = sess
-	c.packetHandlers.Add(c.srcConnID, c)
 	return nil
 }
 
+// addToken is called by the session when it received a token in a NEW_TOKEN frame.
+func (c *client) addToken(token []byte) {
+	if c.config.TokenStore == nil {
+		return
+	}
+	c.config.TokenStore.Put(c.tlsConf.ServerName, token)
+}
+
+func (c *client) getPacketHandlers() packetHandlerManager {
+	c.packetHandlersMutex.Lock()
+	defer c.packetHandlersMutex.Unlock()
+	return c.packetHandlers
+}
+
+// addResetToken is called by the session when it received the stateless reset token of the server.
+func (c *client) addResetToken(token [16]byte) {
+	c.packetHandlersMutex.Lock()
+	defer c.packetHandlersMutex.Unlock()
+	c.resetToken = &token
+	c.packetHandlers.AddWithResetToken(c.srcConnID, c, token)
+}
+
+// addPacketConn starts receiving packets for this client on a new packet conn.
+// It is called by the session when it starts migrating to this packet conn.
+func (c *client) addPacketConn(pconn net.PacketConn) (connection, error) {
+	packetHandlers, err := getMultiplexer().AddConn(pconn, c.config.ConnectionIDLength, c.config.StatelessResetKey)
+	if err != nil {
+		return nil, err
+	}
+	conn := &conn{pconn: pconn, currentAddr: c.conn.RemoteAddr()}
+
+	c.packetHandlersMutex.Lock()
+	defer c.packetHandlersMutex.Unlock()
+	if c.resetToken != nil {
+		packetHandlers.AddWithResetToken(c.srcConnID, c, *c.resetToken)
+	} else {
+		packetHandlers.Add(c.srcConnID, c)
+	}
+	if c.migrationHandlers == nil {
+		c.migrationHandlers = make(map[connection]packetHandlerManager)
+	}
+	c.migrationHandlers[conn] = packetHandlers
+	return conn, nil
+}
+
+// switchPacketConn is called by the session when it migrated to a new packet conn.
+// We stop receiving packets on the old packet conn, and close it if we created it.
+func (c *client) switchPacketConn(conn connection) {
+	c.packetHandlersMutex.Lock()
+	defer c.packetHandlersMutex.Unlock()
+	packetHandlers, ok := c.migrationHandlers[conn]
+	if !ok {
+		return
+	}
+	delete(c.migrationHandlers, conn)
+	c.packetHandlers.Remove(c.srcConnID)
+	if c.createdPacketConn {
+		c.conn.Close()
+	}
+	c.packetHandlers = packetHandlers
+	c.conn = conn
+	// the new packet conn was passed to us by the application
+	c.createdPacketConn = false
+}
+
+// removePacketConn is called by the session when migrating to a new packet conn failed.
+func (c *client) removePacketConn(conn connection) {
+	c.packetHandlersMutex.Lock()
+	defer c.packetHandlersMutex.Unlock()
+	packetHandlers, ok := c.migrationHandlers[conn]
+	if !ok {
+		return
+	}
+	delete(c.migrationHandlers, conn)
+	packetHandlers.Remove(c.srcConnID)
+}
+
 func (c *client) Close() error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"time"
@@ -12,7 +13,6 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/lucas-clemente/quic-go/internal/handshake"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
-	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 
@@ -130,8 +130,8 @@ var _ = Describe("Client", func() {
 			}
 
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any())
-			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any()).Return(manager, nil)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			remoteAddrChan := make(chan string, 1)
 			newClientSession = func(
@@ -161,8 +161,8 @@ var _ = Describe("Client", func() {
 
 		It("uses the tls.Config.ServerName as the hostname, if present", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any())
-			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any()).Return(manager, nil)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			hostnameChan := make(chan string, 1)
 			newClientSession = func(
@@ -190,10 +190,46 @@ var _ = Describe("Client", func() {
 			Eventually(hostnameChan).Should(Receive(Equal("foobar")))
 		})
 
+		It("doesn't modify the tls.Config", func() {
+			manager := NewMockPacketHandlerManager(mockCtrl)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true).Times(2)
+			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any(), gomock.Any()).Return(manager, nil).Times(2)
+
+			hostnameChan := make(chan string, 2)
+			newClientSession = func(
+				_ connection,
+				_ sessionRunner,
+				_ []byte, // token
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ *Config,
+				tlsConf *tls.Config,
+				_ protocol.PacketNumber,
+				_ *handshake.TransportParameters,
+				_ protocol.VersionNumber,
+				_ utils.Logger,
+				_ protocol.VersionNumber,
+			) (quicSession, error) {
+				hostnameChan <- tlsConf.ServerName
+				sess := NewMockQuicSession(mockCtrl)
+				sess.EXPECT().run()
+				return sess, nil
+			}
+			tlsConf := &tls.Config{}
+			_, err := DialAddr("localhost:17890", tlsConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(hostnameChan).Should(Receive(Equal("localhost")))
+			Expect(tlsConf.ServerName).To(BeEmpty())
+			_, err = DialAddr("127.0.0.1:17890", tlsConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(hostnameChan).Should(Receive(Equal("127.0.0.1")))
+		})
+
 		It("returns after the handshake is complete", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any())
-			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			run := make(chan struct{})
 			newClientSession = func(
@@ -230,8 +266,8 @@ var _ = Describe("Client", func() {
 
 		It("returns an error that occurs while waiting for the connection to become secure", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any())
-			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			testErr := errors.New("early handshake error")
 			newClientSession = func(
@@ -266,8 +302,8 @@ var _ = Describe("Client", func() {
 
 		It("closes the session when the context is canceled", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any())
-			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			sessionRunning := make(chan struct{})
 			defer close(sessionRunning)
@@ -313,11 +349,87 @@ var _ = Describe("Client", func() {
 			Eventually(dialed).Should(BeClosed())
 		})
 
+		It("closes the session when the context is canceled at the same time the handshake completes", func() {
+			manager := NewMockPacketHandlerManager(mockCtrl)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			sessionRunning := make(chan struct{})
+			defer close(sessionRunning)
+			sess := NewMockQuicSession(mockCtrl)
+			newClientSession = func(
+				_ connection,
+				runner sessionRunner,
+				_ []byte, // token
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ *Config,
+				_ *tls.Config,
+				_ protocol.PacketNumber,
+				_ *handshake.TransportParameters,
+				_ protocol.VersionNumber,
+				_ utils.Logger,
+				_ protocol.VersionNumber,
+			) (quicSession, error) {
+				sess.EXPECT().run().Do(func() {
+					cancel()
+					runner.onHandshakeComplete(sess)
+					<-sessionRunning
+				})
+				return sess, nil
+			}
+			sess.EXPECT().Close()
+			_, err := DialContext(ctx, packetConn, addr, "localhost:1337", nil, &Config{})
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		It("closes the packet conn when dialing fails, if it was created by DialAddr", func() {
+			manager := NewMockPacketHandlerManager(mockCtrl)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			manager.EXPECT().Remove(gomock.Any())
+			var pconn net.PacketConn
+			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(c net.PacketConn, _ int, _ []byte) (packetHandlerManager, error) {
+				pconn = c
+				return manager, nil
+			})
+
+			testErr := errors.New("test error")
+			newClientSession = func(
+				_ connection,
+				_ sessionRunner,
+				_ []byte, // token
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ *Config,
+				_ *tls.Config,
+				_ protocol.PacketNumber,
+				_ *handshake.TransportParameters,
+				_ protocol.VersionNumber,
+				_ utils.Logger,
+				_ protocol.VersionNumber,
+			) (quicSession, error) {
+				return nil, testErr
+			}
+			_, err := DialAddrContext(context.Background(), "localhost:1337", nil, nil)
+			Expect(err).To(MatchError(testErr))
+			Expect(pconn).ToNot(BeNil())
+			_, err = pconn.WriteTo([]byte("foobar"), addr)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("errors if the DiffServCodePoint can't be applied", func() {
+			_, err := DialAddr("localhost:1337", nil, &Config{DiffServCodePoint: 64})
+			Expect(err).To(MatchError("quic: invalid DiffServCodePoint (64), must be smaller than 64"))
+		})
+
 		It("removes closed sessions from the multiplexer", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(connID, gomock.Any())
+			manager.EXPECT().AddIfNotTaken(connID, gomock.Any()).Return(true)
 			manager.EXPECT().Retire(connID)
-			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			var runner sessionRunner
 			sess := NewMockQuicSession(mockCtrl)
@@ -354,14 +466,63 @@ var _ = Describe("Client", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		It("uses a different source connection ID if it is already used on the packet conn", func() {
+			newConnID := protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef}
+			connIDs := []protocol.ConnectionID{connID, newConnID}
+			generateConnectionID = func(int) (protocol.ConnectionID, error) {
+				c := connIDs[0]
+				connIDs = connIDs[1:]
+				return c, nil
+			}
+			manager := NewMockPacketHandlerManager(mockCtrl)
+			gomock.InOrder(
+				manager.EXPECT().AddIfNotTaken(connID, gomock.Any()).Return(false),
+				manager.EXPECT().AddIfNotTaken(newConnID, gomock.Any()).Return(true),
+			)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+
+			srcConnIDChan := make(chan protocol.ConnectionID, 1)
+			newClientSession = func(
+				_ connection,
+				runner sessionRunner,
+				_ []byte, // token
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				srcConnID protocol.ConnectionID,
+				_ *Config,
+				_ *tls.Config,
+				_ protocol.PacketNumber,
+				_ *handshake.TransportParameters,
+				_ protocol.VersionNumber,
+				_ utils.Logger,
+				_ protocol.VersionNumber,
+			) (quicSession, error) {
+				srcConnIDChan <- srcConnID
+				sess := NewMockQuicSession(mockCtrl)
+				sess.EXPECT().run().Do(func() { runner.onHandshakeComplete(sess) })
+				return sess, nil
+			}
+			_, err := DialContext(context.Background(), packetConn, addr, "localhost:1337", nil, &Config{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(srcConnIDChan).To(Receive(Equal(newConnID)))
+		})
+
+		It("errors if it can't find an unused source connection ID", func() {
+			manager := NewMockPacketHandlerManager(mockCtrl)
+			manager.EXPECT().AddIfNotTaken(connID, gomock.Any()).Return(false).Times(protocol.MaxConnectionIDGenerationAttempts)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+			_, err := DialContext(context.Background(), packetConn, addr, "localhost:1337", nil, &Config{})
+			Expect(err).To(MatchError(fmt.Sprintf("failed to generate an unused connection ID after %d attempts", protocol.MaxConnectionIDGenerationAttempts)))
+		})
+
 		It("closes the connection when it was created by DialAddr", func() {
 			if os.Getenv("APPVEYOR") == "True" {
 				Skip("This test is flaky on AppVeyor.")
 			}
 
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any()).Return(manager, nil)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any())
+			mockMultiplexer.EXPECT().AddConn(gomock.Any(), gomock.Any(), gomock.Any()).Return(manager, nil)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
 
 			var conn connection
 			run := make(chan struct{})
@@ -416,11 +577,16 @@ var _ = Describe("Client", func() {
 		Context("quic.Config", func() {
 			It("setups with the right values", func() {
 				config := &Config{
-					HandshakeTimeout:      1337 * time.Minute,
-					IdleTimeout:           42 * time.Hour,
-					MaxIncomingStreams:    1234,
-					MaxIncomingUniStreams: 4321,
-					ConnectionIDLength:    13,
+					HandshakeTimeout:       1337 * time.Minute,
+					IdleTimeout:            42 * time.Hour,
+					MaxIncomingStreams:     1234,
+					MaxIncomingUniStreams:  4321,
+					ConnectionIDLength:     13,
+					KeepAlive:              true,
+					KeepAlivePeriod:        5 * time.Second,
+					EnableDatagrams:        true,
+					MaxStreamSendBuffer:    1 << 16,
+					TestVersionNegotiation: true,
 				}
 				c := populateClientConfig(config, false)
 				Expect(c.HandshakeTimeout).To(Equal(1337 * time.Minute))
@@ -428,17 +594,46 @@ var _ = Describe("Client", func() {
 				Expect(c.MaxIncomingStreams).To(Equal(1234))
 				Expect(c.MaxIncomingUniStreams).To(Equal(4321))
 				Expect(c.ConnectionIDLength).To(Equal(13))
+				Expect(c.KeepAlive).To(BeTrue())
+				Expect(c.KeepAlivePeriod).To(Equal(5 * time.Second))
+				Expect(c.EnableDatagrams).To(BeTrue())
+				Expect(c.MaxStreamSendBuffer).To(BeEquivalentTo(1 << 16))
+				Expect(c.TestVersionNegotiation).To(BeTrue())
 			})
 
 			It("errors when the Config contains an invalid version", func() {
 				manager := NewMockPacketHandlerManager(mockCtrl)
-				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 				version := protocol.VersionNumber(0x1234)
 				_, err := Dial(packetConn, nil, "localhost:1234", &tls.Config{}, &Config{Versions: []protocol.VersionNumber{version}})
 				Expect(err).To(MatchError("0x1234 is not a valid QUIC version"))
 			})
 
+			It("errors when the stream flow control window is larger than the connection flow control window", func() {
+				manager := NewMockPacketHandlerManager(mockCtrl)
+				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+
+				config := &Config{
+					MaxReceiveStreamFlowControlWindow:     2000,
+					MaxReceiveConnectionFlowControlWindow: 1000,
+				}
+				_, err := Dial(packetConn, nil, "localhost:1234", &tls.Config{}, config)
+				Expect(err).To(MatchError("quic: MaxReceiveStreamFlowControlWindow (2000) is larger than MaxReceiveConnectionFlowControlWindow (1000)"))
+			})
+
+			It("errors when the minimum congestion window is larger than the initial congestion window", func() {
+				manager := NewMockPacketHandlerManager(mockCtrl)
+				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+
+				config := &Config{
+					InitialCongestionWindow: 10,
+					MinCongestionWindow:     20,
+				}
+				_, err := Dial(packetConn, nil, "localhost:1234", &tls.Config{}, config)
+				Expect(err).To(MatchError("quic: MinCongestionWindow (20) is larger than InitialCongestionWindow (10)"))
+			})
+
 			It("disables bidirectional streams", func() {
 				config := &Config{
 					MaxIncomingStreams:    -1,
@@ -470,19 +665,25 @@ var _ = Describe("Client", func() {
 				Expect(c.Versions).To(Equal(protocol.SupportedVersions))
 				Expect(c.HandshakeTimeout).To(Equal(protocol.DefaultHandshakeTimeout))
 				Expect(c.IdleTimeout).To(Equal(protocol.DefaultIdleTimeout))
+				Expect(c.InitialCongestionWindow).To(BeEquivalentTo(protocol.InitialCongestionWindow / protocol.DefaultTCPMSS))
+				Expect(c.MinCongestionWindow).To(BeEquivalentTo(protocol.DefaultMinCongestionWindow / protocol.DefaultTCPMSS))
 			})
 		})
 
 		It("creates new TLS sessions with the right parameters", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(connID, gomock.Any())
-			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+			manager.EXPECT().AddIfNotTaken(connID, gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
-			config := &Config{Versions: []protocol.VersionNumber{protocol.VersionTLS}}
+			config := &Config{
+				Versions:    []protocol.VersionNumber{protocol.VersionTLS},
+				MaxAckDelay: 42 * time.Millisecond,
+			}
 			c := make(chan struct{})
 			var cconn connection
 			var version protocol.VersionNumber
 			var conf *Config
+			var tp *handshake.TransportParameters
 			newClientSession = func(
 				connP connection,
 				_ sessionRunner,
@@ -501,6 +702,7 @@ var _ = Describe("Client", func() {
 				cconn = connP
 				version = versionP
 				conf = configP
+				tp = params
 				close(c)
 				// TODO: check connection IDs?
 				sess := NewMockQuicSession(mockCtrl)
@@ -513,11 +715,12 @@ var _ = Describe("Client", func() {
 			Expect(cconn.(*conn).pconn).To(Equal(packetConn))
 			Expect(version).To(Equal(config.Versions[0]))
 			Expect(conf.Versions).To(Equal(config.Versions))
+			Expect(tp.MaxAckDelay).To(Equal(42 * time.Millisecond))
 		})
 
 		It("creates a new session when the server performs a retry", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any()).Do(func(id protocol.ConnectionID, handler packetHandler) {
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Do(func(id protocol.ConnectionID, handler packetHandler) {
 				go handler.handlePacket(&receivedPacket{
 					hdr: &wire.Header{
 						IsLongHeader:         true,
@@ -528,9 +731,9 @@ var _ = Describe("Client", func() {
 						DestConnectionID:     id,
 					},
 				})
-			})
-			manager.EXPECT().Add(gomock.Any(), gomock.Any())
-			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+			}).Return(true)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			config := &Config{Versions: []protocol.VersionNumber{protocol.VersionTLS}}
 			cl.config = config
@@ -580,9 +783,115 @@ var _ = Describe("Client", func() {
 			Expect(sessions).To(BeEmpty())
 		})
 
+		It("uses tokens from the TokenStore, and stores new tokens", func() {
+			manager := NewMockPacketHandlerManager(mockCtrl)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+
+			tokenStore, err := NewLRUTokenStore(1, 1)
+			Expect(err).ToNot(HaveOccurred())
+			tokenStore.Put("localhost", []byte("foobar"))
+			config := &Config{TokenStore: tokenStore}
+			var runner sessionRunner
+			newClientSession = func(
+				_ connection,
+				runnerP sessionRunner,
+				token []byte,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ *Config,
+				_ *tls.Config,
+				_ protocol.PacketNumber,
+				_ *handshake.TransportParameters,
+				_ protocol.VersionNumber,
+				_ utils.Logger,
+				_ protocol.VersionNumber,
+			) (quicSession, error) {
+				Expect(token).To(Equal([]byte("foobar")))
+				runner = runnerP
+				sess := NewMockQuicSession(mockCtrl)
+				sess.EXPECT().run()
+				return sess, nil
+			}
+			_, err = Dial(packetConn, addr, "localhost:1337", nil, config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tokenStore.Pop("localhost")).To(BeNil())
+			runner.addToken([]byte("raboof"))
+			Expect(tokenStore.Pop("localhost")).To(Equal([]byte("raboof")))
+		})
+
+		It("follows a Retry if the token from the TokenStore wasn't accepted", func() {
+			manager := NewMockPacketHandlerManager(mockCtrl)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Do(func(id protocol.ConnectionID, handler packetHandler) {
+				go handler.handlePacket(&receivedPacket{
+					hdr: &wire.Header{
+						IsLongHeader:         true,
+						Type:                 protocol.PacketTypeRetry,
+						Version:              cl.version,
+						Token:                []byte("retry token"),
+						OrigDestConnectionID: connID,
+						DestConnectionID:     id,
+					},
+				})
+			}).Return(true)
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true)
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+
+			tokenStore, err := NewLRUTokenStore(1, 1)
+			Expect(err).ToNot(HaveOccurred())
+			tokenStore.Put("localhost", []byte("expired token"))
+			config := &Config{
+				Versions:   []protocol.VersionNumber{protocol.VersionTLS},
+				TokenStore: tokenStore,
+			}
+			run1 := make(chan error)
+			sess1 := NewMockQuicSession(mockCtrl)
+			sess1.EXPECT().run().DoAndReturn(func() error {
+				return <-run1
+			})
+			sess1.EXPECT().closeForRecreating().DoAndReturn(func() protocol.PacketNumber {
+				run1 <- errCloseForRecreating
+				return 42
+			})
+			sess2 := NewMockQuicSession(mockCtrl)
+			sess2.EXPECT().run()
+			sessions := make(chan quicSession, 2)
+			sessions <- sess1
+			sessions <- sess2
+			newClientSession = func(
+				_ connection,
+				_ sessionRunner,
+				token []byte,
+				origDestConnID protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ *Config,
+				_ *tls.Config,
+				_ protocol.PacketNumber,
+				_ *handshake.TransportParameters,
+				_ protocol.VersionNumber,
+				_ utils.Logger,
+				_ protocol.VersionNumber,
+			) (quicSession, error) {
+				switch len(sessions) {
+				case 2: // for the first session
+					Expect(token).To(Equal([]byte("expired token")))
+					Expect(origDestConnID).To(BeNil())
+				case 1: // for the second session
+					Expect(token).To(Equal([]byte("retry token")))
+					Expect(origDestConnID).To(Equal(connID))
+				}
+				return <-sessions, nil
+			}
+			_, err = Dial(packetConn, addr, "localhost:1337", nil, config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sessions).To(BeEmpty())
+		})
+
 		It("only accepts a single retry", func() {
 			manager := NewMockPacketHandlerManager(mockCtrl)
-			manager.EXPECT().Add(gomock.Any(), gomock.Any()).Do(func(id protocol.ConnectionID, handler packetHandler) {
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Do(func(id protocol.ConnectionID, handler packetHandler) {
 				go handler.handlePacket(&receivedPacket{
 					hdr: &wire.Header{
 						IsLongHeader:         true,
@@ -594,9 +903,9 @@ var _ = Describe("Client", func() {
 						Version:              cl.version,
 					},
 				})
-			}).AnyTimes()
-			manager.EXPECT().Add(gomock.Any(), gomock.Any()).AnyTimes()
-			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+			}).Return(true).AnyTimes()
+			manager.EXPECT().AddIfNotTaken(gomock.Any(), gomock.Any()).Return(true).AnyTimes()
+			mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 			config := &Config{Versions: []protocol.VersionNumber{protocol.VersionTLS}}
 			cl.config = config
@@ -655,8 +964,8 @@ var _ = Describe("Client", func() {
 
 			It("returns an error that occurs during version negotiation", func() {
 				manager := NewMockPacketHandlerManager(mockCtrl)
-				manager.EXPECT().Add(connID, gomock.Any())
-				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+				manager.EXPECT().AddIfNotTaken(connID, gomock.Any()).Return(true)
+				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
 
 				testErr := errors.New("early handshake error")
 				newClientSession = func(
@@ -707,7 +1016,13 @@ var _ = Describe("Client", func() {
 			It("errors if no matching version is found", func() {
 				sess := NewMockQuicSession(mockCtrl)
 				done := make(chan struct{})
-				sess.EXPECT().destroy(qerr.InvalidVersion).Do(func(error) { close(done) })
+				sess.EXPECT().destroy(gomock.Any()).Do(func(e error) {
+					Expect(e).To(Equal(&VersionNegotiationError{
+						Ours:   protocol.SupportedVersions,
+						Theirs: []protocol.VersionNumber{1},
+					}))
+					close(done)
+				})
 				cl.session = sess
 				cl.config = &Config{Versions: protocol.SupportedVersions}
 				cl.handlePacket(composeVersionNegotiationPacket(connID, []protocol.VersionNumber{1}))
@@ -717,7 +1032,7 @@ var _ = Describe("Client", func() {
 			It("errors if the version is supported by quic-go, but disabled by the quic.Config", func() {
 				sess := NewMockQuicSession(mockCtrl)
 				done := make(chan struct{})
-				sess.EXPECT().destroy(qerr.InvalidVersion).Do(func(error) { close(done) })
+				sess.EXPECT().destroy(gomock.AssignableToTypeOf(&VersionNegotiationError{})).Do(func(error) { close(done) })
 				cl.session = sess
 				v := protocol.VersionNumber(1234)
 				Expect(v).ToNot(Equal(cl.version))
@@ -743,6 +1058,127 @@ var _ = Describe("Client", func() {
 				Expect(cl.version).To(Equal(protocol.VersionNumber(1234)))
 			})
 
+			It("uses the version chosen by the VersionNegotiationCallback", func() {
+				sess := NewMockQuicSession(mockCtrl)
+				destroyed := make(chan struct{})
+				sess.EXPECT().closeForRecreating().Do(func() { close(destroyed) })
+				cl.session = sess
+				versionsChan := make(chan []protocol.VersionNumber, 1)
+				cl.config = &Config{
+					Versions: []protocol.VersionNumber{1234, 4321},
+					VersionNegotiationCallback: func(serverVersions []protocol.VersionNumber) (protocol.VersionNumber, bool) {
+						versionsChan <- serverVersions
+						return 4321, true
+					},
+				}
+				cl.handlePacket(composeVersionNegotiationPacket(connID, []protocol.VersionNumber{1234, 4321}))
+				Eventually(destroyed).Should(BeClosed())
+				Expect(cl.version).To(Equal(protocol.VersionNumber(4321)))
+				// greased versions are not passed to the callback
+				Expect(versionsChan).To(Receive(Equal([]protocol.VersionNumber{1234, 4321})))
+			})
+
+			It("aborts the connection attempt if the VersionNegotiationCallback returns false", func() {
+				sess := NewMockQuicSession(mockCtrl)
+				done := make(chan struct{})
+				sess.EXPECT().destroy(&VersionNegotiationError{
+					Ours:   []protocol.VersionNumber{1234, 4321},
+					Theirs: []protocol.VersionNumber{1234, 4321},
+				}).Do(func(error) { close(done) })
+				cl.session = sess
+				cl.config = &Config{
+					Versions: []protocol.VersionNumber{1234, 4321},
+					VersionNegotiationCallback: func([]protocol.VersionNumber) (protocol.VersionNumber, bool) {
+						return 0, false
+					},
+				}
+				cl.handlePacket(composeVersionNegotiationPacket(connID, []protocol.VersionNumber{1234, 4321}))
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("aborts the connection attempt if the VersionNegotiationCallback chooses an unsupported version", func() {
+				sess := NewMockQuicSession(mockCtrl)
+				done := make(chan struct{})
+				sess.EXPECT().destroy(gomock.AssignableToTypeOf(&VersionNegotiationError{})).Do(func(error) { close(done) })
+				cl.session = sess
+				cl.config = &Config{
+					Versions: []protocol.VersionNumber{1234, 4321},
+					VersionNegotiationCallback: func([]protocol.VersionNumber) (protocol.VersionNumber, bool) {
+						return 4321, true // not supported by the server
+					},
+				}
+				cl.handlePacket(composeVersionNegotiationPacket(connID, []protocol.VersionNumber{1234}))
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("starts with a reserved version, if TestVersionNegotiation is set", func() {
+				manager := NewMockPacketHandlerManager(mockCtrl)
+				manager.EXPECT().AddIfNotTaken(connID, gomock.Any()).Return(true)
+				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any(), gomock.Any()).Return(manager, nil)
+
+				testErr := errors.New("test error")
+				versionChan := make(chan protocol.VersionNumber, 1)
+				newClientSession = func(
+					_ connection,
+					_ sessionRunner,
+					_ []byte, // token
+					_ protocol.ConnectionID,
+					_ protocol.ConnectionID,
+					_ protocol.ConnectionID,
+					_ *Config,
+					_ *tls.Config,
+					_ protocol.PacketNumber,
+					_ *handshake.TransportParameters,
+					_ protocol.VersionNumber,
+					_ utils.Logger,
+					v protocol.VersionNumber,
+				) (quicSession, error) {
+					versionChan <- v
+					sess := NewMockQuicSession(mockCtrl)
+					sess.EXPECT().run().Return(testErr)
+					return sess, nil
+				}
+				_, err := Dial(
+					packetConn,
+					addr,
+					"localhost:1337",
+					nil,
+					&Config{TestVersionNegotiation: true},
+				)
+				Expect(err).To(MatchError(testErr))
+				var v protocol.VersionNumber
+				Expect(versionChan).To(Receive(&v))
+				Expect(protocol.IsReservedVersion(v)).To(BeTrue())
+			})
+
+			It("switches from the reserved version to a supported version", func() {
+				sess := NewMockQuicSession(mockCtrl)
+				destroyed := make(chan struct{})
+				sess.EXPECT().closeForRecreating().Do(func() { close(destroyed) })
+				cl.session = sess
+				reserved := protocol.GenerateReservedVersion()
+				cl.version = reserved
+				cl.config = &Config{Versions: []protocol.VersionNumber{1234}, TestVersionNegotiation: true}
+				cl.handlePacket(composeVersionNegotiationPacket(connID, []protocol.VersionNumber{1234}))
+				Eventually(destroyed).Should(BeClosed())
+				Expect(cl.version).To(Equal(protocol.VersionNumber(1234)))
+				Expect(cl.initialVersion).To(Equal(reserved))
+			})
+
+			It("drops version negotiation packets that echo the reserved version", func() {
+				// a session that is closed would lead to a gomock error
+				cl.session = NewMockQuicSession(mockCtrl)
+				reserved := protocol.GenerateReservedVersion()
+				cl.version = reserved
+				cl.config = &Config{Versions: []protocol.VersionNumber{1234}, TestVersionNegotiation: true}
+				cl.handlePacket(composeVersionNegotiationPacket(connID, []protocol.VersionNumber{reserved, 1234}))
+				Consistently(func() protocol.VersionNumber {
+					cl.mutex.Lock()
+					defer cl.mutex.Unlock()
+					return cl.version
+				}).Should(Equal(reserved))
+			})
+
 			It("drops version negotiation packets that contain the offered version", func() {
 				cl.config = &Config{}
 				ver := cl.version
@@ -752,6 +1188,86 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Context("connection migration", func() {
+		var (
+			oldManager *MockPacketHandlerManager
+			newManager *MockPacketHandlerManager
+			newPconn   *mockPacketConn
+		)
+
+		BeforeEach(func() {
+			oldManager = NewMockPacketHandlerManager(mockCtrl)
+			newManager = NewMockPacketHandlerManager(mockCtrl)
+			cl.packetHandlers = oldManager
+			cl.config = &Config{ConnectionIDLength: 8}
+			newPconn = newMockPacketConn()
+			newPconn.addr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321}
+		})
+
+		It("starts receiving packets on the new packet conn", func() {
+			mockMultiplexer.EXPECT().AddConn(newPconn, 8, gomock.Any()).Return(newManager, nil)
+			newManager.EXPECT().Add(connID, cl)
+			conn, err := cl.addPacketConn(newPconn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.LocalAddr()).To(Equal(newPconn.addr))
+			Expect(conn.RemoteAddr()).To(Equal(addr))
+		})
+
+		It("returns the error when adding the packet conn fails", func() {
+			testErr := errors.New("test error")
+			mockMultiplexer.EXPECT().AddConn(newPconn, 8, gomock.Any()).Return(nil, testErr)
+			_, err := cl.addPacketConn(newPconn)
+			Expect(err).To(MatchError(testErr))
+		})
+
+		It("switches to the new packet conn, and closes the old one if it was created by the client", func() {
+			cl.createdPacketConn = true
+			mockMultiplexer.EXPECT().AddConn(newPconn, 8, gomock.Any()).Return(newManager, nil)
+			newManager.EXPECT().Add(connID, cl)
+			conn, err := cl.addPacketConn(newPconn)
+			Expect(err).ToNot(HaveOccurred())
+			oldManager.EXPECT().Remove(connID)
+			cl.switchPacketConn(conn)
+			Expect(packetConn.closed).To(BeTrue())
+			Expect(cl.conn).To(Equal(conn))
+			Expect(cl.createdPacketConn).To(BeFalse())
+			// the connection ID is now removed from the new packet handler manager
+			newManager.EXPECT().Remove(connID)
+			cl.getPacketHandlers().Remove(connID)
+		})
+
+		It("doesn't close the old packet conn if it was passed to Dial", func() {
+			mockMultiplexer.EXPECT().AddConn(newPconn, 8, gomock.Any()).Return(newManager, nil)
+			newManager.EXPECT().Add(connID, cl)
+			conn, err := cl.addPacketConn(newPconn)
+			Expect(err).ToNot(HaveOccurred())
+			oldManager.EXPECT().Remove(connID)
+			cl.switchPacketConn(conn)
+			Expect(packetConn.closed).To(BeFalse())
+		})
+
+		It("uses the stateless reset token on the new packet conn", func() {
+			token := [16]byte{0xde, 0xad, 0xbe, 0xef}
+			oldManager.EXPECT().AddWithResetToken(connID, cl, token)
+			cl.addResetToken(token)
+			mockMultiplexer.EXPECT().AddConn(newPconn, 8, gomock.Any()).Return(newManager, nil)
+			newManager.EXPECT().AddWithResetToken(connID, cl, token)
+			_, err := cl.addPacketConn(newPconn)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("stops receiving packets on the new packet conn when the migration fails", func() {
+			mockMultiplexer.EXPECT().AddConn(newPconn, 8, gomock.Any()).Return(newManager, nil)
+			newManager.EXPECT().Add(connID, cl)
+			conn, err := cl.addPacketConn(newPconn)
+			Expect(err).ToNot(HaveOccurred())
+			newManager.EXPECT().Remove(connID)
+			cl.removePacketConn(conn)
+			Expect(cl.getPacketHandlers()).To(Equal(oldManager))
+			Expect(newPconn.closed).To(BeFalse())
+		})
+	})
+
 	It("tells its version", func() {
 		Expect(cl.version).ToNot(BeZero())
 		Expect(cl.GetVersion()).To(Equal(cl.version))
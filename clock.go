@@ -0,0 +1,34 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/congestion"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+)
+
+// systemClock is the Clock used if no Clock is configured.
+type systemClock struct{}
+
+var _ Clock = systemClock{}
+var _ congestion.Clock = systemClock{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer() ClockTimer { return utils.NewTimer() }
+
+// clockTimer adapts a ClockTimer to the sessionTimer interface.
+// A ClockTimer discards unread values itself when it is reset, so SetRead is a no-op.
+type clockTimer struct {
+	ClockTimer
+}
+
+func (clockTimer) SetRead() {}
+
+func newSessionTimer(clock Clock) sessionTimer {
+	t := clock.NewTimer()
+	if st, ok := t.(sessionTimer); ok {
+		return st
+	}
+	return &clockTimer{t}
+}
@@ -0,0 +1,46 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockClockTimer struct {
+	c         chan time.Time
+	deadlines []time.Time
+}
+
+func (t *mockClockTimer) Chan() <-chan time.Time   { return t.c }
+func (t *mockClockTimer) Reset(deadline time.Time) { t.deadlines = append(t.deadlines, deadline) }
+
+type mockConfigClock struct {
+	timer *mockClockTimer
+}
+
+func (c *mockConfigClock) Now() time.Time       { return time.Now() }
+func (c *mockConfigClock) NewTimer() ClockTimer { return c.timer }
+
+var _ = Describe("Clock", func() {
+	It("uses the system clock if no clock is configured", func() {
+		clock := getClock(&Config{})
+		Expect(clock.Now()).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
+		Expect(newSessionTimer(clock)).To(BeAssignableToTypeOf(&utils.Timer{}))
+	})
+
+	It("uses the timer of the configured clock", func() {
+		timer := &mockClockTimer{c: make(chan time.Time, 1)}
+		clock := &mockConfigClock{timer: timer}
+		Expect(getClock(&Config{Clock: clock})).To(Equal(clock))
+		t := newSessionTimer(clock)
+		deadline := time.Now().Add(time.Hour)
+		t.Reset(deadline)
+		Expect(timer.deadlines).To(Equal([]time.Time{deadline}))
+		timer.c <- deadline
+		Expect(t.Chan()).To(Receive(Equal(deadline)))
+		t.SetRead() // no-op
+	})
+})
@@ -0,0 +1,138 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/internal/congestion"
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+)
+
+// validateFlowControlWindows checks that the receive flow control windows of a populated Config are consistent.
+// A stream can never receive more data than the connection, so the stream window must not exceed the connection window.
+func validateFlowControlWindows(config *Config) error {
+	if config.MaxReceiveStreamFlowControlWindow > config.MaxReceiveConnectionFlowControlWindow {
+		return fmt.Errorf("quic: MaxReceiveStreamFlowControlWindow (%d) is larger than MaxReceiveConnectionFlowControlWindow (%d)", config.MaxReceiveStreamFlowControlWindow, config.MaxReceiveConnectionFlowControlWindow)
+	}
+	return nil
+}
+
+// validateCongestionWindows checks that the congestion windows of a populated Config are consistent.
+func validateCongestionWindows(config *Config) error {
+	maxCongestionWindow := uint32(protocol.DefaultMaxCongestionWindow / protocol.DefaultTCPMSS)
+	if config.InitialCongestionWindow > maxCongestionWindow {
+		return fmt.Errorf("quic: InitialCongestionWindow (%d) is larger than the maximum congestion window (%d)", config.InitialCongestionWindow, maxCongestionWindow)
+	}
+	if config.MinCongestionWindow > config.InitialCongestionWindow {
+		return fmt.Errorf("quic: MinCongestionWindow (%d) is larger than InitialCongestionWindow (%d)", config.MinCongestionWindow, config.InitialCongestionWindow)
+	}
+	return nil
+}
+
+// validateAckSettings checks the ACK settings of a populated Config.
+func validateAckSettings(config *Config) error {
+	if config.MaxAckDelay > protocol.MaxMaxAckDelay {
+		return fmt.Errorf("quic: MaxAckDelay (%s) is larger than the maximum (%s)", config.MaxAckDelay, protocol.MaxMaxAckDelay)
+	}
+	if config.AckFrequency < 0 {
+		return fmt.Errorf("quic: invalid AckFrequency (%d)", config.AckFrequency)
+	}
+	return nil
+}
+
+// initialMaxStreamData is the stream-level flow control window advertised in the transport parameters.
+// It is capped by the configured maximum stream-level flow control window.
+func initialMaxStreamData(config *Config) protocol.ByteCount {
+	return utils.MinByteCount(protocol.InitialMaxStreamData, protocol.ByteCount(config.MaxReceiveStreamFlowControlWindow))
+}
+
+// initialMaxData is the connection-level flow control window advertised in the transport parameters.
+// It is capped by the configured maximum connection-level flow control window.
+func initialMaxData(config *Config) protocol.ByteCount {
+	return utils.MinByteCount(protocol.InitialMaxData, protocol.ByteCount(config.MaxReceiveConnectionFlowControlWindow))
+}
+
+// generateConnectionIDForConfig generates a connection ID of the configured length.
+// If set, it uses the Config.ConnectionIDGenerator.
+func generateConnectionIDForConfig(config *Config) (protocol.ConnectionID, error) {
+	if config.ConnectionIDGenerator == nil || config.ConnectionIDLength == 0 {
+		return protocol.GenerateConnectionID(config.ConnectionIDLength)
+	}
+	b, err := config.ConnectionIDGenerator(config.ConnectionIDLength)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != config.ConnectionIDLength {
+		return nil, fmt.Errorf("quic: ConnectionIDGenerator returned a connection ID of length %d, expected %d", len(b), config.ConnectionIDLength)
+	}
+	return protocol.ConnectionID(b), nil
+}
+
+// getClock returns the clock used for the config.
+// If no clock is configured, the system clock is used.
+func getClock(config *Config) Clock {
+	if config.Clock == nil {
+		return systemClock{}
+	}
+	return config.Clock
+}
+
+// getLogger returns the logger used for the config.
+// If no logger is configured, the utils.DefaultLogger is used.
+func getLogger(config *Config) utils.Logger {
+	if config.Logger == nil {
+		return utils.DefaultLogger
+	}
+	return utils.NewLogger(config.Logger)
+}
+
+// maxDatagramFrameSize is the maximum size of a DATAGRAM frame advertised in the transport parameters.
+// 0 means that DATAGRAM frames are not supported.
+func maxDatagramFrameSize(config *Config) protocol.ByteCount {
+	if !config.EnableDatagrams {
+		return 0
+	}
+	return protocol.MaxDatagramFrameSize
+}
+
+// NewCubicCongestionControl creates a congestion controller using Cubic.
+// This is the default congestion controller.
+func NewCubicCongestionControl(rttStats *RTTStats) CongestionControl {
+	return congestion.NewCubicSender(congestion.DefaultClock{}, rttStats, false, protocol.InitialCongestionWindow, protocol.DefaultMinCongestionWindow, protocol.DefaultMaxCongestionWindow)
+}
+
+// NewRenoCongestionControl creates a congestion controller using NewReno.
+func NewRenoCongestionControl(rttStats *RTTStats) CongestionControl {
+	return congestion.NewCubicSender(congestion.DefaultClock{}, rttStats, true, protocol.InitialCongestionWindow, protocol.DefaultMinCongestionWindow, protocol.DefaultMaxCongestionWindow)
+}
+
+// NewBBRCongestionControl creates a congestion controller using BBR.
+// BBR paces packets at the estimated bottleneck bandwidth, and doesn't reduce its sending rate on random packet loss.
+func NewBBRCongestionControl(rttStats *RTTStats) CongestionControl {
+	return congestion.NewBBRSender(rttStats, protocol.InitialCongestionWindow, protocol.DefaultMaxCongestionWindow)
+}
+
+// newCongestionControl creates the congestion controller for a new session.
+// The configured congestion windows are only used for the default congestion controller.
+func newCongestionControl(config *Config, rttStats *congestion.RTTStats) (congestion.SendAlgorithm, error) {
+	if config.CongestionControl == nil {
+		var clock congestion.Clock = congestion.DefaultClock{}
+		if config.Clock != nil {
+			clock = config.Clock
+		}
+		return congestion.NewCubicSender(
+			clock,
+			rttStats,
+			false,
+			protocol.ByteCount(config.InitialCongestionWindow)*protocol.DefaultTCPMSS,
+			protocol.ByteCount(config.MinCongestionWindow)*protocol.DefaultTCPMSS,
+			protocol.DefaultMaxCongestionWindow,
+		), nil
+	}
+	cc := config.CongestionControl(rttStats)
+	if cc == nil {
+		return nil, errors.New("quic: CongestionControl returned nil")
+	}
+	return cc, nil
+}
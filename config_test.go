@@ -0,0 +1,176 @@
+package quic
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/congestion"
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	Context("validating the flow control windows", func() {
+		It("accepts a stream window smaller than the connection window", func() {
+			config := populateServerConfig(&Config{MaxReceiveStreamFlowControlWindow: 1 << 20})
+			Expect(validateFlowControlWindows(config)).To(Succeed())
+		})
+
+		It("accepts equal windows", func() {
+			config := &Config{
+				MaxReceiveStreamFlowControlWindow:     1 << 20,
+				MaxReceiveConnectionFlowControlWindow: 1 << 20,
+			}
+			Expect(validateFlowControlWindows(config)).To(Succeed())
+		})
+
+		It("rejects a stream window larger than the default connection window", func() {
+			config := populateClientConfig(&Config{MaxReceiveStreamFlowControlWindow: 1 << 30}, false)
+			Expect(validateFlowControlWindows(config)).ToNot(Succeed())
+		})
+	})
+
+	Context("validating the congestion windows", func() {
+		It("accepts the default values", func() {
+			Expect(validateCongestionWindows(populateServerConfig(&Config{}))).To(Succeed())
+		})
+
+		It("accepts small windows", func() {
+			config := populateClientConfig(&Config{InitialCongestionWindow: 3, MinCongestionWindow: 1}, false)
+			Expect(validateCongestionWindows(config)).To(Succeed())
+		})
+
+		It("limits the default minimum window to the initial window", func() {
+			config := populateClientConfig(&Config{InitialCongestionWindow: 1}, false)
+			Expect(config.MinCongestionWindow).To(BeEquivalentTo(1))
+			Expect(validateCongestionWindows(config)).To(Succeed())
+			config = populateServerConfig(&Config{InitialCongestionWindow: 1})
+			Expect(config.MinCongestionWindow).To(BeEquivalentTo(1))
+			Expect(validateCongestionWindows(config)).To(Succeed())
+		})
+
+		It("rejects an initial window larger than the maximum window", func() {
+			config := populateServerConfig(&Config{InitialCongestionWindow: 1001})
+			Expect(validateCongestionWindows(config)).To(MatchError("quic: InitialCongestionWindow (1001) is larger than the maximum congestion window (1000)"))
+		})
+
+		It("rejects a minimum window larger than the initial window", func() {
+			config := populateServerConfig(&Config{MinCongestionWindow: 33})
+			Expect(validateCongestionWindows(config)).To(MatchError("quic: MinCongestionWindow (33) is larger than InitialCongestionWindow (32)"))
+		})
+	})
+
+	Context("validating the ACK settings", func() {
+		It("accepts the default values", func() {
+			Expect(validateAckSettings(populateClientConfig(&Config{}, false))).To(Succeed())
+		})
+
+		It("rejects a max ACK delay that can't be encoded in the transport parameters", func() {
+			config := populateServerConfig(&Config{MaxAckDelay: 20 * time.Second})
+			Expect(validateAckSettings(config)).To(MatchError("quic: MaxAckDelay (20s) is larger than the maximum (16.383s)"))
+		})
+
+		It("rejects a negative ACK frequency", func() {
+			config := populateServerConfig(&Config{AckFrequency: -1})
+			Expect(validateAckSettings(config)).To(MatchError("quic: invalid AckFrequency (-1)"))
+		})
+
+		It("uses the default values", func() {
+			config := populateServerConfig(&Config{})
+			Expect(config.MaxAckDelay).To(Equal(protocol.DefaultMaxAckDelay))
+			Expect(config.AckFrequency).To(Equal(protocol.DefaultAckFrequency))
+			config = populateClientConfig(&Config{MaxAckDelay: 5 * time.Millisecond, AckFrequency: 10}, false)
+			Expect(config.MaxAckDelay).To(Equal(5 * time.Millisecond))
+			Expect(config.AckFrequency).To(Equal(10))
+		})
+	})
+
+	Context("initial flow control windows", func() {
+		It("uses the default values if the configured windows are larger", func() {
+			config := populateServerConfig(&Config{})
+			Expect(initialMaxStreamData(config)).To(Equal(protocol.ByteCount(protocol.InitialMaxStreamData)))
+			Expect(initialMaxData(config)).To(Equal(protocol.ByteCount(protocol.InitialMaxData)))
+		})
+
+		It("caps the windows at the configured values", func() {
+			config := populateServerConfig(&Config{
+				MaxReceiveStreamFlowControlWindow:     1000,
+				MaxReceiveConnectionFlowControlWindow: 2000,
+			})
+			Expect(initialMaxStreamData(config)).To(Equal(protocol.ByteCount(1000)))
+			Expect(initialMaxData(config)).To(Equal(protocol.ByteCount(2000)))
+		})
+	})
+
+	Context("DATAGRAM frames", func() {
+		It("doesn't advertise a max_datagram_frame_size, if datagrams are disabled", func() {
+			Expect(maxDatagramFrameSize(populateServerConfig(&Config{}))).To(BeZero())
+		})
+
+		It("advertises the max_datagram_frame_size, if datagrams are enabled", func() {
+			config := populateClientConfig(&Config{EnableDatagrams: true}, false)
+			Expect(maxDatagramFrameSize(config)).To(Equal(protocol.MaxDatagramFrameSize))
+		})
+	})
+
+	Context("congestion control", func() {
+		It("uses Cubic by default", func() {
+			rttStats := &RTTStats{}
+			cc, err := newCongestionControl(populateServerConfig(&Config{}), rttStats)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc).To(Equal(NewCubicCongestionControl(rttStats)))
+		})
+
+		It("uses the configured congestion windows", func() {
+			rttStats := &RTTStats{}
+			config := populateServerConfig(&Config{InitialCongestionWindow: 64, MinCongestionWindow: 4})
+			cc, err := newCongestionControl(config, rttStats)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc.GetCongestionWindow()).To(Equal(64 * protocol.DefaultTCPMSS))
+			// after an RTO, the congestion window collapses to the minimum window
+			cc.(congestion.SendAlgorithmWithDebugInfo).OnRetransmissionTimeout(true)
+			Expect(cc.GetCongestionWindow()).To(Equal(4 * protocol.DefaultTCPMSS))
+		})
+
+		It("uses Reno, if configured", func() {
+			rttStats := &RTTStats{}
+			config := populateClientConfig(&Config{CongestionControl: NewRenoCongestionControl}, false)
+			cc, err := newCongestionControl(config, rttStats)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc).To(Equal(NewRenoCongestionControl(rttStats)))
+			Expect(cc).ToNot(Equal(NewCubicCongestionControl(rttStats)))
+		})
+
+		It("uses BBR, if configured", func() {
+			rttStats := &RTTStats{}
+			config := populateServerConfig(&Config{CongestionControl: NewBBRCongestionControl})
+			cc, err := newCongestionControl(config, rttStats)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cc).To(Equal(NewBBRCongestionControl(rttStats)))
+		})
+
+		It("passes the RTTStats to the constructor", func() {
+			rttStats := &RTTStats{}
+			var cc CongestionControl
+			config := populateServerConfig(&Config{
+				CongestionControl: func(r *RTTStats) CongestionControl {
+					Expect(r).To(Equal(rttStats))
+					cc = NewRenoCongestionControl(r)
+					return cc
+				},
+			})
+			c, err := newCongestionControl(config, rttStats)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c).To(Equal(cc))
+		})
+
+		It("errors if the constructor returns nil", func() {
+			config := populateServerConfig(&Config{
+				CongestionControl: func(*RTTStats) CongestionControl { return nil },
+			})
+			_, err := newCongestionControl(config, &RTTStats{})
+			Expect(err).To(MatchError("quic: CongestionControl returned nil"))
+		})
+	})
+})
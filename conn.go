@@ -3,15 +3,27 @@ package quic
 import (
 	"net"
 	"sync"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"golang.org/x/net/ipv4"
 )
 
 type connection interface {
 	Write([]byte) error
+	// WriteTo writes to a different address than the current remote address, e.g. when validating a new path.
+	WriteTo([]byte, net.Addr) error
+	// WriteBatch writes multiple packets to the current remote address.
+	// If supported by the platform, it uses a single syscall for up to protocol.MaxPacketBatchSize packets.
+	WriteBatch([][]byte) error
 	Read([]byte) (int, net.Addr, error)
 	Close() error
 	LocalAddr() net.Addr
 	RemoteAddr() net.Addr
 	SetCurrentRemoteAddr(net.Addr)
+	// ECNEnabled says if packets are sent with the ECT(0) codepoint.
+	ECNEnabled() bool
+	// DisableECN stops marking packets, e.g. when ECN validation failed.
+	DisableECN()
 }
 
 type conn struct {
@@ -19,15 +31,112 @@ type conn struct {
 
 	pconn       net.PacketConn
 	currentAddr net.Addr
+	// ecnOOB contains the control messages that mark sent packets as ECT(0).
+	// It is nil if ECN is not used on this conn.
+	// It is only set for packet conns created by quic-go.
+	ecnOOB []byte
+
+	batchConnOnce sync.Once
+	batchConn     *batchConn // nil if batched packet I/O is not supported
+	messages      []ipv4.Message
 }
 
 var _ connection = &conn{}
 
 func (c *conn) Write(p []byte) error {
-	_, err := c.pconn.WriteTo(p, c.currentAddr)
+	return c.WriteTo(p, c.RemoteAddr())
+}
+
+func (c *conn) WriteTo(p []byte, addr net.Addr) error {
+	if oob := c.ecnControlMessage(); oob != nil {
+		if udpConn, ok := c.pconn.(*net.UDPConn); ok {
+			if udpAddr, ok := addr.(*net.UDPAddr); ok {
+				_, _, err := udpConn.WriteMsgUDP(p, oob, udpAddr)
+				return err
+			}
+		}
+	}
+	_, err := c.pconn.WriteTo(p, addr)
 	return err
 }
 
+func (c *conn) WriteBatch(packets [][]byte) error {
+	addr := c.RemoteAddr()
+	c.batchConnOnce.Do(func() {
+		c.batchConn = newBatchConn(c.pconn)
+		if c.batchConn != nil {
+			c.messages = make([]ipv4.Message, 0, protocol.MaxPacketBatchSize)
+		}
+	})
+	if c.batchConn == nil || !c.batchConn.canWriteTo(addr) {
+		for _, p := range packets {
+			if err := c.WriteTo(p, addr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for len(packets) > 0 {
+		ms := c.packMessages(packets, addr)
+		// If only some of the messages were sent, the error is returned by the next call.
+		n, err := c.batchConn.WriteBatch(ms, 0)
+		if err != nil {
+			if c.batchConn.gso && isGSOError(err) {
+				// The network interface doesn't support GSO.
+				// Resend the packets without it.
+				c.batchConn.gso = false
+				continue
+			}
+			return err
+		}
+		for _, m := range ms[:n] {
+			packets = packets[len(m.Buffers):]
+		}
+	}
+	return nil
+}
+
+// packMessages packs the packets into at most protocol.MaxPacketBatchSize messages.
+// If GSO is enabled, consecutive packets of the same size are sent in a single message,
+// and the kernel splits them into individual UDP datagrams.
+func (c *conn) packMessages(packets [][]byte, addr net.Addr) []ipv4.Message {
+	ms := c.messages[:0]
+	ecnOOB := c.ecnControlMessage()
+	for len(packets) > 0 && len(ms) < cap(ms) {
+		n := 1
+		if c.batchConn.gso {
+			n = numGSOSegments(packets)
+		}
+		// Limit the capacity, so that appending the GSO message doesn't modify ecnOOB.
+		m := ipv4.Message{Buffers: packets[:n], Addr: addr, OOB: ecnOOB[:len(ecnOOB):len(ecnOOB)]}
+		if n > 1 {
+			m.OOB = appendUDPSegmentSizeMsg(m.OOB, uint16(len(packets[0])))
+		}
+		ms = append(ms, m)
+		packets = packets[n:]
+	}
+	return ms
+}
+
+// numGSOSegments returns the number of packets at the beginning of packets that can be sent using GSO.
+// All packets must have the same size, except for the last one, which may be smaller.
+func numGSOSegments(packets [][]byte) int {
+	size := len(packets[0])
+	total := size
+	n := 1
+	for ; n < len(packets) && n < protocol.MaxGSOSegments; n++ {
+		l := len(packets[n])
+		if l > size || total+l > protocol.MaxGSOBufferSize {
+			break
+		}
+		total += l
+		if l < size {
+			return n + 1
+		}
+	}
+	return n
+}
+
 func (c *conn) Read(p []byte) (int, net.Addr, error) {
 	return c.pconn.ReadFrom(p)
 }
@@ -49,6 +158,23 @@ func (c *conn) RemoteAddr() net.Addr {
 	return addr
 }
 
+func (c *conn) ecnControlMessage() []byte {
+	c.mutex.RLock()
+	oob := c.ecnOOB
+	c.mutex.RUnlock()
+	return oob
+}
+
+func (c *conn) ECNEnabled() bool {
+	return c.ecnControlMessage() != nil
+}
+
+func (c *conn) DisableECN() {
+	c.mutex.Lock()
+	c.ecnOOB = nil
+	c.mutex.Unlock()
+}
+
 func (c *conn) Close() error {
 	return c.pconn.Close()
 }
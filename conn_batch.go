@@ -0,0 +1,35 @@
+package quic
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// A batchPacketConn reads and writes multiple packets with a single syscall.
+// It is implemented by both ipv4.PacketConn and ipv6.PacketConn.
+type batchPacketConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// The batchConn is used for batched packet I/O, if the platform supports it.
+type batchConn struct {
+	batchPacketConn
+
+	isIPv6 bool
+	// gso is set if the socket supports UDP Generic Segmentation Offload.
+	// It is reset when sending a GSO packet fails, e.g. because the network interface doesn't support it.
+	gso bool
+}
+
+// canWriteTo says if packets can be sent to addr using WriteBatch.
+// On a dual-stack socket, packets to IPv4 addresses have to be sent using WriteTo,
+// since WriteBatch doesn't convert them to IPv4-mapped IPv6 addresses.
+func (c *batchConn) canWriteTo(addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	return !c.isIPv6 || udpAddr.IP.To4() == nil
+}
@@ -0,0 +1,142 @@
+//go:build linux
+// +build linux
+
+package quic
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// UDP_SEGMENT is not defined in the syscall package.
+const udpSegment = 103
+
+// oobBufferSize is the size of the buffer used to receive control messages.
+// It is large enough to hold the IP_TOS and the IPV6_TCLASS control message.
+const oobBufferSize = 128
+
+// newBatchConn returns a batchConn using recvmmsg and sendmmsg, if c is a *net.UDPConn.
+// Otherwise, it returns nil.
+func newBatchConn(c net.PacketConn) *batchConn {
+	udpConn, ok := c.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	rawConn, err := udpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var domain int
+	var sockErr, gsoErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		domain, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+		if sockErr != nil {
+			return
+		}
+		// Kernels that don't support GSO return an error when querying the UDP_SEGMENT option.
+		_, gsoErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_UDP, udpSegment)
+	}); err != nil || sockErr != nil {
+		return nil
+	}
+	switch domain {
+	case syscall.AF_INET:
+		return &batchConn{batchPacketConn: ipv4.NewPacketConn(udpConn), gso: gsoErr == nil}
+	case syscall.AF_INET6:
+		return &batchConn{batchPacketConn: ipv6.NewPacketConn(udpConn), isIPv6: true, gso: gsoErr == nil}
+	default:
+		return nil
+	}
+}
+
+// enableECN requests the ECN codepoint of packets received on conn.
+// It returns the control messages that mark a sent packet as ECT(0), keeping the DSCP in the upper 6 bits.
+// Marking is done per packet, so that it can be turned off for a single session if ECN validation fails.
+// It returns nil if the kernel doesn't support the socket options.
+// Since it modifies the socket, it must only be called on packet conns created by quic-go.
+func enableECN(c *net.UDPConn, dscp uint8) []byte {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var domain int
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		domain, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+		if sockErr != nil {
+			return
+		}
+		// IPv4 packets might also be received on a dual-stack socket.
+		if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1); sockErr != nil {
+			return
+		}
+		if domain == syscall.AF_INET6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS, 1)
+		}
+	}); err != nil || sockErr != nil {
+		return nil
+	}
+	tos := int32(dscp)<<2 | int32(protocol.ECT0)
+	// The kernel ignores control messages for the other IP version.
+	// On a dual-stack socket, IPv4 packets use the IP_TOS message.
+	oob := appendInt32Msg(nil, syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+	if domain == syscall.AF_INET6 {
+		oob = appendInt32Msg(oob, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return oob
+}
+
+func appendInt32Msg(b []byte, level, typ int, val int32) []byte {
+	startLen := len(b)
+	const dataLen = 4
+	b = append(b, make([]byte, syscall.CmsgSpace(dataLen))...)
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[startLen]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(syscall.CmsgLen(dataLen))
+	*(*int32)(unsafe.Pointer(&b[startLen+syscall.CmsgSpace(0)])) = val
+	return b
+}
+
+// parseECN reads the ECN codepoint from the control messages of a received packet.
+// It returns ECNNon if the control messages don't contain the TOS or traffic class.
+func parseECN(oob []byte) protocol.ECN {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return protocol.ECNNon
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_TOS && len(msg.Data) >= 1:
+			return protocol.ECN(msg.Data[0] & 0x3)
+		case msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == syscall.IPV6_TCLASS && len(msg.Data) >= 4:
+			return protocol.ECN(*(*int32)(unsafe.Pointer(&msg.Data[0])) & 0x3)
+		}
+	}
+	return protocol.ECNNon
+}
+
+// appendUDPSegmentSizeMsg appends the control message that sets the GSO segment size.
+func appendUDPSegmentSizeMsg(b []byte, size uint16) []byte {
+	startLen := len(b)
+	const dataLen = 2 // the segment size is a uint16
+	b = append(b, make([]byte, syscall.CmsgSpace(dataLen))...)
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[startLen]))
+	h.Level = syscall.IPPROTO_UDP
+	h.Type = udpSegment
+	h.SetLen(syscall.CmsgLen(dataLen))
+	*(*uint16)(unsafe.Pointer(&b[startLen+syscall.CmsgSpace(0)])) = size
+	return b
+}
+
+// isGSOError says if sending failed because the network interface doesn't support GSO.
+// In that case, the kernel returns EIO.
+// WriteBatch wraps the syscall error in a *net.OpError.
+func isGSOError(err error) bool {
+	return errors.Is(err, syscall.EIO)
+}
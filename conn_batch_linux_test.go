@@ -0,0 +1,277 @@
+//go:build linux
+// +build linux
+
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"golang.org/x/net/ipv4"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batched packet I/O", func() {
+	listen := func(network, address string) *net.UDPConn {
+		addr, err := net.ResolveUDPAddr(network, address)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := net.ListenUDP(network, addr)
+		Expect(err).ToNot(HaveOccurred())
+		return conn
+	}
+
+	It("doesn't batch on packet conns that are not UDP conns", func() {
+		Expect(newBatchConn(newMockPacketConn())).To(BeNil())
+	})
+
+	It("detects IPv4 and IPv6 sockets", func() {
+		conn4 := listen("udp4", "127.0.0.1:0")
+		defer conn4.Close()
+		bc := newBatchConn(conn4)
+		Expect(bc).ToNot(BeNil())
+		Expect(bc.isIPv6).To(BeFalse())
+		conn6 := listen("udp", ":0")
+		defer conn6.Close()
+		bc = newBatchConn(conn6)
+		Expect(bc).ToNot(BeNil())
+		Expect(bc.isIPv6).To(BeTrue())
+		// IPv4 addresses can't be used with WriteBatch on a dual-stack socket
+		Expect(bc.canWriteTo(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234})).To(BeFalse())
+		Expect(bc.canWriteTo(&net.UDPAddr{IP: net.IPv6loopback, Port: 1234})).To(BeTrue())
+	})
+
+	It("writes and reads batches, preserving the source addresses", func() {
+		server := listen("udp4", "127.0.0.1:0")
+		defer server.Close()
+		client1 := listen("udp4", "127.0.0.1:0")
+		defer client1.Close()
+		client2 := listen("udp4", "127.0.0.1:0")
+		defer client2.Close()
+
+		c1 := &conn{pconn: client1, currentAddr: server.LocalAddr()}
+		c2 := &conn{pconn: client2, currentAddr: server.LocalAddr()}
+		Expect(c1.WriteBatch([][]byte{[]byte("foo"), []byte("bar")})).To(Succeed())
+		Expect(c2.WriteBatch([][]byte{[]byte("foobar")})).To(Succeed())
+
+		bc := newBatchConn(server)
+		Expect(bc).ToNot(BeNil())
+		ms := make([]ipv4.Message, protocol.MaxPacketBatchSize)
+		for i := range ms {
+			ms[i].Buffers = [][]byte{make([]byte, protocol.MaxReceivePacketSize)}
+		}
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		var received []ipv4.Message
+		for len(received) < 3 {
+			n, err := bc.ReadBatch(ms, 0)
+			Expect(err).ToNot(HaveOccurred())
+			for _, m := range ms[:n] {
+				data := make([]byte, m.N)
+				copy(data, m.Buffers[0][:m.N])
+				received = append(received, ipv4.Message{Buffers: [][]byte{data}, Addr: m.Addr, N: m.N})
+			}
+		}
+		Expect(received).To(HaveLen(3))
+		Expect(received[0].Buffers[0]).To(Equal([]byte("foo")))
+		Expect(received[0].Addr.String()).To(Equal(client1.LocalAddr().String()))
+		Expect(received[1].Buffers[0]).To(Equal([]byte("bar")))
+		Expect(received[1].Addr.String()).To(Equal(client1.LocalAddr().String()))
+		Expect(received[2].Buffers[0]).To(Equal([]byte("foobar")))
+		Expect(received[2].Addr.String()).To(Equal(client2.LocalAddr().String()))
+	})
+
+	It("sends packets using GSO", func() {
+		server := listen("udp4", "127.0.0.1:0")
+		defer server.Close()
+		client := listen("udp4", "127.0.0.1:0")
+		defer client.Close()
+		c := &conn{pconn: client, currentAddr: server.LocalAddr()}
+		packets := [][]byte{
+			bytes.Repeat([]byte{'a'}, 100),
+			bytes.Repeat([]byte{'b'}, 100),
+			bytes.Repeat([]byte{'c'}, 100),
+			bytes.Repeat([]byte{'d'}, 42),
+			bytes.Repeat([]byte{'e'}, 100),
+		}
+		Expect(c.WriteBatch(packets)).To(Succeed())
+		if !c.batchConn.gso {
+			Skip("GSO not supported")
+		}
+		ms := c.packMessages(packets, server.LocalAddr())
+		Expect(ms).To(HaveLen(2))
+		Expect(ms[0].Buffers).To(HaveLen(4))
+		Expect(ms[0].OOB).ToNot(BeEmpty())
+		Expect(ms[1].Buffers).To(HaveLen(1))
+		Expect(ms[1].OOB).To(BeEmpty())
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 1000)
+		for _, p := range packets {
+			n, _, err := server.ReadFrom(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:n]).To(Equal(p))
+		}
+	})
+
+	It("detects errors caused by missing GSO support", func() {
+		Expect(isGSOError(os.NewSyscallError("sendmmsg", syscall.EIO))).To(BeTrue())
+		Expect(isGSOError(&net.OpError{Op: "sendmmsg", Net: "udp", Err: os.NewSyscallError("sendmmsg", syscall.EIO)})).To(BeTrue())
+		Expect(isGSOError(&net.OpError{Op: "sendmmsg", Net: "udp", Err: os.NewSyscallError("sendmmsg", syscall.ECONNREFUSED)})).To(BeFalse())
+		Expect(isGSOError(os.NewSyscallError("sendmmsg", syscall.ECONNREFUSED))).To(BeFalse())
+		Expect(isGSOError(errors.New("foobar"))).To(BeFalse())
+	})
+
+	It("marks packets as ECT(0), and reads the ECN codepoint", func() {
+		for _, network := range []string{"udp4", "udp6"} {
+			address := "127.0.0.1:0"
+			if network == "udp6" {
+				address = "[::1]:0"
+			}
+			server := listen(network, address)
+			defer server.Close()
+			client := listen(network, address)
+			defer client.Close()
+			Expect(enableECN(server, 0)).ToNot(BeNil())
+			bc := newBatchConn(server)
+			Expect(bc).ToNot(BeNil())
+			c := &conn{pconn: client, currentAddr: server.LocalAddr(), ecnOOB: enableECN(client, 0)}
+			Expect(c.ECNEnabled()).To(BeTrue())
+			receive := func() protocol.ECN {
+				ms := []ipv4.Message{{
+					Buffers: [][]byte{make([]byte, protocol.MaxReceivePacketSize)},
+					OOB:     make([]byte, oobBufferSize),
+				}}
+				server.SetReadDeadline(time.Now().Add(time.Second))
+				n, err := bc.ReadBatch(ms, 0)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(1))
+				Expect(ms[0].Buffers[0][:ms[0].N]).To(Equal([]byte("foobar")))
+				return parseECN(ms[0].OOB[:ms[0].NN])
+			}
+			Expect(c.WriteBatch([][]byte{[]byte("foobar")})).To(Succeed())
+			Expect(receive()).To(Equal(protocol.ECT0))
+			Expect(c.Write([]byte("foobar"))).To(Succeed())
+			Expect(receive()).To(Equal(protocol.ECT0))
+			c.DisableECN()
+			Expect(c.ECNEnabled()).To(BeFalse())
+			Expect(c.Write([]byte("foobar"))).To(Succeed())
+			Expect(receive()).To(Equal(protocol.ECNNon))
+		}
+	})
+
+	It("keeps the DSCP in the ECN control message", func() {
+		conn := listen("udp4", "127.0.0.1:0")
+		defer conn.Close()
+		msgs, err := syscall.ParseSocketControlMessage(enableECN(conn, 46))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].Header.Type).To(BeEquivalentTo(syscall.IP_TOS))
+		Expect(msgs[0].Data[0]).To(BeEquivalentTo(46<<2 | int(protocol.ECT0)))
+	})
+
+	It("doesn't change the TOS of packet conns passed by the application", func() {
+		conn := listen("udp4", "127.0.0.1:0")
+		defer conn.Close()
+		Expect(newBatchConn(conn)).ToNot(BeNil())
+		tos, err := ipv4.NewConn(conn).TOS()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tos).To(BeZero())
+	})
+
+	It("returns Not-ECT if the control messages don't contain the ECN codepoint", func() {
+		Expect(parseECN(nil)).To(Equal(protocol.ECNNon))
+		Expect(parseECN([]byte{1, 2, 3})).To(Equal(protocol.ECNNon))
+	})
+
+	It("writes more packets than fit into a single batch", func() {
+		server := listen("udp4", "127.0.0.1:0")
+		defer server.Close()
+		server.SetReadBuffer(1 << 20)
+		client := listen("udp4", "127.0.0.1:0")
+		defer client.Close()
+		c := &conn{pconn: client, currentAddr: server.LocalAddr()}
+		packets := make([][]byte, 2*protocol.MaxPacketBatchSize+1)
+		for i := range packets {
+			packets[i] = []byte{byte(i)}
+		}
+		Expect(c.WriteBatch(packets)).To(Succeed())
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 10)
+		for i := range packets {
+			n, _, err := server.ReadFrom(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:n]).To(Equal([]byte{byte(i)}))
+		}
+	})
+})
+
+// The benchmarks compare the number of syscalls needed to read packets.
+// Run them with
+//
+//	go test -run=NONE -bench=Read .
+func benchmarkRead(b *testing.B, read func(*net.UDPConn) int) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer server.Close()
+	server.SetReadBuffer(4 << 20)
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	c := &conn{pconn: client, currentAddr: server.LocalAddr()}
+	packets := make([][]byte, protocol.MaxPacketBatchSize)
+	for i := range packets {
+		packets[i] = make([]byte, 1200)
+	}
+
+	b.ResetTimer()
+	var syscalls, numPackets int
+	for i := 0; i < b.N; i++ {
+		if err := c.WriteBatch(packets); err != nil {
+			b.Fatal(err)
+		}
+		for received := 0; received < len(packets); {
+			received += read(server)
+			syscalls++
+		}
+		numPackets += len(packets)
+	}
+	b.ReportMetric(float64(numPackets)/float64(syscalls), "packets/syscall")
+}
+
+func BenchmarkReadFrom(b *testing.B) {
+	buf := make([]byte, protocol.MaxReceivePacketSize)
+	benchmarkRead(b, func(c *net.UDPConn) int {
+		if _, _, err := c.ReadFrom(buf); err != nil {
+			b.Fatal(err)
+		}
+		return 1
+	})
+}
+
+func BenchmarkReadBatch(b *testing.B) {
+	ms := make([]ipv4.Message, protocol.MaxPacketBatchSize)
+	for i := range ms {
+		ms[i].Buffers = [][]byte{make([]byte, protocol.MaxReceivePacketSize)}
+	}
+	var bc *batchConn
+	benchmarkRead(b, func(c *net.UDPConn) int {
+		if bc == nil {
+			bc = newBatchConn(c)
+		}
+		n, err := bc.ReadBatch(ms, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return n
+	})
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package quic
+
+import (
+	"net"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+)
+
+const oobBufferSize = 0
+
+// newBatchConn returns nil, since batched packet I/O is only supported on Linux.
+func newBatchConn(net.PacketConn) *batchConn {
+	return nil
+}
+
+// enableECN returns nil, since ECN is only supported on Linux.
+func enableECN(*net.UDPConn, uint8) []byte { return nil }
+
+func appendUDPSegmentSizeMsg(b []byte, _ uint16) []byte { return b }
+
+func isGSOError(error) bool { return false }
+
+func parseECN([]byte) protocol.ECN { return protocol.ECNNon }
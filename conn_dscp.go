@@ -0,0 +1,32 @@
+package quic
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// setDiffServCodePoint sets the DSCP on all packets sent on conn.
+// The DSCP is the upper 6 bits of the IPv4 TOS byte and the IPv6 traffic class.
+// A value of 0 leaves the socket untouched.
+func setDiffServCodePoint(conn *net.UDPConn, dscp uint8) error {
+	if dscp == 0 {
+		return nil
+	}
+	if dscp > 63 {
+		return fmt.Errorf("quic: invalid DiffServCodePoint (%d), must be smaller than 64", dscp)
+	}
+	tos := int(dscp) << 2
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return ipv4.NewConn(conn).SetTOS(tos)
+	}
+	if err := ipv6.NewConn(conn).SetTrafficClass(tos); err != nil {
+		return err
+	}
+	// IPv6 sockets might be dual-stack sockets, used to send IPv4 packets as well.
+	// Not all platforms allow setting the TOS on IPv6 sockets.
+	ipv4.NewConn(conn).SetTOS(tos)
+	return nil
+}
@@ -0,0 +1,55 @@
+package quic
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DiffServ Code Point", func() {
+	listen := func(network, address string) *net.UDPConn {
+		addr, err := net.ResolveUDPAddr(network, address)
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := net.ListenUDP(network, addr)
+		Expect(err).ToNot(HaveOccurred())
+		return conn
+	}
+
+	It("sets the TOS on IPv4 sockets", func() {
+		conn := listen("udp4", "127.0.0.1:0")
+		defer conn.Close()
+		Expect(setDiffServCodePoint(conn, 46)).To(Succeed())
+		tos, err := ipv4.NewConn(conn).TOS()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tos).To(Equal(46 << 2))
+	})
+
+	It("sets the traffic class on IPv6 sockets", func() {
+		conn := listen("udp6", "[::1]:0")
+		defer conn.Close()
+		Expect(setDiffServCodePoint(conn, 46)).To(Succeed())
+		tc, err := ipv6.NewConn(conn).TrafficClass()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tc).To(Equal(46 << 2))
+	})
+
+	It("leaves the socket untouched if the DSCP is 0", func() {
+		conn := listen("udp4", "127.0.0.1:0")
+		defer conn.Close()
+		Expect(ipv4.NewConn(conn).SetTOS(0x20)).To(Succeed())
+		Expect(setDiffServCodePoint(conn, 0)).To(Succeed())
+		tos, err := ipv4.NewConn(conn).TOS()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tos).To(Equal(0x20))
+	})
+
+	It("errors on invalid values", func() {
+		conn := listen("udp4", "127.0.0.1:0")
+		defer conn.Close()
+		Expect(setDiffServCodePoint(conn, 64)).To(MatchError("quic: invalid DiffServCodePoint (64), must be smaller than 64"))
+	})
+})
@@ -0,0 +1,146 @@
+package quic
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+// The connIDGenerator issues the connection IDs that the peer uses to address us.
+// The connection ID used during the handshake has sequence number 0.
+type connIDGenerator struct {
+	// The mutex is needed since the connection IDs are retired when the session is closed,
+	// which can happen on a different go routine than the run loop.
+	mutex sync.Mutex
+
+	connIDLen  int
+	highestSeq uint64
+	numConnIDs int // the number of connection IDs the peer should have available
+
+	activeSrcConnIDs map[uint64]protocol.ConnectionID
+
+	generateConnectionID   func() (protocol.ConnectionID, error)
+	addConnectionID        func(protocol.ConnectionID) bool // returns false if the connection ID is already in use
+	getStatelessResetToken func(protocol.ConnectionID) [16]byte
+	retireConnectionID     func(protocol.ConnectionID)
+	removeConnectionID     func(protocol.ConnectionID)
+	queueControlFrame      func(wire.Frame)
+}
+
+func newConnIDGenerator(
+	initialConnectionID protocol.ConnectionID,
+	generateConnectionID func() (protocol.ConnectionID, error),
+	addConnectionID func(protocol.ConnectionID) bool,
+	getStatelessResetToken func(protocol.ConnectionID) [16]byte,
+	retireConnectionID func(protocol.ConnectionID),
+	removeConnectionID func(protocol.ConnectionID),
+	queueControlFrame func(wire.Frame),
+) *connIDGenerator {
+	return &connIDGenerator{
+		connIDLen:              initialConnectionID.Len(),
+		numConnIDs:             1,
+		activeSrcConnIDs:       map[uint64]protocol.ConnectionID{0: initialConnectionID},
+		generateConnectionID:   generateConnectionID,
+		addConnectionID:        addConnectionID,
+		getStatelessResetToken: getStatelessResetToken,
+		retireConnectionID:     retireConnectionID,
+		removeConnectionID:     removeConnectionID,
+		queueControlFrame:      queueControlFrame,
+	}
+}
+
+// SetNumConnIDs issues new connection IDs, until the peer has num connection IDs available.
+// It is a no-op if zero-length connection IDs are used.
+func (m *connIDGenerator) SetNumConnIDs(num int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.connIDLen == 0 {
+		return nil
+	}
+	m.numConnIDs = num
+	for len(m.activeSrcConnIDs) < m.numConnIDs {
+		if err := m.issueNewConnID(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retire is called when the peer sends a RETIRE_CONNECTION_ID frame.
+// A new connection ID is issued to replace the retired one.
+func (m *connIDGenerator) Retire(seq uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if seq > m.highestSeq {
+		return qerr.Error(qerr.InvalidFrameData, fmt.Sprintf("tried to retire connection ID %d, but highest issued is %d", seq, m.highestSeq))
+	}
+	connID, ok := m.activeSrcConnIDs[seq]
+	// We might already have retired this connection ID, if the RETIRE_CONNECTION_ID frame was retransmitted.
+	if !ok {
+		return nil
+	}
+	// Issue the new connection ID first.
+	// This way, the peer always has a connection ID available.
+	if m.connIDLen > 0 && len(m.activeSrcConnIDs) <= m.numConnIDs {
+		if err := m.issueNewConnID(); err != nil {
+			return err
+		}
+	}
+	m.retireConnectionID(connID)
+	delete(m.activeSrcConnIDs, seq)
+	return nil
+}
+
+func (m *connIDGenerator) issueNewConnID() error {
+	var connID protocol.ConnectionID
+	for i := 0; ; i++ {
+		if i >= protocol.MaxConnectionIDGenerationAttempts {
+			return fmt.Errorf("failed to generate an unused connection ID after %d attempts", i)
+		}
+		var err error
+		connID, err = m.generateConnectionID()
+		if err != nil {
+			return err
+		}
+		if m.addConnectionID(connID) {
+			break
+		}
+	}
+	m.highestSeq++
+	m.activeSrcConnIDs[m.highestSeq] = connID
+	m.queueControlFrame(&wire.NewConnectionIDFrame{
+		SequenceNumber:      m.highestSeq,
+		ConnectionID:        connID,
+		StatelessResetToken: m.getStatelessResetToken(connID),
+	})
+	return nil
+}
+
+// RetireAll retires all active connection IDs.
+// It is called when the session is closed locally.
+func (m *connIDGenerator) RetireAll() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for seq, connID := range m.activeSrcConnIDs {
+		m.retireConnectionID(connID)
+		delete(m.activeSrcConnIDs, seq)
+	}
+}
+
+// RemoveAll removes all active connection IDs.
+// It is called when the session is closed by the peer, or destroyed.
+func (m *connIDGenerator) RemoveAll() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for seq, connID := range m.activeSrcConnIDs {
+		m.removeConnectionID(connID)
+		delete(m.activeSrcConnIDs, seq)
+	}
+}
@@ -0,0 +1,139 @@
+package quic
+
+import (
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection ID Generator", func() {
+	var (
+		addedConnIDs   []protocol.ConnectionID
+		retiredConnIDs []protocol.ConnectionID
+		removedConnIDs []protocol.ConnectionID
+		queuedFrames   []wire.Frame
+		g              *connIDGenerator
+	)
+	initialConnID := protocol.ConnectionID{1, 1, 1, 1}
+
+	connIDToToken := func(c protocol.ConnectionID) [16]byte {
+		return [16]byte{c[0], c[1], c[2], c[3]}
+	}
+
+	BeforeEach(func() {
+		addedConnIDs = nil
+		retiredConnIDs = nil
+		removedConnIDs = nil
+		queuedFrames = nil
+		g = newConnIDGenerator(
+			initialConnID,
+			func() (protocol.ConnectionID, error) { return protocol.GenerateConnectionID(initialConnID.Len()) },
+			func(c protocol.ConnectionID) bool {
+				addedConnIDs = append(addedConnIDs, c)
+				return true
+			},
+			connIDToToken,
+			func(c protocol.ConnectionID) { retiredConnIDs = append(retiredConnIDs, c) },
+			func(c protocol.ConnectionID) { removedConnIDs = append(removedConnIDs, c) },
+			func(f wire.Frame) { queuedFrames = append(queuedFrames, f) },
+		)
+	})
+
+	It("issues new connection IDs", func() {
+		Expect(g.SetNumConnIDs(4)).To(Succeed())
+		Expect(addedConnIDs).To(HaveLen(3))
+		Expect(queuedFrames).To(HaveLen(3))
+		for i := 0; i < 3; i++ {
+			f := queuedFrames[i].(*wire.NewConnectionIDFrame)
+			Expect(f.SequenceNumber).To(BeEquivalentTo(i + 1))
+			Expect(f.ConnectionID).To(Equal(addedConnIDs[i]))
+			Expect(f.ConnectionID.Len()).To(Equal(initialConnID.Len()))
+			Expect(f.StatelessResetToken).To(Equal(connIDToToken(f.ConnectionID)))
+		}
+	})
+
+	It("generates a new connection ID if the generated one is already in use", func() {
+		var generated []protocol.ConnectionID
+		g.generateConnectionID = func() (protocol.ConnectionID, error) {
+			connID := protocol.ConnectionID{byte(len(generated)), 2, 3, 4}
+			generated = append(generated, connID)
+			return connID, nil
+		}
+		g.addConnectionID = func(c protocol.ConnectionID) bool {
+			if c[0] == 0 {
+				return false
+			}
+			addedConnIDs = append(addedConnIDs, c)
+			return true
+		}
+		Expect(g.SetNumConnIDs(2)).To(Succeed())
+		Expect(generated).To(HaveLen(2))
+		Expect(addedConnIDs).To(Equal([]protocol.ConnectionID{{1, 2, 3, 4}}))
+		Expect(queuedFrames).To(HaveLen(1))
+		Expect(queuedFrames[0].(*wire.NewConnectionIDFrame).ConnectionID).To(Equal(protocol.ConnectionID{1, 2, 3, 4}))
+	})
+
+	It("errors if it can't generate an unused connection ID", func() {
+		g.addConnectionID = func(protocol.ConnectionID) bool { return false }
+		err := g.SetNumConnIDs(2)
+		Expect(err).To(MatchError(fmt.Sprintf("failed to generate an unused connection ID after %d attempts", protocol.MaxConnectionIDGenerationAttempts)))
+		Expect(queuedFrames).To(BeEmpty())
+	})
+
+	It("doesn't issue connection IDs when using zero-length connection IDs", func() {
+		g.connIDLen = 0
+		Expect(g.SetNumConnIDs(4)).To(Succeed())
+		Expect(addedConnIDs).To(BeEmpty())
+		Expect(queuedFrames).To(BeEmpty())
+	})
+
+	It("retires connection IDs, and issues new ones", func() {
+		Expect(g.SetNumConnIDs(2)).To(Succeed())
+		Expect(addedConnIDs).To(HaveLen(1))
+		queuedFrames = nil
+		Expect(g.Retire(0)).To(Succeed())
+		Expect(retiredConnIDs).To(Equal([]protocol.ConnectionID{initialConnID}))
+		Expect(addedConnIDs).To(HaveLen(2))
+		Expect(queuedFrames).To(HaveLen(1))
+		Expect(queuedFrames[0].(*wire.NewConnectionIDFrame).SequenceNumber).To(BeEquivalentTo(2))
+	})
+
+	It("ignores duplicate retirements", func() {
+		Expect(g.SetNumConnIDs(2)).To(Succeed())
+		Expect(g.Retire(1)).To(Succeed())
+		Expect(retiredConnIDs).To(HaveLen(1))
+		Expect(addedConnIDs).To(HaveLen(2))
+		Expect(g.Retire(1)).To(Succeed())
+		Expect(retiredConnIDs).To(HaveLen(1))
+		Expect(addedConnIDs).To(HaveLen(2))
+	})
+
+	It("errors when the peer retires a connection ID that wasn't issued yet", func() {
+		Expect(g.SetNumConnIDs(2)).To(Succeed())
+		err := g.Retire(2)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidFrameData))
+	})
+
+	It("retires all connection IDs", func() {
+		Expect(g.SetNumConnIDs(3)).To(Succeed())
+		g.RetireAll()
+		Expect(retiredConnIDs).To(HaveLen(3))
+		Expect(retiredConnIDs).To(ContainElement(initialConnID))
+		Expect(retiredConnIDs).To(ContainElement(addedConnIDs[0]))
+		Expect(retiredConnIDs).To(ContainElement(addedConnIDs[1]))
+		Expect(removedConnIDs).To(BeEmpty())
+	})
+
+	It("removes all connection IDs", func() {
+		Expect(g.SetNumConnIDs(3)).To(Succeed())
+		g.RemoveAll()
+		Expect(removedConnIDs).To(HaveLen(3))
+		Expect(removedConnIDs).To(ContainElement(initialConnID))
+		Expect(retiredConnIDs).To(BeEmpty())
+	})
+})
@@ -0,0 +1,109 @@
+package quic
+
+import (
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+type newConnID struct {
+	SequenceNumber      uint64
+	ConnectionID        protocol.ConnectionID
+	StatelessResetToken [16]byte
+}
+
+// The connIDManager keeps track of the connection IDs issued by the peer.
+// The connection ID used during the handshake has sequence number 0.
+type connIDManager struct {
+	// the unused connection IDs, sorted by sequence number
+	queue []newConnID
+
+	activeSequenceNumber uint64
+	activeConnectionID   protocol.ConnectionID
+
+	addStatelessResetToken func([16]byte)
+	queueControlFrame      func(wire.Frame)
+}
+
+func newConnIDManager(
+	initialDestConnID protocol.ConnectionID,
+	addStatelessResetToken func([16]byte),
+	queueControlFrame func(wire.Frame),
+) *connIDManager {
+	return &connIDManager{
+		activeConnectionID:     initialDestConnID,
+		addStatelessResetToken: addStatelessResetToken,
+		queueControlFrame:      queueControlFrame,
+	}
+}
+
+// ChangeInitialConnID is called when the server chose a connection ID during the handshake.
+func (h *connIDManager) ChangeInitialConnID(connID protocol.ConnectionID) {
+	h.activeConnectionID = connID
+}
+
+// SetStatelessResetToken sets the stateless reset token for the connection ID used during the handshake.
+func (h *connIDManager) SetStatelessResetToken(token [16]byte) {
+	h.addStatelessResetToken(token)
+}
+
+// Add adds a connection ID received in a NEW_CONNECTION_ID frame.
+func (h *connIDManager) Add(f *wire.NewConnectionIDFrame) error {
+	if f.SequenceNumber < h.activeSequenceNumber {
+		// this connection ID was already retired
+		return nil
+	}
+	if f.SequenceNumber == h.activeSequenceNumber {
+		if !f.ConnectionID.Equal(h.activeConnectionID) {
+			return qerr.Error(qerr.InvalidFrameData, fmt.Sprintf("received conflicting connection IDs for sequence number %d", f.SequenceNumber))
+		}
+		return nil
+	}
+	i := 0
+	for ; i < len(h.queue); i++ {
+		entry := h.queue[i]
+		if entry.SequenceNumber == f.SequenceNumber {
+			// this NEW_CONNECTION_ID frame was retransmitted
+			if !entry.ConnectionID.Equal(f.ConnectionID) || entry.StatelessResetToken != f.StatelessResetToken {
+				return qerr.Error(qerr.InvalidFrameData, fmt.Sprintf("received conflicting connection IDs for sequence number %d", f.SequenceNumber))
+			}
+			return nil
+		}
+		if entry.SequenceNumber > f.SequenceNumber {
+			break
+		}
+	}
+	if len(h.queue) >= protocol.MaxActiveConnectionIDs-1 {
+		return qerr.Error(qerr.InvalidFrameData, fmt.Sprintf("peer issued more than %d connection IDs", protocol.MaxActiveConnectionIDs))
+	}
+	h.queue = append(h.queue, newConnID{})
+	copy(h.queue[i+1:], h.queue[i:])
+	h.queue[i] = newConnID{
+		SequenceNumber:      f.SequenceNumber,
+		ConnectionID:        f.ConnectionID,
+		StatelessResetToken: f.StatelessResetToken,
+	}
+	return nil
+}
+
+// SwitchToNext switches to the next unused connection ID, and retires the connection ID used so far.
+// It returns false if the peer didn't provide any unused connection IDs.
+func (h *connIDManager) SwitchToNext() (protocol.ConnectionID, bool) {
+	if len(h.queue) == 0 {
+		return nil, false
+	}
+	next := h.queue[0]
+	h.queue = h.queue[1:]
+	h.queueControlFrame(&wire.RetireConnectionIDFrame{SequenceNumber: h.activeSequenceNumber})
+	h.activeSequenceNumber = next.SequenceNumber
+	h.activeConnectionID = next.ConnectionID
+	h.addStatelessResetToken(next.StatelessResetToken)
+	return next.ConnectionID, true
+}
+
+// Get returns the connection ID that is currently used.
+func (h *connIDManager) Get() protocol.ConnectionID {
+	return h.activeConnectionID
+}
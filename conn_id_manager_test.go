@@ -0,0 +1,124 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection ID Manager", func() {
+	var (
+		m            *connIDManager
+		resetTokens  [][16]byte
+		queuedFrames []wire.Frame
+	)
+	initialConnID := protocol.ConnectionID{1, 1, 1, 1}
+
+	BeforeEach(func() {
+		resetTokens = nil
+		queuedFrames = nil
+		m = newConnIDManager(
+			initialConnID,
+			func(token [16]byte) { resetTokens = append(resetTokens, token) },
+			func(f wire.Frame) { queuedFrames = append(queuedFrames, f) },
+		)
+	})
+
+	It("returns the initial connection ID", func() {
+		Expect(m.Get()).To(Equal(initialConnID))
+		m.ChangeInitialConnID(protocol.ConnectionID{2, 2, 2, 2})
+		Expect(m.Get()).To(Equal(protocol.ConnectionID{2, 2, 2, 2}))
+	})
+
+	It("sets the stateless reset token for the initial connection ID", func() {
+		m.SetStatelessResetToken([16]byte{0xde, 0xad})
+		Expect(resetTokens).To(Equal([][16]byte{{0xde, 0xad}}))
+	})
+
+	It("doesn't switch if no new connection IDs are available", func() {
+		_, ok := m.SwitchToNext()
+		Expect(ok).To(BeFalse())
+		Expect(m.Get()).To(Equal(initialConnID))
+		Expect(queuedFrames).To(BeEmpty())
+	})
+
+	It("switches to the connection ID with the lowest sequence number, and retires the old one", func() {
+		Expect(m.Add(&wire.NewConnectionIDFrame{
+			SequenceNumber:      2,
+			ConnectionID:        protocol.ConnectionID{2, 2, 2, 2},
+			StatelessResetToken: [16]byte{2},
+		})).To(Succeed())
+		Expect(m.Add(&wire.NewConnectionIDFrame{
+			SequenceNumber:      1,
+			ConnectionID:        protocol.ConnectionID{1, 2, 3, 4},
+			StatelessResetToken: [16]byte{1},
+		})).To(Succeed())
+		connID, ok := m.SwitchToNext()
+		Expect(ok).To(BeTrue())
+		Expect(connID).To(Equal(protocol.ConnectionID{1, 2, 3, 4}))
+		Expect(m.Get()).To(Equal(connID))
+		Expect(resetTokens).To(Equal([][16]byte{{1}}))
+		Expect(queuedFrames).To(Equal([]wire.Frame{&wire.RetireConnectionIDFrame{SequenceNumber: 0}}))
+		connID, ok = m.SwitchToNext()
+		Expect(ok).To(BeTrue())
+		Expect(connID).To(Equal(protocol.ConnectionID{2, 2, 2, 2}))
+		Expect(resetTokens).To(Equal([][16]byte{{1}, {2}}))
+		Expect(queuedFrames).To(HaveLen(2))
+		Expect(queuedFrames[1]).To(Equal(&wire.RetireConnectionIDFrame{SequenceNumber: 1}))
+	})
+
+	It("ignores duplicate NEW_CONNECTION_ID frames", func() {
+		f := &wire.NewConnectionIDFrame{
+			SequenceNumber:      1,
+			ConnectionID:        protocol.ConnectionID{1, 2, 3, 4},
+			StatelessResetToken: [16]byte{1},
+		}
+		Expect(m.Add(f)).To(Succeed())
+		Expect(m.Add(f)).To(Succeed())
+		Expect(m.queue).To(HaveLen(1))
+		_, ok := m.SwitchToNext()
+		Expect(ok).To(BeTrue())
+		// the connection ID is now in use
+		Expect(m.Add(f)).To(Succeed())
+		Expect(m.queue).To(BeEmpty())
+	})
+
+	It("ignores NEW_CONNECTION_ID frames for retired connection IDs", func() {
+		Expect(m.Add(&wire.NewConnectionIDFrame{SequenceNumber: 1, ConnectionID: protocol.ConnectionID{1, 2, 3, 4}})).To(Succeed())
+		Expect(m.Add(&wire.NewConnectionIDFrame{SequenceNumber: 2, ConnectionID: protocol.ConnectionID{2, 3, 4, 5}})).To(Succeed())
+		_, ok := m.SwitchToNext()
+		Expect(ok).To(BeTrue())
+		_, ok = m.SwitchToNext()
+		Expect(ok).To(BeTrue())
+		Expect(m.Add(&wire.NewConnectionIDFrame{SequenceNumber: 1, ConnectionID: protocol.ConnectionID{1, 2, 3, 4}})).To(Succeed())
+		Expect(m.queue).To(BeEmpty())
+	})
+
+	It("errors when the peer sends conflicting connection IDs", func() {
+		Expect(m.Add(&wire.NewConnectionIDFrame{SequenceNumber: 1, ConnectionID: protocol.ConnectionID{1, 2, 3, 4}})).To(Succeed())
+		err := m.Add(&wire.NewConnectionIDFrame{SequenceNumber: 1, ConnectionID: protocol.ConnectionID{4, 3, 2, 1}})
+		Expect(err).To(MatchError("InvalidFrameData: received conflicting connection IDs for sequence number 1"))
+	})
+
+	It("errors when the peer sends a different connection ID for the active sequence number", func() {
+		err := m.Add(&wire.NewConnectionIDFrame{SequenceNumber: 0, ConnectionID: protocol.ConnectionID{4, 3, 2, 1}})
+		Expect(err).To(MatchError("InvalidFrameData: received conflicting connection IDs for sequence number 0"))
+	})
+
+	It("errors when the peer issues too many connection IDs", func() {
+		for i := 1; i < protocol.MaxActiveConnectionIDs; i++ {
+			Expect(m.Add(&wire.NewConnectionIDFrame{
+				SequenceNumber: uint64(i),
+				ConnectionID:   protocol.ConnectionID{byte(i), 2, 3, 4},
+			})).To(Succeed())
+		}
+		err := m.Add(&wire.NewConnectionIDFrame{
+			SequenceNumber: protocol.MaxActiveConnectionIDs,
+			ConnectionID:   protocol.ConnectionID{0xff, 2, 3, 4},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidFrameData))
+	})
+})
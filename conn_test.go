@@ -5,6 +5,8 @@ import (
 	"net"
 	"time"
 
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -89,6 +91,67 @@ var _ = Describe("Connection", func() {
 		Expect(write.data).To(Equal([]byte("foobar")))
 	})
 
+	It("writes to a different address", func() {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7331}
+		Expect(c.WriteTo([]byte("foobar"), addr)).To(Succeed())
+		var write mockPacketConnWrite
+		Expect(packetConn.dataWritten).To(Receive(&write))
+		Expect(write.to).To(Equal(addr))
+		Expect(write.data).To(Equal([]byte("foobar")))
+		Expect(c.RemoteAddr().String()).To(Equal("192.168.100.200:1337"))
+	})
+
+	It("writes batches packet by packet, if batching is not supported", func() {
+		Expect(c.WriteBatch([][]byte{[]byte("foo"), []byte("bar")})).To(Succeed())
+		var write mockPacketConnWrite
+		Expect(packetConn.dataWritten).To(Receive(&write))
+		Expect(write.to.String()).To(Equal("192.168.100.200:1337"))
+		Expect(write.data).To(Equal([]byte("foo")))
+		Expect(packetConn.dataWritten).To(Receive(&write))
+		Expect(write.data).To(Equal([]byte("bar")))
+		Expect(packetConn.dataWritten).ToNot(Receive())
+	})
+
+	Context("GSO segments", func() {
+		packets := func(sizes ...int) [][]byte {
+			p := make([][]byte, len(sizes))
+			for i, s := range sizes {
+				p[i] = make([]byte, s)
+			}
+			return p
+		}
+
+		It("coalesces packets of the same size", func() {
+			Expect(numGSOSegments(packets(1000, 1000, 1000))).To(Equal(3))
+			Expect(numGSOSegments(packets(1000))).To(Equal(1))
+		})
+
+		It("allows the last packet to be smaller", func() {
+			Expect(numGSOSegments(packets(1000, 1000, 500, 500))).To(Equal(3))
+		})
+
+		It("doesn't coalesce larger packets", func() {
+			Expect(numGSOSegments(packets(500, 1000, 1000))).To(Equal(1))
+			Expect(numGSOSegments(packets(1000, 1000, 1200))).To(Equal(2))
+		})
+
+		It("respects the maximum number of segments", func() {
+			sizes := make([]int, protocol.MaxGSOSegments+10)
+			for i := range sizes {
+				sizes[i] = 10
+			}
+			Expect(numGSOSegments(packets(sizes...))).To(Equal(protocol.MaxGSOSegments))
+		})
+
+		It("respects the maximum buffer size", func() {
+			sizes := make([]int, protocol.MaxGSOSegments)
+			for i := range sizes {
+				sizes[i] = 1400
+			}
+			Expect(numGSOSegments(packets(sizes...))).To(Equal(protocol.MaxGSOBufferSize / 1400))
+		})
+	})
+
 	It("reads", func() {
 		packetConn.dataToRead <- []byte("foo")
 		packetConn.dataReadFrom = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1336}
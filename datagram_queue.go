@@ -0,0 +1,104 @@
+package quic
+
+import (
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+type datagramQueue struct {
+	sendQueue chan *wire.DatagramFrame
+	nextFrame *wire.DatagramFrame // only accessed by the packer
+	rcvQueue  chan []byte
+
+	mutex        sync.Mutex
+	maxFrameSize protocol.ByteCount // 0 as long as the peer didn't enable DATAGRAM frames
+
+	closeErr error
+	closed   chan struct{}
+
+	hasData func()
+
+	logger utils.Logger
+}
+
+func newDatagramQueue(hasData func(), logger utils.Logger) *datagramQueue {
+	return &datagramQueue{
+		sendQueue: make(chan *wire.DatagramFrame, protocol.DatagramSendQueueLen),
+		rcvQueue:  make(chan []byte, protocol.DatagramRcvQueueLen),
+		closed:    make(chan struct{}),
+		hasData:   hasData,
+		logger:    logger,
+	}
+}
+
+// SetMaxFrameSize sets the maximum size of a DATAGRAM frame that can be sent.
+func (h *datagramQueue) SetMaxFrameSize(s protocol.ByteCount) {
+	h.mutex.Lock()
+	h.maxFrameSize = s
+	h.mutex.Unlock()
+}
+
+// MaxFrameSize returns the maximum size of a DATAGRAM frame that can be sent.
+func (h *datagramQueue) MaxFrameSize() protocol.ByteCount {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.maxFrameSize
+}
+
+// AddAndWait queues a new DATAGRAM frame for sending.
+// It blocks until the frame has been queued, or the queue is closed.
+func (h *datagramQueue) AddAndWait(f *wire.DatagramFrame) error {
+	select {
+	case h.sendQueue <- f:
+		h.hasData()
+		return nil
+	case <-h.closed:
+		return h.closeErr
+	}
+}
+
+// Peek returns the next DATAGRAM frame for sending, without removing it from the queue.
+// If the frame doesn't fit into the current packet, it will be returned again for the next packet.
+func (h *datagramQueue) Peek() *wire.DatagramFrame {
+	if h.nextFrame == nil {
+		select {
+		case h.nextFrame = <-h.sendQueue:
+		default:
+		}
+	}
+	return h.nextFrame
+}
+
+// Pop removes the frame returned by Peek from the queue.
+func (h *datagramQueue) Pop() {
+	h.nextFrame = nil
+}
+
+// HandleDatagramFrame handles a received DATAGRAM frame.
+// If the application doesn't read messages fast enough, the frame is dropped.
+func (h *datagramQueue) HandleDatagramFrame(f *wire.DatagramFrame) {
+	select {
+	case h.rcvQueue <- f.Data:
+	default:
+		h.logger.Debugf("Discarding DATAGRAM frame (%d bytes payload)", len(f.Data))
+	}
+}
+
+// Receive gets the payload of a received DATAGRAM frame.
+// It blocks until a frame is received, or the queue is closed.
+func (h *datagramQueue) Receive() ([]byte, error) {
+	select {
+	case data := <-h.rcvQueue:
+		return data, nil
+	case <-h.closed:
+		return nil, h.closeErr
+	}
+}
+
+func (h *datagramQueue) CloseWithError(e error) {
+	h.closeErr = e
+	close(h.closed)
+}
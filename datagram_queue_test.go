@@ -0,0 +1,120 @@
+package quic
+
+import (
+	"errors"
+
+	"github.com/lucas-clemente/quic-go/internal/utils"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Datagram Queue", func() {
+	var queue *datagramQueue
+	var queued chan struct{}
+
+	BeforeEach(func() {
+		queued = make(chan struct{}, 100)
+		queue = newDatagramQueue(func() { queued <- struct{}{} }, utils.DefaultLogger)
+	})
+
+	Context("sending", func() {
+		It("returns nil when there's no datagram to send", func() {
+			Expect(queue.Peek()).To(BeNil())
+		})
+
+		It("queues a datagram", func() {
+			f := &wire.DatagramFrame{Data: []byte("foobar")}
+			Expect(queue.AddAndWait(f)).To(Succeed())
+			Expect(queued).To(Receive())
+			Expect(queue.Peek()).To(Equal(f))
+			// peeking again returns the same frame
+			Expect(queue.Peek()).To(Equal(f))
+			queue.Pop()
+			Expect(queue.Peek()).To(BeNil())
+		})
+
+		It("blocks when the queue is full", func() {
+			for i := 0; i < cap(queue.sendQueue); i++ {
+				Expect(queue.AddAndWait(&wire.DatagramFrame{})).To(Succeed())
+			}
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				Expect(queue.AddAndWait(&wire.DatagramFrame{})).To(Succeed())
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			Expect(queue.Peek()).ToNot(BeNil())
+			queue.Pop()
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("returns the close error when adding a datagram to a closed queue", func() {
+			for i := 0; i < cap(queue.sendQueue); i++ {
+				Expect(queue.AddAndWait(&wire.DatagramFrame{})).To(Succeed())
+			}
+			errChan := make(chan error, 1)
+			go func() { errChan <- queue.AddAndWait(&wire.DatagramFrame{}) }()
+			Consistently(errChan).ShouldNot(Receive())
+			queue.CloseWithError(errors.New("test error"))
+			Eventually(errChan).Should(Receive(MatchError("test error")))
+		})
+	})
+
+	Context("receiving", func() {
+		It("receives DATAGRAM frames", func() {
+			queue.HandleDatagramFrame(&wire.DatagramFrame{Data: []byte("foo")})
+			queue.HandleDatagramFrame(&wire.DatagramFrame{Data: []byte("bar")})
+			data, err := queue.Receive()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foo")))
+			data, err = queue.Receive()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("bar")))
+		})
+
+		It("drops DATAGRAM frames when the queue is full", func() {
+			for i := 0; i < cap(queue.rcvQueue)+1; i++ {
+				queue.HandleDatagramFrame(&wire.DatagramFrame{Data: []byte{byte(i)}})
+			}
+			for i := 0; i < cap(queue.rcvQueue); i++ {
+				data, err := queue.Receive()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte{byte(i)}))
+			}
+			Expect(queue.rcvQueue).To(BeEmpty())
+		})
+
+		It("blocks until a frame is received", func() {
+			dataChan := make(chan []byte, 1)
+			go func() {
+				defer GinkgoRecover()
+				data, err := queue.Receive()
+				Expect(err).ToNot(HaveOccurred())
+				dataChan <- data
+			}()
+			Consistently(dataChan).ShouldNot(Receive())
+			queue.HandleDatagramFrame(&wire.DatagramFrame{Data: []byte("foobar")})
+			Eventually(dataChan).Should(Receive(Equal([]byte("foobar"))))
+		})
+
+		It("returns the close error when receiving from a closed queue", func() {
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := queue.Receive()
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			queue.CloseWithError(errors.New("test error"))
+			Eventually(errChan).Should(Receive(MatchError("test error")))
+		})
+	})
+
+	It("stores the maximum frame size", func() {
+		Expect(queue.MaxFrameSize()).To(BeZero())
+		queue.SetMaxFrameSize(1000)
+		Expect(queue.MaxFrameSize()).To(BeEquivalentTo(1000))
+	})
+})
@@ -0,0 +1,124 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+)
+
+var (
+	// ErrIdleTimeout is matched by the ConnectionError (using errors.Is)
+	// if the session was closed because there was no network activity for the idle timeout.
+	ErrIdleTimeout = errors.New("quic: idle timeout")
+	// ErrHandshakeTimeout is matched by the ConnectionError (using errors.Is)
+	// if the session was closed because the handshake didn't complete in time.
+	ErrHandshakeTimeout = errors.New("quic: handshake timeout")
+	// ErrNoApplicationProtocol is matched by the ConnectionError (using errors.Is)
+	// if the handshake failed because client and server didn't have an ALPN protocol in common (see tls.Config.NextProtos).
+	ErrNoApplicationProtocol = errors.New("quic: no application protocol")
+	// ErrTooManyOpenStreams is matched by the error returned by OpenStream and OpenUniStream (using errors.Is)
+	// if the peer's stream limit was reached.
+	ErrTooManyOpenStreams = errors.New("too many open streams")
+)
+
+// A VersionNegotiationError occurs when a client can't negotiate a QUIC version with the server,
+// either because they don't have a version in common, or because the VersionNegotiationCallback aborted the connection attempt.
+// It is returned by Dial, wrapped in a ConnectionError.
+type VersionNegotiationError struct {
+	// Ours are the versions supported by the client.
+	Ours []VersionNumber
+	// Theirs are the versions supported by the server, as sent in the Version Negotiation packet.
+	Theirs []VersionNumber
+}
+
+var _ error = &VersionNegotiationError{}
+
+func (e *VersionNegotiationError) Error() string {
+	return fmt.Sprintf("quic: no compatible QUIC version found (we support %s, server supports %s)", e.Ours, e.Theirs)
+}
+
+// A ConnectionError is the error that terminated a session.
+// After the session was closed, it is returned by all operations on the session and its streams,
+// and it is the cause of the session's context (see context.Cause).
+// It might be wrapped, and should be extracted using errors.As.
+type ConnectionError struct {
+	// Remote says if the session was closed by the peer,
+	// either by sending a CONNECTION_CLOSE frame or by a stateless reset.
+	Remote bool
+	// IsApplicationError says if the session was closed with an application error, see Session.CloseWithError.
+	IsApplicationError bool
+	// ErrorCode is the application error code for application errors, and the transport error code otherwise.
+	// It is 0 if the session was closed by a stateless reset.
+	ErrorCode uint16
+	// ReasonPhrase is the reason phrase sent in the CONNECTION_CLOSE frame.
+	ReasonPhrase string
+
+	// the error that closed the session
+	err error
+}
+
+var _ error = &ConnectionError{}
+
+func newConnectionError(err error, remote bool) *ConnectionError {
+	switch e := err.(type) {
+	case *StatelessResetError:
+		return &ConnectionError{Remote: true, err: e}
+	case *VersionNegotiationError:
+		return &ConnectionError{ErrorCode: uint16(qerr.InvalidVersion), err: e}
+	}
+	quicErr := qerr.ToQuicError(err)
+	return &ConnectionError{
+		Remote:             remote,
+		IsApplicationError: quicErr.IsApplicationError(),
+		ErrorCode:          uint16(quicErr.ErrorCode),
+		ReasonPhrase:       quicErr.ErrorMessage,
+		err:                err,
+	}
+}
+
+func (e *ConnectionError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error that closed the session.
+// For stateless resets, this is the *StatelessResetError.
+func (e *ConnectionError) Unwrap() error {
+	return e.err
+}
+
+// Is allows matching the error against ErrIdleTimeout, ErrHandshakeTimeout and ErrNoApplicationProtocol using errors.Is.
+func (e *ConnectionError) Is(target error) bool {
+	if e.IsApplicationError {
+		return false
+	}
+	switch target {
+	case ErrIdleTimeout:
+		return qerr.ErrorCode(e.ErrorCode) == qerr.NetworkIdleTimeout
+	case ErrHandshakeTimeout:
+		return qerr.ErrorCode(e.ErrorCode) == qerr.HandshakeTimeout
+	case ErrNoApplicationProtocol:
+		return qerr.ErrorCode(e.ErrorCode) == qerr.NoApplicationProtocol
+	}
+	return false
+}
+
+// Timeout says if the session was closed because of a timeout.
+func (e *ConnectionError) Timeout() bool {
+	if e.IsApplicationError {
+		return false
+	}
+	return qerr.Error(qerr.ErrorCode(e.ErrorCode), "").Timeout()
+}
+
+// IsApplicationError says if the session was closed with an application error.
+func IsApplicationError(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr) && connErr.IsApplicationError
+}
+
+// IsRemoteClose says if the session was closed by the peer.
+func IsRemoteClose(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr) && connErr.Remote
+}
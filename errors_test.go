@@ -0,0 +1,101 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection Errors", func() {
+	It("converts transport errors", func() {
+		err := newConnectionError(qerr.Error(qerr.InvalidFrameData, "foobar"), true)
+		Expect(err.Remote).To(BeTrue())
+		Expect(err.IsApplicationError).To(BeFalse())
+		Expect(qerr.ErrorCode(err.ErrorCode)).To(Equal(qerr.InvalidFrameData))
+		Expect(err.ReasonPhrase).To(Equal("foobar"))
+		Expect(err).To(MatchError(qerr.Error(qerr.InvalidFrameData, "foobar").Error()))
+	})
+
+	It("converts application errors", func() {
+		err := newConnectionError(qerr.ApplicationError(0x1337, "foobar"), false)
+		Expect(err.Remote).To(BeFalse())
+		Expect(err.IsApplicationError).To(BeTrue())
+		Expect(err.ErrorCode).To(BeEquivalentTo(0x1337))
+		Expect(err.ReasonPhrase).To(Equal("foobar"))
+		Expect(IsApplicationError(err)).To(BeTrue())
+		Expect(IsRemoteClose(err)).To(BeFalse())
+	})
+
+	It("keeps the original error", func() {
+		testErr := errors.New("test error")
+		err := newConnectionError(testErr, false)
+		Expect(qerr.ErrorCode(err.ErrorCode)).To(Equal(qerr.InternalError))
+		Expect(err).To(MatchError("test error"))
+		Expect(errors.Is(err, testErr)).To(BeTrue())
+	})
+
+	It("converts stateless resets", func() {
+		err := newConnectionError(&StatelessResetError{Token: [16]byte{1, 2, 3}}, false)
+		Expect(err.Remote).To(BeTrue())
+		Expect(err.IsApplicationError).To(BeFalse())
+		Expect(err.ErrorCode).To(BeZero())
+		var resetErr *StatelessResetError
+		Expect(errors.As(err, &resetErr)).To(BeTrue())
+		Expect(resetErr.Token).To(Equal([16]byte{1, 2, 3}))
+		Expect(IsRemoteClose(err)).To(BeTrue())
+	})
+
+	It("converts version negotiation errors", func() {
+		vnErr := &VersionNegotiationError{
+			Ours:   []VersionNumber{protocol.VersionTLS},
+			Theirs: []VersionNumber{0x1337},
+		}
+		err := newConnectionError(vnErr, false)
+		Expect(err.Remote).To(BeFalse())
+		Expect(qerr.ErrorCode(err.ErrorCode)).To(Equal(qerr.InvalidVersion))
+		Expect(err).To(MatchError(vnErr.Error()))
+		var e *VersionNegotiationError
+		Expect(errors.As(err, &e)).To(BeTrue())
+		Expect(e).To(Equal(vnErr))
+	})
+
+	It("matches timeouts", func() {
+		idleErr := newConnectionError(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."), false)
+		Expect(errors.Is(idleErr, ErrIdleTimeout)).To(BeTrue())
+		Expect(errors.Is(idleErr, ErrHandshakeTimeout)).To(BeFalse())
+		Expect(idleErr.Timeout()).To(BeTrue())
+		handshakeErr := newConnectionError(qerr.Error(qerr.HandshakeTimeout, "Crypto handshake did not complete in time."), false)
+		Expect(errors.Is(handshakeErr, ErrHandshakeTimeout)).To(BeTrue())
+		Expect(errors.Is(handshakeErr, ErrIdleTimeout)).To(BeFalse())
+		Expect(handshakeErr.Timeout()).To(BeTrue())
+	})
+
+	It("matches ALPN failures", func() {
+		err := newConnectionError(qerr.Error(qerr.NoApplicationProtocol, "no application protocol in common"), true)
+		Expect(errors.Is(err, ErrNoApplicationProtocol)).To(BeTrue())
+		Expect(errors.Is(err, ErrHandshakeTimeout)).To(BeFalse())
+		Expect(err.Timeout()).To(BeFalse())
+	})
+
+	It("doesn't match timeouts for application errors", func() {
+		err := newConnectionError(qerr.ApplicationError(qerr.NetworkIdleTimeout, "foobar"), false)
+		Expect(errors.Is(err, ErrIdleTimeout)).To(BeFalse())
+		Expect(err.Timeout()).To(BeFalse())
+	})
+
+	It("can be extracted from wrapped errors", func() {
+		err := fmt.Errorf("read failed: %w", newConnectionError(qerr.ApplicationError(0x42, "foobar"), true))
+		var connErr *ConnectionError
+		Expect(errors.As(err, &connErr)).To(BeTrue())
+		Expect(connErr.ErrorCode).To(BeEquivalentTo(0x42))
+		Expect(IsApplicationError(err)).To(BeTrue())
+		Expect(IsRemoteClose(err)).To(BeTrue())
+		Expect(IsApplicationError(errors.New("foobar"))).To(BeFalse())
+		Expect(IsRemoteClose(errors.New("foobar"))).To(BeFalse())
+	})
+})
@@ -7,10 +7,18 @@ import (
 	"github.com/lucas-clemente/quic-go/internal/utils"
 )
 
+type frameSorterEntry struct {
+	Data []byte
+	// the packet buffer that Data is a slice of, nil if Data is not backed by a packet buffer
+	Buffer *packetBuffer
+}
+
 type frameSorter struct {
-	queue   map[protocol.ByteCount][]byte
+	queue   map[protocol.ByteCount]frameSorterEntry
 	readPos protocol.ByteCount
 	gaps    *utils.ByteIntervalList
+	// the number of bytes queued, i.e. received, but not popped yet
+	bufferedBytes protocol.ByteCount
 }
 
 var errDuplicateStreamData = errors.New("Duplicate Stream Data")
@@ -18,32 +26,36 @@ var errDuplicateStreamData = errors.New("Duplicate Stream Data")
 func newFrameSorter() *frameSorter {
 	s := frameSorter{
 		gaps:  utils.NewByteIntervalList(),
-		queue: make(map[protocol.ByteCount][]byte),
+		queue: make(map[protocol.ByteCount]frameSorterEntry),
 	}
 	s.gaps.PushFront(utils.ByteInterval{Start: 0, End: protocol.MaxByteCount})
 	return &s
 }
 
-func (s *frameSorter) Push(data []byte, offset protocol.ByteCount) error {
-	err := s.push(data, offset)
+// Push queues data received at offset.
+// If data is a slice of a packet buffer, buffer must be set.
+// The packet buffer is retained as long as the data is queued.
+// buffer may be nil.
+func (s *frameSorter) Push(data []byte, offset protocol.ByteCount, buffer *packetBuffer) error {
+	err := s.push(data, offset, buffer)
 	if err == errDuplicateStreamData {
 		return nil
 	}
 	return err
 }
 
-func (s *frameSorter) push(data []byte, offset protocol.ByteCount) error {
+func (s *frameSorter) push(data []byte, offset protocol.ByteCount, buffer *packetBuffer) error {
 	if len(data) == 0 {
 		return nil
 	}
 
 	var wasCut bool
-	if oldData, ok := s.queue[offset]; ok {
-		if len(data) <= len(oldData) {
+	if oldEntry, ok := s.queue[offset]; ok {
+		if len(data) <= len(oldEntry.Data) {
 			return errDuplicateStreamData
 		}
-		data = data[len(oldData):]
-		offset += protocol.ByteCount(len(oldData))
+		data = data[len(oldEntry.Data):]
+		offset += protocol.ByteCount(len(oldEntry.Data))
 		wasCut = true
 	}
 
@@ -88,7 +100,7 @@ func (s *frameSorter) push(data []byte, offset protocol.ByteCount) error {
 			break
 		}
 		// delete queued frames completely covered by the current frame
-		delete(s.queue, endGap.Value.End)
+		s.deleteConsecutive(endGap.Value.End, nextEndGap.Value.Start)
 		endGap = nextEndGap
 	}
 
@@ -132,25 +144,97 @@ func (s *frameSorter) push(data []byte, offset protocol.ByteCount) error {
 		return errors.New("Too many gaps in received data")
 	}
 
-	if wasCut {
+	// Frames that were cut are copied, such that the packet buffer doesn't need to be retained.
+	// This only happens for overlapping retransmissions.
+	// Small frames are copied as well, since retaining the packet buffer would use a lot more memory than the data itself.
+	if wasCut || (buffer != nil && len(data) < protocol.MinStreamFrameBufferSize) {
 		newData := make([]byte, len(data))
 		copy(newData, data)
 		data = newData
+		buffer = nil
+	}
+	if buffer != nil {
+		buffer.Split()
 	}
 
-	s.queue[offset] = data
+	s.queue[offset] = frameSorterEntry{Data: data, Buffer: buffer}
+	s.bufferedBytes += protocol.ByteCount(len(data))
 	return nil
 }
 
-func (s *frameSorter) Pop() (protocol.ByteCount, []byte) {
-	data, ok := s.queue[s.readPos]
+// deleteConsecutive deletes the queued frames between two gaps.
+// These frames are consecutive, so each frame starts where the previous one ended.
+func (s *frameSorter) deleteConsecutive(start, end protocol.ByteCount) {
+	for pos := start; pos < end; {
+		entry, ok := s.queue[pos]
+		if !ok {
+			return
+		}
+		delete(s.queue, pos)
+		if entry.Buffer != nil {
+			entry.Buffer.Release()
+		}
+		s.bufferedBytes -= protocol.ByteCount(len(entry.Data))
+		pos += protocol.ByteCount(len(entry.Data))
+	}
+}
+
+// Pop returns the next data that can be read.
+// If the data is a slice of a packet buffer, the packet buffer is returned as well.
+// The caller must release it when it is done using the data.
+func (s *frameSorter) Pop() (protocol.ByteCount, []byte, *packetBuffer) {
+	entry, ok := s.queue[s.readPos]
 	if !ok {
-		return s.readPos, nil
+		return s.readPos, nil, nil
 	}
 	delete(s.queue, s.readPos)
 	offset := s.readPos
-	s.readPos += protocol.ByteCount(len(data))
-	return offset, data
+	s.readPos += protocol.ByteCount(len(entry.Data))
+	s.bufferedBytes -= protocol.ByteCount(len(entry.Data))
+	return offset, entry.Data, entry.Buffer
+}
+
+// Discard deletes all queued data.
+// It is used when the data won't be read any more.
+func (s *frameSorter) Discard() {
+	for offset, entry := range s.queue {
+		delete(s.queue, offset)
+		if entry.Buffer != nil {
+			entry.Buffer.Release()
+		}
+	}
+	s.bufferedBytes = 0
+	s.readPos = s.gaps.Front().Value.Start
+}
+
+// BufferedBytes returns the number of bytes queued.
+func (s *frameSorter) BufferedBytes() protocol.ByteCount {
+	return s.bufferedBytes
+}
+
+// OutOfOrderBytes returns the number of bytes queued after the first gap.
+// This data can't be popped before the gap is filled.
+func (s *frameSorter) OutOfOrderBytes() protocol.ByteCount {
+	return s.bufferedBytes - (s.gaps.Front().Value.Start - s.readPos)
+}
+
+// NewOutOfOrderBytes returns the number of bytes that pushing data at offset would add after the first gap.
+// Bytes that were already received are not counted.
+// Data that starts at (or before) the first gap is never out of order,
+// since it either fills the gap, or was already received.
+func (s *frameSorter) NewOutOfOrderBytes(data []byte, offset protocol.ByteCount) protocol.ByteCount {
+	if offset <= s.gaps.Front().Value.Start {
+		return 0
+	}
+	end := offset + protocol.ByteCount(len(data))
+	var n protocol.ByteCount
+	for gap := s.gaps.Front(); gap != nil && gap.Value.Start < end; gap = gap.Next() {
+		if gap.Value.End <= offset {
+			continue
+		}
+		n += utils.MinByteCount(end, gap.Value.End) - utils.MaxByteCount(offset, gap.Value.Start)
+	}
+	return n
 }
 
 // HasMoreData says if there is any more data queued at *any* offset.
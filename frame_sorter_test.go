@@ -26,53 +26,53 @@ var _ = Describe("STREAM frame sorter", func() {
 	})
 
 	It("returns nil when empty", func() {
-		_, data := s.Pop()
+		_, data, _ := s.Pop()
 		Expect(data).To(BeNil())
 	})
 
 	Context("Push", func() {
 		It("inserts and pops a single frame", func() {
-			Expect(s.Push([]byte("foobar"), 0)).To(Succeed())
-			offset, data := s.Pop()
+			Expect(s.Push([]byte("foobar"), 0, nil)).To(Succeed())
+			offset, data, _ := s.Pop()
 			Expect(offset).To(BeZero())
 			Expect(data).To(Equal([]byte("foobar")))
-			offset, data = s.Pop()
+			offset, data, _ = s.Pop()
 			Expect(offset).To(Equal(protocol.ByteCount(6)))
 			Expect(data).To(BeNil())
 		})
 
 		It("inserts and pops two consecutive frame", func() {
-			Expect(s.Push([]byte("foo"), 0)).To(Succeed())
-			Expect(s.Push([]byte("bar"), 3)).To(Succeed())
-			offset, data := s.Pop()
+			Expect(s.Push([]byte("foo"), 0, nil)).To(Succeed())
+			Expect(s.Push([]byte("bar"), 3, nil)).To(Succeed())
+			offset, data, _ := s.Pop()
 			Expect(offset).To(BeZero())
 			Expect(data).To(Equal([]byte("foo")))
-			offset, data = s.Pop()
+			offset, data, _ = s.Pop()
 			Expect(offset).To(Equal(protocol.ByteCount(3)))
 			Expect(data).To(Equal([]byte("bar")))
-			offset, data = s.Pop()
+			offset, data, _ = s.Pop()
 			Expect(offset).To(Equal(protocol.ByteCount(6)))
 			Expect(data).To(BeNil())
 		})
 
 		It("ignores empty frames", func() {
-			Expect(s.Push(nil, 0)).To(Succeed())
-			_, data := s.Pop()
+			Expect(s.Push(nil, 0, nil)).To(Succeed())
+			_, data, _ := s.Pop()
 			Expect(data).To(BeNil())
 		})
 
 		It("says if has more data", func() {
 			Expect(s.HasMoreData()).To(BeFalse())
-			Expect(s.Push([]byte("foo"), 0)).To(Succeed())
+			Expect(s.Push([]byte("foo"), 0, nil)).To(Succeed())
 			Expect(s.HasMoreData()).To(BeTrue())
-			_, data := s.Pop()
+			_, data, _ := s.Pop()
 			Expect(data).To(Equal([]byte("foo")))
 			Expect(s.HasMoreData()).To(BeFalse())
 		})
 
 		Context("Gap handling", func() {
 			It("finds the first gap", func() {
-				Expect(s.Push([]byte("foobar"), 10)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 10, nil)).To(Succeed())
 				checkGaps([]utils.ByteInterval{
 					{Start: 0, End: 10},
 					{Start: 16, End: protocol.MaxByteCount},
@@ -80,15 +80,15 @@ var _ = Describe("STREAM frame sorter", func() {
 			})
 
 			It("correctly sets the first gap for a frame with offset 0", func() {
-				Expect(s.Push([]byte("foobar"), 0)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 0, nil)).To(Succeed())
 				checkGaps([]utils.ByteInterval{
 					{Start: 6, End: protocol.MaxByteCount},
 				})
 			})
 
 			It("finds the two gaps", func() {
-				Expect(s.Push([]byte("foobar"), 10)).To(Succeed())
-				Expect(s.Push([]byte("foobar"), 20)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 10, nil)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 20, nil)).To(Succeed())
 				checkGaps([]utils.ByteInterval{
 					{Start: 0, End: 10},
 					{Start: 16, End: 20},
@@ -97,8 +97,8 @@ var _ = Describe("STREAM frame sorter", func() {
 			})
 
 			It("finds the two gaps in reverse order", func() {
-				Expect(s.Push([]byte("foobar"), 20)).To(Succeed())
-				Expect(s.Push([]byte("foobar"), 10)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 20, nil)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 10, nil)).To(Succeed())
 				checkGaps([]utils.ByteInterval{
 					{Start: 0, End: 10},
 					{Start: 16, End: 20},
@@ -107,8 +107,8 @@ var _ = Describe("STREAM frame sorter", func() {
 			})
 
 			It("shrinks a gap when it is partially filled", func() {
-				Expect(s.Push([]byte("test"), 10)).To(Succeed())
-				Expect(s.Push([]byte("foobar"), 4)).To(Succeed())
+				Expect(s.Push([]byte("test"), 10, nil)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 4, nil)).To(Succeed())
 				checkGaps([]utils.ByteInterval{
 					{Start: 0, End: 4},
 					{Start: 14, End: protocol.MaxByteCount},
@@ -116,17 +116,17 @@ var _ = Describe("STREAM frame sorter", func() {
 			})
 
 			It("deletes a gap at the beginning, when it is filled", func() {
-				Expect(s.Push([]byte("test"), 6)).To(Succeed())
-				Expect(s.Push([]byte("foobar"), 0)).To(Succeed())
+				Expect(s.Push([]byte("test"), 6, nil)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 0, nil)).To(Succeed())
 				checkGaps([]utils.ByteInterval{
 					{Start: 10, End: protocol.MaxByteCount},
 				})
 			})
 
 			It("deletes a gap in the middle, when it is filled", func() {
-				Expect(s.Push([]byte("test"), 0)).To(Succeed())
-				Expect(s.Push([]byte("test2"), 10)).To(Succeed())
-				Expect(s.Push([]byte("foobar"), 4)).To(Succeed())
+				Expect(s.Push([]byte("test"), 0, nil)).To(Succeed())
+				Expect(s.Push([]byte("test2"), 10, nil)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 4, nil)).To(Succeed())
 				Expect(s.queue).To(HaveLen(3))
 				checkGaps([]utils.ByteInterval{
 					{Start: 15, End: protocol.MaxByteCount},
@@ -134,8 +134,8 @@ var _ = Describe("STREAM frame sorter", func() {
 			})
 
 			It("splits a gap into two", func() {
-				Expect(s.Push([]byte("test"), 100)).To(Succeed())
-				Expect(s.Push([]byte("foobar"), 50)).To(Succeed())
+				Expect(s.Push([]byte("test"), 100, nil)).To(Succeed())
+				Expect(s.Push([]byte("foobar"), 50, nil)).To(Succeed())
 				Expect(s.queue).To(HaveLen(2))
 				checkGaps([]utils.ByteInterval{
 					{Start: 0, End: 50},
@@ -147,9 +147,9 @@ var _ = Describe("STREAM frame sorter", func() {
 			Context("Overlapping Stream Data detection", func() {
 				// create gaps: 0-5, 10-15, 20-25, 30-inf
 				BeforeEach(func() {
-					Expect(s.Push([]byte("12345"), 5)).To(Succeed())
-					Expect(s.Push([]byte("12345"), 15)).To(Succeed())
-					Expect(s.Push([]byte("12345"), 25)).To(Succeed())
+					Expect(s.Push([]byte("12345"), 5, nil)).To(Succeed())
+					Expect(s.Push([]byte("12345"), 15, nil)).To(Succeed())
+					Expect(s.Push([]byte("12345"), 25, nil)).To(Succeed())
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
 						{Start: 10, End: 15},
@@ -159,10 +159,10 @@ var _ = Describe("STREAM frame sorter", func() {
 				})
 
 				It("cuts a frame with offset 0 that overlaps at the end", func() {
-					Expect(s.Push([]byte("foobar"), 0)).To(Succeed())
+					Expect(s.Push([]byte("foobar"), 0, nil)).To(Succeed())
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(0)))
-					Expect(s.queue[0]).To(Equal([]byte("fooba")))
-					Expect(s.queue[0]).To(HaveCap(5))
+					Expect(s.queue[0].Data).To(Equal([]byte("fooba")))
+					Expect(s.queue[0].Data).To(HaveCap(5))
 					checkGaps([]utils.ByteInterval{
 						{Start: 10, End: 15},
 						{Start: 20, End: 25},
@@ -172,10 +172,10 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("cuts a frame that overlaps at the end", func() {
 					// 4 to 7
-					Expect(s.Push([]byte("foo"), 4)).To(Succeed())
+					Expect(s.Push([]byte("foo"), 4, nil)).To(Succeed())
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(4)))
-					Expect(s.queue[4]).To(Equal([]byte("f")))
-					Expect(s.queue[4]).To(HaveCap(1))
+					Expect(s.queue[4].Data).To(Equal([]byte("f")))
+					Expect(s.queue[4].Data).To(HaveCap(1))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 4},
 						{Start: 10, End: 15},
@@ -186,10 +186,10 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("cuts a frame that completely fills a gap, but overlaps at the end", func() {
 					// 10 to 16
-					Expect(s.Push([]byte("foobar"), 10)).To(Succeed())
+					Expect(s.Push([]byte("foobar"), 10, nil)).To(Succeed())
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(10)))
-					Expect(s.queue[10]).To(Equal([]byte("fooba")))
-					Expect(s.queue[10]).To(HaveCap(5))
+					Expect(s.queue[10].Data).To(Equal([]byte("fooba")))
+					Expect(s.queue[10].Data).To(HaveCap(5))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
 						{Start: 20, End: 25},
@@ -199,11 +199,11 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("cuts a frame that overlaps at the beginning", func() {
 					// 8 to 14
-					Expect(s.Push([]byte("foobar"), 8)).To(Succeed())
+					Expect(s.Push([]byte("foobar"), 8, nil)).To(Succeed())
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(8)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(10)))
-					Expect(s.queue[10]).To(Equal([]byte("obar")))
-					Expect(s.queue[10]).To(HaveCap(4))
+					Expect(s.queue[10].Data).To(Equal([]byte("obar")))
+					Expect(s.queue[10].Data).To(HaveCap(4))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
 						{Start: 14, End: 15},
@@ -214,10 +214,10 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("processes a frame that overlaps at the beginning and at the end, starting in a gap", func() {
 					// 2 to 12
-					Expect(s.Push([]byte("1234567890"), 2)).To(Succeed())
+					Expect(s.Push([]byte("1234567890"), 2, nil)).To(Succeed())
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(5)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(2)))
-					Expect(s.queue[2]).To(Equal([]byte("1234567890")))
+					Expect(s.queue[2].Data).To(Equal([]byte("1234567890")))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 2},
 						{Start: 12, End: 15},
@@ -228,11 +228,11 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("processes a frame that overlaps at the beginning and at the end, starting in a gap, ending in data", func() {
 					// 2 to 17
-					Expect(s.Push([]byte("123456789012345"), 2)).To(Succeed())
+					Expect(s.Push([]byte("123456789012345"), 2, nil)).To(Succeed())
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(5)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(2)))
-					Expect(s.queue[2]).To(Equal([]byte("1234567890123")))
-					Expect(s.queue[2]).To(HaveCap(13))
+					Expect(s.queue[2].Data).To(Equal([]byte("1234567890123")))
+					Expect(s.queue[2].Data).To(HaveCap(13))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 2},
 						{Start: 20, End: 25},
@@ -242,10 +242,10 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("processes a frame that overlaps at the beginning and at the end, starting in a gap, ending in data", func() {
 					// 5 to 22
-					Expect(s.Push([]byte("12345678901234567"), 5)).To(Succeed())
+					Expect(s.Push([]byte("12345678901234567"), 5, nil)).To(Succeed())
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(5)))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(15)))
-					Expect(s.queue[10]).To(Equal([]byte("678901234567")))
+					Expect(s.queue[10].Data).To(Equal([]byte("678901234567")))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
 						{Start: 22, End: 25},
@@ -255,13 +255,13 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("processes a frame that closes multiple gaps", func() {
 					// 2 to 27
-					Expect(s.Push(bytes.Repeat([]byte{'e'}, 25), 2)).To(Succeed())
+					Expect(s.Push(bytes.Repeat([]byte{'e'}, 25), 2, nil)).To(Succeed())
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(5)))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(15)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(25)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(2)))
-					Expect(s.queue[2]).To(Equal(bytes.Repeat([]byte{'e'}, 23)))
-					Expect(s.queue[2]).To(HaveCap(23))
+					Expect(s.queue[2].Data).To(Equal(bytes.Repeat([]byte{'e'}, 23)))
+					Expect(s.queue[2].Data).To(HaveCap(23))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 2},
 						{Start: 30, End: protocol.MaxByteCount},
@@ -270,13 +270,13 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("processes a frame that closes multiple gaps", func() {
 					// 5 to 27
-					Expect(s.Push(bytes.Repeat([]byte{'d'}, 22), 5)).To(Succeed())
+					Expect(s.Push(bytes.Repeat([]byte{'d'}, 22), 5, nil)).To(Succeed())
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(5)))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(15)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(25)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(10)))
-					Expect(s.queue[10]).To(Equal(bytes.Repeat([]byte{'d'}, 15)))
-					Expect(s.queue[10]).To(HaveCap(15))
+					Expect(s.queue[10].Data).To(Equal(bytes.Repeat([]byte{'d'}, 15)))
+					Expect(s.queue[10].Data).To(HaveCap(15))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
 						{Start: 30, End: protocol.MaxByteCount},
@@ -286,11 +286,11 @@ var _ = Describe("STREAM frame sorter", func() {
 				It("processes a frame that covers multiple gaps and ends at the end of a gap", func() {
 					data := bytes.Repeat([]byte{'e'}, 14)
 					// 1 to 15
-					Expect(s.Push(data, 1)).To(Succeed())
+					Expect(s.Push(data, 1, nil)).To(Succeed())
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(1)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(15)))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(5)))
-					Expect(s.queue[1]).To(Equal(data))
+					Expect(s.queue[1].Data).To(Equal(data))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 1},
 						{Start: 20, End: 25},
@@ -301,10 +301,10 @@ var _ = Describe("STREAM frame sorter", func() {
 				It("processes a frame that closes all gaps (except for the last one)", func() {
 					data := bytes.Repeat([]byte{'f'}, 32)
 					// 0 to 32
-					Expect(s.Push(data, 0)).To(Succeed())
+					Expect(s.Push(data, 0, nil)).To(Succeed())
 					Expect(s.queue).To(HaveLen(1))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(0)))
-					Expect(s.queue[0]).To(Equal(data))
+					Expect(s.queue[0].Data).To(Equal(data))
 					checkGaps([]utils.ByteInterval{
 						{Start: 32, End: protocol.MaxByteCount},
 					})
@@ -312,11 +312,11 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("cuts a frame that overlaps at the beginning and at the end, starting in data already received", func() {
 					// 8 to 17
-					Expect(s.Push([]byte("123456789"), 8)).To(Succeed())
+					Expect(s.Push([]byte("123456789"), 8, nil)).To(Succeed())
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(8)))
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(10)))
-					Expect(s.queue[10]).To(Equal([]byte("34567")))
-					Expect(s.queue[10]).To(HaveCap(5))
+					Expect(s.queue[10].Data).To(Equal([]byte("34567")))
+					Expect(s.queue[10].Data).To(HaveCap(5))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
 						{Start: 20, End: 25},
@@ -326,10 +326,10 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				It("cuts a frame that completely covers two gaps", func() {
 					// 10 to 20
-					Expect(s.Push([]byte("1234567890"), 10)).To(Succeed())
+					Expect(s.Push([]byte("1234567890"), 10, nil)).To(Succeed())
 					Expect(s.queue).To(HaveKey(protocol.ByteCount(10)))
-					Expect(s.queue[10]).To(Equal([]byte("12345")))
-					Expect(s.queue[10]).To(HaveCap(5))
+					Expect(s.queue[10].Data).To(Equal([]byte("12345")))
+					Expect(s.queue[10].Data).To(HaveCap(5))
 					checkGaps([]utils.ByteInterval{
 						{Start: 0, End: 5},
 						{Start: 20, End: 25},
@@ -346,8 +346,8 @@ var _ = Describe("STREAM frame sorter", func() {
 
 				BeforeEach(func() {
 					// create gaps: 5-10, 15-inf
-					Expect(s.Push([]byte("12345"), 0)).To(Succeed())
-					Expect(s.Push([]byte("12345"), 10)).To(Succeed())
+					Expect(s.Push([]byte("12345"), 0, nil)).To(Succeed())
+					Expect(s.Push([]byte("12345"), 10, nil)).To(Succeed())
 					checkGaps(expectedGaps)
 				})
 
@@ -357,47 +357,47 @@ var _ = Describe("STREAM frame sorter", func() {
 				})
 
 				It("does not modify data when receiving a duplicate", func() {
-					err := s.push([]byte("fffff"), 0)
+					err := s.push([]byte("fffff"), 0, nil)
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queue[0]).ToNot(Equal([]byte("fffff")))
+					Expect(s.queue[0].Data).ToNot(Equal([]byte("fffff")))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, starting at the beginning", func() {
 					// 10 to 12
-					err := s.push([]byte("12"), 10)
+					err := s.push([]byte("12"), 10, nil)
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queue[10]).To(HaveLen(5))
+					Expect(s.queue[10].Data).To(HaveLen(5))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, somewhere in the middle", func() {
 					// 1 to 4
-					err := s.push([]byte("123"), 1)
+					err := s.push([]byte("123"), 1, nil)
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queue[0]).To(HaveLen(5))
+					Expect(s.queue[0].Data).To(HaveLen(5))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(1)))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, somewhere in the middle in the last block", func() {
 					// 11 to 14
-					err := s.push([]byte("123"), 11)
+					err := s.push([]byte("123"), 11, nil)
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queue[10]).To(HaveLen(5))
+					Expect(s.queue[10].Data).To(HaveLen(5))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(11)))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, with aligned end in the last block", func() {
 					// 11 to 15
-					err := s.push([]byte("1234"), 1)
+					err := s.push([]byte("1234"), 1, nil)
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queue[10]).To(HaveLen(5))
+					Expect(s.queue[10].Data).To(HaveLen(5))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(11)))
 				})
 
 				It("detects a duplicate frame that is smaller than the original, with aligned end", func() {
 					// 3 to 5
-					err := s.push([]byte("12"), 3)
+					err := s.push([]byte("12"), 3, nil)
 					Expect(err).To(MatchError(errDuplicateStreamData))
-					Expect(s.queue[0]).To(HaveLen(5))
+					Expect(s.queue[0].Data).To(HaveLen(5))
 					Expect(s.queue).ToNot(HaveKey(protocol.ByteCount(3)))
 				})
 			})
@@ -405,13 +405,135 @@ var _ = Describe("STREAM frame sorter", func() {
 			Context("DoS protection", func() {
 				It("errors when too many gaps are created", func() {
 					for i := 0; i < protocol.MaxStreamFrameSorterGaps; i++ {
-						Expect(s.Push([]byte("foobar"), protocol.ByteCount(i*7))).To(Succeed())
+						Expect(s.Push([]byte("foobar"), protocol.ByteCount(i*7), nil)).To(Succeed())
 					}
 					Expect(s.gaps.Len()).To(Equal(protocol.MaxStreamFrameSorterGaps))
-					err := s.Push([]byte("foobar"), protocol.ByteCount(protocol.MaxStreamFrameSorterGaps*7)+100)
+					err := s.Push([]byte("foobar"), protocol.ByteCount(protocol.MaxStreamFrameSorterGaps*7)+100, nil)
 					Expect(err).To(MatchError("Too many gaps in received data"))
 				})
 			})
 		})
 	})
+
+	Context("buffered data", func() {
+		It("counts the buffered data", func() {
+			Expect(s.Push([]byte("foo"), 0, nil)).To(Succeed())
+			Expect(s.Push([]byte("bar"), 3, nil)).To(Succeed())
+			Expect(s.BufferedBytes()).To(Equal(protocol.ByteCount(6)))
+			Expect(s.OutOfOrderBytes()).To(BeZero())
+			_, data, _ := s.Pop()
+			Expect(data).To(Equal([]byte("foo")))
+			Expect(s.BufferedBytes()).To(Equal(protocol.ByteCount(3)))
+		})
+
+		It("counts the out-of-order data", func() {
+			Expect(s.Push([]byte("foo"), 0, nil)).To(Succeed())
+			Expect(s.Push([]byte("bar"), 10, nil)).To(Succeed())
+			Expect(s.Push([]byte("baz"), 20, nil)).To(Succeed())
+			Expect(s.BufferedBytes()).To(Equal(protocol.ByteCount(9)))
+			Expect(s.OutOfOrderBytes()).To(Equal(protocol.ByteCount(6)))
+			// popping in-order data doesn't change the amount of out-of-order data
+			s.Pop()
+			Expect(s.OutOfOrderBytes()).To(Equal(protocol.ByteCount(6)))
+			// filling the first gap makes the data up to the next gap readable
+			Expect(s.Push([]byte("1234567"), 3, nil)).To(Succeed())
+			Expect(s.BufferedBytes()).To(Equal(protocol.ByteCount(13)))
+			Expect(s.OutOfOrderBytes()).To(Equal(protocol.ByteCount(3)))
+		})
+
+		It("doesn't double count overlapping data", func() {
+			Expect(s.Push([]byte("foobar"), 10, nil)).To(Succeed())
+			Expect(s.Push([]byte("foobar"), 10, nil)).To(Succeed())
+			Expect(s.BufferedBytes()).To(Equal(protocol.ByteCount(6)))
+			Expect(s.Push([]byte("xxfoo"), 8, nil)).To(Succeed())
+			Expect(s.Push([]byte("barxx"), 13, nil)).To(Succeed())
+			Expect(s.BufferedBytes()).To(Equal(protocol.ByteCount(10)))
+			// this frame covers all frames received so far
+			Expect(s.Push(bytes.Repeat([]byte{'a'}, 20), 5, nil)).To(Succeed())
+			Expect(s.queue).To(HaveLen(1))
+			Expect(s.BufferedBytes()).To(Equal(protocol.ByteCount(20)))
+			Expect(s.OutOfOrderBytes()).To(Equal(protocol.ByteCount(20)))
+		})
+
+		It("calculates how much out-of-order data a frame would add", func() {
+			Expect(s.Push([]byte("foo"), 0, nil)).To(Succeed())
+			Expect(s.Push([]byte("bar"), 10, nil)).To(Succeed())
+			// starting at the first gap
+			Expect(s.NewOutOfOrderBytes([]byte("foobar"), 3)).To(BeZero())
+			// starting before the first gap
+			Expect(s.NewOutOfOrderBytes([]byte("foobar"), 1)).To(BeZero())
+			// within the first gap
+			Expect(s.NewOutOfOrderBytes([]byte("foo"), 5)).To(Equal(protocol.ByteCount(3)))
+			// overlapping with data already received
+			Expect(s.NewOutOfOrderBytes([]byte("foobar"), 8)).To(Equal(protocol.ByteCount(3)))
+			Expect(s.NewOutOfOrderBytes([]byte("foobar"), 11)).To(Equal(protocol.ByteCount(4)))
+			Expect(s.NewOutOfOrderBytes([]byte("foo"), 10)).To(BeZero())
+			// spanning multiple gaps
+			Expect(s.NewOutOfOrderBytes(bytes.Repeat([]byte{'a'}, 20), 5)).To(Equal(protocol.ByteCount(17)))
+		})
+	})
+
+	Context("retaining packet buffers", func() {
+		var buf *packetBuffer
+
+		BeforeEach(func() {
+			buf = getPacketBuffer()
+			copy(buf.Slice, "foobar")
+		})
+
+		It("retains the packet buffer, and returns it when popping the data", func() {
+			Expect(s.Push(buf.Slice[:200], 0, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(2))
+			_, data, b := s.Pop()
+			Expect(data).To(HaveLen(200))
+			Expect(data[:6]).To(Equal([]byte("foobar")))
+			Expect(b).To(Equal(buf))
+			Expect(buf.refCount).To(BeEquivalentTo(2))
+		})
+
+		It("copies small frames, and doesn't retain the packet buffer", func() {
+			Expect(s.Push(buf.Slice[:6], 0, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+			buf.Slice[0] = 'x'
+			_, data, b := s.Pop()
+			Expect(data).To(Equal([]byte("foobar")))
+			Expect(b).To(BeNil())
+		})
+
+		It("doesn't retain the packet buffer for duplicate data", func() {
+			Expect(s.Push([]byte("foobar"), 0, nil)).To(Succeed())
+			Expect(s.Push(buf.Slice[:3], 0, buf)).To(Succeed())
+			Expect(s.Push(buf.Slice[3:6], 3, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+		})
+
+		It("copies data that was cut, and doesn't retain the packet buffer", func() {
+			Expect(s.Push([]byte("foo"), 0, nil)).To(Succeed())
+			Expect(s.Push(buf.Slice[:6], 0, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+			buf.Slice[4] = 'x'
+			Expect(s.queue[3].Data).To(Equal([]byte("bar")))
+			Expect(s.queue[3].Buffer).To(BeNil())
+		})
+
+		It("releases the packet buffer when data is deleted, because it is covered by a later frame", func() {
+			Expect(s.Push(buf.Slice[:200], 500, buf)).To(Succeed())
+			Expect(s.Push(buf.Slice[200:400], 700, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(3))
+			Expect(s.Push(bytes.Repeat([]byte{'a'}, 1000), 200, nil)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+			Expect(s.queue).To(HaveLen(1))
+		})
+
+		It("releases all packet buffers when discarding the data", func() {
+			Expect(s.Push(buf.Slice[:200], 0, buf)).To(Succeed())
+			Expect(s.Push(buf.Slice[200:400], 1000, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(3))
+			s.Discard()
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+			Expect(s.HasMoreData()).To(BeFalse())
+			Expect(s.BufferedBytes()).To(BeZero())
+			Expect(s.OutOfOrderBytes()).To(BeZero())
+		})
+	})
 })
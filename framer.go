@@ -12,7 +12,10 @@ type framer interface {
 	AppendControlFrames([]wire.Frame, protocol.ByteCount) ([]wire.Frame, protocol.ByteCount)
 
 	AddActiveStream(protocol.StreamID)
+	StreamPriorityChanged(protocol.StreamID)
 	AppendStreamFrames([]wire.Frame, protocol.ByteCount) []wire.Frame
+
+	HasData() bool
 }
 
 type framerI struct {
@@ -22,7 +25,12 @@ type framerI struct {
 	version      protocol.VersionNumber
 
 	activeStreams map[protocol.StreamID]struct{}
-	streamQueue   []protocol.StreamID
+	// The active streams, ordered by priority.
+	// Streams with the same priority are served round-robin.
+	streamQueue []queuedStream
+	// used by AppendStreamFrames, saved here to avoid allocations
+	servedStreams  []queuedStream
+	blockedStreams []queuedStream
 
 	controlFrameMutex sync.Mutex
 	controlFrames     []wire.Frame
@@ -64,46 +72,133 @@ func (f *framerI) AppendControlFrames(frames []wire.Frame, maxLen protocol.ByteC
 	return frames, length
 }
 
+// HasData says if there are control frames or active streams queued for sending.
+func (f *framerI) HasData() bool {
+	f.controlFrameMutex.Lock()
+	hasControlFrames := len(f.controlFrames) > 0
+	f.controlFrameMutex.Unlock()
+	if hasControlFrames {
+		return true
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.streamQueue) > 0
+}
+
 func (f *framerI) AddActiveStream(id protocol.StreamID) {
 	f.mutex.Lock()
-	if _, ok := f.activeStreams[id]; !ok {
-		f.streamQueue = append(f.streamQueue, id)
-		f.activeStreams[id] = struct{}{}
+	defer f.mutex.Unlock()
+
+	if _, ok := f.activeStreams[id]; ok {
+		return
 	}
-	f.mutex.Unlock()
+	// This should never return an error. Better check it anyway.
+	str, err := f.streamGetter.GetOrOpenSendStream(id)
+	// The stream can be nil if it completed after it said it had data.
+	if str == nil || err != nil {
+		return
+	}
+	f.activeStreams[id] = struct{}{}
+	f.insertIntoQueue(queuedStream{id: id, str: str, priority: str.getPriority()})
+}
+
+// StreamPriorityChanged moves an active stream to its new position in the queue.
+// Within its new priority, it is served after all other streams.
+func (f *framerI) StreamPriorityChanged(id protocol.StreamID) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i, entry := range f.streamQueue {
+		if entry.id != id {
+			continue
+		}
+		priority := entry.str.getPriority()
+		if priority == entry.priority {
+			return
+		}
+		copy(f.streamQueue[i:], f.streamQueue[i+1:])
+		f.streamQueue[len(f.streamQueue)-1] = queuedStream{}
+		f.streamQueue = f.streamQueue[:len(f.streamQueue)-1]
+		entry.priority = priority
+		f.insertIntoQueue(entry)
+		return
+	}
+}
+
+// insertIntoQueue inserts a stream into the queue, after all streams that have the same or a higher priority.
+// It must be called with the mutex held.
+func (f *framerI) insertIntoQueue(entry queuedStream) {
+	i := len(f.streamQueue)
+	for i > 0 && f.streamQueue[i-1].priority < entry.priority {
+		i--
+	}
+	f.streamQueue = append(f.streamQueue, queuedStream{})
+	copy(f.streamQueue[i+1:], f.streamQueue[i:])
+	f.streamQueue[i] = entry
 }
 
 func (f *framerI) AppendStreamFrames(frames []wire.Frame, maxLen protocol.ByteCount) []wire.Frame {
 	var length protocol.ByteCount
 	f.mutex.Lock()
-	// pop STREAM frames, until less than MinStreamFrameSize bytes are left in the packet
-	numActiveStreams := len(f.streamQueue)
-	for i := 0; i < numActiveStreams; i++ {
+	// Pop STREAM frames, until less than MinStreamFrameSize bytes are left in the packet.
+	// The streams are served in rounds: streams that still have data after they were served
+	// are asked again, as long as there's space left in the packet.
+	// This way, small frames from many streams are coalesced into a single packet.
+	queue := f.streamQueue
+	served := f.servedStreams[:0]   // streams served in the current round, that have more data
+	blocked := f.blockedStreams[:0] // streams that have data, but didn't return a frame
+	var popped int
+	for {
+		if popped == len(queue) {
+			if len(served) == 0 {
+				break
+			}
+			// All streams in the queue were popped, so its backing array can be reused for the next round.
+			clear(queue)
+			queue, served, popped = served, queue[:0], 0
+		}
 		if maxLen-length < protocol.MinStreamFrameSize {
 			break
 		}
-		id := f.streamQueue[0]
-		f.streamQueue = f.streamQueue[1:]
-		// This should never return an error. Better check it anyway.
-		// The stream will only be in the streamQueue, if it enqueued itself there.
-		str, err := f.streamGetter.GetOrOpenSendStream(id)
-		// The stream can be nil if it completed after it said it had data.
-		if str == nil || err != nil {
-			delete(f.activeStreams, id)
-			continue
-		}
-		frame, hasMoreData := str.popStreamFrame(maxLen - length)
-		if hasMoreData { // put the stream back in the queue (at the end)
-			f.streamQueue = append(f.streamQueue, id)
-		} else { // no more data to send. Stream is not active any more
-			delete(f.activeStreams, id)
+		entry := queue[popped]
+		popped++
+		frame, hasMoreData := entry.str.popStreamFrame(maxLen - length)
+		if !hasMoreData { // no more data to send. Stream is not active any more
+			delete(f.activeStreams, entry.id)
 		}
 		if frame == nil { // can happen if the receiveStream was canceled after it said it had data
+			if hasMoreData { // e.g. when the stream is blocked by flow control
+				blocked = append(blocked, entry)
+			}
 			continue
 		}
 		frames = append(frames, frame)
 		length += frame.Length(f.version)
+		if hasMoreData {
+			served = append(served, entry)
+		}
+	}
+	// Streams that weren't served in the current round go first,
+	// streams that have more data are put back in the queue (at the end of their priority).
+	n := copy(queue, queue[popped:])
+	clear(queue[n:]) // don't keep references to streams that are not active anymore
+	f.streamQueue = queue[:n]
+	for _, entry := range served {
+		f.insertIntoQueue(entry)
 	}
+	for _, entry := range blocked {
+		f.insertIntoQueue(entry)
+	}
+	clear(served)
+	clear(blocked)
+	f.servedStreams = served[:0]
+	f.blockedStreams = blocked[:0]
 	f.mutex.Unlock()
 	return frames
 }
+
+type queuedStream struct {
+	id       protocol.StreamID
+	str      sendStreamI
+	priority int
+}
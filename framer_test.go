@@ -5,6 +5,8 @@ import (
 
 	"github.com/golang/mock/gomock"
 
+	"github.com/lucas-clemente/quic-go/internal/congestion"
+	"github.com/lucas-clemente/quic-go/internal/mocks"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 	. "github.com/onsi/ginkgo"
@@ -28,8 +30,10 @@ var _ = Describe("Stream Framer", func() {
 		streamGetter = NewMockStreamGetter(mockCtrl)
 		stream1 = NewMockSendStreamI(mockCtrl)
 		stream1.EXPECT().StreamID().Return(protocol.StreamID(5)).AnyTimes()
+		stream1.EXPECT().getPriority().AnyTimes()
 		stream2 = NewMockSendStreamI(mockCtrl)
 		stream2.EXPECT().StreamID().Return(protocol.StreamID(6)).AnyTimes()
+		stream2.EXPECT().getPriority().AnyTimes()
 		framer = newFramer(streamGetter, version)
 	})
 
@@ -71,6 +75,28 @@ var _ = Describe("Stream Framer", func() {
 		})
 	})
 
+	Context("reporting if it has data", func() {
+		It("doesn't have data when it's empty", func() {
+			Expect(framer.HasData()).To(BeFalse())
+		})
+
+		It("has data when a control frame is queued", func() {
+			framer.QueueControlFrame(&wire.PingFrame{})
+			Expect(framer.HasData()).To(BeTrue())
+			framer.AppendControlFrames(nil, 1000)
+			Expect(framer.HasData()).To(BeFalse())
+		})
+
+		It("has data when a stream is active", func() {
+			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil)
+			stream1.EXPECT().popStreamFrame(gomock.Any()).Return(&wire.StreamFrame{Data: []byte("foobar")}, false)
+			framer.AddActiveStream(id1)
+			Expect(framer.HasData()).To(BeTrue())
+			framer.AppendStreamFrames(nil, 1000)
+			Expect(framer.HasData()).To(BeFalse())
+		})
+	})
+
 	Context("popping STREAM frames", func() {
 		It("returns nil when popping an empty framer", func() {
 			Expect(framer.AppendStreamFrames(nil, 1000)).To(BeEmpty())
@@ -116,6 +142,15 @@ var _ = Describe("Stream Framer", func() {
 			Expect(framer.AppendStreamFrames(nil, 1000)).To(Equal([]wire.Frame{f}))
 		})
 
+		It("skips a stream that completed after it was queued", func() {
+			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil)
+			stream1.EXPECT().popStreamFrame(gomock.Any()).Return(nil, false)
+			framer.AddActiveStream(id1)
+			Expect(framer.HasData()).To(BeTrue())
+			Expect(framer.AppendStreamFrames(nil, 1000)).To(BeEmpty())
+			Expect(framer.HasData()).To(BeFalse())
+		})
+
 		It("skips a stream that was reported active, but doesn't have any data", func() {
 			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil)
 			streamGetter.EXPECT().GetOrOpenSendStream(id2).Return(stream2, nil)
@@ -131,7 +166,7 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("pops from a stream multiple times, if it has enough data", func() {
-			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil).Times(2)
+			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil)
 			f1 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
 			f2 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobaz")}
 			stream1.EXPECT().popStreamFrame(gomock.Any()).Return(f1, true)
@@ -144,7 +179,7 @@ var _ = Describe("Stream Framer", func() {
 		})
 
 		It("re-queues a stream at the end, if it has enough data", func() {
-			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil).Times(2)
+			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil)
 			streamGetter.EXPECT().GetOrOpenSendStream(id2).Return(stream2, nil)
 			f11 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
 			f12 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobaz")}
@@ -162,17 +197,75 @@ var _ = Describe("Stream Framer", func() {
 			Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f12}))
 		})
 
-		It("only dequeues data from each stream once per packet", func() {
+		It("pops from all streams again, if there's space left in the packet", func() {
 			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil)
 			streamGetter.EXPECT().GetOrOpenSendStream(id2).Return(stream2, nil)
-			f1 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
-			f2 := &wire.StreamFrame{StreamID: id2, Data: []byte("raboof")}
-			// both streams have more data, and will be re-queued
-			stream1.EXPECT().popStreamFrame(gomock.Any()).Return(f1, true)
-			stream2.EXPECT().popStreamFrame(gomock.Any()).Return(f2, true)
+			f11 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
+			f12 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobaz")}
+			f21 := &wire.StreamFrame{StreamID: id2, Data: []byte("raboof")}
+			f22 := &wire.StreamFrame{StreamID: id2, Data: []byte("zaboof")}
+			gomock.InOrder(
+				stream1.EXPECT().popStreamFrame(gomock.Any()).Return(f11, true),
+				stream1.EXPECT().popStreamFrame(gomock.Any()).Return(f12, false),
+			)
+			gomock.InOrder(
+				stream2.EXPECT().popStreamFrame(gomock.Any()).Return(f21, true),
+				stream2.EXPECT().popStreamFrame(gomock.Any()).Return(f22, false),
+			)
+			framer.AddActiveStream(id1)
+			framer.AddActiveStream(id2)
+			Expect(framer.AppendStreamFrames(nil, 1000)).To(Equal([]wire.Frame{f11, f21, f12, f22}))
+			Expect(framer.HasData()).To(BeFalse())
+		})
+
+		It("doesn't pop from a stream again in the same packet, if it didn't return a frame", func() {
+			streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil)
+			streamGetter.EXPECT().GetOrOpenSendStream(id2).Return(stream2, nil)
+			f2 := &wire.StreamFrame{StreamID: id2, Data: []byte("foobar")}
+			// stream 1 is blocked by flow control
+			stream1.EXPECT().popStreamFrame(gomock.Any()).Return(nil, true).Times(2)
+			stream2.EXPECT().popStreamFrame(gomock.Any()).Return(f2, true).Times(2)
+			stream2.EXPECT().popStreamFrame(gomock.Any()).Return(nil, false)
 			framer.AddActiveStream(id1)
 			framer.AddActiveStream(id2)
-			Expect(framer.AppendStreamFrames(nil, 1000)).To(Equal([]wire.Frame{f1, f2}))
+			Expect(framer.AppendStreamFrames(nil, 1000)).To(Equal([]wire.Frame{f2, f2}))
+			// stream 1 is requeued at the end
+			Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(BeEmpty())
+		})
+
+		It("coalesces small frames from many streams into few packets", func() {
+			const numStreams = 100
+			const maxPacketLen = 1200
+			streams := make(map[protocol.StreamID]*sendStream)
+			for i := 0; i < numStreams; i++ {
+				id := protocol.StreamID(4 * i)
+				fc := mocks.NewMockStreamFlowController(mockCtrl)
+				fc.EXPECT().SendWindowSize().Return(protocol.MaxByteCount).AnyTimes()
+				fc.EXPECT().AddBytesSent(gomock.Any()).AnyTimes()
+				str := newSendStream(id, NewMockStreamSender(mockCtrl), fc, 0, congestion.DefaultClock{}, version)
+				str.dataForWriting = make([]byte, 200)
+				streams[id] = str
+				streamGetter.EXPECT().GetOrOpenSendStream(id).Return(str, nil).AnyTimes()
+				framer.AddActiveStream(id)
+			}
+			var numPackets, dataLen int
+			var totalLen protocol.ByteCount
+			for framer.HasData() {
+				frames := framer.AppendStreamFrames(nil, maxPacketLen)
+				Expect(frames).ToNot(BeEmpty())
+				var length protocol.ByteCount
+				for _, f := range frames {
+					length += f.Length(version)
+					dataLen += int(f.(*wire.StreamFrame).DataLen())
+				}
+				Expect(length).To(BeNumerically("<=", maxPacketLen))
+				totalLen += length
+				numPackets++
+			}
+			Expect(dataLen).To(Equal(numStreams * 200))
+			// every packet (except the last one) is filled up to less than MinStreamFrameSize
+			Expect(numPackets).To(BeNumerically("<=", 1+totalLen/(maxPacketLen-protocol.MinStreamFrameSize)))
+			Expect(numPackets).To(BeNumerically("<", numStreams/5))
 		})
 
 		It("returns multiple normal frames in the order they were reported active", func() {
@@ -196,6 +289,98 @@ var _ = Describe("Stream Framer", func() {
 			Expect(framer.AppendStreamFrames(nil, 1000)).To(HaveLen(1))
 		})
 
+		Context("priorities", func() {
+			var stream3 *MockSendStreamI
+			const id3 = protocol.StreamID(12)
+
+			BeforeEach(func() {
+				stream3 = NewMockSendStreamI(mockCtrl)
+				streamGetter.EXPECT().GetOrOpenSendStream(id1).Return(stream1, nil).AnyTimes()
+				streamGetter.EXPECT().GetOrOpenSendStream(id2).Return(stream2, nil).AnyTimes()
+				streamGetter.EXPECT().GetOrOpenSendStream(id3).Return(stream3, nil).AnyTimes()
+			})
+
+			It("sends data from a high-priority stream ahead of a low-priority stream", func() {
+				stream3.EXPECT().getPriority().Return(10).AnyTimes()
+				// stream 1 has a lot of data, and would fill every packet
+				stream1.EXPECT().popStreamFrame(gomock.Any()).DoAndReturn(func(maxLen protocol.ByteCount) (*wire.StreamFrame, bool) {
+					f := &wire.StreamFrame{StreamID: id1, DataLenPresent: true}
+					f.Data = bytes.Repeat([]byte{'f'}, int(f.MaxDataLen(maxLen, version)))
+					return f, true
+				}).AnyTimes()
+				framer.AddActiveStream(id1)
+				fs := framer.AppendStreamFrames(nil, 1000)
+				Expect(fs).To(HaveLen(1))
+				Expect(fs[0].(*wire.StreamFrame).StreamID).To(Equal(id1))
+				// now the high-priority stream gets data
+				f3 := &wire.StreamFrame{StreamID: id3, Data: []byte("foobar"), DataLenPresent: true}
+				stream3.EXPECT().popStreamFrame(gomock.Any()).Return(f3, false)
+				framer.AddActiveStream(id3)
+				fs = framer.AppendStreamFrames(nil, 1000)
+				Expect(fs).To(HaveLen(2))
+				Expect(fs[0]).To(Equal(f3))
+				Expect(fs[1].(*wire.StreamFrame).StreamID).To(Equal(id1))
+			})
+
+			It("serves streams with the same priority round-robin", func() {
+				stream3.EXPECT().getPriority().Return(-1).AnyTimes()
+				f1 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
+				f2 := &wire.StreamFrame{StreamID: id2, Data: []byte("foobaz")}
+				stream1.EXPECT().popStreamFrame(gomock.Any()).Return(f1, true).Times(2)
+				stream2.EXPECT().popStreamFrame(gomock.Any()).Return(f2, true).Times(2)
+				// stream 3 has a lower priority, and is never served
+				framer.AddActiveStream(id3)
+				framer.AddActiveStream(id1)
+				framer.AddActiveStream(id2)
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f1}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f2}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f1}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f2}))
+			})
+
+			It("applies priority changes to subsequently packed packets", func() {
+				prio := 0
+				stream3.EXPECT().getPriority().DoAndReturn(func() int { return prio }).AnyTimes()
+				f1 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
+				f3 := &wire.StreamFrame{StreamID: id3, Data: []byte("raboof")}
+				stream1.EXPECT().popStreamFrame(gomock.Any()).Return(f1, true).AnyTimes()
+				stream3.EXPECT().popStreamFrame(gomock.Any()).Return(f3, true).AnyTimes()
+				framer.AddActiveStream(id1)
+				framer.AddActiveStream(id3)
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f1}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f3}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f1}))
+				prio = 1
+				framer.StreamPriorityChanged(id3)
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f3}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f3}))
+				prio = 0
+				framer.StreamPriorityChanged(id3)
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f1}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f3}))
+			})
+
+			It("serves a stream after the other streams of its new priority, when the priority changes", func() {
+				prio := 1
+				stream3.EXPECT().getPriority().DoAndReturn(func() int { return prio }).AnyTimes()
+				f1 := &wire.StreamFrame{StreamID: id1, Data: []byte("foobar")}
+				f3 := &wire.StreamFrame{StreamID: id3, Data: []byte("raboof")}
+				stream1.EXPECT().popStreamFrame(gomock.Any()).Return(f1, true).AnyTimes()
+				stream3.EXPECT().popStreamFrame(gomock.Any()).Return(f3, true).AnyTimes()
+				framer.AddActiveStream(id3)
+				framer.AddActiveStream(id1)
+				prio = 0
+				framer.StreamPriorityChanged(id3)
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f1}))
+				Expect(framer.AppendStreamFrames(nil, protocol.MinStreamFrameSize)).To(Equal([]wire.Frame{f3}))
+			})
+
+			It("ignores priority changes of inactive streams", func() {
+				framer.StreamPriorityChanged(id3)
+				Expect(framer.HasData()).To(BeFalse())
+			})
+		})
+
 		It("does not pop empty frames", func() {
 			fs := framer.AppendStreamFrames(nil, 500)
 			Expect(fs).To(BeEmpty())
@@ -1,6 +1,7 @@
 package h2quic
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -21,10 +23,32 @@ import (
 )
 
 type roundTripperOpts struct {
-	DisableCompression bool
+	DisableCompression    bool
+	DisablePush           bool
+	ExpectContinueTimeout time.Duration
+	MaxHeaderBytes        int
 }
 
-var dialAddr = quic.DialAddr
+const defaultExpectContinueTimeout = time.Second
+
+const (
+	// maxPushPromises is the maximum number of pushed responses that weren't requested yet.
+	// Additional PUSH_PROMISEs are refused.
+	maxPushPromises = 100
+	// pushPromiseTimeout is the time after which a pushed response that wasn't requested is discarded.
+	pushPromiseTimeout = time.Minute
+)
+
+var dialAddr = quic.DialAddrContext
+
+var (
+	errRequestBodyTooLong  = errors.New("h2quic: request body larger than the specified content length")
+	errRequestBodyTooShort = errors.New("h2quic: request body shorter than the specified content length")
+	errRequestCanceled     = errors.New("net/http: request canceled")
+	// errGoAway is returned for new requests after the server sent a GOAWAY frame.
+	// The RoundTripper retries these requests on a new session.
+	errGoAway = errors.New("h2quic: server sent GOAWAY")
+)
 
 // client is a HTTP2 client doing QUIC requests
 type client struct {
@@ -35,9 +59,10 @@ type client struct {
 	opts    *roundTripperOpts
 
 	hostname     string
+	dialed       bool
 	handshakeErr error
 	dialOnce     sync.Once
-	dialer       func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
+	dialer       func(ctx context.Context, network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
 
 	session       quic.Session
 	headerStream  quic.Stream
@@ -46,12 +71,31 @@ type client struct {
 	requestWriter *requestWriter
 
 	responses map[protocol.StreamID]chan *http.Response
+	// trailers are the channels on which the trailers of responses are delivered
+	trailers map[protocol.StreamID]chan http.Header
+	// continues are closed when a 100 Continue response is received for a request with an "Expect: 100-continue" header
+	continues map[protocol.StreamID]chan struct{}
+	// pushPromises are the responses promised by the server, that weren't requested yet.
+	pushPromises map[string]*pushPromise
+	// goingAway is set when the server sent a GOAWAY frame
+	goingAway bool
 
 	logger utils.Logger
 }
 
 var _ http.RoundTripper = &client{}
 
+// A pushPromise is a response promised by the server in a PUSH_PROMISE frame.
+type pushPromise struct {
+	streamID     protocol.StreamID
+	responseChan chan *http.Response
+	trailerChan  chan http.Header
+	// acceptsGzip is set if the promised request contains an "Accept-Encoding: gzip" header
+	acceptsGzip bool
+	// the pushed response is discarded if it isn't requested before this time
+	expiry time.Time
+}
+
 var defaultQuicConfig = &quic.Config{KeepAlive: true}
 
 // newClient creates a new client
@@ -60,16 +104,28 @@ func newClient(
 	tlsConfig *tls.Config,
 	opts *roundTripperOpts,
 	quicConfig *quic.Config,
-	dialer func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error),
+	dialer func(ctx context.Context, network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error),
 ) *client {
 	config := defaultQuicConfig
 	if quicConfig != nil {
 		config = quicConfig
 	}
+	hostname = authorityAddr("https", hostname)
+	tlsConf := tlsConfigWithNextProto(tlsConfig)
+	// A custom dialer might connect to a different address than the one derived from the request URL,
+	// so the certificate must be verified for the hostname of the request.
+	if dialer != nil && tlsConf.ServerName == "" {
+		if host, _, err := net.SplitHostPort(hostname); err == nil {
+			tlsConf.ServerName = host
+		}
+	}
 	return &client{
-		hostname:      authorityAddr("https", hostname),
+		hostname:      hostname,
 		responses:     make(map[protocol.StreamID]chan *http.Response),
-		tlsConf:       tlsConfig,
+		trailers:      make(map[protocol.StreamID]chan http.Header),
+		continues:     make(map[protocol.StreamID]chan struct{}),
+		pushPromises:  make(map[string]*pushPromise),
+		tlsConf:       tlsConf,
 		config:        config,
 		opts:          opts,
 		headerErrored: make(chan struct{}),
@@ -79,36 +135,53 @@ func newClient(
 }
 
 // dial dials the connection
-func (c *client) dial() error {
+func (c *client) dial(ctx context.Context) error {
 	var err error
 	if c.dialer != nil {
-		c.session, err = c.dialer("udp", c.hostname, c.tlsConf, c.config)
+		c.session, err = c.dialer(ctx, "udp", c.hostname, c.tlsConf, c.config)
 	} else {
-		c.session, err = dialAddr(c.hostname, c.tlsConf, c.config)
+		c.session, err = dialAddr(ctx, c.hostname, c.tlsConf, c.config)
 	}
 	if err != nil {
+		if isHandshakeFailure(err) {
+			return &HandshakeFailedError{Err: err}
+		}
+		return err
+	}
+	// A custom dialer might not use the tls.Config that offers the h2quic ALPN protocol.
+	if p := c.session.ConnectionState().NegotiatedProtocol; p != nextProtoH2Quic {
+		err := qerr.Error(qerr.NoApplicationProtocol, fmt.Sprintf("h2quic: server negotiated an unexpected application protocol: %q", p))
+		c.session.CloseWithError(quic.ErrorCode(err.ErrorCode), err.ErrorMessage)
 		return err
 	}
 
 	// once the version has been negotiated, open the header stream
-	c.headerStream, err = c.session.OpenStreamSync()
+	c.headerStream, err = c.session.OpenStreamSync(ctx)
 	if err != nil {
 		return err
 	}
 	c.requestWriter = newRequestWriter(c.headerStream, c.logger)
+	settings := []http2.Setting{{ID: http2.SettingMaxHeaderListSize, Val: maxHeaderListSize(c.opts.MaxHeaderBytes)}}
+	if c.opts.DisablePush {
+		settings = append(settings, http2.Setting{ID: http2.SettingEnablePush, Val: 0})
+	}
+	if err := c.requestWriter.WriteSettings(settings...); err != nil {
+		return err
+	}
 	go c.handleHeaderStream()
 	return nil
 }
 
 func (c *client) handleHeaderStream() {
-	decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
-	h2framer := http2.NewFramer(nil, c.headerStream)
+	decoder := hpack.NewDecoder(hpackTableSize, func(hf hpack.HeaderField) {})
+	frames := newFrameReader(c.headerStream, decoder, maxHeaderListSize(c.opts.MaxHeaderBytes))
 
 	var err error
 	for err == nil {
-		err = c.readResponse(h2framer, decoder)
+		err = c.readResponse(frames, decoder)
 	}
-	if quicErr, ok := err.(*qerr.QuicError); !ok || quicErr.ErrorCode != qerr.PeerGoingAway {
+	var connErr *quic.ConnectionError
+	if !errors.As(err, &connErr) || qerr.ErrorCode(connErr.ErrorCode) != qerr.PeerGoingAway {
 		c.logger.Debugf("Error handling header stream: %s", err)
 	}
 	c.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, err.Error())
@@ -116,36 +189,248 @@ func (c *client) handleHeaderStream() {
 	close(c.headerErrored)
 }
 
-func (c *client) readResponse(h2framer *http2.Framer, decoder *hpack.Decoder) error {
-	frame, err := h2framer.ReadFrame()
+func (c *client) readResponse(frames *frameReader, decoder *hpack.Decoder) error {
+	frame, err := frames.ReadFrame()
 	if err != nil {
+		if tooLarge, ok := err.(*headerBlockTooLargeError); ok {
+			c.handleHeaderBlockTooLarge(tooLarge)
+			return nil
+		}
 		return err
 	}
+	if f, ok := frame.(*http2.PushPromiseFrame); ok {
+		return c.handlePushPromise(f, decoder)
+	}
+	if f, ok := frame.(*http2.SettingsFrame); ok {
+		return c.handleSettingsFrame(f)
+	}
+	if _, ok := frame.(*http2.GoAwayFrame); ok {
+		// The server is shutting down. Requests that were already sent are still handled.
+		c.mutex.Lock()
+		c.goingAway = true
+		c.mutex.Unlock()
+		return nil
+	}
 	hframe, ok := frame.(*http2.HeadersFrame)
 	if !ok {
 		return errors.New("not a headers frame")
 	}
 	mhframe := &http2.MetaHeadersFrame{HeadersFrame: hframe}
-	mhframe.Fields, err = decoder.DecodeFull(hframe.HeaderBlockFragment())
+	mhframe.Fields, mhframe.Truncated, err = decodeHeaderFields(decoder, hframe.HeaderBlockFragment(), maxHeaderListSize(c.opts.MaxHeaderBytes))
 	if err != nil {
 		return fmt.Errorf("cannot read header fields: %s", err.Error())
 	}
 
-	c.mutex.RLock()
-	responseChan, ok := c.responses[protocol.StreamID(hframe.StreamID)]
-	c.mutex.RUnlock()
-	if !ok {
-		return fmt.Errorf("response channel for stream %d not found", hframe.StreamID)
+	// The first HEADERS frame on a stream contains the response, the second one the trailers.
+	// The response might be preceded by interim (1xx) responses.
+	streamID := protocol.StreamID(hframe.StreamID)
+	c.mutex.Lock()
+	responseChan, isResponse := c.responses[streamID]
+	trailerChan, isTrailer := c.trailers[streamID]
+	if !isResponse {
+		delete(c.trailers, streamID)
+	}
+	c.mutex.Unlock()
+	if !isResponse {
+		if !isTrailer {
+			// The request might have been canceled, or the server sent the response to a PUSH_PROMISE that we refused.
+			// The server might also have sent a HEADERS frame after the trailers.
+			// This only affects a single stream, so the header stream is still usable.
+			c.logger.Debugf("Ignoring HEADERS frame for data stream %d", streamID)
+			return nil
+		}
+		// A nil trailer signals that the trailers exceeded the MaxHeaderBytes.
+		var trailer http.Header
+		if !mhframe.Truncated {
+			trailer, err = trailerFromHeaders(mhframe.Fields)
+			if err != nil {
+				return err
+			}
+		}
+		// the channel is buffered, and only used once
+		trailerChan <- trailer
+		return nil
 	}
 
+	if mhframe.Truncated {
+		// A nil response signals that the response headers exceeded the MaxHeaderBytes.
+		c.mutex.Lock()
+		delete(c.responses, streamID)
+		delete(c.continues, streamID)
+		c.mutex.Unlock()
+		responseChan <- nil
+		return nil
+	}
 	rsp, err := responseFromHeaders(mhframe)
 	if err != nil {
 		return err
 	}
+	if rsp.StatusCode >= 100 && rsp.StatusCode <= 199 {
+		if hframe.StreamEnded() {
+			return fmt.Errorf("interim response on stream %d ended the stream", hframe.StreamID)
+		}
+		if rsp.StatusCode == http.StatusContinue {
+			c.mutex.Lock()
+			if continueChan, ok := c.continues[streamID]; ok {
+				close(continueChan)
+				delete(c.continues, streamID)
+			}
+			c.mutex.Unlock()
+		}
+		return nil
+	}
+	c.mutex.Lock()
+	delete(c.responses, streamID)
+	delete(c.continues, streamID)
+	c.mutex.Unlock()
 	responseChan <- rsp
 	return nil
 }
 
+// handleHeaderBlockTooLarge handles a HEADERS or PUSH_PROMISE frame that was skipped,
+// because its header block exceeds the MaxHeaderBytes.
+func (c *client) handleHeaderBlockTooLarge(e *headerBlockTooLargeError) {
+	if e.isPushPromise {
+		c.logger.Debugf("Refusing pushed stream %d, since the PUSH_PROMISE header block is larger than %d bytes", e.streamID, maxHeaderListSize(c.opts.MaxHeaderBytes))
+		c.refusePush(e.streamID)
+		return
+	}
+	c.mutex.Lock()
+	responseChan, isResponse := c.responses[e.streamID]
+	trailerChan, isTrailer := c.trailers[e.streamID]
+	delete(c.responses, e.streamID)
+	delete(c.continues, e.streamID)
+	if !isResponse {
+		delete(c.trailers, e.streamID)
+	}
+	c.mutex.Unlock()
+	// A nil response (or trailer) signals that the header list exceeded the MaxHeaderBytes.
+	switch {
+	case isResponse:
+		responseChan <- nil
+	case isTrailer:
+		trailerChan <- nil
+	default:
+		c.logger.Debugf("Ignoring HEADERS frame for data stream %d", e.streamID)
+	}
+}
+
+func (c *client) handleSettingsFrame(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	return f.ForeachSetting(func(setting http2.Setting) error {
+		if err := setting.Valid(); err != nil {
+			return err
+		}
+		if setting.ID == http2.SettingMaxHeaderListSize {
+			c.logger.Debugf("Server set SETTINGS_MAX_HEADER_LIST_SIZE to %d", setting.Val)
+			c.requestWriter.SetPeerMaxHeaderListSize(setting.Val)
+		}
+		return nil
+	})
+}
+
+func (c *client) handlePushPromise(f *http2.PushPromiseFrame, decoder *hpack.Decoder) error {
+	// The header block needs to be decoded even if the push is refused, to keep the HPACK state in sync.
+	maxSize := maxHeaderListSize(c.opts.MaxHeaderBytes)
+	fields, truncated, err := decodeHeaderFields(decoder, f.HeaderBlockFragment(), maxSize)
+	if err != nil {
+		return fmt.Errorf("cannot read header fields: %s", err.Error())
+	}
+	streamID := protocol.StreamID(f.PromiseID)
+	if c.opts.DisablePush {
+		c.logger.Debugf("Refusing pushed stream %d, since push is disabled", streamID)
+		c.refusePush(streamID)
+		return nil
+	}
+	if truncated {
+		c.logger.Debugf("Refusing pushed stream %d, since the PUSH_PROMISE header list is larger than %d bytes", streamID, maxSize)
+		c.refusePush(streamID)
+		return nil
+	}
+	req, err := requestFromHeaders(fields)
+	if err != nil {
+		return err
+	}
+	c.logger.Debugf("Server promised %s %s%s on data stream %d", req.Method, req.Host, req.RequestURI, f.PromiseID)
+	// The response headers might arrive before the pushed resource is requested, so the channel needs to be buffered.
+	p := &pushPromise{
+		streamID:     streamID,
+		responseChan: make(chan *http.Response, 1),
+		trailerChan:  make(chan http.Header, 1),
+		acceptsGzip:  req.Header.Get("Accept-Encoding") == "gzip",
+		expiry:       time.Now().Add(pushPromiseTimeout),
+	}
+	key := pushPromiseKey(req.Method, req.Host, req.RequestURI)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.expirePushPromises()
+	if old, ok := c.pushPromises[key]; ok {
+		// the server pushed the same resource again
+		c.discardPushPromise(key, old)
+	}
+	if len(c.pushPromises) >= maxPushPromises {
+		c.logger.Debugf("Refusing pushed stream %d, since there are too many pushed responses that weren't requested", streamID)
+		c.refusePush(streamID)
+		return nil
+	}
+	c.responses[p.streamID] = p.responseChan
+	c.trailers[p.streamID] = p.trailerChan
+	c.pushPromises[key] = p
+	return nil
+}
+
+// expirePushPromises discards the pushed responses that weren't requested in time.
+// It must be called with the mutex held.
+func (c *client) expirePushPromises() {
+	now := time.Now()
+	for key, p := range c.pushPromises {
+		if !now.Before(p.expiry) {
+			c.logger.Debugf("Discarding pushed stream %d, since it wasn't requested in time", p.streamID)
+			c.discardPushPromise(key, p)
+		}
+	}
+}
+
+// discardPushPromise deletes a pushed response that wasn't requested, and refuses the pushed stream.
+// It must be called with the mutex held.
+func (c *client) discardPushPromise(key string, p *pushPromise) {
+	delete(c.pushPromises, key)
+	c.discardPushedStream(p.streamID)
+}
+
+// discardPushedStream deletes the state of a pushed stream, and refuses the stream.
+// It must be called with the mutex held.
+func (c *client) discardPushedStream(id protocol.StreamID) {
+	delete(c.responses, id)
+	delete(c.trailers, id)
+	c.refusePush(id)
+}
+
+// refusePush tells the server to stop sending on a pushed stream.
+func (c *client) refusePush(id protocol.StreamID) {
+	session, ok := c.session.(streamCreator)
+	if !ok {
+		return
+	}
+	str, err := session.GetOrOpenStream(id)
+	if err != nil || str == nil {
+		return
+	}
+	str.CancelRead(errorCodeRefusedStream)
+	// we never send any data on a pushed stream
+	str.Close()
+}
+
+// pushPromiseKey is used to match a request to a response pushed by the server.
+func pushPromiseKey(method, authority, requestURI string) string {
+	if method == "" {
+		method = "GET"
+	}
+	return method + " " + authorityAddr("https", authority) + requestURI
+}
+
 // Roundtrip executes a request and returns a response
 func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	// TODO: add port to address, if it doesn't have one
@@ -157,7 +442,11 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 
 	c.dialOnce.Do(func() {
-		c.handshakeErr = c.dial()
+		err := c.dial(req.Context())
+		c.mutex.Lock()
+		c.dialed = err == nil
+		c.handshakeErr = err
+		c.mutex.Unlock()
 	})
 
 	if c.handshakeErr != nil {
@@ -165,33 +454,76 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 
 	hasBody := (req.Body != nil)
+	if !hasBody {
+		c.mutex.Lock()
+		c.expirePushPromises()
+		key := pushPromiseKey(req.Method, hostnameFromRequest(req), req.URL.RequestURI())
+		p, ok := c.pushPromises[key]
+		delete(c.pushPromises, key)
+		c.mutex.Unlock()
+		if ok {
+			return c.roundTripPushed(req, p)
+		}
+	}
+
+	c.mutex.RLock()
+	goingAway := c.goingAway
+	c.mutex.RUnlock()
+	if goingAway {
+		return nil, errGoAway
+	}
 
-	responseChan := make(chan *http.Response)
-	dataStream, err := c.session.OpenStreamSync()
+	// The channel is buffered, so that the header stream isn't blocked if the request fails before the response arrives.
+	responseChan := make(chan *http.Response, 1)
+	// The trailers might arrive before the body was read, so the channel needs to be buffered.
+	trailerChan := make(chan http.Header, 1)
+	dataStream, err := c.session.OpenStreamSync(req.Context())
 	if err != nil {
 		_ = c.closeWithError(err)
 		return nil, err
 	}
+	// If the request expects a 100 Continue response, the body is sent after it was received.
+	var continueChan chan struct{}
+	if hasBody && expectsContinue(req.Header) {
+		continueChan = make(chan struct{})
+	}
 	c.mutex.Lock()
 	c.responses[dataStream.StreamID()] = responseChan
+	c.trailers[dataStream.StreamID()] = trailerChan
+	if continueChan != nil {
+		c.continues[dataStream.StreamID()] = continueChan
+	}
 	c.mutex.Unlock()
 
+	isConnect := req.Method == "CONNECT"
 	var requestedGzip bool
-	if !c.opts.DisableCompression && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" {
+	if !c.opts.DisableCompression && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" && !isConnect {
 		requestedGzip = true
 	}
-	// TODO: add support for trailers
 	endStream := !hasBody
 	err = c.requestWriter.WriteRequest(req, dataStream.StreamID(), endStream, requestedGzip)
 	if err != nil {
+		if encErr, ok := err.(*headerEncodingError); ok {
+			// The request wasn't sent, so the session can still be used for other requests.
+			c.cancelRequest(dataStream)
+			return nil, encErr.err
+		}
 		_ = c.closeWithError(err)
 		return nil, err
 	}
 
 	resc := make(chan error, 1)
+	// abortBody is closed when the final response arrives before the 100 Continue response
+	abortBody := make(chan struct{})
 	if hasBody {
 		go func() {
-			resc <- c.writeRequestBody(dataStream, req.Body)
+			if continueChan != nil && !c.waitForContinue(continueChan, abortBody) {
+				req.Body.Close()
+				dataStream.CancelWrite(0)
+				resc <- nil
+				return
+			}
+			resc <- c.writeRequestBody(req, dataStream)
 		}()
 	}
 
@@ -200,7 +532,9 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	var receivedResponse bool
 	var bodySent bool
 
-	if !hasBody {
+	// For CONNECT requests, the body is the data sent through the tunnel.
+	// It is sent while the response body is read, so the response is returned before the body was sent.
+	if !hasBody || isConnect {
 		bodySent = true
 	}
 
@@ -208,23 +542,35 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	for !(bodySent && receivedResponse) {
 		select {
 		case res = <-responseChan:
+			if res == nil {
+				c.refuseResponse(dataStream)
+				return nil, errResponseHeaderListSize
+			}
 			receivedResponse = true
 			c.mutex.Lock()
 			delete(c.responses, dataStream.StreamID())
 			c.mutex.Unlock()
+			if continueChan != nil {
+				close(abortBody)
+			}
 		case err := <-resc:
 			bodySent = true
 			if err != nil {
+				// The upload failed, e.g. because the server reset the stream.
+				dataStream.CancelRead(0)
+				c.mutex.Lock()
+				delete(c.responses, dataStream.StreamID())
+				delete(c.trailers, dataStream.StreamID())
+				delete(c.continues, dataStream.StreamID())
+				c.mutex.Unlock()
 				return nil, err
 			}
 		case <-ctx.Done():
-			// error code 6 signals that stream was canceled
-			dataStream.CancelRead(6)
-			dataStream.CancelWrite(6)
-			c.mutex.Lock()
-			delete(c.responses, dataStream.StreamID())
-			c.mutex.Unlock()
+			c.cancelRequest(dataStream)
 			return nil, ctx.Err()
+		case <-req.Cancel:
+			c.cancelRequest(dataStream)
+			return nil, errRequestCanceled
 		case <-c.headerErrored:
 			// an error occurred on the header stream
 			_ = c.closeWithError(c.headerErr)
@@ -232,6 +578,120 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 
+	if isConnect && hasBody && (res.StatusCode < 200 || res.StatusCode > 299) {
+		// the tunnel wasn't established
+		dataStream.CancelWrite(0)
+	}
+	return c.setResponseBody(req, res, dataStream, trailerChan, requestedGzip), nil
+}
+
+// cancelRequest resets the data stream of a request that was canceled before the response arrived.
+func (c *client) cancelRequest(dataStream quic.Stream) {
+	// error code 6 signals that stream was canceled
+	dataStream.CancelRead(6)
+	dataStream.CancelWrite(6)
+	c.mutex.Lock()
+	delete(c.responses, dataStream.StreamID())
+	delete(c.trailers, dataStream.StreamID())
+	delete(c.continues, dataStream.StreamID())
+	c.mutex.Unlock()
+}
+
+// refuseResponse resets the data stream of a request whose response headers exceeded the MaxHeaderBytes.
+func (c *client) refuseResponse(dataStream quic.Stream) {
+	dataStream.CancelRead(errorCodeRefusedStream)
+	dataStream.CancelWrite(errorCodeRefusedStream)
+	c.mutex.Lock()
+	delete(c.trailers, dataStream.StreamID())
+	c.mutex.Unlock()
+}
+
+// waitForContinue waits until the server sent a 100 Continue response, or until the ExpectContinueTimeout expires.
+// It returns false if the request body shouldn't be sent, because the final response arrived first.
+func (c *client) waitForContinue(continueChan <-chan struct{}, abort <-chan struct{}) bool {
+	timeout := c.opts.ExpectContinueTimeout
+	if timeout == 0 {
+		timeout = defaultExpectContinueTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-continueChan:
+		return true
+	case <-timer.C:
+		return true
+	case <-abort:
+		// the 100 Continue response might have been received before the final response
+		select {
+		case <-continueChan:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// roundTripPushed returns the response that the server pushed for a request.
+func (c *client) roundTripPushed(req *http.Request, p *pushPromise) (*http.Response, error) {
+	var res *http.Response
+	select {
+	case res = <-p.responseChan:
+	case <-req.Context().Done():
+		c.mutex.Lock()
+		c.discardPushedStream(p.streamID)
+		c.mutex.Unlock()
+		return nil, req.Context().Err()
+	case <-req.Cancel:
+		c.mutex.Lock()
+		c.discardPushedStream(p.streamID)
+		c.mutex.Unlock()
+		return nil, errRequestCanceled
+	case <-c.headerErrored:
+		// an error occurred on the header stream
+		_ = c.closeWithError(c.headerErr)
+		return nil, c.headerErr
+	}
+	c.mutex.Lock()
+	delete(c.responses, p.streamID)
+	c.mutex.Unlock()
+
+	session, ok := c.session.(streamCreator)
+	if !ok {
+		return nil, errors.New("h2quic: session doesn't support pushed streams")
+	}
+	dataStream, err := session.GetOrOpenStream(p.streamID)
+	if err != nil {
+		return nil, err
+	}
+	if dataStream == nil {
+		return nil, fmt.Errorf("h2quic: pushed stream %d was already closed", p.streamID)
+	}
+	if res == nil {
+		c.refuseResponse(dataStream)
+		return nil, errResponseHeaderListSize
+	}
+	// We never send any data on a pushed stream.
+	dataStream.Close()
+	// If the server promised a request that accepts gzip, the pushed response is decompressed,
+	// unless the caller set the Accept-Encoding itself.
+	requestedGzip := p.acceptsGzip && !c.opts.DisableCompression && req.Header.Get("Accept-Encoding") == ""
+	return c.setResponseBody(req, res, dataStream, p.trailerChan, requestedGzip), nil
+}
+
+func (c *client) setResponseBody(
+	req *http.Request,
+	res *http.Response,
+	dataStream quic.Stream,
+	trailerChan <-chan http.Header,
+	requestedGzip bool,
+) *http.Response {
+	// If the response didn't announce any trailers, the server won't send any.
+	if res.Trailer == nil {
+		c.mutex.Lock()
+		delete(c.trailers, dataStream.StreamID())
+		c.mutex.Unlock()
+	}
+
 	// TODO: correctly set this variable
 	var streamEnded bool
 	isHead := (req.Method == "HEAD")
@@ -241,8 +701,26 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	if streamEnded || isHead {
 		res.Body = noBody
 	} else {
-		res.Body = &responseBody{dataStream}
-		if requestedGzip && res.Header.Get("Content-Encoding") == "gzip" {
+		body := newResponseBody(req.Context(), dataStream, req.Cancel)
+		if res.Trailer != nil {
+			body.readTrailers = func() error {
+				select {
+				case trailer := <-trailerChan:
+					if trailer == nil {
+						c.refuseResponse(dataStream)
+						return errResponseHeaderListSize
+					}
+					for k, v := range trailer {
+						res.Trailer[k] = v
+					}
+					return nil
+				case <-c.headerErrored:
+					return c.headerErr
+				}
+			}
+		}
+		res.Body = body
+		if requestedGzip && strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
 			res.Header.Del("Content-Encoding")
 			res.Header.Del("Content-Length")
 			res.ContentLength = -1
@@ -252,23 +730,48 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 
 	res.Request = req
-	return res, nil
+	return res
 }
 
-func (c *client) writeRequestBody(dataStream quic.Stream, body io.ReadCloser) (err error) {
+// writeRequestBody copies the request body to the data stream.
+// The body is never buffered: Writes on the stream block until the peer grants flow control credit.
+// If the request specifies a content length, the body must have exactly this length.
+func (c *client) writeRequestBody(req *http.Request, dataStream quic.Stream) (err error) {
 	defer func() {
-		cerr := body.Close()
+		cerr := req.Body.Close()
 		if err == nil {
-			// TODO: what to do with dataStream here? Maybe reset it?
 			err = cerr
 		}
+		if err != nil {
+			// the server won't receive the complete body
+			dataStream.CancelWrite(0)
+		}
 	}()
 
-	_, err = io.Copy(dataStream, body)
+	body := io.Reader(req.Body)
+	contentLength := actualContentLength(req)
+	if contentLength >= 0 {
+		// read one byte more than announced, to detect a body that is too long
+		body = io.LimitReader(req.Body, contentLength+1)
+	}
+	n, err := io.Copy(dataStream, body)
 	if err != nil {
-		// TODO: what to do with dataStream here? Maybe reset it?
 		return err
 	}
+	if contentLength >= 0 {
+		if n > contentLength {
+			return errRequestBodyTooLong
+		}
+		if n < contentLength {
+			return errRequestBodyTooShort
+		}
+	}
+	// The trailers are only complete once the body was read.
+	if len(req.Trailer) > 0 {
+		if err := c.requestWriter.WriteTrailers(req, dataStream.StreamID()); err != nil {
+			return err
+		}
+	}
 	return dataStream.Close()
 }
 
@@ -276,7 +779,38 @@ func (c *client) closeWithError(e error) error {
 	if c.session == nil {
 		return nil
 	}
-	return c.session.CloseWithError(quic.ErrorCode(qerr.InternalError), e)
+	return c.session.CloseWithError(quic.ErrorCode(qerr.InternalError), e.Error())
+}
+
+// isHandshakeFailure says if dialing failed because the handshake timed out,
+// or because client and server don't support a common QUIC version.
+func isHandshakeFailure(err error) bool {
+	var connErr *quic.ConnectionError
+	if !errors.As(err, &connErr) || connErr.IsApplicationError {
+		return false
+	}
+	switch qerr.ErrorCode(connErr.ErrorCode) {
+	case qerr.HandshakeTimeout, qerr.InvalidVersion:
+		return true
+	}
+	return false
+}
+
+func (c *client) isClosed() bool {
+	select {
+	case <-c.headerErrored:
+		return true
+	default:
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.handshakeErr != nil
+}
+
+func (c *client) isDialed() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.dialed
 }
 
 // Close closes the client
@@ -6,8 +6,12 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"runtime"
+	"strings"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -23,6 +27,26 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// discardingStream is a stream that discards all data written to it
+type discardingStream struct {
+	*mockStream
+	bytesWritten int64
+}
+
+func (s *discardingStream) Write(p []byte) (int, error) {
+	s.bytesWritten += int64(len(p))
+	return len(p), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
 var _ = Describe("Client", func() {
 	var (
 		client       *client
@@ -65,10 +89,45 @@ var _ = Describe("Client", func() {
 		dialAddr = origDialAddr
 	})
 
-	It("saves the TLS config", func() {
-		tlsConf := &tls.Config{InsecureSkipVerify: true}
+	It("saves the TLS config, setting the ALPN protocol", func() {
+		tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"foo"}}
 		client = newClient("", tlsConf, &roundTripperOpts{}, nil, nil)
-		Expect(client.tlsConf).To(Equal(tlsConf))
+		Expect(client.tlsConf.InsecureSkipVerify).To(BeTrue())
+		Expect(client.tlsConf.NextProtos).To(Equal([]string{nextProtoH2Quic}))
+		Expect(tlsConf.NextProtos).To(Equal([]string{"foo"}))
+	})
+
+	It("sets the ServerName to the requested host, if a custom dialer is used", func() {
+		dialer := func(context.Context, string, string, *tls.Config, *quic.Config) (quic.Session, error) {
+			return nil, nil
+		}
+		client = newClient("quic.clemente.io:4433", nil, &roundTripperOpts{}, nil, dialer)
+		Expect(client.tlsConf.ServerName).To(Equal("quic.clemente.io"))
+		client = newClient("[::1]", nil, &roundTripperOpts{}, nil, dialer)
+		Expect(client.tlsConf.ServerName).To(Equal("::1"))
+		// the ServerName is only set on the client's copy of the tls.Config
+		tlsConf := &tls.Config{}
+		client = newClient("quic.clemente.io", tlsConf, &roundTripperOpts{}, nil, dialer)
+		Expect(client.tlsConf.ServerName).To(Equal("quic.clemente.io"))
+		Expect(tlsConf.ServerName).To(BeEmpty())
+	})
+
+	It("doesn't overwrite the ServerName set in the TLS config", func() {
+		dialer := func(context.Context, string, string, *tls.Config, *quic.Config) (quic.Session, error) {
+			return nil, nil
+		}
+		client = newClient("quic.clemente.io", &tls.Config{ServerName: "example.com"}, &roundTripperOpts{}, nil, dialer)
+		Expect(client.tlsConf.ServerName).To(Equal("example.com"))
+	})
+
+	It("doesn't set the ServerName if no custom dialer is used", func() {
+		client = newClient("quic.clemente.io", nil, &roundTripperOpts{}, nil, nil)
+		Expect(client.tlsConf.ServerName).To(BeEmpty())
+	})
+
+	It("sets the ALPN protocol if no TLS config is given", func() {
+		client = newClient("", nil, &roundTripperOpts{}, nil, nil)
+		Expect(client.tlsConf.NextProtos).To(Equal([]string{nextProtoH2Quic}))
 	})
 
 	It("saves the QUIC config", func() {
@@ -91,7 +150,7 @@ var _ = Describe("Client", func() {
 	It("dials", func() {
 		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
 		session.streamsToOpen = []quic.Stream{newMockStream(3), newMockStream(5)}
-		dialAddr = func(hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+		dialAddr = func(_ context.Context, hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
 			return session, nil
 		}
 		close(headerStream.unblockRead)
@@ -109,34 +168,127 @@ var _ = Describe("Client", func() {
 		Eventually(done).Should(BeClosed())
 	})
 
+	It("disables push when dialing", func() {
+		client = newClient("localhost:1337", nil, &roundTripperOpts{DisablePush: true}, nil, nil)
+		hdrStr := newMockStream(3)
+		session.streamsToOpen = []quic.Stream{hdrStr}
+		dialAddr = func(context.Context, string, *tls.Config, *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		Expect(client.dial(context.Background())).To(Succeed())
+		frame, err := http2.NewFramer(nil, &hdrStr.dataWritten).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+		val, ok := frame.(*http2.SettingsFrame).Value(http2.SettingEnablePush)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeZero())
+	})
+
+	It("sends the MaxHeaderBytes when dialing", func() {
+		client = newClient("localhost:1337", nil, &roundTripperOpts{MaxHeaderBytes: 1337}, nil, nil)
+		hdrStr := newMockStream(3)
+		session.streamsToOpen = []quic.Stream{hdrStr}
+		dialAddr = func(context.Context, string, *tls.Config, *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		Expect(client.dial(context.Background())).To(Succeed())
+		frame, err := http2.NewFramer(nil, &hdrStr.dataWritten).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+		val, ok := frame.(*http2.SettingsFrame).Value(http2.SettingMaxHeaderListSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(1337))
+		_, ok = frame.(*http2.SettingsFrame).Value(http2.SettingEnablePush)
+		Expect(ok).To(BeFalse())
+	})
+
 	It("errors when dialing fails", func() {
 		testErr := errors.New("handshake error")
 		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
-		dialAddr = func(hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+		dialAddr = func(_ context.Context, hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
 			return nil, testErr
 		}
 		_, err := client.RoundTrip(req)
 		Expect(err).To(MatchError(testErr))
+		Expect(client.isDialed()).To(BeFalse())
+		Expect(client.isClosed()).To(BeTrue())
+	})
+
+	It("returns a HandshakeFailedError if the handshake times out", func() {
+		connErr := &quic.ConnectionError{ErrorCode: uint16(qerr.HandshakeTimeout)}
+		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
+		dialAddr = func(context.Context, string, *tls.Config, *quic.Config) (quic.Session, error) {
+			return nil, connErr
+		}
+		_, err := client.RoundTrip(req)
+		Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+		var hsErr *HandshakeFailedError
+		Expect(errors.As(err, &hsErr)).To(BeTrue())
+		Expect(hsErr.Err).To(BeIdenticalTo(connErr))
+	})
+
+	It("detects handshake failures", func() {
+		Expect(isHandshakeFailure(&quic.ConnectionError{ErrorCode: uint16(qerr.HandshakeTimeout)})).To(BeTrue())
+		Expect(isHandshakeFailure(&quic.ConnectionError{ErrorCode: uint16(qerr.InvalidVersion)})).To(BeTrue())
+		Expect(isHandshakeFailure(&quic.ConnectionError{ErrorCode: uint16(qerr.NoApplicationProtocol)})).To(BeFalse())
+		Expect(isHandshakeFailure(&quic.ConnectionError{ErrorCode: uint16(qerr.HandshakeTimeout), IsApplicationError: true})).To(BeFalse())
+		Expect(isHandshakeFailure(errors.New("handshake error"))).To(BeFalse())
+	})
+
+	It("closes the session if the server negotiated a different application protocol", func() {
+		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
+		session.negotiatedProtocol = "foo"
+		dialAddr = func(_ context.Context, hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		_, err := client.RoundTrip(req)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.NoApplicationProtocol))
+		Expect(session.closedWithError).To(MatchError(err))
+	})
+
+	It("uses the request context for dialing", func() {
+		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		dialAddr = func(ctx context.Context, _ string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := client.RoundTrip(req.WithContext(ctx))
+			Expect(err).To(MatchError(context.Canceled))
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+		cancel()
+		Eventually(done).Should(BeClosed())
 	})
 
 	It("uses the custom dialer, if provided", func() {
 		var tlsCfg *tls.Config
 		var qCfg *quic.Config
+		var dialCtx context.Context
 		session.streamsToOpen = []quic.Stream{newMockStream(3), newMockStream(5)}
-		dialer := func(_, _ string, tlsCfgP *tls.Config, cfg *quic.Config) (quic.Session, error) {
+		dialer := func(ctx context.Context, _, _ string, tlsCfgP *tls.Config, cfg *quic.Config) (quic.Session, error) {
+			dialCtx = ctx
 			tlsCfg = tlsCfgP
 			qCfg = cfg
 			return session, nil
 		}
 		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, dialer)
+		type ctxKey struct{}
+		ctx := context.WithValue(context.Background(), ctxKey{}, "foobar")
 		done := make(chan struct{})
 		go func() {
 			defer GinkgoRecover()
-			_, err := client.RoundTrip(req)
+			_, err := client.RoundTrip(req.WithContext(ctx))
 			Expect(err).ToNot(HaveOccurred())
 			close(done)
 		}()
 		Eventually(func() quic.Session { return client.session }).Should(Equal(session))
+		Expect(dialCtx.Value(ctxKey{})).To(Equal("foobar"))
 		Expect(qCfg).To(Equal(client.config))
 		Expect(tlsCfg).To(Equal(client.tlsConf))
 		// make the go routine return
@@ -148,7 +300,7 @@ var _ = Describe("Client", func() {
 		testErr := errors.New("you shall not pass")
 		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
 		session.streamOpenErr = testErr
-		dialAddr = func(hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+		dialAddr = func(_ context.Context, hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
 			return session, nil
 		}
 		_, err := client.RoundTrip(req)
@@ -157,7 +309,7 @@ var _ = Describe("Client", func() {
 
 	It("returns a request when dial fails", func() {
 		testErr := errors.New("dial error")
-		dialAddr = func(hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+		dialAddr = func(_ context.Context, hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
 			return nil, testErr
 		}
 		request, err := http.NewRequest("https", "https://quic.clemente.io:1337/file1.dat", nil)
@@ -184,6 +336,11 @@ var _ = Describe("Client", func() {
 			h2framer := http2.NewFramer(nil, r)
 			frame, err := h2framer.ReadFrame()
 			Expect(err).ToNot(HaveOccurred())
+			// skip the SETTINGS frame sent after dialing
+			if _, ok := frame.(*http2.SettingsFrame); ok {
+				frame, err = h2framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+			}
 			mhframe := &http2.MetaHeadersFrame{HeadersFrame: frame.(*http2.HeadersFrame)}
 			mhframe.Fields, err = decoder.DecodeFull(mhframe.HeadersFrame.HeaderBlockFragment())
 			Expect(err).ToNot(HaveOccurred())
@@ -200,7 +357,7 @@ var _ = Describe("Client", func() {
 
 		BeforeEach(func() {
 			var err error
-			dialAddr = func(hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+			dialAddr = func(_ context.Context, hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
 				return session, nil
 			}
 			dataStream = newMockStream(5)
@@ -231,6 +388,36 @@ var _ = Describe("Client", func() {
 			injectResponse(5, teapot)
 			Expect(client.headerErrored).ToNot(BeClosed())
 			Eventually(done).Should(BeClosed())
+			Expect(client.isClosed()).To(BeFalse())
+		})
+
+		It("returns the error if the request headers can't be encoded, without closing the session", func() {
+			client.dialOnce.Do(func() {}) // don't dial
+			session.streamsToOpen = []quic.Stream{dataStream}
+			request.Header.Set("foo bar", "baz")
+			_, err := client.RoundTrip(request)
+			Expect(err).To(MatchError(`invalid HTTP header name "foo bar"`))
+			Expect(session.closed).To(BeFalse())
+			Expect(headerStream.dataWritten.Bytes()).To(BeEmpty())
+			Expect(dataStream.canceledWrite).To(BeTrue())
+			Expect(client.responses).To(BeEmpty())
+			Expect(client.trailers).To(BeEmpty())
+		})
+
+		It("refuses a response with header lists larger than the MaxHeaderBytes", func() {
+			client.dialOnce.Do(func() {}) // don't dial
+			session.streamsToOpen = []quic.Stream{dataStream}
+			errChan := make(chan error)
+			go func() {
+				defer GinkgoRecover()
+				_, err := client.RoundTrip(request)
+				errChan <- err
+			}()
+			injectResponse(5, nil)
+			Eventually(errChan).Should(Receive(MatchError(errResponseHeaderListSize)))
+			Expect(dataStream.canceledRead).To(BeTrue())
+			Expect(dataStream.canceledWrite).To(BeTrue())
+			Expect(client.isClosed()).To(BeFalse())
 		})
 
 		It("errors if a request without a body is canceled", func() {
@@ -252,6 +439,43 @@ var _ = Describe("Client", func() {
 			Expect(client.headerErrored).ToNot(BeClosed())
 		})
 
+		It("errors if a request is canceled using the Cancel channel", func() {
+			done := make(chan struct{})
+			cancel := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				request.Cancel = cancel
+				rsp, err := client.RoundTrip(request)
+				Expect(err).To(MatchError(errRequestCanceled))
+				Expect(rsp).To(BeNil())
+				close(done)
+			}()
+
+			close(cancel)
+			Eventually(done).Should(BeClosed())
+			Expect(dataStream.canceledRead).To(BeTrue())
+			Expect(dataStream.canceledWrite).To(BeTrue())
+			Expect(client.responses).To(BeEmpty())
+		})
+
+		It("resets the stream if a request is canceled after the response was received", func() {
+			rspChan := make(chan *http.Response)
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				defer GinkgoRecover()
+				rsp, err := client.RoundTrip(request.WithContext(ctx))
+				Expect(err).ToNot(HaveOccurred())
+				rspChan <- rsp
+			}()
+			injectResponse(5, &http.Response{StatusCode: 200, Header: http.Header{}})
+			Eventually(rspChan).Should(Receive())
+			Expect(dataStream.canceledRead).To(BeFalse())
+			cancel()
+			Eventually(func() bool { return dataStream.canceledRead }).Should(BeTrue())
+			Eventually(func() bool { return dataStream.canceledWrite }).Should(BeTrue())
+			Expect(client.headerErrored).ToNot(BeClosed())
+		})
+
 		It("errors if a request with a body is canceled after the body is sent", func() {
 			done := make(chan struct{})
 			ctx, cancel := context.WithCancel(context.Background())
@@ -306,8 +530,10 @@ var _ = Describe("Client", func() {
 			}()
 
 			Eventually(done).Should(BeClosed())
+			Expect(client.isDialed()).To(BeTrue())
+			Expect(client.isClosed()).To(BeTrue())
 			Expect(client.headerErr.ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
-			Expect(client.session.(*mockSession).closedWithError).To(MatchError(client.headerErr))
+			Expect(client.session.(*mockSession).closedWithError).To(MatchError(qerr.Error(qerr.InternalError, client.headerErr.Error())))
 		})
 
 		It("returns subsequent request if there was an error on the header stream before", func() {
@@ -470,6 +696,274 @@ var _ = Describe("Client", func() {
 				Eventually(done).Should(BeClosed())
 				Expect(request.Body.(*mockBody).closed).To(BeTrue())
 			})
+
+			It("errors if the body is longer than the content length", func() {
+				request.ContentLength = int64(len(requestBody)) - 1
+				_, err := client.RoundTrip(request)
+				Expect(err).To(MatchError(errRequestBodyTooLong))
+				Expect(dataStream.canceledWrite).To(BeTrue())
+				Expect(dataStream.closed).To(BeFalse())
+				Expect(request.Body.(*mockBody).closed).To(BeTrue())
+			})
+
+			It("errors if the body is shorter than the content length", func() {
+				request.ContentLength = int64(len(requestBody)) + 1
+				_, err := client.RoundTrip(request)
+				Expect(err).To(MatchError(errRequestBodyTooShort))
+				Expect(dataStream.canceledWrite).To(BeTrue())
+				Expect(dataStream.closed).To(BeFalse())
+			})
+
+			It("returns the error if the stream is reset during the upload", func() {
+				testErr := errors.New("stream reset")
+				dataStream.writeErr = testErr
+				_, err := client.RoundTrip(request)
+				Expect(err).To(MatchError(testErr))
+				Expect(dataStream.canceledWrite).To(BeTrue())
+				Expect(dataStream.canceledRead).To(BeTrue())
+				Expect(client.responses).To(BeEmpty())
+				Expect(client.trailers).To(BeEmpty())
+				Expect(client.headerErrored).ToNot(BeClosed())
+			})
+
+			Context("Expect: 100-continue", func() {
+				getContinueChan := func() chan struct{} {
+					client.mutex.Lock()
+					defer client.mutex.Unlock()
+					return client.continues[5]
+				}
+
+				BeforeEach(func() {
+					request.Header.Set("Expect", "100-continue")
+				})
+
+				It("sends the body after receiving the 100 Continue response", func() {
+					client.opts.ExpectContinueTimeout = time.Hour
+					rspChan := make(chan *http.Response)
+					go func() {
+						defer GinkgoRecover()
+						rsp, err := client.RoundTrip(request)
+						Expect(err).ToNot(HaveOccurred())
+						rspChan <- rsp
+					}()
+					Eventually(getContinueChan).ShouldNot(BeNil())
+					Consistently(func() bool { return request.Body.(*mockBody).closed }).Should(BeFalse())
+					close(getContinueChan())
+					Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+					Expect(dataStream.dataWritten.Bytes()).To(Equal(requestBody))
+					injectResponse(5, response)
+					Eventually(rspChan).Should(Receive(Equal(response)))
+				})
+
+				It("sends the body when the timeout expires", func() {
+					client.opts.ExpectContinueTimeout = 100 * time.Millisecond
+					rspChan := make(chan *http.Response)
+					go func() {
+						defer GinkgoRecover()
+						rsp, err := client.RoundTrip(request)
+						Expect(err).ToNot(HaveOccurred())
+						rspChan <- rsp
+					}()
+					Eventually(getContinueChan).ShouldNot(BeNil())
+					Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+					Expect(dataStream.dataWritten.Bytes()).To(Equal(requestBody))
+					injectResponse(5, response)
+					Eventually(rspChan).Should(Receive(Equal(response)))
+				})
+
+				It("doesn't send the body if the final response arrives first", func() {
+					client.opts.ExpectContinueTimeout = time.Hour
+					response.StatusCode = 417
+					rspChan := make(chan *http.Response)
+					go func() {
+						defer GinkgoRecover()
+						rsp, err := client.RoundTrip(request)
+						Expect(err).ToNot(HaveOccurred())
+						rspChan <- rsp
+					}()
+					injectResponse(5, response)
+					Eventually(rspChan).Should(Receive(Equal(response)))
+					Expect(request.Body.(*mockBody).closed).To(BeTrue())
+					Expect(dataStream.canceledWrite).To(BeTrue())
+					Expect(dataStream.dataWritten.Bytes()).To(BeEmpty())
+				})
+			})
+
+			Context("CONNECT requests", func() {
+				var pw *io.PipeWriter
+
+				BeforeEach(func() {
+					var pr *io.PipeReader
+					pr, pw = io.Pipe()
+					request.Method = "CONNECT"
+					request.Host = "target.clemente.io:443"
+					request.Body = pr
+				})
+
+				It("returns the response before the request body was sent", func() {
+					rspChan := make(chan *http.Response)
+					go func() {
+						defer GinkgoRecover()
+						rsp, err := client.RoundTrip(request)
+						Expect(err).ToNot(HaveOccurred())
+						rspChan <- rsp
+					}()
+					Eventually(func() []byte { return headerStream.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+					hfs := getHeaderFields(getRequest(headerStream.dataWritten.Bytes()))
+					Expect(hfs).To(HaveKeyWithValue(":method", "CONNECT"))
+					Expect(hfs).To(HaveKeyWithValue(":authority", "target.clemente.io:443"))
+					Expect(hfs).ToNot(HaveKey("accept-encoding"))
+					injectResponse(5, response)
+					var rsp *http.Response
+					Eventually(rspChan).Should(Receive(&rsp))
+					Expect(rsp.Body).To(BeAssignableToTypeOf(&responseBody{}))
+					Expect(dataStream.closed).To(BeFalse())
+					// the tunnel is closed when the request body is closed
+					_, err := pw.Write([]byte("foobar"))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(pw.Close()).To(Succeed())
+					Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+					Expect(dataStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+					Expect(dataStream.canceledWrite).To(BeFalse())
+				})
+
+				It("stops sending if the server refuses to establish the tunnel", func() {
+					response.StatusCode = 403
+					rspChan := make(chan *http.Response)
+					go func() {
+						defer GinkgoRecover()
+						rsp, err := client.RoundTrip(request)
+						Expect(err).ToNot(HaveOccurred())
+						rspChan <- rsp
+					}()
+					injectResponse(5, response)
+					Eventually(rspChan).Should(Receive())
+					Expect(dataStream.canceledWrite).To(BeTrue())
+					pw.Close()
+				})
+			})
+
+			It("doesn't buffer the body", func() {
+				const size = 100 << 20 // 100 MB
+				stream := &discardingStream{mockStream: newMockStream(5)}
+				req, err := http.NewRequest("POST", "https://quic.clemente.io:1337/upload", ioutil.NopCloser(io.LimitReader(zeroReader{}, size)))
+				Expect(err).ToNot(HaveOccurred())
+				req.ContentLength = size
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				Expect(client.writeRequestBody(req, stream)).To(Succeed())
+				runtime.ReadMemStats(&after)
+				Expect(stream.bytesWritten).To(BeEquivalentTo(size))
+				Expect(stream.closed).To(BeTrue())
+				Expect(after.TotalAlloc - before.TotalAlloc).To(BeNumerically("<", 64<<10))
+			})
+
+			It("sends the trailers after the body", func() {
+				request.Trailer = http.Header{"Foo": []string{"bar"}}
+				rspChan := make(chan *http.Response)
+				go func() {
+					defer GinkgoRecover()
+					rsp, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					rspChan <- rsp
+				}()
+				injectResponse(5, response)
+				Eventually(rspChan).Should(Receive())
+				Expect(dataStream.dataWritten.Bytes()).To(Equal(requestBody))
+
+				decoder := hpack.NewDecoder(4096, nil)
+				h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				frame, err := h2framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				fields, err := decoder.DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: "trailer", Value: "Foo"}))
+				frame, err = h2framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				hframe := frame.(*http2.HeadersFrame)
+				Expect(hframe.StreamID).To(BeEquivalentTo(5))
+				Expect(hframe.StreamEnded()).To(BeTrue())
+				fields, err = decoder.DecodeFull(hframe.HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(Equal([]hpack.HeaderField{{Name: "foo", Value: "bar"}}))
+			})
+		})
+
+		Context("response trailers", func() {
+			var response *http.Response
+
+			BeforeEach(func() {
+				response = &http.Response{
+					StatusCode: 200,
+					Header:     http.Header{},
+					Trailer:    http.Header{"Foo": nil},
+				}
+				// fake a handshake
+				client.dialOnce.Do(func() {})
+				session.streamsToOpen = []quic.Stream{dataStream}
+				dataStream.dataToRead.Write([]byte("foobar"))
+				close(dataStream.unblockRead)
+			})
+
+			getTrailerChan := func() chan http.Header {
+				client.mutex.Lock()
+				defer client.mutex.Unlock()
+				return client.trailers[5]
+			}
+
+			It("populates the trailers when the body was read", func() {
+				rspChan := make(chan *http.Response)
+				go func() {
+					defer GinkgoRecover()
+					rsp, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					rspChan <- rsp
+				}()
+				injectResponse(5, response)
+				var rsp *http.Response
+				Eventually(rspChan).Should(Receive(&rsp))
+				getTrailerChan() <- http.Header{"Foo": []string{"bar"}}
+				body, err := ioutil.ReadAll(rsp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(body).To(Equal([]byte("foobar")))
+				Expect(rsp.Trailer).To(Equal(http.Header{"Foo": []string{"bar"}}))
+			})
+
+			It("returns an error if the header stream fails while waiting for the trailers", func() {
+				rspChan := make(chan *http.Response)
+				go func() {
+					defer GinkgoRecover()
+					rsp, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					rspChan <- rsp
+				}()
+				injectResponse(5, response)
+				var rsp *http.Response
+				Eventually(rspChan).Should(Receive(&rsp))
+				client.headerErr = qerr.Error(qerr.InvalidHeadersStreamData, "test error")
+				close(client.headerErrored)
+				_, err := ioutil.ReadAll(rsp.Body)
+				Expect(err).To(MatchError(client.headerErr))
+			})
+
+			It("doesn't wait for trailers if the response didn't announce any", func() {
+				response.Trailer = nil
+				rspChan := make(chan *http.Response)
+				go func() {
+					defer GinkgoRecover()
+					rsp, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					rspChan <- rsp
+				}()
+				injectResponse(5, response)
+				var rsp *http.Response
+				Eventually(rspChan).Should(Receive(&rsp))
+				Expect(getTrailerChan()).To(BeNil())
+				body, err := ioutil.ReadAll(rsp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(body).To(Equal([]byte("foobar")))
+			})
 		})
 
 		Context("gzip compression", func() {
@@ -498,6 +992,7 @@ var _ = Describe("Client", func() {
 					Expect(rsp.ContentLength).To(BeEquivalentTo(-1))
 					Expect(rsp.Header.Get("Content-Encoding")).To(BeEmpty())
 					Expect(rsp.Header.Get("Content-Length")).To(BeEmpty())
+					Expect(rsp.Uncompressed).To(BeTrue())
 					data := make([]byte, 6)
 					_, err = io.ReadFull(rsp.Body, data)
 					Expect(err).ToNot(HaveOccurred())
@@ -532,6 +1027,50 @@ var _ = Describe("Client", func() {
 				Eventually(done).Should(BeClosed())
 			})
 
+			It("matches the content-encoding header case-insensitively", func() {
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					rsp, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(rsp.Uncompressed).To(BeTrue())
+					data := make([]byte, 6)
+					_, err = io.ReadFull(rsp.Body, data)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(data).To(Equal([]byte("foobar")))
+					close(done)
+				}()
+
+				dataStream.dataToRead.Write(gzippedData)
+				response.Header.Add("Content-Encoding", "GZIP")
+				injectResponse(5, response)
+				close(dataStream.unblockRead)
+				Eventually(done).Should(BeClosed())
+			})
+
+			doesntRequestGzip := func(req *http.Request) {
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, err := client.RoundTrip(req)
+					Expect(err).ToNot(HaveOccurred())
+					close(done)
+				}()
+				Eventually(func() []byte { return headerStream.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+				headers := getHeaderFields(getRequest(headerStream.dataWritten.Bytes()))
+				Expect(headers).ToNot(HaveKey("accept-encoding"))
+				injectResponse(5, &http.Response{})
+				Eventually(done).Should(BeClosed())
+			}
+
+			It("doesn't request gzip for HEAD requests", func() {
+				doesntRequestGzip(&http.Request{Method: "HEAD", URL: request.URL, Header: http.Header{}})
+			})
+
+			It("doesn't request gzip for Range requests", func() {
+				doesntRequestGzip(&http.Request{Method: "GET", URL: request.URL, Header: http.Header{"Range": []string{"bytes=0-100"}}})
+			})
+
 			It("only decompresses the response if the response contains the right content-encoding header", func() {
 				done := make(chan struct{})
 				go func() {
@@ -542,6 +1081,7 @@ var _ = Describe("Client", func() {
 					data := make([]byte, 11)
 					rsp.Body.Read(data)
 					Expect(rsp.ContentLength).ToNot(BeEquivalentTo(-1))
+					Expect(rsp.Uncompressed).To(BeFalse())
 					Expect(data).To(Equal([]byte("not gzipped")))
 					close(done)
 				}()
@@ -584,14 +1124,20 @@ var _ = Describe("Client", func() {
 				client.responses[23] = make(chan *http.Response)
 			})
 
+			readResponse := func() error {
+				decoder := hpack.NewDecoder(4096, nil)
+				return client.readResponse(newFrameReader(&headerStream.dataToRead, decoder, maxHeaderListSize(client.opts.MaxHeaderBytes)), decoder)
+			}
+
 			It("reads header values from a response", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				data := []byte{0x48, 0x03, 0x33, 0x30, 0x32, 0x58, 0x07, 0x70, 0x72, 0x69, 0x76, 0x61, 0x74, 0x65, 0x61, 0x1d, 0x4d, 0x6f, 0x6e, 0x2c, 0x20, 0x32, 0x31, 0x20, 0x4f, 0x63, 0x74, 0x20, 0x32, 0x30, 0x31, 0x33, 0x20, 0x32, 0x30, 0x3a, 0x31, 0x33, 0x3a, 0x32, 0x31, 0x20, 0x47, 0x4d, 0x54, 0x6e, 0x17, 0x68, 0x74, 0x74, 0x70, 0x73, 0x3a, 0x2f, 0x2f, 0x77, 0x77, 0x77, 0x2e, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d}
 				headerStream.dataToRead.Write([]byte{0x0, 0x0, byte(len(data)), 0x1, 0x5, 0x0, 0x0, 0x0, 23})
 				headerStream.dataToRead.Write(data)
+				rspChan := client.responses[23]
 				go client.handleHeaderStream()
 				var rsp *http.Response
-				Eventually(client.responses[23]).Should(Receive(&rsp))
+				Eventually(rspChan).Should(Receive(&rsp))
 				Expect(rsp).ToNot(BeNil())
 				Expect(rsp.Proto).To(Equal("HTTP/2.0"))
 				Expect(rsp.ProtoMajor).To(BeEquivalentTo(2))
@@ -620,20 +1166,456 @@ var _ = Describe("Client", func() {
 				Expect(client.headerErr.ErrorMessage).To(ContainSubstring("cannot read header fields"))
 			})
 
-			It("errors if the stream cannot be found", func() {
+			Context("server push", func() {
+				writePushPromise := func(path string, fields ...hpack.HeaderField) {
+					var headers bytes.Buffer
+					enc := hpack.NewEncoder(&headers)
+					enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+					enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+					enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "quic.clemente.io:1337"})
+					enc.WriteField(hpack.HeaderField{Name: ":path", Value: path})
+					for _, hf := range fields {
+						enc.WriteField(hf)
+					}
+					Expect(h2framer.WritePushPromise(http2.PushPromiseParam{
+						StreamID:      23,
+						PromiseID:     7,
+						EndHeaders:    true,
+						BlockFragment: headers.Bytes(),
+					})).To(Succeed())
+				}
+
+				writeResponse := func(id uint32, fields ...hpack.HeaderField) {
+					var headers bytes.Buffer
+					enc := hpack.NewEncoder(&headers)
+					enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+					for _, hf := range fields {
+						enc.WriteField(hf)
+					}
+					Expect(h2framer.WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      id,
+						EndHeaders:    true,
+						BlockFragment: headers.Bytes(),
+					})).To(Succeed())
+				}
+
+				BeforeEach(func() {
+					// the session was already set up in the outer BeforeEach
+					client.dialOnce.Do(func() {})
+				})
+
+				It("uses a pushed response for a subsequent request", func() {
+					pushedStream := newMockStream(7)
+					session.dataStream = pushedStream
+					writePushPromise("/style.css")
+					writeResponse(7)
+					go client.handleHeaderStream()
+					Eventually(func() int {
+						client.mutex.Lock()
+						defer client.mutex.Unlock()
+						for _, p := range client.pushPromises {
+							return len(p.responseChan)
+						}
+						return 0
+					}).Should(Equal(1))
+
+					req, err := http.NewRequest("GET", "https://quic.clemente.io:1337/style.css", nil)
+					Expect(err).ToNot(HaveOccurred())
+					rsp, err := client.RoundTrip(req)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(rsp.StatusCode).To(Equal(200))
+					Expect(rsp.Request).To(Equal(req))
+					Expect(rsp.Body.(*responseBody).Stream).To(Equal(pushedStream))
+					// we don't send anything on the pushed stream
+					Expect(pushedStream.closed).To(BeTrue())
+					// no request was sent
+					Expect(headerStream.dataWritten.Len()).To(BeZero())
+					Expect(client.pushPromises).To(BeEmpty())
+					Expect(client.responses).ToNot(HaveKey(protocol.StreamID(7)))
+				})
+
+				Context("gzip compression", func() {
+					var pushedStream *mockStream
+
+					BeforeEach(func() {
+						pushedStream = newMockStream(7)
+						var b bytes.Buffer
+						w := gzip.NewWriter(&b)
+						w.Write([]byte("foobar"))
+						w.Close()
+						pushedStream.dataToRead.Write(b.Bytes())
+						close(pushedStream.unblockRead)
+						session.dataStream = pushedStream
+					})
+
+					getPushedResponse := func(req *http.Request) *http.Response {
+						go client.handleHeaderStream()
+						Eventually(func() int {
+							client.mutex.Lock()
+							defer client.mutex.Unlock()
+							for _, p := range client.pushPromises {
+								return len(p.responseChan)
+							}
+							return 0
+						}).Should(Equal(1))
+						rsp, err := client.RoundTrip(req)
+						Expect(err).ToNot(HaveOccurred())
+						return rsp
+					}
+
+					It("decompresses pushed responses, if the promised request accepted gzip", func() {
+						writePushPromise("/style.css", hpack.HeaderField{Name: "accept-encoding", Value: "gzip"})
+						writeResponse(7, hpack.HeaderField{Name: "content-encoding", Value: "gzip"})
+						req, err := http.NewRequest("GET", "https://quic.clemente.io:1337/style.css", nil)
+						Expect(err).ToNot(HaveOccurred())
+						rsp := getPushedResponse(req)
+						Expect(rsp.Uncompressed).To(BeTrue())
+						Expect(rsp.Header.Get("Content-Encoding")).To(BeEmpty())
+						body, err := ioutil.ReadAll(rsp.Body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(body).To(Equal([]byte("foobar")))
+					})
+
+					It("doesn't decompress pushed responses, if the request set the Accept-Encoding", func() {
+						writePushPromise("/style.css", hpack.HeaderField{Name: "accept-encoding", Value: "gzip"})
+						writeResponse(7, hpack.HeaderField{Name: "content-encoding", Value: "gzip"})
+						req, err := http.NewRequest("GET", "https://quic.clemente.io:1337/style.css", nil)
+						Expect(err).ToNot(HaveOccurred())
+						req.Header.Set("Accept-Encoding", "gzip")
+						rsp := getPushedResponse(req)
+						Expect(rsp.Uncompressed).To(BeFalse())
+						Expect(rsp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+					})
+
+					It("doesn't decompress pushed responses, if compression is disabled", func() {
+						client.opts.DisableCompression = true
+						writePushPromise("/style.css", hpack.HeaderField{Name: "accept-encoding", Value: "gzip"})
+						writeResponse(7, hpack.HeaderField{Name: "content-encoding", Value: "gzip"})
+						req, err := http.NewRequest("GET", "https://quic.clemente.io:1337/style.css", nil)
+						Expect(err).ToNot(HaveOccurred())
+						rsp := getPushedResponse(req)
+						Expect(rsp.Uncompressed).To(BeFalse())
+					})
+				})
+
+				It("waits for the headers of the pushed response", func() {
+					session.dataStream = newMockStream(7)
+					writePushPromise("/style.css")
+					go client.handleHeaderStream()
+					Eventually(func() int {
+						client.mutex.Lock()
+						defer client.mutex.Unlock()
+						return len(client.pushPromises)
+					}).Should(Equal(1))
+
+					done := make(chan struct{})
+					go func() {
+						defer GinkgoRecover()
+						req, err := http.NewRequest("GET", "https://quic.clemente.io:1337/style.css", nil)
+						Expect(err).ToNot(HaveOccurred())
+						rsp, err := client.RoundTrip(req)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(rsp.StatusCode).To(Equal(200))
+						close(done)
+					}()
+					Consistently(done).ShouldNot(BeClosed())
+					client.mutex.Lock()
+					client.responses[7] <- &http.Response{StatusCode: 200, Header: http.Header{}}
+					client.mutex.Unlock()
+					Eventually(done).Should(BeClosed())
+				})
+
+				It("doesn't use pushed responses for other URLs", func() {
+					writePushPromise("/style.css")
+					go client.handleHeaderStream()
+					Eventually(func() int {
+						client.mutex.Lock()
+						defer client.mutex.Unlock()
+						return len(client.pushPromises)
+					}).Should(Equal(1))
+					client.mutex.Lock()
+					defer client.mutex.Unlock()
+					Expect(client.pushPromises).To(HaveKey(pushPromiseKey("GET", "quic.clemente.io:1337", "/style.css")))
+					Expect(client.pushPromises).ToNot(HaveKey(pushPromiseKey("GET", "quic.clemente.io:1337", "/script.js")))
+					Expect(client.pushPromises).ToNot(HaveKey(pushPromiseKey("HEAD", "quic.clemente.io:1337", "/style.css")))
+				})
+
+				It("refuses a PUSH_PROMISE with a header list larger than the MaxHeaderBytes", func() {
+					pushedStream := newMockStream(7)
+					session.dataStream = pushedStream
+					client.opts.MaxHeaderBytes = 100
+					writePushPromise("/" + strings.Repeat("a", 60))
+					writeResponse(7)
+					go client.handleHeaderStream()
+					Eventually(func() bool { return pushedStream.canceledRead }).Should(BeTrue())
+					Expect(pushedStream.closed).To(BeTrue())
+					Consistently(client.headerErrored).ShouldNot(BeClosed())
+					client.mutex.Lock()
+					defer client.mutex.Unlock()
+					Expect(client.pushPromises).To(BeEmpty())
+					Expect(client.responses).ToNot(HaveKey(protocol.StreamID(7)))
+				})
+
+				It("refuses a PUSH_PROMISE if push is disabled", func() {
+					pushedStream := newMockStream(7)
+					session.dataStream = pushedStream
+					client.opts.DisablePush = true
+					writePushPromise("/style.css")
+					writeResponse(7)
+					go client.handleHeaderStream()
+					Eventually(func() bool { return pushedStream.canceledRead }).Should(BeTrue())
+					Expect(pushedStream.closed).To(BeTrue())
+					Consistently(client.headerErrored).ShouldNot(BeClosed())
+					client.mutex.Lock()
+					defer client.mutex.Unlock()
+					Expect(client.pushPromises).To(BeEmpty())
+					Expect(client.responses).ToNot(HaveKey(protocol.StreamID(7)))
+				})
+
+				It("refuses a PUSH_PROMISE if there are too many pushed responses that weren't requested", func() {
+					pushedStream := newMockStream(7)
+					session.dataStream = pushedStream
+					for i := 0; i < maxPushPromises; i++ {
+						client.pushPromises[fmt.Sprintf("%d", i)] = &pushPromise{
+							streamID: protocol.StreamID(1001 + 2*i),
+							expiry:   time.Now().Add(time.Hour),
+						}
+					}
+					writePushPromise("/style.css")
+					go client.handleHeaderStream()
+					Eventually(func() bool { return pushedStream.canceledRead }).Should(BeTrue())
+					Consistently(client.headerErrored).ShouldNot(BeClosed())
+					client.mutex.Lock()
+					defer client.mutex.Unlock()
+					Expect(client.pushPromises).To(HaveLen(maxPushPromises))
+					Expect(client.pushPromises).ToNot(HaveKey(pushPromiseKey("GET", "quic.clemente.io:1337", "/style.css")))
+					Expect(client.responses).ToNot(HaveKey(protocol.StreamID(7)))
+				})
+
+				It("discards pushed responses that weren't requested in time", func() {
+					pushedStream := newMockStream(7)
+					session.dataStream = pushedStream
+					writePushPromise("/style.css")
+					go client.handleHeaderStream()
+					Eventually(func() int {
+						client.mutex.Lock()
+						defer client.mutex.Unlock()
+						return len(client.pushPromises)
+					}).Should(Equal(1))
+					client.mutex.Lock()
+					defer client.mutex.Unlock()
+					for _, p := range client.pushPromises {
+						Expect(p.expiry).To(BeTemporally("~", time.Now().Add(pushPromiseTimeout), time.Second))
+						p.expiry = time.Now().Add(-time.Second)
+					}
+					client.expirePushPromises()
+					Expect(client.pushPromises).To(BeEmpty())
+					Expect(client.responses).ToNot(HaveKey(protocol.StreamID(7)))
+					Expect(client.trailers).ToNot(HaveKey(protocol.StreamID(7)))
+					Expect(pushedStream.canceledRead).To(BeTrue())
+				})
+
+				It("discards the pushed response when the request is canceled", func() {
+					pushedStream := newMockStream(7)
+					session.dataStream = pushedStream
+					writePushPromise("/style.css")
+					go client.handleHeaderStream()
+					Eventually(func() int {
+						client.mutex.Lock()
+						defer client.mutex.Unlock()
+						return len(client.pushPromises)
+					}).Should(Equal(1))
+
+					ctx, cancel := context.WithCancel(context.Background())
+					req, err := http.NewRequest("GET", "https://quic.clemente.io:1337/style.css", nil)
+					Expect(err).ToNot(HaveOccurred())
+					req = req.WithContext(ctx)
+					done := make(chan struct{})
+					go func() {
+						defer GinkgoRecover()
+						_, err := client.RoundTrip(req)
+						Expect(err).To(MatchError(context.Canceled))
+						close(done)
+					}()
+					Consistently(done).ShouldNot(BeClosed())
+					cancel()
+					Eventually(done).Should(BeClosed())
+					Expect(pushedStream.canceledRead).To(BeTrue())
+					client.mutex.Lock()
+					defer client.mutex.Unlock()
+					Expect(client.pushPromises).To(BeEmpty())
+					Expect(client.responses).ToNot(HaveKey(protocol.StreamID(7)))
+					Expect(client.trailers).ToNot(HaveKey(protocol.StreamID(7)))
+				})
+			})
+
+			It("delivers the trailers of a response", func() {
+				trailerChan := make(chan http.Header, 1)
+				client.trailers[23] = trailerChan
+				rspChan := client.responses[23]
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				Expect(enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})).To(Succeed())
+				Expect(enc.WriteField(hpack.HeaderField{Name: "trailer", Value: "Foo"})).To(Succeed())
+				Expect(h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      23,
+					EndHeaders:    true,
+					BlockFragment: headers.Bytes(),
+				})).To(Succeed())
+				headers.Reset()
+				Expect(enc.WriteField(hpack.HeaderField{Name: "foo", Value: "bar"})).To(Succeed())
+				Expect(h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      23,
+					EndHeaders:    true,
+					EndStream:     true,
+					BlockFragment: headers.Bytes(),
+				})).To(Succeed())
+				go client.handleHeaderStream()
+				var rsp *http.Response
+				Eventually(rspChan).Should(Receive(&rsp))
+				Expect(rsp.Trailer).To(HaveKey("Foo"))
+				Eventually(trailerChan).Should(Receive(Equal(http.Header{"Foo": []string{"bar"}})))
+				client.mutex.Lock()
+				defer client.mutex.Unlock()
+				Expect(client.responses).To(BeEmpty())
+				Expect(client.trailers).To(BeEmpty())
+			})
+
+			Context("header list size", func() {
+				writeLargeResponse := func(endStream bool) {
+					var headers bytes.Buffer
+					enc := hpack.NewEncoder(&headers)
+					enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+					for i := 0; i < 10; i++ {
+						enc.WriteField(hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 197)})
+					}
+					Expect(h2framer.WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      23,
+						EndHeaders:    true,
+						EndStream:     endStream,
+						BlockFragment: headers.Bytes(),
+					})).To(Succeed())
+				}
+
+				It("refuses responses with header lists larger than the MaxHeaderBytes", func() {
+					client.opts.MaxHeaderBytes = 1000
+					rspChan := make(chan *http.Response, 1)
+					client.responses[23] = rspChan
+					writeLargeResponse(false)
+					Expect(readResponse()).To(Succeed())
+					Expect(rspChan).To(Receive(BeNil()))
+					Expect(client.responses).To(BeEmpty())
+				})
+
+				It("accepts responses with header lists smaller than the MaxHeaderBytes", func() {
+					client.opts.MaxHeaderBytes = 3000
+					rspChan := make(chan *http.Response, 1)
+					client.responses[23] = rspChan
+					writeLargeResponse(false)
+					Expect(readResponse()).To(Succeed())
+					var rsp *http.Response
+					Expect(rspChan).To(Receive(&rsp))
+					Expect(rsp.Header.Get("Foo")).To(HaveLen(197))
+				})
+
+				It("refuses trailers larger than the MaxHeaderBytes", func() {
+					client.opts.MaxHeaderBytes = 1000
+					delete(client.responses, 23)
+					trailerChan := make(chan http.Header, 1)
+					client.trailers[23] = trailerChan
+					writeLargeResponse(true)
+					Expect(readResponse()).To(Succeed())
+					Expect(trailerChan).To(Receive(BeNil()))
+				})
+
+				It("saves the server's SETTINGS_MAX_HEADER_LIST_SIZE", func() {
+					Expect(h2framer.WriteSettings(http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: 1337})).To(Succeed())
+					Expect(readResponse()).To(Succeed())
+					Expect(client.requestWriter.peerMaxHeaderListSize).To(BeEquivalentTo(1337))
+				})
+			})
+
+			Context("interim responses", func() {
+				writeStatus := func(status string, endStream bool) {
+					var headers bytes.Buffer
+					enc := hpack.NewEncoder(&headers)
+					enc.WriteField(hpack.HeaderField{Name: ":status", Value: status})
+					Expect(h2framer.WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      23,
+						EndHeaders:    true,
+						EndStream:     endStream,
+						BlockFragment: headers.Bytes(),
+					})).To(Succeed())
+				}
+
+				It("ignores interim responses", func() {
+					rspChan := client.responses[23]
+					writeStatus("103", false)
+					writeStatus("200", false)
+					go client.handleHeaderStream()
+					var rsp *http.Response
+					Eventually(rspChan).Should(Receive(&rsp))
+					Expect(rsp.StatusCode).To(Equal(200))
+				})
+
+				It("signals a 100 Continue response", func() {
+					continueChan := make(chan struct{})
+					client.continues[23] = continueChan
+					writeStatus("100", false)
+					Expect(readResponse()).To(Succeed())
+					Expect(continueChan).To(BeClosed())
+					Expect(client.continues).To(BeEmpty())
+					Expect(client.responses).To(HaveKey(protocol.StreamID(23)))
+				})
+
+				It("errors if an interim response ends the stream", func() {
+					writeStatus("100", true)
+					err := readResponse()
+					Expect(err).To(MatchError("interim response on stream 23 ended the stream"))
+				})
+			})
+
+			It("stops sending new requests after receiving a GOAWAY", func() {
+				Expect(h2framer.WriteGoAway(1<<31-1, http2.ErrCodeNo, nil)).To(Succeed())
+				Expect(readResponse()).To(Succeed())
+				client.dialOnce.Do(func() {}) // don't dial
+				_, err := client.RoundTrip(request)
+				Expect(err).To(MatchError(errGoAway))
+				Expect(session.streamsToOpen).To(HaveLen(2)) // no stream was opened
+			})
+
+			It("ignores HEADERS frames for unknown streams", func() {
 				var headers bytes.Buffer
 				enc := hpack.NewEncoder(&headers)
 				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
 				err := h2framer.WriteHeaders(http2.HeadersFrameParam{
-					StreamID:      1337,
+					StreamID:      1338,
 					EndHeaders:    true,
 					BlockFragment: headers.Bytes(),
 				})
 				Expect(err).ToNot(HaveOccurred())
-				client.handleHeaderStream()
-				Eventually(client.headerErrored).Should(BeClosed())
-				Expect(client.headerErr.ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
-				Expect(client.headerErr.ErrorMessage).To(ContainSubstring("response channel for stream 1337 not found"))
+				go client.handleHeaderStream()
+				Consistently(client.headerErrored).ShouldNot(BeClosed())
+			})
+
+			It("ignores HEADERS frames after the trailers", func() {
+				trailerChan := make(chan http.Header, 1)
+				client.trailers[23] = trailerChan
+				delete(client.responses, 23)
+				for i := 0; i < 2; i++ {
+					var headers bytes.Buffer
+					enc := hpack.NewEncoder(&headers)
+					enc.WriteField(hpack.HeaderField{Name: "foo", Value: "bar"})
+					Expect(h2framer.WriteHeaders(http2.HeadersFrameParam{
+						StreamID:      23,
+						EndHeaders:    true,
+						EndStream:     true,
+						BlockFragment: headers.Bytes(),
+					})).To(Succeed())
+				}
+				go client.handleHeaderStream()
+				Eventually(trailerChan).Should(Receive(Equal(http.Header{"Foo": []string{"bar"}})))
+				Consistently(client.headerErrored).ShouldNot(BeClosed())
 			})
 		})
 	})
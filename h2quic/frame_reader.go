@@ -0,0 +1,292 @@
+package h2quic
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+const frameHeaderLen = 9
+
+// The HPACK decoders use a dynamic table size of 4096 bytes.
+const hpackTableSize = 4096
+
+// evictAllEntries is a literal header field with incremental indexing that is larger than the dynamic table.
+// Adding it to the dynamic table evicts all entries (see section 4.4 of RFC 7541).
+var evictAllEntries = func() []byte {
+	// literal with incremental indexing, with an empty name, followed by the length of the value
+	b := appendHpackInt([]byte{0x40, 0x0, 0x0}, 7, hpackTableSize)
+	return append(b, make([]byte, hpackTableSize)...)
+}()
+
+// A headerBlockTooLargeError is returned by the frameReader if it skipped a header block
+// that is larger than the maximum header list size.
+// Only the stream is affected, the header stream can still be used.
+type headerBlockTooLargeError struct {
+	// the stream that the header block belongs to.
+	// For PUSH_PROMISE frames, this is the promised stream.
+	streamID      protocol.StreamID
+	isPushPromise bool
+	size          uint32
+}
+
+func (e *headerBlockTooLargeError) Error() string {
+	return fmt.Sprintf("header block for stream %d too large (%d bytes)", e.streamID, e.size)
+}
+
+// A frameReader reads HTTP/2 frames from the header stream.
+// HEADERS and PUSH_PROMISE frames larger than the maximum header list size are skipped,
+// without reading them into memory, and a headerBlockTooLargeError is returned.
+type frameReader struct {
+	r       *bufio.Reader
+	framer  *http2.Framer
+	decoder *hpack.Decoder
+	maxSize uint32
+
+	// the representation of the header field that is currently skipped
+	field []byte
+	// the number of bytes of the header block that weren't read yet
+	remaining uint32
+}
+
+func newFrameReader(str io.Reader, decoder *hpack.Decoder, maxSize uint32) *frameReader {
+	r := bufio.NewReader(str)
+	framer := http2.NewFramer(nil, r)
+	// The header block is never larger than the decoded header list, so larger frames are rejected before reading them.
+	framer.SetMaxReadFrameSize(maxSize)
+	return &frameReader{
+		r:       r,
+		framer:  framer,
+		decoder: decoder,
+		maxSize: maxSize,
+	}
+}
+
+func (r *frameReader) ReadFrame() (http2.Frame, error) {
+	hdr, err := r.r.Peek(frameHeaderLen)
+	if err != nil {
+		// let the framer return the error
+		return r.framer.ReadFrame()
+	}
+	length := uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2])
+	frameType := http2.FrameType(hdr[3])
+	if length <= r.maxSize || (frameType != http2.FrameHeaders && frameType != http2.FramePushPromise) {
+		return r.framer.ReadFrame()
+	}
+	flags := http2.Flags(hdr[4])
+	streamID := protocol.StreamID(binary.BigEndian.Uint32(hdr[5:]) & (1<<31 - 1))
+	isPushPromise := frameType == http2.FramePushPromise
+	// HEADERS and PUSH_PROMISE frames use the same values for these flags
+	if !flags.Has(http2.FlagHeadersEndHeaders) {
+		return nil, errors.New("http2 header continuation not implemented")
+	}
+	var overhead uint32
+	isPadded := flags.Has(http2.FlagHeadersPadded)
+	if isPadded {
+		overhead++
+	}
+	hasPriority := !isPushPromise && flags.Has(http2.FlagHeadersPriority)
+	if hasPriority {
+		overhead += 5
+	}
+	if isPushPromise {
+		overhead += 4
+	}
+	if length < overhead {
+		return nil, fmt.Errorf("invalid %s frame", frameType)
+	}
+	if _, err := r.r.Discard(frameHeaderLen); err != nil {
+		return nil, err
+	}
+	var padLen uint32
+	if isPadded {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		padLen = uint32(b)
+	}
+	if padLen > length-overhead {
+		return nil, fmt.Errorf("invalid padding in %s frame", frameType)
+	}
+	if hasPriority {
+		if _, err := r.r.Discard(5); err != nil {
+			return nil, err
+		}
+	}
+	if isPushPromise {
+		var b [4]byte
+		if _, err := io.ReadFull(r.r, b[:]); err != nil {
+			return nil, err
+		}
+		streamID = protocol.StreamID(binary.BigEndian.Uint32(b[:]) & (1<<31 - 1))
+	}
+	blockLen := length - overhead - padLen
+	if err := r.skipHeaderBlock(blockLen); err != nil {
+		return nil, err
+	}
+	if _, err := r.r.Discard(int(padLen)); err != nil {
+		return nil, err
+	}
+	return nil, &headerBlockTooLargeError{
+		streamID:      streamID,
+		isPushPromise: isPushPromise,
+		size:          blockLen,
+	}
+}
+
+// skipHeaderBlock reads a header block, without keeping it in memory.
+// The decoder has to stay in sync with the peer's encoder, so every header field is still passed to the decoder
+// (with emitting disabled), unless it contains a string larger than both the maximum header list size and the dynamic table.
+// Such a field is never added to the dynamic table, but if it is sent with incremental indexing, it evicts all entries.
+func (r *frameReader) skipHeaderBlock(length uint32) error {
+	r.remaining = length
+	r.decoder.SetEmitEnabled(false)
+	// evictAllEntries might be larger than the maximum header list size
+	r.decoder.SetMaxStringLength(0)
+	for r.remaining > 0 {
+		r.field = r.field[:0]
+		b, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		var isIndexed, isTooLarge bool
+		switch {
+		case b&0x80 != 0: // indexed header field
+			_, err = r.readInt(b, 7)
+		case b&0xe0 == 0x20: // dynamic table size update
+			_, err = r.readInt(b, 5)
+		case b&0xc0 == 0x40: // literal header field with incremental indexing
+			isIndexed = true
+			isTooLarge, err = r.skipLiteral(b, 6)
+		default: // literal header field without indexing, or never indexed
+			isTooLarge, err = r.skipLiteral(b, 4)
+		}
+		if err != nil {
+			return err
+		}
+		field := r.field
+		if isTooLarge {
+			if !isIndexed {
+				continue
+			}
+			field = evictAllEntries
+		}
+		if _, err := r.decoder.Write(field); err != nil {
+			return err
+		}
+	}
+	r.field = nil
+	return r.decoder.Close()
+}
+
+// skipLiteral reads a literal header field.
+// It returns true if the name or the value was discarded.
+func (r *frameReader) skipLiteral(first byte, n uint8) (bool, error) {
+	nameIndex, err := r.readInt(first, n)
+	if err != nil {
+		return false, err
+	}
+	var nameTooLarge bool
+	if nameIndex == 0 {
+		nameTooLarge, err = r.readString()
+		if err != nil {
+			return false, err
+		}
+	}
+	valueTooLarge, err := r.readString()
+	if err != nil {
+		return false, err
+	}
+	return nameTooLarge || valueTooLarge, nil
+}
+
+// readString reads a string literal.
+// Strings larger than both the maximum header list size and the dynamic table are discarded.
+func (r *frameReader) readString() (bool, error) {
+	b, err := r.readByte()
+	if err != nil {
+		return false, err
+	}
+	l, err := r.readInt(b, 7)
+	if err != nil {
+		return false, err
+	}
+	if l > uint64(r.remaining) {
+		return false, errors.New("string literal exceeds the header block")
+	}
+	r.remaining -= uint32(l)
+	maxLen := uint64(r.maxSize)
+	if maxLen < hpackTableSize {
+		maxLen = hpackTableSize
+	}
+	if l > maxLen {
+		_, err := r.r.Discard(int(l))
+		return true, err
+	}
+	start := len(r.field)
+	r.field = append(r.field, make([]byte, l)...)
+	_, err = io.ReadFull(r.r, r.field[start:])
+	return false, err
+}
+
+func (r *frameReader) readByte() (byte, error) {
+	if r.remaining == 0 {
+		return 0, errors.New("truncated header field")
+	}
+	r.remaining--
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.field = append(r.field, b)
+	return b, nil
+}
+
+// readInt reads an integer with an n-bit prefix, see section 5.1 of RFC 7541.
+func (r *frameReader) readInt(first byte, n uint8) (uint64, error) {
+	mask := uint64(1)<<n - 1
+	i := uint64(first) & mask
+	if i < mask {
+		return i, nil
+	}
+	var m uint
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		i += uint64(b&0x7f) << m
+		if b&0x80 == 0 {
+			return i, nil
+		}
+		m += 7
+		if m >= 63 {
+			return 0, errors.New("integer overflow")
+		}
+	}
+}
+
+// appendHpackInt appends an integer with an n-bit prefix, see section 5.1 of RFC 7541.
+// The prefix is or'ed into the last byte of b.
+func appendHpackInt(b []byte, n uint8, i uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if i < mask {
+		b[len(b)-1] |= byte(i)
+		return b
+	}
+	b[len(b)-1] |= byte(mask)
+	i -= mask
+	for i >= 0x80 {
+		b = append(b, byte(i&0x7f)|0x80)
+		i >>= 7
+	}
+	return append(b, byte(i))
+}
@@ -0,0 +1,141 @@
+package h2quic
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Frame Reader", func() {
+	const maxSize = 100
+
+	var (
+		buf     *bytes.Buffer
+		framer  *http2.Framer
+		encoder *hpack.Encoder
+		headers *bytes.Buffer
+		decoder *hpack.Decoder
+		r       *frameReader
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		framer = http2.NewFramer(buf, nil)
+		headers = &bytes.Buffer{}
+		encoder = hpack.NewEncoder(headers)
+		decoder = hpack.NewDecoder(4096, nil)
+		r = newFrameReader(buf, decoder, maxSize)
+	})
+
+	// writeHeaders writes a HEADERS frame with the header fields, and returns the size of the header block
+	writeHeaders := func(streamID uint32, padLength uint8, fields ...hpack.HeaderField) int {
+		headers.Reset()
+		for _, hf := range fields {
+			Expect(encoder.WriteField(hf)).To(Succeed())
+		}
+		Expect(framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      streamID,
+			EndHeaders:    true,
+			PadLength:     padLength,
+			Priority:      http2.PriorityParam{StreamDep: 1, Weight: 42},
+			BlockFragment: headers.Bytes(),
+		})).To(Succeed())
+		return headers.Len()
+	}
+
+	readHeaders := func() []hpack.HeaderField {
+		frame, err := r.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+		fields, truncated, err := decodeHeaderFields(decoder, frame.(*http2.HeadersFrame).HeaderBlockFragment(), maxSize)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(truncated).To(BeFalse())
+		return fields
+	}
+
+	It("reads frames that are smaller than the limit", func() {
+		writeHeaders(5, 0, hpack.HeaderField{Name: "foo", Value: "bar"})
+		Expect(readHeaders()).To(Equal([]hpack.HeaderField{{Name: "foo", Value: "bar"}}))
+	})
+
+	It("skips HEADERS frames larger than the limit, and keeps the decoder in sync", func() {
+		size := writeHeaders(5, 10,
+			hpack.HeaderField{Name: "foo", Value: "bar"},
+			hpack.HeaderField{Name: "large", Value: strings.Repeat("a", 200)},
+			hpack.HeaderField{Name: "huge", Value: strings.Repeat("b", 10000)},
+		)
+		// the encoder now uses the dynamic table for these fields
+		writeHeaders(7, 0,
+			hpack.HeaderField{Name: "foo", Value: "bar"},
+			hpack.HeaderField{Name: "large", Value: strings.Repeat("a", 200)},
+		)
+		_, err := r.ReadFrame()
+		Expect(err).To(Equal(&headerBlockTooLargeError{streamID: 5, size: uint32(size)}))
+		fields, truncated, err := func() ([]hpack.HeaderField, bool, error) {
+			frame, err := r.ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			return decodeHeaderFields(decoder, frame.(*http2.HeadersFrame).HeaderBlockFragment(), 1000)
+		}()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(truncated).To(BeFalse())
+		Expect(fields).To(Equal([]hpack.HeaderField{
+			{Name: "foo", Value: "bar"},
+			{Name: "large", Value: strings.Repeat("a", 200)},
+		}))
+	})
+
+	It("evicts all entries from the dynamic table for huge fields with incremental indexing", func() {
+		writeHeaders(5, 0, hpack.HeaderField{Name: "foo", Value: "bar"})
+		Expect(readHeaders()).To(Equal([]hpack.HeaderField{{Name: "foo", Value: "bar"}}))
+		// the hpack.Encoder never indexes fields larger than the dynamic table, so this needs to be encoded manually
+		block := []byte{0x40, 0x4, 'h', 'u', 'g', 'e', 0x0}
+		block = appendHpackInt(block, 7, 10000)
+		block = append(block, bytes.Repeat([]byte{'a'}, 10000)...)
+		Expect(framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 7, EndHeaders: true, BlockFragment: block})).To(Succeed())
+		// refers to the first entry of the dynamic table
+		Expect(framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 9, EndHeaders: true, BlockFragment: []byte{0xbe}})).To(Succeed())
+		_, err := r.ReadFrame()
+		Expect(err).To(Equal(&headerBlockTooLargeError{streamID: 7, size: uint32(len(block))}))
+		frame, err := r.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = decodeHeaderFields(decoder, frame.(*http2.HeadersFrame).HeaderBlockFragment(), maxSize)
+		Expect(err).To(MatchError(ContainSubstring("invalid indexed representation index 62")))
+	})
+
+	It("skips PUSH_PROMISE frames larger than the limit", func() {
+		Expect(encoder.WriteField(hpack.HeaderField{Name: "large", Value: strings.Repeat("a", 200)})).To(Succeed())
+		Expect(framer.WritePushPromise(http2.PushPromiseParam{
+			StreamID:      5,
+			PromiseID:     8,
+			EndHeaders:    true,
+			PadLength:     10,
+			BlockFragment: headers.Bytes(),
+		})).To(Succeed())
+		Expect(framer.WriteSettings()).To(Succeed())
+		_, err := r.ReadFrame()
+		Expect(err).To(Equal(&headerBlockTooLargeError{streamID: 8, isPushPromise: true, size: uint32(headers.Len())}))
+		frame, err := r.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+	})
+
+	It("errors on large header blocks that are continued in CONTINUATION frames", func() {
+		Expect(framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      5,
+			BlockFragment: bytes.Repeat([]byte{0x82}, 200),
+		})).To(Succeed())
+		_, err := r.ReadFrame()
+		Expect(err).To(MatchError("http2 header continuation not implemented"))
+	})
+
+	It("rejects other frames larger than the limit", func() {
+		Expect(framer.WriteGoAway(1, http2.ErrCodeNo, bytes.Repeat([]byte{'a'}, 200))).To(Succeed())
+		_, err := r.ReadFrame()
+		Expect(err).To(MatchError(http2.ErrFrameTooLarge))
+	})
+})
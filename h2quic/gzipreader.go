@@ -6,9 +6,12 @@ package h2quic
 // call gzip.NewReader on the first call to Read
 import (
 	"compress/gzip"
+	"errors"
 	"io"
 )
 
+var errReadOnClosedResBody = errors.New("http: read on closed response body")
+
 // call gzip.NewReader on the first call to Read
 type gzipReader struct {
 	body io.ReadCloser // underlying Response.Body
@@ -31,5 +34,9 @@ func (gz *gzipReader) Read(p []byte) (n int, err error) {
 }
 
 func (gz *gzipReader) Close() error {
-	return gz.body.Close()
+	if err := gz.body.Close(); err != nil {
+		return err
+	}
+	gz.zerr = errReadOnClosedResBody
+	return nil
 }
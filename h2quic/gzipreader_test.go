@@ -0,0 +1,47 @@
+package h2quic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gzip Reader", func() {
+	var body *mockBody
+
+	BeforeEach(func() {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		w.Write([]byte("foobar"))
+		w.Close()
+		body = &mockBody{}
+		body.SetData(b.Bytes())
+	})
+
+	It("decompresses the body", func() {
+		data, err := ioutil.ReadAll(&gzipReader{body: body})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+	})
+
+	It("returns an error for invalid data", func() {
+		body.SetData([]byte("not gzipped"))
+		gz := &gzipReader{body: body}
+		_, err := gz.Read(make([]byte, 6))
+		Expect(err).To(HaveOccurred())
+		// the error is sticky
+		_, err2 := gz.Read(make([]byte, 6))
+		Expect(err2).To(Equal(err))
+	})
+
+	It("errors when reading after Close", func() {
+		gz := &gzipReader{body: body}
+		Expect(gz.Close()).To(Succeed())
+		Expect(body.closed).To(BeTrue())
+		_, err := gz.Read(make([]byte, 6))
+		Expect(err).To(MatchError(errReadOnClosedResBody))
+	})
+})
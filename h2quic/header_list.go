@@ -0,0 +1,68 @@
+package h2quic
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"golang.org/x/net/http2/hpack"
+)
+
+// errorCodeRefusedStream is used to reset streams if the header list is too large (QUIC_REFUSED_STREAM)
+const errorCodeRefusedStream quic.ErrorCode = 8
+
+var errRequestHeaderListSize = errors.New("h2quic: request header list larger than peer's advertised limit")
+
+// maxHeaderListSize converts a MaxHeaderBytes setting to the limit on the size of a header list.
+// If zero, http.DefaultMaxHeaderBytes is used.
+func maxHeaderListSize(maxHeaderBytes int) uint32 {
+	if maxHeaderBytes <= 0 {
+		return http.DefaultMaxHeaderBytes
+	}
+	if int64(maxHeaderBytes) > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(maxHeaderBytes)
+}
+
+// decodeHeaderFields decodes a header block.
+// The size of the header list is calculated as defined in section 6.5.2 of RFC 7540.
+// Once it exceeds maxSize, the decoded fields are discarded and truncated is true.
+// The block is still decoded completely, so that the decoder stays in sync with the peer's encoder,
+// but the header fields aren't emitted any more. This is the same as what http2.Framer does.
+func decodeHeaderFields(decoder *hpack.Decoder, block []byte, maxSize uint32) (fields []hpack.HeaderField, truncated bool, err error) {
+	var size uint32
+	decoder.SetEmitEnabled(true)
+	// A single string larger than the limit can't be discarded without decoding it.
+	// This leaves the decoder in an inconsistent state, so the caller has to close the session.
+	decoder.SetMaxStringLength(int(maxSize))
+	decoder.SetEmitFunc(func(hf hpack.HeaderField) {
+		size += hf.Size()
+		if size > maxSize {
+			decoder.SetEmitEnabled(false)
+			fields = nil
+			truncated = true
+			return
+		}
+		fields = append(fields, hf)
+	})
+	defer decoder.SetEmitFunc(func(hpack.HeaderField) {})
+
+	if _, err := decoder.Write(block); err != nil {
+		return nil, false, err
+	}
+	if err := decoder.Close(); err != nil {
+		return nil, false, err
+	}
+	return fields, truncated, nil
+}
+
+// headerListSize calculates the size of a header list, as defined in section 6.5.2 of RFC 7540.
+func headerListSize(fields []hpack.HeaderField) uint32 {
+	var size uint32
+	for _, hf := range fields {
+		size += hf.Size()
+	}
+	return size
+}
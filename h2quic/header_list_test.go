@@ -0,0 +1,77 @@
+package h2quic
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Header lists", func() {
+	var (
+		encoder *hpack.Encoder
+		buf     *bytes.Buffer
+		decoder *hpack.Decoder
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		encoder = hpack.NewEncoder(buf)
+		decoder = hpack.NewDecoder(4096, nil)
+	})
+
+	encode := func(fields ...hpack.HeaderField) []byte {
+		buf.Reset()
+		for _, hf := range fields {
+			Expect(encoder.WriteField(hf)).To(Succeed())
+		}
+		return append([]byte{}, buf.Bytes()...)
+	}
+
+	It("uses http.DefaultMaxHeaderBytes by default", func() {
+		Expect(maxHeaderListSize(0)).To(BeEquivalentTo(http.DefaultMaxHeaderBytes))
+		Expect(maxHeaderListSize(-1)).To(BeEquivalentTo(http.DefaultMaxHeaderBytes))
+		Expect(maxHeaderListSize(1337)).To(BeEquivalentTo(1337))
+	})
+
+	It("decodes header fields", func() {
+		fields := []hpack.HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: "foo", Value: "bar"},
+		}
+		decoded, truncated, err := decodeHeaderFields(decoder, encode(fields...), 1000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(truncated).To(BeFalse())
+		Expect(decoded).To(Equal(fields))
+		Expect(headerListSize(decoded)).To(BeEquivalentTo(len(":status200") + len("foobar") + 2*32))
+	})
+
+	It("discards header fields exceeding the limit, and stays in sync with the encoder", func() {
+		value := strings.Repeat("a", 1000)
+		// The field is added to the dynamic table, and then referenced 100 times.
+		var fields []hpack.HeaderField
+		for i := 0; i < 100; i++ {
+			fields = append(fields, hpack.HeaderField{Name: "foo", Value: value})
+		}
+		block := encode(fields...)
+		Expect(len(block)).To(BeNumerically("<", 2000))
+		decoded, truncated, err := decodeHeaderFields(decoder, block, 10000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(truncated).To(BeTrue())
+		Expect(decoded).To(BeEmpty())
+		// the next header block references the dynamic table
+		decoded, truncated, err = decodeHeaderFields(decoder, encode(hpack.HeaderField{Name: "foo", Value: value}), 10000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(truncated).To(BeFalse())
+		Expect(decoded).To(Equal([]hpack.HeaderField{{Name: "foo", Value: value}}))
+	})
+
+	It("errors if a single string exceeds the limit", func() {
+		_, _, err := decodeHeaderFields(decoder, encode(hpack.HeaderField{Name: "cookie", Value: strings.Repeat("a", 1000)}), 100)
+		Expect(err).To(MatchError(hpack.ErrStringLength))
+	})
+})
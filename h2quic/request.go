@@ -3,6 +3,7 @@ package h2quic
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -32,21 +33,39 @@ func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
 		}
 	}
 
+	trailer := declaredTrailers(httpHeaders["Trailer"])
+	delete(httpHeaders, "Trailer")
+
 	// concatenate cookie headers, see https://tools.ietf.org/html/rfc6265#section-5.4
 	if len(httpHeaders["Cookie"]) > 0 {
 		httpHeaders.Set("Cookie", strings.Join(httpHeaders["Cookie"], "; "))
 	}
 
-	if len(path) == 0 || len(authority) == 0 || len(method) == 0 {
-		return nil, errors.New(":path, :authority and :method must not be empty")
-	}
-
-	u, err := url.Parse(path)
-	if err != nil {
-		return nil, err
+	var u *url.URL
+	var requestURI string
+	var contentLength int64
+	var err error
+	if method == "CONNECT" {
+		// CONNECT requests only contain the authority, see section 8.3 of RFC 7540.
+		// As in net/http, the authority is used as the RequestURI.
+		if len(path) != 0 || len(authority) == 0 {
+			return nil, errors.New("CONNECT requests must contain an :authority, and no :path")
+		}
+		u = &url.URL{Host: authority}
+		requestURI = authority
+		// the request body is the data sent through the tunnel
+		contentLength = -1
+	} else {
+		if len(path) == 0 || len(authority) == 0 || len(method) == 0 {
+			return nil, errors.New(":path, :authority and :method must not be empty")
+		}
+		u, err = url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		requestURI = path
 	}
 
-	var contentLength int64
 	if len(contentLengthStr) > 0 {
 		contentLength, err = strconv.ParseInt(contentLengthStr, 10, 64)
 		if err != nil {
@@ -61,14 +80,76 @@ func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
 		ProtoMajor:    2,
 		ProtoMinor:    0,
 		Header:        httpHeaders,
+		Trailer:       trailer,
 		Body:          nil,
 		ContentLength: contentLength,
 		Host:          authority,
-		RequestURI:    path,
+		RequestURI:    requestURI,
 		TLS:           &tls.ConnectionState{},
 	}, nil
 }
 
+// pushPromiseHeaders returns the header fields of the request promised in a PUSH_PROMISE frame.
+// The target is either an absolute https URL, or an absolute path, in which case the authority of the request is used.
+// These checks are taken from the http2 server.
+func pushPromiseHeaders(req *http.Request, target string, opts *http.PushOptions) ([]hpack.HeaderField, error) {
+	if opts == nil {
+		opts = &http.PushOptions{}
+	}
+	method := opts.Method
+	if method == "" {
+		method = "GET"
+	}
+	if method != "GET" && method != "HEAD" {
+		return nil, fmt.Errorf("h2quic: method %q must be GET or HEAD", method)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		if !strings.HasPrefix(target, "/") {
+			return nil, fmt.Errorf("h2quic: target must be an absolute URL or an absolute path: %q", target)
+		}
+		u.Scheme = "https"
+		u.Host = req.Host
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("h2quic: cannot push URL with scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("h2quic: URL must have a host")
+	}
+
+	headers := []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: u.Scheme},
+		{Name: ":authority", Value: u.Host},
+		{Name: ":path", Value: u.RequestURI()},
+	}
+	for k, vv := range opts.Header {
+		lowKey := strings.ToLower(k)
+		if strings.HasPrefix(lowKey, ":") {
+			return nil, fmt.Errorf("h2quic: promised request headers cannot include pseudo header %q", k)
+		}
+		// These headers are meaningful only if the request has a body,
+		// but PUSH_PROMISE requests cannot have a body.
+		switch lowKey {
+		case "content-length", "content-encoding", "trailer", "te", "expect", "host":
+			return nil, fmt.Errorf("h2quic: promised request headers cannot include %q", k)
+		}
+		for _, v := range vv {
+			headers = append(headers, hpack.HeaderField{Name: lowKey, Value: v})
+		}
+	}
+	return headers, nil
+}
+
+// expectsContinue says if the sender of a request waits for a 100 Continue response before sending the body.
+func expectsContinue(header http.Header) bool {
+	return strings.EqualFold(header.Get("Expect"), "100-continue")
+}
+
 func hostnameFromRequest(req *http.Request) string {
 	if req.URL != nil {
 		return req.URL.Host
@@ -2,6 +2,7 @@ package h2quic
 
 import (
 	"io"
+	"net/http"
 
 	quic "github.com/lucas-clemente/quic-go"
 )
@@ -9,18 +10,45 @@ import (
 type requestBody struct {
 	requestRead bool
 	dataStream  quic.Stream
+	// onFirstRead, if set, is called before the body is read for the first time.
+	// It is used to send a 100 Continue response.
+	onFirstRead func()
+
+	// If the request announced trailers, they are received on the trailerChan,
+	// and are copied to the http.Request.Trailer once the body was read completely.
+	trailer     http.Header
+	trailerChan <-chan http.Header
 }
 
 // make sure the requestBody can be used as a http.Request.Body
 var _ io.ReadCloser = &requestBody{}
 
-func newRequestBody(stream quic.Stream) *requestBody {
-	return &requestBody{dataStream: stream}
+func newRequestBody(stream quic.Stream, trailer http.Header, trailerChan <-chan http.Header) *requestBody {
+	return &requestBody{
+		dataStream:  stream,
+		trailer:     trailer,
+		trailerChan: trailerChan,
+	}
 }
 
 func (b *requestBody) Read(p []byte) (int, error) {
+	if !b.requestRead && b.onFirstRead != nil {
+		b.onFirstRead()
+	}
 	b.requestRead = true
-	return b.dataStream.Read(p)
+	n, err := b.dataStream.Read(p)
+	if err == io.EOF && b.trailerChan != nil {
+		select {
+		case trailer := <-b.trailerChan:
+			for k, v := range trailer {
+				b.trailer[k] = v
+			}
+			b.trailerChan = nil
+		case <-b.dataStream.Context().Done():
+			return n, b.dataStream.Context().Err()
+		}
+	}
+	return n, err
 }
 
 func (b *requestBody) Close() error {
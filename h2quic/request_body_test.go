@@ -1,6 +1,10 @@
 package h2quic
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -14,12 +18,12 @@ var _ = Describe("Request body", func() {
 	BeforeEach(func() {
 		stream = &mockStream{}
 		stream.dataToRead.Write([]byte("foobar")) // provides data to be read
-		rb = newRequestBody(stream)
+		rb = newRequestBody(stream, nil, nil)
 	})
 
 	It("reads from the stream", func() {
 		b := make([]byte, 10)
-		n, _ := stream.Read(b)
+		n, _ := rb.Read(b)
 		Expect(n).To(Equal(6))
 		Expect(b[0:6]).To(Equal([]byte("foobar")))
 	})
@@ -30,10 +34,60 @@ var _ = Describe("Request body", func() {
 		Expect(rb.requestRead).To(BeTrue())
 	})
 
+	It("calls the onFirstRead callback before the first read", func() {
+		var calls int
+		rb.onFirstRead = func() {
+			Expect(rb.requestRead).To(BeFalse())
+			calls++
+		}
+		rb.Read(make([]byte, 1))
+		rb.Read(make([]byte, 1))
+		Expect(calls).To(Equal(1))
+	})
+
 	It("doesn't close the stream when closing the request body", func() {
 		Expect(stream.closed).To(BeFalse())
 		err := rb.Close()
 		Expect(err).ToNot(HaveOccurred())
 		Expect(stream.closed).To(BeFalse())
 	})
+
+	Context("trailers", func() {
+		var (
+			trailer     http.Header
+			trailerChan chan http.Header
+		)
+
+		BeforeEach(func() {
+			stream = newMockStream(5)
+			stream.dataToRead.Write([]byte("foobar"))
+			close(stream.unblockRead)
+			trailer = http.Header{"Foo": nil}
+			trailerChan = make(chan http.Header, 1)
+			rb = newRequestBody(stream, trailer, trailerChan)
+		})
+
+		It("waits for the trailers when reaching the end of the body", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				data, err := ioutil.ReadAll(rb)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+				Expect(trailer).To(Equal(http.Header{"Foo": []string{"foo"}}))
+				close(done)
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			trailerChan <- http.Header{"Foo": []string{"foo"}}
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("stops waiting for the trailers when the stream's context is canceled", func() {
+			_, err := rb.Read(make([]byte, 6))
+			Expect(err).ToNot(HaveOccurred())
+			stream.ctxCancel()
+			_, err = rb.Read(make([]byte, 6))
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
 })
@@ -65,6 +65,20 @@ var _ = Describe("Request", func() {
 		}))
 	})
 
+	It("parses the announced trailers", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "POST"},
+			{Name: "trailer", Value: "foo, Bar"},
+			{Name: "trailer", Value: "content-length"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Header).To(BeEmpty())
+		Expect(req.Trailer).To(Equal(http.Header{"Foo": nil, "Bar": nil}))
+	})
+
 	It("errors with missing path", func() {
 		headers := []hpack.HeaderField{
 			{Name: ":authority", Value: "quic.clemente.io"},
@@ -74,6 +88,31 @@ var _ = Describe("Request", func() {
 		Expect(err).To(MatchError(":path, :authority and :method must not be empty"))
 	})
 
+	It("parses CONNECT requests", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":authority", Value: "quic.clemente.io:443"},
+			{Name: ":method", Value: "CONNECT"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Method).To(Equal("CONNECT"))
+		Expect(req.URL.Host).To(Equal("quic.clemente.io:443"))
+		Expect(req.URL.Path).To(BeEmpty())
+		Expect(req.Host).To(Equal("quic.clemente.io:443"))
+		Expect(req.RequestURI).To(Equal("quic.clemente.io:443"))
+		Expect(req.ContentLength).To(BeEquivalentTo(-1))
+	})
+
+	It("errors with a path in a CONNECT request", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io:443"},
+			{Name: ":method", Value: "CONNECT"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError("CONNECT requests must contain an :authority, and no :path"))
+	})
+
 	It("errors with missing method", func() {
 		headers := []hpack.HeaderField{
 			{Name: ":path", Value: "/foo"},
@@ -92,6 +131,63 @@ var _ = Describe("Request", func() {
 		Expect(err).To(MatchError(":path, :authority and :method must not be empty"))
 	})
 
+	Context("generating the headers of promised requests", func() {
+		req := &http.Request{Host: "quic.clemente.io"}
+
+		It("uses the authority of the request for paths", func() {
+			headers, err := pushPromiseHeaders(req, "/style.css?foo=bar", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers).To(Equal([]hpack.HeaderField{
+				{Name: ":method", Value: "GET"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":authority", Value: "quic.clemente.io"},
+				{Name: ":path", Value: "/style.css?foo=bar"},
+			}))
+		})
+
+		It("uses absolute URLs", func() {
+			headers, err := pushPromiseHeaders(req, "https://static.clemente.io/style.css", &http.PushOptions{Method: "HEAD"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers).To(Equal([]hpack.HeaderField{
+				{Name: ":method", Value: "HEAD"},
+				{Name: ":scheme", Value: "https"},
+				{Name: ":authority", Value: "static.clemente.io"},
+				{Name: ":path", Value: "/style.css"},
+			}))
+		})
+
+		It("adds the headers", func() {
+			headers, err := pushPromiseHeaders(req, "/style.css", &http.PushOptions{Header: http.Header{"Accept-Encoding": []string{"gzip"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers).To(ContainElement(hpack.HeaderField{Name: "accept-encoding", Value: "gzip"}))
+		})
+
+		It("only allows GET and HEAD", func() {
+			_, err := pushPromiseHeaders(req, "/style.css", &http.PushOptions{Method: "POST"})
+			Expect(err).To(MatchError(`h2quic: method "POST" must be GET or HEAD`))
+		})
+
+		It("rejects relative paths", func() {
+			_, err := pushPromiseHeaders(req, "style.css", nil)
+			Expect(err).To(MatchError(`h2quic: target must be an absolute URL or an absolute path: "style.css"`))
+		})
+
+		It("rejects URLs that don't use https", func() {
+			_, err := pushPromiseHeaders(req, "http://quic.clemente.io/style.css", nil)
+			Expect(err).To(MatchError(`h2quic: cannot push URL with scheme "http"`))
+		})
+
+		It("rejects headers that only make sense for requests with a body", func() {
+			_, err := pushPromiseHeaders(req, "/style.css", &http.PushOptions{Header: http.Header{"Content-Length": []string{"42"}}})
+			Expect(err).To(MatchError(`h2quic: promised request headers cannot include "Content-Length"`))
+		})
+
+		It("rejects pseudo headers", func() {
+			_, err := pushPromiseHeaders(req, "/style.css", &http.PushOptions{Header: http.Header{":path": []string{"/foo"}}})
+			Expect(err).To(MatchError(`h2quic: promised request headers cannot include pseudo header ":path"`))
+		})
+	})
+
 	Context("extracting the hostname from a request", func() {
 		var url *url.URL
 
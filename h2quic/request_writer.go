@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,11 +25,22 @@ type requestWriter struct {
 	henc *hpack.Encoder
 	hbuf bytes.Buffer // HPACK encoder writes into this
 
+	// peerMaxHeaderListSize is the server's SETTINGS_MAX_HEADER_LIST_SIZE, or 0 if the server didn't send it
+	peerMaxHeaderListSize uint32
+
 	logger utils.Logger
 }
 
 const defaultUserAgent = "quic-go"
 
+// A headerEncodingError is returned by WriteRequest if the request headers can't be encoded.
+// Nothing was written on the header stream in that case.
+type headerEncodingError struct {
+	err error
+}
+
+func (e *headerEncodingError) Error() string { return e.err.Error() }
+
 func newRequestWriter(headerStream quic.Stream, logger utils.Logger) *requestWriter {
 	rw := &requestWriter{
 		headerStream: headerStream,
@@ -39,14 +51,25 @@ func newRequestWriter(headerStream quic.Stream, logger utils.Logger) *requestWri
 }
 
 func (w *requestWriter) WriteRequest(req *http.Request, dataStreamID protocol.StreamID, endStream, requestGzip bool) error {
-	// TODO: add support for trailers
 	// TODO: add support for gzip compression
 	// TODO: write continuation frames, if the header frame is too long
 
+	// Trailers are sent after the request body, so a request without a body can't have trailers.
+	var trailers string
+	if !endStream {
+		var err error
+		trailers, err = commaSeparatedTrailers(req)
+		if err != nil {
+			return err
+		}
+	}
+
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	w.encodeHeaders(req, requestGzip, "", actualContentLength(req))
+	if _, err := w.encodeHeaders(req, requestGzip, trailers, actualContentLength(req)); err != nil {
+		return &headerEncodingError{err}
+	}
 	h2framer := http2.NewFramer(w.headerStream, nil)
 	return h2framer.WriteHeaders(http2.HeadersFrameParam{
 		StreamID:      uint32(dataStreamID),
@@ -57,6 +80,44 @@ func (w *requestWriter) WriteRequest(req *http.Request, dataStreamID protocol.St
 	})
 }
 
+// WriteTrailers writes the request trailers on the header stream.
+// It must be called after the request body was written.
+func (w *requestWriter) WriteTrailers(req *http.Request, dataStreamID protocol.StreamID) error {
+	keys := make([]string, 0, len(req.Trailer))
+	for k := range req.Trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.hbuf.Reset()
+	encodeTrailers(w.henc, keys, req.Trailer)
+	h2framer := http2.NewFramer(w.headerStream, nil)
+	return h2framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      uint32(dataStreamID),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: w.hbuf.Bytes(),
+	})
+}
+
+// WriteSettings writes a SETTINGS frame on the header stream.
+func (w *requestWriter) WriteSettings(settings ...http2.Setting) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return http2.NewFramer(w.headerStream, nil).WriteSettings(settings...)
+}
+
+// SetPeerMaxHeaderListSize sets the limit on the size of the request header list advertised by the server.
+func (w *requestWriter) SetPeerMaxHeaderListSize(size uint32) {
+	w.mutex.Lock()
+	w.peerMaxHeaderListSize = size
+	w.mutex.Unlock()
+}
+
 // the rest of this files is copied from http2.Transport
 func (w *requestWriter) encodeHeaders(req *http.Request, addGzipHeader bool, trailers string, contentLength int64) ([]byte, error) {
 	w.hbuf.Reset()
@@ -99,6 +160,23 @@ func (w *requestWriter) encodeHeaders(req *http.Request, addGzipHeader bool, tra
 		}
 	}
 
+	if w.peerMaxHeaderListSize > 0 {
+		// The header list size is calculated before encoding anything,
+		// so that the hpack state isn't polluted by a request that's not sent.
+		size := hpack.HeaderField{Name: ":authority", Value: host}.Size() +
+			hpack.HeaderField{Name: ":method", Value: req.Method}.Size() +
+			hpack.HeaderField{Name: ":path", Value: path}.Size() +
+			hpack.HeaderField{Name: ":scheme", Value: req.URL.Scheme}.Size()
+		for k, vv := range req.Header {
+			for _, v := range vv {
+				size += hpack.HeaderField{Name: k, Value: v}.Size()
+			}
+		}
+		if size > w.peerMaxHeaderListSize {
+			return nil, errRequestHeaderListSize
+		}
+	}
+
 	// 8.1.2.3 Request Pseudo-Header Fields
 	// The :path pseudo-header field includes the path and query parts of the
 	// target URI (the path-absolute production and optionally a '?' character
@@ -56,6 +56,34 @@ var _ = Describe("Request", func() {
 		Expect(headerFields).ToNot(HaveKey("accept-encoding"))
 	})
 
+	It("writes a CONNECT request", func() {
+		req, err := http.NewRequest("CONNECT", "https://proxy.clemente.io", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Host = "quic.clemente.io:443"
+		rw.WriteRequest(req, 1337, false, false)
+		_, headerFields := decode(headerStream.dataWritten.Bytes())
+		Expect(headerFields).To(HaveKeyWithValue(":authority", "quic.clemente.io:443"))
+		Expect(headerFields).To(HaveKeyWithValue(":method", "CONNECT"))
+		Expect(headerFields).ToNot(HaveKey(":path"))
+		Expect(headerFields).ToNot(HaveKey(":scheme"))
+	})
+
+	It("refuses to write requests with header lists larger than the server's limit", func() {
+		rw.SetPeerMaxHeaderListSize(1000)
+		req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Cookie", strings.Repeat("a", 1000))
+		err = rw.WriteRequest(req, 1337, true, false)
+		Expect(err).To(BeAssignableToTypeOf(&headerEncodingError{}))
+		Expect(err.(*headerEncodingError).err).To(MatchError(errRequestHeaderListSize))
+		Expect(headerStream.dataWritten.Bytes()).To(BeEmpty())
+		// the hpack state wasn't changed, so the next request can still be decoded
+		req.Header.Set("Cookie", "foo")
+		Expect(rw.WriteRequest(req, 1339, true, false)).To(Succeed())
+		_, headerFields := decode(headerStream.dataWritten.Bytes())
+		Expect(headerFields).To(HaveKeyWithValue("cookie", "foo"))
+	})
+
 	It("sets the EndStream header", func() {
 		req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
 		Expect(err).ToNot(HaveOccurred())
@@ -111,4 +139,52 @@ var _ = Describe("Request", func() {
 		_, headerFields := decode(headerStream.dataWritten.Bytes())
 		Expect(headerFields).To(HaveKeyWithValue("cookie", `Cookie #1="Value #1"; Cookie #2="Value #2"`))
 	})
+
+	Context("trailers", func() {
+		It("announces the trailers", func() {
+			req, err := http.NewRequest("POST", "https://quic.clemente.io/", strings.NewReader("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			req.Trailer = http.Header{"foo": nil, "Bar": nil}
+			Expect(rw.WriteRequest(req, 5, false, false)).To(Succeed())
+			_, headerFields := decode(headerStream.dataWritten.Bytes())
+			Expect(headerFields).To(HaveKeyWithValue("trailer", "Bar,Foo"))
+		})
+
+		It("doesn't announce trailers for requests without a body", func() {
+			req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Trailer = http.Header{"Foo": nil}
+			Expect(rw.WriteRequest(req, 5, true, false)).To(Succeed())
+			_, headerFields := decode(headerStream.dataWritten.Bytes())
+			Expect(headerFields).ToNot(HaveKey("trailer"))
+		})
+
+		It("rejects invalid trailers", func() {
+			req, err := http.NewRequest("POST", "https://quic.clemente.io/", strings.NewReader("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			req.Trailer = http.Header{"Content-Length": nil}
+			Expect(rw.WriteRequest(req, 5, false, false)).To(MatchError(`invalid Trailer key "Content-Length"`))
+			Expect(headerStream.dataWritten.Len()).To(BeZero())
+		})
+
+		It("writes the trailers", func() {
+			req, err := http.NewRequest("POST", "https://quic.clemente.io/", strings.NewReader("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			req.Trailer = http.Header{"Foo": []string{"foo"}, "Bar": []string{"bar1", "bar2"}}
+			Expect(rw.WriteTrailers(req, 5)).To(Succeed())
+			framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+			frame, err := framer.ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			headerFrame := frame.(*http2.HeadersFrame)
+			Expect(headerFrame.StreamID).To(BeEquivalentTo(5))
+			Expect(headerFrame.StreamEnded()).To(BeTrue())
+			fields, err := decoder.DecodeFull(headerFrame.HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fields).To(Equal([]hpack.HeaderField{
+				{Name: "bar", Value: "bar1"},
+				{Name: "bar", Value: "bar2"},
+				{Name: "foo", Value: "foo"},
+			}))
+		})
+	})
 })
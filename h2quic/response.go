@@ -32,8 +32,6 @@ func responseFromHeaders(f *http2.MetaHeadersFrame) (*http.Response, error) {
 		return nil, errors.New("malformed non-numeric status pseudo header")
 	}
 
-	// TODO: handle statusCode == 100
-
 	header := make(http.Header)
 	res := &http.Response{
 		Proto:      "HTTP/2.0",
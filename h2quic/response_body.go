@@ -1,18 +1,91 @@
 package h2quic
 
 import (
+	"context"
 	"io"
+	"sync"
 
 	quic "github.com/lucas-clemente/quic-go"
 )
 
 type responseBody struct {
 	quic.Stream
+
+	// readTrailers is called once the body was read completely.
+	// It is nil if the response didn't announce any trailers.
+	readTrailers func() error
+
+	// If the request is canceled, cancelErr is set and canceled is closed.
+	canceled  chan struct{}
+	cancelErr error
+
+	// done is closed when the body was read completely, or when it is closed
+	done     chan struct{}
+	doneOnce sync.Once
 }
 
 var _ io.ReadCloser = &responseBody{}
 
+// newResponseBody creates the body of a response.
+// When the request is canceled, either by canceling its context or by closing the cancel channel,
+// the stream is reset, and Read returns the respective error.
+func newResponseBody(ctx context.Context, str quic.Stream, cancel <-chan struct{}) *responseBody {
+	rb := &responseBody{
+		Stream:   str,
+		canceled: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			rb.cancelErr = ctx.Err()
+		case <-cancel:
+			rb.cancelErr = errRequestCanceled
+		case <-rb.done:
+			return
+		}
+		// the body might have been completed concurrently
+		select {
+		case <-rb.done:
+			return
+		default:
+		}
+		close(rb.canceled)
+		// error code 6 signals that stream was canceled
+		str.CancelRead(6)
+		str.CancelWrite(6)
+	}()
+	return rb
+}
+
+func (rb *responseBody) Read(b []byte) (int, error) {
+	n, err := rb.Stream.Read(b)
+	if err != nil && err != io.EOF {
+		select {
+		case <-rb.canceled:
+			err = rb.cancelErr
+		default:
+		}
+	}
+	if err == io.EOF && rb.readTrailers != nil {
+		if err := rb.readTrailers(); err != nil {
+			rb.setDone()
+			return n, err
+		}
+		rb.readTrailers = nil
+	}
+	if err != nil {
+		rb.setDone()
+	}
+	return n, err
+}
+
 func (rb *responseBody) Close() error {
 	rb.Stream.CancelRead(0)
+	rb.setDone()
 	return nil
 }
+
+func (rb *responseBody) setDone() {
+	rb.doneOnce.Do(func() { close(rb.done) })
+}
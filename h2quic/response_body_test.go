@@ -2,6 +2,9 @@ package h2quic
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -15,7 +18,7 @@ var _ = Describe("Response Body", func() {
 
 	BeforeEach(func() {
 		stream = newMockStream(42)
-		body = &responseBody{stream}
+		body = newResponseBody(context.Background(), stream, nil)
 	})
 
 	It("calls CancelRead when closing", func() {
@@ -26,4 +29,70 @@ var _ = Describe("Response Body", func() {
 		Expect(body.Close()).To(Succeed())
 		Expect(stream.canceledRead).To(BeTrue())
 	})
+
+	Context("canceling the request", func() {
+		It("resets the stream when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			body = newResponseBody(ctx, stream, nil)
+			cancel()
+			Eventually(func() bool { return stream.canceledRead }).Should(BeTrue())
+			Eventually(func() bool { return stream.canceledWrite }).Should(BeTrue())
+			stream.readErr = errors.New("read canceled")
+			_, err := body.Read(make([]byte, 3))
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		It("resets the stream when the cancel channel is closed", func() {
+			cancel := make(chan struct{})
+			body = newResponseBody(context.Background(), stream, cancel)
+			close(cancel)
+			Eventually(func() bool { return stream.canceledRead }).Should(BeTrue())
+			Eventually(func() bool { return stream.canceledWrite }).Should(BeTrue())
+			stream.readErr = errors.New("read canceled")
+			_, err := body.Read(make([]byte, 3))
+			Expect(err).To(MatchError(errRequestCanceled))
+		})
+
+		It("doesn't reset the stream after the body was read", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			body = newResponseBody(ctx, stream, nil)
+			stream.dataToRead = *bytes.NewBuffer([]byte("foobar"))
+			close(stream.unblockRead)
+			data, err := ioutil.ReadAll(body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+			cancel()
+			Consistently(func() bool { return stream.canceledRead }).Should(BeFalse())
+		})
+
+		It("doesn't reset the stream after the body was closed", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			body = newResponseBody(ctx, stream, nil)
+			Expect(body.Close()).To(Succeed())
+			cancel()
+			Consistently(func() bool { return stream.canceledWrite }).Should(BeFalse())
+		})
+	})
+
+	It("reads the trailers at the end of the body", func() {
+		stream.dataToRead = *bytes.NewBuffer([]byte("foobar"))
+		close(stream.unblockRead)
+		var readTrailers bool
+		body.readTrailers = func() error {
+			readTrailers = true
+			return nil
+		}
+		data, err := ioutil.ReadAll(body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+		Expect(readTrailers).To(BeTrue())
+	})
+
+	It("returns the error that occurred when reading the trailers", func() {
+		close(stream.unblockRead)
+		testErr := errors.New("test error")
+		body.readTrailers = func() error { return testErr }
+		_, err := body.Read(make([]byte, 3))
+		Expect(err).To(MatchError(testErr))
+	})
 })
@@ -2,7 +2,10 @@ package h2quic
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,10 +27,45 @@ type responseWriter struct {
 	header        http.Header
 	status        int // status code passed to WriteHeader
 	headerWritten bool
+	// trailers are the names of the trailers announced in the Trailer header
+	trailers []string
+
+	// push promises and serves a pushed response.
+	// It is nil for pushed responses, since these can't push any other resources.
+	push        func(target string, opts *http.PushOptions) error
+	handlerDone utils.AtomicBool
+
+	session  quic.Session
+	hijacked bool
+
+	// peerMaxHeaderListSize is the client's SETTINGS_MAX_HEADER_LIST_SIZE, or 0 if the client didn't send it
+	peerMaxHeaderListSize uint32
 
 	logger utils.Logger
 }
 
+var (
+	errPushDisabled      = fmt.Errorf("h2quic: client disabled push: %w", http.ErrNotSupported)
+	errRecursivePush     = fmt.Errorf("h2quic: pushed responses can't push: %w", http.ErrNotSupported)
+	errPushAfterResponse = fmt.Errorf("h2quic: push after the response was completed: %w", http.ErrNotSupported)
+
+	errAlreadyHijacked     = errors.New("h2quic: stream already hijacked")
+	errHijackAfterResponse = errors.New("h2quic: hijack after the response was completed")
+)
+
+// The StreamHijacker interface is implemented by the http.ResponseWriter of the h2quic server.
+// It allows handlers to take over the QUIC stream of a request,
+// e.g. to use it for a different protocol after the HTTP exchange.
+type StreamHijacker interface {
+	// HijackStream takes over the data stream of the request, and returns it along with the QUIC session.
+	// The response headers are sent before the stream is returned. If WriteHeader wasn't called yet, the status is 200.
+	// After HijackStream was called, the h2quic server doesn't use the stream any more:
+	// Writes to the http.ResponseWriter return http.ErrHijacked, no trailers are sent,
+	// and the stream is not closed when the handler returns. Closing the stream is the caller's responsibility.
+	// Reading from the request body reads from the data stream.
+	HijackStream() (quic.Stream, quic.Session, error)
+}
+
 func newResponseWriter(
 	headerStream quic.Stream,
 	headerStreamMutex *sync.Mutex,
@@ -55,16 +93,31 @@ func (w *responseWriter) WriteHeader(status int) {
 	}
 	w.headerWritten = true
 	w.status = status
+	for k := range declaredTrailers(w.header["Trailer"]) {
+		w.trailers = append(w.trailers, k)
+	}
+	sort.Strings(w.trailers)
 
-	var headers bytes.Buffer
-	enc := hpack.NewEncoder(&headers)
-	enc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
-
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(status)}}
 	for k, v := range w.header {
 		for index := range v {
-			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v[index]})
+			fields = append(fields, hpack.HeaderField{Name: strings.ToLower(k), Value: v[index]})
 		}
 	}
+	if w.peerMaxHeaderListSize > 0 && headerListSize(fields) > w.peerMaxHeaderListSize {
+		// The client would refuse the response. Send a 500 instead, and abort the response body.
+		w.logger.Errorf("Response header list exceeds the client's limit of %d bytes. Sending status 500 on data stream %d.", w.peerMaxHeaderListSize, w.dataStreamID)
+		w.status = http.StatusInternalServerError
+		w.trailers = nil
+		fields = []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(w.status)}}
+		w.dataStream.CancelWrite(errorCodeRefusedStream)
+	}
+
+	var headers bytes.Buffer
+	enc := hpack.NewEncoder(&headers)
+	for _, hf := range fields {
+		enc.WriteField(hf)
+	}
 
 	w.logger.Infof("Responding with %d", status)
 	w.headerStreamMutex.Lock()
@@ -80,7 +133,55 @@ func (w *responseWriter) WriteHeader(status int) {
 	}
 }
 
+// writeContinue sends a 100 Continue response, unless the response headers were already sent.
+func (w *responseWriter) writeContinue() {
+	if w.headerWritten {
+		return
+	}
+	var headers bytes.Buffer
+	enc := hpack.NewEncoder(&headers)
+	enc.WriteField(hpack.HeaderField{Name: ":status", Value: "100"})
+
+	w.headerStreamMutex.Lock()
+	defer w.headerStreamMutex.Unlock()
+	h2framer := http2.NewFramer(w.headerStream, nil)
+	err := h2framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      uint32(w.dataStreamID),
+		EndHeaders:    true,
+		BlockFragment: headers.Bytes(),
+	})
+	if err != nil {
+		w.logger.Errorf("could not write h2 header: %s", err.Error())
+	}
+}
+
+// writeTrailers sends the values of the announced trailers in a HEADERS frame.
+// It is called after the handler returned.
+func (w *responseWriter) writeTrailers() {
+	if len(w.trailers) == 0 {
+		return
+	}
+	var headers bytes.Buffer
+	encodeTrailers(hpack.NewEncoder(&headers), w.trailers, w.header)
+
+	w.headerStreamMutex.Lock()
+	defer w.headerStreamMutex.Unlock()
+	h2framer := http2.NewFramer(w.headerStream, nil)
+	err := h2framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      uint32(w.dataStreamID),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: headers.Bytes(),
+	})
+	if err != nil {
+		w.logger.Errorf("could not write h2 trailers: %s", err.Error())
+	}
+}
+
 func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
 	if !w.headerWritten {
 		w.WriteHeader(200)
 	}
@@ -92,11 +193,42 @@ func (w *responseWriter) Write(p []byte) (int, error) {
 
 func (w *responseWriter) Flush() {}
 
+// Push initiates a server push.
+// The returned errors wrap http.ErrNotSupported if the client disabled push,
+// if this is a pushed response, or if the handler already returned.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if w.push == nil {
+		return errRecursivePush
+	}
+	if w.handlerDone.Get() {
+		return errPushAfterResponse
+	}
+	return w.push(target, opts)
+}
+
+// HijackStream takes over the data stream.
+// It must not be called after the handler returned.
+func (w *responseWriter) HijackStream() (quic.Stream, quic.Session, error) {
+	if w.hijacked {
+		return nil, nil, errAlreadyHijacked
+	}
+	if w.handlerDone.Get() {
+		return nil, nil, errHijackAfterResponse
+	}
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+	w.hijacked = true
+	return w.dataStream, w.session, nil
+}
+
 // This is a NOP. Use http.Request.Context
 func (w *responseWriter) CloseNotify() <-chan bool { return make(<-chan bool) }
 
-// test that we implement http.Flusher
+// test that we implement http.Flusher and http.Pusher
 var _ http.Flusher = &responseWriter{}
+var _ http.Pusher = &responseWriter{}
+var _ StreamHijacker = &responseWriter{}
 
 // copied from http2/http2.go
 // bodyAllowedForStatus reports whether a given response status code
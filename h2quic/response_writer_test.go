@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -26,6 +27,8 @@ type mockStream struct {
 	canceledWrite bool
 	closed        bool
 	remoteClosed  bool
+	readErr       error
+	writeErr      error
 
 	unblockRead chan struct{}
 	ctx         context.Context
@@ -52,8 +55,26 @@ func (s *mockStream) Context() context.Context              { return s.ctx }
 func (s *mockStream) SetDeadline(time.Time) error           { panic("not implemented") }
 func (s *mockStream) SetReadDeadline(time.Time) error       { panic("not implemented") }
 func (s *mockStream) SetWriteDeadline(time.Time) error      { panic("not implemented") }
+func (s *mockStream) SetPriority(int)                       { panic("not implemented") }
+func (s *mockStream) TryWrite([]byte) (int, error)          { panic("not implemented") }
+func (s *mockStream) WriteVectors([][]byte) (int, error)    { panic("not implemented") }
+func (s *mockStream) Stats() quic.StreamStats               { panic("not implemented") }
+func (s *mockStream) Peek(int) ([]byte, error)              { panic("not implemented") }
+func (s *mockStream) Discard(int) (int, error)              { panic("not implemented") }
+func (s *mockStream) LocalAddr() net.Addr                   { panic("not implemented") }
+func (s *mockStream) RemoteAddr() net.Addr                  { panic("not implemented") }
+func (s *mockStream) SendStreamState() quic.SendStreamState { panic("not implemented") }
+func (s *mockStream) ReceiveStreamState() quic.ReceiveStreamState {
+	panic("not implemented")
+}
+func (s *mockStream) SetWriteBufferOwnership(quic.BufferOwnership, func([]byte)) error {
+	panic("not implemented")
+}
 
 func (s *mockStream) Read(p []byte) (int, error) {
+	if s.readErr != nil {
+		return 0, s.readErr
+	}
 	n, _ := s.dataToRead.Read(p)
 	if n == 0 { // block if there's no data
 		<-s.unblockRead
@@ -61,7 +82,12 @@ func (s *mockStream) Read(p []byte) (int, error) {
 	}
 	return n, nil // never return an EOF
 }
-func (s *mockStream) Write(p []byte) (int, error) { return s.dataWritten.Write(p) }
+func (s *mockStream) Write(p []byte) (int, error) {
+	if s.writeErr != nil {
+		return 0, s.writeErr
+	}
+	return s.dataWritten.Write(p)
+}
 
 var _ = Describe("Response Writer", func() {
 	var (
@@ -160,4 +186,78 @@ var _ = Describe("Response Writer", func() {
 		Expect(err).To(MatchError(http.ErrBodyNotAllowed))
 		Expect(dataStream.dataWritten.Bytes()).To(HaveLen(0))
 	})
+
+	Context("hijacking", func() {
+		It("hijacks the stream", func() {
+			session := newMockSession()
+			w.session = session
+			w.WriteHeader(http.StatusSwitchingProtocols)
+			str, sess, err := w.HijackStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str).To(Equal(dataStream))
+			Expect(sess).To(Equal(session))
+			Expect(decodeHeaderFields()).To(HaveKeyWithValue(":status", []string{"101"}))
+			_, err = w.Write([]byte("foobar"))
+			Expect(err).To(MatchError(http.ErrHijacked))
+			Expect(dataStream.dataWritten.Bytes()).To(BeEmpty())
+		})
+
+		It("sends the status 200 if no status was set", func() {
+			_, _, err := w.HijackStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decodeHeaderFields()).To(HaveKeyWithValue(":status", []string{"200"}))
+		})
+
+		It("doesn't hijack twice", func() {
+			_, _, err := w.HijackStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = w.HijackStream()
+			Expect(err).To(MatchError(errAlreadyHijacked))
+		})
+
+		It("doesn't hijack after the handler returned", func() {
+			w.handlerDone.Set(true)
+			_, _, err := w.HijackStream()
+			Expect(err).To(MatchError(errHijackAfterResponse))
+		})
+	})
+
+	Context("trailers", func() {
+		It("writes the announced trailers", func() {
+			w.Header().Set("Trailer", "Foo, Bar")
+			w.Header().Set("Foo", "foo") // set before the body was written
+			_, err := w.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			w.Header().Set("Bar", "bar")
+			w.Header().Set("Baz", "not announced")
+			w.writeTrailers()
+
+			fields := decodeHeaderFields()
+			Expect(fields).To(HaveKeyWithValue("trailer", []string{"Foo, Bar"}))
+			Expect(fields).To(HaveKeyWithValue("foo", []string{"foo"}))
+			h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+			_, err = h2framer.ReadFrame() // the HEADERS frame containing the response headers
+			Expect(err).ToNot(HaveOccurred())
+			frame, err := h2framer.ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+			hframe := frame.(*http2.HeadersFrame)
+			Expect(hframe.StreamID).To(BeEquivalentTo(5))
+			Expect(hframe.StreamEnded()).To(BeTrue())
+			trailers, err := hpack.NewDecoder(4096, nil).DecodeFull(hframe.HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trailers).To(Equal([]hpack.HeaderField{
+				{Name: "bar", Value: "bar"},
+				{Name: "foo", Value: "foo"},
+			}))
+		})
+
+		It("doesn't write trailers if none were announced", func() {
+			w.WriteHeader(200)
+			w.Header().Set("Foo", "foo")
+			l := headerStream.dataWritten.Len()
+			w.writeTrailers()
+			Expect(headerStream.dataWritten.Len()).To(Equal(l))
+		})
+	})
 })
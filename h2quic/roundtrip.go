@@ -1,15 +1,19 @@
 package h2quic
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/internal/utils"
 
 	"golang.org/x/net/http/httpguts"
 )
@@ -19,6 +23,26 @@ type roundTripCloser interface {
 	io.Closer
 }
 
+// A sessionClient does the requests to one host on a single session.
+type sessionClient interface {
+	roundTripCloser
+	// isClosed says if the client can't be used for new requests any more,
+	// because dialing failed, or because the session was closed.
+	isClosed() bool
+	// isDialed says if the session was established.
+	isDialed() bool
+}
+
+// A pooledClient is a client cached by the RoundTripper.
+type pooledClient struct {
+	sessionClient
+
+	// The following fields are protected by the RoundTripper's mutex.
+	activeRequests int
+	idleSince      time.Time   // when the last active request completed
+	idleTimer      *time.Timer // closes the client after the IdleConnTimeout
+}
+
 // RoundTripper implements the http.RoundTripper interface
 type RoundTripper struct {
 	mutex sync.Mutex
@@ -33,6 +57,23 @@ type RoundTripper struct {
 	// uncompressed.
 	DisableCompression bool
 
+	// DisablePush, if true, tells the server not to push any responses.
+	// Otherwise, pushed responses are used for subsequent GET and HEAD requests for the same URL.
+	DisablePush bool
+
+	// ExpectContinueTimeout is the amount of time to wait for a server's 100 Continue response,
+	// if the request has an "Expect: 100-continue" header.
+	// The request body is sent when the 100 Continue response arrives, or when the timeout expires.
+	// If the server sends the final response first, the request body is not sent.
+	// If zero, a timeout of 1 second is used.
+	ExpectContinueTimeout time.Duration
+
+	// MaxHeaderBytes limits the size of the response headers (and trailers) received from the server.
+	// It is sent to the server in the SETTINGS_MAX_HEADER_LIST_SIZE setting.
+	// Responses with larger header lists are reset, and the request fails.
+	// If zero, http.DefaultMaxHeaderBytes is used.
+	MaxHeaderBytes int
+
 	// TLSClientConfig specifies the TLS configuration to use with
 	// tls.Client. If nil, the default configuration is used.
 	TLSClientConfig *tls.Config
@@ -43,12 +84,50 @@ type RoundTripper struct {
 
 	// Dial specifies an optional dial function for creating QUIC
 	// connections for requests.
-	// If Dial is nil, quic.DialAddr will be used.
-	Dial func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
+	// It is called for every new session, with the context of the request that triggered dialing,
+	// and the address (host:port) of the request URL.
+	// The ServerName of the tls.Config passed to Dial is set to the host of the request URL,
+	// unless the TLSClientConfig sets a ServerName. This allows connecting to a different
+	// address (e.g. a specific IP, or a relay), while verifying the certificate for the requested host.
+	// If Dial is nil, quic.DialAddrContext will be used.
+	Dial func(ctx context.Context, network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
 
-	clients map[string]roundTripCloser
+	// IdleConnTimeout is the maximum amount of time a session without any active requests
+	// is kept open before it is closed.
+	// Zero means no limit.
+	IdleConnTimeout time.Duration
+
+	// MaxIdleConns controls the maximum number of sessions without any active requests,
+	// across all hosts. When this limit is exceeded, the sessions that were idle for the longest time are closed.
+	// If zero, at most 100 idle sessions are kept.
+	MaxIdleConns int
+
+	// Fallback, if set, is used for requests that can't be sent using QUIC,
+	// because the QUIC handshake failed (see HandshakeFailedError).
+	// This allows falling back to HTTP over TCP if QUIC is blocked on the path to the server.
+	Fallback http.RoundTripper
+
+	// FallbackDuration is the duration for which QUIC isn't used for a host after the QUIC handshake failed.
+	// During that time, requests to the host are sent using the Fallback,
+	// or fail with a HandshakeFailedError if no Fallback is set.
+	// If zero, a duration of 5 minutes is used.
+	FallbackDuration time.Duration
+
+	clients map[string]*pooledClient
+	// brokenHosts are the hosts that the QUIC handshake recently failed for
+	brokenHosts map[string]*brokenHost
 }
 
+// A brokenHost is a host that the QUIC handshake failed for.
+type brokenHost struct {
+	err   *HandshakeFailedError
+	until time.Time
+}
+
+const defaultFallbackDuration = 5 * time.Minute
+
+const defaultMaxIdleConns = 100
+
 // RoundTripOpt are options for the Transport.RoundTripOpt method.
 type RoundTripOpt struct {
 	// OnlyCachedConn controls whether the RoundTripper may
@@ -63,6 +142,32 @@ var _ roundTripCloser = &RoundTripper{}
 // ErrNoCachedConn is returned when RoundTripper.OnlyCachedConn is set
 var ErrNoCachedConn = errors.New("h2quic: no cached connection was available")
 
+// ErrHandshakeFailed is matched by the HandshakeFailedError (using errors.Is).
+var ErrHandshakeFailed = errors.New("h2quic: QUIC handshake failed")
+
+// A HandshakeFailedError is returned by the RoundTripper if the QUIC handshake failed,
+// because it timed out, or because client and server don't support a common QUIC version.
+// This might mean that QUIC is blocked on the path to the server, and that the request should be retried using TCP.
+// Other errors, e.g. TLS errors, are not wrapped in a HandshakeFailedError.
+type HandshakeFailedError struct {
+	// the error that caused the handshake to fail
+	Err error
+}
+
+func (e *HandshakeFailedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrHandshakeFailed, e.Err)
+}
+
+// Unwrap returns the error that caused the handshake to fail.
+func (e *HandshakeFailedError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows matching the error against ErrHandshakeFailed using errors.Is.
+func (e *HandshakeFailedError) Is(target error) bool {
+	return target == ErrHandshakeFailed
+}
+
 // RoundTripOpt is like RoundTrip, but takes options.
 func (r *RoundTripper) RoundTripOpt(req *http.Request, opt RoundTripOpt) (*http.Response, error) {
 	if req.URL == nil {
@@ -100,11 +205,102 @@ func (r *RoundTripper) RoundTripOpt(req *http.Request, opt RoundTripOpt) (*http.
 	}
 
 	hostname := authorityAddr("https", hostnameFromRequest(req))
-	cl, err := r.getClient(hostname, opt.OnlyCachedConn)
+	if err := r.recentHandshakeFailure(hostname); err != nil {
+		return r.fallback(req, err)
+	}
+	cl, isNew, err := r.getClient(hostname, opt.OnlyCachedConn)
 	if err != nil {
 		return nil, err
 	}
-	return cl.RoundTrip(req)
+	rsp, err := r.roundTrip(hostname, cl, req)
+	if err == nil {
+		return rsp, nil
+	}
+	var hsErr *HandshakeFailedError
+	switch {
+	case errors.As(err, &hsErr):
+		return r.handshakeFailed(hostname, cl, req, hsErr)
+	case err == errGoAway:
+		// The server is shutting down the session, retry the request on a new session.
+		// The old session is closed by the server once all outstanding requests were handled.
+	case !isNew && cl.isDialed() && cl.isClosed() && isReplayable(req) && req.Context().Err() == nil:
+		// The cached session was closed before the request completed.
+		if req, err = rewindBody(req); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	r.removeClient(hostname, cl)
+	cl, _, err = r.getClient(hostname, opt.OnlyCachedConn)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err = r.roundTrip(hostname, cl, req)
+	if errors.As(err, &hsErr) {
+		return r.handshakeFailed(hostname, cl, req, hsErr)
+	}
+	return rsp, err
+}
+
+// handshakeFailed remembers that QUIC can't be used for a host, and sends the request using the Fallback.
+func (r *RoundTripper) handshakeFailed(hostname string, cl *pooledClient, req *http.Request, err *HandshakeFailedError) (*http.Response, error) {
+	r.removeClient(hostname, cl)
+	duration := r.FallbackDuration
+	if duration == 0 {
+		duration = defaultFallbackDuration
+	}
+	now := time.Now()
+	r.mutex.Lock()
+	if r.brokenHosts == nil {
+		r.brokenHosts = make(map[string]*brokenHost)
+	}
+	// Entries are only deleted when the host is used again, so expired entries need to be removed here.
+	for h, b := range r.brokenHosts {
+		if now.After(b.until) {
+			delete(r.brokenHosts, h)
+		}
+	}
+	r.brokenHosts[hostname] = &brokenHost{err: err, until: now.Add(duration)}
+	r.mutex.Unlock()
+	return r.fallback(req, err)
+}
+
+// recentHandshakeFailure returns the error if the QUIC handshake to a host failed within the FallbackDuration.
+func (r *RoundTripper) recentHandshakeFailure(hostname string) *HandshakeFailedError {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	h, ok := r.brokenHosts[hostname]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(h.until) {
+		delete(r.brokenHosts, hostname)
+		return nil
+	}
+	return h.err
+}
+
+func (r *RoundTripper) fallback(req *http.Request, err *HandshakeFailedError) (*http.Response, error) {
+	if r.Fallback == nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+	return r.Fallback.RoundTrip(req)
+}
+
+// roundTrip does the request, and tracks it as active until the response body was read completely or closed.
+func (r *RoundTripper) roundTrip(hostname string, cl *pooledClient, req *http.Request) (*http.Response, error) {
+	rsp, err := cl.RoundTrip(req)
+	if err != nil || rsp.Body == nil || rsp.Body == noBody {
+		r.requestDone(hostname, cl)
+		return rsp, err
+	}
+	rsp.Body = &trackedBody{
+		ReadCloser: rsp.Body,
+		onDone:     func() { r.requestDone(hostname, cl) },
+	}
+	return rsp, nil
 }
 
 // RoundTrip does a round trip.
@@ -112,42 +308,219 @@ func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return r.RoundTripOpt(req, RoundTripOpt{})
 }
 
-func (r *RoundTripper) getClient(hostname string, onlyCached bool) (http.RoundTripper, error) {
+// getClient returns the client for a hostname, and marks it as active.
+// All requests to the same host share one client. Clients whose session was closed are replaced.
+func (r *RoundTripper) getClient(hostname string, onlyCached bool) (cl *pooledClient, isNew bool, err error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
 	if r.clients == nil {
-		r.clients = make(map[string]roundTripCloser)
+		r.clients = make(map[string]*pooledClient)
 	}
 
 	client, ok := r.clients[hostname]
+	if ok && client.isClosed() {
+		r.closeClient(hostname, client)
+		ok = false
+	}
 	if !ok {
 		if onlyCached {
-			return nil, ErrNoCachedConn
+			return nil, false, ErrNoCachedConn
+		}
+		client = &pooledClient{
+			sessionClient: newClient(
+				hostname,
+				r.TLSClientConfig,
+				&roundTripperOpts{
+					DisableCompression:    r.DisableCompression,
+					DisablePush:           r.DisablePush,
+					ExpectContinueTimeout: r.ExpectContinueTimeout,
+					MaxHeaderBytes:        r.MaxHeaderBytes,
+				},
+				r.QuicConfig,
+				r.Dial,
+			),
 		}
-		client = newClient(
-			hostname,
-			r.TLSClientConfig,
-			&roundTripperOpts{DisableCompression: r.DisableCompression},
-			r.QuicConfig,
-			r.Dial,
-		)
 		r.clients[hostname] = client
+		isNew = true
+	}
+	client.activeRequests++
+	if client.idleTimer != nil {
+		client.idleTimer.Stop()
+		client.idleTimer = nil
+	}
+	return client, isNew, nil
+}
+
+// requestDone is called when a request completed.
+// If the client doesn't have any active requests left, it becomes idle.
+func (r *RoundTripper) requestDone(hostname string, cl *pooledClient) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cl.activeRequests--
+	if cl.activeRequests > 0 || r.clients[hostname] != cl {
+		return
+	}
+	cl.idleSince = time.Now()
+	if r.IdleConnTimeout > 0 {
+		cl.idleTimer = time.AfterFunc(r.IdleConnTimeout, func() { r.closeIfIdle(hostname, cl) })
+	}
+	r.enforceMaxIdleConns()
+}
+
+func (r *RoundTripper) closeIfIdle(hostname string, cl *pooledClient) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// The client might have been used again after the timer fired.
+	if r.clients[hostname] != cl || cl.activeRequests > 0 || time.Since(cl.idleSince) < r.IdleConnTimeout {
+		return
+	}
+	r.closeClient(hostname, cl)
+}
+
+// enforceMaxIdleConns closes the clients that were idle for the longest time,
+// until there are no more than MaxIdleConns idle clients.
+// It must be called with the mutex held.
+func (r *RoundTripper) enforceMaxIdleConns() {
+	maxIdleConns := r.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	var idle []string
+	for hostname, cl := range r.clients {
+		if cl.activeRequests == 0 {
+			idle = append(idle, hostname)
+		}
+	}
+	if len(idle) <= maxIdleConns {
+		return
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return r.clients[idle[i]].idleSince.Before(r.clients[idle[j]].idleSince)
+	})
+	for _, hostname := range idle[:len(idle)-maxIdleConns] {
+		r.closeClient(hostname, r.clients[hostname])
+	}
+}
+
+// closeClient removes a client from the pool and closes it.
+// It must be called with the mutex held.
+func (r *RoundTripper) closeClient(hostname string, cl *pooledClient) {
+	delete(r.clients, hostname)
+	if cl.idleTimer != nil {
+		cl.idleTimer.Stop()
+	}
+	if err := cl.Close(); err != nil {
+		utils.DefaultLogger.Debugf("Error closing session to %s: %s", hostname, err)
+	}
+}
+
+// removeClient removes a client from the pool, without closing it.
+func (r *RoundTripper) removeClient(hostname string, cl *pooledClient) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// another request might already have replaced the client
+	if r.clients[hostname] == cl {
+		delete(r.clients, hostname)
+		if cl.idleTimer != nil {
+			cl.idleTimer.Stop()
+		}
 	}
-	return client, nil
 }
 
-// Close closes the QUIC connections that this RoundTripper has used
+// CloseIdleConnections closes all sessions that don't have any active requests.
+// It doesn't interrupt any sessions currently in use.
+func (r *RoundTripper) CloseIdleConnections() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for hostname, cl := range r.clients {
+		if cl.activeRequests == 0 {
+			r.closeClient(hostname, cl)
+		}
+	}
+}
+
+// Close closes the QUIC connections that this RoundTripper has used.
+// All connections are closed, even if closing one of them fails.
 func (r *RoundTripper) Close() error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	for _, client := range r.clients {
+	var errs []error
+	var msgs []string
+	for hostname, client := range r.clients {
+		if client.idleTimer != nil {
+			client.idleTimer.Stop()
+		}
 		if err := client.Close(); err != nil {
-			return err
+			errs = append(errs, err)
+			msgs = append(msgs, fmt.Sprintf("%s: %s", hostname, err))
 		}
 	}
 	r.clients = nil
-	return nil
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		sort.Strings(msgs)
+		return fmt.Errorf("h2quic: closing %d sessions failed: %s", len(errs), strings.Join(msgs, "; "))
+	}
+}
+
+// A trackedBody is a response body that calls onDone once it was read completely, or closed.
+type trackedBody struct {
+	io.ReadCloser
+
+	onDone   func()
+	doneOnce sync.Once
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.doneOnce.Do(b.onDone)
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.doneOnce.Do(b.onDone)
+	return err
+}
+
+// rewindBody returns a copy of the request with a new body, so that it can be sent again.
+func rewindBody(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	newReq := *req
+	newReq.Body = body
+	return &newReq, nil
+}
+
+// isReplayable says if a request can be sent again on a new session.
+// Copied from net/http/request.go.
+func isReplayable(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		switch req.Method {
+		case "", "GET", "HEAD", "OPTIONS", "TRACE":
+			return true
+		}
+		if _, ok := req.Header["Idempotency-Key"]; ok {
+			return true
+		}
+		if _, ok := req.Header["X-Idempotency-Key"]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 func closeRequestBody(req *http.Request) {
@@ -2,30 +2,50 @@ package h2quic
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 type mockClient struct {
-	closed bool
+	closed        bool
+	closeErr      error
+	goingAway     bool
+	sessionClosed bool
+	// if set, RoundTrip closes the session and returns this error
+	roundTripErr error
+	body         io.ReadCloser
 }
 
 func (m *mockClient) RoundTrip(req *http.Request) (*http.Response, error) {
-	return &http.Response{Request: req}, nil
+	if m.goingAway {
+		return nil, errGoAway
+	}
+	if m.roundTripErr != nil {
+		m.sessionClosed = true
+		return nil, m.roundTripErr
+	}
+	return &http.Response{Request: req, Body: m.body}, nil
 }
 func (m *mockClient) Close() error {
 	m.closed = true
-	return nil
+	return m.closeErr
 }
+func (m *mockClient) isClosed() bool { return m.sessionClosed }
+func (m *mockClient) isDialed() bool { return true }
 
-var _ roundTripCloser = &mockClient{}
+var _ sessionClient = &mockClient{}
 
 type mockBody struct {
 	reader   bytes.Reader
@@ -72,10 +92,13 @@ var _ = Describe("RoundTripper", func() {
 
 		BeforeEach(func() {
 			origDialAddr = dialAddr
-			dialAddr = func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+			dialAddr = func(_ context.Context, addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
 				// return an error when trying to open a stream
 				// we don't want to test all the dial logic here, just that dialing happens at all
-				return &mockSession{streamOpenErr: streamOpenErr}, nil
+				sess := newMockSession()
+				sess.streamOpenErr = streamOpenErr
+				sess.ctx, sess.ctxCancel = context.WithCancel(context.Background())
+				return sess, nil
 			}
 		})
 
@@ -94,7 +117,7 @@ var _ = Describe("RoundTripper", func() {
 		It("uses the quic.Config, if provided", func() {
 			config := &quic.Config{HandshakeTimeout: time.Millisecond}
 			var receivedConfig *quic.Config
-			dialAddr = func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+			dialAddr = func(_ context.Context, addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
 				receivedConfig = config
 				return nil, errors.New("err")
 			}
@@ -105,13 +128,18 @@ var _ = Describe("RoundTripper", func() {
 
 		It("uses the custom dialer, if provided", func() {
 			var dialed bool
-			dialer := func(_, _ string, tlsCfgP *tls.Config, cfg *quic.Config) (quic.Session, error) {
+			var addr, serverName string
+			dialer := func(_ context.Context, _, a string, tlsCfgP *tls.Config, cfg *quic.Config) (quic.Session, error) {
 				dialed = true
+				addr = a
+				serverName = tlsCfgP.ServerName
 				return nil, errors.New("err")
 			}
 			rt.Dial = dialer
 			rt.RoundTrip(req1)
 			Expect(dialed).To(BeTrue())
+			Expect(addr).To(Equal("www.example.org:443"))
+			Expect(serverName).To(Equal("www.example.org"))
 		})
 
 		It("reuses existing clients", func() {
@@ -127,6 +155,67 @@ var _ = Describe("RoundTripper", func() {
 			Expect(rt.clients).To(HaveLen(1))
 		})
 
+		It("retries a request on a new client if the server is going away", func() {
+			cl := &mockClient{goingAway: true}
+			rt.clients = map[string]*pooledClient{"www.example.org:443": {sessionClient: cl}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError(streamOpenErr))
+			Expect(rt.clients).To(HaveKey("www.example.org:443"))
+			Expect(rt.clients["www.example.org:443"].sessionClient).ToNot(Equal(cl))
+			Expect(cl.closed).To(BeFalse())
+		})
+
+		It("replaces clients whose session was closed", func() {
+			cl := &mockClient{sessionClosed: true}
+			rt.clients = map[string]*pooledClient{"www.example.org:443": {sessionClient: cl}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError(streamOpenErr))
+			Expect(rt.clients["www.example.org:443"].sessionClient).ToNot(Equal(cl))
+			Expect(cl.closed).To(BeTrue())
+		})
+
+		It("retries a request on a new client if the session is closed during the request", func() {
+			cl := &mockClient{roundTripErr: errors.New("session closed")}
+			rt.clients = map[string]*pooledClient{"www.example.org:443": {sessionClient: cl}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError(streamOpenErr))
+			Expect(rt.clients["www.example.org:443"].sessionClient).ToNot(Equal(cl))
+		})
+
+		It("doesn't retry requests that are not replayable", func() {
+			testErr := errors.New("session closed")
+			cl := &mockClient{roundTripErr: testErr}
+			rt.clients = map[string]*pooledClient{"www.example.org:443": {sessionClient: cl}}
+			req, err := http.NewRequest("POST", "https://www.example.org/file1.html", &mockBody{})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).To(MatchError(testErr))
+		})
+
+		It("dials only once for concurrent requests to the same host", func() {
+			var dialCount int32
+			unblockDial := make(chan struct{})
+			dialAddr = func(context.Context, string, *tls.Config, *quic.Config) (quic.Session, error) {
+				atomic.AddInt32(&dialCount, 1)
+				<-unblockDial
+				return nil, errors.New("dial error")
+			}
+			errChan := make(chan error, 5)
+			for i := 0; i < 5; i++ {
+				go func() {
+					_, err := rt.RoundTrip(req1)
+					errChan <- err
+				}()
+			}
+			Eventually(func() int32 { return atomic.LoadInt32(&dialCount) }).Should(BeEquivalentTo(1))
+			Consistently(func() int32 { return atomic.LoadInt32(&dialCount) }).Should(BeEquivalentTo(1))
+			close(unblockDial)
+			for i := 0; i < 5; i++ {
+				Eventually(errChan).Should(Receive(MatchError("dial error")))
+			}
+			Expect(atomic.LoadInt32(&dialCount)).To(BeEquivalentTo(1))
+		})
+
 		It("doesn't create new clients if RoundTripOpt.OnlyCachedConn is set", func() {
 			req, err := http.NewRequest("GET", "https://quic.clemente.io/foobar.html", nil)
 			Expect(err).ToNot(HaveOccurred())
@@ -197,17 +286,229 @@ var _ = Describe("RoundTripper", func() {
 		})
 	})
 
+	Context("falling back", func() {
+		var dialCount int
+		origDialAddr := dialAddr
+
+		BeforeEach(func() {
+			dialCount = 0
+			origDialAddr = dialAddr
+			dialAddr = func(context.Context, string, *tls.Config, *quic.Config) (quic.Session, error) {
+				dialCount++
+				return nil, &quic.ConnectionError{ErrorCode: uint16(qerr.HandshakeTimeout)}
+			}
+		})
+
+		AfterEach(func() {
+			dialAddr = origDialAddr
+		})
+
+		It("returns a HandshakeFailedError and doesn't dial again", func() {
+			_, err := rt.RoundTrip(req1)
+			Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+			Expect(dialCount).To(Equal(1))
+			Expect(rt.clients).To(BeEmpty())
+			_, err = rt.RoundTrip(req1)
+			Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+			Expect(dialCount).To(Equal(1))
+		})
+
+		It("uses the fallback RoundTripper", func() {
+			rt.Fallback = &mockClient{}
+			rsp, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.Request).To(Equal(req1))
+			rsp, err = rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.Request).To(Equal(req1))
+			Expect(dialCount).To(Equal(1))
+		})
+
+		It("tries QUIC again after the FallbackDuration", func() {
+			rt.FallbackDuration = 50 * time.Millisecond
+			_, err := rt.RoundTrip(req1)
+			Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+			Expect(dialCount).To(Equal(1))
+			time.Sleep(75 * time.Millisecond)
+			_, err = rt.RoundTrip(req1)
+			Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+			Expect(dialCount).To(Equal(2))
+		})
+
+		It("only falls back for the host that the handshake failed for", func() {
+			_, err := rt.RoundTrip(req1)
+			Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+			req2, err := http.NewRequest("GET", "https://quic.clemente.io/file1.html", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req2)
+			Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+			Expect(dialCount).To(Equal(2))
+		})
+
+		It("removes expired entries when the handshake fails for another host", func() {
+			rt.brokenHosts = map[string]*brokenHost{
+				"expired:443": {until: time.Now().Add(-time.Second)},
+				"broken:443":  {until: time.Now().Add(time.Hour)},
+			}
+			_, err := rt.RoundTrip(req1)
+			Expect(errors.Is(err, ErrHandshakeFailed)).To(BeTrue())
+			Expect(rt.brokenHosts).To(HaveLen(2))
+			Expect(rt.brokenHosts).To(HaveKey("www.example.org:443"))
+			Expect(rt.brokenHosts).To(HaveKey("broken:443"))
+		})
+
+		It("doesn't fall back for other errors", func() {
+			testErr := errors.New("TLS error")
+			dialAddr = func(context.Context, string, *tls.Config, *quic.Config) (quic.Session, error) {
+				dialCount++
+				return nil, testErr
+			}
+			rt.Fallback = &mockClient{}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError(testErr))
+			_, err = rt.RoundTrip(req1)
+			Expect(err).To(MatchError(testErr))
+			Expect(dialCount).To(Equal(2))
+		})
+	})
+
+	Context("idle sessions", func() {
+		const host = "www.example.org:443"
+
+		It("closes idle sessions", func() {
+			cl := &mockClient{}
+			rt.clients = map[string]*pooledClient{host: {sessionClient: cl}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			rt.CloseIdleConnections()
+			Expect(cl.closed).To(BeTrue())
+			Expect(rt.clients).To(BeEmpty())
+		})
+
+		It("doesn't close sessions until the response body was closed", func() {
+			cl := &mockClient{body: &mockBody{}}
+			rt.clients = map[string]*pooledClient{host: {sessionClient: cl}}
+			rsp, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			rt.CloseIdleConnections()
+			Expect(cl.closed).To(BeFalse())
+			Expect(rsp.Body.Close()).To(Succeed())
+			rt.CloseIdleConnections()
+			Expect(cl.closed).To(BeTrue())
+		})
+
+		It("considers a session idle when the response body was read completely", func() {
+			body := &mockBody{}
+			body.SetData([]byte("foobar"))
+			cl := &mockClient{body: body}
+			rt.clients = map[string]*pooledClient{host: {sessionClient: cl}}
+			rsp, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+			rt.CloseIdleConnections()
+			Expect(cl.closed).To(BeTrue())
+		})
+
+		It("closes sessions after the idle timeout", func() {
+			rt.IdleConnTimeout = 50 * time.Millisecond
+			cl := &mockClient{}
+			rt.clients = map[string]*pooledClient{host: {sessionClient: cl}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() bool {
+				rt.mutex.Lock()
+				defer rt.mutex.Unlock()
+				return cl.closed
+			}).Should(BeTrue())
+			rt.mutex.Lock()
+			defer rt.mutex.Unlock()
+			Expect(rt.clients).To(BeEmpty())
+		})
+
+		It("doesn't close sessions with active requests after the idle timeout", func() {
+			rt.IdleConnTimeout = 50 * time.Millisecond
+			cl := &mockClient{body: &mockBody{}}
+			rt.clients = map[string]*pooledClient{host: {sessionClient: cl}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Consistently(func() bool {
+				rt.mutex.Lock()
+				defer rt.mutex.Unlock()
+				return cl.closed
+			}, 150*time.Millisecond).Should(BeFalse())
+		})
+
+		It("limits the number of idle sessions", func() {
+			rt.MaxIdleConns = 1
+			cl1 := &mockClient{}
+			cl2 := &mockClient{}
+			rt.clients = map[string]*pooledClient{host: {sessionClient: cl1}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl1.closed).To(BeFalse())
+			rt.clients["quic.clemente.io:443"] = &pooledClient{sessionClient: cl2}
+			req2, err := http.NewRequest("GET", "https://quic.clemente.io/file1.html", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl1.closed).To(BeTrue())
+			Expect(cl2.closed).To(BeFalse())
+			Expect(rt.clients).To(HaveLen(1))
+			Expect(rt.clients).To(HaveKey("quic.clemente.io:443"))
+		})
+
+		It("limits the number of idle sessions, if MaxIdleConns is not set", func() {
+			rt.clients = make(map[string]*pooledClient)
+			for i := 0; i < defaultMaxIdleConns; i++ {
+				rt.clients[fmt.Sprintf("host%d:443", i)] = &pooledClient{sessionClient: &mockClient{}, idleSince: time.Now().Add(-time.Hour)}
+			}
+			cl := &mockClient{}
+			rt.clients[host] = &pooledClient{sessionClient: cl}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.closed).To(BeFalse())
+			Expect(rt.clients).To(HaveLen(defaultMaxIdleConns))
+			Expect(rt.clients).To(HaveKey(host))
+		})
+	})
+
 	Context("closing", func() {
 		It("closes", func() {
-			rt.clients = make(map[string]roundTripCloser)
+			rt.clients = make(map[string]*pooledClient)
 			cl := &mockClient{}
-			rt.clients["foo.bar"] = cl
+			rt.clients["foo.bar"] = &pooledClient{sessionClient: cl}
 			err := rt.Close()
 			Expect(err).ToNot(HaveOccurred())
 			Expect(len(rt.clients)).To(BeZero())
 			Expect(cl.closed).To(BeTrue())
 		})
 
+		It("closes all sessions, even if closing one of them fails", func() {
+			cl1 := &mockClient{closeErr: errors.New("close error 1")}
+			cl2 := &mockClient{}
+			cl3 := &mockClient{closeErr: errors.New("close error 3")}
+			rt.clients = map[string]*pooledClient{
+				"host1:443": {sessionClient: cl1},
+				"host2:443": {sessionClient: cl2},
+				"host3:443": {sessionClient: cl3},
+			}
+			err := rt.Close()
+			Expect(err).To(MatchError("h2quic: closing 2 sessions failed: host1:443: close error 1; host3:443: close error 3"))
+			Expect(cl1.closed).To(BeTrue())
+			Expect(cl2.closed).To(BeTrue())
+			Expect(cl3.closed).To(BeTrue())
+			Expect(rt.clients).To(BeEmpty())
+		})
+
+		It("returns the error if closing a single session fails", func() {
+			testErr := errors.New("close error")
+			cl := &mockClient{closeErr: testErr}
+			rt.clients = map[string]*pooledClient{"foo.bar": {sessionClient: cl}}
+			Expect(rt.Close()).To(MatchError(testErr))
+		})
+
 		It("closes a RoundTripper that has never been used", func() {
 			Expect(len(rt.clients)).To(BeZero())
 			err := rt.Close()
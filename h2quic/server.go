@@ -1,6 +1,8 @@
 package h2quic
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -35,8 +37,40 @@ var (
 	quicListenAddr = quic.ListenAddr
 )
 
+// ServerSessionContextKey is a context key. It can be used in HTTP handlers with
+// Context.Value to access the QUIC session that the request was received on.
+// The associated value will be of type quic.Session.
+var ServerSessionContextKey = &contextKey{"h2quic-session"}
+
+// contextKey is a value for use with context.WithValue.
+// Copied from net/http/http.go.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string { return "h2quic context value " + k.name }
+
+// shutdownPollInterval is how often Shutdown checks if all requests have been handled
+const shutdownPollInterval = 10 * time.Millisecond
+
+// nextProtoH2Quic is the ALPN protocol used by h2quic.
+// The mapping of HTTP/2 onto QUIC used by h2quic isn't standardized, so this is a quic-go specific value.
+const nextProtoH2Quic = "h2q"
+
+// tlsConfigWithNextProto returns a copy of the tls.Config that only offers the h2quic ALPN protocol.
+func tlsConfigWithNextProto(tlsConf *tls.Config) *tls.Config {
+	if tlsConf == nil {
+		return &tls.Config{NextProtos: []string{nextProtoH2Quic}}
+	}
+	c := tlsConf.Clone()
+	c.NextProtos = []string{nextProtoH2Quic}
+	return c
+}
+
 // Server is a HTTP2 server listening for QUIC connections.
 type Server struct {
+	// The http.Server's MaxHeaderBytes limits the size of the header lists received from the client.
+	// Streams carrying larger header lists are reset. If zero, http.DefaultMaxHeaderBytes is used.
 	*http.Server
 
 	// By providing a quic.Config, it is possible to set parameters of the QUIC connection.
@@ -46,17 +80,43 @@ type Server struct {
 	// Private flag for demo, do not use
 	CloseAfterFirstRequest bool
 
-	port uint32 // used atomically
+	port           uint32 // used atomically
+	activeRequests int32  // used atomically
 
 	listenerMutex sync.Mutex
 	listener      quic.Listener
 	closed        bool
 
+	mutex      sync.Mutex // protects the following fields
+	inShutdown bool
+	sessions   map[streamCreator]*sessionHeaderStream
+	onShutdown []func()
+
 	supportedVersionsAsString string
 
 	logger utils.Logger // will be set by Server.serveImpl()
 }
 
+// The sessionHeaderStream is used to send a GOAWAY frame when the server is shut down.
+type sessionHeaderStream struct {
+	stream quic.Stream
+	mutex  *sync.Mutex // protects concurrent calls to Write()
+}
+
+// clientSettings are the values of the settings sent by the client in SETTINGS frames.
+type clientSettings struct {
+	// Push is enabled, unless the client disables it using the SETTINGS_ENABLE_PUSH setting.
+	pushEnabled utils.AtomicBool
+	// maxHeaderListSize is the value of the SETTINGS_MAX_HEADER_LIST_SIZE setting, or 0 if it wasn't sent.
+	maxHeaderListSize uint32 // used atomically
+}
+
+func newClientSettings() *clientSettings {
+	s := &clientSettings{}
+	s.pushEnabled.Set(true)
+	return s
+}
+
 // ListenAndServe listens on the UDP address s.Addr and calls s.Handler to handle HTTP/2 requests on incoming connections.
 func (s *Server) ListenAndServe() error {
 	if s.Server == nil {
@@ -101,6 +161,9 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn net.PacketConn) error {
 		return errors.New("ListenAndServe may only be called once")
 	}
 
+	// The tls.Config might be shared with a TCP listener (see ListenAndServeQUIC),
+	// so the ALPN protocol is set on a copy.
+	tlsConfig = tlsConfigWithNextProto(tlsConfig)
 	var ln quic.Listener
 	var err error
 	if conn == nil {
@@ -116,44 +179,72 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn net.PacketConn) error {
 	s.listenerMutex.Unlock()
 
 	for {
-		sess, err := ln.Accept()
+		sess, err := ln.Accept(context.Background())
 		if err != nil {
 			return err
 		}
+		if s.shuttingDown() {
+			sess.CloseWithError(quic.ErrorCode(qerr.PeerGoingAway), "server shutting down")
+			continue
+		}
 		go s.handleHeaderStream(sess.(streamCreator))
 	}
 }
 
 func (s *Server) handleHeaderStream(session streamCreator) {
-	stream, err := session.AcceptStream()
+	stream, err := session.AcceptStream(context.Background())
 	if err != nil {
-		session.CloseWithError(quic.ErrorCode(qerr.InvalidHeadersStreamData), err)
+		session.CloseWithError(quic.ErrorCode(qerr.InvalidHeadersStreamData), err.Error())
 		return
 	}
 
-	hpackDecoder := hpack.NewDecoder(4096, nil)
-	h2framer := http2.NewFramer(nil, stream)
+	hpackDecoder := hpack.NewDecoder(hpackTableSize, nil)
+	frames := newFrameReader(stream, hpackDecoder, s.maxHeaderListSize())
 
 	var headerStreamMutex sync.Mutex // Protects concurrent calls to Write()
+	settings := newClientSettings()
+	if s.addSession(session, stream, &headerStreamMutex) {
+		// Shutdown was called after the session was accepted
+		s.sendGoAway(stream, &headerStreamMutex)
+	}
+	defer s.removeSession(session)
+
+	// The trailers of requests that announced trailers are delivered on these channels.
+	// This map is only accessed by handleRequest, so it doesn't need to be protected by a mutex.
+	requestTrailers := make(map[protocol.StreamID]chan<- http.Header)
 	for {
-		if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer); err != nil {
+		if err := s.handleRequest(session, stream, &headerStreamMutex, settings, requestTrailers, hpackDecoder, frames); err != nil {
 			// QuicErrors must originate from stream.Read() returning an error.
 			// In this case, the session has already logged the error, so we don't
 			// need to log it again.
 			errorCode := qerr.InternalError
+			reason := err.Error()
 			if qerr, ok := err.(*qerr.QuicError); ok {
 				errorCode = qerr.ErrorCode
+				reason = qerr.ErrorMessage
 				s.logger.Errorf("error handling h2 request: %s", err.Error())
 			}
-			session.CloseWithError(quic.ErrorCode(errorCode), err)
+			session.CloseWithError(quic.ErrorCode(errorCode), reason)
 			return
 		}
 	}
 }
 
-func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer) error {
-	h2frame, err := h2framer.ReadFrame()
+func (s *Server) handleRequest(
+	session streamCreator,
+	headerStream quic.Stream,
+	headerStreamMutex *sync.Mutex,
+	settings *clientSettings,
+	requestTrailers map[protocol.StreamID]chan<- http.Header,
+	hpackDecoder *hpack.Decoder,
+	frames *frameReader,
+) error {
+	h2frame, err := frames.ReadFrame()
 	if err != nil {
+		if tooLarge, ok := err.(*headerBlockTooLargeError); ok && !tooLarge.isPushPromise {
+			s.logger.Errorf("Header block on data stream %d exceeds the limit of %d bytes. Refusing the stream.", tooLarge.streamID, s.maxHeaderListSize())
+			return s.refuseStream(session, headerStream, headerStreamMutex, requestTrailers, tooLarge.streamID)
+		}
 		return qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")
 	}
 	var h2headersFrame *http2.HeadersFrame
@@ -162,6 +253,8 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 		// ignore PRIORITY frames
 		s.logger.Debugf("Ignoring H2 PRIORITY frame: %#v", f)
 		return nil
+	case *http2.SettingsFrame:
+		return s.handleSettingsFrame(f, settings)
 	case *http2.HeadersFrame:
 		h2headersFrame = f
 	default:
@@ -171,12 +264,34 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 	if !h2headersFrame.HeadersEnded() {
 		return errors.New("http2 header continuation not implemented")
 	}
-	headers, err := hpackDecoder.DecodeFull(h2headersFrame.HeaderBlockFragment())
+	maxSize := s.maxHeaderListSize()
+	headers, truncated, err := decodeHeaderFields(hpackDecoder, h2headersFrame.HeaderBlockFragment(), maxSize)
 	if err != nil {
 		s.logger.Errorf("invalid http2 headers encoding: %s", err.Error())
 		return err
 	}
 
+	streamID := protocol.StreamID(h2headersFrame.StreamID)
+	if truncated {
+		s.logger.Errorf("Header list on data stream %d exceeds the limit of %d bytes. Refusing the stream.", streamID, maxSize)
+		return s.refuseStream(session, headerStream, headerStreamMutex, requestTrailers, streamID)
+	}
+	if trailerChan, ok := requestTrailers[streamID]; ok {
+		delete(requestTrailers, streamID)
+		trailer, err := trailerFromHeaders(headers)
+		if err != nil {
+			return qerr.Error(qerr.InvalidHeadersStreamData, err.Error())
+		}
+		trailerChan <- trailer
+		return nil
+	}
+	if isTrailerBlock(headers) {
+		// The request didn't announce any trailers, or the trailers were already received.
+		// This only affects a single stream, so the header stream is still usable.
+		s.logger.Debugf("Ignoring unannounced trailers on data stream %d", streamID)
+		return nil
+	}
+
 	req, err := requestFromHeaders(headers)
 	if err != nil {
 		return err
@@ -188,7 +303,7 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 		s.logger.Infof("%s %s%s", req.Method, req.Host, req.RequestURI)
 	}
 
-	dataStream, err := session.GetOrOpenStream(protocol.StreamID(h2headersFrame.StreamID))
+	dataStream, err := session.GetOrOpenStream(streamID)
 	if err != nil {
 		return err
 	}
@@ -197,61 +312,206 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 		return nil
 	}
 
+	// The trailers are sent after the request body.
+	// The channel is buffered, so that handleRequest doesn't block if the handler didn't read the body yet.
+	var trailerChan chan http.Header
+	if req.Trailer != nil && !h2headersFrame.StreamEnded() {
+		trailerChan = make(chan http.Header, 1)
+		requestTrailers[streamID] = trailerChan
+	}
+
 	// handleRequest should be as non-blocking as possible to minimize
 	// head-of-line blocking. Potentially blocking code is run in a separate
 	// goroutine, enabling handleRequest to return before the code is executed.
-	go func() {
-		streamEnded := h2headersFrame.StreamEnded()
-		if streamEnded {
-			dataStream.(remoteCloser).CloseRemote(0)
-			streamEnded = true
-			_, _ = dataStream.Read([]byte{0}) // read the eof
-		}
+	atomic.AddInt32(&s.activeRequests, 1)
+	go s.serveRequest(session, headerStream, headerStreamMutex, settings, req, dataStream, streamID, h2headersFrame.StreamEnded(), trailerChan, false)
 
-		req = req.WithContext(dataStream.Context())
-		reqBody := newRequestBody(dataStream)
-		req.Body = reqBody
-
-		req.RemoteAddr = session.RemoteAddr().String()
+	return nil
+}
 
-		responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID), s.logger)
+// refuseStream refuses a stream whose header list exceeds the maximum header list size.
+func (s *Server) refuseStream(
+	session streamCreator,
+	headerStream quic.Stream,
+	headerStreamMutex *sync.Mutex,
+	requestTrailers map[protocol.StreamID]chan<- http.Header,
+	streamID protocol.StreamID,
+) error {
+	dataStream, err := session.GetOrOpenStream(streamID)
+	if err != nil || dataStream == nil {
+		return err
+	}
+	dataStream.CancelRead(errorCodeRefusedStream)
+	if _, ok := requestTrailers[streamID]; ok {
+		// The handler is already running. Abort the response.
+		delete(requestTrailers, streamID)
+		dataStream.CancelWrite(errorCodeRefusedStream)
+		return nil
+	}
+	// As in net/http, tell the client why the request was refused.
+	newResponseWriter(headerStream, headerStreamMutex, dataStream, streamID, s.logger).WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+	dataStream.Close()
+	return nil
+}
 
-		handler := s.Handler
-		if handler == nil {
-			handler = http.DefaultServeMux
+func (s *Server) handleSettingsFrame(f *http2.SettingsFrame, settings *clientSettings) error {
+	if f.IsAck() {
+		return nil
+	}
+	return f.ForeachSetting(func(setting http2.Setting) error {
+		if err := setting.Valid(); err != nil {
+			return qerr.Error(qerr.InvalidHeadersStreamData, err.Error())
 		}
-		panicked := false
-		func() {
-			defer func() {
-				if p := recover(); p != nil {
-					// Copied from net/http/server.go
-					const size = 64 << 10
-					buf := make([]byte, size)
-					buf = buf[:runtime.Stack(buf, false)]
-					s.logger.Errorf("http: panic serving: %v\n%s", p, buf)
-					panicked = true
-				}
-			}()
-			handler.ServeHTTP(responseWriter, req)
-		}()
-		if panicked {
-			responseWriter.WriteHeader(500)
-		} else {
-			responseWriter.WriteHeader(200)
+		if setting.ID == http2.SettingEnablePush {
+			s.logger.Debugf("Client set SETTINGS_ENABLE_PUSH to %d", setting.Val)
+			settings.pushEnabled.Set(setting.Val == 1)
 		}
-		if responseWriter.dataStream != nil {
-			if !streamEnded && !reqBody.requestRead {
-				// in gQUIC, the error code doesn't matter, so just use 0 here
-				responseWriter.dataStream.CancelRead(0)
-			}
-			responseWriter.dataStream.Close()
+		if setting.ID == http2.SettingMaxHeaderListSize {
+			s.logger.Debugf("Client set SETTINGS_MAX_HEADER_LIST_SIZE to %d", setting.Val)
+			atomic.StoreUint32(&settings.maxHeaderListSize, setting.Val)
 		}
-		if s.CloseAfterFirstRequest {
-			time.Sleep(100 * time.Millisecond)
-			session.Close()
+		return nil
+	})
+}
+
+// serveRequest runs the handler for a request.
+// For pushed responses, the request is the request promised in the PUSH_PROMISE frame.
+func (s *Server) serveRequest(
+	session streamCreator,
+	headerStream quic.Stream,
+	headerStreamMutex *sync.Mutex,
+	settings *clientSettings,
+	req *http.Request,
+	dataStream quic.Stream,
+	dataStreamID protocol.StreamID,
+	streamEnded bool,
+	trailerChan <-chan http.Header,
+	isPush bool,
+) {
+	defer atomic.AddInt32(&s.activeRequests, -1)
+
+	if streamEnded {
+		dataStream.(remoteCloser).CloseRemote(0)
+		_, _ = dataStream.Read([]byte{0}) // read the eof
+	}
+
+	req = req.WithContext(context.WithValue(dataStream.Context(), ServerSessionContextKey, quic.Session(session)))
+	reqBody := newRequestBody(dataStream, req.Trailer, trailerChan)
+	req.Body = reqBody
+
+	req.RemoteAddr = session.RemoteAddr().String()
+
+	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, dataStreamID, s.logger)
+	responseWriter.session = session
+	responseWriter.peerMaxHeaderListSize = atomic.LoadUint32(&settings.maxHeaderListSize)
+	// As in net/http, the 100 Continue response is sent when the handler first reads the body.
+	if !streamEnded && expectsContinue(req.Header) {
+		req.Header.Del("Expect")
+		reqBody.onFirstRead = responseWriter.writeContinue
+	}
+	// Pushed responses can't push any other resources.
+	if !isPush {
+		responseWriter.push = func(target string, opts *http.PushOptions) error {
+			return s.push(session, headerStream, headerStreamMutex, settings, req, dataStreamID, target, opts)
 		}
+	}
+
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	panicked := false
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				// Copied from net/http/server.go
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				s.logger.Errorf("http: panic serving: %v\n%s", p, buf)
+				panicked = true
+			}
+		}()
+		handler.ServeHTTP(responseWriter, req)
 	}()
+	responseWriter.handlerDone.Set(true)
+	if responseWriter.hijacked {
+		// The handler took over the data stream.
+		return
+	}
+	if panicked {
+		responseWriter.WriteHeader(500)
+	} else {
+		responseWriter.WriteHeader(200)
+	}
+	responseWriter.writeTrailers()
+	if responseWriter.dataStream != nil {
+		if !streamEnded && !reqBody.requestRead {
+			// in gQUIC, the error code doesn't matter, so just use 0 here
+			responseWriter.dataStream.CancelRead(0)
+		}
+		responseWriter.dataStream.Close()
+	}
+	if s.CloseAfterFirstRequest && !isPush {
+		time.Sleep(100 * time.Millisecond)
+		session.Close()
+	}
+}
 
+func (s *Server) maxHeaderListSize() uint32 {
+	if s.Server == nil {
+		return maxHeaderListSize(0)
+	}
+	return maxHeaderListSize(s.MaxHeaderBytes)
+}
+
+// push sends a PUSH_PROMISE for the target on the header stream, and serves the promised request on a new stream.
+func (s *Server) push(
+	session streamCreator,
+	headerStream quic.Stream,
+	headerStreamMutex *sync.Mutex,
+	settings *clientSettings,
+	req *http.Request,
+	assocStreamID protocol.StreamID,
+	target string,
+	opts *http.PushOptions,
+) error {
+	if !settings.pushEnabled.Get() {
+		return errPushDisabled
+	}
+	headers, err := pushPromiseHeaders(req, target, opts)
+	if err != nil {
+		return err
+	}
+	pushReq, err := requestFromHeaders(headers)
+	if err != nil {
+		return err
+	}
+	dataStream, err := session.OpenStream()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := hpack.NewEncoder(&buf)
+	for _, hf := range headers {
+		enc.WriteField(hf)
+	}
+	s.logger.Debugf("Pushing %s %s%s on data stream %d", pushReq.Method, pushReq.Host, pushReq.RequestURI, dataStream.StreamID())
+	headerStreamMutex.Lock()
+	err = http2.NewFramer(headerStream, nil).WritePushPromise(http2.PushPromiseParam{
+		StreamID:      uint32(assocStreamID),
+		PromiseID:     uint32(dataStream.StreamID()),
+		EndHeaders:    true,
+		BlockFragment: buf.Bytes(),
+	})
+	headerStreamMutex.Unlock()
+	if err != nil {
+		dataStream.CancelWrite(0)
+		return err
+	}
+	atomic.AddInt32(&s.activeRequests, 1)
+	go s.serveRequest(session, headerStream, headerStreamMutex, settings, pushReq, dataStream, dataStream.StreamID(), true, nil, true)
 	return nil
 }
 
@@ -272,13 +532,127 @@ func (s *Server) Close() error {
 // CloseGracefully shuts down the server gracefully. The server sends a GOAWAY frame first, then waits for either timeout to trigger, or for all running requests to complete.
 // CloseGracefully in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
 func (s *Server) CloseGracefully(timeout time.Duration) error {
-	// TODO: implement
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown gracefully shuts down the server without interrupting any active requests.
+// New sessions are rejected, and a GOAWAY frame is sent on all open sessions, so that clients stop sending new requests.
+// Shutdown then waits for all handlers to return, and closes the sessions as soon as all response data was delivered.
+// If the context doesn't have a deadline, delivering the response data may take up to 30 seconds.
+// If the context expires first, the server is closed immediately, and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.inShutdown = true
+	for _, f := range s.onShutdown {
+		go f()
+	}
+	headerStreams := make([]*sessionHeaderStream, 0, len(s.sessions))
+	for _, hs := range s.sessions {
+		headerStreams = append(headerStreams, hs)
+	}
+	s.mutex.Unlock()
+
+	for _, hs := range headerStreams {
+		s.sendGoAway(hs.stream, hs.mutex)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt32(&s.activeRequests) > 0 {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	timeout := protocol.DefaultIdleTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	s.mutex.Lock()
+	if len(s.sessions) == 0 {
+		s.mutex.Unlock()
+		return s.Close()
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(s.sessions))
+	for sess := range s.sessions {
+		go func(sess streamCreator) {
+			defer wg.Done()
+			sess.CloseGracefully(timeout)
+		}(sess)
+	}
+	s.mutex.Unlock()
+	closed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		// all sessions might have been closed concurrently
+		select {
+		case <-closed:
+		default:
+			s.Close()
+			return ctx.Err()
+		}
+	}
+	return s.Close()
+}
+
+// RegisterOnShutdown registers a function to call on Shutdown.
+// Each function is called in its own goroutine.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mutex.Lock()
+	s.onShutdown = append(s.onShutdown, f)
+	s.mutex.Unlock()
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.inShutdown
+}
+
+// addSession adds a session, such that a GOAWAY frame is sent on its header stream on Shutdown.
+// It returns if the server is already shutting down.
+func (s *Server) addSession(session streamCreator, headerStream quic.Stream, headerStreamMutex *sync.Mutex) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[streamCreator]*sessionHeaderStream)
+	}
+	s.sessions[session] = &sessionHeaderStream{stream: headerStream, mutex: headerStreamMutex}
+	return s.inShutdown
+}
+
+func (s *Server) removeSession(session streamCreator) {
+	s.mutex.Lock()
+	delete(s.sessions, session)
+	s.mutex.Unlock()
+}
+
+// sendGoAway tells the client not to send any new requests.
+func (s *Server) sendGoAway(headerStream quic.Stream, headerStreamMutex *sync.Mutex) {
+	headerStreamMutex.Lock()
+	defer headerStreamMutex.Unlock()
+	// Requests that the client sent before receiving the GOAWAY are still handled,
+	// so the GOAWAY doesn't limit the stream ID.
+	if err := http2.NewFramer(headerStream, nil).WriteGoAway(1<<31-1, http2.ErrCodeNo, nil); err != nil {
+		s.logger.Debugf("Failed to send GOAWAY: %s", err)
+	}
 }
 
 // SetQuicHeaders can be used to set the proper headers that announce that this server supports QUIC.
 // The values that are set depend on the port information from s.Server.Addr, and currently look like this (if Addr has port 443):
-//  Alt-Svc: quic=":443"; ma=2592000; v="33,32,31,30"
+//
+//	Alt-Svc: quic=":443"; ma=2592000; v="33,32,31,30"
 func (s *Server) SetQuicHeaders(hdr http.Header) error {
 	port := atomic.LoadUint32(&s.port)
 
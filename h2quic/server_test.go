@@ -7,10 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -37,16 +40,22 @@ type mockSession struct {
 	streamOpenErr       error
 	ctx                 context.Context
 	ctxCancel           context.CancelFunc
+	negotiatedProtocol  string
 }
 
 func newMockSession() *mockSession {
-	return &mockSession{blockOpenStreamChan: make(chan struct{})}
+	return &mockSession{
+		blockOpenStreamChan: make(chan struct{}),
+		negotiatedProtocol:  nextProtoH2Quic,
+	}
 }
 
 func (s *mockSession) GetOrOpenStream(id protocol.StreamID) (quic.Stream, error) {
 	return s.dataStream, nil
 }
-func (s *mockSession) AcceptStream() (quic.Stream, error) { return s.streamToAccept, nil }
+func (s *mockSession) AcceptStream(context.Context) (quic.Stream, error) {
+	return s.streamToAccept, nil
+}
 func (s *mockSession) OpenStream() (quic.Stream, error) {
 	if s.streamOpenErr != nil {
 		return nil, s.streamOpenErr
@@ -55,7 +64,7 @@ func (s *mockSession) OpenStream() (quic.Stream, error) {
 	s.streamsToOpen = s.streamsToOpen[1:]
 	return str, nil
 }
-func (s *mockSession) OpenStreamSync() (quic.Stream, error) {
+func (s *mockSession) OpenStreamSync(context.Context) (quic.Stream, error) {
 	if s.blockOpenStreamSync {
 		<-s.blockOpenStreamChan
 	}
@@ -69,8 +78,21 @@ func (s *mockSession) Close() error {
 	s.closed = true
 	return nil
 }
-func (s *mockSession) CloseWithError(_ quic.ErrorCode, e error) error {
-	s.closedWithError = e
+func (s *mockSession) CloseWithError(code quic.ErrorCode, reason string) error {
+	s.closedWithError = qerr.Error(qerr.ErrorCode(code), reason)
+	return s.Close()
+}
+func (s *mockSession) Stats() quic.SessionStats { panic("not implemented") }
+func (s *mockSession) PeerParams() (quic.NegotiatedParams, bool) {
+	panic("not implemented")
+}
+func (s *mockSession) Ping(context.Context) (time.Duration, error) {
+	panic("not implemented")
+}
+func (s *mockSession) SendMessage([]byte) error        { panic("not implemented") }
+func (s *mockSession) ReceiveMessage() ([]byte, error) { panic("not implemented") }
+func (s *mockSession) Migrate(net.PacketConn) error    { panic("not implemented") }
+func (s *mockSession) CloseGracefully(time.Duration) error {
 	return s.Close()
 }
 func (s *mockSession) LocalAddr() net.Addr {
@@ -82,10 +104,44 @@ func (s *mockSession) RemoteAddr() net.Addr {
 func (s *mockSession) Context() context.Context {
 	return s.ctx
 }
-func (s *mockSession) ConnectionState() quic.ConnectionState        { panic("not implemented") }
-func (s *mockSession) AcceptUniStream() (quic.ReceiveStream, error) { panic("not implemented") }
-func (s *mockSession) OpenUniStream() (quic.SendStream, error)      { panic("not implemented") }
-func (s *mockSession) OpenUniStreamSync() (quic.SendStream, error)  { panic("not implemented") }
+func (s *mockSession) ConnectionState() quic.ConnectionState {
+	return quic.ConnectionState{NegotiatedProtocol: s.negotiatedProtocol}
+}
+func (s *mockSession) ExportKeyingMaterial(string, []byte, int) ([]byte, error) {
+	panic("not implemented")
+}
+func (s *mockSession) AcceptUniStream(context.Context) (quic.ReceiveStream, error) {
+	panic("not implemented")
+}
+func (s *mockSession) OpenUniStream() (quic.SendStream, error) { panic("not implemented") }
+func (s *mockSession) OpenUniStreamSync(context.Context) (quic.SendStream, error) {
+	panic("not implemented")
+}
+
+type mockListener struct {
+	sessions chan quic.Session
+	closed   chan struct{}
+}
+
+var _ quic.Listener = &mockListener{}
+
+func newMockListener() *mockListener {
+	return &mockListener{
+		sessions: make(chan quic.Session, 1),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (l *mockListener) Accept(context.Context) (quic.Session, error) {
+	select {
+	case sess := <-l.sessions:
+		return sess, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+func (l *mockListener) Close() error   { close(l.closed); return nil }
+func (l *mockListener) Addr() net.Addr { panic("not implemented") }
 
 var _ = Describe("H2 server", func() {
 	var (
@@ -116,15 +172,20 @@ var _ = Describe("H2 server", func() {
 
 	Context("handling requests", func() {
 		var (
-			h2framer     *http2.Framer
+			frames       *frameReader
 			hpackDecoder *hpack.Decoder
 			headerStream *mockStream
+			settings     *clientSettings
+			// request trailers
+			trailers map[protocol.StreamID]chan<- http.Header
 		)
 
 		BeforeEach(func() {
+			settings = newClientSettings()
+			trailers = make(map[protocol.StreamID]chan<- http.Header)
 			headerStream = &mockStream{}
 			hpackDecoder = hpack.NewDecoder(4096, nil)
-			h2framer = http2.NewFramer(nil, headerStream)
+			frames = newFrameReader(headerStream, hpackDecoder, maxHeaderListSize(0))
 		})
 
 		It("handles a sample GET request", func() {
@@ -140,13 +201,58 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeTrue())
 			Expect(dataStream.canceledRead).To(BeFalse())
 		})
 
+		It("adds the session to the request context", func() {
+			sessChan := make(chan quic.Session, 1)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sessChan <- r.Context().Value(ServerSessionContextKey).(quic.Session)
+			})
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(sessChan).Should(Receive(Equal(session)))
+		})
+
+		It("doesn't touch the stream after it was hijacked", func() {
+			handlerReturned := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(handlerReturned)
+				w.Header().Set("Trailer", "Foo")
+				str, sess, err := w.(StreamHijacker).HijackStream()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str).To(Equal(dataStream))
+				Expect(sess).To(Equal(session))
+				w.Header().Set("Foo", "bar")
+			})
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(handlerReturned).Should(BeClosed())
+			Eventually(func() int32 { return atomic.LoadInt32(&s.activeRequests) }).Should(BeZero())
+			// only the HEADERS frame, no trailers
+			framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+			_, err = framer.ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = framer.ReadFrame()
+			Expect(err).To(MatchError(io.EOF))
+			Expect(dataStream.closed).To(BeFalse())
+			Expect(dataStream.canceledRead).To(BeFalse())
+		})
+
 		It("returns 200 with an empty handler", func() {
 			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 			headerStream.dataToRead.Write([]byte{
@@ -154,7 +260,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() []byte {
 				return headerStream.dataWritten.Bytes()
@@ -170,7 +276,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() []byte {
 				return headerStream.dataWritten.Bytes()
@@ -188,7 +294,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Eventually(func() bool { return dataStream.canceledRead }).Should(BeTrue())
@@ -203,7 +309,7 @@ var _ = Describe("H2 server", func() {
 				handlerCalled = true
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return dataStream.canceledRead }).Should(BeTrue())
 			Consistently(func() bool { return dataStream.remoteClosed }).Should(BeFalse())
@@ -221,7 +327,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Consistently(func() bool { return handlerCalled }).Should(BeFalse())
 		})
@@ -236,7 +342,7 @@ var _ = Describe("H2 server", func() {
 				handlerCalled = true
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return dataStream.canceledRead }).Should(BeTrue())
 			Consistently(func() bool { return dataStream.remoteClosed }).Should(BeFalse())
@@ -256,12 +362,27 @@ var _ = Describe("H2 server", func() {
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
 			dataStream.dataToRead.Write([]byte("foo=bar"))
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.canceledRead).To(BeFalse())
 		})
 
+		It("cancels the request context when the client cancels the request", func() {
+			handlerReturned := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(handlerReturned)
+				<-r.Context().Done()
+			})
+			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
+			Expect(err).NotTo(HaveOccurred())
+			Consistently(handlerReturned).ShouldNot(BeClosed())
+			// the stream's context is canceled when the client sends a STOP_SENDING
+			dataStream.ctxCancel()
+			Eventually(handlerReturned).Should(BeClosed())
+		})
+
 		It("ignores PRIORITY frames", func() {
 			handlerCalled := make(chan struct{})
 			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -273,19 +394,437 @@ var _ = Describe("H2 server", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(buf.Bytes()).ToNot(BeEmpty())
 			headerStream.dataToRead.Write(buf.Bytes())
-			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err = s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).ToNot(HaveOccurred())
 			Consistently(handlerCalled).ShouldNot(BeClosed())
 			Expect(dataStream.canceledRead).To(BeFalse())
 			Expect(dataStream.closed).To(BeFalse())
 		})
 
+		It("disables push when receiving the SETTINGS_ENABLE_PUSH setting", func() {
+			buf := &bytes.Buffer{}
+			Expect(http2.NewFramer(buf, nil).WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 0})).To(Succeed())
+			headerStream.dataToRead.Write(buf.Bytes())
+			Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+			Expect(settings.pushEnabled.Get()).To(BeFalse())
+		})
+
+		It("errors on invalid settings", func() {
+			buf := &bytes.Buffer{}
+			Expect(http2.NewFramer(buf, nil).WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 2})).To(Succeed())
+			headerStream.dataToRead.Write(buf.Bytes())
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
+			Expect(settings.pushEnabled.Get()).To(BeTrue())
+		})
+
+		Context("server push", func() {
+			var pushedStream *mockStream
+
+			// a GET request for www.example.com/ on stream 5
+			writeRequest := func() {
+				headerStream.dataToRead.Write([]byte{
+					0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+					// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+					0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+				})
+			}
+
+			BeforeEach(func() {
+				pushedStream = newMockStream(7)
+				close(pushedStream.unblockRead)
+				session.streamsToOpen = []quic.Stream{pushedStream}
+			})
+
+			It("pushes a response", func() {
+				pushErr := make(chan error, 1)
+				pushedRequest := make(chan *http.Request, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/style.css" {
+						pushedRequest <- r
+						w.Write([]byte("foobar"))
+						return
+					}
+					pushErr <- w.(http.Pusher).Push("/style.css", &http.PushOptions{Header: http.Header{"Foo": []string{"bar"}}})
+				})
+				var headerStreamMutex sync.Mutex
+				writeRequest()
+				Expect(s.handleRequest(session, headerStream, &headerStreamMutex, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				var r *http.Request
+				Eventually(pushedRequest).Should(Receive(&r))
+				Eventually(pushErr).Should(Receive(BeNil()))
+				Expect(r.Method).To(Equal("GET"))
+				Expect(r.Host).To(Equal("www.example.com"))
+				Expect(r.Header.Get("Foo")).To(Equal("bar"))
+				Eventually(func() bool { return pushedStream.closed }).Should(BeTrue())
+				Expect(pushedStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+				Expect(pushedStream.canceledRead).To(BeFalse())
+
+				headerStreamMutex.Lock()
+				defer headerStreamMutex.Unlock()
+				framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				decoder := hpack.NewDecoder(4096, nil)
+				frame, err := framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame).To(BeAssignableToTypeOf(&http2.PushPromiseFrame{}))
+				pushPromise := frame.(*http2.PushPromiseFrame)
+				Expect(pushPromise.StreamID).To(BeEquivalentTo(5))
+				Expect(pushPromise.PromiseID).To(BeEquivalentTo(7))
+				fields, err := decoder.DecodeFull(pushPromise.HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":path", Value: "/style.css"}))
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":authority", Value: "www.example.com"}))
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: "foo", Value: "bar"}))
+				// the HEADERS frames of the response and of the pushed response follow
+				var streamIDs []uint32
+				for i := 0; i < 2; i++ {
+					frame, err = framer.ReadFrame()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+					streamIDs = append(streamIDs, frame.Header().StreamID)
+				}
+				Expect(streamIDs).To(ConsistOf(uint32(5), uint32(7)))
+			})
+
+			It("doesn't push if the client disabled push", func() {
+				settings.pushEnabled.Set(false)
+				pushErr := make(chan error, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					pushErr <- w.(http.Pusher).Push("/style.css", nil)
+				})
+				writeRequest()
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				var err error
+				Eventually(pushErr).Should(Receive(&err))
+				Expect(err).To(MatchError(errPushDisabled))
+				Expect(errors.Is(err, http.ErrNotSupported)).To(BeTrue())
+				Expect(session.streamsToOpen).To(HaveLen(1))
+			})
+
+			It("doesn't push after the handler returned", func() {
+				rw := make(chan http.ResponseWriter, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					rw <- w
+				})
+				writeRequest()
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				var w http.ResponseWriter
+				Eventually(rw).Should(Receive(&w))
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				err := w.(http.Pusher).Push("/style.css", nil)
+				Expect(err).To(MatchError(errPushAfterResponse))
+				Expect(errors.Is(err, http.ErrNotSupported)).To(BeTrue())
+			})
+
+			It("doesn't allow pushed responses to push", func() {
+				pushErr := make(chan error, 2)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/style.css" {
+						pushErr <- w.(http.Pusher).Push("/script.js", nil)
+						return
+					}
+					pushErr <- w.(http.Pusher).Push("/style.css", nil)
+				})
+				writeRequest()
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				var err error
+				Eventually(pushErr).Should(Receive(&err))
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(pushErr).Should(Receive(&err))
+				Expect(err).To(MatchError(errRecursivePush))
+			})
+
+			It("returns the error when opening the stream fails", func() {
+				testErr := errors.New("too many streams")
+				session.streamOpenErr = testErr
+				pushErr := make(chan error, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					pushErr <- w.(http.Pusher).Push("/style.css", nil)
+				})
+				writeRequest()
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(pushErr).Should(Receive(Equal(testErr)))
+			})
+		})
+
+		writeHeaders := func(endStream bool, fields ...hpack.HeaderField) {
+			var headers bytes.Buffer
+			enc := hpack.NewEncoder(&headers)
+			for _, hf := range fields {
+				Expect(enc.WriteField(hf)).To(Succeed())
+			}
+			Expect(http2.NewFramer(&headerStream.dataToRead, nil).WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      5,
+				EndHeaders:    true,
+				EndStream:     endStream,
+				BlockFragment: headers.Bytes(),
+			})).To(Succeed())
+		}
+
+		Context("Expect: 100-continue", func() {
+			writeRequest := func() {
+				writeHeaders(false,
+					hpack.HeaderField{Name: ":method", Value: "POST"},
+					hpack.HeaderField{Name: ":authority", Value: "www.example.com"},
+					hpack.HeaderField{Name: ":path", Value: "/"},
+					hpack.HeaderField{Name: "expect", Value: "100-continue"},
+				)
+			}
+
+			decodeStatuses := func() []string {
+				var statuses []string
+				h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				for {
+					frame, err := h2framer.ReadFrame()
+					if err != nil {
+						return statuses
+					}
+					fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+					Expect(err).ToNot(HaveOccurred())
+					Expect(fields[0].Name).To(Equal(":status"))
+					statuses = append(statuses, fields[0].Value)
+				}
+			}
+
+			It("sends a 100 Continue response when the handler reads the body", func() {
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Header).ToNot(HaveKey("Expect"))
+					Expect(headerStream.dataWritten.Len()).To(BeZero())
+					body, err := ioutil.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(body).To(Equal([]byte("foobar")))
+					w.WriteHeader(201)
+				})
+				writeRequest()
+				dataStream.dataToRead.Write([]byte("foobar"))
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(decodeStatuses()).To(Equal([]string{"100", "201"}))
+			})
+
+			It("doesn't send a 100 Continue response if the handler doesn't read the body", func() {
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(417)
+				})
+				writeRequest()
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(decodeStatuses()).To(Equal([]string{"417"}))
+				Expect(dataStream.canceledRead).To(BeTrue())
+			})
+
+			It("doesn't send a 100 Continue response after the response headers", func() {
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(200)
+					ioutil.ReadAll(r.Body)
+				})
+				writeRequest()
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(decodeStatuses()).To(Equal([]string{"200"}))
+			})
+		})
+
+		Context("header list size", func() {
+			// 10 header fields with a size of 232 bytes each
+			largeHeaders := func() []hpack.HeaderField {
+				fields := []hpack.HeaderField{
+					{Name: ":method", Value: "GET"},
+					{Name: ":authority", Value: "www.example.com"},
+					{Name: ":path", Value: "/"},
+				}
+				for i := 0; i < 10; i++ {
+					fields = append(fields, hpack.HeaderField{Name: "foo", Value: strings.Repeat("a", 197)})
+				}
+				return fields
+			}
+
+			It("refuses requests with header lists larger than MaxHeaderBytes", func() {
+				s.MaxHeaderBytes = 1000
+				var handlerCalled bool
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					handlerCalled = true
+				})
+				writeHeaders(true, largeHeaders()...)
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Expect(dataStream.canceledRead).To(BeTrue())
+				Expect(dataStream.closed).To(BeTrue())
+				frame, err := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes())).ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(Equal([]hpack.HeaderField{{Name: ":status", Value: "431"}}))
+				Consistently(func() bool { return handlerCalled }).Should(BeFalse())
+			})
+
+			It("accepts requests with header lists smaller than MaxHeaderBytes", func() {
+				s.MaxHeaderBytes = 3000
+				handlerCalled := make(chan struct{})
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(handlerCalled)
+				})
+				writeHeaders(true, largeHeaders()...)
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(handlerCalled).Should(BeClosed())
+			})
+
+			It("resets the stream if the trailers are larger than MaxHeaderBytes", func() {
+				s.MaxHeaderBytes = 1000
+				handlerDone := make(chan struct{})
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					<-handlerDone
+				})
+				writeHeaders(false,
+					hpack.HeaderField{Name: ":method", Value: "POST"},
+					hpack.HeaderField{Name: ":authority", Value: "www.example.com"},
+					hpack.HeaderField{Name: ":path", Value: "/"},
+					hpack.HeaderField{Name: "trailer", Value: "Foo"},
+				)
+				writeHeaders(true, largeHeaders()[3:]...)
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Expect(trailers).To(BeEmpty())
+				Expect(dataStream.canceledRead).To(BeTrue())
+				Expect(dataStream.canceledWrite).To(BeTrue())
+				close(handlerDone)
+			})
+
+			It("saves the client's SETTINGS_MAX_HEADER_LIST_SIZE", func() {
+				Expect(http2.NewFramer(&headerStream.dataToRead, nil).WriteSettings(http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: 1337})).To(Succeed())
+				frame, err := http2.NewFramer(nil, &headerStream.dataToRead).ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+				Expect(s.handleSettingsFrame(frame.(*http2.SettingsFrame), settings)).To(Succeed())
+				Expect(settings.maxHeaderListSize).To(BeEquivalentTo(1337))
+			})
+
+			It("doesn't send response headers larger than the client's limit", func() {
+				settings.maxHeaderListSize = 100
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Foo", strings.Repeat("a", 100))
+					w.WriteHeader(200)
+				})
+				writeHeaders(true,
+					hpack.HeaderField{Name: ":method", Value: "GET"},
+					hpack.HeaderField{Name: ":authority", Value: "www.example.com"},
+					hpack.HeaderField{Name: ":path", Value: "/"},
+				)
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(dataStream.canceledWrite).To(BeTrue())
+				frame, err := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes())).ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(Equal([]hpack.HeaderField{{Name: ":status", Value: "500"}}))
+			})
+		})
+
+		Context("trailers", func() {
+			writeRequest := func() {
+				writeHeaders(false,
+					hpack.HeaderField{Name: ":method", Value: "POST"},
+					hpack.HeaderField{Name: ":authority", Value: "www.example.com"},
+					hpack.HeaderField{Name: ":path", Value: "/"},
+					hpack.HeaderField{Name: "trailer", Value: "Foo"},
+				)
+			}
+
+			It("delivers the request trailers", func() {
+				trailerChan := make(chan http.Header, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Trailer).To(HaveKey("Foo"))
+					Expect(r.Trailer.Get("Foo")).To(BeEmpty())
+					body, err := ioutil.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(body).To(Equal([]byte("foobar")))
+					trailerChan <- r.Trailer
+				})
+				writeRequest()
+				writeHeaders(true, hpack.HeaderField{Name: "foo", Value: "bar"})
+				dataStream.dataToRead.Write([]byte("foobar"))
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Expect(trailers).To(HaveKey(protocol.StreamID(5)))
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Expect(trailers).To(BeEmpty())
+				Eventually(trailerChan).Should(Receive(Equal(http.Header{"Foo": []string{"bar"}})))
+			})
+
+			It("errors if the trailers contain pseudo header fields", func() {
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+				writeRequest()
+				writeHeaders(true, hpack.HeaderField{Name: ":status", Value: "200"})
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
+				Expect(err).To(MatchError(qerr.Error(qerr.InvalidHeadersStreamData, "trailers must not contain pseudo header fields")))
+			})
+
+			It("ignores trailers that weren't announced", func() {
+				var handlerCalls int32
+				handlerDone := make(chan struct{})
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					atomic.AddInt32(&handlerCalls, 1)
+					Expect(r.Trailer).To(BeNil())
+					body, err := ioutil.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(body).To(Equal([]byte("foobar")))
+					close(handlerDone)
+				})
+				writeHeaders(false,
+					hpack.HeaderField{Name: ":method", Value: "POST"},
+					hpack.HeaderField{Name: ":authority", Value: "www.example.com"},
+					hpack.HeaderField{Name: ":path", Value: "/"},
+				)
+				writeHeaders(true, hpack.HeaderField{Name: "foo", Value: "bar"})
+				dataStream.dataToRead.Write([]byte("foobar"))
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Expect(trailers).To(BeEmpty())
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(handlerDone).Should(BeClosed())
+				Consistently(func() int32 { return atomic.LoadInt32(&handlerCalls) }).Should(BeEquivalentTo(1))
+				Expect(dataStream.canceledRead).To(BeFalse())
+			})
+
+			It("sends the response trailers after the handler returned", func() {
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Trailer", "Foo")
+					w.Write([]byte("foobar"))
+					w.Header().Set("Foo", "bar")
+				})
+				writeHeaders(true,
+					hpack.HeaderField{Name: ":method", Value: "GET"},
+					hpack.HeaderField{Name: ":authority", Value: "www.example.com"},
+					hpack.HeaderField{Name: ":path", Value: "/"},
+				)
+				Expect(s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)).To(Succeed())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(dataStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+
+				h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				frame, err := h2framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.(*http2.HeadersFrame).StreamEnded()).To(BeFalse())
+				frame, err = h2framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				hframe := frame.(*http2.HeadersFrame)
+				Expect(hframe.StreamID).To(BeEquivalentTo(5))
+				Expect(hframe.StreamEnded()).To(BeTrue())
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(hframe.HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(Equal([]hpack.HeaderField{{Name: "foo", Value: "bar"}}))
+			})
+		})
+
 		It("errors when non-header frames are received", func() {
 			headerStream.dataToRead.Write([]byte{
 				0x0, 0x0, 0x06, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5,
 				'f', 'o', 'o', 'b', 'a', 'r',
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).To(MatchError("InvalidHeadersStreamData: expected a header frame"))
 		})
 
@@ -304,7 +843,7 @@ var _ = Describe("H2 server", func() {
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
 			dataStream.Close()
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(session, headerStream, &sync.Mutex{}, settings, trailers, hpackDecoder, frames)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeTrue())
@@ -343,6 +882,63 @@ var _ = Describe("H2 server", func() {
 		Expect(session.closedWithError).To(MatchError(qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")))
 	})
 
+	It("refuses a stream with a 10 MB cookie without reading it", func() {
+		requests := make(chan *http.Request, 2)
+		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+		})
+		headerStream := newMockStream(3)
+		close(headerStream.unblockRead)
+		var headers bytes.Buffer
+		enc := hpack.NewEncoder(&headers)
+		framer := http2.NewFramer(&headerStream.dataToRead, nil)
+		Expect(enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})).To(Succeed())
+		Expect(enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})).To(Succeed())
+		Expect(enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})).To(Succeed())
+		// this header field is added to the dynamic table
+		Expect(enc.WriteField(hpack.HeaderField{Name: "foo", Value: "bar"})).To(Succeed())
+		Expect(enc.WriteField(hpack.HeaderField{Name: "cookie", Value: strings.Repeat("a", 10<<20)})).To(Succeed())
+		Expect(framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      5,
+			EndHeaders:    true,
+			EndStream:     true,
+			BlockFragment: headers.Bytes(),
+		})).To(Succeed())
+		// the next request on the header stream is still handled
+		headers.Reset()
+		Expect(enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})).To(Succeed())
+		Expect(enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})).To(Succeed())
+		Expect(enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/foobar"})).To(Succeed())
+		Expect(enc.WriteField(hpack.HeaderField{Name: "foo", Value: "bar"})).To(Succeed())
+		Expect(framer.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      7,
+			EndHeaders:    true,
+			EndStream:     true,
+			BlockFragment: headers.Bytes(),
+		})).To(Succeed())
+		session.streamToAccept = headerStream
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		s.handleHeaderStream(session)
+		runtime.ReadMemStats(&after)
+		Expect(after.TotalAlloc - before.TotalAlloc).To(BeNumerically("<", 256<<10))
+		Expect(dataStream.canceledRead).To(BeTrue())
+		frame, err := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes())).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame.Header().StreamID).To(BeEquivalentTo(5))
+		fields, err := hpack.NewDecoder(4096, nil).DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal([]hpack.HeaderField{{Name: ":status", Value: "431"}}))
+		var req *http.Request
+		Eventually(requests).Should(Receive(&req))
+		Expect(req.URL.Path).To(Equal("/foobar"))
+		Expect(req.Header.Get("Foo")).To(Equal("bar"))
+		Consistently(requests).ShouldNot(Receive())
+		// the session is closed when the header stream is closed
+		Expect(session.closedWithError).To(MatchError(qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")))
+	})
+
 	It("supports closing after first request", func() {
 		s.CloseAfterFirstRequest = true
 		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
@@ -375,6 +971,107 @@ var _ = Describe("H2 server", func() {
 		Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 	})
 
+	Context("graceful shutdown", func() {
+		var headerStream *mockStream
+
+		// readGoAway reads the first frame written to the header stream
+		readGoAway := func() (*http2.GoAwayFrame, error) {
+			frame, err := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes())).ReadFrame()
+			if err != nil {
+				return nil, err
+			}
+			return frame.(*http2.GoAwayFrame), nil
+		}
+
+		BeforeEach(func() {
+			headerStream = newMockStream(3)
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			session.streamToAccept = headerStream
+		})
+
+		It("sends a GOAWAY and waits for active requests", func() {
+			handlerCalled := make(chan struct{})
+			unblockHandler := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(handlerCalled)
+				<-unblockHandler
+			})
+			go s.handleHeaderStream(session)
+			Eventually(handlerCalled).Should(BeClosed())
+			done := make(chan error, 1)
+			go func() { done <- s.Shutdown(context.Background()) }()
+			Eventually(func() error {
+				_, err := readGoAway()
+				return err
+			}).ShouldNot(HaveOccurred())
+			frame, err := readGoAway()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.ErrCode).To(Equal(http2.ErrCodeNo))
+			Expect(frame.LastStreamID).To(BeEquivalentTo(1<<31 - 1))
+			Consistently(done).ShouldNot(Receive())
+			Expect(session.closed).To(BeFalse())
+			close(unblockHandler)
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(session.closed).To(BeTrue())
+		})
+
+		It("closes the server when the context expires", func() {
+			unblockHandler := make(chan struct{})
+			defer close(unblockHandler)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-unblockHandler
+			})
+			go s.handleHeaderStream(session)
+			Eventually(func() int32 { return atomic.LoadInt32(&s.activeRequests) }).Should(BeEquivalentTo(1))
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			Expect(s.Shutdown(ctx)).To(MatchError(context.DeadlineExceeded))
+			Expect(s.closed).To(BeTrue())
+		})
+
+		It("sends a GOAWAY on sessions opened during the shutdown", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			headerStream.dataToRead.Reset()
+			s.mutex.Lock()
+			s.inShutdown = true
+			s.mutex.Unlock()
+			go s.handleHeaderStream(session)
+			Eventually(func() error {
+				_, err := readGoAway()
+				return err
+			}).ShouldNot(HaveOccurred())
+		})
+
+		It("rejects new sessions", func() {
+			ln := newMockListener()
+			ln.sessions <- session
+			quicListenAddr = func(string, *tls.Config, *quic.Config) (quic.Listener, error) {
+				return ln, nil
+			}
+			s.mutex.Lock()
+			s.inShutdown = true
+			s.mutex.Unlock()
+			serveErr := make(chan error, 1)
+			go func() { serveErr <- s.ListenAndServe() }()
+			Eventually(func() bool { return session.closed }).Should(BeTrue())
+			Expect(session.closedWithError).To(MatchError(qerr.Error(qerr.PeerGoingAway, "server shutting down")))
+			Expect(s.Close()).To(Succeed())
+			Eventually(serveErr).Should(Receive())
+		})
+
+		It("calls the shutdown hooks", func() {
+			called := make(chan struct{}, 2)
+			s.RegisterOnShutdown(func() { called <- struct{}{} })
+			s.RegisterOnShutdown(func() { called <- struct{}{} })
+			Expect(s.Shutdown(context.Background())).To(Succeed())
+			Eventually(called).Should(HaveLen(2))
+		})
+	})
+
 	Context("setting http headers", func() {
 		var expected http.Header
 
@@ -489,6 +1186,21 @@ var _ = Describe("H2 server", func() {
 			go s.ListenAndServe()
 			Eventually(func() *quic.Config { return receivedConf }).Should(Equal(conf))
 		})
+
+		It("sets the ALPN protocol on a copy of the tls.Config", func() {
+			tlsConfChan := make(chan *tls.Config, 1)
+			quicListenAddr = func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Listener, error) {
+				tlsConfChan <- tlsConf
+				return nil, errors.New("listen err")
+			}
+			s.TLSConfig.NextProtos = []string{"h2"}
+			go s.ListenAndServe()
+			var tlsConf *tls.Config
+			Eventually(tlsConfChan).Should(Receive(&tlsConf))
+			Expect(tlsConf.NextProtos).To(Equal([]string{nextProtoH2Quic}))
+			Expect(tlsConf.Certificates).To(Equal(s.TLSConfig.Certificates))
+			Expect(s.TLSConfig.NextProtos).To(Equal([]string{"h2"}))
+		})
 	})
 
 	Context("ListenAndServeTLS", func() {
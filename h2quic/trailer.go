@@ -0,0 +1,88 @@
+package h2quic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// trailerFromHeaders parses the header fields of a trailing HEADERS frame.
+// Trailers are sent on the header stream after the body was written to the data stream.
+// Since these streams are independent, the trailers might arrive before the body was read completely.
+func trailerFromHeaders(headers []hpack.HeaderField) (http.Header, error) {
+	trailer := make(http.Header)
+	for _, hf := range headers {
+		if hf.IsPseudo() {
+			return nil, errors.New("trailers must not contain pseudo header fields")
+		}
+		key := http.CanonicalHeaderKey(hf.Name)
+		trailer[key] = append(trailer[key], hf.Value)
+	}
+	return trailer, nil
+}
+
+// isTrailerBlock says if a header block contains trailers.
+// Unlike requests and responses, trailers don't contain any pseudo header fields.
+func isTrailerBlock(headers []hpack.HeaderField) bool {
+	for _, hf := range headers {
+		if hf.IsPseudo() {
+			return false
+		}
+	}
+	return true
+}
+
+// declaredTrailers parses the values of a Trailer header.
+// It returns nil if no trailers are declared.
+// Copied from net/http2/server.go.
+func declaredTrailers(values []string) http.Header {
+	var trailer http.Header
+	for _, v := range values {
+		foreachHeaderElement(v, func(key string) {
+			key = http.CanonicalHeaderKey(key)
+			switch key {
+			case "Transfer-Encoding", "Trailer", "Content-Length":
+				// Bogus. (copy of http1 rules)
+				// Ignore.
+			default:
+				if trailer == nil {
+					trailer = make(http.Header)
+				}
+				trailer[key] = nil
+			}
+		})
+	}
+	return trailer
+}
+
+// commaSeparatedTrailers returns the value of the Trailer header that announces the request trailers.
+// Copied from net/http2/transport.go.
+func commaSeparatedTrailers(req *http.Request) (string, error) {
+	keys := make([]string, 0, len(req.Trailer))
+	for k := range req.Trailer {
+		k = http.CanonicalHeaderKey(k)
+		switch k {
+		case "Transfer-Encoding", "Trailer", "Content-Length":
+			return "", fmt.Errorf("invalid Trailer key %q", k)
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return strings.Join(keys, ","), nil
+	}
+	return "", nil
+}
+
+// encodeTrailers HPACK encodes the values of the trailer keys.
+func encodeTrailers(enc *hpack.Encoder, keys []string, header http.Header) {
+	for _, k := range keys {
+		for _, v := range header[k] {
+			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v})
+		}
+	}
+}
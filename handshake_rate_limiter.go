@@ -0,0 +1,195 @@
+package quic
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+)
+
+type handshakeRateLimiterEntry struct {
+	key          string
+	tokens       float64
+	cookieTokens float64
+	lastUpdate   time.Time
+}
+
+// A HandshakeRateLimiter limits the rate of connection attempts per client address, using a token bucket for every address.
+// Addresses can be aggregated into prefixes, so that a client can't circumvent the limit by using many addresses from the same network.
+// Connection attempts carrying a Cookie that exceed the rate use a second, larger token bucket.
+// The number of tracked addresses is bounded: when the limit is reached, the least recently used address is forgotten.
+type HandshakeRateLimiter struct {
+	mutex sync.Mutex
+
+	rate         float64
+	burst        float64
+	cookieRate   float64
+	cookieBurst  float64
+	ipv4Mask     net.IPMask
+	ipv6Mask     net.IPMask
+	maxAddresses int
+	sendRetry    bool
+
+	m map[string]*list.Element
+	q *list.List
+
+	stats HandshakeRateLimiterStats
+}
+
+// NewHandshakeRateLimiter creates a new HandshakeRateLimiter.
+// It can be used by multiple servers, in which case the limit applies to the connection attempts to all of them.
+func NewHandshakeRateLimiter(config *HandshakeRateLimiterConfig) (*HandshakeRateLimiter, error) {
+	if config == nil || config.Rate <= 0 {
+		return nil, errors.New("quic: the handshake rate must be positive")
+	}
+	if config.Burst < 0 {
+		return nil, fmt.Errorf("quic: invalid handshake burst: %d", config.Burst)
+	}
+	ipv4PrefixLen := config.IPv4PrefixLen
+	if ipv4PrefixLen == 0 {
+		ipv4PrefixLen = 8 * net.IPv4len
+	}
+	if ipv4PrefixLen < 0 || ipv4PrefixLen > 8*net.IPv4len {
+		return nil, fmt.Errorf("quic: invalid IPv4 prefix length: %d", ipv4PrefixLen)
+	}
+	ipv6PrefixLen := config.IPv6PrefixLen
+	if ipv6PrefixLen == 0 {
+		ipv6PrefixLen = 8 * net.IPv6len
+	}
+	if ipv6PrefixLen < 0 || ipv6PrefixLen > 8*net.IPv6len {
+		return nil, fmt.Errorf("quic: invalid IPv6 prefix length: %d", ipv6PrefixLen)
+	}
+	burst := float64(config.Burst)
+	if burst == 0 {
+		burst = config.Rate
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	if config.CookieRate < 0 {
+		return nil, fmt.Errorf("quic: invalid handshake rate for connection attempts with a Cookie: %f", config.CookieRate)
+	}
+	cookieRate := config.CookieRate
+	if cookieRate == 0 {
+		cookieRate = protocol.DefaultCookieRateFactor * config.Rate
+	}
+	if config.CookieBurst < 0 {
+		return nil, fmt.Errorf("quic: invalid handshake burst for connection attempts with a Cookie: %d", config.CookieBurst)
+	}
+	cookieBurst := float64(config.CookieBurst)
+	if cookieBurst == 0 {
+		cookieBurst = protocol.DefaultCookieRateFactor * burst
+	}
+	maxAddresses := config.MaxAddresses
+	if maxAddresses == 0 {
+		maxAddresses = protocol.DefaultMaxRateLimitedAddresses
+	} else if maxAddresses < 0 {
+		return nil, fmt.Errorf("quic: invalid number of rate limited addresses: %d", maxAddresses)
+	}
+	return &HandshakeRateLimiter{
+		rate:         config.Rate,
+		burst:        burst,
+		cookieRate:   cookieRate,
+		cookieBurst:  cookieBurst,
+		ipv4Mask:     net.CIDRMask(ipv4PrefixLen, 8*net.IPv4len),
+		ipv6Mask:     net.CIDRMask(ipv6PrefixLen, 8*net.IPv6len),
+		maxAddresses: maxAddresses,
+		sendRetry:    config.SendRetry,
+		m:            make(map[string]*list.Element),
+		q:            list.New(),
+	}, nil
+}
+
+// Stats returns the number of packets that were rate limited so far.
+func (l *HandshakeRateLimiter) Stats() HandshakeRateLimiterStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.stats
+}
+
+// allow decides about a connection attempt from a client address.
+// It returns RejectWithRetry only if canRetry is set, i.e. if the Initial packet may be answered with a Retry.
+// If the packet carries a Cookie, validateCookie is set.
+// It is only called if the connection attempt exceeds the rate, and if the budget for connection attempts carrying a Cookie allows it.
+// Validating a Cookie is expensive, so invalid Cookies use up this budget as well.
+func (l *HandshakeRateLimiter) allow(addr net.Addr, canRetry bool, now time.Time, validateCookie func() bool) Decision {
+	key := l.key(addr)
+
+	l.mutex.Lock()
+	entry := l.getEntry(key, now)
+	if entry.tokens >= 1 {
+		entry.tokens--
+		l.mutex.Unlock()
+		return Accept
+	}
+	checkCookie := validateCookie != nil && entry.cookieTokens >= 1
+	if checkCookie {
+		entry.cookieTokens--
+	}
+	l.mutex.Unlock()
+
+	// Don't hold the lock while validating the Cookie, the HandshakeRateLimiter might be shared between multiple servers.
+	if checkCookie && validateCookie() {
+		return Accept
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.sendRetry && canRetry {
+		l.stats.RetriesSent++
+		return RejectWithRetry
+	}
+	l.stats.PacketsDropped++
+	return RejectSilently
+}
+
+// getEntry returns the entry for a client address, with the token buckets refilled.
+// It must be called with the mutex held.
+func (l *HandshakeRateLimiter) getEntry(key string, now time.Time) *handshakeRateLimiterEntry {
+	if el, ok := l.m[key]; ok {
+		entry := el.Value.(*handshakeRateLimiterEntry)
+		if elapsed := now.Sub(entry.lastUpdate); elapsed > 0 {
+			entry.tokens = refill(entry.tokens, elapsed.Seconds()*l.rate, l.burst)
+			entry.cookieTokens = refill(entry.cookieTokens, elapsed.Seconds()*l.cookieRate, l.cookieBurst)
+		}
+		entry.lastUpdate = now
+		l.q.MoveToFront(el)
+		return entry
+	}
+	if l.q.Len() >= l.maxAddresses {
+		el := l.q.Back()
+		delete(l.m, el.Value.(*handshakeRateLimiterEntry).key)
+		l.q.Remove(el)
+	}
+	entry := &handshakeRateLimiterEntry{
+		key:          key,
+		tokens:       l.burst,
+		cookieTokens: l.cookieBurst,
+		lastUpdate:   now,
+	}
+	l.m[key] = l.q.PushFront(entry)
+	return entry
+}
+
+func refill(tokens, add, limit float64) float64 {
+	tokens += add
+	if tokens > limit {
+		return limit
+	}
+	return tokens
+}
+
+func (l *HandshakeRateLimiter) key(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		if ip := udpAddr.IP.To4(); ip != nil {
+			return "ip:" + string(ip.Mask(l.ipv4Mask))
+		}
+		return "ip:" + string(udpAddr.IP.Mask(l.ipv6Mask))
+	}
+	return "addr:" + addr.String()
+}
@@ -0,0 +1,159 @@
+package quic
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handshake Rate Limiter", func() {
+	var now time.Time
+
+	addr := func(ip string) net.Addr {
+		return &net.UDPAddr{IP: net.ParseIP(ip), Port: 1337}
+	}
+
+	newRateLimiter := func(config *HandshakeRateLimiterConfig) *HandshakeRateLimiter {
+		l, err := NewHandshakeRateLimiter(config)
+		Expect(err).ToNot(HaveOccurred())
+		return l
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+	})
+
+	It("rejects invalid configs", func() {
+		_, err := NewHandshakeRateLimiter(nil)
+		Expect(err).To(MatchError("quic: the handshake rate must be positive"))
+		_, err = NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: -1})
+		Expect(err).To(MatchError("quic: the handshake rate must be positive"))
+		_, err = NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, IPv4PrefixLen: 33})
+		Expect(err).To(MatchError("quic: invalid IPv4 prefix length: 33"))
+		_, err = NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, IPv6PrefixLen: -1})
+		Expect(err).To(MatchError("quic: invalid IPv6 prefix length: -1"))
+		_, err = NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, Burst: -1})
+		Expect(err).To(MatchError("quic: invalid handshake burst: -1"))
+		_, err = NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, CookieRate: -1})
+		Expect(err).To(MatchError("quic: invalid handshake rate for connection attempts with a Cookie: -1.000000"))
+		_, err = NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, CookieBurst: -1})
+		Expect(err).To(MatchError("quic: invalid handshake burst for connection attempts with a Cookie: -1"))
+	})
+
+	It("allows a burst, and refills the bucket at the configured rate", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 2, Burst: 3})
+		for i := 0; i < 3; i++ {
+			Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		}
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(RejectSilently))
+		now = now.Add(500 * time.Millisecond)
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(RejectSilently))
+		// the bucket never holds more than the burst
+		now = now.Add(time.Hour)
+		for i := 0; i < 3; i++ {
+			Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		}
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(RejectSilently))
+		Expect(l.Stats()).To(Equal(HandshakeRateLimiterStats{PacketsDropped: 3}))
+	})
+
+	It("uses the rate as the default burst", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 2})
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(RejectSilently))
+		l = newRateLimiter(&HandshakeRateLimiterConfig{Rate: 0.1})
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(RejectSilently))
+	})
+
+	It("sends a Retry, if configured and if possible", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, SendRetry: true})
+		Expect(l.allow(addr("192.168.13.37"), true, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.37"), true, now, nil)).To(Equal(RejectWithRetry))
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(RejectSilently))
+		Expect(l.Stats()).To(Equal(HandshakeRateLimiterStats{PacketsDropped: 1, RetriesSent: 1}))
+	})
+
+	It("uses a separate budget for connection attempts carrying a Cookie", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, CookieRate: 2, CookieBurst: 3})
+		var validations int
+		validCookie := func() bool { validations++; return true }
+		invalidCookie := func() bool { validations++; return false }
+		// the Cookie is not validated as long as the rate is not exceeded
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(Accept))
+		Expect(validations).To(BeZero())
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(RejectSilently))
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(Accept))
+		// invalid Cookies use up the budget as well
+		Expect(l.allow(addr("192.168.13.37"), false, now, invalidCookie)).To(Equal(RejectSilently))
+		Expect(validations).To(Equal(3))
+		// the budget is exhausted, the Cookie is not validated any more
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(RejectSilently))
+		Expect(validations).To(Equal(3))
+		Expect(l.Stats()).To(Equal(HandshakeRateLimiterStats{PacketsDropped: 3}))
+		// the budget is refilled at the configured rate
+		now = now.Add(500 * time.Millisecond)
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(RejectSilently))
+		Expect(validations).To(Equal(4))
+	})
+
+	It("uses 10 times the rate and the burst as the default budget for connection attempts carrying a Cookie", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, Burst: 2})
+		validCookie := func() bool { return true }
+		for i := 0; i < 22; i++ {
+			Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(Accept))
+		}
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(RejectSilently))
+		now = now.Add(time.Second)
+		// the first bucket is refilled by 1 token, the second one by 10 tokens
+		for i := 0; i < 11; i++ {
+			Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(Accept))
+		}
+		Expect(l.allow(addr("192.168.13.37"), false, now, validCookie)).To(Equal(RejectSilently))
+	})
+
+	It("limits addresses individually, by default", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 1})
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.38"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("2001:db8::1"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("2001:db8::2"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("2001:db8::2"), false, now, nil)).To(Equal(RejectSilently))
+	})
+
+	It("aggregates addresses into prefixes", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, IPv4PrefixLen: 24, IPv6PrefixLen: 64})
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("192.168.13.38"), false, now, nil)).To(Equal(RejectSilently))
+		Expect(l.allow(addr("192.168.14.37"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("2001:db8::1"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("2001:db8::ffff:1"), false, now, nil)).To(Equal(RejectSilently))
+		Expect(l.allow(addr("2001:db8:0:1::1"), false, now, nil)).To(Equal(Accept))
+	})
+
+	It("handles addresses that are not UDP addresses", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 1})
+		Expect(l.allow(&net.TCPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1337}, false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(&net.TCPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1337}, false, now, nil)).To(Equal(RejectSilently))
+		Expect(l.allow(addr("192.168.13.37"), false, now, nil)).To(Equal(Accept))
+	})
+
+	It("forgets the least recently used address", func() {
+		l := newRateLimiter(&HandshakeRateLimiterConfig{Rate: 1, MaxAddresses: 2})
+		Expect(l.allow(addr("10.0.0.1"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("10.0.0.2"), false, now, nil)).To(Equal(Accept))
+		Expect(l.allow(addr("10.0.0.1"), false, now, nil)).To(Equal(RejectSilently))
+		// 10.0.0.2 is now the least recently used address
+		Expect(l.allow(addr("10.0.0.3"), false, now, nil)).To(Equal(Accept))
+		Expect(l.q.Len()).To(Equal(2))
+		Expect(l.m).To(HaveLen(2))
+		Expect(l.allow(addr("10.0.0.1"), false, now, nil)).To(Equal(RejectSilently))
+		Expect(l.allow(addr("10.0.0.2"), false, now, nil)).To(Equal(Accept))
+	})
+})
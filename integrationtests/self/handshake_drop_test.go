@@ -1,6 +1,7 @@
 package self_test
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	mrand "math/rand"
@@ -30,14 +31,16 @@ var _ = Describe("Handshake drop tests", func() {
 		ln    quic.Listener
 	)
 
-	startListenerAndProxy := func(dropCallback quicproxy.DropCallback, version protocol.VersionNumber) {
+	startListenerAndProxy := func(dropCallback quicproxy.DropCallback, doRetry bool, version protocol.VersionNumber) {
+		conf := &quic.Config{Versions: []protocol.VersionNumber{version}}
+		if !doRetry {
+			conf.AcceptCookie = func(net.Addr, *quic.Cookie) bool { return true }
+		}
 		var err error
 		ln, err = quic.ListenAddr(
 			"localhost:0",
 			testdata.GetTLSConfig(),
-			&quic.Config{
-				Versions: []protocol.VersionNumber{version},
-			},
+			conf,
 		)
 		Expect(err).ToNot(HaveOccurred())
 		serverPort := ln.Addr().(*net.UDPAddr).Port
@@ -59,10 +62,10 @@ var _ = Describe("Handshake drop tests", func() {
 			serverSessionChan := make(chan quic.Session)
 			go func() {
 				defer GinkgoRecover()
-				sess, err := ln.Accept()
+				sess, err := ln.Accept(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				defer sess.Close()
-				str, err := sess.AcceptStream()
+				str, err := sess.AcceptStream(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				b := make([]byte, 6)
 				_, err = gbytes.TimeoutReader(str, 10*time.Second).Read(b)
@@ -94,7 +97,7 @@ var _ = Describe("Handshake drop tests", func() {
 			serverSessionChan := make(chan quic.Session)
 			go func() {
 				defer GinkgoRecover()
-				sess, err := ln.Accept()
+				sess, err := ln.Accept(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				str, err := sess.OpenStream()
 				Expect(err).ToNot(HaveOccurred())
@@ -108,7 +111,7 @@ var _ = Describe("Handshake drop tests", func() {
 				&quic.Config{Versions: []protocol.VersionNumber{version}},
 			)
 			Expect(err).ToNot(HaveOccurred())
-			str, err := sess.AcceptStream()
+			str, err := sess.AcceptStream(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 6)
 			_, err = gbytes.TimeoutReader(str, 10*time.Second).Read(b)
@@ -128,7 +131,7 @@ var _ = Describe("Handshake drop tests", func() {
 			serverSessionChan := make(chan quic.Session)
 			go func() {
 				defer GinkgoRecover()
-				sess, err := ln.Accept()
+				sess, err := ln.Accept(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				serverSessionChan <- sess
 			}()
@@ -157,30 +160,36 @@ var _ = Describe("Handshake drop tests", func() {
 			for _, d := range directions {
 				direction := d
 
-				for _, a := range []*applicationProtocol{clientSpeaksFirst, serverSpeaksFirst, nobodySpeaks} {
-					app := a
-
-					Context(app.name, func() {
-						It(fmt.Sprintf("establishes a connection when the first packet is lost in %s direction", d), func() {
-							startListenerAndProxy(func(d quicproxy.Direction, p uint64) bool {
-								return p == 1 && d.Is(direction)
-							}, version)
-							app.run(version)
-						})
-
-						It(fmt.Sprintf("establishes a connection when the second packet is lost in %s direction", d), func() {
-							startListenerAndProxy(func(d quicproxy.Direction, p uint64) bool {
-								return p == 2 && d.Is(direction)
-							}, version)
-							app.run(version)
-						})
-
-						It(fmt.Sprintf("establishes a connection when 1/5 of the packets are lost in %s direction", d), func() {
-							startListenerAndProxy(func(d quicproxy.Direction, p uint64) bool {
-								return d.Is(direction) && stochasticDropper(5)
-							}, version)
-							app.run(version)
-						})
+				for _, dr := range []bool{true, false} {
+					doRetry := dr
+
+					Context(fmt.Sprintf("retry: %t", doRetry), func() {
+						for _, a := range []*applicationProtocol{clientSpeaksFirst, serverSpeaksFirst, nobodySpeaks} {
+							app := a
+
+							Context(app.name, func() {
+								It(fmt.Sprintf("establishes a connection when the first packet is lost in %s direction", d), func() {
+									startListenerAndProxy(func(d quicproxy.Direction, p uint64) bool {
+										return p == 1 && d.Is(direction)
+									}, doRetry, version)
+									app.run(version)
+								})
+
+								It(fmt.Sprintf("establishes a connection when the second packet is lost in %s direction", d), func() {
+									startListenerAndProxy(func(d quicproxy.Direction, p uint64) bool {
+										return p == 2 && d.Is(direction)
+									}, doRetry, version)
+									app.run(version)
+								})
+
+								It(fmt.Sprintf("establishes a connection when 1/5 of the packets are lost in %s direction", d), func() {
+									startListenerAndProxy(func(d quicproxy.Direction, p uint64) bool {
+										return d.Is(direction) && stochasticDropper(5)
+									}, doRetry, version)
+									app.run(version)
+								})
+							})
+						}
 					})
 				}
 			}
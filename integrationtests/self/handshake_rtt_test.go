@@ -1,14 +1,15 @@
 package self_test
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"net"
 	"time"
 
 	quic "github.com/lucas-clemente/quic-go"
 	quicproxy "github.com/lucas-clemente/quic-go/integrationtests/tools/proxy"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
-	"github.com/lucas-clemente/quic-go/internal/qerr"
 
 	"github.com/lucas-clemente/quic-go/internal/testdata"
 	. "github.com/onsi/ginkgo"
@@ -55,7 +56,7 @@ var _ = Describe("Handshake RTT tests", func() {
 			defer GinkgoRecover()
 			defer close(acceptStopped)
 			for {
-				_, err := server.Accept()
+				_, err := server.Accept(context.Background())
 				if err != nil {
 					return
 				}
@@ -83,7 +84,9 @@ var _ = Describe("Handshake RTT tests", func() {
 		}
 		_, err := quic.DialAddr(proxy.LocalAddr().String(), nil, clientConfig)
 		Expect(err).To(HaveOccurred())
-		Expect(err.(qerr.ErrorCode)).To(Equal(qerr.InvalidVersion))
+		var vnErr *quic.VersionNegotiationError
+		Expect(errors.As(err, &vnErr)).To(BeTrue())
+		Expect(vnErr.Theirs).To(Equal(serverConfig.Versions))
 		expectDurationInRTTs(1)
 	})
 
@@ -126,6 +129,22 @@ var _ = Describe("Handshake RTT tests", func() {
 		expectDurationInRTTs(1)
 	})
 
+	It("is forward-secure after 2 RTTs when the client tests version negotiation", func() {
+		serverConfig.AcceptCookie = func(_ net.Addr, _ *quic.Cookie) bool {
+			return true
+		}
+		clientConfig.TestVersionNegotiation = true
+		runServerAndProxy()
+		sess, err := quic.DialAddr(
+			proxy.LocalAddr().String(),
+			clientTLSConfig,
+			clientConfig,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		expectDurationInRTTs(2)
+		Expect(sess.ConnectionState().Version).To(Equal(protocol.VersionTLS))
+	})
+
 	It("doesn't complete the handshake when the server never accepts the Cookie", func() {
 		serverConfig.AcceptCookie = func(_ net.Addr, _ *quic.Cookie) bool {
 			return false
@@ -138,6 +157,6 @@ var _ = Describe("Handshake RTT tests", func() {
 			clientConfig,
 		)
 		Expect(err).To(HaveOccurred())
-		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.HandshakeTimeout))
+		Expect(errors.Is(err, quic.ErrHandshakeTimeout)).To(BeTrue())
 	})
 })
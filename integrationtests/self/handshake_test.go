@@ -1,12 +1,21 @@
 package self_test
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	quic "github.com/lucas-clemente/quic-go"
+	quicproxy "github.com/lucas-clemente/quic-go/integrationtests/tools/proxy"
+	"github.com/lucas-clemente/quic-go/integrationtests/tools/testserver"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/testdata"
@@ -48,7 +57,7 @@ var _ = Describe("Handshake tests", func() {
 			defer GinkgoRecover()
 			defer close(acceptStopped)
 			for {
-				if _, err := server.Accept(); err != nil {
+				if _, err := server.Accept(context.Background()); err != nil {
 					return
 				}
 			}
@@ -148,6 +157,345 @@ var _ = Describe("Handshake tests", func() {
 		}
 	})
 
+	Context("exporting keying material", func() {
+		It("derives the same keying material on the client and on the server", func() {
+			server, err := quic.ListenAddr("localhost:0", testdata.GetTLSConfig(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer server.Close()
+
+			serverKeyChan := make(chan []byte, 1)
+			go func() {
+				defer GinkgoRecover()
+				sess, err := server.Accept(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				key, err := sess.ExportKeyingMaterial("EXPORTER-test", []byte("context"), 32)
+				Expect(err).ToNot(HaveOccurred())
+				serverKeyChan <- key
+			}()
+
+			sess, err := quic.DialAddr(
+				fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				&tls.Config{InsecureSkipVerify: true},
+				nil,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer sess.Close()
+			clientKey, err := sess.ExportKeyingMaterial("EXPORTER-test", []byte("context"), 32)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clientKey).To(HaveLen(32))
+			var serverKey []byte
+			Eventually(serverKeyChan).Should(Receive(&serverKey))
+			Expect(serverKey).To(Equal(clientKey))
+		})
+	})
+
+	Context("transport parameters", func() {
+		It("exposes the transport parameters advertised by both endpoints", func() {
+			server, err := quic.ListenAddr("localhost:0", testdata.GetTLSConfig(), &quic.Config{
+				IdleTimeout:        42 * time.Second,
+				MaxIncomingStreams: 11,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer server.Close()
+
+			serverParamsChan := make(chan quic.NegotiatedParams, 1)
+			go func() {
+				defer GinkgoRecover()
+				sess, err := server.Accept(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				params, ok := sess.PeerParams()
+				Expect(ok).To(BeTrue())
+				serverParamsChan <- params
+			}()
+
+			sess, err := quic.DialAddr(
+				fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				&tls.Config{InsecureSkipVerify: true},
+				&quic.Config{IdleTimeout: 17 * time.Second, MaxIncomingUniStreams: 7},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer sess.Close()
+			clientParams, ok := sess.PeerParams()
+			Expect(ok).To(BeTrue())
+			Expect(clientParams.Local.IdleTimeout).To(Equal(17 * time.Second))
+			Expect(clientParams.Local.MaxUniStreams).To(BeEquivalentTo(7))
+			Expect(clientParams.Peer.IdleTimeout).To(Equal(42 * time.Second))
+			Expect(clientParams.Peer.MaxBidiStreams).To(BeEquivalentTo(11))
+			var serverParams quic.NegotiatedParams
+			Eventually(serverParamsChan).Should(Receive(&serverParams))
+			Expect(serverParams.Local).To(Equal(clientParams.Peer))
+			Expect(serverParams.Peer).To(Equal(clientParams.Local))
+			// the idle timeout is the minimum of both values
+			Expect(clientParams.IdleTimeout).To(Equal(17 * time.Second))
+			Expect(serverParams.IdleTimeout).To(Equal(17 * time.Second))
+		})
+	})
+
+	Context("accepting sessions before the handshake completes", func() {
+		It("sends data on a stream opened by the server before the handshake completes", func() {
+			server, err := quic.ListenAddrEarly("localhost:0", testdata.GetTLSConfig(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer server.Close()
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				sess, err := server.Accept(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				str, err := sess.OpenStream()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = str.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.Close()).To(Succeed())
+				Eventually(sess.HandshakeComplete()).Should(BeClosed())
+				Expect(sess.ConnectionState().HandshakeComplete).To(BeTrue())
+			}()
+
+			sess, err := quic.DialAddr(
+				fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				&tls.Config{InsecureSkipVerify: true},
+				nil,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer sess.Close()
+			str, err := sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Context("address validation using tokens", func() {
+		var cookies chan *quic.Cookie
+
+		BeforeEach(func() {
+			cookies = make(chan *quic.Cookie, 10)
+			serverConfig.AcceptCookie = func(addr net.Addr, cookie *quic.Cookie) bool {
+				cookies <- cookie
+				// require address validation, like the default AcceptCookie
+				return cookie != nil && cookie.Valid
+			}
+		})
+
+		dial := func(tokenStore quic.TokenStore) quic.Session {
+			sess, err := quic.DialAddr(
+				fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				&tls.Config{InsecureSkipVerify: true},
+				&quic.Config{TokenStore: tokenStore},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			return sess
+		}
+
+		It("uses the token from the previous connection to skip the Retry", func() {
+			runServer()
+			lruTokenStore, err := quic.NewLRUTokenStore(10, 10)
+			Expect(err).ToNot(HaveOccurred())
+			tokenStore := &tokenStore{TokenStore: lruTokenStore, gotToken: make(chan struct{}, 1)}
+			sess := dial(tokenStore)
+			// first connection: the server sends a Retry
+			Expect(<-cookies).To(BeNil())
+			Expect((<-cookies).Valid).To(BeTrue())
+			// the server sends the token after completing the handshake
+			Eventually(tokenStore.gotToken).Should(Receive())
+			sess.Close()
+
+			sess = dial(tokenStore)
+			defer sess.Close()
+			// second connection: the server accepts the token
+			Expect((<-cookies).Valid).To(BeTrue())
+			Expect(cookies).To(BeEmpty())
+		})
+
+		It("falls back to a Retry if the token is invalid", func() {
+			runServer()
+			tokenStore, err := quic.NewLRUTokenStore(10, 10)
+			Expect(err).ToNot(HaveOccurred())
+			tokenStore.Put("localhost", []byte("invalid token"))
+			sess := dial(tokenStore)
+			defer sess.Close()
+			Expect((<-cookies).Valid).To(BeFalse())
+			Expect((<-cookies).Valid).To(BeTrue())
+		})
+	})
+
+	Context("amplification limit", func() {
+		It("sends at most 3x the bytes received before the client's address is validated", func() {
+			serverConfig.AcceptCookie = func(net.Addr, *quic.Cookie) bool { return true }
+			runServer()
+			udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+			Expect(err).ToNot(HaveOccurred())
+			conn := &spoofedPacketConn{PacketConn: udpConn}
+			defer conn.Close()
+			// The client completes the handshake as soon as it receives the server's first flight.
+			// The server never receives any packets after the first Initial.
+			sess, err := quic.Dial(
+				conn,
+				server.Addr(),
+				fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				&tls.Config{InsecureSkipVerify: true},
+				nil,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			// give the server some time to retransmit its packets
+			time.Sleep(time.Second)
+			sess.Close()
+			bytesSent, bytesReceived := conn.Stats()
+			Expect(bytesSent).To(BeNumerically(">=", protocol.MinInitialPacketSize))
+			Expect(bytesReceived).ToNot(BeZero())
+			Expect(bytesReceived).To(BeNumerically("<=", 3*bytesSent))
+		})
+
+		It("completes the handshake when the server's first Handshake flight is lost", func() {
+			// Use a certificate chain that doesn't fit into the server's first flight.
+			tlsConf := testdata.GetTLSConfig()
+			cert := &tlsConf.Certificates[0]
+			for len(bytes.Join(cert.Certificate, nil)) <= 3*protocol.MinInitialPacketSize {
+				cert.Certificate = append(cert.Certificate, cert.Certificate[0])
+			}
+			serverConfig.AcceptCookie = func(net.Addr, *quic.Cookie) bool { return true }
+			var err error
+			server, err = quic.ListenAddr("localhost:0", tlsConf, serverConfig)
+			Expect(err).ToNot(HaveOccurred())
+			go func() {
+				defer GinkgoRecover()
+				defer close(acceptStopped)
+				for {
+					if _, err := server.Accept(context.Background()); err != nil {
+						return
+					}
+				}
+			}()
+
+			// The client's first packet is its Initial, the second one acknowledges the server's Initial.
+			// Drop the second one, and all packets the server sends in response to the client's Initial,
+			// except for the server's Initial.
+			// The server's Initial acknowledges the client's Initial, so the client doesn't have any outstanding crypto packets.
+			// The server is blocked by the amplification limit, so it can't retransmit the lost Handshake packets,
+			// until it receives a packet from the client.
+			var numIncoming uint64
+			proxy, err := quicproxy.NewQuicProxy("localhost:0", &quicproxy.Opts{
+				RemoteAddr: fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				DropPacket: func(dir quicproxy.Direction, packetCount uint64) bool {
+					if dir == quicproxy.DirectionIncoming {
+						atomic.StoreUint64(&numIncoming, packetCount)
+						return packetCount == 2
+					}
+					return packetCount > 1 && atomic.LoadUint64(&numIncoming) == 1
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer proxy.Close()
+
+			sess, err := quic.DialAddr(
+				fmt.Sprintf("localhost:%d", proxy.LocalPort()),
+				&tls.Config{InsecureSkipVerify: true},
+				&quic.Config{HandshakeTimeout: 5 * time.Second},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.ConnectionState().HandshakeComplete).To(BeTrue())
+			sess.Close()
+		})
+	})
+
+	Context("ALPN", func() {
+		dial := func(server quic.Listener, nextProtos []string) (quic.Session, error) {
+			return quic.DialAddr(
+				fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				&tls.Config{InsecureSkipVerify: true, NextProtos: nextProtos},
+				nil,
+			)
+		}
+
+		It("negotiates an application protocol", func() {
+			tlsConf := testdata.GetTLSConfig()
+			tlsConf.NextProtos = []string{"foo", "bar"}
+			server, err := quic.ListenAddr("localhost:0", tlsConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer server.Close()
+
+			serverProtoChan := make(chan string, 1)
+			go func() {
+				defer GinkgoRecover()
+				sess, err := server.Accept(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				serverProtoChan <- sess.ConnectionState().NegotiatedProtocol
+			}()
+
+			sess, err := dial(server, []string{"bar"})
+			Expect(err).ToNot(HaveOccurred())
+			defer sess.Close()
+			Expect(sess.ConnectionState().NegotiatedProtocol).To(Equal("bar"))
+			Eventually(serverProtoChan).Should(Receive(Equal("bar")))
+		})
+
+		It("fails the handshake if the server doesn't support any of the client's protocols", func() {
+			tlsConf := testdata.GetTLSConfig()
+			tlsConf.NextProtos = []string{"foo"}
+			server, err := quic.ListenAddr("localhost:0", tlsConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer server.Close()
+
+			_, err = dial(server, []string{"bar"})
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, quic.ErrNoApplicationProtocol)).To(BeTrue())
+			Expect(quic.IsRemoteClose(err)).To(BeTrue())
+		})
+
+		It("fails the handshake if the server doesn't select a protocol", func() {
+			server, err := quic.ListenAddr("localhost:0", testdata.GetTLSConfig(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer server.Close()
+
+			_, err = dial(server, []string{"bar"})
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, quic.ErrNoApplicationProtocol)).To(BeTrue())
+			Expect(quic.IsRemoteClose(err)).To(BeFalse())
+		})
+	})
+
+	Context("cipher suites", func() {
+		It("uses ChaCha20-Poly1305, if the client only offers this cipher suite", func() {
+			server, err := quic.ListenAddr("localhost:0", testdata.GetTLSConfig(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer server.Close()
+
+			serverSuiteChan := make(chan uint16, 1)
+			go func() {
+				defer GinkgoRecover()
+				sess, err := server.Accept(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				str, err := sess.AcceptStream(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				_, err = io.Copy(str, str)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.Close()).To(Succeed())
+				serverSuiteChan <- sess.ConnectionState().CipherSuite
+			}()
+
+			sess, err := quic.DialAddr(
+				fmt.Sprintf("localhost:%d", server.Addr().(*net.UDPAddr).Port),
+				&tls.Config{InsecureSkipVerify: true, CipherSuites: []uint16{tls.TLS_CHACHA20_POLY1305_SHA256}},
+				nil,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer sess.Close()
+			Expect(sess.ConnectionState().CipherSuite).To(Equal(tls.TLS_CHACHA20_POLY1305_SHA256))
+			str, err := sess.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write(testserver.PRData)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+			data, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(testserver.PRData))
+			Eventually(serverSuiteChan).Should(Receive(Equal(tls.TLS_CHACHA20_POLY1305_SHA256)))
+		})
+	})
+
 	Context("rate limiting", func() {
 		var server quic.Listener
 
@@ -182,10 +530,12 @@ var _ = Describe("Handshake tests", func() {
 			_, err := dial()
 			Expect(err).To(HaveOccurred())
 			// TODO(#1567): use the SERVER_BUSY error code
-			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+			var connErr *quic.ConnectionError
+			Expect(errors.As(err, &connErr)).To(BeTrue())
+			Expect(qerr.ErrorCode(connErr.ErrorCode)).To(Equal(qerr.PeerGoingAway))
 
 			// now accept one session, freeing one spot in the queue
-			_, err = server.Accept()
+			_, err = server.Accept(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			// dial again, and expect that this dial succeeds
 			sess, err := dial()
@@ -196,7 +546,8 @@ var _ = Describe("Handshake tests", func() {
 			_, err = dial()
 			Expect(err).To(HaveOccurred())
 			// TODO(#1567): use the SERVER_BUSY error code
-			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+			Expect(errors.As(err, &connErr)).To(BeTrue())
+			Expect(qerr.ErrorCode(connErr.ErrorCode)).To(Equal(qerr.PeerGoingAway))
 		})
 
 		It("rejects new connection attempts if connections don't get accepted", func() {
@@ -213,7 +564,9 @@ var _ = Describe("Handshake tests", func() {
 			_, err = dial()
 			Expect(err).To(HaveOccurred())
 			// TODO(#1567): use the SERVER_BUSY error code
-			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+			var connErr *quic.ConnectionError
+			Expect(errors.As(err, &connErr)).To(BeTrue())
+			Expect(qerr.ErrorCode(connErr.ErrorCode)).To(Equal(qerr.PeerGoingAway))
 
 			// Now close the one of the session that are waiting to be accepted.
 			// This should free one spot in the queue.
@@ -227,8 +580,53 @@ var _ = Describe("Handshake tests", func() {
 
 			_, err = dial()
 			// TODO(#1567): use the SERVER_BUSY error code
-			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.PeerGoingAway))
+			Expect(errors.As(err, &connErr)).To(BeTrue())
+			Expect(qerr.ErrorCode(connErr.ErrorCode)).To(Equal(qerr.PeerGoingAway))
 		})
 
 	})
 })
+
+// spoofedPacketConn simulates a client that spoofed its address.
+// Only the first packet is sent, since the client never receives the server's response.
+type spoofedPacketConn struct {
+	net.PacketConn
+
+	mutex         sync.Mutex
+	bytesSent     int
+	bytesReceived int
+}
+
+func (c *spoofedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.bytesSent > 0 {
+		return len(b), nil
+	}
+	c.bytesSent = len(b)
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *spoofedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.mutex.Lock()
+	c.bytesReceived += n
+	c.mutex.Unlock()
+	return n, addr, err
+}
+
+func (c *spoofedPacketConn) Stats() (sent, received int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bytesSent, c.bytesReceived
+}
+
+type tokenStore struct {
+	quic.TokenStore
+	gotToken chan struct{}
+}
+
+func (s *tokenStore) Put(key string, token []byte) {
+	s.TokenStore.Put(key, token)
+	s.gotToken <- struct{}{}
+}
@@ -2,10 +2,15 @@ package self_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	quic "github.com/lucas-clemente/quic-go"
@@ -101,6 +106,252 @@ var _ = Describe("HTTP tests", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(bytes.Equal(body, testserver.PRData)).To(BeTrue())
 			})
+
+			It("waits for the 100 Continue response before uploading", func() {
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+					// the body is only sent if the server sends the 100 Continue response
+					ExpectContinueTimeout: time.Hour,
+				}
+				defer rt.Close()
+				req, err := http.NewRequest("POST", "https://localhost:"+testserver.Port()+"/echo", bytes.NewReader(testserver.PRData))
+				Expect(err).ToNot(HaveOccurred())
+				req.Header.Set("Expect", "100-continue")
+				resp, err := (&http.Client{Transport: rt}).Do(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				body, err := ioutil.ReadAll(gbytes.TimeoutReader(resp.Body, 5*time.Second))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bytes.Equal(body, testserver.PRData)).To(BeTrue())
+			})
+
+			It("rejects requests with too large header lists", func() {
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				defer rt.Close()
+				req, err := http.NewRequest("GET", "https://localhost:"+testserver.Port()+"/hello", nil)
+				Expect(err).ToNot(HaveOccurred())
+				// The value is added to the HPACK dynamic table, so the header block stays small.
+				// The decoded header list is larger than http.DefaultMaxHeaderBytes.
+				value := strings.Repeat("a", 3000)
+				for i := 0; i < 400; i++ {
+					req.Header.Add("Foo", value)
+				}
+				resp, err := (&http.Client{Transport: rt}).Do(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusRequestHeaderFieldsTooLarge))
+				// the session can still be used
+				resp, err = (&http.Client{Transport: rt}).Get("https://localhost:" + testserver.Port() + "/hello")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+			})
+
+			It("sends and receives trailers", func() {
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				defer rt.Close()
+				req, err := http.NewRequest("POST", "https://localhost:"+testserver.Port()+"/echotrailers", bytes.NewReader(testserver.PRDataLong))
+				Expect(err).ToNot(HaveOccurred())
+				req.Trailer = http.Header{"Foo": []string{"bar"}}
+				resp, err := (&http.Client{Transport: rt}).Do(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				Expect(resp.Trailer).To(HaveKey("Body-Length"))
+				Expect(resp.Trailer.Get("Body-Length")).To(BeEmpty())
+				body, err := ioutil.ReadAll(gbytes.TimeoutReader(resp.Body, 20*time.Second))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(bytes.Equal(body, testserver.PRDataLong)).To(BeTrue())
+				Expect(resp.Trailer.Get("Body-Length")).To(Equal(strconv.Itoa(len(testserver.PRDataLong))))
+				Expect(resp.Trailer.Get("Echoed-Foo")).To(Equal("bar"))
+			})
+
+			It("cancels a request while the response is streamed", func() {
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				defer rt.Close()
+				ctx, cancel := context.WithCancel(context.Background())
+				req, err := http.NewRequest("GET", "https://localhost:"+testserver.Port()+"/stream", nil)
+				Expect(err).ToNot(HaveOccurred())
+				resp, err := (&http.Client{Transport: rt}).Do(req.WithContext(ctx))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				_, err = io.ReadFull(resp.Body, make([]byte, 1024))
+				Expect(err).ToNot(HaveOccurred())
+				cancel()
+				_, err = ioutil.ReadAll(gbytes.TimeoutReader(resp.Body, 5*time.Second))
+				Expect(err).To(MatchError(context.Canceled))
+			})
+
+			It("hijacks the stream", func() {
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				defer rt.Close()
+				resp, err := (&http.Client{Transport: rt}).Get("https://localhost:" + testserver.Port() + "/hijack")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				body, err := ioutil.ReadAll(gbytes.TimeoutReader(resp.Body, 3*time.Second))
+				Expect(err).ToNot(HaveOccurred())
+				_, port, err := net.SplitHostPort(string(body))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(port).ToNot(BeEmpty())
+			})
+
+			It("shuts down gracefully", func() {
+				unblockHandler := make(chan struct{})
+				server := &h2quic.Server{
+					Server: &http.Server{
+						TLSConfig: testdata.GetTLSConfig(),
+						Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							defer GinkgoRecover()
+							w.Write(testserver.PRData[:1024])
+							w.(http.Flusher).Flush()
+							<-unblockHandler
+							w.Write(testserver.PRData[1024:])
+						}),
+					},
+					QuicConfig: &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				go server.Serve(conn)
+
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				defer rt.Close()
+				resp, err := (&http.Client{Transport: rt}).Get(fmt.Sprintf("https://localhost:%d/", conn.LocalAddr().(*net.UDPAddr).Port))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				_, err = io.ReadFull(resp.Body, make([]byte, 1024))
+				Expect(err).ToNot(HaveOccurred())
+
+				shutdownErr := make(chan error, 1)
+				go func() { shutdownErr <- server.Shutdown(context.Background()) }()
+				Consistently(shutdownErr).ShouldNot(Receive())
+				close(unblockHandler)
+				body, err := ioutil.ReadAll(gbytes.TimeoutReader(resp.Body, 5*time.Second))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(body).To(Equal(testserver.PRData[1024:]))
+				Eventually(shutdownErr, 5*time.Second).Should(Receive(BeNil()))
+			})
+
+			It("tunnels data through CONNECT requests", func() {
+				server := &h2quic.Server{
+					Server: &http.Server{
+						TLSConfig: testdata.GetTLSConfig(),
+						Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							defer GinkgoRecover()
+							Expect(r.Method).To(Equal("CONNECT"))
+							Expect(r.Host).To(Equal("target.clemente.io:443"))
+							w.WriteHeader(200)
+							// echo everything until the client closes its side of the tunnel
+							_, err := io.Copy(w, r.Body)
+							Expect(err).ToNot(HaveOccurred())
+						}),
+					},
+					QuicConfig: &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				go server.Serve(conn)
+				defer server.Close()
+
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+				}
+				defer rt.Close()
+				pr, pw := io.Pipe()
+				req, err := http.NewRequest("CONNECT", fmt.Sprintf("https://localhost:%d", conn.LocalAddr().(*net.UDPAddr).Port), pr)
+				Expect(err).ToNot(HaveOccurred())
+				req.Host = "target.clemente.io:443"
+				resp, err := rt.RoundTrip(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				body := gbytes.TimeoutReader(resp.Body, 5*time.Second)
+				_, err = pw.Write([]byte("foo"))
+				Expect(err).ToNot(HaveOccurred())
+				data := make([]byte, 3)
+				_, err = io.ReadFull(body, data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foo")))
+				_, err = pw.Write([]byte("bar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(pw.Close()).To(Succeed())
+				rest, err := ioutil.ReadAll(body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rest).To(Equal([]byte("bar")))
+			})
+
+			It("uses a custom dial function, connecting to a different address than the requested host", func() {
+				var dialedAddr, serverName string
+				rt := &h2quic.RoundTripper{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+					Dial: func(ctx context.Context, network, addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+						dialedAddr = addr
+						serverName = tlsConf.ServerName
+						// connect to the IP address, instead of resolving localhost
+						return quic.DialAddrContext(ctx, "127.0.0.1:"+testserver.Port(), tlsConf, config)
+					},
+				}
+				defer rt.Close()
+				resp, err := (&http.Client{Transport: rt}).Get("https://localhost:" + testserver.Port() + "/hello")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(200))
+				Expect(dialedAddr).To(Equal("localhost:" + testserver.Port()))
+				Expect(serverName).To(Equal("localhost"))
+				body, err := ioutil.ReadAll(gbytes.TimeoutReader(resp.Body, 3*time.Second))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal("Hello, World!\n"))
+			})
+
+			Context("server push", func() {
+				get := func(rt *h2quic.RoundTripper, path string) string {
+					resp, err := (&http.Client{Transport: rt}).Get("https://localhost:" + testserver.Port() + path)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(resp.StatusCode).To(Equal(200))
+					body, err := ioutil.ReadAll(gbytes.TimeoutReader(resp.Body, 3*time.Second))
+					Expect(err).ToNot(HaveOccurred())
+					return string(body)
+				}
+
+				newRoundTripper := func(disablePush bool) *h2quic.RoundTripper {
+					return &h2quic.RoundTripper{
+						TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+						QuicConfig:      &quic.Config{Versions: []protocol.VersionNumber{version}},
+						DisablePush:     disablePush,
+					}
+				}
+
+				It("uses pushed responses", func() {
+					rt := newRoundTripper(false)
+					defer rt.Close()
+					Expect(get(rt, "/push")).To(Equal("Hello, World!\n"))
+					Expect(get(rt, "/pushed")).To(Equal("pushed"))
+					// the pushed response is only used once
+					Expect(get(rt, "/pushed")).To(Equal("not pushed"))
+				})
+
+				It("doesn't push if the client disabled push", func() {
+					rt := newRoundTripper(true)
+					defer rt.Close()
+					Expect(get(rt, "/push")).To(Equal("Hello, World!\n"))
+					Expect(get(rt, "/pushed")).To(Equal("not pushed"))
+				})
+			})
 		})
 	}
 })
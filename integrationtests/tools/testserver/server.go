@@ -56,12 +56,67 @@ func init() {
 		io.WriteString(w, "Hello, World!\n") // don't check the error here. Stream may be reset.
 	})
 
+	// /push pushes /pushed, before responding with "Hello, World!".
+	// /pushed responds with "pushed" if it was pushed.
+	http.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		if pusher, ok := w.(http.Pusher); ok {
+			pusher.Push("/pushed", &http.PushOptions{Header: http.Header{"X-Pushed": []string{"true"}}}) // don't check the error here. The client might have disabled push.
+		}
+		io.WriteString(w, "Hello, World!\n") // don't check the error here. Stream may be reset.
+	})
+
+	http.HandleFunc("/pushed", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		if r.Header.Get("X-Pushed") == "true" {
+			io.WriteString(w, "pushed") // don't check the error here. Stream may be reset.
+			return
+		}
+		io.WriteString(w, "not pushed") // don't check the error here. Stream may be reset.
+	})
+
 	http.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
 		defer GinkgoRecover()
 		body, err := ioutil.ReadAll(r.Body)
 		Expect(err).NotTo(HaveOccurred())
 		w.Write(body) // don't check the error here. Stream may be reset.
 	})
+
+	// streams data until the request is canceled
+	http.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		for {
+			if _, err := w.Write(PRData[:1024]); err != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			default:
+			}
+		}
+	})
+
+	// hijacks the stream, and writes the client's address to it
+	http.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		sess := r.Context().Value(h2quic.ServerSessionContextKey).(quic.Session)
+		str, _, err := w.(h2quic.StreamHijacker).HijackStream()
+		Expect(err).ToNot(HaveOccurred())
+		io.WriteString(str, sess.RemoteAddr().String()) // don't check the error here. Stream may be reset.
+		str.Close()
+	})
+
+	// echoes the body, and sends its length and the value of the Foo request trailer in the response trailers
+	http.HandleFunc("/echotrailers", func(w http.ResponseWriter, r *http.Request) {
+		defer GinkgoRecover()
+		w.Header().Set("Trailer", "Body-Length, Echoed-Foo")
+		body, err := ioutil.ReadAll(r.Body)
+		Expect(err).NotTo(HaveOccurred())
+		w.Write(body) // don't check the error here. Stream may be reset.
+		w.Header().Set("Body-Length", strconv.Itoa(len(body)))
+		w.Header().Set("Echoed-Foo", r.Trailer.Get("Foo"))
+	})
 }
 
 // See https://en.wikipedia.org/wiki/Lehmer_random_number_generator
@@ -2,12 +2,16 @@ package quic
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"time"
 
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/handshake"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/logging"
 )
 
 // The StreamID is the ID of a QUIC stream.
@@ -16,10 +20,173 @@ type StreamID = protocol.StreamID
 // A VersionNumber is a QUIC version number.
 type VersionNumber = protocol.VersionNumber
 
-// A Cookie can be used to verify the ownership of the client address.
+// A ByteCount is a number of bytes.
+type ByteCount = protocol.ByteCount
+
+// A PacketNumber is a QUIC packet number.
+type PacketNumber = protocol.PacketNumber
+
+// RTTStats holds the round trip time measurements of a session.
+type RTTStats = congestion.RTTStats
+
+// A CongestionControl performs congestion control for a single session.
+// It is only used from the session's run loop, so it doesn't need to be safe for concurrent use.
+// See Config.CongestionControl.
+type CongestionControl interface {
+	// TimeUntilSend returns the pacing interval, i.e. the time it takes to send a packet of 1460 bytes at the pacing rate.
+	// Packets are paced using a token bucket, allowing small bursts. 0 means that packets are not paced.
+	TimeUntilSend(bytesInFlight ByteCount) time.Duration
+	// OnPacketSent is called for every packet sent.
+	// bytesInFlight is the number of bytes in flight, including this packet.
+	OnPacketSent(sentTime time.Time, bytesInFlight ByteCount, packetNumber PacketNumber, bytes ByteCount, isRetransmittable bool)
+	// GetCongestionWindow returns the congestion window.
+	// No new packets are sent while the bytes in flight exceed the congestion window.
+	GetCongestionWindow() ByteCount
+	// MaybeExitSlowStart is called when an ACK is received, before any of the packets are processed.
+	MaybeExitSlowStart()
+	// OnPacketAcked is called for every packet that was acknowledged.
+	OnPacketAcked(number PacketNumber, ackedBytes ByteCount, priorInFlight ByteCount, eventTime time.Time)
+	// OnPacketLost is called for every packet that was declared lost.
+	OnPacketLost(number PacketNumber, lostBytes ByteCount, priorInFlight ByteCount)
+	// OnPacketDiscarded is called for every packet that will neither be acknowledged nor declared lost,
+	// because its keys were dropped. It is removed from the bytes in flight.
+	OnPacketDiscarded(number PacketNumber, bytes ByteCount)
+	// OnCongestionEvent is called when the peer reports new ECN-CE marks.
+	OnCongestionEvent(largestAcked PacketNumber, priorInFlight ByteCount)
+	// OnPersistentCongestion is called when all packets sent over a period of multiple PTOs were lost.
+	// This happens if the path was black-holed. The congestion window should be collapsed to the minimum.
+	OnPersistentCongestion()
+	// OnConnectionMigration is called when the session migrates to a new path.
+	OnConnectionMigration()
+}
+
+// A Cookie is a token sent by the client, which can be used to verify the ownership of the client address.
 type Cookie struct {
+	// RemoteAddr is the address that the Cookie was issued for.
+	// For UDP addresses, this is the IP address, without the port.
 	RemoteAddr string
-	SentTime   time.Time
+	// SentTime is the time when the Cookie was issued.
+	SentTime time.Time
+	// Valid is true if the CookieGenerator successfully validated the Cookie,
+	// i.e. the Cookie was issued for the client's address and hasn't expired yet.
+	// RemoteAddr and SentTime are only set for valid Cookies.
+	Valid bool
+}
+
+// ConnectionInfo contains information about a connection attempt, see Config.AcceptConnection.
+type ConnectionInfo struct {
+	// RemoteAddr is the address of the client.
+	RemoteAddr net.Addr
+	// Cookie is the Cookie sent by the client. It is nil if the client didn't send a Cookie.
+	Cookie *Cookie
+	// Version is the QUIC version the client is trying to use.
+	Version VersionNumber
+	// ServerName is the server name (SNI) requested by the client.
+	// It is empty if the client didn't send a server name,
+	// or if it couldn't be read from the first packet of the connection attempt.
+	ServerName string
+}
+
+// A Decision is the decision about a connection attempt, see Config.AcceptConnection.
+type Decision uint8
+
+const (
+	// Accept accepts the connection attempt.
+	Accept Decision = iota
+	// RejectSilently drops the packet, without sending a response.
+	RejectSilently
+	// RejectWithRetry sends a Retry, which requires the client to prove that it owns its address.
+	// The client then sends a new connection attempt with a Cookie.
+	RejectWithRetry
+)
+
+// A CookieGenerator generates and validates the Cookies sent in Retry packets.
+// Servers using the same CookieGenerator (or the same key, see NewCookieGenerator)
+// can validate each other's Cookies.
+type CookieGenerator interface {
+	// Generate generates a new Cookie for the client address.
+	// The data has to be saved in the Cookie, and returned by Validate.
+	Generate(clientAddr net.Addr, data []byte) ([]byte, error)
+	// Validate validates a Cookie sent by the client.
+	// It returns the Cookie, with the address it was issued for and the time it was issued, and the data saved in the Cookie.
+	// It returns an error if the Cookie wasn't issued for the client address, or if it expired.
+	Validate(cookie []byte, clientAddr net.Addr) (*Cookie, []byte, error)
+}
+
+// A CookieGeneratorConfig configures the CookieGenerator returned by NewCookieGenerator.
+type CookieGeneratorConfig struct {
+	// Key is the 32 byte key used to protect the Cookies.
+	// If not set, a random key is used, and Cookies can't be validated after a restart.
+	Key []byte
+	// Lifetime is the time that Cookies are valid for.
+	// If this value is zero, Cookies are valid for 24 hours.
+	Lifetime time.Duration
+	// IPv4PrefixLen is the number of bits of an IPv4 address that Cookies are bound to.
+	// For example, a value of 24 allows a Cookie to be used from any address in the client's /24 network.
+	// If this value is zero, Cookies are bound to the full address.
+	IPv4PrefixLen int
+	// IPv6PrefixLen is the number of bits of an IPv6 address that Cookies are bound to.
+	// If this value is zero, Cookies are bound to the full address.
+	IPv6PrefixLen int
+	// Clock is used to get the current time when issuing and validating Cookies.
+	// If not set, the system clock is used.
+	Clock Clock
+}
+
+// A HandshakeRateLimiterConfig configures the HandshakeRateLimiter returned by NewHandshakeRateLimiter.
+type HandshakeRateLimiterConfig struct {
+	// Rate is the number of connection attempts per second that are allowed from a single client address.
+	// It must be positive.
+	Rate float64
+	// Burst is the number of connection attempts that a client address may start at once.
+	// If this value is zero, it defaults to the Rate, but at least 1.
+	Burst int
+	// CookieRate is the number of connection attempts per second carrying a valid Cookie that are allowed
+	// from a single client address, in addition to the Rate.
+	// The client proved that it owns its address, so these connection attempts weren't sent by an attacker spoofing the address.
+	// The Cookie is only validated if the connection attempt exceeds the Rate, and invalid Cookies count against this rate as well.
+	// If this value is zero, it defaults to 10 times the Rate.
+	CookieRate float64
+	// CookieBurst is the number of connection attempts carrying a valid Cookie that a client address may start at once,
+	// in addition to the Burst.
+	// If this value is zero, it defaults to 10 times the Burst.
+	CookieBurst int
+	// IPv4PrefixLen is the number of bits of an IPv4 address that are used to identify a client.
+	// For example, a value of 24 applies a single limit to all addresses in a /24 network.
+	// If this value is zero, the full address is used.
+	IPv4PrefixLen int
+	// IPv6PrefixLen is the number of bits of an IPv6 address that are used to identify a client.
+	// Since clients usually control a large range of IPv6 addresses, a value of 64 or less should be used.
+	// If this value is zero, the full address is used.
+	IPv6PrefixLen int
+	// MaxAddresses is the maximum number of client addresses (or address prefixes) that are tracked.
+	// When this number is reached, the least recently used address is forgotten.
+	// If this value is zero, 10000 addresses are tracked.
+	MaxAddresses int
+	// SendRetry defines whether a Retry is sent in response to connection attempts exceeding the rate,
+	// instead of dropping them.
+	// This allows clients to prove that they own their address, which prevents an attacker
+	// from exhausting the limit of another client by spoofing its address.
+	// Connection attempts carrying a valid Cookie are limited by the CookieRate.
+	SendRetry bool
+}
+
+// HandshakeRateLimiterStats contains the number of packets that were rate limited by a HandshakeRateLimiter.
+type HandshakeRateLimiterStats struct {
+	// PacketsDropped is the number of Initial packets that were dropped.
+	PacketsDropped uint64
+	// RetriesSent is the number of Initial packets that were answered with a Retry.
+	RetriesSent uint64
+}
+
+// A TokenStore stores the tokens that servers send after the handshake (in NEW_TOKEN frames).
+// Each token is only used once, see NewLRUTokenStore.
+type TokenStore interface {
+	// Pop returns a token for the key, and removes it from the store.
+	// It returns nil if no token is stored for the key.
+	Pop(key string) []byte
+	// Put stores a token for the key.
+	Put(key string, token []byte)
 }
 
 // ConnectionState records basic details about the QUIC connection.
@@ -36,7 +203,8 @@ type Stream interface {
 	// Read can be made to time out and return a net.Error with Timeout() == true
 	// after a fixed time limit; see SetDeadline and SetReadDeadline.
 	// If the stream was canceled by the peer, the error implements the StreamError
-	// interface, and Canceled() == true.
+	// interface, and Canceled() == true and Remote() == true.
+	// Data received before the peer reset the stream is returned before the StreamError.
 	io.Reader
 	// Write writes data to the stream.
 	// Write can be made to time out and return a net.Error with Timeout() == true
@@ -51,16 +219,20 @@ type Stream interface {
 	io.Closer
 	// CancelWrite aborts sending on this stream.
 	// Data already written, but not yet delivered to the peer is not guaranteed to be delivered reliably.
-	// Write will unblock immediately, and future calls to Write will fail.
+	// Write will unblock immediately, and future calls to Write will fail with a StreamError.
 	// When called multiple times or after closing the stream it is a no-op.
 	CancelWrite(ErrorCode)
 	// CancelRead aborts receiving on this stream.
 	// It will ask the peer to stop transmitting stream data.
-	// Read will unblock immediately, and future Read calls will fail.
+	// Read will unblock immediately, and future Read calls will fail with a StreamError.
 	// When called multiple times or after reading the io.EOF it is a no-op.
 	CancelRead(ErrorCode)
 	// The context is canceled as soon as the write-side of the stream is closed.
 	// This happens when Close() is called, or when the stream is reset (either locally or remotely).
+	// It is also canceled when the peer resets the read-side of the stream,
+	// and when both directions of the stream have completed.
+	// If the stream was reset, context.Cause returns the StreamError.
+	// If the session was closed, context.Cause returns the session's error.
 	// Warning: This API should not be considered stable and might change soon.
 	Context() context.Context
 	// SetReadDeadline sets the deadline for future Read calls and
@@ -73,12 +245,164 @@ type Stream interface {
 	// some of the data was successfully written.
 	// A zero value for t means Write will not time out.
 	SetWriteDeadline(t time.Time) error
+	// SetPriority sets the priority of the stream.
+	// When packing packets, data from streams with a higher priority is sent first.
+	// Streams with the same priority are served round-robin.
+	// The default priority is 0. It can be changed at any time,
+	// and applies to all packets packed after the call.
+	SetPriority(int)
+	// WriteVectors writes the data of all slices, as if they were concatenated and passed to Write.
+	// The slices are not concatenated: a STREAM frame can contain data from multiple slices.
+	// If Config.MaxStreamSendBuffer is set, the data is copied to the send buffer.
+	// Otherwise, the slices are retained until all data was sent, and copied when packing STREAM frames.
+	// In both cases, the slices can be reused after WriteVectors returns,
+	// unless the stream retains the data (see SetWriteBufferOwnership).
+	// If the write deadline expires or writing is canceled, the number of bytes written is returned,
+	// and the remaining data is not sent.
+	WriteVectors(data [][]byte) (int, error)
+	// TryWrite writes as much of p to the stream's send buffer as fits, without blocking.
+	// It returns the number of bytes written, which is 0 if the send buffer is full.
+	// It requires Config.MaxStreamSendBuffer to be set, and can't be used if the stream retains the data
+	// (see SetWriteBufferOwnership). Otherwise, it returns an error.
+	TryWrite(p []byte) (int, error)
+	// SetWriteBufferOwnership sets if Write copies the data passed to it, see BufferOwnership.
+	// If the data is retained, release is called with every slice passed to Write (and WriteVectors)
+	// as soon as the stream doesn't reference it any more, i.e. when all data sent from it was acknowledged,
+	// or when the stream was canceled. If none of the data was sent, release is called before Write returns.
+	// release can be called on any goroutine, including the one calling Write or CancelWrite,
+	// and it must not block.
+	// Config.MaxStreamSendBuffer doesn't apply when the data is retained: Write blocks until all data was sent.
+	// It must be called before writing any data to the stream.
+	SetWriteBufferOwnership(ownership BufferOwnership, release func([]byte)) error
+	// Peek returns up to n bytes of received data, without consuming it.
+	// It blocks until at least one byte is available, like Read.
+	// If fewer than n bytes were received so far, the data that's available is returned.
+	// If the stream ends (or was reset by the peer) before n bytes, the remaining data is returned
+	// together with io.EOF (or the StreamError).
+	// The returned slice is only valid until the next call to Read, Peek or Discard, or until the session is closed.
+	// Peek respects the read deadline. It doesn't consume any flow control credit.
+	Peek(n int) ([]byte, error)
+	// Discard skips the next n bytes, without copying them.
+	// It blocks until n bytes were discarded, or an error occurs, and returns the number of bytes discarded.
+	// Discarded data is treated like data that was read: the peer is granted flow control credit for it.
+	Discard(n int) (int, error)
+	// SendStreamState returns the state of the send direction of the stream.
+	SendStreamState() SendStreamState
+	// ReceiveStreamState returns the state of the receive direction of the stream.
+	ReceiveStreamState() ReceiveStreamState
+	// Stats returns statistics about the data transferred on this stream.
+	// It is safe to call Stats concurrently with Read and Write.
+	Stats() StreamStats
 	// SetDeadline sets the read and write deadlines associated
 	// with the connection. It is equivalent to calling both
 	// SetReadDeadline and SetWriteDeadline.
 	SetDeadline(t time.Time) error
+	// LocalAddr returns the local address of the session the stream belongs to.
+	// If the session migrates to a new path, the new address is returned.
+	// Together with RemoteAddr, this allows using a Stream as a net.Conn.
+	// Note that Close only closes the write direction of the stream.
+	LocalAddr() net.Addr
+	// RemoteAddr returns the address of the peer, see LocalAddr.
+	RemoteAddr() net.Addr
+}
+
+// StreamStats contains statistics about a stream.
+type StreamStats struct {
+	// BytesWritten is the number of bytes written by the application that were packed into STREAM frames.
+	BytesWritten uint64
+	// BytesAcked is the number of bytes acknowledged by the peer.
+	BytesAcked uint64
+	// BytesRetransmitted is the number of bytes retransmitted, because the packets containing them were lost.
+	BytesRetransmitted uint64
+	// BytesRead is the number of bytes read by the application.
+	BytesRead uint64
+	// BytesBuffered is the number of bytes received, that weren't read by the application yet.
+	// This includes data that was received out of order.
+	BytesBuffered uint64
+	// BytesQueued is the number of bytes written by the application that weren't sent yet.
+	BytesQueued uint64
+	// FlowControlBlocked is set if there's data to send, but the stream is blocked by flow control.
+	// This can be either stream-level or connection-level flow control.
+	FlowControlBlocked bool
+	// SendState says what's currently keeping the stream from sending data.
+	SendState SendState
+	// TimeApplicationLimited is the cumulative time the stream didn't have any data to send.
+	TimeApplicationLimited time.Duration
+	// TimeFlowControlBlocked is the cumulative time the stream was blocked by flow control.
+	TimeFlowControlBlocked time.Duration
+	// TimeCongestionLimited is the cumulative time the stream had data to send,
+	// but was waiting for the congestion controller to allow sending it.
+	TimeCongestionLimited time.Duration
 }
 
+// SendState says what's limiting the sending of data.
+type SendState uint8
+
+const (
+	// SendStateApplicationLimited means that there's no data waiting to be sent.
+	SendStateApplicationLimited SendState = iota
+	// SendStateFlowControlBlocked means that there's data waiting to be sent,
+	// but the peer's flow control limit was reached.
+	// This usually means that the peer is not reading the data fast enough.
+	SendStateFlowControlBlocked
+	// SendStateCongestionLimited means that there's data waiting to be sent,
+	// but the congestion controller (or the pacer) doesn't allow sending it yet.
+	SendStateCongestionLimited
+)
+
+// SendStreamState is the state of the send direction of a stream.
+// Once the session is closed, the state doesn't change any more.
+type SendStreamState uint8
+
+const (
+	// SendStreamOpen means that data can be written to the stream.
+	SendStreamOpen SendStreamState = iota
+	// SendStreamDataQueued means that the stream was closed,
+	// but the FIN wasn't sent yet, since there's still data queued.
+	SendStreamDataQueued
+	// SendStreamFinSent means that the FIN was sent,
+	// but the peer didn't acknowledge all data (and the FIN) yet.
+	SendStreamFinSent
+	// SendStreamFinAcked means that the peer acknowledged all data and the FIN.
+	SendStreamFinAcked
+	// SendStreamResetSent means that writing was canceled, either by CancelWrite,
+	// or because the peer sent a STOP_SENDING frame, and a RESET_STREAM frame was sent.
+	SendStreamResetSent
+)
+
+// ReceiveStreamState is the state of the receive direction of a stream.
+// Calling CancelRead doesn't change the state.
+// Once the session is closed, the state doesn't change any more.
+type ReceiveStreamState uint8
+
+const (
+	// ReceiveStreamOpen means that the peer didn't send the FIN yet.
+	ReceiveStreamOpen ReceiveStreamState = iota
+	// ReceiveStreamFinReceived means that the peer sent the FIN,
+	// but not all data was read yet.
+	// Some of the data might not have been received yet.
+	ReceiveStreamFinReceived
+	// ReceiveStreamDataRead means that all data was read, up to the FIN.
+	ReceiveStreamDataRead
+	// ReceiveStreamResetReceived means that the peer reset the stream.
+	ReceiveStreamResetReceived
+)
+
+// BufferOwnership says if a stream copies the data passed to Write.
+type BufferOwnership uint8
+
+const (
+	// BufferOwnershipCopied means that the data is copied when packing STREAM frames
+	// (or to the send buffer, if Config.MaxStreamSendBuffer is set).
+	// The slice passed to Write can be reused as soon as Write returns.
+	// This is the default.
+	BufferOwnershipCopied BufferOwnership = iota
+	// BufferOwnershipRetained means that the data is not copied.
+	// STREAM frames (and their retransmissions) reference the slice passed to Write,
+	// so it must not be modified until it is released.
+	BufferOwnershipRetained
+)
+
 // A ReceiveStream is a unidirectional Receive Stream.
 type ReceiveStream interface {
 	// see Stream.StreamID
@@ -89,6 +413,12 @@ type ReceiveStream interface {
 	CancelRead(ErrorCode)
 	// see Stream.SetReadDealine
 	SetReadDeadline(t time.Time) error
+	// see Stream.Peek
+	Peek(n int) ([]byte, error)
+	// see Stream.Discard
+	Discard(n int) (int, error)
+	// see Stream.ReceiveStreamState
+	ReceiveStreamState() ReceiveStreamState
 }
 
 // A SendStream is a unidirectional Send Stream.
@@ -105,62 +435,260 @@ type SendStream interface {
 	Context() context.Context
 	// see Stream.SetWriteDeadline
 	SetWriteDeadline(t time.Time) error
+	// see Stream.SetPriority
+	SetPriority(int)
+	// see Stream.WriteVectors
+	WriteVectors(data [][]byte) (int, error)
+	// see Stream.TryWrite
+	TryWrite(p []byte) (int, error)
+	// see Stream.SetWriteBufferOwnership
+	SetWriteBufferOwnership(ownership BufferOwnership, release func([]byte)) error
+	// see Stream.SendStreamState
+	SendStreamState() SendStreamState
 }
 
-// StreamError is returned by Read and Write when the peer cancels the stream.
+// StreamError is returned by Read and Write when the stream is canceled,
+// either locally (using CancelRead or CancelWrite) or by the peer.
 type StreamError interface {
 	error
 	Canceled() bool
 	ErrorCode() ErrorCode
+	// Remote says if the stream was canceled by the peer,
+	// i.e. if a RESET_STREAM or STOP_SENDING frame was received.
+	Remote() bool
+}
+
+// A DatagramTooLargeError is returned by Session.SendMessage if the message doesn't fit into a single DATAGRAM frame.
+type DatagramTooLargeError struct {
+	// MaxDataLen is the maximum size of a message that can be sent.
+	MaxDataLen int64
 }
 
+func (e *DatagramTooLargeError) Error() string {
+	return fmt.Sprintf("DATAGRAM frame too large (maximum message size: %d bytes)", e.MaxDataLen)
+}
+
+// A StatelessResetError is returned when the session is closed by a stateless reset sent by the peer.
+type StatelessResetError struct {
+	// Token is the stateless reset token that was received.
+	Token [16]byte
+}
+
+func (e *StatelessResetError) Error() string {
+	return "received a stateless reset"
+}
+
+// ErrServerClosed is returned by the Listener's Accept method after a call to Close.
+var ErrServerClosed = errors.New("quic: server closed")
+
 // A Session is a QUIC connection between two peers.
 type Session interface {
 	// AcceptStream returns the next stream opened by the peer, blocking until one is available.
-	AcceptStream() (Stream, error)
+	// If the context is canceled, AcceptStream returns ctx.Err(). The session is not affected.
+	AcceptStream(context.Context) (Stream, error)
 	// AcceptUniStream returns the next unidirectional stream opened by the peer, blocking until one is available.
-	AcceptUniStream() (ReceiveStream, error)
+	// If the context is canceled, AcceptUniStream returns ctx.Err(). The session is not affected.
+	AcceptUniStream(context.Context) (ReceiveStream, error)
 	// OpenStream opens a new bidirectional QUIC stream.
 	// There is no signaling to the peer about new streams:
 	// The peer can only accept the stream after data has been sent on the stream.
 	// If the error is non-nil, it satisfies the net.Error interface.
-	// When reaching the peer's stream limit, err.Temporary() will be true.
+	// When reaching the peer's stream limit, err.Temporary() will be true,
+	// and the error matches ErrTooManyOpenStreams (using errors.Is).
 	OpenStream() (Stream, error)
 	// OpenStreamSync opens a new bidirectional QUIC stream.
-	// It blocks until a new stream can be opened.
-	// If the error is non-nil, it satisfies the net.Error interface.
-	OpenStreamSync() (Stream, error)
+	// It blocks until a new stream can be opened, or until the context is canceled.
+	// If the context is canceled, OpenStreamSync returns ctx.Err().
+	// Any other non-nil error satisfies the net.Error interface.
+	OpenStreamSync(context.Context) (Stream, error)
 	// OpenUniStream opens a new outgoing unidirectional QUIC stream.
 	// If the error is non-nil, it satisfies the net.Error interface.
-	// When reaching the peer's stream limit, Temporary() will be true.
+	// When reaching the peer's stream limit, Temporary() will be true,
+	// and the error matches ErrTooManyOpenStreams (using errors.Is).
 	OpenUniStream() (SendStream, error)
 	// OpenUniStreamSync opens a new outgoing unidirectional QUIC stream.
-	// It blocks until a new stream can be opened.
-	// If the error is non-nil, it satisfies the net.Error interface.
-	OpenUniStreamSync() (SendStream, error)
+	// It blocks until a new stream can be opened, or until the context is canceled.
+	// If the context is canceled, OpenUniStreamSync returns ctx.Err().
+	// Any other non-nil error satisfies the net.Error interface.
+	OpenUniStreamSync(context.Context) (SendStream, error)
 	// LocalAddr returns the local address.
 	LocalAddr() net.Addr
 	// RemoteAddr returns the address of the peer.
 	RemoteAddr() net.Addr
 	// Close the connection.
 	io.Closer
-	// Close the connection with an error.
-	// The error must not be nil.
-	CloseWithError(ErrorCode, error) error
+	// Close the connection with an application error.
+	// The error code and the reason phrase are sent to the peer in a CONNECTION_CLOSE frame.
+	// All streams are closed with an error carrying this code and reason.
+	CloseWithError(ErrorCode, string) error
+	// CloseGracefully closes the connection after all data written to the streams has been delivered to the peer.
+	// New streams can't be opened or accepted any more, and the session is closed as soon
+	// as all stream data (including retransmissions) has been acknowledged.
+	// If this takes longer than the timeout, the session is closed anyway, and an error is returned.
+	CloseGracefully(timeout time.Duration) error
 	// The context is cancelled when the session is closed.
+	// context.Cause returns the error that the session was closed with.
 	// Warning: This API should not be considered stable and might change soon.
 	Context() context.Context
 	// ConnectionState returns basic details about the QUIC connection.
 	// Warning: This API should not be considered stable and might change soon.
 	ConnectionState() ConnectionState
+	// ExportKeyingMaterial derives keying material from the session, e.g. for channel binding.
+	// Both endpoints derive the same keying material for the same label and context.
+	// It returns an error if it is called before the handshake completes.
+	// The keying material is not the same as the one exported by the TLS 1.3 exporter (RFC 8446, section 7.5),
+	// and it is not the same as the one exported by other QUIC implementations.
+	ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error)
+	// Stats returns statistics about the session.
+	// It is cheap to call and safe to call from any goroutine.
+	Stats() SessionStats
+	// PeerParams returns the transport parameters advertised by the peer, and our own advertised values.
+	// It returns false if the peer's transport parameters weren't received yet.
+	// The returned values are a snapshot, and are safe to use from any goroutine.
+	PeerParams() (NegotiatedParams, bool)
+	// Ping sends a PING frame to the peer and waits until it is acknowledged.
+	// It returns the round-trip time measured for this PING.
+	// If the session is closed before the PING is acknowledged, the error that closed the session is returned.
+	Ping(context.Context) (time.Duration, error)
+	// SendMessage sends a message as an unreliable DATAGRAM frame.
+	// Messages are not retransmitted if they are lost, and they might be delivered out of order.
+	// It is only possible to send messages if both endpoints enabled them using Config.EnableDatagrams.
+	// If the message is too large to fit into a single packet, a *DatagramTooLargeError is returned.
+	SendMessage([]byte) error
+	// ReceiveMessage gets a message received in a DATAGRAM frame.
+	// It blocks until a message arrives, or the session is closed.
+	ReceiveMessage() ([]byte, error)
+	// Migrate moves the session to a new packet conn, e.g. when the network interface of the client changed.
+	// The new path is validated by sending a PATH_CHALLENGE before switching to it.
+	// Once validated, the congestion controller and the RTT estimate are reset, and all data that wasn't acknowledged is retransmitted.
+	// If the packet conn was created by DialAddr, it is closed. The new packet conn is not closed when the session is closed.
+	// Only clients can migrate, and only after the handshake completed.
+	Migrate(net.PacketConn) error
+}
+
+// An EarlySession is a session that is returned by an EarlyListener before the handshake completes.
+// The server already processed the client's ClientHello, but the client's identity isn't verified yet
+// (e.g. client certificates), and ConnectionState blocks until the handshake completes.
+// Data written to streams opened by the server is sent as 0.5-RTT data, i.e. it is encrypted
+// with the forward-secure keys, but sent before the client completed the handshake.
+// Streams opened by the client can only be accepted after the handshake completes,
+// and ExportKeyingMaterial returns an error until then.
+type EarlySession interface {
+	Session
+	// HandshakeComplete returns a channel that is closed when the handshake completes.
+	// If the handshake fails (or doesn't complete within the Config.HandshakeTimeout),
+	// the session is closed and the channel is never closed. Use the Context to detect that.
+	HandshakeComplete() <-chan struct{}
+}
+
+// TransportParameters are the transport parameters advertised by an endpoint during the handshake.
+type TransportParameters struct {
+	// IdleTimeout is the idle timeout. It is sent in seconds.
+	IdleTimeout time.Duration
+	// MaxPacketSize is the maximum size of packets the endpoint is willing to receive.
+	// It is 0 if the peer didn't send this parameter.
+	MaxPacketSize ByteCount
+	// InitialMaxData is the initial connection-level flow control window.
+	InitialMaxData ByteCount
+	// InitialMaxStreamDataBidiLocal is the initial flow control window for bidirectional streams opened by the endpoint.
+	InitialMaxStreamDataBidiLocal ByteCount
+	// InitialMaxStreamDataBidiRemote is the initial flow control window for bidirectional streams opened by its peer.
+	InitialMaxStreamDataBidiRemote ByteCount
+	// InitialMaxStreamDataUni is the initial flow control window for unidirectional streams opened by its peer.
+	InitialMaxStreamDataUni ByteCount
+	// MaxBidiStreams is the number of bidirectional streams its peer is allowed to open.
+	MaxBidiStreams uint64
+	// MaxUniStreams is the number of unidirectional streams its peer is allowed to open.
+	MaxUniStreams uint64
+	// MaxAckDelay is the maximum time by which the endpoint delays sending ACKs. It is sent in milliseconds.
+	MaxAckDelay time.Duration
+	// MaxDatagramFrameSize is the maximum size of a DATAGRAM frame the endpoint is willing to receive.
+	// 0 means that the endpoint doesn't support DATAGRAM frames.
+	MaxDatagramFrameSize ByteCount
+	// DisableMigration is set if the endpoint doesn't support connection migration.
+	DisableMigration bool
+}
+
+// NegotiatedParams are the transport parameters advertised by both endpoints, see Session.PeerParams.
+type NegotiatedParams struct {
+	// Local are the transport parameters we sent.
+	Local TransportParameters
+	// Peer are the transport parameters the peer sent.
+	Peer TransportParameters
+	// IdleTimeout is the idle timeout used for the session, i.e. the minimum of both endpoints' idle timeouts.
+	// The session is closed with an error matching ErrIdleTimeout if no packet was received for this duration.
+	// Unless a Config.KeepAlivePeriod is set, keep-alive PINGs are sent after half of this duration.
+	IdleTimeout time.Duration
+}
+
+// SessionStats contains statistics about a session.
+// The packet and byte counters only ever increase.
+type SessionStats struct {
+	// SmoothedRTT is the smoothed round-trip time.
+	SmoothedRTT time.Duration
+	// RTTVariance is the mean deviation of the round-trip time.
+	RTTVariance time.Duration
+	// MinRTT is the minimum round-trip time observed.
+	MinRTT time.Duration
+	// LatestRTT is the most recent round-trip time sample.
+	LatestRTT time.Duration
+	// PacketsSent is the number of packets sent.
+	PacketsSent uint64
+	// PacketsLost is the number of packets that were declared lost.
+	PacketsLost uint64
+	// SpuriousLosses is the number of packets that were declared lost, but were acknowledged later.
+	SpuriousLosses uint64
+	// PTOs is the number of times the probe timeout fired.
+	PTOs uint64
+	// PersistentCongestions is the number of times persistent congestion was detected,
+	// i.e. all packets sent over a period of multiple PTOs were lost.
+	PersistentCongestions uint64
+	// BytesRetransmitted is the size of all packets that were retransmitted.
+	BytesRetransmitted uint64
+	// CongestionWindow is the current congestion window.
+	CongestionWindow uint64
+	// BytesInFlight is the number of bytes sent, but not yet acknowledged or declared lost.
+	BytesInFlight uint64
+	// PacingRate is the rate at which packets are paced, in bits per second.
+	// It is 0 if packets are not paced.
+	PacingRate uint64
+	// SendState says what's currently keeping the session from sending data.
+	// The session is only considered flow control blocked if it is blocked by connection-level flow control.
+	// Use Stream.Stats to find out which streams are blocked by stream-level flow control.
+	SendState SendState
+	// TimeApplicationLimited is the cumulative time the session didn't have any data to send.
+	TimeApplicationLimited time.Duration
+	// TimeFlowControlBlocked is the cumulative time the session was blocked by connection-level flow control.
+	TimeFlowControlBlocked time.Duration
+	// TimeCongestionLimited is the cumulative time the session had data to send,
+	// but was waiting for the congestion controller to allow sending it.
+	TimeCongestionLimited time.Duration
 }
 
 // Config contains all configuration data needed for a QUIC server or client.
 type Config struct {
 	// The QUIC versions that can be negotiated.
+	// A client offers the first version, and uses the other versions if the server doesn't support it.
+	// A server offers these versions to clients that use a different version, so every Listener can support a different set of versions.
 	// If not set, it uses all versions available.
 	// Warning: This API should not be considered stable and will change soon.
 	Versions []VersionNumber
+	// VersionNegotiationCallback is called by the client if the server doesn't support the version the client offered.
+	// It is called with the versions supported by the server, as sent in the Version Negotiation packet,
+	// and returns the version to use, which must be one of the Versions.
+	// If it returns false, the connection attempt is aborted, and Dial returns a VersionNegotiationError.
+	// Since the Version Negotiation packet is not authenticated, the callback is called again during the handshake
+	// with the versions announced by the server in the (authenticated) transport parameters.
+	// If it then chooses a different version, the handshake fails, as this is an indication of a version downgrade attack.
+	// If not set, the first of the Versions that is supported by the server is used.
+	// This option is only valid for the client.
+	VersionNegotiationCallback func(serverVersions []VersionNumber) (VersionNumber, bool)
+	// TestVersionNegotiation makes the client start the connection with a reserved version that no server supports,
+	// forcing the server to send a Version Negotiation packet. This costs one round trip,
+	// and is useful to check that version negotiation works with a server, and with the network path to it.
+	// Servers always include a reserved version in Version Negotiation packets, which clients ignore.
+	// This option is only valid for the client.
+	TestVersionNegotiation bool
 	// The length of the connection ID in bytes.
 	// It can be 0, or any value between 4 and 18.
 	// If not set, the interpretation depends on where the Config is used:
@@ -169,25 +697,60 @@ type Config struct {
 	// When dialing on a packet conn, the ConnectionIDLength value must be the same for every Dial call.
 	ConnectionIDLength int
 	// HandshakeTimeout is the maximum duration that the cryptographic handshake may take.
-	// If the timeout is exceeded, the connection is closed.
+	// If the timeout is exceeded, the connection is closed with a HandshakeTimeout error, which is returned by Dial.
+	// The server closes sessions that didn't complete the handshake within this time.
 	// If this value is zero, the timeout is set to 10 seconds.
 	HandshakeTimeout time.Duration
 	// IdleTimeout is the maximum duration that may pass without any incoming network activity.
 	// This value only applies after the handshake has completed.
+	// It is sent to the peer, and the minimum of both endpoints' values is used (see NegotiatedParams.IdleTimeout).
 	// If the timeout is exceeded, the connection is closed.
 	// If this value is zero, the timeout is set to 30 seconds.
 	IdleTimeout time.Duration
 	// AcceptCookie determines if a Cookie is accepted.
 	// It is called with cookie = nil if the client didn't send an Cookie.
-	// If not set, it verifies that the address matches, and that the Cookie was issued within the last 24 hours.
+	// If not set, it accepts Cookies that were successfully validated by the CookieGenerator.
+	// If the Cookie is not accepted, the server sends a Retry and doesn't allocate any state for the connection.
+	// Clients follow the Retry transparently, at the cost of one additional round trip.
+	// To skip the address validation, use a function that always returns true.
 	// This option is only valid for the server.
 	AcceptCookie func(clientAddr net.Addr, cookie *Cookie) bool
+	// AcceptConnection decides about every connection attempt, e.g. to reject clients based on their address,
+	// or to limit the number of connections per client.
+	// It is called for the first packet of a connection attempt, before any state is allocated for the connection.
+	// If it returns RejectWithRetry for a client that already sent a valid Cookie, the connection attempt fails,
+	// since a client only follows a single Retry.
+	// If set, AcceptCookie is not used.
+	// This option is only valid for the server.
+	AcceptConnection func(info ConnectionInfo) Decision
+	// HandshakeRateLimiter limits the rate of connection attempts per client address.
+	// It is applied before any cryptographic work is done for a connection attempt.
+	// Connection attempts carrying a valid Cookie have a larger budget, see HandshakeRateLimiterConfig.CookieRate.
+	// A HandshakeRateLimiter can be shared between multiple servers, see NewHandshakeRateLimiter.
+	// If not set, connection attempts are not rate limited.
+	// This option is only valid for the server.
+	HandshakeRateLimiter *HandshakeRateLimiter
+	// CookieGenerator generates and validates Cookies.
+	// If not set, a CookieGenerator using a random key is used, see NewCookieGenerator.
+	// This option is only valid for the server.
+	CookieGenerator CookieGenerator
+	// TokenStore stores the tokens that the server sends after the handshake.
+	// A token is used when dialing the same server (as identified by the hostname) again,
+	// so that the server can skip the address validation, saving the round trip for the Retry.
+	// If not set, tokens are not stored.
+	// This option is only valid for the client.
+	TokenStore TokenStore
 	// MaxReceiveStreamFlowControlWindow is the maximum stream-level flow control window for receiving data.
-	// If this value is zero, it will default to 1 MB for the server and 6 MB for the client.
+	// If this value is zero, it will default to 6 MB.
+	// It must not be larger than the MaxReceiveConnectionFlowControlWindow, otherwise Dial and Listen return an error.
 	MaxReceiveStreamFlowControlWindow uint64
 	// MaxReceiveConnectionFlowControlWindow is the connection-level flow control window for receiving data.
-	// If this value is zero, it will default to 1.5 MB for the server and 15 MB for the client.
+	// If this value is zero, it will default to 15 MB.
 	MaxReceiveConnectionFlowControlWindow uint64
+	// MaxStreamSendBuffer is the maximum number of bytes that are buffered for sending on a stream.
+	// Write copies the data to the send buffer, and only blocks if the send buffer is full.
+	// If not set, data is not buffered: Write blocks until all data was sent.
+	MaxStreamSendBuffer uint64
 	// MaxIncomingStreams is the maximum number of concurrent bidirectional streams that a peer is allowed to open.
 	// If not set, it will default to 100.
 	// If set to a negative value, it doesn't allow any bidirectional streams.
@@ -198,14 +761,151 @@ type Config struct {
 	MaxIncomingUniStreams int
 	// KeepAlive defines whether this peer will periodically send PING frames to keep the connection alive.
 	KeepAlive bool
+	// KeepAlivePeriod is the time after which a PING frame is sent, if no packet was received from the peer.
+	// It is only used if KeepAlive is set.
+	// If not set, it will default to half the idle timeout negotiated with the peer.
+	KeepAlivePeriod time.Duration
+	// EnableDatagrams defines whether unreliable messages (DATAGRAM frames) can be sent and received.
+	// Messages can only be sent if the peer enabled them as well.
+	EnableDatagrams bool
+	// DisableSpinBit disables the latency spin bit, which allows on-path observers to measure the RTT of a connection.
+	// Even if not set, the spin bit is disabled for a random fraction of connections.
+	DisableSpinBit bool
+	// DisableMigration disables connection migration.
+	// A server announces this to the client, and doesn't switch to new addresses of the client.
+	// A client can't migrate using Session.Migrate.
+	DisableMigration bool
+	// StatelessResetKey is used to derive the stateless reset tokens for the connection IDs used on a packet conn.
+	// If set, a stateless reset is sent in response to packets for unknown connection IDs,
+	// e.g. after a server restart, allowing the peer to detect that the connection was lost.
+	// All Listeners and Dialers using the same packet conn need to use the same key.
+	// If not set, no stateless resets are sent.
+	StatelessResetKey []byte
+	// ActiveConnectionIDs is the number of connection IDs the server issues to the client, including the one used during the handshake.
+	// Additional connection IDs are issued using NEW_CONNECTION_ID frames after the handshake completes.
+	// The client switches to a new connection ID when migrating to a new path, such that the paths can't be linked by an observer.
+	// If this value is zero, 4 connection IDs are issued. If it is negative, no additional connection IDs are issued.
+	// It must not be larger than 8.
+	// This option is only valid for the server.
+	ActiveConnectionIDs int
+	// ConnectionIDGenerator generates the connection IDs used by the server, e.g. to encode routing information for a load balancer.
+	// It is called with the ConnectionIDLength, and must return a connection ID of exactly this length.
+	// Connection IDs that are already in use on the same packet conn are rejected, and a new one is generated.
+	// If not set, random connection IDs are used.
+	// This option is only valid for the server.
+	ConnectionIDGenerator func(length int) ([]byte, error)
+	// NonQUICPacketHandler is called for packets received on the packet conn that are not QUIC packets,
+	// allowing the packet conn to be shared with a different UDP-based protocol (e.g. STUN).
+	// A packet is considered a non-QUIC packet if its header can't be parsed, which is the case for every packet that has the QUIC fixed bit unset.
+	// The handler is called on a separate go routine, one packet at a time. If it doesn't keep up, packets are dropped.
+	// The data must not be used after the handler returns.
+	// All Listeners and Dialers using the same packet conn should use the same handler.
+	// If not set, non-QUIC packets are dropped.
+	NonQUICPacketHandler func(data []byte, addr net.Addr)
+	// DiffServCodePoint is the DSCP used to mark all packets, e.g. 46 for Expedited Forwarding.
+	// It is applied to the IPv4 TOS byte and the IPv6 traffic class of the UDP connection created by DialAddr and ListenAddr.
+	// Packet conns passed to Dial and Listen are left untouched.
+	// It must be smaller than 64, and DialAddr and ListenAddr return an error if it can't be applied.
+	// If not set, packets are not marked.
+	DiffServCodePoint uint8
+	// CongestionControl creates the congestion controller for a new session.
+	// It must not return nil, otherwise the session can't be established.
+	// NewRenoCongestionControl and NewBBRCongestionControl provide alternatives to the default.
+	// If not set, Cubic is used, see NewCubicCongestionControl.
+	CongestionControl func(rttStats *RTTStats) CongestionControl
+	// InitialCongestionWindow is the initial congestion window, in packets.
+	// It must not be larger than 1000 packets.
+	// If not set, it defaults to 32 packets.
+	// This option only applies to the default congestion controller, it is ignored if CongestionControl is set.
+	InitialCongestionWindow uint32
+	// MinCongestionWindow is the minimum congestion window, in packets.
+	// The congestion window is never reduced below this value, not even after a retransmission timeout.
+	// It must not be larger than the InitialCongestionWindow.
+	// If not set, it defaults to 2 packets, or to the InitialCongestionWindow if that is smaller.
+	// This option only applies to the default congestion controller, it is ignored if CongestionControl is set.
+	MinCongestionWindow uint32
+	// MaxAckDelay is the maximum time by which ACKs are delayed.
+	// It is advertised to the peer, which takes it into account when estimating the RTT.
+	// It must be smaller than 16384ms, and is rounded down to milliseconds.
+	// If not set, it defaults to 25ms.
+	MaxAckDelay time.Duration
+	// AckFrequency is the number of retransmittable packets received before an ACK is sent.
+	// An ACK is sent earlier if MaxAckDelay expires, if a packet was received out of order, or if a packet was marked with ECN-CE.
+	// If not set, an ACK is sent for every 2 retransmittable packets.
+	AckFrequency int
+	// Tracer is used to trace the packet- and frame-level events of each session, e.g. to count packets or to write a log.
+	// The ConnectionTracer is called synchronously from the session's run loop, and must not block.
+	// If not set, no events are traced.
+	Tracer logging.Tracer
+	// GetLogWriter is used to write a qlog trace of each session, e.g. to a file.
+	// It is called with the perspective and the original destination connection ID of a new session.
+	// The trace is written in the JSON-SEQ format, the writer is closed when the session is closed.
+	// If it returns nil, no trace is written for this session.
+	GetLogWriter func(p logging.Perspective, connectionID []byte) io.WriteCloser
+	// Logger is used for logging by the server or client, and by all its sessions.
+	// The log messages of a session are prefixed with the original destination connection ID.
+	// If not set, the default logger is used, which is configured using the QUIC_GO_LOG_LEVEL environment variable.
+	Logger Logger
+	// Clock is used by the session run loop to get the current time and to set its timer.
+	// All timestamps used for loss detection, RTT measurement, congestion control, flow control and timeouts are taken from it.
+	// It is also used for the send state statistics of streams, and by the server's default CookieGenerator.
+	// It is intended for tests that run sessions on a simulated network, see the quictest package.
+	// Stream deadlines always use the system clock.
+	// If not set, the system clock is used.
+	Clock Clock
+}
+
+// A Logger is used for logging.
+// Messages logged with Debugf are very verbose, and only logged if Debug returns true.
+type Logger interface {
+	// Debug says if debug logging is enabled.
+	// It is used to skip formatting the (expensive) debug messages, for example when logging packets.
+	Debug() bool
+
+	Errorf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+}
+
+// A Clock provides the current time and timers.
+type Clock interface {
+	Now() time.Time
+	// NewTimer creates a timer that is not set.
+	NewTimer() ClockTimer
+}
+
+// A ClockTimer is a timer created by a Clock.
+type ClockTimer interface {
+	// Chan returns the channel that the timer sends the current time on when it fires.
+	Chan() <-chan time.Time
+	// Reset sets the timer to fire at the deadline, discarding a value that was not received from the channel yet.
+	// A zero deadline stops the timer.
+	Reset(deadline time.Time)
 }
 
 // A Listener for incoming QUIC connections
 type Listener interface {
 	// Close the server, sending CONNECTION_CLOSE frames to each peer.
+	// No new sessions are accepted, and pending calls to Accept return ErrServerClosed.
 	Close() error
 	// Addr returns the local network addr that the server is listening on.
 	Addr() net.Addr
 	// Accept returns new sessions. It should be called in a loop.
-	Accept() (Session, error)
+	// It returns ErrServerClosed after the Listener was closed,
+	// and the context's error if the context is canceled.
+	Accept(context.Context) (Session, error)
+}
+
+// An EarlyListener listens for incoming QUIC connections,
+// and returns them before the handshake completes, see EarlySession.
+type EarlyListener interface {
+	// Close the server, sending CONNECTION_CLOSE frames to each peer.
+	// No new sessions are accepted, and pending calls to Accept return ErrServerClosed.
+	Close() error
+	// Addr returns the local network addr that the server is listening on.
+	Addr() net.Addr
+	// Accept returns new sessions as soon as the ClientHello was processed. It should be called in a loop.
+	// It returns ErrServerClosed after the Listener was closed,
+	// and the context's error if the context is canceled.
+	Accept(context.Context) (EarlySession, error)
 }
@@ -0,0 +1,19 @@
+package ackhandler
+
+type ecnState uint8
+
+const (
+	// packets are not marked
+	ecnStateDisabled ecnState = iota
+	// packets are marked, but the peer didn't acknowledge any marked packet yet
+	ecnStateTesting
+	// the ECN counts reported by the peer were valid
+	ecnStateCapable
+	// the ECN counts reported by the peer were invalid, packets are not marked any more
+	ecnStateFailed
+)
+
+// ecnCounts are the ECN counts reported in an ACK frame
+type ecnCounts struct {
+	ect0, ect1, ce uint64
+}
@@ -3,6 +3,7 @@ package ackhandler
 import (
 	"time"
 
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 )
@@ -12,8 +13,25 @@ type SentPacketHandler interface {
 	// SentPacket may modify the packet
 	SentPacket(packet *Packet)
 	SentPacketsAsRetransmission(packets []*Packet, retransmissionOf protocol.PacketNumber)
+	// RegisterAckCallback registers a callback that is called when the packet with the given packet number is acknowledged.
+	// The callback receives the time that passed between sending the packet and receiving the ACK.
+	// It is not called if the packet is declared lost.
+	RegisterAckCallback(protocol.PacketNumber, func(rtt time.Duration)) error
+	// RegisterLossCallback registers a callback that is called when the packet with the given packet number
+	// is declared lost, or discarded without being acknowledged.
+	// The frames of the packet might still be retransmitted in a new packet.
+	RegisterLossCallback(protocol.PacketNumber, func()) error
 	ReceivedAck(ackFrame *wire.AckFrame, withPacketNumber protocol.PacketNumber, encLevel protocol.EncryptionLevel, recvTime time.Time) error
 	SetHandshakeComplete()
+	// ReceivedBytes is called for every packet received from the peer.
+	// Until the peer's address is validated, the bytes received limit the bytes that can be sent.
+	ReceivedBytes(protocol.ByteCount)
+	// SetPeerAddressValidated is called once the peer's address is validated.
+	SetPeerAddressValidated()
+	// EnableECN is called if packets are sent with the ECT(0) codepoint.
+	// The ECN counts in the peer's ACKs are then used to validate that the path supports ECN.
+	// If validation fails, onFailed is called, and packets must not be marked any more.
+	EnableECN(onFailed func())
 
 	// The SendMode determines if and what kind of packets can be sent.
 	SendMode() SendMode
@@ -30,20 +48,60 @@ type SentPacketHandler interface {
 	// only to be called once the handshake is complete
 	GetLowestPacketNotConfirmedAcked() protocol.PacketNumber
 	DequeuePacketForRetransmission() *Packet
+	// DequeueProbePacket dequeues a packet that is retransmitted as a probe packet.
+	// It returns nil if the packet used for probing only contained DATAGRAM frames, which are never retransmitted,
+	// or if the client sends a probe packet before the handshake is complete without having any outstanding packets.
+	// A probe packet has to be sent anyway.
 	DequeueProbePacket() (*Packet, error)
+	// HasOutstandingData says if there are retransmittable packets that haven't been acknowledged yet,
+	// or packets that are queued for retransmission.
+	HasOutstandingData() bool
 
 	PeekPacketNumber() (protocol.PacketNumber, protocol.PacketNumberLen)
 	PopPacketNumber() protocol.PacketNumber
 
 	GetAlarmTimeout() time.Time
 	OnAlarm() error
+
+	// OnConnectionMigration is called when the connection migrated to a new path.
+	// It resets the RTT estimate and the congestion controller,
+	// and queues all packets that haven't been acknowledged yet for retransmission.
+	OnConnectionMigration() error
+
+	// GetStats returns statistics about the packets sent.
+	GetStats() Stats
+}
+
+// Stats contains statistics about sent packets.
+// The packet and byte counters only ever increase.
+type Stats struct {
+	PacketsSent           uint64
+	PacketsLost           uint64
+	SpuriousLosses        uint64
+	PTOs                  uint64
+	PersistentCongestions uint64
+	BytesRetransmitted    protocol.ByteCount
+	BytesInFlight         protocol.ByteCount
+	CongestionWindow      protocol.ByteCount
+	PacingRate            congestion.Bandwidth
+}
+
+// A StreamFrameHandler is notified about the fate of sent STREAM frames.
+type StreamFrameHandler interface {
+	// OnStreamFrameAcked is called when a STREAM frame is acknowledged.
+	OnStreamFrameAcked(*wire.StreamFrame)
+	// OnStreamFrameLost is called when a STREAM frame is declared lost.
+	// The data is not retransmitted by the sent packet handler, but by the stream.
+	OnStreamFrameLost(*wire.StreamFrame)
 }
 
 // ReceivedPacketHandler handles ACKs needed to send for incoming packets
 type ReceivedPacketHandler interface {
-	ReceivedPacket(pn protocol.PacketNumber, encLevel protocol.EncryptionLevel, rcvTime time.Time, shouldInstigateAck bool) error
+	ReceivedPacket(pn protocol.PacketNumber, ecn protocol.ECN, encLevel protocol.EncryptionLevel, rcvTime time.Time, shouldInstigateAck bool) error
 	IgnoreBelow(protocol.PacketNumber)
 
 	GetAlarmTimeout() time.Time
+	// GetAckFrame returns the ACK frame that should be sent, or nil.
+	// The frame is reused: it is only valid until the next call to GetAckFrame for the same encryption level.
 	GetAckFrame(protocol.EncryptionLevel) *wire.AckFrame
 }
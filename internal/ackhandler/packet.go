@@ -23,7 +23,12 @@ type Packet struct {
 	// * this packet is a retransmission, and we already received an ACK for the original packet
 	canBeRetransmitted      bool
 	includedInBytesInFlight bool
+	ecnMarked               bool // the packet was sent with the ECT(0) codepoint
 	retransmittedAs         []protocol.PacketNumber
 	isRetransmission        bool // we need a separate bool here because 0 is a valid packet number
 	retransmissionOf        protocol.PacketNumber
+	// called when this packet is acknowledged
+	ackCallbacks []func(rtt time.Duration)
+	// called when this packet is declared lost, or discarded without being acknowledged
+	lossCallbacks []func()
 }
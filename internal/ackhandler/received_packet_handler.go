@@ -11,11 +11,7 @@ import (
 )
 
 const (
-	// maximum delay that can be applied to an ACK for a retransmittable packet
-	ackSendDelay = 25 * time.Millisecond
-	// initial maximum number of retransmittable packets received before sending an ack.
-	initialRetransmittablePacketsBeforeAck = 2
-	// number of retransmittable that an ACK is sent for
+	// number of retransmittable that an ACK is sent for, when doing ack decimation
 	retransmittablePacketsBeforeAck = 10
 	// 1/5 RTT delay when doing ack decimation
 	ackDecimationDelay = 1.0 / 4
@@ -41,32 +37,37 @@ type receivedPacketHandler struct {
 
 var _ ReceivedPacketHandler = &receivedPacketHandler{}
 
-// NewReceivedPacketHandler creates a new receivedPacketHandler
+// NewReceivedPacketHandler creates a new receivedPacketHandler.
+// An ACK is sent after receiving ackFrequency retransmittable packets, or after maxAckDelay, whichever comes first.
 func NewReceivedPacketHandler(
+	clock congestion.Clock,
 	rttStats *congestion.RTTStats,
+	maxAckDelay time.Duration,
+	ackFrequency int,
 	logger utils.Logger,
 	version protocol.VersionNumber,
 ) ReceivedPacketHandler {
 	return &receivedPacketHandler{
-		initialPackets:   newReceivedPacketTracker(rttStats, logger, version),
-		handshakePackets: newReceivedPacketTracker(rttStats, logger, version),
-		oneRTTPackets:    newReceivedPacketTracker(rttStats, logger, version),
+		initialPackets:   newReceivedPacketTracker(clock, rttStats, maxAckDelay, ackFrequency, logger, version),
+		handshakePackets: newReceivedPacketTracker(clock, rttStats, maxAckDelay, ackFrequency, logger, version),
+		oneRTTPackets:    newReceivedPacketTracker(clock, rttStats, maxAckDelay, ackFrequency, logger, version),
 	}
 }
 
 func (h *receivedPacketHandler) ReceivedPacket(
 	pn protocol.PacketNumber,
+	ecn protocol.ECN,
 	encLevel protocol.EncryptionLevel,
 	rcvTime time.Time,
 	shouldInstigateAck bool,
 ) error {
 	switch encLevel {
 	case protocol.EncryptionInitial:
-		return h.initialPackets.ReceivedPacket(pn, rcvTime, shouldInstigateAck)
+		return h.initialPackets.ReceivedPacket(pn, ecn, rcvTime, shouldInstigateAck)
 	case protocol.EncryptionHandshake:
-		return h.handshakePackets.ReceivedPacket(pn, rcvTime, shouldInstigateAck)
+		return h.handshakePackets.ReceivedPacket(pn, ecn, rcvTime, shouldInstigateAck)
 	case protocol.Encryption1RTT:
-		return h.oneRTTPackets.ReceivedPacket(pn, rcvTime, shouldInstigateAck)
+		return h.oneRTTPackets.ReceivedPacket(pn, ecn, rcvTime, shouldInstigateAck)
 	default:
 		return fmt.Errorf("received packet with unknown encryption level: %s", encLevel)
 	}
@@ -17,7 +17,10 @@ var _ = Describe("Received Packet Handler", func() {
 
 	BeforeEach(func() {
 		handler = NewReceivedPacketHandler(
+			congestion.DefaultClock{},
 			&congestion.RTTStats{},
+			protocol.DefaultMaxAckDelay,
+			protocol.DefaultAckFrequency,
 			utils.DefaultLogger,
 			protocol.VersionWhatever,
 		)
@@ -25,12 +28,12 @@ var _ = Describe("Received Packet Handler", func() {
 
 	It("generates ACKs for different packet number spaces", func() {
 		now := time.Now()
-		Expect(handler.ReceivedPacket(2, protocol.EncryptionInitial, now, true)).To(Succeed())
-		Expect(handler.ReceivedPacket(1, protocol.EncryptionHandshake, now, true)).To(Succeed())
-		Expect(handler.ReceivedPacket(5, protocol.Encryption1RTT, now, true)).To(Succeed())
-		Expect(handler.ReceivedPacket(3, protocol.EncryptionInitial, now, true)).To(Succeed())
-		Expect(handler.ReceivedPacket(2, protocol.EncryptionHandshake, now, true)).To(Succeed())
-		Expect(handler.ReceivedPacket(4, protocol.Encryption1RTT, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(2, protocol.ECNNon, protocol.EncryptionInitial, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(1, protocol.ECNNon, protocol.EncryptionHandshake, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(5, protocol.ECNNon, protocol.Encryption1RTT, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(3, protocol.ECNNon, protocol.EncryptionInitial, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(2, protocol.ECNNon, protocol.EncryptionHandshake, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(4, protocol.ECNNon, protocol.Encryption1RTT, now, true)).To(Succeed())
 		initialAck := handler.GetAckFrame(protocol.EncryptionInitial)
 		Expect(initialAck).ToNot(BeNil())
 		Expect(initialAck.AckRanges).To(HaveLen(1))
@@ -44,4 +47,20 @@ var _ = Describe("Received Packet Handler", func() {
 		Expect(oneRTTAck.AckRanges).To(HaveLen(1))
 		Expect(oneRTTAck.AckRanges[0]).To(Equal(wire.AckRange{Smallest: 4, Largest: 5}))
 	})
+
+	It("counts ECN marks per packet number space", func() {
+		now := time.Now()
+		Expect(handler.ReceivedPacket(1, protocol.ECT0, protocol.EncryptionInitial, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(1, protocol.ECNCE, protocol.EncryptionHandshake, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacket(1, protocol.ECT1, protocol.Encryption1RTT, now, true)).To(Succeed())
+		initialAck := handler.GetAckFrame(protocol.EncryptionInitial)
+		Expect(initialAck).ToNot(BeNil())
+		Expect([]uint64{initialAck.ECT0, initialAck.ECT1, initialAck.ECNCE}).To(Equal([]uint64{1, 0, 0}))
+		handshakeAck := handler.GetAckFrame(protocol.EncryptionHandshake)
+		Expect(handshakeAck).ToNot(BeNil())
+		Expect([]uint64{handshakeAck.ECT0, handshakeAck.ECT1, handshakeAck.ECNCE}).To(Equal([]uint64{0, 0, 1}))
+		oneRTTAck := handler.GetAckFrame(protocol.Encryption1RTT)
+		Expect(oneRTTAck).ToNot(BeNil())
+		Expect([]uint64{oneRTTAck.ECT0, oneRTTAck.ECT1, oneRTTAck.ECNCE}).To(Equal([]uint64{0, 1, 0}))
+	})
 })
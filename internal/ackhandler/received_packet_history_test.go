@@ -1,6 +1,9 @@
 package ackhandler
 
 import (
+	"bytes"
+	"math/rand"
+
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
@@ -200,13 +203,13 @@ var _ = Describe("receivedPacketHistory", func() {
 
 	Context("ACK range export", func() {
 		It("returns nil if there are no ranges", func() {
-			Expect(hist.GetAckRanges()).To(BeNil())
+			Expect(hist.AppendAckRanges(nil)).To(BeNil())
 		})
 
 		It("gets a single ACK range", func() {
 			hist.ReceivedPacket(4)
 			hist.ReceivedPacket(5)
-			ackRanges := hist.GetAckRanges()
+			ackRanges := hist.AppendAckRanges(nil)
 			Expect(ackRanges).To(HaveLen(1))
 			Expect(ackRanges[0]).To(Equal(wire.AckRange{Smallest: 4, Largest: 5}))
 		})
@@ -219,12 +222,73 @@ var _ = Describe("receivedPacketHistory", func() {
 			hist.ReceivedPacket(11)
 			hist.ReceivedPacket(10)
 			hist.ReceivedPacket(2)
-			ackRanges := hist.GetAckRanges()
+			ackRanges := hist.AppendAckRanges(nil)
 			Expect(ackRanges).To(HaveLen(3))
 			Expect(ackRanges[0]).To(Equal(wire.AckRange{Smallest: 10, Largest: 11}))
 			Expect(ackRanges[1]).To(Equal(wire.AckRange{Smallest: 4, Largest: 6}))
 			Expect(ackRanges[2]).To(Equal(wire.AckRange{Smallest: 1, Largest: 2}))
 		})
+
+		It("appends to the slice", func() {
+			hist.ReceivedPacket(4)
+			hist.ReceivedPacket(6)
+			ackRanges := make([]wire.AckRange, 0, 10)
+			ackRanges = hist.AppendAckRanges(ackRanges)
+			Expect(ackRanges).To(Equal([]wire.AckRange{{Smallest: 6, Largest: 6}, {Smallest: 4, Largest: 4}}))
+			Expect(cap(ackRanges)).To(Equal(10))
+		})
+
+		It("drops the lowest ranges, if there are more than MaxNumAckRanges ranges", func() {
+			for i := 0; i < 2*protocol.MaxNumAckRanges; i++ {
+				Expect(hist.ReceivedPacket(protocol.PacketNumber(2 * i))).To(Succeed())
+			}
+			ackRanges := hist.AppendAckRanges(nil)
+			Expect(ackRanges).To(HaveLen(protocol.MaxNumAckRanges))
+			Expect(ackRanges[0]).To(Equal(wire.AckRange{Smallest: 4*protocol.MaxNumAckRanges - 2, Largest: 4*protocol.MaxNumAckRanges - 2}))
+			Expect(ackRanges[protocol.MaxNumAckRanges-1]).To(Equal(wire.AckRange{Smallest: 2 * protocol.MaxNumAckRanges, Largest: 2 * protocol.MaxNumAckRanges}))
+		})
+
+		It("generates correct ACK frames for random arrival orders", func() {
+			r := rand.New(rand.NewSource(GinkgoRandomSeed()))
+			for run := 0; run < 200; run++ {
+				hist = newReceivedPacketHistory()
+				received := make(map[protocol.PacketNumber]bool)
+				var largest protocol.PacketNumber
+				maxPacketNumber := 1 + r.Intn(1000)
+				numPackets := 1 + r.Intn(maxPacketNumber)
+				for i := 0; i < numPackets; i++ {
+					p := protocol.PacketNumber(r.Intn(maxPacketNumber))
+					if err := hist.ReceivedPacket(p); err != nil {
+						Expect(err).To(MatchError(errTooManyOutstandingReceivedAckRanges))
+						continue
+					}
+					received[p] = true
+					if p > largest {
+						largest = p
+					}
+				}
+
+				b := &bytes.Buffer{}
+				Expect((&wire.AckFrame{AckRanges: hist.AppendAckRanges(nil)}).Write(b, protocol.VersionWhatever)).To(Succeed())
+				Expect(protocol.ByteCount(b.Len())).To(BeNumerically("<=", protocol.MaxAckFrameSize))
+				frame, err := wire.ParseNextFrame(bytes.NewReader(b.Bytes()), protocol.VersionWhatever)
+				Expect(err).ToNot(HaveOccurred())
+				ack := frame.(*wire.AckFrame)
+				Expect(len(ack.AckRanges)).To(BeNumerically("<=", protocol.MaxNumAckRanges))
+				// The ranges must be sorted, and there must be a gap between every two ranges.
+				for i, ackRange := range ack.AckRanges {
+					Expect(ackRange.Smallest).To(BeNumerically("<=", ackRange.Largest))
+					if i > 0 {
+						Expect(ackRange.Largest + 1).To(BeNumerically("<", ack.AckRanges[i-1].Smallest))
+					}
+				}
+				// All packets between the lowest and the largest acknowledged packet must be reported correctly.
+				Expect(ack.LargestAcked()).To(Equal(largest))
+				for p := ack.LowestAcked(); p <= ack.LargestAcked(); p++ {
+					Expect(ack.AcksPacket(p)).To(Equal(received[p]))
+				}
+			}
+		})
 	})
 
 	Context("Getting the highest ACK range", func() {
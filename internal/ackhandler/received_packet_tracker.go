@@ -16,7 +16,9 @@ type receivedPacketTracker struct {
 
 	packetHistory *receivedPacketHistory
 
-	ackSendDelay time.Duration
+	maxAckDelay  time.Duration
+	ackFrequency int
+	clock        congestion.Clock
 	rttStats     *congestion.RTTStats
 
 	packetsReceivedSinceLastAck                int
@@ -24,6 +26,10 @@ type receivedPacketTracker struct {
 	ackQueued                                  bool
 	ackAlarm                                   time.Time
 	lastAck                                    *wire.AckFrame
+	// ackFrame is reused for every ACK frame returned by GetAckFrame
+	ackFrame wire.AckFrame
+
+	ect0, ect1, ecnce uint64
 
 	logger utils.Logger
 
@@ -31,20 +37,25 @@ type receivedPacketTracker struct {
 }
 
 func newReceivedPacketTracker(
+	clock congestion.Clock,
 	rttStats *congestion.RTTStats,
+	maxAckDelay time.Duration,
+	ackFrequency int,
 	logger utils.Logger,
 	version protocol.VersionNumber,
 ) *receivedPacketTracker {
 	return &receivedPacketTracker{
 		packetHistory: newReceivedPacketHistory(),
-		ackSendDelay:  ackSendDelay,
+		maxAckDelay:   maxAckDelay,
+		ackFrequency:  ackFrequency,
+		clock:         clock,
 		rttStats:      rttStats,
 		logger:        logger,
 		version:       version,
 	}
 }
 
-func (h *receivedPacketTracker) ReceivedPacket(packetNumber protocol.PacketNumber, rcvTime time.Time, shouldInstigateAck bool) error {
+func (h *receivedPacketTracker) ReceivedPacket(packetNumber protocol.PacketNumber, ecn protocol.ECN, rcvTime time.Time, shouldInstigateAck bool) error {
 	if packetNumber < h.ignoreBelow {
 		return nil
 	}
@@ -58,7 +69,21 @@ func (h *receivedPacketTracker) ReceivedPacket(packetNumber protocol.PacketNumbe
 	if err := h.packetHistory.ReceivedPacket(packetNumber); err != nil {
 		return err
 	}
+	switch ecn {
+	case protocol.ECT0:
+		h.ect0++
+	case protocol.ECT1:
+		h.ect1++
+	case protocol.ECNCE:
+		h.ecnce++
+	}
 	h.maybeQueueAck(packetNumber, rcvTime, shouldInstigateAck, isMissing)
+	// Report congestion to the peer as soon as possible.
+	if ecn == protocol.ECNCE {
+		h.logger.Debugf("\tQueueing ACK because packet %#x was marked CE.", packetNumber)
+		h.ackQueued = true
+		h.ackAlarm = time.Time{}
+	}
 	return nil
 }
 
@@ -118,32 +143,33 @@ func (h *receivedPacketTracker) maybeQueueAck(packetNumber protocol.PacketNumber
 		h.retransmittablePacketsReceivedSinceLastAck++
 
 		if packetNumber > minReceivedBeforeAckDecimation {
-			// ack up to 10 packets at once
-			if h.retransmittablePacketsReceivedSinceLastAck >= retransmittablePacketsBeforeAck {
+			// ack up to 10 packets at once, or more if configured
+			threshold := utils.Max(h.ackFrequency, retransmittablePacketsBeforeAck)
+			if h.retransmittablePacketsReceivedSinceLastAck >= threshold {
 				h.ackQueued = true
 				if h.logger.Debug() {
-					h.logger.Debugf("\tQueueing ACK because packet %d packets were received after the last ACK (using threshold: %d).", h.retransmittablePacketsReceivedSinceLastAck, retransmittablePacketsBeforeAck)
+					h.logger.Debugf("\tQueueing ACK because packet %d packets were received after the last ACK (using threshold: %d).", h.retransmittablePacketsReceivedSinceLastAck, threshold)
 				}
 			} else if h.ackAlarm.IsZero() {
 				// wait for the minimum of the ack decimation delay or the delayed ack time before sending an ack
-				ackDelay := utils.MinDuration(ackSendDelay, time.Duration(float64(h.rttStats.MinRTT())*float64(ackDecimationDelay)))
+				ackDelay := utils.MinDuration(h.maxAckDelay, time.Duration(float64(h.rttStats.MinRTT())*float64(ackDecimationDelay)))
 				h.ackAlarm = rcvTime.Add(ackDelay)
 				if h.logger.Debug() {
 					h.logger.Debugf("\tSetting ACK timer to min(1/4 min-RTT, max ack delay): %s (%s from now)", ackDelay, time.Until(h.ackAlarm))
 				}
 			}
 		} else {
-			// send an ACK every 2 retransmittable packets
-			if h.retransmittablePacketsReceivedSinceLastAck >= initialRetransmittablePacketsBeforeAck {
+			// send an ACK every ackFrequency retransmittable packets
+			if h.retransmittablePacketsReceivedSinceLastAck >= h.ackFrequency {
 				if h.logger.Debug() {
-					h.logger.Debugf("\tQueueing ACK because packet %d packets were received after the last ACK (using initial threshold: %d).", h.retransmittablePacketsReceivedSinceLastAck, initialRetransmittablePacketsBeforeAck)
+					h.logger.Debugf("\tQueueing ACK because packet %d packets were received after the last ACK (using initial threshold: %d).", h.retransmittablePacketsReceivedSinceLastAck, h.ackFrequency)
 				}
 				h.ackQueued = true
 			} else if h.ackAlarm.IsZero() {
 				if h.logger.Debug() {
-					h.logger.Debugf("\tSetting ACK timer to max ack delay: %s", ackSendDelay)
+					h.logger.Debugf("\tSetting ACK timer to max ack delay: %s", h.maxAckDelay)
 				}
-				h.ackAlarm = rcvTime.Add(ackSendDelay)
+				h.ackAlarm = rcvTime.Add(h.maxAckDelay)
 			}
 		}
 		// If there are new missing packets to report, set a short timer to send an ACK.
@@ -167,7 +193,7 @@ func (h *receivedPacketTracker) maybeQueueAck(packetNumber protocol.PacketNumber
 }
 
 func (h *receivedPacketTracker) GetAckFrame() *wire.AckFrame {
-	now := time.Now()
+	now := h.clock.Now()
 	if !h.ackQueued && (h.ackAlarm.IsZero() || h.ackAlarm.After(now)) {
 		return nil
 	}
@@ -175,10 +201,12 @@ func (h *receivedPacketTracker) GetAckFrame() *wire.AckFrame {
 		h.logger.Debugf("Sending ACK because the ACK timer expired.")
 	}
 
-	ack := &wire.AckFrame{
-		AckRanges: h.packetHistory.GetAckRanges(),
-		DelayTime: now.Sub(h.largestObservedReceivedTime),
-	}
+	ack := &h.ackFrame
+	ack.AckRanges = h.packetHistory.AppendAckRanges(ack.AckRanges[:0])
+	ack.DelayTime = now.Sub(h.largestObservedReceivedTime)
+	ack.ECT0 = h.ect0
+	ack.ECT1 = h.ect1
+	ack.ECNCE = h.ecnce
 
 	h.lastAck = ack
 	h.ackAlarm = time.Time{}
@@ -1,6 +1,7 @@
 package ackhandler
 
 import (
+	"testing"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/internal/congestion"
@@ -20,21 +21,21 @@ var _ = Describe("Received Packet Tracker", func() {
 
 	BeforeEach(func() {
 		rttStats = &congestion.RTTStats{}
-		tracker = newReceivedPacketTracker(rttStats, utils.DefaultLogger, protocol.VersionWhatever)
+		tracker = newReceivedPacketTracker(congestion.DefaultClock{}, rttStats, protocol.DefaultMaxAckDelay, protocol.DefaultAckFrequency, utils.DefaultLogger, protocol.VersionWhatever)
 	})
 
 	Context("accepting packets", func() {
 		It("handles a packet that arrives late", func() {
-			err := tracker.ReceivedPacket(protocol.PacketNumber(1), time.Time{}, true)
+			err := tracker.ReceivedPacket(protocol.PacketNumber(1), protocol.ECNNon, time.Time{}, true)
 			Expect(err).ToNot(HaveOccurred())
-			err = tracker.ReceivedPacket(protocol.PacketNumber(3), time.Time{}, true)
+			err = tracker.ReceivedPacket(protocol.PacketNumber(3), protocol.ECNNon, time.Time{}, true)
 			Expect(err).ToNot(HaveOccurred())
-			err = tracker.ReceivedPacket(protocol.PacketNumber(2), time.Time{}, true)
+			err = tracker.ReceivedPacket(protocol.PacketNumber(2), protocol.ECNNon, time.Time{}, true)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("saves the time when each packet arrived", func() {
-			err := tracker.ReceivedPacket(protocol.PacketNumber(3), time.Now(), true)
+			err := tracker.ReceivedPacket(protocol.PacketNumber(3), protocol.ECNNon, time.Now(), true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(tracker.largestObservedReceivedTime).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
 		})
@@ -43,7 +44,7 @@ var _ = Describe("Received Packet Tracker", func() {
 			now := time.Now()
 			tracker.largestObserved = 3
 			tracker.largestObservedReceivedTime = now.Add(-1 * time.Second)
-			err := tracker.ReceivedPacket(5, now, true)
+			err := tracker.ReceivedPacket(5, protocol.ECNNon, now, true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(tracker.largestObserved).To(Equal(protocol.PacketNumber(5)))
 			Expect(tracker.largestObservedReceivedTime).To(Equal(now))
@@ -54,7 +55,7 @@ var _ = Describe("Received Packet Tracker", func() {
 			timestamp := now.Add(-1 * time.Second)
 			tracker.largestObserved = 5
 			tracker.largestObservedReceivedTime = timestamp
-			err := tracker.ReceivedPacket(4, now, true)
+			err := tracker.ReceivedPacket(4, protocol.ECNNon, now, true)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(tracker.largestObserved).To(Equal(protocol.PacketNumber(5)))
 			Expect(tracker.largestObservedReceivedTime).To(Equal(timestamp))
@@ -63,7 +64,7 @@ var _ = Describe("Received Packet Tracker", func() {
 		It("passes on errors from receivedPacketHistory", func() {
 			var err error
 			for i := protocol.PacketNumber(0); i < 5*protocol.MaxTrackedReceivedAckRanges; i++ {
-				err = tracker.ReceivedPacket(2*i+1, time.Time{}, true)
+				err = tracker.ReceivedPacket(2*i+1, protocol.ECNNon, time.Time{}, true)
 				// this will eventually return an error
 				// details about when exactly the receivedPacketHistory errors are tested there
 				if err != nil {
@@ -78,7 +79,7 @@ var _ = Describe("Received Packet Tracker", func() {
 		Context("queueing ACKs", func() {
 			receiveAndAck10Packets := func() {
 				for i := 1; i <= 10; i++ {
-					err := tracker.ReceivedPacket(protocol.PacketNumber(i), time.Time{}, true)
+					err := tracker.ReceivedPacket(protocol.PacketNumber(i), protocol.ECNNon, time.Time{}, true)
 					Expect(err).ToNot(HaveOccurred())
 				}
 				Expect(tracker.GetAckFrame()).ToNot(BeNil())
@@ -87,7 +88,7 @@ var _ = Describe("Received Packet Tracker", func() {
 
 			receiveAndAckPacketsUntilAckDecimation := func() {
 				for i := 1; i <= minReceivedBeforeAckDecimation; i++ {
-					err := tracker.ReceivedPacket(protocol.PacketNumber(i), time.Time{}, true)
+					err := tracker.ReceivedPacket(protocol.PacketNumber(i), protocol.ECNNon, time.Time{}, true)
 					Expect(err).ToNot(HaveOccurred())
 				}
 				Expect(tracker.GetAckFrame()).ToNot(BeNil())
@@ -95,28 +96,37 @@ var _ = Describe("Received Packet Tracker", func() {
 			}
 
 			It("always queues an ACK for the first packet", func() {
-				Expect(tracker.ReceivedPacket(1, time.Now(), false)).To(Succeed())
+				Expect(tracker.ReceivedPacket(1, protocol.ECNNon, time.Now(), false)).To(Succeed())
 				Expect(tracker.ackQueued).To(BeTrue())
 				Expect(tracker.GetAlarmTimeout()).To(BeZero())
 				Expect(tracker.GetAckFrame().DelayTime).To(BeNumerically("~", 0, time.Second))
 			})
 
 			It("works with packet number 0", func() {
-				Expect(tracker.ReceivedPacket(0, time.Now(), false)).To(Succeed())
+				Expect(tracker.ReceivedPacket(0, protocol.ECNNon, time.Now(), false)).To(Succeed())
 				Expect(tracker.ackQueued).To(BeTrue())
 				Expect(tracker.GetAlarmTimeout()).To(BeZero())
 				Expect(tracker.GetAckFrame().DelayTime).To(BeNumerically("~", 0, time.Second))
 			})
 
+			It("queues an ACK when receiving a packet marked CE", func() {
+				receiveAndAck10Packets()
+				Expect(tracker.ReceivedPacket(11, protocol.ECNNon, time.Now(), true)).To(Succeed())
+				Expect(tracker.ackQueued).To(BeFalse())
+				Expect(tracker.ReceivedPacket(13, protocol.ECNCE, time.Now(), false)).To(Succeed())
+				Expect(tracker.ackQueued).To(BeTrue())
+				Expect(tracker.GetAlarmTimeout()).To(BeZero())
+			})
+
 			It("queues an ACK for every second retransmittable packet at the beginning", func() {
 				receiveAndAck10Packets()
 				p := protocol.PacketNumber(11)
 				for i := 0; i <= 20; i++ {
-					err := tracker.ReceivedPacket(p, time.Time{}, true)
+					err := tracker.ReceivedPacket(p, protocol.ECNNon, time.Time{}, true)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(tracker.ackQueued).To(BeFalse())
 					p++
-					err = tracker.ReceivedPacket(p, time.Time{}, true)
+					err = tracker.ReceivedPacket(p, protocol.ECNNon, time.Time{}, true)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(tracker.ackQueued).To(BeTrue())
 					p++
@@ -129,13 +139,13 @@ var _ = Describe("Received Packet Tracker", func() {
 				receiveAndAck10Packets()
 				p := protocol.PacketNumber(10000)
 				for i := 0; i < 9; i++ {
-					err := tracker.ReceivedPacket(p, time.Now(), true)
+					err := tracker.ReceivedPacket(p, protocol.ECNNon, time.Now(), true)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(tracker.ackQueued).To(BeFalse())
 					p++
 				}
 				Expect(tracker.GetAlarmTimeout()).NotTo(BeZero())
-				err := tracker.ReceivedPacket(p, time.Now(), true)
+				err := tracker.ReceivedPacket(p, protocol.ECNNon, time.Now(), true)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(tracker.ackQueued).To(BeTrue())
 				Expect(tracker.GetAlarmTimeout()).To(BeZero())
@@ -143,28 +153,88 @@ var _ = Describe("Received Packet Tracker", func() {
 
 			It("only sets the timer when receiving a retransmittable packets", func() {
 				receiveAndAck10Packets()
-				err := tracker.ReceivedPacket(11, time.Now(), false)
+				err := tracker.ReceivedPacket(11, protocol.ECNNon, time.Now(), false)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(tracker.ackQueued).To(BeFalse())
 				Expect(tracker.GetAlarmTimeout()).To(BeZero())
 				rcvTime := time.Now().Add(10 * time.Millisecond)
-				err = tracker.ReceivedPacket(12, rcvTime, true)
+				err = tracker.ReceivedPacket(12, protocol.ECNNon, rcvTime, true)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(tracker.ackQueued).To(BeFalse())
-				Expect(tracker.GetAlarmTimeout()).To(Equal(rcvTime.Add(ackSendDelay)))
+				Expect(tracker.GetAlarmTimeout()).To(Equal(rcvTime.Add(protocol.DefaultMaxAckDelay)))
+			})
+
+			Context("with a configured ACK frequency and max ACK delay", func() {
+				BeforeEach(func() {
+					tracker = newReceivedPacketTracker(congestion.DefaultClock{}, rttStats, 10*time.Millisecond, 5, utils.DefaultLogger, protocol.VersionWhatever)
+				})
+
+				It("queues an ACK for every 5th retransmittable packet", func() {
+					receiveAndAck10Packets()
+					p := protocol.PacketNumber(11)
+					for i := 0; i < 5; i++ {
+						for j := 0; j < 4; j++ {
+							Expect(tracker.ReceivedPacket(p, protocol.ECNNon, time.Now(), true)).To(Succeed())
+							Expect(tracker.ackQueued).To(BeFalse())
+							p++
+						}
+						Expect(tracker.ReceivedPacket(p, protocol.ECNNon, time.Now(), true)).To(Succeed())
+						Expect(tracker.ackQueued).To(BeTrue())
+						p++
+						Expect(tracker.GetAckFrame()).ToNot(BeNil())
+					}
+				})
+
+				It("sets the timer to the max ACK delay", func() {
+					receiveAndAck10Packets()
+					rcvTime := time.Now()
+					Expect(tracker.ReceivedPacket(11, protocol.ECNNon, rcvTime, true)).To(Succeed())
+					Expect(tracker.ackQueued).To(BeFalse())
+					Expect(tracker.GetAlarmTimeout()).To(Equal(rcvTime.Add(10 * time.Millisecond)))
+				})
+
+				It("uses the ACK frequency when doing ack decimation, if it is larger than 10", func() {
+					tracker.ackFrequency = 20
+					receiveAndAckPacketsUntilAckDecimation()
+					p := protocol.PacketNumber(10000)
+					for i := 0; i < 19; i++ {
+						Expect(tracker.ReceivedPacket(p, protocol.ECNNon, time.Now(), true)).To(Succeed())
+						Expect(tracker.ackQueued).To(BeFalse())
+						p++
+					}
+					Expect(tracker.ReceivedPacket(p, protocol.ECNNon, time.Now(), true)).To(Succeed())
+					Expect(tracker.ackQueued).To(BeTrue())
+				})
+
+				It("still queues an ACK immediately for packets marked CE", func() {
+					receiveAndAck10Packets()
+					Expect(tracker.ReceivedPacket(11, protocol.ECNCE, time.Now(), true)).To(Succeed())
+					Expect(tracker.ackQueued).To(BeTrue())
+				})
+
+				It("still queues an ACK immediately if a packet was reported missing before", func() {
+					receiveAndAck10Packets()
+					Expect(tracker.ReceivedPacket(11, protocol.ECNNon, time.Now(), true)).To(Succeed())
+					Expect(tracker.ReceivedPacket(13, protocol.ECNNon, time.Now(), true)).To(Succeed())
+					tracker.ackQueued = true
+					ack := tracker.GetAckFrame() // ACK: 1-11 and 13, missing: 12
+					Expect(ack.HasMissingRanges()).To(BeTrue())
+					Expect(tracker.ReceivedPacket(12, protocol.ECNNon, time.Now(), false)).To(Succeed())
+					Expect(tracker.ackQueued).To(BeTrue())
+				})
 			})
 
 			It("queues an ACK if it was reported missing before", func() {
 				receiveAndAck10Packets()
-				err := tracker.ReceivedPacket(11, time.Time{}, true)
+				err := tracker.ReceivedPacket(11, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(13, time.Time{}, true)
+				err = tracker.ReceivedPacket(13, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame() // ACK: 1-11 and 13, missing: 12
 				Expect(ack).ToNot(BeNil())
 				Expect(ack.HasMissingRanges()).To(BeTrue())
 				Expect(tracker.ackQueued).To(BeFalse())
-				err = tracker.ReceivedPacket(12, time.Time{}, false)
+				err = tracker.ReceivedPacket(12, protocol.ECNNon, time.Time{}, false)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(tracker.ackQueued).To(BeTrue())
 			})
@@ -172,15 +242,15 @@ var _ = Describe("Received Packet Tracker", func() {
 			It("doesn't queue an ACK if it was reported missing before, but is below the threshold", func() {
 				receiveAndAck10Packets()
 				// 11 is missing
-				err := tracker.ReceivedPacket(12, time.Time{}, true)
+				err := tracker.ReceivedPacket(12, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(13, time.Time{}, true)
+				err = tracker.ReceivedPacket(13, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame() // ACK: 1-10, 12-13
 				Expect(ack).ToNot(BeNil())
 				// now receive 11
 				tracker.IgnoreBelow(12)
-				err = tracker.ReceivedPacket(11, time.Time{}, false)
+				err = tracker.ReceivedPacket(11, protocol.ECNNon, time.Time{}, false)
 				Expect(err).ToNot(HaveOccurred())
 				ack = tracker.GetAckFrame()
 				Expect(ack).To(BeNil())
@@ -189,11 +259,11 @@ var _ = Describe("Received Packet Tracker", func() {
 			It("doesn't queue an ACK if the packet closes a gap that was not yet reported", func() {
 				receiveAndAckPacketsUntilAckDecimation()
 				p := protocol.PacketNumber(minReceivedBeforeAckDecimation + 1)
-				err := tracker.ReceivedPacket(p+1, time.Now(), true) // p is missing now
+				err := tracker.ReceivedPacket(p+1, protocol.ECNNon, time.Now(), true) // p is missing now
 				Expect(err).ToNot(HaveOccurred())
 				Expect(tracker.ackQueued).To(BeFalse())
 				Expect(tracker.GetAlarmTimeout()).ToNot(BeZero())
-				err = tracker.ReceivedPacket(p, time.Now(), true) // p is not missing any more
+				err = tracker.ReceivedPacket(p, protocol.ECNNon, time.Now(), true) // p is not missing any more
 				Expect(err).ToNot(HaveOccurred())
 				Expect(tracker.ackQueued).To(BeFalse())
 			})
@@ -205,10 +275,10 @@ var _ = Describe("Received Packet Tracker", func() {
 				receiveAndAckPacketsUntilAckDecimation()
 				p := protocol.PacketNumber(minReceivedBeforeAckDecimation + 1)
 				for i := p; i < p+6; i++ {
-					err := tracker.ReceivedPacket(i, now, true)
+					err := tracker.ReceivedPacket(i, protocol.ECNNon, now, true)
 					Expect(err).ToNot(HaveOccurred())
 				}
-				err := tracker.ReceivedPacket(p+10, now, true) // we now know that packets p+7, p+8 and p+9
+				err := tracker.ReceivedPacket(p+10, protocol.ECNNon, now, true) // we now know that packets p+7, p+8 and p+9
 				Expect(err).ToNot(HaveOccurred())
 				Expect(rttStats.MinRTT()).To(Equal(rtt))
 				Expect(tracker.ackAlarm.Sub(now)).To(Equal(rtt / 8))
@@ -224,9 +294,9 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("generates a simple ACK frame", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(2, time.Time{}, true)
+				err = tracker.ReceivedPacket(2, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
@@ -235,8 +305,21 @@ var _ = Describe("Received Packet Tracker", func() {
 				Expect(ack.HasMissingRanges()).To(BeFalse())
 			})
 
+			It("includes the ECN counts", func() {
+				Expect(tracker.ReceivedPacket(1, protocol.ECT0, time.Time{}, true)).To(Succeed())
+				Expect(tracker.ReceivedPacket(2, protocol.ECT0, time.Time{}, true)).To(Succeed())
+				Expect(tracker.ReceivedPacket(3, protocol.ECT1, time.Time{}, true)).To(Succeed())
+				Expect(tracker.ReceivedPacket(4, protocol.ECNCE, time.Time{}, true)).To(Succeed())
+				Expect(tracker.ReceivedPacket(5, protocol.ECNNon, time.Time{}, true)).To(Succeed())
+				ack := tracker.GetAckFrame()
+				Expect(ack).ToNot(BeNil())
+				Expect(ack.ECT0).To(BeEquivalentTo(2))
+				Expect(ack.ECT1).To(BeEquivalentTo(1))
+				Expect(ack.ECNCE).To(BeEquivalentTo(1))
+			})
+
 			It("generates an ACK for packet number 0", func() {
-				err := tracker.ReceivedPacket(0, time.Time{}, true)
+				err := tracker.ReceivedPacket(0, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
@@ -246,9 +329,9 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("sets the delay time", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(2, time.Now().Add(-1337*time.Millisecond), true)
+				err = tracker.ReceivedPacket(2, protocol.ECNNon, time.Now().Add(-1337*time.Millisecond), true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
@@ -256,12 +339,12 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("saves the last sent ACK", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
 				Expect(tracker.lastAck).To(Equal(ack))
-				err = tracker.ReceivedPacket(2, time.Time{}, true)
+				err = tracker.ReceivedPacket(2, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				tracker.ackQueued = true
 				ack = tracker.GetAckFrame()
@@ -269,10 +352,30 @@ var _ = Describe("Received Packet Tracker", func() {
 				Expect(tracker.lastAck).To(Equal(ack))
 			})
 
+			It("reuses the ACK frame", func() {
+				for i := protocol.PacketNumber(1); i <= 10; i += 2 {
+					Expect(tracker.ReceivedPacket(i, protocol.ECNNon, time.Time{}, true)).To(Succeed())
+				}
+				tracker.ackQueued = true
+				ack := tracker.GetAckFrame()
+				Expect(ack).ToNot(BeNil())
+				Expect(ack.AckRanges).To(HaveLen(5))
+				Expect(tracker.ReceivedPacket(12, protocol.ECNNon, time.Time{}, true)).To(Succeed())
+				tracker.ackQueued = true
+				ack2 := tracker.GetAckFrame()
+				Expect(ack2).To(BeIdenticalTo(ack))
+				Expect(ack2.LargestAcked()).To(Equal(protocol.PacketNumber(12)))
+				Expect(ack2.AckRanges).To(HaveLen(6))
+				Expect(testing.AllocsPerRun(100, func() {
+					tracker.ackQueued = true
+					tracker.GetAckFrame()
+				})).To(BeZero())
+			})
+
 			It("generates an ACK frame with missing packets", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(4, time.Time{}, true)
+				err = tracker.ReceivedPacket(4, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
@@ -285,11 +388,11 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("generates an ACK for packet number 0 and other packets", func() {
-				err := tracker.ReceivedPacket(0, time.Time{}, true)
+				err := tracker.ReceivedPacket(0, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(1, time.Time{}, true)
+				err = tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(3, time.Time{}, true)
+				err = tracker.ReceivedPacket(3, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
@@ -303,15 +406,15 @@ var _ = Describe("Received Packet Tracker", func() {
 
 			It("accepts packets below the lower limit", func() {
 				tracker.IgnoreBelow(6)
-				err := tracker.ReceivedPacket(2, time.Time{}, true)
+				err := tracker.ReceivedPacket(2, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 			})
 
 			It("doesn't add delayed packets to the packetHistory", func() {
 				tracker.IgnoreBelow(7)
-				err := tracker.ReceivedPacket(4, time.Time{}, true)
+				err := tracker.ReceivedPacket(4, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
-				err = tracker.ReceivedPacket(10, time.Time{}, true)
+				err = tracker.ReceivedPacket(10, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
@@ -321,7 +424,7 @@ var _ = Describe("Received Packet Tracker", func() {
 
 			It("deletes packets from the packetHistory when a lower limit is set", func() {
 				for i := 1; i <= 12; i++ {
-					err := tracker.ReceivedPacket(protocol.PacketNumber(i), time.Time{}, true)
+					err := tracker.ReceivedPacket(protocol.PacketNumber(i), protocol.ECNNon, time.Time{}, true)
 					Expect(err).ToNot(HaveOccurred())
 				}
 				tracker.IgnoreBelow(7)
@@ -336,7 +439,7 @@ var _ = Describe("Received Packet Tracker", func() {
 			// TODO: remove this test when dropping support for STOP_WAITINGs
 			It("handles a lower limit of 0", func() {
 				tracker.IgnoreBelow(0)
-				err := tracker.ReceivedPacket(1337, time.Time{}, true)
+				err := tracker.ReceivedPacket(1337, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				ack := tracker.GetAckFrame()
 				Expect(ack).ToNot(BeNil())
@@ -344,7 +447,7 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("resets all counters needed for the ACK queueing decision when sending an ACK", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				tracker.ackAlarm = time.Now().Add(-time.Minute)
 				Expect(tracker.GetAckFrame()).ToNot(BeNil())
@@ -355,7 +458,7 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("doesn't generate an ACK when none is queued and the timer is not set", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				tracker.ackQueued = false
 				tracker.ackAlarm = time.Time{}
@@ -363,7 +466,7 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("doesn't generate an ACK when none is queued and the timer has not yet expired", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				tracker.ackQueued = false
 				tracker.ackAlarm = time.Now().Add(time.Minute)
@@ -371,7 +474,7 @@ var _ = Describe("Received Packet Tracker", func() {
 			})
 
 			It("generates an ACK when the timer has expired", func() {
-				err := tracker.ReceivedPacket(1, time.Time{}, true)
+				err := tracker.ReceivedPacket(1, protocol.ECNNon, time.Time{}, true)
 				Expect(err).ToNot(HaveOccurred())
 				tracker.ackQueued = false
 				tracker.ackAlarm = time.Now().Add(-time.Minute)
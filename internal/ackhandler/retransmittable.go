@@ -13,6 +13,22 @@ func stripNonRetransmittableFrames(fs []wire.Frame) []wire.Frame {
 	return res
 }
 
+// stripNeverRetransmittedFrames returns a new slice with all DATAGRAM, PATH_CHALLENGE and PATH_RESPONSE frames deleted.
+// These frames elicit ACKs and count towards the bytes in flight, so they are treated like retransmittable frames,
+// but they are never retransmitted when the packet is lost.
+// A lost PATH_CHALLENGE would be retransmitted on the wrong path, and a new one is sent for every path validation anyway.
+func stripNeverRetransmittedFrames(fs []wire.Frame) []wire.Frame {
+	res := make([]wire.Frame, 0, len(fs))
+	for _, f := range fs {
+		switch f.(type) {
+		case *wire.DatagramFrame, *wire.PathChallengeFrame, *wire.PathResponseFrame:
+		default:
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
 // IsFrameRetransmittable returns true if the frame should be retransmitted.
 func IsFrameRetransmittable(f wire.Frame) bool {
 	switch f.(type) {
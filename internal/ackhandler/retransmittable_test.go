@@ -18,6 +18,7 @@ var _ = Describe("retransmittable frames", func() {
 		&wire.StreamFrame{}:          true,
 		&wire.MaxDataFrame{}:         true,
 		&wire.MaxStreamDataFrame{}:   true,
+		&wire.DatagramFrame{}:        true,
 	} {
 		f := fl
 		e := el
@@ -40,4 +41,10 @@ var _ = Describe("retransmittable frames", func() {
 			Expect(HasRetransmittableFrames([]wire.Frame{f})).To(Equal(e))
 		})
 	}
+
+	It("strips frames that are never retransmitted", func() {
+		sf := &wire.StreamFrame{}
+		fs := []wire.Frame{&wire.DatagramFrame{}, sf, &wire.PathChallengeFrame{}, &wire.PathResponseFrame{}, &wire.DatagramFrame{}}
+		Expect(stripNeverRetransmittedFrames(fs)).To(Equal([]wire.Frame{sf}))
+	})
 })
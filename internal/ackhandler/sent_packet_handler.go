@@ -3,7 +3,7 @@ package ackhandler
 import (
 	"errors"
 	"fmt"
-	"math"
+	"sort"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/internal/congestion"
@@ -11,24 +11,33 @@ import (
 	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
+	"github.com/lucas-clemente/quic-go/logging"
 )
 
 const (
 	// Maximum reordering in time space before time based loss detection considers a packet lost.
-	// In fraction of an RTT.
-	timeReorderingFraction = 1.0 / 8
+	// In fraction of an RTT, expressed as a right shift: 3 corresponds to 1/8 RTT.
+	// The reordering window is increased when spurious losses are detected.
+	initialReorderingShift = 3
 	// Timer granularity. The timer will not be set to a value smaller than granularity.
 	granularity = time.Millisecond
 )
 
+type lostPacket struct {
+	PacketNumber protocol.PacketNumber
+	SendTime     time.Time
+}
+
 type sentPacketHandler struct {
 	lastSentPacketNumber  protocol.PacketNumber
 	packetNumberGenerator *packetNumberGenerator
 
+	// The crypto packets and the 1-RTT packets use separate timers.
+	// The crypto timer is based on the last crypto packet, the PTO on the last retransmittable 1-RTT packet.
 	lastSentRetransmittablePacketTime time.Time
 	lastSentCryptoPacketTime          time.Time
 
-	nextPacketSendTime time.Time
+	pacer *congestion.Pacer
 
 	largestAcked                 protocol.PacketNumber
 	largestReceivedPacketWithAck protocol.PacketNumber
@@ -43,11 +52,47 @@ type sentPacketHandler struct {
 
 	bytesInFlight protocol.ByteCount
 
+	ecnState    ecnState
+	onECNFailed func()
+	// the ECN counts reported by the peer, per packet number space
+	ecnCounts map[protocol.EncryptionLevel]ecnCounts
+
+	// counters, for the Stats
+	numPacketsSent           uint64
+	numPacketsLost           uint64
+	numSpuriousLosses        uint64
+	numPTOs                  uint64
+	numPersistentCongestions uint64
+	bytesRetransmitted       protocol.ByteCount
+
+	// the most recently lost packets, used to detect spurious losses
+	recentlyLostPackets []lostPacket
+	// time based loss detection declares packets lost after maxRTT + maxRTT>>reorderingShift
+	reorderingShift uint
+
+	// the send times of acknowledged packets that were sent after the oldest outstanding packet,
+	// used to determine if all packets sent during a period were lost
+	// It is sorted in ascending order.
+	ackedSendTimes []time.Time
+	// the time when persistent congestion was last detected
+	// Packets sent before that time don't produce RTT samples.
+	persistentCongestionTime time.Time
+
+	clock      congestion.Clock
 	congestion congestion.SendAlgorithm
 	rttStats   *congestion.RTTStats
 
+	streamFrameHandler StreamFrameHandler
+
+	perspective       protocol.Perspective
 	handshakeComplete bool
 
+	// Before the peer's address is validated, we don't send more than
+	// AmplificationFactor times the number of bytes received from it.
+	peerAddressValidated bool
+	bytesReceived        protocol.ByteCount
+	bytesSent            protocol.ByteCount
+
 	// The number of times the crypto packets have been retransmitted without receiving an ack.
 	cryptoCount uint32
 	// The number of times a PTO has been sent without receiving an ack.
@@ -61,30 +106,42 @@ type sentPacketHandler struct {
 	// The alarm timeout
 	alarm time.Time
 
+	tracer logging.ConnectionTracer
+	// the congestion window that was last reported to the tracer
+	tracedCongestionWindow protocol.ByteCount
+
 	logger utils.Logger
 }
 
-// NewSentPacketHandler creates a new sentPacketHandler
+// NewSentPacketHandler creates a new sentPacketHandler.
+// All congestion control decisions are made by the congestion.SendAlgorithm.
 func NewSentPacketHandler(
 	initialPacketNumber protocol.PacketNumber,
+	peerAddressValidated bool,
+	pers protocol.Perspective,
+	clock congestion.Clock,
 	rttStats *congestion.RTTStats,
+	sendAlgorithm congestion.SendAlgorithm,
+	streamFrameHandler StreamFrameHandler,
+	tracer logging.ConnectionTracer,
 	logger utils.Logger,
 ) SentPacketHandler {
-	congestion := congestion.NewCubicSender(
-		congestion.DefaultClock{},
-		rttStats,
-		false, /* don't use reno since chromium doesn't (why?) */
-		protocol.InitialCongestionWindow,
-		protocol.DefaultMaxCongestionWindow,
-	)
-
-	return &sentPacketHandler{
+	h := &sentPacketHandler{
 		packetNumberGenerator: newPacketNumberGenerator(initialPacketNumber, protocol.SkipPacketAveragePeriodLength),
 		packetHistory:         newSentPacketHistory(),
+		ecnCounts:             make(map[protocol.EncryptionLevel]ecnCounts),
+		reorderingShift:       initialReorderingShift,
+		peerAddressValidated:  peerAddressValidated,
+		perspective:           pers,
+		clock:                 clock,
 		rttStats:              rttStats,
-		congestion:            congestion,
+		congestion:            sendAlgorithm,
+		streamFrameHandler:    streamFrameHandler,
+		tracer:                tracer,
 		logger:                logger,
 	}
+	h.pacer = congestion.NewPacer(h.pacingRate)
+	return h
 }
 
 func (h *sentPacketHandler) lowestUnacked() protocol.PacketNumber {
@@ -110,10 +167,45 @@ func (h *sentPacketHandler) SetHandshakeComplete() {
 		return true, nil
 	})
 	for _, p := range cryptoPackets {
+		// These packets will never be acknowledged or declared lost.
+		if p.includedInBytesInFlight {
+			h.bytesInFlight -= p.Length
+			h.congestion.OnPacketDiscarded(p.PacketNumber, p.Length)
+		}
+		h.onPacketLost(p)
 		h.packetHistory.Remove(p.PacketNumber)
 	}
 	h.retransmissionQueue = queue
 	h.handshakeComplete = true
+	h.cryptoCount = 0
+	h.updateLossDetectionAlarm()
+}
+
+func (h *sentPacketHandler) ReceivedBytes(n protocol.ByteCount) {
+	if h.peerAddressValidated {
+		return
+	}
+	wasAmplificationLimited := h.isAmplificationLimited()
+	h.bytesReceived += n
+	if wasAmplificationLimited && !h.isAmplificationLimited() {
+		h.updateLossDetectionAlarm()
+	}
+}
+
+func (h *sentPacketHandler) SetPeerAddressValidated() {
+	if h.peerAddressValidated {
+		return
+	}
+	h.peerAddressValidated = true
+	h.updateLossDetectionAlarm()
+}
+
+// isAmplificationLimited says if sending another packet could exceed the amplification limit.
+func (h *sentPacketHandler) isAmplificationLimited() bool {
+	if h.peerAddressValidated {
+		return false
+	}
+	return h.bytesSent+protocol.MaxPacketSizeIPv4 > protocol.AmplificationFactor*h.bytesReceived
 }
 
 func (h *sentPacketHandler) SentPacket(packet *Packet) {
@@ -142,6 +234,10 @@ func (h *sentPacketHandler) sentPacketImpl(packet *Packet) bool /* isRetransmitt
 	}
 
 	h.lastSentPacketNumber = packet.PacketNumber
+	h.numPacketsSent++
+	if !h.peerAddressValidated {
+		h.bytesSent += packet.Length
+	}
 
 	if len(packet.Frames) > 0 {
 		if ackFrame, ok := packet.Frames[0].(*wire.AckFrame); ok {
@@ -151,12 +247,14 @@ func (h *sentPacketHandler) sentPacketImpl(packet *Packet) bool /* isRetransmitt
 
 	packet.Frames = stripNonRetransmittableFrames(packet.Frames)
 	isRetransmittable := len(packet.Frames) != 0
+	packet.ecnMarked = h.ecnState == ecnStateTesting || h.ecnState == ecnStateCapable
 
 	if isRetransmittable {
 		if packet.EncryptionLevel != protocol.Encryption1RTT {
 			h.lastSentCryptoPacketTime = packet.SendTime
+		} else {
+			h.lastSentRetransmittablePacketTime = packet.SendTime
 		}
-		h.lastSentRetransmittablePacketTime = packet.SendTime
 		packet.includedInBytesInFlight = true
 		h.bytesInFlight += packet.Length
 		packet.canBeRetransmitted = true
@@ -165,8 +263,10 @@ func (h *sentPacketHandler) sentPacketImpl(packet *Packet) bool /* isRetransmitt
 		}
 	}
 	h.congestion.OnPacketSent(packet.SendTime, h.bytesInFlight, packet.PacketNumber, packet.Length, isRetransmittable)
-
-	h.nextPacketSendTime = utils.MaxTime(h.nextPacketSendTime, packet.SendTime).Add(h.congestion.TimeUntilSend(h.bytesInFlight))
+	// Handshake packets and ACK-only packets are not paced.
+	if isRetransmittable && packet.EncryptionLevel == protocol.Encryption1RTT {
+		h.pacer.SentPacket(packet.SendTime, packet.Length)
+	}
 	return isRetransmittable
 }
 
@@ -187,8 +287,15 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumbe
 	if !h.packetNumberGenerator.Validate(ackFrame) {
 		return qerr.Error(qerr.InvalidAckData, "Received an ACK for a skipped packet number")
 	}
+	h.detectSpuriousLosses(ackFrame, rcvTime)
 
-	if rttUpdated := h.maybeUpdateRTT(largestAcked, ackFrame.DelayTime, rcvTime); rttUpdated {
+	ackDelay := ackFrame.DelayTime
+	// After the handshake, the peer's ACK delay is bounded by its max_ack_delay.
+	// Larger values are caused by scheduling delays at the peer, and would lead to an underestimation of the RTT.
+	if h.handshakeComplete {
+		ackDelay = utils.MinDuration(ackDelay, h.rttStats.MaxAckDelay())
+	}
+	if rttUpdated := h.maybeUpdateRTT(largestAcked, ackDelay, rcvTime); rttUpdated {
 		h.congestion.MaybeExitSlowStart()
 	}
 
@@ -216,22 +323,121 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumbe
 		if err := h.onPacketAcked(p, rcvTime); err != nil {
 			return err
 		}
+		h.addAckedSendTime(p.SendTime)
 		if p.includedInBytesInFlight {
 			h.congestion.OnPacketAcked(p.PacketNumber, p.Length, priorInFlight, rcvTime)
 		}
 	}
 
+	if h.ecnState == ecnStateTesting || h.ecnState == ecnStateCapable {
+		h.processECNCounts(ackFrame, encLevel, ackedPackets, largestAcked, priorInFlight)
+	}
+
 	if err := h.detectLostPackets(rcvTime, priorInFlight); err != nil {
 		return err
 	}
+	h.pruneAckedSendTimes()
 
 	h.ptoCount = 0
 	h.cryptoCount = 0
 
 	h.updateLossDetectionAlarm()
+	h.traceCongestionWindow()
 	return nil
 }
 
+func (h *sentPacketHandler) EnableECN(onFailed func()) {
+	h.ecnState = ecnStateTesting
+	h.onECNFailed = onFailed
+}
+
+// processECNCounts validates the ECN counts reported in an ACK frame (see RFC 9000, section 13.4.2).
+// The counts must account for all newly acknowledged packets that were sent with ECT(0).
+// Otherwise, a middlebox is removing the ECN marks, or the peer doesn't report them correctly,
+// and we can't rely on the CE count.
+func (h *sentPacketHandler) processECNCounts(
+	ackFrame *wire.AckFrame,
+	encLevel protocol.EncryptionLevel,
+	ackedPackets []*Packet,
+	largestAcked protocol.PacketNumber,
+	priorInFlight protocol.ByteCount,
+) {
+	var newlyAckedECT uint64
+	for _, p := range ackedPackets {
+		if p.ecnMarked {
+			newlyAckedECT++
+		}
+	}
+	if newlyAckedECT == 0 {
+		return
+	}
+	prev := h.ecnCounts[encLevel]
+	counts := ecnCounts{ect0: ackFrame.ECT0, ect1: ackFrame.ECT1, ce: ackFrame.ECNCE}
+	switch {
+	case counts == ecnCounts{}:
+		h.failECNValidation("ACK doesn't contain ECN counts")
+		return
+	case counts.ect1 > 0:
+		// We only send ECT(0).
+		h.failECNValidation("peer reported ECT(1)")
+		return
+	case counts.ect0 < prev.ect0 || counts.ce < prev.ce:
+		h.failECNValidation("ECN counts decreased")
+		return
+	case counts.ect0-prev.ect0+counts.ce-prev.ce < newlyAckedECT:
+		h.failECNValidation("ECN counts don't account for all acknowledged packets")
+		return
+	}
+	h.ecnCounts[encLevel] = counts
+	if h.ecnState == ecnStateTesting {
+		h.logger.Debugf("ECN validation succeeded.")
+		h.ecnState = ecnStateCapable
+	}
+	if counts.ce > prev.ce {
+		h.logger.Debugf("Peer reported %d new packets marked CE.", counts.ce-prev.ce)
+		h.congestion.OnCongestionEvent(largestAcked, priorInFlight)
+	}
+}
+
+func (h *sentPacketHandler) failECNValidation(reason string) {
+	h.logger.Debugf("ECN validation failed: %s. Disabling ECN.", reason)
+	h.ecnState = ecnStateFailed
+	if h.onECNFailed != nil {
+		h.onECNFailed()
+	}
+}
+
+func (h *sentPacketHandler) RegisterAckCallback(pn protocol.PacketNumber, cb func(rtt time.Duration)) error {
+	p := h.packetHistory.GetPacket(pn)
+	if p == nil {
+		return fmt.Errorf("sent packet handler: packet %d not found", pn)
+	}
+	p.ackCallbacks = append(p.ackCallbacks, cb)
+	return nil
+}
+
+func (h *sentPacketHandler) RegisterLossCallback(pn protocol.PacketNumber, cb func()) error {
+	p := h.packetHistory.GetPacket(pn)
+	if p == nil {
+		return fmt.Errorf("sent packet handler: packet %d not found", pn)
+	}
+	p.lossCallbacks = append(p.lossCallbacks, cb)
+	return nil
+}
+
+// onPacketLost calls the loss callbacks of a packet that is removed from the history without being acknowledged.
+func (h *sentPacketHandler) onPacketLost(p *Packet) {
+	for _, cb := range p.lossCallbacks {
+		cb()
+	}
+	p.ackCallbacks = nil
+	p.lossCallbacks = nil
+}
+
+func (h *sentPacketHandler) HasOutstandingData() bool {
+	return h.packetHistory.HasOutstandingPackets() || len(h.retransmissionQueue) > 0
+}
+
 func (h *sentPacketHandler) GetLowestPacketNotConfirmedAcked() protocol.PacketNumber {
 	return h.lowestPacketNotConfirmedAcked
 }
@@ -282,37 +488,83 @@ func (h *sentPacketHandler) determineNewlyAckedPackets(ackFrame *wire.AckFrame)
 
 func (h *sentPacketHandler) maybeUpdateRTT(largestAcked protocol.PacketNumber, ackDelay time.Duration, rcvTime time.Time) bool {
 	if p := h.packetHistory.GetPacket(largestAcked); p != nil {
+		// Packets sent before persistent congestion was detected might have been delayed by the congestion.
+		if p.SendTime.Before(h.persistentCongestionTime) {
+			return false
+		}
 		h.rttStats.UpdateRTT(rcvTime.Sub(p.SendTime), ackDelay, rcvTime)
 		if h.logger.Debug() {
 			h.logger.Debugf("\tupdated RTT: %s (σ: %s)", h.rttStats.SmoothedRTT(), h.rttStats.MeanDeviation())
 		}
+		if h.tracer != nil {
+			h.tracer.UpdatedRTT(logging.RTTSnapshot{
+				LatestRTT:     h.rttStats.LatestRTT(),
+				SmoothedRTT:   h.rttStats.SmoothedRTT(),
+				MinRTT:        h.rttStats.MinRTT(),
+				MeanDeviation: h.rttStats.MeanDeviation(),
+			})
+		}
 		return true
 	}
 	return false
 }
 
 func (h *sentPacketHandler) updateLossDetectionAlarm() {
-	// Cancel the alarm if no packets are outstanding
-	if !h.packetHistory.HasOutstandingPackets() {
+	// Retransmissions can't be sent while we're amplification limited.
+	// The alarm is set again once more bytes are received from the peer.
+	if h.isAmplificationLimited() {
 		h.alarm = time.Time{}
 		return
 	}
+	cryptoAlarm := h.getCryptoAlarm()
+	lossAlarm := h.getLossAlarm()
+	if cryptoAlarm.IsZero() || (!lossAlarm.IsZero() && lossAlarm.Before(cryptoAlarm)) {
+		h.alarm = lossAlarm
+	} else {
+		h.alarm = cryptoAlarm
+	}
+}
 
-	if h.packetHistory.HasOutstandingCryptoPackets() {
-		h.alarm = h.lastSentCryptoPacketTime.Add(h.computeCryptoTimeout())
-	} else if !h.lossTime.IsZero() {
+// getCryptoAlarm returns the time when the outstanding crypto packets are retransmitted.
+// It returns the zero value if there are no outstanding crypto packets,
+// unless the client needs to send a crypto probe packet (see needsCryptoProbe).
+func (h *sentPacketHandler) getCryptoAlarm() time.Time {
+	if !h.packetHistory.HasOutstandingCryptoPackets() && !h.needsCryptoProbe() {
+		return time.Time{}
+	}
+	return h.lastSentCryptoPacketTime.Add(h.computeCryptoTimeout())
+}
+
+// needsCryptoProbe says if the client needs to send a probe packet when the crypto alarm fires,
+// although it doesn't have any outstanding crypto packets.
+// This happens when the server's first flight was lost, and the server can't retransmit it
+// because it is blocked by the amplification limit.
+// The client therefore keeps the crypto alarm armed until the handshake is complete.
+func (h *sentPacketHandler) needsCryptoProbe() bool {
+	return h.perspective == protocol.PerspectiveClient &&
+		!h.handshakeComplete &&
+		!h.lastSentCryptoPacketTime.IsZero() &&
+		!h.packetHistory.HasOutstandingCryptoPackets()
+}
+
+// getLossAlarm returns the time when the time based loss detection or the PTO fires.
+// The PTO is only armed if there are outstanding 1-RTT packets.
+func (h *sentPacketHandler) getLossAlarm() time.Time {
+	if !h.lossTime.IsZero() {
 		// Early retransmit timer or time loss detection.
-		h.alarm = h.lossTime
-	} else { // PTO alarm
-		h.alarm = h.lastSentRetransmittablePacketTime.Add(h.computePTOTimeout())
+		return h.lossTime
 	}
+	if !h.packetHistory.HasOutstanding1RTTPackets() {
+		return time.Time{}
+	}
+	return h.lastSentRetransmittablePacketTime.Add(h.computePTOTimeout())
 }
 
 func (h *sentPacketHandler) detectLostPackets(now time.Time, priorInFlight protocol.ByteCount) error {
 	h.lossTime = time.Time{}
 
-	maxRTT := float64(utils.MaxDuration(h.rttStats.LatestRTT(), h.rttStats.SmoothedRTT()))
-	delayUntilLost := time.Duration((1.0 + timeReorderingFraction) * maxRTT)
+	maxRTT := utils.MaxDuration(h.rttStats.LatestRTT(), h.rttStats.SmoothedRTT())
+	delayUntilLost := maxRTT + maxRTT>>h.reorderingShift
 
 	var lostPackets []*Packet
 	h.packetHistory.Iterate(func(packet *Packet) (bool, error) {
@@ -341,7 +593,12 @@ func (h *sentPacketHandler) detectLostPackets(now time.Time, priorInFlight proto
 		h.logger.Debugf("\tlost packets (%d): %#x", len(pns), pns)
 	}
 
+	h.numPacketsLost += uint64(len(lostPackets))
 	for _, p := range lostPackets {
+		if h.tracer != nil {
+			h.tracer.LostPacket(p.EncryptionLevel, p.PacketNumber, logging.PacketLossTimeThreshold)
+		}
+		h.recentlyLostPackets = append(h.recentlyLostPackets, lostPacket{PacketNumber: p.PacketNumber, SendTime: p.SendTime})
 		// the bytes in flight need to be reduced no matter if this packet will be retransmitted
 		if p.includedInBytesInFlight {
 			h.bytesInFlight -= p.Length
@@ -353,44 +610,153 @@ func (h *sentPacketHandler) detectLostPackets(now time.Time, priorInFlight proto
 				return err
 			}
 		}
+		h.onPacketLost(p)
 		h.packetHistory.Remove(p.PacketNumber)
 	}
+	if len(h.recentlyLostPackets) > protocol.MaxTrackedLostPackets {
+		h.recentlyLostPackets = h.recentlyLostPackets[len(h.recentlyLostPackets)-protocol.MaxTrackedLostPackets:]
+	}
+	if h.isPersistentCongestion(lostPackets) {
+		h.logger.Debugf("\tpersistent congestion detected")
+		h.numPersistentCongestions++
+		h.persistentCongestionTime = now
+		h.congestion.OnPersistentCongestion()
+		h.rttStats.OnPersistentCongestion()
+	}
 	return nil
 }
 
+// isPersistentCongestion says if all packets sent over a period longer than PersistentCongestionThreshold PTOs were lost.
+// The lost packets are sorted by packet number, and therefore by send time.
+// A period ends when a packet sent during the period was acknowledged.
+// Since the send times of acknowledged packets are sorted as well, this is a single pass over both lists.
+func (h *sentPacketHandler) isPersistentCongestion(lostPackets []*Packet) bool {
+	if len(lostPackets) < 2 {
+		return false
+	}
+	duration := h.ptoDuration() * protocol.PersistentCongestionThreshold
+	start := lostPackets[0].SendTime
+	// i is the index of the first acknowledged packet sent after start
+	var i int
+	for i < len(h.ackedSendTimes) && !h.ackedSendTimes[i].After(start) {
+		i++
+	}
+	for _, p := range lostPackets[1:] {
+		if i < len(h.ackedSendTimes) && h.ackedSendTimes[i].Before(p.SendTime) {
+			// a packet sent between start and this packet was acknowledged
+			start = p.SendTime
+			for i < len(h.ackedSendTimes) && !h.ackedSendTimes[i].After(start) {
+				i++
+			}
+			continue
+		}
+		if p.SendTime.Sub(start) > duration {
+			return true
+		}
+	}
+	return false
+}
+
+// addAckedSendTime inserts the send time of an acknowledged packet, keeping ackedSendTimes sorted.
+// Packets are usually acknowledged in the order they were sent, so this is an append in most cases.
+func (h *sentPacketHandler) addAckedSendTime(t time.Time) {
+	if n := len(h.ackedSendTimes); n == 0 || !t.Before(h.ackedSendTimes[n-1]) {
+		h.ackedSendTimes = append(h.ackedSendTimes, t)
+		return
+	}
+	i := sort.Search(len(h.ackedSendTimes), func(i int) bool { return h.ackedSendTimes[i].After(t) })
+	h.ackedSendTimes = append(h.ackedSendTimes, time.Time{})
+	copy(h.ackedSendTimes[i+1:], h.ackedSendTimes[i:])
+	h.ackedSendTimes[i] = t
+}
+
+// pruneAckedSendTimes deletes the send times of acknowledged packets that were sent before all outstanding packets.
+// These packets can't have been sent between two packets that will be declared lost.
+func (h *sentPacketHandler) pruneAckedSendTimes() {
+	var oldest *Packet
+	h.packetHistory.Iterate(func(p *Packet) (bool, error) {
+		oldest = p
+		return false, nil
+	})
+	if oldest == nil {
+		h.ackedSendTimes = h.ackedSendTimes[:0]
+		return
+	}
+	times := h.ackedSendTimes[:0]
+	for _, t := range h.ackedSendTimes {
+		if t.After(oldest.SendTime) {
+			times = append(times, t)
+		}
+	}
+	h.ackedSendTimes = times
+}
+
+// detectSpuriousLosses detects packets that were declared lost (and retransmitted), but are acknowledged later.
+// The reordering window is increased, such that these packets wouldn't have been declared lost.
+func (h *sentPacketHandler) detectSpuriousLosses(ackFrame *wire.AckFrame, rcvTime time.Time) {
+	if len(h.recentlyLostPackets) == 0 {
+		return
+	}
+	maxRTT := utils.MaxDuration(h.rttStats.LatestRTT(), h.rttStats.SmoothedRTT())
+	lost := h.recentlyLostPackets[:0]
+	for _, p := range h.recentlyLostPackets {
+		if !ackFrame.AcksPacket(p.PacketNumber) {
+			lost = append(lost, p)
+			continue
+		}
+		h.numSpuriousLosses++
+		timeNeeded := rcvTime.Sub(p.SendTime)
+		for h.reorderingShift > 0 && maxRTT+maxRTT>>h.reorderingShift < timeNeeded {
+			h.reorderingShift--
+		}
+		if h.logger.Debug() {
+			h.logger.Debugf("\tpacket %#x was declared lost, but was acknowledged. Reordering window: 1/%d RTT", p.PacketNumber, 1<<h.reorderingShift)
+		}
+	}
+	h.recentlyLostPackets = lost
+}
+
 func (h *sentPacketHandler) OnAlarm() error {
 	// When all outstanding are acknowledged, the alarm is canceled in
 	// updateLossDetectionAlarm. This doesn't reset the timer in the session though.
 	// When OnAlarm is called, we therefore need to make sure that there are
-	// actually packets outstanding.
-	if h.packetHistory.HasOutstandingPackets() {
+	// actually packets outstanding (or that the client needs to send a crypto probe packet).
+	if h.packetHistory.HasOutstandingPackets() || h.needsCryptoProbe() {
 		if err := h.onVerifiedAlarm(); err != nil {
 			return err
 		}
 	}
 	h.updateLossDetectionAlarm()
+	h.traceCongestionWindow()
 	return nil
 }
 
 func (h *sentPacketHandler) onVerifiedAlarm() error {
 	var err error
-	if h.packetHistory.HasOutstandingCryptoPackets() {
+	// Both timers might be armed. Handle the one that fires first.
+	cryptoAlarm := h.getCryptoAlarm()
+	if lossAlarm := h.getLossAlarm(); !cryptoAlarm.IsZero() && (lossAlarm.IsZero() || !lossAlarm.Before(cryptoAlarm)) {
 		if h.logger.Debug() {
 			h.logger.Debugf("Loss detection alarm fired in crypto mode. Crypto count: %d", h.cryptoCount)
 		}
 		h.cryptoCount++
-		err = h.queueCryptoPacketsForRetransmission()
+		if h.needsCryptoProbe() {
+			h.numProbesToSend++
+		} else {
+			err = h.queueCryptoPacketsForRetransmission()
+		}
 	} else if !h.lossTime.IsZero() {
 		if h.logger.Debug() {
 			h.logger.Debugf("Loss detection alarm fired in loss timer mode. Loss time: %s", h.lossTime)
 		}
 		// Early retransmit or time loss detection
-		err = h.detectLostPackets(time.Now(), h.bytesInFlight)
+		err = h.detectLostPackets(h.clock.Now(), h.bytesInFlight)
 	} else { // PTO
 		if h.logger.Debug() {
 			h.logger.Debugf("Loss detection alarm fired in PTO mode. PTO count: %d", h.ptoCount)
 		}
 		h.ptoCount++
+		h.numPTOs++
 		h.numProbesToSend += 2
 	}
 	return err
@@ -407,6 +773,9 @@ func (h *sentPacketHandler) onPacketAcked(p *Packet, rcvTime time.Time) error {
 	if packet := h.packetHistory.GetPacket(p.PacketNumber); packet == nil {
 		return nil
 	}
+	for _, cb := range p.ackCallbacks {
+		cb(rcvTime.Sub(p.SendTime))
+	}
 
 	// only report the acking of this packet to the congestion controller if:
 	// * it is a retransmittable packet
@@ -432,6 +801,11 @@ func (h *sentPacketHandler) onPacketAcked(p *Packet, rcvTime time.Time) error {
 	if p.includedInBytesInFlight {
 		h.bytesInFlight -= p.Length
 	}
+	for _, f := range p.Frames {
+		if sf, ok := f.(*wire.StreamFrame); ok {
+			h.streamFrameHandler.OnStreamFrameAcked(sf)
+		}
+	}
 	if err := h.stopRetransmissionsFor(p); err != nil {
 		return err
 	}
@@ -468,6 +842,9 @@ func (h *sentPacketHandler) DequeueProbePacket() (*Packet, error) {
 	if len(h.retransmissionQueue) == 0 {
 		p := h.packetHistory.FirstOutstanding()
 		if p == nil {
+			if h.needsCryptoProbe() {
+				return nil, nil
+			}
 			return nil, errors.New("cannot dequeue a probe packet. No outstanding packets")
 		}
 		if err := h.queuePacketForRetransmission(p); err != nil {
@@ -499,6 +876,12 @@ func (h *sentPacketHandler) SendMode() SendMode {
 		}
 		return SendNone
 	}
+	if h.isAmplificationLimited() {
+		if h.logger.Debug() {
+			h.logger.Debugf("Amplification limited: sent %d bytes, received %d bytes from an unvalidated address", h.bytesSent, h.bytesReceived)
+		}
+		return SendNone
+	}
 	if h.numProbesToSend > 0 {
 		return SendPTO
 	}
@@ -523,7 +906,7 @@ func (h *sentPacketHandler) SendMode() SendMode {
 }
 
 func (h *sentPacketHandler) TimeUntilSend() time.Time {
-	return h.nextPacketSendTime
+	return h.pacer.TimeUntilSend()
 }
 
 func (h *sentPacketHandler) ShouldSendNumPackets() int {
@@ -531,11 +914,25 @@ func (h *sentPacketHandler) ShouldSendNumPackets() int {
 		// RTO probes should not be paced, but must be sent immediately.
 		return h.numProbesToSend
 	}
+	budget := h.pacer.Budget(h.clock.Now())
+	if budget >= protocol.MaxPacingBurstPackets*protocol.MaxPacketSizeIPv4 {
+		return protocol.MaxPacingBurstPackets
+	}
+	return utils.Max(1, int(budget/protocol.MaxPacketSizeIPv4))
+}
+
+// pacingRate is the rate at which packets are paced, as determined by the congestion controller.
+func (h *sentPacketHandler) pacingRate() congestion.Bandwidth {
 	delay := h.congestion.TimeUntilSend(h.bytesInFlight)
-	if delay == 0 || delay > protocol.MinPacingDelay {
+	if delay == 0 {
+		return 0
+	}
+	// Don't use BandwidthFromDelta here, it rounds very low rates down to 0, which would disable pacing.
+	bw := congestion.Bandwidth(protocol.DefaultTCPMSS) * congestion.BytesPerSecond * congestion.Bandwidth(time.Second) / congestion.Bandwidth(delay)
+	if bw == 0 {
 		return 1
 	}
-	return int(math.Ceil(float64(protocol.MinPacingDelay) / float64(delay)))
+	return bw
 }
 
 func (h *sentPacketHandler) queueCryptoPacketsForRetransmission() error {
@@ -562,10 +959,88 @@ func (h *sentPacketHandler) queuePacketForRetransmission(p *Packet) error {
 	if err := h.packetHistory.MarkCannotBeRetransmitted(p.PacketNumber); err != nil {
 		return err
 	}
+	p.Frames = stripNeverRetransmittedFrames(p.Frames)
+	// If the packet only contained frames that are never retransmitted, there's nothing left to retransmit.
+	if len(p.Frames) == 0 {
+		return nil
+	}
+	h.bytesRetransmitted += p.Length
+	// Lost STREAM frames are handed back to their streams.
+	// The streams retransmit the data in new STREAM frames, sized to fit into the packets sent then.
+	frames := p.Frames[:0]
+	for _, f := range p.Frames {
+		if sf, ok := f.(*wire.StreamFrame); ok {
+			h.streamFrameHandler.OnStreamFrameLost(sf)
+			continue
+		}
+		frames = append(frames, f)
+	}
+	p.Frames = frames
+	if len(p.Frames) == 0 {
+		return nil
+	}
 	h.retransmissionQueue = append(h.retransmissionQueue, p)
 	return nil
 }
 
+func (h *sentPacketHandler) OnConnectionMigration() error {
+	// None of the packets sent on the old path will be acknowledged reliably.
+	// Queue all of them for retransmission on the new path.
+	var packets []*Packet
+	h.packetHistory.Iterate(func(p *Packet) (bool, error) {
+		packets = append(packets, p)
+		return true, nil
+	})
+	for _, p := range packets {
+		if p.includedInBytesInFlight {
+			h.bytesInFlight -= p.Length
+		}
+		if p.canBeRetransmitted {
+			if err := h.queuePacketForRetransmission(p); err != nil {
+				return err
+			}
+		}
+		h.onPacketLost(p)
+		h.packetHistory.Remove(p.PacketNumber)
+	}
+	// The RTT and the congestion state of the old path don't tell us anything about the new path.
+	h.rttStats.OnConnectionMigration()
+	h.congestion.OnConnectionMigration()
+	h.lossTime = time.Time{}
+	h.ackedSendTimes = nil
+	h.cryptoCount = 0
+	h.ptoCount = 0
+	h.numProbesToSend = 0
+	h.updateLossDetectionAlarm()
+	h.traceCongestionWindow()
+	return nil
+}
+
+// traceCongestionWindow reports the congestion window to the tracer, if it changed.
+func (h *sentPacketHandler) traceCongestionWindow() {
+	if h.tracer == nil {
+		return
+	}
+	if cwnd := h.congestion.GetCongestionWindow(); cwnd != h.tracedCongestionWindow {
+		h.tracedCongestionWindow = cwnd
+		h.tracer.UpdatedCongestionWindow(cwnd, h.bytesInFlight)
+	}
+}
+
+func (h *sentPacketHandler) GetStats() Stats {
+	return Stats{
+		PacketsSent:           h.numPacketsSent,
+		PacketsLost:           h.numPacketsLost,
+		SpuriousLosses:        h.numSpuriousLosses,
+		PTOs:                  h.numPTOs,
+		PersistentCongestions: h.numPersistentCongestions,
+		BytesRetransmitted:    h.bytesRetransmitted,
+		BytesInFlight:         h.bytesInFlight,
+		CongestionWindow:      h.congestion.GetCongestionWindow(),
+		PacingRate:            h.pacingRate(),
+	}
+}
+
 func (h *sentPacketHandler) computeCryptoTimeout() time.Duration {
 	duration := utils.MaxDuration(2*h.rttStats.SmoothedOrInitialRTT(), granularity)
 	// exponential backoff
@@ -574,7 +1049,11 @@ func (h *sentPacketHandler) computeCryptoTimeout() time.Duration {
 }
 
 func (h *sentPacketHandler) computePTOTimeout() time.Duration {
-	// TODO(#1236): include the max_ack_delay
-	duration := utils.MaxDuration(h.rttStats.SmoothedOrInitialRTT()+4*h.rttStats.MeanDeviation(), granularity)
-	return duration << h.ptoCount
+	// exponential backoff
+	return h.ptoDuration() << h.ptoCount
+}
+
+// ptoDuration is the probe timeout, without the exponential backoff.
+func (h *sentPacketHandler) ptoDuration() time.Duration {
+	return utils.MaxDuration(h.rttStats.SmoothedOrInitialRTT()+4*h.rttStats.MeanDeviation(), granularity) + h.rttStats.MaxAckDelay()
 }
@@ -6,9 +6,11 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/mocks"
+	mocklogging "github.com/lucas-clemente/quic-go/internal/mocks/logging"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
+	"github.com/lucas-clemente/quic-go/logging"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -43,13 +45,23 @@ func cryptoPacket(p *Packet) *Packet {
 
 var _ = Describe("SentPacketHandler", func() {
 	var (
-		handler     *sentPacketHandler
-		streamFrame wire.StreamFrame
+		handler            *sentPacketHandler
+		streamFrame        wire.StreamFrame
+		streamFrameHandler *mocks.MockStreamFrameHandler
 	)
 
 	BeforeEach(func() {
 		rttStats := &congestion.RTTStats{}
-		handler = NewSentPacketHandler(42, rttStats, utils.DefaultLogger).(*sentPacketHandler)
+		streamFrameHandler = mocks.NewMockStreamFrameHandler(mockCtrl)
+		cong := congestion.NewCubicSender(
+			congestion.DefaultClock{},
+			rttStats,
+			false,
+			protocol.InitialCongestionWindow,
+			protocol.DefaultMinCongestionWindow,
+			protocol.DefaultMaxCongestionWindow,
+		)
+		handler = NewSentPacketHandler(42, true, protocol.PerspectiveServer, congestion.DefaultClock{}, rttStats, cong, streamFrameHandler, nil, utils.DefaultLogger).(*sentPacketHandler)
 		handler.SetHandshakeComplete()
 		streamFrame = wire.StreamFrame{
 			StreamID: 5,
@@ -126,6 +138,24 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.lastSentRetransmittablePacketTime).To(BeZero())
 			Expect(handler.bytesInFlight).To(BeZero())
 		})
+
+		It("says if it has outstanding data", func() {
+			Expect(handler.HasOutstandingData()).To(BeFalse())
+			handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: 1}))
+			Expect(handler.HasOutstandingData()).To(BeFalse())
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2}))
+			Expect(handler.HasOutstandingData()).To(BeTrue())
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+			Expect(handler.HasOutstandingData()).To(BeFalse())
+		})
+
+		It("has outstanding data when a packet is queued for retransmission", func() {
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1}))
+			Expect(handler.queuePacketForRetransmission(getPacket(1))).To(Succeed())
+			Expect(handler.packetHistory.HasOutstandingPackets()).To(BeFalse())
+			Expect(handler.HasOutstandingData()).To(BeTrue())
+		})
 	})
 
 	Context("ACK processing", func() {
@@ -321,6 +351,7 @@ var _ = Describe("SentPacketHandler", func() {
 			})
 
 			It("uses the DelayTime in the ACK frame", func() {
+				handler.rttStats.SetMaxAckDelay(10 * time.Minute)
 				now := time.Now()
 				// make sure the rttStats have a min RTT, so that the delay is used
 				handler.rttStats.UpdateRTT(5*time.Minute, 0, time.Now())
@@ -333,6 +364,32 @@ var _ = Describe("SentPacketHandler", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 5*time.Minute, 1*time.Second))
 			})
+
+			It("limits the DelayTime in the ACK frame to the max_ack_delay, after the handshake completed", func() {
+				handler.rttStats.SetMaxAckDelay(time.Minute)
+				now := time.Now()
+				handler.rttStats.UpdateRTT(5*time.Minute, 0, time.Now())
+				getPacket(1).SendTime = now.Add(-10 * time.Minute)
+				ack := &wire.AckFrame{
+					AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}},
+					DelayTime: 5 * time.Minute,
+				}
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 9*time.Minute, 1*time.Second))
+			})
+
+			It("doesn't limit the DelayTime in the ACK frame before the handshake completed", func() {
+				handler.handshakeComplete = false
+				now := time.Now()
+				handler.rttStats.UpdateRTT(5*time.Minute, 0, time.Now())
+				getPacket(1).SendTime = now.Add(-10 * time.Minute)
+				ack := &wire.AckFrame{
+					AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}},
+					DelayTime: 5 * time.Minute,
+				}
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(handler.rttStats.LatestRTT()).To(BeNumerically("~", 5*time.Minute, 1*time.Second))
+			})
 		})
 
 		Context("determining which ACKs we have received an ACK for", func() {
@@ -347,6 +404,8 @@ var _ = Describe("SentPacketHandler", func() {
 				for _, packet := range morePackets {
 					handler.SentPacket(packet)
 				}
+				streamFrameHandler.EXPECT().OnStreamFrameAcked(&streamFrame).AnyTimes()
+				streamFrameHandler.EXPECT().OnStreamFrameLost(&streamFrame).AnyTimes()
 			})
 
 			It("determines which ACK we have received an ACK for", func() {
@@ -421,6 +480,102 @@ var _ = Describe("SentPacketHandler", func() {
 		})
 	})
 
+	Context("ACK callbacks", func() {
+		It("calls the callback when the packet is acked", func() {
+			now := time.Now()
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-time.Second)}))
+			var rtts []time.Duration
+			Expect(handler.RegisterAckCallback(1, func(rtt time.Duration) { rtts = append(rtts, rtt) })).To(Succeed())
+			Expect(handler.RegisterAckCallback(1, func(rtt time.Duration) { rtts = append(rtts, rtt) })).To(Succeed())
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+			Expect(rtts).To(Equal([]time.Duration{time.Second, time.Second}))
+		})
+
+		It("errors when registering a callback for an unknown packet", func() {
+			err := handler.RegisterAckCallback(1, func(time.Duration) {})
+			Expect(err).To(MatchError("sent packet handler: packet 1 not found"))
+			err = handler.RegisterLossCallback(1, func() {})
+			Expect(err).To(MatchError("sent packet handler: packet 1 not found"))
+		})
+
+		It("calls the loss callback when the packet is declared lost", func() {
+			now := time.Now()
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-time.Hour)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now.Add(-time.Second)}))
+			var lost, acked int
+			Expect(handler.RegisterLossCallback(1, func() { lost++ })).To(Succeed())
+			Expect(handler.RegisterAckCallback(1, func(time.Duration) { acked++ })).To(Succeed())
+			Expect(handler.RegisterLossCallback(2, func() { lost++ })).To(Succeed())
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+			Expect(lost).To(Equal(1))
+			Expect(acked).To(BeZero())
+		})
+
+		It("calls the loss callback when a crypto packet is discarded", func() {
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, EncryptionLevel: protocol.EncryptionInitial}))
+			var lost bool
+			Expect(handler.RegisterLossCallback(1, func() { lost = true })).To(Succeed())
+			handler.SetHandshakeComplete()
+			Expect(lost).To(BeTrue())
+		})
+
+		It("removes discarded crypto packets from bytes in flight", func() {
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, EncryptionLevel: protocol.EncryptionInitial, Length: 1000}))
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(1000)))
+			handler.SetHandshakeComplete()
+			Expect(handler.bytesInFlight).To(BeZero())
+		})
+	})
+
+	Context("reporting STREAM frames", func() {
+		streamPacket := func(p *Packet) *Packet {
+			p = retransmittablePacket(p)
+			p.Frames = []wire.Frame{&streamFrame}
+			return p
+		}
+
+		It("reports STREAM frames in acknowledged packets", func() {
+			handler.SentPacket(streamPacket(&Packet{PacketNumber: 1}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2}))
+			streamFrameHandler.EXPECT().OnStreamFrameAcked(&streamFrame)
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+		})
+
+		It("reports STREAM frames in lost packets, and doesn't queue them for retransmission", func() {
+			handler.SentPacket(streamPacket(&Packet{PacketNumber: 1}))
+			streamFrameHandler.EXPECT().OnStreamFrameLost(&streamFrame)
+			Expect(handler.queuePacketForRetransmission(getPacket(1))).To(Succeed())
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+		})
+
+		It("only queues the control frames of a lost packet for retransmission", func() {
+			p := streamPacket(&Packet{PacketNumber: 1})
+			p.Frames = append(p.Frames, &wire.MaxDataFrame{ByteOffset: 0x1337})
+			handler.SentPacket(p)
+			streamFrameHandler.EXPECT().OnStreamFrameLost(&streamFrame)
+			Expect(handler.queuePacketForRetransmission(getPacket(1))).To(Succeed())
+			packet := handler.DequeuePacketForRetransmission()
+			Expect(packet).ToNot(BeNil())
+			Expect(packet.Frames).To(Equal([]wire.Frame{&wire.MaxDataFrame{ByteOffset: 0x1337}}))
+		})
+
+		It("doesn't report STREAM frames as acknowledged when a lost packet is acked", func() {
+			handler.SentPacket(streamPacket(&Packet{PacketNumber: 5}))
+			streamFrameHandler.EXPECT().OnStreamFrameLost(&streamFrame)
+			Expect(handler.queuePacketForRetransmission(getPacket(5))).To(Succeed())
+			// the stream sends the data in a new packet
+			handler.SentPacket(streamPacket(&Packet{PacketNumber: 6}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 5, Largest: 5}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+			streamFrameHandler.EXPECT().OnStreamFrameAcked(&streamFrame)
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 5, Largest: 6}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, time.Now())).To(Succeed())
+		})
+	})
+
 	It("does not dequeue a packet if no ack has been received", func() {
 		handler.SentPacket(&Packet{PacketNumber: 1})
 		Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
@@ -442,7 +597,6 @@ var _ = Describe("SentPacketHandler", func() {
 				protocol.ByteCount(42),
 				true,
 			)
-			cong.EXPECT().TimeUntilSend(gomock.Any())
 			p := &Packet{
 				PacketNumber: 1,
 				Length:       42,
@@ -515,6 +669,93 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		It("reports congestion events when the peer reports new CE marks", func() {
+			handler.EnableECN(nil)
+			cong.EXPECT().OnPacketSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(3)
+			cong.EXPECT().TimeUntilSend(gomock.Any()).Times(3)
+			cong.EXPECT().MaybeExitSlowStart().AnyTimes()
+			cong.EXPECT().OnPacketAcked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(3)
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3}))
+			cong.EXPECT().OnCongestionEvent(protocol.PacketNumber(1), protocol.ByteCount(3))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}, ECT0: 1, ECNCE: 1}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+			// the CE count didn't increase
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}, ECT0: 2, ECNCE: 1}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, time.Now())).To(Succeed())
+			cong.EXPECT().OnCongestionEvent(protocol.PacketNumber(3), protocol.ByteCount(1))
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 3}}, ECT0: 2, ECNCE: 2}
+			Expect(handler.ReceivedAck(ack, 3, protocol.Encryption1RTT, time.Now())).To(Succeed())
+		})
+
+		Context("ECN validation", func() {
+			var failed bool
+
+			BeforeEach(func() {
+				failed = false
+				handler.EnableECN(func() { failed = true })
+				cong.EXPECT().OnPacketSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				cong.EXPECT().TimeUntilSend(gomock.Any()).AnyTimes()
+				cong.EXPECT().MaybeExitSlowStart().AnyTimes()
+				cong.EXPECT().OnPacketAcked(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				for pn := protocol.PacketNumber(1); pn <= 3; pn++ {
+					handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: pn}))
+				}
+			})
+
+			It("marks packets", func() {
+				Expect(handler.packetHistory.GetPacket(1).ecnMarked).To(BeTrue())
+			})
+
+			It("accepts valid ECN counts", func() {
+				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}, ECT0: 2}
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(handler.ecnState).To(Equal(ecnStateCapable))
+				Expect(failed).To(BeFalse())
+			})
+
+			It("fails if the ACK doesn't contain ECN counts", func() {
+				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}}
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(failed).To(BeTrue())
+				// packets sent after validation failed are not marked
+				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 4}))
+				Expect(handler.packetHistory.GetPacket(4).ecnMarked).To(BeFalse())
+			})
+
+			It("fails if the ECN counts don't account for all acknowledged packets", func() {
+				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}, ECT0: 1}
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(failed).To(BeTrue())
+			})
+
+			It("fails if the peer reports ECT(1)", func() {
+				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}, ECT0: 1, ECT1: 1}
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(failed).To(BeTrue())
+			})
+
+			It("fails if the ECN counts decrease", func() {
+				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}, ECT0: 1, ECNCE: 1}
+				cong.EXPECT().OnCongestionEvent(gomock.Any(), gomock.Any())
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(failed).To(BeFalse())
+				ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}, ECT0: 3}
+				Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(failed).To(BeTrue())
+			})
+
+			It("ignores CE marks after validation failed", func() {
+				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}, ECT0: 0, ECNCE: 0}
+				Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+				Expect(failed).To(BeTrue())
+				// no call to OnCongestionEvent
+				ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}, ECT0: 0, ECNCE: 2}
+				Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, time.Now())).To(Succeed())
+			})
+		})
+
 		It("only allows sending of ACKs when congestion limited", func() {
 			handler.bytesInFlight = 100
 			cong.EXPECT().GetCongestionWindow().Return(protocol.ByteCount(200))
@@ -565,35 +806,56 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.SendMode()).To(Equal(SendPTO))
 		})
 
-		It("gets the pacing delay", func() {
-			sendTime := time.Now().Add(-time.Minute)
-			handler.bytesInFlight = 100
-			cong.EXPECT().OnPacketSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
-			cong.EXPECT().TimeUntilSend(protocol.ByteCount(100)).Return(time.Hour)
-			handler.SentPacket(&Packet{PacketNumber: 1, SendTime: sendTime})
-			Expect(handler.TimeUntilSend()).To(Equal(sendTime.Add(time.Hour)))
+		It("paces packets, after sending a burst", func() {
+			now := time.Now()
+			// 10 ms for a packet of 1460 bytes
+			cong.EXPECT().TimeUntilSend(gomock.Any()).Return(10 * time.Millisecond).AnyTimes()
+			cong.EXPECT().OnPacketSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+			cong.EXPECT().GetCongestionWindow().Return(protocol.MaxByteCount).AnyTimes()
+			Expect(handler.ShouldSendNumPackets()).To(Equal(protocol.MaxPacingBurstPackets))
+			for i := 1; i <= protocol.MaxPacingBurstPackets; i++ {
+				Expect(handler.TimeUntilSend()).To(BeZero())
+				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: protocol.PacketNumber(i), Length: protocol.MaxPacketSizeIPv4, SendTime: now}))
+			}
+			Expect(handler.TimeUntilSend()).To(BeTemporally("~", now.Add(10*time.Millisecond*protocol.MaxPacketSizeIPv4/1460), time.Millisecond))
 		})
 
-		It("allows sending of all RTO probe packets", func() {
-			handler.numProbesToSend = 5
-			Expect(handler.ShouldSendNumPackets()).To(Equal(5))
+		It("doesn't pace ACK-only and handshake packets", func() {
+			now := time.Now()
+			cong.EXPECT().TimeUntilSend(gomock.Any()).Return(10 * time.Millisecond).AnyTimes()
+			cong.EXPECT().OnPacketSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+			for i := 1; i <= 2*protocol.MaxPacingBurstPackets; i++ {
+				handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: protocol.PacketNumber(i), Length: protocol.MaxPacketSizeIPv4, SendTime: now}))
+			}
+			for i := 2*protocol.MaxPacingBurstPackets + 1; i <= 4*protocol.MaxPacingBurstPackets; i++ {
+				handler.SentPacket(cryptoPacket(&Packet{PacketNumber: protocol.PacketNumber(i), Length: protocol.MaxPacketSizeIPv4, SendTime: now}))
+			}
+			Expect(handler.TimeUntilSend()).To(BeZero())
 		})
 
-		It("allows sending of one packet, if it should be sent immediately", func() {
-			cong.EXPECT().TimeUntilSend(gomock.Any()).Return(time.Duration(0))
-			Expect(handler.ShouldSendNumPackets()).To(Equal(1))
+		It("doesn't pace packets if the congestion controller doesn't set a pacing rate", func() {
+			cong.EXPECT().TimeUntilSend(gomock.Any()).Return(time.Duration(0)).AnyTimes()
+			cong.EXPECT().OnPacketSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+			for i := 1; i <= 2*protocol.MaxPacingBurstPackets; i++ {
+				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: protocol.PacketNumber(i), Length: protocol.MaxPacketSizeIPv4}))
+			}
+			Expect(handler.TimeUntilSend()).To(BeZero())
+			Expect(handler.ShouldSendNumPackets()).To(Equal(protocol.MaxPacingBurstPackets))
 		})
 
-		It("allows sending of multiple packets, if the pacing delay is smaller than the minimum", func() {
-			pacingDelay := protocol.MinPacingDelay / 10
-			cong.EXPECT().TimeUntilSend(gomock.Any()).Return(pacingDelay)
-			Expect(handler.ShouldSendNumPackets()).To(Equal(10))
+		It("allows sending of all RTO probe packets", func() {
+			handler.numProbesToSend = 5
+			Expect(handler.ShouldSendNumPackets()).To(Equal(5))
 		})
 
-		It("allows sending of multiple packets, if the pacing delay is smaller than the minimum, and not a fraction", func() {
-			pacingDelay := protocol.MinPacingDelay * 2 / 5
-			cong.EXPECT().TimeUntilSend(gomock.Any()).Return(pacingDelay)
-			Expect(handler.ShouldSendNumPackets()).To(Equal(3))
+		It("allows sending of one packet, if the budget is used up", func() {
+			now := time.Now()
+			cong.EXPECT().TimeUntilSend(gomock.Any()).Return(time.Hour).AnyTimes()
+			cong.EXPECT().OnPacketSent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+			for i := 1; i <= protocol.MaxPacingBurstPackets; i++ {
+				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: protocol.PacketNumber(i), Length: protocol.MaxPacketSizeIPv4, SendTime: now}))
+			}
+			Expect(handler.ShouldSendNumPackets()).To(Equal(1))
 		})
 	})
 
@@ -620,6 +882,12 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.computePTOTimeout()).To(Equal(time.Duration(2+4) * time.Second))
 		})
 
+		It("includes the max_ack_delay", func() {
+			updateRTT(2 * time.Second)
+			handler.rttStats.SetMaxAckDelay(25 * time.Millisecond)
+			Expect(handler.computePTOTimeout()).To(Equal(6*time.Second + 25*time.Millisecond))
+		})
+
 		It("uses the granularity for short RTTs", func() {
 			rtt := time.Microsecond
 			updateRTT(rtt)
@@ -748,6 +1016,42 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		It("retransmits a lost STREAM frame with a FIN in a probe packet", func() {
+			finFrame := &wire.StreamFrame{StreamID: 5, Offset: 2, Data: []byte("foobar"), FinBit: true}
+			streamPacket := func(p *Packet, f *wire.StreamFrame) *Packet {
+				p = retransmittablePacket(p)
+				p.Frames = []wire.Frame{f}
+				return p
+			}
+			now := time.Now()
+			updateRTT(time.Second)
+			handler.SentPacket(streamPacket(&Packet{PacketNumber: 1, SendTime: now}, &streamFrame))
+			handler.SentPacket(streamPacket(&Packet{PacketNumber: 2, SendTime: now}, finFrame))
+			streamFrameHandler.EXPECT().OnStreamFrameAcked(&streamFrame)
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(time.Second))).To(Succeed())
+			// packet 2 is the last packet, so it can't be detected as lost by the ACK
+			Expect(handler.lossTime).To(BeZero())
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			Expect(handler.GetAlarmTimeout()).To(Equal(now.Add(handler.computePTOTimeout())))
+			Expect(handler.OnAlarm()).To(Succeed())
+			Expect(handler.SendMode()).To(Equal(SendPTO))
+			// the STREAM frame is handed back to the stream, which sends it in the probe packet
+			streamFrameHandler.EXPECT().OnStreamFrameLost(finFrame)
+			p, err := handler.DequeueProbePacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+			handler.SentPacket(streamPacket(&Packet{PacketNumber: 3, SendTime: now.Add(3 * time.Second)}, finFrame))
+			// the backoff is reset when the probe packet is acknowledged
+			Expect(handler.ptoCount).To(BeEquivalentTo(1))
+			streamFrameHandler.EXPECT().OnStreamFrameAcked(finFrame)
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 3}, {Smallest: 1, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now.Add(4*time.Second))).To(Succeed())
+			Expect(handler.ptoCount).To(BeZero())
+			Expect(handler.HasOutstandingData()).To(BeFalse())
+			Expect(handler.GetAlarmTimeout()).To(BeZero())
+		})
+
 		It("handles ACKs for the original packet", func() {
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 5, SendTime: time.Now().Add(-time.Hour)}))
 			handler.rttStats.UpdateRTT(time.Second, 0, time.Now())
@@ -797,6 +1101,387 @@ var _ = Describe("SentPacketHandler", func() {
 			// make sure this is not an RTO: only packet 1 is retransmissted
 			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
 		})
+		It("increases the reordering window when a spurious loss is detected", func() {
+			now := time.Now()
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, now)
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-20 * time.Millisecond)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(100*time.Millisecond))).To(Succeed())
+			Expect(handler.rttStats.SmoothedRTT()).To(Equal(100 * time.Millisecond))
+			// packet 1 was sent 120ms ago, which is more than 9/8 RTT
+			Expect(handler.DequeuePacketForRetransmission().PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			// packet 1 arrives after all, 140ms after it was sent
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now.Add(120*time.Millisecond))).To(Succeed())
+			// a reordering window of 1/2 RTT would have been sufficient
+			Expect(handler.reorderingShift).To(BeEquivalentTo(1))
+
+			now = now.Add(time.Second)
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3, SendTime: now.Add(-20 * time.Millisecond)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 4, SendTime: now}))
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 4, Largest: 4}}}
+			Expect(handler.ReceivedAck(ack, 3, protocol.Encryption1RTT, now.Add(100*time.Millisecond))).To(Succeed())
+			// packet 3 is not lost yet, it will be declared lost 3/2 RTT after it was sent
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			Expect(handler.lossTime.Sub(getPacket(3).SendTime)).To(Equal(150 * time.Millisecond))
+		})
+
+		It("doesn't increase the reordering window beyond 1 RTT", func() {
+			now := time.Now()
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, now)
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-20 * time.Millisecond)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(100*time.Millisecond))).To(Succeed())
+			Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now.Add(time.Second))).To(Succeed())
+			Expect(handler.reorderingShift).To(BeZero())
+		})
+	})
+
+	Context("tracing", func() {
+		var tracer *mocklogging.MockConnectionTracer
+
+		BeforeEach(func() {
+			tracer = mocklogging.NewMockConnectionTracer(mockCtrl)
+			handler.tracer = tracer
+		})
+
+		It("traces RTT updates, lost packets and congestion window changes", func() {
+			now := time.Now()
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-time.Hour)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now.Add(-time.Second)}))
+			cwnd := handler.congestion.GetCongestionWindow()
+			gomock.InOrder(
+				tracer.EXPECT().UpdatedRTT(gomock.Any()).Do(func(rtt logging.RTTSnapshot) {
+					Expect(rtt.LatestRTT).To(Equal(time.Second))
+					Expect(rtt.SmoothedRTT).To(Equal(time.Second))
+					Expect(rtt.MinRTT).To(Equal(time.Second))
+				}),
+				tracer.EXPECT().LostPacket(protocol.Encryption1RTT, protocol.PacketNumber(1), logging.PacketLossTimeThreshold),
+				tracer.EXPECT().UpdatedCongestionWindow(gomock.Any(), protocol.ByteCount(0)).Do(func(c, _ protocol.ByteCount) {
+					Expect(c).To(BeNumerically("<", cwnd))
+				}),
+			)
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+		})
+
+		It("doesn't trace the congestion window if it didn't change", func() {
+			now := time.Now()
+			handler.tracedCongestionWindow = handler.congestion.GetCongestionWindow()
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-time.Second)}))
+			tracer.EXPECT().UpdatedRTT(gomock.Any())
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+		})
+	})
+
+	Context("persistent congestion", func() {
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Now()
+			// After the next RTT sample of 100ms, the PTO is 100ms + 4 * 37.5ms = 250ms.
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, now)
+		})
+
+		sendPacket := func(pn protocol.PacketNumber, sendTime time.Time) {
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: pn, SendTime: sendTime, Length: protocol.DefaultTCPMSS}))
+		}
+
+		It("detects persistent congestion", func() {
+			Expect(handler.congestion.GetCongestionWindow()).To(Equal(protocol.InitialCongestionWindow))
+			sendPacket(1, now)
+			sendPacket(2, now.Add(500*time.Millisecond))
+			sendPacket(3, now.Add(time.Second))
+			sendPacket(4, now.Add(1100*time.Millisecond))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 4, Largest: 4}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(1200*time.Millisecond))).To(Succeed())
+			// packets 1 to 3 were lost, and they were sent over a period longer than 3 PTOs
+			Expect(handler.GetStats().PacketsLost).To(BeEquivalentTo(3))
+			Expect(handler.GetStats().PersistentCongestions).To(BeEquivalentTo(1))
+			Expect(handler.congestion.GetCongestionWindow()).To(Equal(protocol.DefaultMinCongestionWindow))
+			Expect(handler.rttStats.MinRTT()).To(BeZero())
+		})
+
+		It("doesn't detect persistent congestion if the lost packets were sent over a shorter period", func() {
+			sendPacket(1, now)
+			sendPacket(2, now.Add(500*time.Millisecond))
+			sendPacket(3, now.Add(700*time.Millisecond))
+			sendPacket(4, now.Add(1100*time.Millisecond))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 4, Largest: 4}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(1200*time.Millisecond))).To(Succeed())
+			Expect(handler.GetStats().PacketsLost).To(BeEquivalentTo(3))
+			Expect(handler.GetStats().PersistentCongestions).To(BeZero())
+			Expect(handler.congestion.GetCongestionWindow()).To(BeNumerically(">", protocol.DefaultMinCongestionWindow))
+		})
+
+		It("doesn't detect persistent congestion if a packet sent in between was acknowledged", func() {
+			sendPacket(1, now)
+			sendPacket(2, now.Add(10*time.Millisecond))
+			sendPacket(3, now.Add(time.Second))
+			sendPacket(4, now.Add(1100*time.Millisecond))
+			// packet 2 is acknowledged, but packet 1 isn't declared lost yet
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(110*time.Millisecond))).To(Succeed())
+			Expect(handler.GetStats().PacketsLost).To(BeZero())
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 4, Largest: 4}, {Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now.Add(1200*time.Millisecond))).To(Succeed())
+			Expect(handler.GetStats().PacketsLost).To(BeEquivalentTo(2))
+			Expect(handler.GetStats().PersistentCongestions).To(BeZero())
+		})
+
+		It("detects persistent congestion in a period that starts after an acknowledged packet", func() {
+			sendPacket(1, now)
+			sendPacket(2, now.Add(10*time.Millisecond))
+			sendPacket(3, now.Add(20*time.Millisecond))
+			sendPacket(4, now.Add(time.Second))
+			sendPacket(5, now.Add(1100*time.Millisecond))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(110*time.Millisecond))).To(Succeed())
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 5, Largest: 5}, {Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now.Add(1200*time.Millisecond))).To(Succeed())
+			// packets 3 and 4 were sent over a period longer than 3 PTOs, without any packet acknowledged in between
+			Expect(handler.GetStats().PacketsLost).To(BeEquivalentTo(3))
+			Expect(handler.GetStats().PersistentCongestions).To(BeEquivalentTo(1))
+		})
+
+		It("keeps the send times of acknowledged packets sorted", func() {
+			for _, d := range []time.Duration{1, 3, 2, 5, 0, 4} {
+				handler.addAckedSendTime(now.Add(d * time.Second))
+			}
+			Expect(handler.ackedSendTimes).To(HaveLen(6))
+			for i, t := range handler.ackedSendTimes {
+				Expect(t).To(Equal(now.Add(time.Duration(i) * time.Second)))
+			}
+		})
+
+		It("only uses packets sent after the persistent congestion for RTT samples", func() {
+			sendPacket(1, now)
+			sendPacket(2, now.Add(time.Second))
+			sendPacket(3, now.Add(1100*time.Millisecond))
+			// sent before the persistent congestion is detected
+			sendPacket(4, now.Add(1150*time.Millisecond))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 3}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now.Add(1200*time.Millisecond))).To(Succeed())
+			Expect(handler.GetStats().PersistentCongestions).To(BeEquivalentTo(1))
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 4}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now.Add(2*time.Second))).To(Succeed())
+			Expect(handler.rttStats.LatestRTT()).To(Equal(100 * time.Millisecond))
+			Expect(handler.rttStats.MinRTT()).To(BeZero())
+			sendPacket(5, now.Add(2*time.Second))
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 5}}}
+			Expect(handler.ReceivedAck(ack, 3, protocol.Encryption1RTT, now.Add(2050*time.Millisecond))).To(Succeed())
+			Expect(handler.rttStats.LatestRTT()).To(Equal(50 * time.Millisecond))
+			Expect(handler.rttStats.MinRTT()).To(Equal(50 * time.Millisecond))
+		})
+	})
+
+	Context("statistics", func() {
+		It("counts sent and lost packets", func() {
+			now := time.Now()
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, Length: 100, SendTime: now.Add(-time.Hour)}))
+			handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: 2}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3, Length: 200, SendTime: now.Add(-time.Second)}))
+			stats := handler.GetStats()
+			Expect(stats.PacketsSent).To(BeEquivalentTo(3))
+			Expect(stats.PacketsLost).To(BeZero())
+			Expect(stats.BytesInFlight).To(Equal(protocol.ByteCount(300)))
+			Expect(stats.CongestionWindow).To(Equal(handler.congestion.GetCongestionWindow()))
+			// ACK packet 3, which causes packet 1 to be declared lost
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 3}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+			stats = handler.GetStats()
+			Expect(stats.PacketsLost).To(BeEquivalentTo(1))
+			Expect(stats.BytesRetransmitted).To(Equal(protocol.ByteCount(100)))
+			Expect(stats.BytesInFlight).To(BeZero())
+		})
+
+		It("counts spurious losses", func() {
+			now := time.Now()
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-time.Hour)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now.Add(-time.Hour)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3, SendTime: now.Add(-time.Second)}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 3}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+			Expect(handler.GetStats().PacketsLost).To(BeEquivalentTo(2))
+			Expect(handler.GetStats().SpuriousLosses).To(BeZero())
+			// now packet 2 arrives after all
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 3}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now)).To(Succeed())
+			Expect(handler.GetStats().SpuriousLosses).To(BeEquivalentTo(1))
+			// a spurious loss is only counted once
+			Expect(handler.ReceivedAck(ack, 3, protocol.Encryption1RTT, now)).To(Succeed())
+			stats := handler.GetStats()
+			Expect(stats.PacketsLost).To(BeEquivalentTo(2))
+			Expect(stats.SpuriousLosses).To(BeEquivalentTo(1))
+		})
+
+		It("only keeps track of a limited number of lost packets", func() {
+			now := time.Now()
+			for i := 1; i <= 2*protocol.MaxTrackedLostPackets; i++ {
+				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: protocol.PacketNumber(i), SendTime: now.Add(-time.Hour)}))
+			}
+			largest := protocol.PacketNumber(2*protocol.MaxTrackedLostPackets + 1)
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: largest, SendTime: now.Add(-time.Second)}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: largest, Largest: largest}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+			Expect(handler.GetStats().PacketsLost).To(BeEquivalentTo(2 * protocol.MaxTrackedLostPackets))
+			Expect(handler.recentlyLostPackets).To(HaveLen(protocol.MaxTrackedLostPackets))
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: largest}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.Encryption1RTT, now)).To(Succeed())
+			Expect(handler.GetStats().SpuriousLosses).To(BeEquivalentTo(protocol.MaxTrackedLostPackets))
+		})
+
+		It("counts PTOs", func() {
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: time.Now().Add(-time.Hour)}))
+			Expect(handler.OnAlarm()).To(Succeed())
+			Expect(handler.GetStats().PTOs).To(BeEquivalentTo(1))
+			Expect(handler.OnAlarm()).To(Succeed())
+			Expect(handler.GetStats().PTOs).To(BeEquivalentTo(2))
+			// receiving an ACK resets the PTO count, but not the counter
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, time.Now())).To(Succeed())
+			Expect(handler.ptoCount).To(BeZero())
+			Expect(handler.GetStats().PTOs).To(BeEquivalentTo(2))
+		})
+
+		It("reports the pacing rate", func() {
+			Expect(handler.GetStats().PacingRate).To(BeZero())
+			handler.rttStats.UpdateRTT(100*time.Millisecond, 0, time.Now())
+			// in slow start, Cubic paces at twice the congestion window per RTT
+			cwnd := handler.congestion.GetCongestionWindow()
+			Expect(handler.GetStats().PacingRate).To(BeNumerically("~", congestion.BandwidthFromDelta(2*cwnd, 100*time.Millisecond), 1000))
+		})
+	})
+
+	Context("DATAGRAM frames", func() {
+		datagramPacket := func(p *Packet) *Packet {
+			p = retransmittablePacket(p)
+			p.Frames = []wire.Frame{&wire.DatagramFrame{Data: []byte("foobar")}}
+			return p
+		}
+
+		It("counts packets containing DATAGRAM frames towards the bytes in flight", func() {
+			handler.SentPacket(datagramPacket(&Packet{PacketNumber: 1, Length: 42}))
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(42)))
+			Expect(handler.HasOutstandingData()).To(BeTrue())
+		})
+
+		It("doesn't retransmit DATAGRAM frames", func() {
+			now := time.Now()
+			handler.SentPacket(datagramPacket(&Packet{PacketNumber: 1, SendTime: now.Add(-time.Hour)}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now.Add(-time.Hour)}))
+			getPacket(2).Frames = append(getPacket(2).Frames, &wire.DatagramFrame{})
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3, SendTime: now.Add(-time.Second)}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 3}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.Encryption1RTT, now)).To(Succeed())
+			Expect(handler.bytesInFlight).To(BeZero())
+			p := handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(p.Frames).To(Equal([]wire.Frame{&wire.PingFrame{}}))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+		})
+
+		It("doesn't return a probe packet, if the packet only contained DATAGRAM frames", func() {
+			handler.SentPacket(datagramPacket(&Packet{PacketNumber: 1}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2}))
+			p, err := handler.DequeueProbePacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+			p, err = handler.DequeueProbePacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(2)))
+		})
+	})
+
+	Context("connection migration", func() {
+		It("queues all outstanding packets for retransmission", func() {
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, Length: 100}))
+			handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: 2}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3, Length: 200}))
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(300)))
+			Expect(handler.OnConnectionMigration()).To(Succeed())
+			Expect(handler.bytesInFlight).To(BeZero())
+			expectInPacketHistory([]protocol.PacketNumber{})
+			Expect(handler.GetAlarmTimeout()).To(BeZero())
+			p := handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			p = handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(3)))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+		})
+
+		It("doesn't retransmit PATH_CHALLENGE frames", func() {
+			p := retransmittablePacket(&Packet{PacketNumber: 1})
+			p.Frames = []wire.Frame{&wire.PathChallengeFrame{}}
+			handler.SentPacket(p)
+			Expect(handler.bytesInFlight).ToNot(BeZero())
+			Expect(handler.OnConnectionMigration()).To(Succeed())
+			Expect(handler.bytesInFlight).To(BeZero())
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+		})
+
+		It("resets the RTT estimate and the congestion controller", func() {
+			updateRTT(time.Second)
+			cong := mocks.NewMockSendAlgorithm(mockCtrl)
+			handler.congestion = cong
+			cong.EXPECT().OnConnectionMigration()
+			Expect(handler.OnConnectionMigration()).To(Succeed())
+			Expect(handler.rttStats.SmoothedRTT()).To(BeZero())
+			Expect(handler.rttStats.MinRTT()).To(BeZero())
+		})
+	})
+
+	Context("amplification limit", func() {
+		BeforeEach(func() {
+			handler.handshakeComplete = false
+			handler.peerAddressValidated = false
+		})
+
+		It("stops sending when it would send more than 3x the bytes received", func() {
+			handler.ReceivedBytes(1200)
+			Expect(handler.SendMode()).To(Equal(SendAny))
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 1, Length: 1200, SendTime: time.Now()}))
+			Expect(handler.SendMode()).To(Equal(SendAny))
+			// sending another full-size packet would exceed 3600 bytes
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 2, Length: 1200, SendTime: time.Now()}))
+			Expect(handler.SendMode()).To(Equal(SendNone))
+			handler.ReceivedBytes(1200)
+			Expect(handler.SendMode()).To(Equal(SendAny))
+		})
+
+		It("counts packets that are not retransmittable", func() {
+			handler.ReceivedBytes(1000)
+			handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: 1, Length: 2000}))
+			Expect(handler.SendMode()).To(Equal(SendNone))
+		})
+
+		It("doesn't set an alarm while limited", func() {
+			handler.ReceivedBytes(1200)
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 1, Length: 2500, SendTime: time.Now()}))
+			Expect(handler.GetAlarmTimeout()).To(BeZero())
+			// receiving more bytes sets the alarm again
+			handler.ReceivedBytes(1200)
+			Expect(handler.GetAlarmTimeout()).ToNot(BeZero())
+		})
+
+		It("stops limiting once the peer's address is validated", func() {
+			handler.ReceivedBytes(100)
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 1, Length: 1200, SendTime: time.Now()}))
+			Expect(handler.SendMode()).To(Equal(SendNone))
+			Expect(handler.GetAlarmTimeout()).To(BeZero())
+			handler.SetPeerAddressValidated()
+			Expect(handler.SendMode()).To(Equal(SendAny))
+			Expect(handler.GetAlarmTimeout()).ToNot(BeZero())
+		})
 	})
 
 	Context("crypto packets", func() {
@@ -830,7 +1515,56 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.cryptoCount).To(BeEquivalentTo(1))
 			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 4, SendTime: lastCryptoPacketSendTime}))
 			// make sure the exponential backoff is used
-			Expect(handler.GetAlarmTimeout().Sub(lastCryptoPacketSendTime)).To(Equal(4 * time.Minute))
+			Expect(handler.getCryptoAlarm().Sub(lastCryptoPacketSendTime)).To(Equal(4 * time.Minute))
+			// the PTO for the forward-secure packet 2 fires first
+			Expect(handler.GetAlarmTimeout()).To(Equal(sendTime.Add(handler.computePTOTimeout())))
+		})
+
+		It("retransmits a lost final handshake flight, while 1-RTT packets are outstanding", func() {
+			now := time.Now()
+			updateRTT(time.Second)
+			// the client's final flight
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 1, SendTime: now}))
+			// 1-RTT data sent after the final flight
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now}))
+			Expect(handler.GetAlarmTimeout()).To(Equal(now.Add(2 * time.Second)))
+			Expect(handler.OnAlarm()).To(Succeed())
+			// only the crypto packet is retransmitted
+			Expect(handler.cryptoCount).To(BeEquivalentTo(1))
+			Expect(handler.ptoCount).To(BeZero())
+			p := handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			Expect(handler.SendMode()).ToNot(Equal(SendPTO))
+			handler.SentPacketsAsRetransmission([]*Packet{cryptoPacket(&Packet{PacketNumber: 3, SendTime: now.Add(2 * time.Second)})}, 1)
+			// the retransmission is lost as well
+			Expect(handler.getCryptoAlarm()).To(Equal(now.Add(6 * time.Second)))
+			// the PTO for the 1-RTT packet is armed independently
+			Expect(handler.GetAlarmTimeout()).To(Equal(now.Add(handler.computePTOTimeout())))
+			Expect(handler.OnAlarm()).To(Succeed())
+			Expect(handler.ptoCount).To(BeEquivalentTo(1))
+			Expect(handler.cryptoCount).To(BeEquivalentTo(1))
+			Expect(handler.SendMode()).To(Equal(SendPTO))
+			// the server receives the final flight and completes the handshake
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 3}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.EncryptionHandshake, now.Add(3*time.Second))).To(Succeed())
+			Expect(handler.cryptoCount).To(BeZero())
+			Expect(handler.ptoCount).To(BeZero())
+			// the 1-RTT packet was sent more than 1 RTT before the retransmission of the final flight
+			p = handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(2)))
+			handler.SetHandshakeComplete()
+			Expect(handler.getCryptoAlarm()).To(BeZero())
+			Expect(handler.GetAlarmTimeout()).To(BeZero())
+		})
+
+		It("doesn't arm the PTO if only crypto packets are outstanding", func() {
+			now := time.Now()
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 1, SendTime: now}))
+			Expect(handler.getLossAlarm()).To(BeZero())
+			Expect(handler.GetAlarmTimeout()).To(Equal(now.Add(handler.computeCryptoTimeout())))
 		})
 
 		// TODO(#1534): also check the encryption level for IETF QUIC
@@ -846,13 +1580,56 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(err).To(MatchError("Received ACK with encryption level encrypted (not forward-secure) that acks a packet 13 (encryption level forward-secure)"))
 		})
 
+		It("keeps the crypto alarm armed until the handshake completes, for the client", func() {
+			handler.perspective = protocol.PerspectiveClient
+			now := time.Now()
+			updateRTT(time.Second)
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 1, SendTime: now}))
+			// the server's Initial acknowledges the client's Initial
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.EncryptionInitial, now.Add(time.Second))).To(Succeed())
+			Expect(handler.packetHistory.HasOutstandingCryptoPackets()).To(BeFalse())
+			Expect(handler.GetAlarmTimeout()).To(Equal(now.Add(handler.computeCryptoTimeout())))
+			Expect(handler.OnAlarm()).To(Succeed())
+			Expect(handler.cryptoCount).To(BeEquivalentTo(1))
+			Expect(handler.SendMode()).To(Equal(SendPTO))
+			// there's no packet to retransmit, the session sends a PING
+			p, err := handler.DequeueProbePacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+			ping := &Packet{
+				PacketNumber:    2,
+				EncryptionLevel: protocol.EncryptionHandshake,
+				Frames:          []wire.Frame{&wire.PingFrame{}},
+				Length:          1,
+				SendTime:        now.Add(2 * time.Second),
+			}
+			handler.SentPacket(ping)
+			Expect(handler.SendMode()).ToNot(Equal(SendPTO))
+			// exponential backoff
+			Expect(handler.GetAlarmTimeout()).To(Equal(now.Add(6 * time.Second)))
+			ack = &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			Expect(handler.ReceivedAck(ack, 2, protocol.EncryptionHandshake, now.Add(3*time.Second))).To(Succeed())
+			Expect(handler.GetAlarmTimeout()).ToNot(BeZero())
+			handler.SetHandshakeComplete()
+			Expect(handler.GetAlarmTimeout()).To(BeZero())
+		})
+
+		It("doesn't arm the crypto alarm without outstanding crypto packets, for the server", func() {
+			now := time.Now()
+			handler.SentPacket(cryptoPacket(&Packet{PacketNumber: 1, SendTime: now}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 1, protocol.EncryptionInitial, now.Add(time.Second))).To(Succeed())
+			Expect(handler.GetAlarmTimeout()).To(BeZero())
+		})
+
 		It("deletes crypto packets when the handshake completes", func() {
 			for i := protocol.PacketNumber(1); i <= 6; i++ {
 				p := retransmittablePacket(&Packet{PacketNumber: i})
 				p.EncryptionLevel = protocol.EncryptionHandshake
 				handler.SentPacket(p)
 			}
-			handler.queuePacketForRetransmission(getPacket(1))
+			Expect(handler.queuePacketForRetransmission(getPacket(1))).To(Succeed())
 			handler.queuePacketForRetransmission(getPacket(3))
 			handler.SetHandshakeComplete()
 			Expect(handler.packetHistory.Len()).To(BeZero())
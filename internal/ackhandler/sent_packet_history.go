@@ -166,3 +166,7 @@ func (h *sentPacketHistory) HasOutstandingPackets() bool {
 func (h *sentPacketHistory) HasOutstandingCryptoPackets() bool {
 	return h.numOutstandingCryptoPackets > 0
 }
+
+func (h *sentPacketHistory) HasOutstanding1RTTPackets() bool {
+	return h.numOutstandingPackets > h.numOutstandingCryptoPackets
+}
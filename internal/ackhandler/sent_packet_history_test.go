@@ -219,6 +219,23 @@ var _ = Describe("SentPacketHistory", func() {
 			Expect(hist.HasOutstandingPackets()).To(BeTrue())
 		})
 
+		It("says if it has outstanding 1-RTT packets", func() {
+			hist.SentPacket(&Packet{
+				PacketNumber:       1,
+				EncryptionLevel:    protocol.EncryptionHandshake,
+				canBeRetransmitted: true,
+			})
+			Expect(hist.HasOutstanding1RTTPackets()).To(BeFalse())
+			hist.SentPacket(&Packet{
+				PacketNumber:       2,
+				EncryptionLevel:    protocol.Encryption1RTT,
+				canBeRetransmitted: true,
+			})
+			Expect(hist.HasOutstanding1RTTPackets()).To(BeTrue())
+			Expect(hist.Remove(2)).To(Succeed())
+			Expect(hist.HasOutstanding1RTTPackets()).To(BeFalse())
+		})
+
 		It("doesn't consider non-retransmittable packets as outstanding", func() {
 			hist.SentPacket(&Packet{
 				EncryptionLevel: protocol.EncryptionInitial,
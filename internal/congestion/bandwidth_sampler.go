@@ -0,0 +1,103 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+)
+
+// The sendState is the state of the connection at the time a packet was sent.
+type sendState struct {
+	sentTime time.Time
+	size     protocol.ByteCount
+	// the number of bytes delivered when the packet was sent
+	delivered     protocol.ByteCount
+	deliveredTime time.Time
+	// the send time of the most recently acknowledged packet when the packet was sent
+	firstSentTime time.Time
+}
+
+// A bandwidthSample is a delivery rate sample, taken when a packet is acknowledged.
+type bandwidthSample struct {
+	bandwidth Bandwidth
+	rtt       time.Duration
+	// the number of bytes delivered when the acknowledged packet was sent
+	priorDelivered protocol.ByteCount
+}
+
+// The bandwidthSampler estimates the delivery rate of a connection.
+// The delivery rate is the number of bytes acknowledged between sending a packet and receiving the acknowledgement for it,
+// divided by the duration of this interval.
+// To avoid overestimating the bandwidth due to ACK compression, the interval is the maximum of the send and the ACK interval.
+type bandwidthSampler struct {
+	packets map[protocol.PacketNumber]*sendState
+
+	// the total number of bytes acknowledged
+	delivered protocol.ByteCount
+	// the time when delivered was last updated
+	deliveredTime time.Time
+	// the send time of the most recently acknowledged packet
+	firstSentTime time.Time
+}
+
+func newBandwidthSampler() *bandwidthSampler {
+	return &bandwidthSampler{packets: make(map[protocol.PacketNumber]*sendState)}
+}
+
+// OnPacketSent is called when a retransmittable packet is sent.
+// priorInFlight is the number of bytes in flight before the packet was sent.
+func (s *bandwidthSampler) OnPacketSent(sentTime time.Time, packetNumber protocol.PacketNumber, bytes, priorInFlight protocol.ByteCount) {
+	// When starting to send after an idle period, there's no meaningful send interval yet.
+	if priorInFlight == 0 {
+		s.firstSentTime = sentTime
+		s.deliveredTime = sentTime
+		// All packets sent before were acknowledged, lost or discarded.
+		// Make sure that we don't keep any of them around.
+		if len(s.packets) > 0 {
+			s.packets = make(map[protocol.PacketNumber]*sendState)
+		}
+	}
+	s.packets[packetNumber] = &sendState{
+		sentTime:      sentTime,
+		size:          bytes,
+		delivered:     s.delivered,
+		deliveredTime: s.deliveredTime,
+		firstSentTime: s.firstSentTime,
+	}
+}
+
+// OnPacketAcked is called when a packet is acknowledged.
+// It returns false if the packet wasn't tracked.
+func (s *bandwidthSampler) OnPacketAcked(packetNumber protocol.PacketNumber, ackTime time.Time) (bandwidthSample, bool) {
+	p, ok := s.packets[packetNumber]
+	if !ok {
+		return bandwidthSample{}, false
+	}
+	delete(s.packets, packetNumber)
+	s.delivered += p.size
+	s.deliveredTime = ackTime
+	s.firstSentTime = p.sentTime
+
+	sample := bandwidthSample{
+		rtt:            ackTime.Sub(p.sentTime),
+		priorDelivered: p.delivered,
+	}
+	interval := p.sentTime.Sub(p.firstSentTime)
+	if ackInterval := ackTime.Sub(p.deliveredTime); ackInterval > interval {
+		interval = ackInterval
+	}
+	if interval > 0 {
+		sample.bandwidth = BandwidthFromDelta(s.delivered-p.delivered, interval)
+	}
+	return sample, true
+}
+
+// RemovePacket stops tracking a packet that was declared lost or discarded.
+func (s *bandwidthSampler) RemovePacket(packetNumber protocol.PacketNumber) {
+	delete(s.packets, packetNumber)
+}
+
+// Delivered returns the total number of bytes acknowledged.
+func (s *bandwidthSampler) Delivered() protocol.ByteCount {
+	return s.delivered
+}
@@ -0,0 +1,84 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bandwidth Sampler", func() {
+	var (
+		s   *bandwidthSampler
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		s = newBandwidthSampler()
+		now = time.Now()
+	})
+
+	It("samples the delivery rate", func() {
+		// send 10 packets, one every millisecond, and receive the ACKs 20ms later
+		var inFlight protocol.ByteCount
+		for i := 1; i <= 10; i++ {
+			s.OnPacketSent(now.Add(time.Duration(i)*time.Millisecond), protocol.PacketNumber(i), 1000, inFlight)
+			inFlight += 1000
+		}
+		var sample bandwidthSample
+		for i := 1; i <= 10; i++ {
+			var ok bool
+			sample, ok = s.OnPacketAcked(protocol.PacketNumber(i), now.Add(time.Duration(20+i)*time.Millisecond))
+			Expect(ok).To(BeTrue())
+			Expect(sample.rtt).To(Equal(20 * time.Millisecond))
+		}
+		Expect(s.Delivered()).To(Equal(protocol.ByteCount(10000)))
+		Expect(sample.priorDelivered).To(BeZero())
+		// 10 packets were delivered within 20ms + 9ms
+		Expect(sample.bandwidth).To(Equal(BandwidthFromDelta(10000, 29*time.Millisecond)))
+	})
+
+	It("uses the longer send interval to avoid overestimating the bandwidth due to ACK compression", func() {
+		s.OnPacketSent(now, 1, 1000, 0)
+		s.OnPacketSent(now.Add(10*time.Millisecond), 2, 1000, 1000)
+		_, ok := s.OnPacketAcked(1, now.Add(20*time.Millisecond))
+		Expect(ok).To(BeTrue())
+		s.OnPacketSent(now.Add(20*time.Millisecond), 3, 1000, 1000)
+		s.OnPacketSent(now.Add(40*time.Millisecond), 4, 1000, 2000)
+		// packet 3 and 4 are acknowledged at the same time
+		_, ok = s.OnPacketAcked(2, now.Add(41*time.Millisecond))
+		Expect(ok).To(BeTrue())
+		_, ok = s.OnPacketAcked(3, now.Add(42*time.Millisecond))
+		Expect(ok).To(BeTrue())
+		sample, ok := s.OnPacketAcked(4, now.Add(42*time.Millisecond))
+		Expect(ok).To(BeTrue())
+		// 3 packets (2, 3 and 4) were delivered while packet 4 was in flight,
+		// the send interval (from sending packet 1 to sending packet 4) is longer than the ACK interval
+		Expect(sample.bandwidth).To(Equal(BandwidthFromDelta(3000, 40*time.Millisecond)))
+	})
+
+	It("ignores ACKs for packets that weren't tracked", func() {
+		_, ok := s.OnPacketAcked(42, now)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("stops tracking lost packets", func() {
+		s.OnPacketSent(now, 1, 1000, 0)
+		s.RemovePacket(1)
+		_, ok := s.OnPacketAcked(1, now)
+		Expect(ok).To(BeFalse())
+		Expect(s.Delivered()).To(BeZero())
+		Expect(s.packets).To(BeEmpty())
+	})
+
+	It("stops tracking all packets when nothing is in flight", func() {
+		s.OnPacketSent(now, 1, 1000, 0)
+		s.OnPacketSent(now, 2, 1000, 1000)
+		// packets 1 and 2 were removed from bytes in flight, without being acknowledged or lost
+		s.OnPacketSent(now.Add(time.Second), 3, 1000, 0)
+		Expect(s.packets).To(HaveLen(1))
+		Expect(s.packets).To(HaveKey(protocol.PacketNumber(3)))
+	})
+})
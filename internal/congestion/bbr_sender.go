@@ -0,0 +1,389 @@
+package congestion
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+)
+
+// This is an implementation of BBR (version 1), as described in
+// https://tools.ietf.org/html/draft-cardwell-iccrg-bbr-congestion-control-00.
+// BBR builds a model of the path from the delivery rate and the round trip time,
+// and paces packets at the estimated bottleneck bandwidth.
+// In contrast to loss-based congestion controllers, it doesn't reduce the sending rate on random packet loss.
+
+const (
+	// The gain used in Startup and for the congestion window, 2/ln(2).
+	// It allows doubling the sending rate every round trip.
+	bbrHighGain = 2.885
+	// The gain used in Drain, draining the queue built in Startup within one round trip.
+	bbrDrainGain = 1 / bbrHighGain
+	// The congestion window gain used in ProbeBW.
+	bbrCongestionWindowGain = 2
+	// The number of round trips the maximum bandwidth filter covers.
+	bbrBandwidthWindow = 10
+	// Startup is exited if the bandwidth didn't grow by bbrStartupGrowthTarget for bbrStartupFullBandwidthRounds round trips.
+	bbrStartupGrowthTarget        = 1.25
+	bbrStartupFullBandwidthRounds = 3
+	// ProbeRTT is entered if the min RTT wasn't updated for this duration.
+	bbrMinRTTExpiry = 10 * time.Second
+	// The minimum duration spent in ProbeRTT.
+	bbrProbeRTTDuration = 200 * time.Millisecond
+	// The minimum congestion window, also used in ProbeRTT.
+	bbrMinCongestionWindow = 4 * protocol.DefaultTCPMSS
+)
+
+// In ProbeBW, the pacing gain cycles through these values, one phase per min RTT.
+// The first phase probes for more bandwidth, the second one drains the queue built in the first one.
+var bbrPacingGainCycle = [...]float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+type bbrMode uint8
+
+const (
+	bbrStartup bbrMode = iota
+	bbrDrain
+	bbrProbeBW
+	bbrProbeRTT
+)
+
+func (m bbrMode) String() string {
+	switch m {
+	case bbrStartup:
+		return "Startup"
+	case bbrDrain:
+		return "Drain"
+	case bbrProbeBW:
+		return "ProbeBW"
+	case bbrProbeRTT:
+		return "ProbeRTT"
+	default:
+		return "unknown mode"
+	}
+}
+
+type bbrSender struct {
+	rttStats     *RTTStats
+	sampler      *bandwidthSampler
+	maxBandwidth *windowedMaxFilter
+
+	mode                 bbrMode
+	pacingGain           float64
+	congestionWindowGain float64
+
+	// round trip counting
+	roundCount         uint64
+	nextRoundDelivered protocol.ByteCount
+	roundStart         bool
+
+	minRTT          time.Duration
+	minRTTTimestamp time.Time
+
+	// used to detect when Startup has filled the pipe
+	fullBandwidth        Bandwidth
+	fullBandwidthRounds  int
+	fullBandwidthReached bool
+
+	// ProbeBW
+	cycleIndex  int
+	cycleStart  time.Time
+	lostInCycle bool
+
+	// ProbeRTT
+	probeRTTDoneTime      time.Time
+	probeRTTRoundDone     bool
+	priorCongestionWindow protocol.ByteCount
+
+	bytesInFlight protocol.ByteCount
+
+	congestionWindow        protocol.ByteCount
+	initialCongestionWindow protocol.ByteCount
+	maxCongestionWindow     protocol.ByteCount
+	pacingRate              Bandwidth
+}
+
+var _ SendAlgorithm = &bbrSender{}
+
+// NewBBRSender makes a new BBR sender.
+func NewBBRSender(rttStats *RTTStats, initialCongestionWindow, maxCongestionWindow protocol.ByteCount) SendAlgorithm {
+	s := &bbrSender{
+		rttStats:                rttStats,
+		initialCongestionWindow: initialCongestionWindow,
+		maxCongestionWindow:     maxCongestionWindow,
+	}
+	s.reset()
+	return s
+}
+
+func (s *bbrSender) reset() {
+	s.sampler = newBandwidthSampler()
+	s.maxBandwidth = newWindowedMaxFilter(bbrBandwidthWindow)
+	s.roundCount = 0
+	s.nextRoundDelivered = 0
+	s.roundStart = false
+	s.minRTT = 0
+	s.minRTTTimestamp = time.Time{}
+	s.fullBandwidth = 0
+	s.fullBandwidthRounds = 0
+	s.fullBandwidthReached = false
+	s.probeRTTDoneTime = time.Time{}
+	s.bytesInFlight = 0
+	s.congestionWindow = s.initialCongestionWindow
+	s.enterStartup()
+	// Before the first bandwidth sample, pace the initial congestion window over one (smoothed or initial) RTT.
+	s.pacingRate = Bandwidth(bbrHighGain * float64(BandwidthFromDelta(s.initialCongestionWindow, s.rttStats.SmoothedOrInitialRTT())))
+}
+
+func (s *bbrSender) enterStartup() {
+	s.mode = bbrStartup
+	s.pacingGain = bbrHighGain
+	s.congestionWindowGain = bbrHighGain
+}
+
+func (s *bbrSender) enterProbeBW(now time.Time) {
+	s.mode = bbrProbeBW
+	s.congestionWindowGain = bbrCongestionWindowGain
+	// Start at a random phase, but never in the phase that drains the queue.
+	s.cycleIndex = rand.Intn(len(bbrPacingGainCycle) - 1)
+	if s.cycleIndex >= 1 {
+		s.cycleIndex++
+	}
+	s.cycleStart = now
+	s.lostInCycle = false
+	s.pacingGain = bbrPacingGainCycle[s.cycleIndex]
+}
+
+// TimeUntilSend returns the time it takes to send a packet of DefaultTCPMSS at the pacing rate.
+func (s *bbrSender) TimeUntilSend(protocol.ByteCount) time.Duration {
+	return time.Duration(uint64(protocol.DefaultTCPMSS) * uint64(BytesPerSecond) * uint64(time.Second) / uint64(s.pacingRate))
+}
+
+func (s *bbrSender) OnPacketSent(
+	sentTime time.Time,
+	bytesInFlight protocol.ByteCount,
+	packetNumber protocol.PacketNumber,
+	bytes protocol.ByteCount,
+	isRetransmittable bool,
+) {
+	if !isRetransmittable {
+		return
+	}
+	// bytesInFlight already includes this packet
+	s.sampler.OnPacketSent(sentTime, packetNumber, bytes, bytesInFlight-bytes)
+	s.bytesInFlight = bytesInFlight
+}
+
+func (s *bbrSender) GetCongestionWindow() protocol.ByteCount {
+	return s.congestionWindow
+}
+
+// MaybeExitSlowStart is a no-op. BBR exits Startup when the bandwidth stops growing.
+func (s *bbrSender) MaybeExitSlowStart() {}
+
+func (s *bbrSender) OnPacketAcked(
+	packetNumber protocol.PacketNumber,
+	ackedBytes protocol.ByteCount,
+	priorInFlight protocol.ByteCount,
+	eventTime time.Time,
+) {
+	s.reduceBytesInFlight(ackedBytes)
+	sample, ok := s.sampler.OnPacketAcked(packetNumber, eventTime)
+	if !ok {
+		return
+	}
+	s.updateRound(sample)
+	if sample.bandwidth > 0 {
+		s.maxBandwidth.Update(sample.bandwidth, s.roundCount)
+	}
+	minRTTExpired := s.updateMinRTT(sample.rtt, eventTime)
+
+	s.updateGainCycle(eventTime)
+	s.checkFullBandwidthReached()
+	s.checkDrain(eventTime)
+	s.updateProbeRTT(minRTTExpired, eventTime)
+
+	s.updatePacingRate()
+	s.updateCongestionWindow(ackedBytes)
+}
+
+// OnPacketLost is called when a packet is lost.
+// Random loss doesn't change the model of the path. It only ends the probing phase of the ProbeBW gain cycle.
+func (s *bbrSender) OnPacketLost(packetNumber protocol.PacketNumber, lostBytes protocol.ByteCount, _ protocol.ByteCount) {
+	s.reduceBytesInFlight(lostBytes)
+	s.sampler.RemovePacket(packetNumber)
+	s.lostInCycle = true
+}
+
+// OnPacketDiscarded stops tracking a packet that won't be acknowledged.
+func (s *bbrSender) OnPacketDiscarded(packetNumber protocol.PacketNumber, bytes protocol.ByteCount) {
+	s.reduceBytesInFlight(bytes)
+	s.sampler.RemovePacket(packetNumber)
+}
+
+// OnCongestionEvent is a no-op. BBR (version 1) doesn't respond to ECN marks.
+func (s *bbrSender) OnCongestionEvent(protocol.PacketNumber, protocol.ByteCount) {}
+
+// OnPersistentCongestion collapses the congestion window.
+// The model of the path is kept, the congestion window grows back with every ACK received.
+func (s *bbrSender) OnPersistentCongestion() {
+	s.congestionWindow = bbrMinCongestionWindow
+	// don't restore the old congestion window when leaving ProbeRTT
+	s.priorCongestionWindow = bbrMinCongestionWindow
+}
+
+// OnConnectionMigration resets the model of the path.
+func (s *bbrSender) OnConnectionMigration() {
+	s.reset()
+}
+
+func (s *bbrSender) reduceBytesInFlight(bytes protocol.ByteCount) {
+	if bytes > s.bytesInFlight {
+		s.bytesInFlight = 0
+		return
+	}
+	s.bytesInFlight -= bytes
+}
+
+// updateRound starts a new round trip when a packet sent after the start of the current round trip is acknowledged.
+func (s *bbrSender) updateRound(sample bandwidthSample) {
+	s.roundStart = false
+	if sample.priorDelivered >= s.nextRoundDelivered {
+		s.nextRoundDelivered = s.sampler.Delivered()
+		s.roundCount++
+		s.roundStart = true
+	}
+}
+
+// updateMinRTT updates the min RTT. It returns true if the previous min RTT sample expired.
+func (s *bbrSender) updateMinRTT(rtt time.Duration, now time.Time) bool {
+	expired := !s.minRTTTimestamp.IsZero() && now.Sub(s.minRTTTimestamp) > bbrMinRTTExpiry
+	if rtt > 0 && (s.minRTT == 0 || rtt <= s.minRTT || expired) {
+		s.minRTT = rtt
+		s.minRTTTimestamp = now
+	}
+	return expired
+}
+
+// bdp calculates the bandwidth-delay product, multiplied by gain.
+func (s *bbrSender) bdp(gain float64) protocol.ByteCount {
+	bw := s.maxBandwidth.Get()
+	if bw == 0 || s.minRTT == 0 {
+		return s.initialCongestionWindow
+	}
+	return protocol.ByteCount(gain * float64(bw) / float64(BytesPerSecond) * s.minRTT.Seconds())
+}
+
+func (s *bbrSender) updateGainCycle(now time.Time) {
+	if s.mode != bbrProbeBW {
+		return
+	}
+	isFullLength := now.Sub(s.cycleStart) > s.minRTT
+	var advance bool
+	switch {
+	case s.pacingGain > 1:
+		// Probe until the additional data is in flight, or until packets are lost.
+		advance = isFullLength && (s.lostInCycle || s.bytesInFlight >= s.bdp(s.pacingGain))
+	case s.pacingGain < 1:
+		// Drain until the queue is empty.
+		advance = isFullLength || s.bytesInFlight <= s.bdp(1)
+	default:
+		advance = isFullLength
+	}
+	if !advance {
+		return
+	}
+	s.cycleIndex = (s.cycleIndex + 1) % len(bbrPacingGainCycle)
+	s.cycleStart = now
+	s.lostInCycle = false
+	s.pacingGain = bbrPacingGainCycle[s.cycleIndex]
+}
+
+// checkFullBandwidthReached checks if the bandwidth stopped growing in Startup.
+func (s *bbrSender) checkFullBandwidthReached() {
+	if s.fullBandwidthReached || !s.roundStart {
+		return
+	}
+	if bw := s.maxBandwidth.Get(); float64(bw) >= float64(s.fullBandwidth)*bbrStartupGrowthTarget {
+		s.fullBandwidth = bw
+		s.fullBandwidthRounds = 0
+		return
+	}
+	s.fullBandwidthRounds++
+	if s.fullBandwidthRounds >= bbrStartupFullBandwidthRounds {
+		s.fullBandwidthReached = true
+	}
+}
+
+func (s *bbrSender) checkDrain(now time.Time) {
+	if s.mode == bbrStartup && s.fullBandwidthReached {
+		s.mode = bbrDrain
+		s.pacingGain = bbrDrainGain
+		s.congestionWindowGain = bbrHighGain
+	}
+	if s.mode == bbrDrain && s.bytesInFlight <= s.bdp(1) {
+		s.enterProbeBW(now)
+	}
+}
+
+func (s *bbrSender) updateProbeRTT(minRTTExpired bool, now time.Time) {
+	if s.mode != bbrProbeRTT && minRTTExpired {
+		s.mode = bbrProbeRTT
+		s.pacingGain = 1
+		s.probeRTTDoneTime = time.Time{}
+		s.priorCongestionWindow = s.congestionWindow
+	}
+	if s.mode != bbrProbeRTT {
+		return
+	}
+	if s.probeRTTDoneTime.IsZero() {
+		// Wait until the bytes in flight have been reduced to the minimum congestion window.
+		if s.bytesInFlight <= bbrMinCongestionWindow {
+			s.probeRTTDoneTime = now.Add(bbrProbeRTTDuration)
+			s.probeRTTRoundDone = false
+			s.nextRoundDelivered = s.sampler.Delivered()
+		}
+		return
+	}
+	if s.roundStart {
+		s.probeRTTRoundDone = true
+	}
+	// Stay in ProbeRTT for at least bbrProbeRTTDuration and one round trip.
+	if s.probeRTTRoundDone && now.After(s.probeRTTDoneTime) {
+		s.minRTTTimestamp = now
+		s.congestionWindow = utils.MaxByteCount(s.congestionWindow, s.priorCongestionWindow)
+		if s.fullBandwidthReached {
+			s.enterProbeBW(now)
+		} else {
+			s.enterStartup()
+		}
+	}
+}
+
+func (s *bbrSender) updatePacingRate() {
+	bw := s.maxBandwidth.Get()
+	if bw == 0 {
+		return
+	}
+	rate := Bandwidth(s.pacingGain * float64(bw))
+	// Don't reduce the pacing rate in Startup, before the bandwidth is known.
+	if s.fullBandwidthReached || rate > s.pacingRate {
+		s.pacingRate = rate
+	}
+}
+
+func (s *bbrSender) updateCongestionWindow(ackedBytes protocol.ByteCount) {
+	if s.mode == bbrProbeRTT {
+		s.congestionWindow = utils.MinByteCount(s.congestionWindow, bbrMinCongestionWindow)
+		return
+	}
+	// Allow for 3 packets to be in flight at the sender, the receiver and in the network, to account for delayed ACKs.
+	target := s.bdp(s.congestionWindowGain) + 3*protocol.DefaultTCPMSS
+	if s.fullBandwidthReached {
+		s.congestionWindow = utils.MinByteCount(s.congestionWindow+ackedBytes, target)
+	} else if s.congestionWindow < target || s.sampler.Delivered() < s.initialCongestionWindow {
+		s.congestionWindow += ackedBytes
+	}
+	s.congestionWindow = utils.MaxByteCount(s.congestionWindow, bbrMinCongestionWindow)
+	s.congestionWindow = utils.MinByteCount(s.congestionWindow, s.maxCongestionWindow)
+}
@@ -0,0 +1,221 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BBR Sender", func() {
+	const packetSize = protocol.DefaultTCPMSS
+
+	var (
+		sender        *bbrSender
+		rttStats      *RTTStats
+		now           time.Time
+		packetNumber  protocol.PacketNumber
+		bytesInFlight protocol.ByteCount
+	)
+
+	sendPacket := func() protocol.PacketNumber {
+		packetNumber++
+		bytesInFlight += packetSize
+		sender.OnPacketSent(now, bytesInFlight, packetNumber, packetSize, true)
+		return packetNumber
+	}
+
+	ackPacket := func(pn protocol.PacketNumber) {
+		sender.OnPacketAcked(pn, packetSize, bytesInFlight, now)
+		bytesInFlight -= packetSize
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+		packetNumber = 0
+		bytesInFlight = 0
+		rttStats = NewRTTStats()
+		sender = NewBBRSender(rttStats, 10*packetSize, 1000*packetSize).(*bbrSender)
+	})
+
+	It("starts in Startup", func() {
+		Expect(sender.mode).To(Equal(bbrStartup))
+		Expect(sender.pacingGain).To(Equal(bbrHighGain))
+		Expect(sender.GetCongestionWindow()).To(Equal(10 * packetSize))
+	})
+
+	It("paces the initial congestion window over the initial RTT, using the Startup gain", func() {
+		sendPacket()
+		rtt := defaultInitialRTT
+		// 10 packets are sent within one RTT, divided by the gain
+		Expect(sender.TimeUntilSend(bytesInFlight)).To(BeNumerically("~", time.Duration(float64(rtt)/bbrHighGain)/10, time.Microsecond))
+	})
+
+	It("paces at the estimated bandwidth", func() {
+		sender.maxBandwidth.Update(10*1000*1000*BitsPerSecond, 0)
+		sender.pacingGain = 1
+		sender.fullBandwidthReached = true
+		sender.updatePacingRate()
+		sendPacket()
+		// sending 1460 bytes at 10 Mbit/s takes 1.168ms
+		Expect(sender.TimeUntilSend(bytesInFlight)).To(Equal(1168 * time.Microsecond))
+	})
+
+	It("doesn't track non-retransmittable packets", func() {
+		sender.OnPacketSent(now, 0, 1, 100, false)
+		Expect(sender.sampler.packets).To(BeEmpty())
+	})
+
+	It("ignores ECN congestion events", func() {
+		sendPacket()
+		cwnd := sender.GetCongestionWindow()
+		sender.OnCongestionEvent(1, bytesInFlight)
+		Expect(sender.GetCongestionWindow()).To(Equal(cwnd))
+	})
+
+	It("doesn't reduce the congestion window on packet loss", func() {
+		pn := sendPacket()
+		cwnd := sender.GetCongestionWindow()
+		sender.OnPacketLost(pn, packetSize, bytesInFlight)
+		Expect(sender.GetCongestionWindow()).To(Equal(cwnd))
+		Expect(sender.bytesInFlight).To(BeZero())
+		Expect(sender.sampler.packets).To(BeEmpty())
+	})
+
+	It("stops tracking discarded packets", func() {
+		pn := sendPacket()
+		Expect(sender.sampler.packets).To(HaveLen(1))
+		sender.OnPacketDiscarded(pn, packetSize)
+		Expect(sender.bytesInFlight).To(BeZero())
+		Expect(sender.sampler.packets).To(BeEmpty())
+	})
+
+	It("estimates the bandwidth and the min RTT, and enters ProbeBW after filling the pipe", func() {
+		var s *bbrSender
+		newBBR := func(_ Clock, rttStats *RTTStats) SendAlgorithm {
+			s = NewBBRSender(rttStats, protocol.InitialCongestionWindow, protocol.DefaultMaxCongestionWindow).(*bbrSender)
+			return s
+		}
+		link := simulatedLink{
+			bandwidth:  10 * 1000 * 1000 * BitsPerSecond,
+			rtt:        50 * time.Millisecond,
+			queueDelay: 50 * time.Millisecond,
+		}
+		simulateTransfer(newBBR, link, 5*time.Second, 1)
+		Expect(s.mode).To(Equal(bbrProbeBW))
+		Expect(s.fullBandwidthReached).To(BeTrue())
+		Expect(s.maxBandwidth.Get()).To(BeNumerically("~", link.bandwidth, link.bandwidth/20))
+		Expect(s.minRTT).To(BeNumerically("~", link.rtt, 5*time.Millisecond))
+		// the congestion window is twice the bandwidth-delay product
+		bdp := protocol.ByteCount(link.bandwidth / BytesPerSecond * Bandwidth(link.rtt) / Bandwidth(time.Second))
+		Expect(s.GetCongestionWindow()).To(BeNumerically("~", 2*bdp, bdp/5))
+	})
+
+	It("exits Startup when the bandwidth stops growing, and drains the queue", func() {
+		sender.maxBandwidth.Update(1000, 0)
+		sender.roundStart = true
+		sender.checkFullBandwidthReached()
+		Expect(sender.fullBandwidth).To(Equal(Bandwidth(1000)))
+		for i := 0; i < bbrStartupFullBandwidthRounds; i++ {
+			sender.roundStart = true
+			sender.checkFullBandwidthReached()
+			Expect(sender.fullBandwidthReached).To(Equal(i == bbrStartupFullBandwidthRounds-1))
+		}
+		// Drain until the queue built in Startup is empty
+		sender.bytesInFlight = 2 * sender.bdp(1)
+		sender.checkDrain(now)
+		Expect(sender.mode).To(Equal(bbrDrain))
+		Expect(sender.pacingGain).To(Equal(bbrDrainGain))
+		sender.bytesInFlight = sender.bdp(1)
+		sender.checkDrain(now)
+		Expect(sender.mode).To(Equal(bbrProbeBW))
+	})
+
+	It("keeps growing in Startup as long as the bandwidth increases by 25%", func() {
+		bw := Bandwidth(1000)
+		for i := 0; i < 10; i++ {
+			sender.maxBandwidth.Update(bw, uint64(i))
+			sender.roundStart = true
+			sender.checkFullBandwidthReached()
+			bw = bw * 5 / 4
+		}
+		Expect(sender.fullBandwidthReached).To(BeFalse())
+	})
+
+	It("cycles through the pacing gains in ProbeBW", func() {
+		sender.minRTT = 10 * time.Millisecond
+		sender.enterProbeBW(now)
+		Expect(sender.cycleIndex).ToNot(Equal(1))
+		for i := 0; i < 2*len(bbrPacingGainCycle); i++ {
+			index := sender.cycleIndex
+			// losses end the probing phase
+			sender.lostInCycle = true
+			now = now.Add(11 * time.Millisecond)
+			sender.updateGainCycle(now)
+			Expect(sender.cycleIndex).To(Equal((index + 1) % len(bbrPacingGainCycle)))
+			Expect(sender.pacingGain).To(Equal(bbrPacingGainCycle[sender.cycleIndex]))
+		}
+	})
+
+	It("enters ProbeRTT when the min RTT expires, and exits after 200ms and one round trip", func() {
+		pn := sendPacket()
+		now = now.Add(10 * time.Millisecond)
+		ackPacket(pn)
+		Expect(sender.minRTT).To(Equal(10 * time.Millisecond))
+		Expect(sender.mode).To(Equal(bbrStartup))
+
+		for i := 0; i < 10; i++ {
+			sendPacket()
+		}
+		now = now.Add(bbrMinRTTExpiry + time.Second)
+		ackPacket(packetNumber - 9)
+		Expect(sender.mode).To(Equal(bbrProbeRTT))
+		Expect(sender.GetCongestionWindow()).To(Equal(bbrMinCongestionWindow))
+		// drain the bytes in flight
+		for pn := packetNumber - 8; pn <= packetNumber; pn++ {
+			ackPacket(pn)
+		}
+		Expect(sender.probeRTTDoneTime).ToNot(BeZero())
+		// Wait for one round trip, and for 200ms.
+		pn = sendPacket()
+		now = now.Add(bbrProbeRTTDuration + time.Millisecond)
+		ackPacket(pn)
+		Expect(sender.mode).To(Equal(bbrStartup))
+		Expect(sender.GetCongestionWindow()).To(BeNumerically(">", bbrMinCongestionWindow))
+	})
+
+	It("collapses the congestion window on persistent congestion", func() {
+		for i := 0; i < 10; i++ {
+			pn := sendPacket()
+			now = now.Add(10 * time.Millisecond)
+			ackPacket(pn)
+		}
+		Expect(sender.GetCongestionWindow()).To(BeNumerically(">", bbrMinCongestionWindow))
+		bw := sender.maxBandwidth.Get()
+		sender.OnPersistentCongestion()
+		Expect(sender.GetCongestionWindow()).To(Equal(bbrMinCongestionWindow))
+		// the model of the path is kept
+		Expect(sender.maxBandwidth.Get()).To(Equal(bw))
+		// the congestion window grows with every ACK
+		pn := sendPacket()
+		now = now.Add(10 * time.Millisecond)
+		ackPacket(pn)
+		Expect(sender.GetCongestionWindow()).To(Equal(bbrMinCongestionWindow + packetSize))
+	})
+
+	It("resets the model on connection migration", func() {
+		pn := sendPacket()
+		now = now.Add(10 * time.Millisecond)
+		ackPacket(pn)
+		Expect(sender.minRTT).ToNot(BeZero())
+		sender.fullBandwidthReached = true
+		sender.OnConnectionMigration()
+		Expect(sender.minRTT).To(BeZero())
+		Expect(sender.maxBandwidth.Get()).To(BeZero())
+		Expect(sender.fullBandwidthReached).To(BeFalse())
+		Expect(sender.mode).To(Equal(bbrStartup))
+		Expect(sender.GetCongestionWindow()).To(Equal(10 * packetSize))
+	})
+})
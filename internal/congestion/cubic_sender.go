@@ -8,9 +8,8 @@ import (
 )
 
 const (
-	maxBurstBytes                                     = 3 * protocol.DefaultTCPMSS
-	renoBeta                       float32            = 0.7 // Reno backoff factor.
-	defaultMinimumCongestionWindow protocol.ByteCount = 2 * protocol.DefaultTCPMSS
+	maxBurstBytes         = 3 * protocol.DefaultTCPMSS
+	renoBeta      float32 = 0.7 // Reno backoff factor.
 )
 
 type cubicSender struct {
@@ -65,14 +64,15 @@ type cubicSender struct {
 var _ SendAlgorithm = &cubicSender{}
 var _ SendAlgorithmWithDebugInfo = &cubicSender{}
 
-// NewCubicSender makes a new cubic sender
-func NewCubicSender(clock Clock, rttStats *RTTStats, reno bool, initialCongestionWindow, initialMaxCongestionWindow protocol.ByteCount) SendAlgorithmWithDebugInfo {
+// NewCubicSender makes a new cubic sender.
+// The congestion window is never reduced below minCongestionWindow, not even after a retransmission timeout.
+func NewCubicSender(clock Clock, rttStats *RTTStats, reno bool, initialCongestionWindow, minCongestionWindow, initialMaxCongestionWindow protocol.ByteCount) SendAlgorithmWithDebugInfo {
 	return &cubicSender{
 		rttStats:                   rttStats,
 		initialCongestionWindow:    initialCongestionWindow,
 		initialMaxCongestionWindow: initialMaxCongestionWindow,
 		congestionWindow:           initialCongestionWindow,
-		minCongestionWindow:        defaultMinimumCongestionWindow,
+		minCongestionWindow:        minCongestionWindow,
 		slowstartThreshold:         initialMaxCongestionWindow,
 		maxCongestionWindow:        initialMaxCongestionWindow,
 		numConnections:             defaultNumConnections,
@@ -81,7 +81,8 @@ func NewCubicSender(clock Clock, rttStats *RTTStats, reno bool, initialCongestio
 	}
 }
 
-// TimeUntilSend returns when the next packet should be sent.
+// TimeUntilSend returns the pacing interval for a packet of DefaultTCPMSS.
+// Packets are paced at twice the congestion window per RTT in slow start, and at 1.25 times the congestion window per RTT in congestion avoidance.
 func (c *cubicSender) TimeUntilSend(bytesInFlight protocol.ByteCount) time.Duration {
 	if c.InRecovery() {
 		// PRR is used when in recovery.
@@ -89,7 +90,7 @@ func (c *cubicSender) TimeUntilSend(bytesInFlight protocol.ByteCount) time.Durat
 			return 0
 		}
 	}
-	delay := c.rttStats.SmoothedRTT() / time.Duration(2*c.GetCongestionWindow())
+	delay := c.rttStats.SmoothedRTT() * time.Duration(protocol.DefaultTCPMSS) / time.Duration(2*c.GetCongestionWindow())
 	if !c.InSlowStart() { // adjust delay, such that it's 1.25*cwd/rtt
 		delay = delay * 8 / 5
 	}
@@ -185,7 +186,22 @@ func (c *cubicSender) OnPacketLost(
 	if c.InSlowStart() {
 		c.stats.slowstartPacketsLost++
 	}
+	c.reduceCongestionWindow(priorInFlight)
+}
+
+// OnPacketDiscarded is a no-op. Cubic doesn't keep any per-packet state.
+func (c *cubicSender) OnPacketDiscarded(protocol.PacketNumber, protocol.ByteCount) {}
+
+func (c *cubicSender) OnCongestionEvent(largestAcked protocol.PacketNumber, priorInFlight protocol.ByteCount) {
+	// As for losses, all CE marks for packets sent before the last cutback are treated as a single congestion event.
+	if largestAcked <= c.largestSentAtLastCutback {
+		return
+	}
+	c.lastCutbackExitedSlowstart = c.InSlowStart()
+	c.reduceCongestionWindow(priorInFlight)
+}
 
+func (c *cubicSender) reduceCongestionWindow(priorInFlight protocol.ByteCount) {
 	c.prr.OnPacketLost(priorInFlight)
 
 	// TODO(chromium): Separate out all of slow start into a separate class.
@@ -297,6 +313,17 @@ func (c *cubicSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
 	c.congestionWindow = c.minCongestionWindow
 }
 
+// OnPersistentCongestion collapses the congestion window to the minimum congestion window.
+// The slow start threshold is set to half the congestion window, as after a retransmission timeout.
+func (c *cubicSender) OnPersistentCongestion() {
+	c.hybridSlowStart.Restart()
+	c.prr = PrrSender{}
+	c.cubic.Reset()
+	c.largestSentAtLastCutback = 0
+	c.slowstartThreshold = utils.MaxByteCount(c.congestionWindow/2, c.minCongestionWindow)
+	c.congestionWindow = c.minCongestionWindow
+}
+
 // OnConnectionMigration is called when the connection is migrated (?)
 func (c *cubicSender) OnConnectionMigration() {
 	c.hybridSlowStart.Restart()
@@ -40,7 +40,7 @@ var _ = Describe("Cubic Sender", func() {
 		ackedPacketNumber = 0
 		clock = mockClock{}
 		rttStats = NewRTTStats()
-		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets*protocol.DefaultTCPMSS, MaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets*protocol.DefaultTCPMSS, protocol.DefaultMinCongestionWindow, MaxCongestionWindow)
 	})
 
 	canSend := func() bool {
@@ -281,6 +281,28 @@ var _ = Describe("Cubic Sender", func() {
 		Expect(sender.TimeUntilSend(0)).To(BeZero())
 	})
 
+	It("reduces the congestion window on a congestion event", func() {
+		sender.SetNumEmulatedConnections(1)
+		for i := 0; i < 10; i++ {
+			SendAvailableSendWindow()
+			AckNPackets(2)
+		}
+		SendAvailableSendWindow()
+		expectedSendWindow := defaultWindowTCP + (protocol.DefaultTCPMSS * 2 * 10)
+		Expect(sender.GetCongestionWindow()).To(Equal(expectedSendWindow))
+
+		// The peer reports a CE mark. No packets are lost.
+		sender.OnCongestionEvent(ackedPacketNumber+1, bytesInFlight)
+		expectedSendWindow = protocol.ByteCount(float32(expectedSendWindow) * renoBeta)
+		Expect(sender.GetCongestionWindow()).To(Equal(expectedSendWindow))
+		Expect(sender.InRecovery()).To(BeTrue())
+		Expect(sender.SlowstartThreshold()).To(Equal(expectedSendWindow))
+
+		// Further CE marks for packets sent before the cutback don't reduce the window again.
+		sender.OnCongestionEvent(packetNumber-1, bytesInFlight)
+		Expect(sender.GetCongestionWindow()).To(Equal(expectedSendWindow))
+	})
+
 	It("slow start packet loss PRR", func() {
 		sender.SetNumEmulatedConnections(1)
 		// Test based on the first example in RFC6937.
@@ -393,6 +415,31 @@ var _ = Describe("Cubic Sender", func() {
 		Expect(sender.SlowstartThreshold()).To(Equal(5 * protocol.DefaultTCPMSS))
 	})
 
+	It("uses the configured minimum congestion window", func() {
+		sender = NewCubicSender(&clock, rttStats, true /*reno*/, 20*protocol.DefaultTCPMSS, 5*protocol.DefaultTCPMSS, MaxCongestionWindow)
+		// after an RTO, the window collapses to the minimum, not to the initial window
+		sender.OnRetransmissionTimeout(true)
+		Expect(sender.GetCongestionWindow()).To(Equal(5 * protocol.DefaultTCPMSS))
+		// the minimum is also applied when the window is reduced after a loss
+		sender.OnPacketSent(clock.Now(), 0, 1, protocol.DefaultTCPMSS, true)
+		sender.OnPacketLost(1, protocol.DefaultTCPMSS, protocol.DefaultTCPMSS)
+		Expect(sender.GetCongestionWindow()).To(Equal(5 * protocol.DefaultTCPMSS))
+	})
+
+	It("collapses the congestion window on persistent congestion", func() {
+		SendAvailableSendWindow()
+		AckNPackets(10)
+		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP + 10*protocol.DefaultTCPMSS))
+		Expect(sender.HybridSlowStart().Started()).To(BeTrue())
+		sender.OnPersistentCongestion()
+		Expect(sender.GetCongestionWindow()).To(Equal(protocol.DefaultMinCongestionWindow))
+		Expect(sender.SlowstartThreshold()).To(Equal((defaultWindowTCP + 10*protocol.DefaultTCPMSS) / 2))
+		Expect(sender.HybridSlowStart().Started()).To(BeFalse())
+		// the slow start threshold is never smaller than the minimum congestion window
+		sender.OnPersistentCongestion()
+		Expect(sender.SlowstartThreshold()).To(Equal(protocol.DefaultMinCongestionWindow))
+	})
+
 	It("RTO congestion window no retransmission", func() {
 		Expect(sender.GetCongestionWindow()).To(Equal(defaultWindowTCP))
 
@@ -405,7 +452,7 @@ var _ = Describe("Cubic Sender", func() {
 	It("tcp cubic reset epoch on quiescence", func() {
 		const maxCongestionWindow = 50
 		const maxCongestionWindowBytes = maxCongestionWindow * protocol.DefaultTCPMSS
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets*protocol.DefaultTCPMSS, maxCongestionWindowBytes)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets*protocol.DefaultTCPMSS, protocol.DefaultMinCongestionWindow, maxCongestionWindowBytes)
 
 		numSent := SendAvailableSendWindow()
 
@@ -591,7 +638,7 @@ var _ = Describe("Cubic Sender", func() {
 	})
 
 	It("default max cwnd", func() {
-		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets*protocol.DefaultTCPMSS, protocol.DefaultMaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, true /*reno*/, initialCongestionWindowPackets*protocol.DefaultTCPMSS, protocol.DefaultMinCongestionWindow, protocol.DefaultMaxCongestionWindow)
 
 		defaultMaxCongestionWindowPackets := protocol.DefaultMaxCongestionWindow / protocol.DefaultTCPMSS
 		for i := 1; i < int(defaultMaxCongestionWindowPackets); i++ {
@@ -603,7 +650,7 @@ var _ = Describe("Cubic Sender", func() {
 
 	It("limit cwnd increase in congestion avoidance", func() {
 		// Enable Cubic.
-		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets*protocol.DefaultTCPMSS, MaxCongestionWindow)
+		sender = NewCubicSender(&clock, rttStats, false, initialCongestionWindowPackets*protocol.DefaultTCPMSS, protocol.DefaultMinCongestionWindow, MaxCongestionWindow)
 		numSent := SendAvailableSendWindow()
 
 		// Make sure we fall out of slow start.
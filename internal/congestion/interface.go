@@ -8,24 +8,35 @@ import (
 
 // A SendAlgorithm performs congestion control and calculates the congestion window
 type SendAlgorithm interface {
+	// TimeUntilSend returns the pacing interval, i.e. the time it takes to send a packet of DefaultTCPMSS at the pacing rate.
+	// 0 means that packets are not paced.
 	TimeUntilSend(bytesInFlight protocol.ByteCount) time.Duration
 	OnPacketSent(sentTime time.Time, bytesInFlight protocol.ByteCount, packetNumber protocol.PacketNumber, bytes protocol.ByteCount, isRetransmittable bool)
 	GetCongestionWindow() protocol.ByteCount
 	MaybeExitSlowStart()
 	OnPacketAcked(number protocol.PacketNumber, ackedBytes protocol.ByteCount, priorInFlight protocol.ByteCount, eventTime time.Time)
 	OnPacketLost(number protocol.PacketNumber, lostBytes protocol.ByteCount, priorInFlight protocol.ByteCount)
-	SetNumEmulatedConnections(n int)
-	OnRetransmissionTimeout(packetsRetransmitted bool)
+	// OnPacketDiscarded is called when a packet will neither be acknowledged nor declared lost,
+	// e.g. because its keys were dropped when the handshake completed.
+	OnPacketDiscarded(number protocol.PacketNumber, bytes protocol.ByteCount)
+	// OnCongestionEvent is called when the peer reports packets that were marked CE (ECN Congestion Experienced).
+	// It reduces the congestion window like a loss would, without any packets being lost.
+	OnCongestionEvent(largestAcked protocol.PacketNumber, priorInFlight protocol.ByteCount)
+	// OnPersistentCongestion is called when all packets sent over a period of multiple PTOs were lost.
+	// The congestion window is collapsed to the minimum congestion window.
+	OnPersistentCongestion()
 	OnConnectionMigration()
-
-	// Experiments
-	SetSlowStartLargeReduction(enabled bool)
 }
 
 // SendAlgorithmWithDebugInfo adds some debug functions to SendAlgorithm
 type SendAlgorithmWithDebugInfo interface {
 	SendAlgorithm
 	BandwidthEstimate() Bandwidth
+	SetNumEmulatedConnections(n int)
+	OnRetransmissionTimeout(packetsRetransmitted bool)
+
+	// Experiments
+	SetSlowStartLargeReduction(enabled bool)
 
 	// Stuff only used in testing
 
@@ -0,0 +1,159 @@
+package congestion
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A simulatedLink is a bottleneck link with a drop-tail queue, and random packet loss.
+type simulatedLink struct {
+	bandwidth Bandwidth
+	rtt       time.Duration
+	// the maximum queueing delay, packets are dropped when the queue is full
+	queueDelay time.Duration
+	lossRate   float64
+}
+
+type simulatedPacket struct {
+	packetNumber protocol.PacketNumber
+	sentTime     time.Time
+	ackTime      time.Time
+	acked        bool
+}
+
+// simulateTransfer runs a bulk transfer over the link for the given duration,
+// and returns the goodput, i.e. the rate at which data was acknowledged.
+// ACKs are sent for every packet, and are never lost.
+// Packets are declared lost when 3 packets sent later are acknowledged, or after a timeout.
+func simulateTransfer(
+	newSender func(Clock, *RTTStats) SendAlgorithm,
+	link simulatedLink,
+	duration time.Duration,
+	seed int64,
+) Bandwidth {
+	const packetSize = protocol.DefaultTCPMSS
+	r := rand.New(rand.NewSource(seed))
+	start := time.Now()
+	clock := mockClock(start)
+	rttStats := NewRTTStats()
+	sender := newSender(&clock, rttStats)
+
+	var (
+		now           = start
+		end           = start.Add(duration)
+		nextSendTime  = start
+		linkFree      = start
+		packetNumber  protocol.PacketNumber
+		bytesInFlight protocol.ByteCount
+		delivered     protocol.ByteCount
+		outstanding   []*simulatedPacket // sorted by packet number
+		acks          []*simulatedPacket // sorted by ACK time
+	)
+	transmissionTime := time.Duration(uint64(packetSize) * uint64(BytesPerSecond) * uint64(time.Second) / uint64(link.bandwidth))
+
+	declareLost := func(p *simulatedPacket, priorInFlight protocol.ByteCount) {
+		bytesInFlight -= packetSize
+		sender.OnPacketLost(p.packetNumber, packetSize, priorInFlight)
+	}
+	lossTimeout := func() time.Duration { return 4 * rttStats.SmoothedOrInitialRTT() }
+
+	for now.Before(end) {
+		clock = mockClock(now)
+		for len(acks) > 0 && !acks[0].ackTime.After(now) {
+			p := acks[0]
+			acks = acks[1:]
+			p.acked = true
+			rttStats.UpdateRTT(p.ackTime.Sub(p.sentTime), 0, p.ackTime)
+			sender.MaybeExitSlowStart()
+			priorInFlight := bytesInFlight
+			bytesInFlight -= packetSize
+			delivered += packetSize
+			sender.OnPacketAcked(p.packetNumber, packetSize, priorInFlight, now)
+			for len(outstanding) > 0 {
+				first := outstanding[0]
+				if !first.acked {
+					if first.packetNumber+3 > p.packetNumber {
+						break
+					}
+					declareLost(first, priorInFlight)
+				}
+				outstanding = outstanding[1:]
+			}
+		}
+		for len(outstanding) > 0 && (outstanding[0].acked || !outstanding[0].sentTime.Add(lossTimeout()).After(now)) {
+			if !outstanding[0].acked {
+				declareLost(outstanding[0], bytesInFlight)
+			}
+			outstanding = outstanding[1:]
+		}
+
+		canSend := bytesInFlight < sender.GetCongestionWindow()
+		if canSend && !nextSendTime.After(now) {
+			packetNumber++
+			p := &simulatedPacket{packetNumber: packetNumber, sentTime: now}
+			outstanding = append(outstanding, p)
+			bytesInFlight += packetSize
+			sender.OnPacketSent(now, bytesInFlight, packetNumber, packetSize, true)
+			nextSendTime = utils.MaxTime(nextSendTime, now).Add(sender.TimeUntilSend(bytesInFlight))
+			if r.Float64() < link.lossRate || linkFree.Sub(now) > link.queueDelay {
+				continue
+			}
+			linkFree = utils.MaxTime(linkFree, now).Add(transmissionTime)
+			p.ackTime = linkFree.Add(link.rtt)
+			acks = append(acks, p)
+			continue
+		}
+
+		next := end
+		if len(acks) > 0 {
+			next = utils.MinTime(next, acks[0].ackTime)
+		}
+		if canSend {
+			next = utils.MinTime(next, nextSendTime)
+		}
+		if len(outstanding) > 0 {
+			next = utils.MinTime(next, outstanding[0].sentTime.Add(lossTimeout()))
+		}
+		now = next
+	}
+	return BandwidthFromDelta(delivered, duration)
+}
+
+var _ = Describe("Network Simulation", func() {
+	link := simulatedLink{
+		bandwidth:  10 * 1000 * 1000 * BitsPerSecond,
+		rtt:        100 * time.Millisecond,
+		queueDelay: 100 * time.Millisecond,
+	}
+	const duration = 30 * time.Second
+
+	newBBR := func(_ Clock, rttStats *RTTStats) SendAlgorithm {
+		return NewBBRSender(rttStats, protocol.InitialCongestionWindow, protocol.DefaultMaxCongestionWindow)
+	}
+	newCubic := func(clock Clock, rttStats *RTTStats) SendAlgorithm {
+		return NewCubicSender(clock, rttStats, false, protocol.InitialCongestionWindow, protocol.DefaultMinCongestionWindow, protocol.DefaultMaxCongestionWindow)
+	}
+
+	It("utilizes the link", func() {
+		Expect(simulateTransfer(newBBR, link, duration, 42)).To(BeNumerically(">", link.bandwidth*9/10))
+		Expect(simulateTransfer(newCubic, link, duration, 42)).To(BeNumerically(">", link.bandwidth*9/10))
+	})
+
+	for _, l := range []float64{0.01, 0.02} {
+		lossRate := l
+
+		It(fmt.Sprintf("sustains the throughput at %.0f%% random loss with BBR, but not with Cubic", lossRate*100), func() {
+			lossyLink := link
+			lossyLink.lossRate = lossRate
+			Expect(simulateTransfer(newBBR, lossyLink, duration, 42)).To(BeNumerically(">", link.bandwidth*9/10))
+			Expect(simulateTransfer(newCubic, lossyLink, duration, 42)).To(BeNumerically("<", link.bandwidth/2))
+		})
+	}
+})
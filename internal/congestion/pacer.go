@@ -0,0 +1,76 @@
+package congestion
+
+import (
+	"math"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+)
+
+const maxPacketSize = protocol.ByteCount(protocol.MaxPacketSizeIPv4)
+
+// The Pacer implements a token bucket pacing algorithm.
+// The budget grows at the pacing rate, and is capped at a small burst size.
+// Sending a packet consumes budget.
+type Pacer struct {
+	budgetAtLastSent protocol.ByteCount
+	lastSentTime     time.Time
+	// getBandwidth returns the pacing rate. 0 means that packets are not paced.
+	getBandwidth func() Bandwidth
+}
+
+// NewPacer creates a new Pacer.
+func NewPacer(getBandwidth func() Bandwidth) *Pacer {
+	return &Pacer{
+		budgetAtLastSent: protocol.MaxPacingBurstPackets * maxPacketSize,
+		getBandwidth:     getBandwidth,
+	}
+}
+
+// SentPacket consumes budget for a packet.
+func (p *Pacer) SentPacket(sendTime time.Time, size protocol.ByteCount) {
+	budget := p.Budget(sendTime)
+	if size > budget {
+		p.budgetAtLastSent = 0
+	} else {
+		p.budgetAtLastSent = budget - size
+	}
+	p.lastSentTime = sendTime
+}
+
+// Budget returns the number of bytes that can be sent at the given time.
+func (p *Pacer) Budget(now time.Time) protocol.ByteCount {
+	bw := p.getBandwidth()
+	if bw == 0 {
+		return protocol.MaxByteCount
+	}
+	if p.lastSentTime.IsZero() {
+		return p.maxBurstSize(bw)
+	}
+	budget := p.budgetAtLastSent + protocol.ByteCount(float64(bw)/float64(BytesPerSecond)*now.Sub(p.lastSentTime).Seconds())
+	return utils.MinByteCount(p.maxBurstSize(bw), budget)
+}
+
+// The burst size must be large enough to allow sending at the pacing rate, if the timer only fires every MinPacingDelay.
+func (p *Pacer) maxBurstSize(bw Bandwidth) protocol.ByteCount {
+	return utils.MaxByteCount(
+		protocol.MaxPacingBurstPackets*maxPacketSize,
+		protocol.ByteCount(float64(bw)/float64(BytesPerSecond)*(2*protocol.MinPacingDelay).Seconds()),
+	)
+}
+
+// TimeUntilSend returns when the budget allows sending the next packet.
+// It returns the zero value if a packet can be sent immediately.
+func (p *Pacer) TimeUntilSend() time.Time {
+	if p.budgetAtLastSent >= maxPacketSize {
+		return time.Time{}
+	}
+	bw := p.getBandwidth()
+	if bw == 0 {
+		return time.Time{}
+	}
+	// round up, so that the budget is large enough to send a full-size packet at the returned time
+	delay := time.Duration(math.Ceil(float64(maxPacketSize-p.budgetAtLastSent) * float64(BytesPerSecond) / float64(bw) * float64(time.Second)))
+	return p.lastSentTime.Add(utils.MaxDuration(protocol.MinPacingDelay, delay))
+}
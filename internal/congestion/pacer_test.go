@@ -0,0 +1,94 @@
+package congestion
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pacer", func() {
+	var p *Pacer
+
+	const packetsPerSecond = 42
+	var bandwidth Bandwidth // in bytes/s
+
+	BeforeEach(func() {
+		bandwidth = packetsPerSecond * Bandwidth(maxPacketSize) // 42 full-size packets per second
+		p = NewPacer(func() Bandwidth { return bandwidth * BytesPerSecond })
+	})
+
+	It("allows a burst at the beginning", func() {
+		t := time.Now()
+		Expect(p.TimeUntilSend()).To(BeZero())
+		Expect(p.Budget(t)).To(BeEquivalentTo(protocol.MaxPacingBurstPackets * maxPacketSize))
+	})
+
+	It("paces packets after a burst", func() {
+		sendTime := time.Now()
+		budget := p.Budget(sendTime)
+		for i := 0; i < protocol.MaxPacingBurstPackets; i++ {
+			Expect(p.TimeUntilSend()).To(BeZero())
+			p.SentPacket(sendTime, maxPacketSize)
+			budget -= maxPacketSize
+			Expect(p.Budget(sendTime)).To(Equal(budget))
+		}
+		Expect(p.Budget(sendTime)).To(BeZero())
+		interval := time.Second / packetsPerSecond
+		Expect(p.TimeUntilSend()).To(BeTemporally("~", sendTime.Add(interval), time.Nanosecond))
+		Expect(p.Budget(sendTime.Add(interval))).To(BeNumerically("~", maxPacketSize, 1))
+	})
+
+	It("spaces packets according to the pacing rate", func() {
+		now := time.Now()
+		var sendTimes []time.Time
+		for len(sendTimes) < 3*protocol.MaxPacingBurstPackets {
+			if t := p.TimeUntilSend(); !t.IsZero() {
+				now = t
+			}
+			Expect(p.Budget(now)).To(BeNumerically(">=", maxPacketSize))
+			p.SentPacket(now, maxPacketSize)
+			sendTimes = append(sendTimes, now)
+		}
+		interval := time.Second / packetsPerSecond
+		for i := protocol.MaxPacingBurstPackets; i < len(sendTimes); i++ {
+			Expect(sendTimes[i].Sub(sendTimes[i-1])).To(BeNumerically("~", interval, time.Microsecond))
+		}
+	})
+
+	It("accumulates budget, if no packets are sent", func() {
+		sendTime := time.Now()
+		for i := 0; i < protocol.MaxPacingBurstPackets; i++ {
+			p.SentPacket(sendTime, maxPacketSize)
+		}
+		Expect(p.Budget(sendTime)).To(BeZero())
+		interval := time.Second / packetsPerSecond
+		Expect(p.Budget(sendTime.Add(5 * interval))).To(BeNumerically("~", 5*maxPacketSize, 1))
+	})
+
+	It("caps the budget at the maximum burst size", func() {
+		sendTime := time.Now()
+		p.SentPacket(sendTime, maxPacketSize)
+		Expect(p.Budget(sendTime.Add(time.Hour))).To(BeEquivalentTo(protocol.MaxPacingBurstPackets * maxPacketSize))
+	})
+
+	It("uses a larger burst size for high pacing rates", func() {
+		bandwidth = 1e6 * Bandwidth(maxPacketSize) // 1 million packets per second
+		sendTime := time.Now()
+		p.SentPacket(sendTime, maxPacketSize)
+		Expect(p.Budget(sendTime.Add(time.Hour))).To(BeNumerically(">", protocol.MaxPacingBurstPackets*maxPacketSize))
+		Expect(p.Budget(sendTime.Add(time.Hour))).To(BeEquivalentTo(bandwidth * Bandwidth(2*protocol.MinPacingDelay) / Bandwidth(time.Second)))
+	})
+
+	It("doesn't pace if the pacing rate is 0", func() {
+		bandwidth = 0
+		sendTime := time.Now()
+		for i := 0; i < 5*protocol.MaxPacingBurstPackets; i++ {
+			p.SentPacket(sendTime, maxPacketSize)
+		}
+		Expect(p.TimeUntilSend()).To(BeZero())
+		Expect(p.Budget(sendTime)).To(Equal(protocol.MaxByteCount))
+	})
+})
@@ -21,6 +21,8 @@ type RTTStats struct {
 	latestRTT     time.Duration
 	smoothedRTT   time.Duration
 	meanDeviation time.Duration
+
+	maxAckDelay time.Duration
 }
 
 // NewRTTStats makes a properly initialized RTTStats object
@@ -52,6 +54,15 @@ func (r *RTTStats) SmoothedOrInitialRTT() time.Duration {
 // MeanDeviation gets the mean deviation
 func (r *RTTStats) MeanDeviation() time.Duration { return r.meanDeviation }
 
+// MaxAckDelay gets the max_ack_delay advertised by the peer.
+// It is 0 until the transport parameters have been received.
+func (r *RTTStats) MaxAckDelay() time.Duration { return r.maxAckDelay }
+
+// SetMaxAckDelay sets the max_ack_delay advertised by the peer.
+func (r *RTTStats) SetMaxAckDelay(mad time.Duration) {
+	r.maxAckDelay = mad
+}
+
 // UpdateRTT updates the RTT based on a new sample.
 func (r *RTTStats) UpdateRTT(sendDelta, ackDelay time.Duration, now time.Time) {
 	if sendDelta == utils.InfDuration || sendDelta <= 0 {
@@ -84,6 +95,12 @@ func (r *RTTStats) UpdateRTT(sendDelta, ackDelay time.Duration, now time.Time) {
 	}
 }
 
+// OnPersistentCongestion is called when persistent congestion is detected.
+// The min RTT is reset, such that it is set to the next RTT sample.
+func (r *RTTStats) OnPersistentCongestion() {
+	r.minRTT = 0
+}
+
 // OnConnectionMigration is called when connection migrates and rtt measurement needs to be reset.
 func (r *RTTStats) OnConnectionMigration() {
 	r.latestRTT = 0
@@ -111,6 +111,16 @@ var _ = Describe("RTT stats", func() {
 		}
 	})
 
+	It("resets the min RTT on persistent congestion", func() {
+		rttStats.UpdateRTT(200*time.Millisecond, 0, time.Time{})
+		Expect(rttStats.MinRTT()).To(Equal(200 * time.Millisecond))
+		rttStats.OnPersistentCongestion()
+		Expect(rttStats.MinRTT()).To(BeZero())
+		Expect(rttStats.SmoothedRTT()).To(Equal(200 * time.Millisecond))
+		rttStats.UpdateRTT(300*time.Millisecond, 0, time.Time{})
+		Expect(rttStats.MinRTT()).To(Equal(300 * time.Millisecond))
+	})
+
 	It("ResetAfterConnectionMigrations", func() {
 		rttStats.UpdateRTT((200 * time.Millisecond), 0, time.Time{})
 		Expect(rttStats.LatestRTT()).To(Equal((200 * time.Millisecond)))
@@ -0,0 +1,71 @@
+package congestion
+
+// windowedMaxFilter tracks the maximum bandwidth over a window of round trips.
+// It implements Kathleen Nichols' algorithm for tracking the minimum (or maximum) estimate of a stream of samples over some fixed time interval.
+// It keeps the best, second best and third best estimate, such that the maximum of the window is always available in constant time.
+type windowedMaxFilter struct {
+	window    uint64 // in round trips
+	estimates [3]windowedSample
+}
+
+type windowedSample struct {
+	bandwidth Bandwidth
+	round     uint64
+}
+
+func newWindowedMaxFilter(window uint64) *windowedMaxFilter {
+	return &windowedMaxFilter{window: window}
+}
+
+// Update adds a new sample, taken in the given round trip.
+func (f *windowedMaxFilter) Update(bw Bandwidth, round uint64) {
+	sample := windowedSample{bandwidth: bw, round: round}
+	// Reset all estimates if they have not yet been initialized, if the new sample is a new best,
+	// or if the newest recorded estimate is too old.
+	if f.estimates[0].bandwidth == 0 || bw >= f.estimates[0].bandwidth || round-f.estimates[2].round > f.window {
+		f.Reset(bw, round)
+		return
+	}
+	if bw >= f.estimates[1].bandwidth {
+		f.estimates[1] = sample
+		f.estimates[2] = sample
+	} else if bw >= f.estimates[2].bandwidth {
+		f.estimates[2] = sample
+	}
+
+	// Expire and update estimates as necessary.
+	if round-f.estimates[0].round > f.window {
+		// The best estimate hasn't been updated for an entire window, so promote second and third best estimates.
+		f.estimates[0] = f.estimates[1]
+		f.estimates[1] = f.estimates[2]
+		f.estimates[2] = sample
+		// Need to iterate one more time. Check if the new best estimate is outside the window as well,
+		// since it may also have been recorded a long time ago.
+		if round-f.estimates[0].round > f.window {
+			f.estimates[0] = f.estimates[1]
+			f.estimates[1] = f.estimates[2]
+		}
+		return
+	}
+	if f.estimates[1].bandwidth == f.estimates[0].bandwidth && round-f.estimates[1].round > f.window/4 {
+		// A quarter of the window has passed without a better sample, so the second best estimate is taken from the second quarter of the window.
+		f.estimates[1] = sample
+		f.estimates[2] = sample
+		return
+	}
+	if f.estimates[2].bandwidth == f.estimates[1].bandwidth && round-f.estimates[2].round > f.window/2 {
+		// We've passed half of the window without a better estimate, so take a third best estimate from the second half of the window.
+		f.estimates[2] = sample
+	}
+}
+
+// Reset resets all estimates to the given sample.
+func (f *windowedMaxFilter) Reset(bw Bandwidth, round uint64) {
+	sample := windowedSample{bandwidth: bw, round: round}
+	f.estimates = [3]windowedSample{sample, sample, sample}
+}
+
+// Get returns the maximum bandwidth within the window.
+func (f *windowedMaxFilter) Get() Bandwidth {
+	return f.estimates[0].bandwidth
+}
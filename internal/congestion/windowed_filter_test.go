@@ -0,0 +1,41 @@
+package congestion
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Windowed Max Filter", func() {
+	var f *windowedMaxFilter
+
+	BeforeEach(func() {
+		f = newWindowedMaxFilter(10)
+	})
+
+	It("returns 0 before the first sample", func() {
+		Expect(f.Get()).To(BeZero())
+	})
+
+	It("tracks the maximum", func() {
+		f.Update(100, 0)
+		f.Update(200, 1)
+		f.Update(150, 2)
+		Expect(f.Get()).To(Equal(Bandwidth(200)))
+	})
+
+	It("expires the maximum after the window", func() {
+		f.Update(200, 0)
+		f.Update(150, 3)
+		Expect(f.Get()).To(Equal(Bandwidth(200)))
+		f.Update(100, 11)
+		Expect(f.Get()).To(Equal(Bandwidth(150)))
+		f.Update(100, 14)
+		Expect(f.Get()).To(Equal(Bandwidth(100)))
+	})
+
+	It("resets all estimates when no sample was taken during the window", func() {
+		f.Update(200, 0)
+		f.Update(50, 100)
+		Expect(f.Get()).To(Equal(Bandwidth(50)))
+	})
+})
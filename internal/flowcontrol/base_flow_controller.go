@@ -25,6 +25,7 @@ type baseFlowController struct {
 
 	epochStartTime   time.Time
 	epochStartOffset protocol.ByteCount
+	clock            congestion.Clock
 	rttStats         *congestion.RTTStats
 
 	logger utils.Logger
@@ -85,7 +86,11 @@ func (c *baseFlowController) getWindowUpdate() protocol.ByteCount {
 	if !c.hasWindowUpdate() {
 		return 0
 	}
+	return c.updateReceiveWindow()
+}
 
+// updateReceiveWindow increases the receive window, and returns the new offset
+func (c *baseFlowController) updateReceiveWindow() protocol.ByteCount {
 	c.maybeAdjustWindowSize()
 	c.receiveWindow = c.bytesRead + c.receiveWindowSize
 	return c.receiveWindow
@@ -105,7 +110,7 @@ func (c *baseFlowController) maybeAdjustWindowSize() {
 	}
 
 	fraction := float64(bytesReadInEpoch) / float64(c.receiveWindowSize)
-	if time.Since(c.epochStartTime) < time.Duration(4*fraction*float64(rtt)) {
+	if c.clock.Now().Sub(c.epochStartTime) < time.Duration(4*fraction*float64(rtt)) {
 		// window is consumed too fast, try to increase the window size
 		c.receiveWindowSize = utils.MinByteCount(2*c.receiveWindowSize, c.maxReceiveWindowSize)
 	}
@@ -113,7 +118,7 @@ func (c *baseFlowController) maybeAdjustWindowSize() {
 }
 
 func (c *baseFlowController) startNewAutoTuningEpoch() {
-	c.epochStartTime = time.Now()
+	c.epochStartTime = c.clock.Now()
 	c.epochStartOffset = c.bytesRead
 }
 
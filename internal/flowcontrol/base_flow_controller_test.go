@@ -21,11 +21,16 @@ func scaleDuration(t time.Duration) time.Duration {
 	return time.Duration(scaleFactor) * t
 }
 
+type mockClock time.Time
+
+func (c mockClock) Now() time.Time { return time.Time(c) }
+
 var _ = Describe("Base Flow controller", func() {
 	var controller *baseFlowController
 
 	BeforeEach(func() {
 		controller = &baseFlowController{}
+		controller.clock = congestion.DefaultClock{}
 		controller.rttStats = &congestion.RTTStats{}
 	})
 
@@ -230,6 +235,24 @@ var _ = Describe("Base Flow controller", func() {
 				controller.maybeAdjustWindowSize()
 				Expect(controller.receiveWindowSize).To(Equal(controller.maxReceiveWindowSize)) // 5000
 			})
+
+			It("ramps up the throughput on a path with a high RTT", func() {
+				rtt := 100 * time.Millisecond
+				setRtt(rtt)
+				controller.startNewAutoTuningEpoch()
+				// Simulate a sender that is only limited by flow control:
+				// Every RTT, it sends (and the application reads) the whole receive window.
+				var bytesPerRTT []protocol.ByteCount
+				for i := 0; i < 6; i++ {
+					// pretend that one RTT passed since the last window update
+					controller.epochStartTime = controller.epochStartTime.Add(-rtt)
+					consumed := controller.receiveWindow - controller.bytesRead
+					controller.AddBytesRead(consumed)
+					bytesPerRTT = append(bytesPerRTT, consumed)
+					Expect(controller.getWindowUpdate()).To(Equal(controller.bytesRead + controller.receiveWindowSize))
+				}
+				Expect(bytesPerRTT).To(Equal([]protocol.ByteCount{1000, 2000, 4000, 5000, 5000, 5000}))
+			})
 		})
 	})
 })
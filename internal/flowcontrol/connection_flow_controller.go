@@ -23,11 +23,13 @@ func NewConnectionFlowController(
 	receiveWindow protocol.ByteCount,
 	maxReceiveWindow protocol.ByteCount,
 	queueWindowUpdate func(),
+	clock congestion.Clock,
 	rttStats *congestion.RTTStats,
 	logger utils.Logger,
 ) ConnectionFlowController {
 	return &connectionFlowController{
 		baseFlowController: baseFlowController{
+			clock:                clock,
 			rttStats:             rttStats,
 			receiveWindow:        receiveWindow,
 			receiveWindowSize:    receiveWindow,
@@ -70,15 +72,61 @@ func (c *connectionFlowController) maybeQueueWindowUpdate() {
 
 func (c *connectionFlowController) GetWindowUpdate() protocol.ByteCount {
 	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.hasWindowUpdate() {
+		return 0
+	}
+	return c.updateReceiveWindow()
+}
+
+func (c *connectionFlowController) GetCoalescedWindowUpdate() protocol.ByteCount {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.hasConsumed(protocol.EarlyWindowUpdateThreshold) {
+		return 0
+	}
+	return c.updateReceiveWindow()
+}
+
+func (c *connectionFlowController) updateReceiveWindow() protocol.ByteCount {
 	oldWindowSize := c.receiveWindowSize
-	offset := c.baseFlowController.getWindowUpdate()
+	offset := c.baseFlowController.updateReceiveWindow()
 	if oldWindowSize < c.receiveWindowSize {
 		c.logger.Debugf("Increasing receive flow control window for the connection to %d kB", c.receiveWindowSize/(1<<10))
 	}
-	c.mutex.Unlock()
 	return offset
 }
 
+// hasWindowUpdate says if a window update should be sent.
+// In addition to the threshold used for streams, a window update is sent early
+// if the peer would use up the remaining window (at the current rate) before the window update arrives.
+// It must be called with the mutex held.
+func (c *connectionFlowController) hasWindowUpdate() bool {
+	if c.baseFlowController.hasWindowUpdate() {
+		return true
+	}
+	if !c.hasConsumed(protocol.EarlyWindowUpdateThreshold) {
+		return false
+	}
+	rtt := c.rttStats.SmoothedRTT()
+	bytesReadInEpoch := c.bytesRead - c.epochStartOffset
+	elapsed := c.clock.Now().Sub(c.epochStartTime)
+	if rtt == 0 || bytesReadInEpoch == 0 || elapsed <= 0 {
+		return false
+	}
+	bytesPerRTT := float64(bytesReadInEpoch) * float64(rtt) / float64(elapsed)
+	// The window update arrives at the peer after half an RTT,
+	// and then it takes another half RTT until the data sent in response arrives.
+	// Use twice the RTT to allow for some variance in the rate.
+	return float64(c.receiveWindow-c.bytesRead) <= 2*bytesPerRTT
+}
+
+// hasConsumed says if at least the given fraction of the receive window was consumed.
+func (c *connectionFlowController) hasConsumed(fraction float64) bool {
+	bytesRemaining := c.receiveWindow - c.bytesRead
+	return bytesRemaining <= protocol.ByteCount(float64(c.receiveWindowSize)*(1-fraction))
+}
+
 // EnsureMinimumWindowSize sets a minimum window size
 // it should make sure that the connection-level window is increased when a stream-level window grows
 func (c *connectionFlowController) EnsureMinimumWindowSize(inc protocol.ByteCount) {
@@ -25,6 +25,7 @@ var _ = Describe("Connection Flow controller", func() {
 	BeforeEach(func() {
 		queuedWindowUpdate = false
 		controller = &connectionFlowController{}
+		controller.clock = congestion.DefaultClock{}
 		controller.rttStats = &congestion.RTTStats{}
 		controller.logger = utils.DefaultLogger
 		controller.queueWindowUpdate = func() { queuedWindowUpdate = true }
@@ -37,7 +38,7 @@ var _ = Describe("Connection Flow controller", func() {
 			receiveWindow := protocol.ByteCount(2000)
 			maxReceiveWindow := protocol.ByteCount(3000)
 
-			fc := NewConnectionFlowController(receiveWindow, maxReceiveWindow, nil, rttStats, utils.DefaultLogger).(*connectionFlowController)
+			fc := NewConnectionFlowController(receiveWindow, maxReceiveWindow, nil, congestion.DefaultClock{}, rttStats, utils.DefaultLogger).(*connectionFlowController)
 			Expect(fc.receiveWindow).To(Equal(receiveWindow))
 			Expect(fc.maxReceiveWindowSize).To(Equal(maxReceiveWindow))
 		})
@@ -93,6 +94,114 @@ var _ = Describe("Connection Flow controller", func() {
 				Expect(offset).To(Equal(protocol.ByteCount(oldOffset + dataRead + newWindowSize)))
 			})
 		})
+
+		Context("sending window updates early", func() {
+			const rtt = 40 * time.Millisecond
+
+			BeforeEach(func() {
+				setRtt(rtt)
+				controller.receiveWindow = 1000
+				controller.receiveWindowSize = 1000
+				controller.maxReceiveWindowSize = 1000 // disable auto-tuning
+			})
+
+			It("sends a window update early, if the peer would use up the window before the window update arrives", func() {
+				controller.AddBytesRead(1)
+				// 200 bytes were read within a quarter of an RTT
+				controller.epochStartTime = time.Now().Add(-rtt / 4)
+				controller.AddBytesRead(199)
+				Expect(queuedWindowUpdate).To(BeTrue())
+				Expect(controller.GetWindowUpdate()).To(Equal(protocol.ByteCount(200 + 1000)))
+			})
+
+			It("doesn't send a window update early, if the peer is sending slowly", func() {
+				controller.AddBytesRead(1)
+				// 200 bytes were read within 4 RTTs
+				controller.epochStartTime = time.Now().Add(-4 * rtt)
+				controller.AddBytesRead(199)
+				Expect(queuedWindowUpdate).To(BeFalse())
+				Expect(controller.GetWindowUpdate()).To(BeZero())
+			})
+
+			It("uses the clock to determine the rate", func() {
+				now := time.Now().Add(time.Hour)
+				controller.clock = mockClock(now)
+				controller.AddBytesRead(1)
+				// 200 bytes were read within 4 RTTs, according to the clock
+				controller.epochStartTime = now.Add(-4 * rtt)
+				controller.AddBytesRead(199)
+				Expect(queuedWindowUpdate).To(BeFalse())
+				controller.epochStartTime = now.Add(-rtt / 4)
+				controller.AddBytesRead(1)
+				Expect(queuedWindowUpdate).To(BeTrue())
+			})
+
+			It("doesn't send a window update early, if only a small part of the window was consumed", func() {
+				controller.AddBytesRead(1)
+				controller.epochStartTime = time.Now().Add(-rtt / 10)
+				controller.AddBytesRead(99)
+				Expect(queuedWindowUpdate).To(BeFalse())
+				Expect(controller.GetWindowUpdate()).To(BeZero())
+			})
+
+			It("gets a coalesced window update", func() {
+				controller.AddBytesRead(100)
+				Expect(controller.GetCoalescedWindowUpdate()).To(BeZero())
+				controller.AddBytesRead(25)
+				Expect(controller.GetCoalescedWindowUpdate()).To(Equal(protocol.ByteCount(125 + 1000)))
+				Expect(controller.GetCoalescedWindowUpdate()).To(BeZero())
+			})
+
+			It("sustains the throughput if the peer is sending at a high rate", func() {
+				// The peer sends 2 packets per millisecond, i.e. 80 kB per RTT, using 80% of the window.
+				// Without early window updates, the window update is sent after 25% of the window was consumed,
+				// and the peer would be blocked by flow control for a short time after every window update.
+				const (
+					tick           = time.Millisecond
+					packetSize     = protocol.ByteCount(1000)
+					packetsPerTick = 2
+					window         = 100 * packetSize
+					numTicks       = 2000
+				)
+				controller.receiveWindow = window
+				controller.receiveWindowSize = window
+				controller.maxReceiveWindowSize = window
+
+				oneWayDelay := int(rtt / tick / 2)
+				arrivals := make(map[int]int)                     // tick -> number of packets arriving at the receiver
+				windowUpdates := make(map[int]protocol.ByteCount) // tick -> offset arriving at the peer
+				sendWindow := window
+				var bytesSent protocol.ByteCount
+				var numBlocked int
+				for t := 0; t < numTicks; t++ {
+					if offset, ok := windowUpdates[t]; ok && offset > sendWindow {
+						sendWindow = offset
+					}
+					for i := 0; i < packetsPerTick; i++ {
+						if bytesSent+packetSize > sendWindow {
+							numBlocked++
+							break
+						}
+						bytesSent += packetSize
+						arrivals[t+oneWayDelay]++
+					}
+					for i := 0; i < arrivals[t]; i++ {
+						Expect(controller.IncrementHighestReceived(packetSize)).To(Succeed())
+						controller.AddBytesRead(packetSize)
+						if queuedWindowUpdate {
+							queuedWindowUpdate = false
+							windowUpdates[t+oneWayDelay] = controller.GetWindowUpdate()
+						}
+					}
+					// advance the time by one tick
+					controller.epochStartTime = controller.epochStartTime.Add(-tick)
+				}
+				Expect(numBlocked).To(BeZero())
+				Expect(bytesSent).To(Equal(numTicks * packetsPerTick * packetSize))
+				// make sure that we're not sending a window update for every packet
+				Expect(len(windowUpdates)).To(BeNumerically("<=", int(bytesSent/(window/8))+1))
+			})
+		})
 	})
 
 	Context("setting the minimum window size", func() {
@@ -29,6 +29,10 @@ type StreamFlowController interface {
 // The ConnectionFlowController is the flow controller for the connection.
 type ConnectionFlowController interface {
 	flowController
+	// GetCoalescedWindowUpdate returns a window update if a window update will be necessary soon.
+	// It is used to send a connection-level window update in the same packet as stream-level window updates.
+	// It returns 0 if no update is necessary.
+	GetCoalescedWindowUpdate() protocol.ByteCount
 }
 
 type connectionFlowControllerI interface {
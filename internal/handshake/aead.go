@@ -33,7 +33,7 @@ func newSealer(aead cipher.AEAD, hpEncrypter cipher.Block, is1RTT bool) Sealer {
 
 func (s *sealer) Seal(dst, src []byte, pn protocol.PacketNumber, ad []byte) []byte {
 	binary.BigEndian.PutUint64(s.nonceBuf[len(s.nonceBuf)-8:], uint64(pn))
-	// The AEAD we're using here will be the qtls.aeadAESGCM13.
+	// The AEAD we're using here is created by qtls (AES-GCM or ChaCha20-Poly1305).
 	// It uses the nonce provided here and XOR it with the IV.
 	return s.aead.Seal(dst, s.nonceBuf, src, ad)
 }
@@ -83,7 +83,7 @@ func newOpener(aead cipher.AEAD, pnDecrypter cipher.Block, is1RTT bool) Opener {
 
 func (o *opener) Open(dst, src []byte, pn protocol.PacketNumber, ad []byte) ([]byte, error) {
 	binary.BigEndian.PutUint64(o.nonceBuf[len(o.nonceBuf)-8:], uint64(pn))
-	// The AEAD we're using here will be the qtls.aeadAESGCM13.
+	// The AEAD we're using here is created by qtls (AES-GCM or ChaCha20-Poly1305).
 	// It uses the nonce provided here and XOR it with the IV.
 	return o.aead.Open(dst, o.nonceBuf, src, ad)
 }
@@ -0,0 +1,126 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/lucas-clemente/quic-go/internal/utils"
+)
+
+const (
+	extensionTypeServerName  = 0
+	serverNameTypeHostName   = 0
+	clientHelloRandomLen     = 32
+	clientHelloLegacyVersion = 2
+)
+
+// ParseServerName parses the server name (SNI) from a ClientHello.
+// It returns an empty string if the ClientHello doesn't contain a server_name extension.
+// The ClientHello may be truncated, as long as the server_name extension is complete.
+func ParseServerName(data []byte) (string, error) {
+	r := bytes.NewReader(data)
+	msgType, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if messageType(msgType) != typeClientHello {
+		return "", errors.New("not a ClientHello")
+	}
+	// The length of the message might not match the data, if the ClientHello is truncated.
+	if _, err := utils.BigEndian.ReadUintN(r, 3); err != nil {
+		return "", err
+	}
+	if err := skip(r, clientHelloLegacyVersion+clientHelloRandomLen); err != nil {
+		return "", err
+	}
+	sessionIDLen, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if err := skip(r, int(sessionIDLen)); err != nil {
+		return "", err
+	}
+	cipherSuitesLen, err := utils.BigEndian.ReadUint16(r)
+	if err != nil {
+		return "", err
+	}
+	if err := skip(r, int(cipherSuitesLen)); err != nil {
+		return "", err
+	}
+	compressionMethodsLen, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if err := skip(r, int(compressionMethodsLen)); err != nil {
+		return "", err
+	}
+	extensionsLen, err := utils.BigEndian.ReadUint16(r)
+	if err != nil {
+		return "", err
+	}
+	remaining := int(extensionsLen)
+	for remaining > 0 {
+		extType, err := utils.BigEndian.ReadUint16(r)
+		if err != nil {
+			return "", err
+		}
+		extLen, err := utils.BigEndian.ReadUint16(r)
+		if err != nil {
+			return "", err
+		}
+		remaining -= 4 + int(extLen)
+		if remaining < 0 {
+			return "", errors.New("extension exceeds the extensions")
+		}
+		if extType != extensionTypeServerName {
+			if err := skip(r, int(extLen)); err != nil {
+				return "", err
+			}
+			continue
+		}
+		ext := make([]byte, extLen)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return "", err
+		}
+		return parseServerNameExtension(ext)
+	}
+	return "", nil
+}
+
+func parseServerNameExtension(data []byte) (string, error) {
+	r := bytes.NewReader(data)
+	listLen, err := utils.BigEndian.ReadUint16(r)
+	if err != nil {
+		return "", err
+	}
+	if int(listLen) != r.Len() {
+		return "", errors.New("invalid server_name extension")
+	}
+	for r.Len() > 0 {
+		nameType, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		nameLen, err := utils.BigEndian.ReadUint16(r)
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		if nameType == serverNameTypeHostName {
+			return string(name), nil
+		}
+	}
+	return "", nil
+}
+
+func skip(r *bytes.Reader, n int) error {
+	if r.Len() < n {
+		return io.EOF
+	}
+	_, err := r.Seek(int64(n), io.SeekCurrent)
+	return err
+}
@@ -0,0 +1,60 @@
+package handshake
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClientHello", func() {
+	// getClientHello returns the ClientHello sent by a crypto/tls client
+	getClientHello := func(conf *tls.Config) []byte {
+		client, server := net.Pipe()
+		defer server.Close()
+		go tls.Client(client, conf).Handshake()
+		recordHdr := make([]byte, 5)
+		_, err := io.ReadFull(server, recordHdr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recordHdr[0]).To(BeEquivalentTo(0x16)) // handshake record
+		data := make([]byte, int(recordHdr[3])<<8+int(recordHdr[4]))
+		_, err = io.ReadFull(server, data)
+		Expect(err).ToNot(HaveOccurred())
+		client.Close()
+		return data
+	}
+
+	It("parses the server name", func() {
+		name, err := ParseServerName(getClientHello(&tls.Config{ServerName: "quic.clemente.io"}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(Equal("quic.clemente.io"))
+	})
+
+	It("returns an empty string if no server name is sent", func() {
+		name, err := ParseServerName(getClientHello(&tls.Config{InsecureSkipVerify: true}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(BeEmpty())
+	})
+
+	It("parses a truncated ClientHello", func() {
+		data := getClientHello(&tls.Config{ServerName: "quic.clemente.io"})
+		var found bool
+		for i := 0; i < len(data); i++ {
+			name, err := ParseServerName(data[:i])
+			if err != nil {
+				Expect(name).To(BeEmpty())
+				continue
+			}
+			Expect(name).To(Equal("quic.clemente.io"))
+			found = true
+		}
+		Expect(found).To(BeTrue())
+	})
+
+	It("errors on messages that are not a ClientHello", func() {
+		_, err := ParseServerName([]byte{2, 0, 0, 0})
+		Expect(err).To(MatchError("not a ClientHello"))
+	})
+})
@@ -1,12 +1,14 @@
 package handshake
 
 import (
+	"bytes"
 	"encoding/asn1"
+	"errors"
 	"fmt"
 	"net"
 	"time"
 
-	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 )
 
 const (
@@ -14,58 +16,77 @@ const (
 	cookiePrefixString
 )
 
-// A Cookie is derived from the client address and can be used to verify the ownership of this address.
-type Cookie struct {
-	RemoteAddr               string
-	OriginalDestConnectionID protocol.ConnectionID
-	// The time that the Cookie was issued (resolution 1 second)
-	SentTime time.Time
-}
-
 // token is the struct that is used for ASN1 serialization and deserialization
 type token struct {
-	RemoteAddr               []byte
-	OriginalDestConnectionID []byte
+	RemoteAddr []byte
+	Data       []byte
 
 	Timestamp int64
 }
 
-// A CookieGenerator generates Cookies
+// A Token is the content of a Cookie, as returned by Validate.
+type Token struct {
+	// RemoteAddr is the address that the Cookie was issued for.
+	// For UDP addresses, this is the IP address (or the network, see NewCookieGenerator), without the port.
+	RemoteAddr string
+	// SentTime is the time when the Cookie was issued.
+	SentTime time.Time
+	// Data is the data passed to Generate.
+	Data []byte
+}
+
+// A CookieGenerator generates and validates Cookies.
+// A Cookie is bound to the client's IP address (or the network the address is in),
+// and is only valid for a limited time.
 type CookieGenerator struct {
 	cookieProtector cookieProtector
+	clock           congestion.Clock
+
+	lifetime time.Duration
+	ipv4Mask net.IPMask
+	ipv6Mask net.IPMask
 }
 
-// NewCookieGenerator initializes a new CookieGenerator
-func NewCookieGenerator() (*CookieGenerator, error) {
-	cookieProtector, err := newCookieProtector()
+// NewCookieGenerator initializes a new CookieGenerator.
+// If no key is given, a random key is used.
+// Cookies are bound to the first ipv4PrefixLen bits of an IPv4 address, and the first ipv6PrefixLen bits of an IPv6 address.
+// The clock is used to set and check the timestamp of the Cookies.
+func NewCookieGenerator(key []byte, lifetime time.Duration, ipv4PrefixLen, ipv6PrefixLen int, clock congestion.Clock) (*CookieGenerator, error) {
+	if ipv4PrefixLen < 0 || ipv4PrefixLen > 8*net.IPv4len {
+		return nil, fmt.Errorf("invalid IPv4 prefix length: %d", ipv4PrefixLen)
+	}
+	if ipv6PrefixLen < 0 || ipv6PrefixLen > 8*net.IPv6len {
+		return nil, fmt.Errorf("invalid IPv6 prefix length: %d", ipv6PrefixLen)
+	}
+	cookieProtector, err := newCookieProtector(key)
 	if err != nil {
 		return nil, err
 	}
 	return &CookieGenerator{
 		cookieProtector: cookieProtector,
+		clock:           clock,
+		lifetime:        lifetime,
+		ipv4Mask:        net.CIDRMask(ipv4PrefixLen, 8*net.IPv4len),
+		ipv6Mask:        net.CIDRMask(ipv6PrefixLen, 8*net.IPv6len),
 	}, nil
 }
 
-// NewToken generates a new Cookie for a given source address
-func (g *CookieGenerator) NewToken(raddr net.Addr, origConnID protocol.ConnectionID) ([]byte, error) {
-	data, err := asn1.Marshal(token{
-		RemoteAddr:               encodeRemoteAddr(raddr),
-		OriginalDestConnectionID: origConnID,
-		Timestamp:                time.Now().Unix(),
+// Generate generates a new Cookie for a given source address.
+// The data is saved in the Cookie, and returned by Validate.
+func (g *CookieGenerator) Generate(raddr net.Addr, data []byte) ([]byte, error) {
+	t, err := asn1.Marshal(token{
+		RemoteAddr: g.encodeRemoteAddr(raddr),
+		Data:       data,
+		Timestamp:  g.clock.Now().Unix(),
 	})
 	if err != nil {
 		return nil, err
 	}
-	return g.cookieProtector.NewToken(data)
+	return g.cookieProtector.NewToken(t)
 }
 
-// DecodeToken decodes a Cookie
-func (g *CookieGenerator) DecodeToken(encrypted []byte) (*Cookie, error) {
-	// if the client didn't send any Cookie, DecodeToken will be called with a nil-slice
-	if len(encrypted) == 0 {
-		return nil, nil
-	}
-
+// Validate decodes a Cookie, and checks that it was issued for the source address and that it hasn't expired.
+func (g *CookieGenerator) Validate(encrypted []byte, raddr net.Addr) (*Token, error) {
 	data, err := g.cookieProtector.DecodeToken(encrypted)
 	if err != nil {
 		return nil, err
@@ -78,27 +99,33 @@ func (g *CookieGenerator) DecodeToken(encrypted []byte) (*Cookie, error) {
 	if len(rest) != 0 {
 		return nil, fmt.Errorf("rest when unpacking token: %d", len(rest))
 	}
-	cookie := &Cookie{
-		RemoteAddr: decodeRemoteAddr(t.RemoteAddr),
-		SentTime:   time.Unix(t.Timestamp, 0),
+	// The time resolution of the Cookie is just 1 second.
+	if g.clock.Now().After(time.Unix(t.Timestamp, 0).Add(g.lifetime)) {
+		return nil, errors.New("cookie expired")
 	}
-	if len(t.OriginalDestConnectionID) > 0 {
-		cookie.OriginalDestConnectionID = protocol.ConnectionID(t.OriginalDestConnectionID)
+	if !bytes.Equal(t.RemoteAddr, g.encodeRemoteAddr(raddr)) {
+		return nil, errors.New("cookie issued for a different address")
 	}
-	return cookie, nil
+	return &Token{
+		RemoteAddr: decodeRemoteAddr(t.RemoteAddr),
+		SentTime:   time.Unix(t.Timestamp, 0),
+		Data:       t.Data,
+	}, nil
 }
 
 // encodeRemoteAddr encodes a remote address such that it can be saved in the Cookie
-func encodeRemoteAddr(remoteAddr net.Addr) []byte {
+func (g *CookieGenerator) encodeRemoteAddr(remoteAddr net.Addr) []byte {
 	if udpAddr, ok := remoteAddr.(*net.UDPAddr); ok {
-		return append([]byte{cookiePrefixIP}, udpAddr.IP...)
+		if ip := udpAddr.IP.To4(); ip != nil {
+			return append([]byte{cookiePrefixIP}, ip.Mask(g.ipv4Mask)...)
+		}
+		return append([]byte{cookiePrefixIP}, udpAddr.IP.Mask(g.ipv6Mask)...)
 	}
 	return append([]byte{cookiePrefixString}, []byte(remoteAddr.String())...)
 }
 
 // decodeRemoteAddr decodes the remote address saved in the Cookie
 func decodeRemoteAddr(data []byte) string {
-	// data will never be empty for a Cookie that we generated. Check it to be on the safe side
 	if len(data) == 0 {
 		return ""
 	}
@@ -5,70 +5,149 @@ import (
 	"net"
 	"time"
 
-	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+type mockClock struct{ now time.Time }
+
+func (c *mockClock) Now() time.Time { return c.now }
+
 var _ = Describe("Cookie Generator", func() {
 	var cookieGen *CookieGenerator
 
 	BeforeEach(func() {
 		var err error
-		cookieGen, err = NewCookieGenerator()
+		cookieGen, err = NewCookieGenerator(nil, time.Hour, 32, 128, congestion.DefaultClock{})
 		Expect(err).ToNot(HaveOccurred())
 	})
 
 	It("generates a Cookie", func() {
 		ip := net.IPv4(127, 0, 0, 1)
-		token, err := cookieGen.NewToken(&net.UDPAddr{IP: ip, Port: 1337}, nil)
+		token, err := cookieGen.Generate(&net.UDPAddr{IP: ip, Port: 1337}, nil)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(token).ToNot(BeEmpty())
 	})
 
-	It("works with nil tokens", func() {
-		cookie, err := cookieGen.DecodeToken(nil)
+	It("accepts a valid cookie", func() {
+		raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		token, err := cookieGen.Generate(raddr, nil)
 		Expect(err).ToNot(HaveOccurred())
-		Expect(cookie).To(BeNil())
+		t, err := cookieGen.Validate(token, raddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.RemoteAddr).To(Equal("192.168.0.1"))
+		Expect(t.SentTime).To(BeTemporally("~", time.Now(), time.Second))
+		Expect(t.Data).To(BeEmpty())
 	})
 
-	It("accepts a valid cookie", func() {
-		ip := net.IPv4(192, 168, 0, 1)
-		token, err := cookieGen.NewToken(
-			&net.UDPAddr{IP: ip, Port: 1337},
-			nil,
-		)
+	It("saves data", func() {
+		raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		token, err := cookieGen.Generate(raddr, []byte{0xde, 0xad, 0xbe, 0xef})
+		Expect(err).ToNot(HaveOccurred())
+		t, err := cookieGen.Validate(token, raddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Data).To(Equal([]byte{0xde, 0xad, 0xbe, 0xef}))
+	})
+
+	It("accepts cookies when the port changes", func() {
+		token, err := cookieGen.Generate(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}, nil)
 		Expect(err).ToNot(HaveOccurred())
-		cookie, err := cookieGen.DecodeToken(token)
+		_, err = cookieGen.Validate(token, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 7331})
 		Expect(err).ToNot(HaveOccurred())
-		Expect(cookie.RemoteAddr).To(Equal("192.168.0.1"))
-		// the time resolution of the Cookie is just 1 second
-		// if Cookie generation and this check happen in "different seconds", the difference will be between 1 and 2 seconds
-		Expect(cookie.SentTime).To(BeTemporally("~", time.Now(), 2*time.Second))
-		Expect(cookie.OriginalDestConnectionID).To(BeNil())
 	})
 
-	It("saves the connection ID", func() {
-		token, err := cookieGen.NewToken(
-			&net.UDPAddr{},
-			protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
-		)
+	It("rejects cookies issued for a different address", func() {
+		token, err := cookieGen.Generate(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}, nil)
 		Expect(err).ToNot(HaveOccurred())
-		cookie, err := cookieGen.DecodeToken(token)
+		_, err = cookieGen.Validate(token, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1337})
+		Expect(err).To(MatchError("cookie issued for a different address"))
+	})
+
+	It("uses the clock for the timestamp", func() {
+		clock := &mockClock{now: time.Now().Add(-time.Hour)}
+		var err error
+		cookieGen, err = NewCookieGenerator(nil, time.Hour, 32, 128, clock)
+		Expect(err).ToNot(HaveOccurred())
+		raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		token, err := cookieGen.Generate(raddr, nil)
+		Expect(err).ToNot(HaveOccurred())
+		t, err := cookieGen.Validate(token, raddr)
 		Expect(err).ToNot(HaveOccurred())
-		Expect(cookie.OriginalDestConnectionID).To(Equal(protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef}))
+		Expect(t.SentTime).To(BeTemporally("~", clock.now, time.Second))
+		clock.now = clock.now.Add(time.Hour + 2*time.Second)
+		_, err = cookieGen.Validate(token, raddr)
+		Expect(err).To(MatchError("cookie expired"))
+	})
+
+	It("rejects expired cookies", func() {
+		cookieGen.lifetime = -time.Second
+		raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		token, err := cookieGen.Generate(raddr, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cookieGen.Validate(token, raddr)
+		Expect(err).To(MatchError("cookie expired"))
+	})
+
+	It("validates cookies generated by a different cookie generator using the same key", func() {
+		key := make([]byte, CookieKeySize)
+		cookieGen1, err := NewCookieGenerator(key, time.Hour, 32, 128, congestion.DefaultClock{})
+		Expect(err).ToNot(HaveOccurred())
+		cookieGen2, err := NewCookieGenerator(key, time.Hour, 32, 128, congestion.DefaultClock{})
+		Expect(err).ToNot(HaveOccurred())
+		raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		token, err := cookieGen1.Generate(raddr, []byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		t, err := cookieGen2.Validate(token, raddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t.Data).To(Equal([]byte("foobar")))
+	})
+
+	It("errors on invalid prefix lengths", func() {
+		_, err := NewCookieGenerator(nil, time.Hour, 33, 128, congestion.DefaultClock{})
+		Expect(err).To(MatchError("invalid IPv4 prefix length: 33"))
+		_, err = NewCookieGenerator(nil, time.Hour, 32, -1, congestion.DefaultClock{})
+		Expect(err).To(MatchError("invalid IPv6 prefix length: -1"))
+	})
+
+	Context("binding cookies to networks", func() {
+		BeforeEach(func() {
+			var err error
+			cookieGen, err = NewCookieGenerator(nil, time.Hour, 24, 48, congestion.DefaultClock{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("accepts cookies from the same IPv4 network", func() {
+			token, err := cookieGen.Generate(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			t, err := cookieGen.Validate(token, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Port: 1337})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t.RemoteAddr).To(Equal("192.168.0.0"))
+			_, err = cookieGen.Validate(token, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 1337})
+			Expect(err).To(MatchError("cookie issued for a different address"))
+		})
+
+		It("accepts cookies from the same IPv6 network", func() {
+			token, err := cookieGen.Generate(&net.UDPAddr{IP: net.ParseIP("2001:db8:1234::1"), Port: 1337}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			t, err := cookieGen.Validate(token, &net.UDPAddr{IP: net.ParseIP("2001:db8:1234:5678::42"), Port: 1337})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t.RemoteAddr).To(Equal("2001:db8:1234::"))
+			_, err = cookieGen.Validate(token, &net.UDPAddr{IP: net.ParseIP("2001:db8:1235::1"), Port: 1337})
+			Expect(err).To(MatchError("cookie issued for a different address"))
+		})
 	})
 
 	It("rejects invalid tokens", func() {
-		_, err := cookieGen.DecodeToken([]byte("invalid token"))
+		_, err := cookieGen.Validate([]byte("invalid token"), &net.UDPAddr{})
 		Expect(err).To(HaveOccurred())
 	})
 
 	It("rejects tokens that cannot be decoded", func() {
 		token, err := cookieGen.cookieProtector.NewToken([]byte("foobar"))
 		Expect(err).ToNot(HaveOccurred())
-		_, err = cookieGen.DecodeToken(token)
+		_, err = cookieGen.Validate(token, &net.UDPAddr{})
 		Expect(err).To(HaveOccurred())
 	})
 
@@ -78,21 +157,21 @@ var _ = Describe("Cookie Generator", func() {
 		t = append(t, []byte("rest")...)
 		enc, err := cookieGen.cookieProtector.NewToken(t)
 		Expect(err).ToNot(HaveOccurred())
-		_, err = cookieGen.DecodeToken(enc)
+		_, err = cookieGen.Validate(enc, &net.UDPAddr{})
 		Expect(err).To(MatchError("rest when unpacking token: 4"))
 	})
 
 	// we don't generate tokens that have no data, but we should be able to handle them if we receive one for whatever reason
 	It("doesn't panic if a tokens has no data", func() {
-		t, err := asn1.Marshal(token{RemoteAddr: []byte("")})
+		t, err := asn1.Marshal(token{RemoteAddr: []byte(""), Timestamp: time.Now().Unix()})
 		Expect(err).ToNot(HaveOccurred())
 		enc, err := cookieGen.cookieProtector.NewToken(t)
 		Expect(err).ToNot(HaveOccurred())
-		_, err = cookieGen.DecodeToken(enc)
-		Expect(err).ToNot(HaveOccurred())
+		_, err = cookieGen.Validate(enc, &net.UDPAddr{})
+		Expect(err).To(MatchError("cookie issued for a different address"))
 	})
 
-	It("works with an IPv6 addresses ", func() {
+	It("works with IPv6 addresses", func() {
 		addresses := []string{
 			"2001:db8::68",
 			"2001:0000:4136:e378:8000:63bf:3fff:fdd2",
@@ -103,26 +182,22 @@ var _ = Describe("Cookie Generator", func() {
 			ip := net.ParseIP(addr)
 			Expect(ip).ToNot(BeNil())
 			raddr := &net.UDPAddr{IP: ip, Port: 1337}
-			token, err := cookieGen.NewToken(raddr, nil)
+			token, err := cookieGen.Generate(raddr, nil)
 			Expect(err).ToNot(HaveOccurred())
-			cookie, err := cookieGen.DecodeToken(token)
+			t, err := cookieGen.Validate(token, raddr)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(cookie.RemoteAddr).To(Equal(ip.String()))
-			// the time resolution of the Cookie is just 1 second
-			// if Cookie generation and this check happen in "different seconds", the difference will be between 1 and 2 seconds
-			Expect(cookie.SentTime).To(BeTemporally("~", time.Now(), 2*time.Second))
+			Expect(t.RemoteAddr).To(Equal(ip.String()))
 		}
 	})
 
 	It("uses the string representation an address that is not a UDP address", func() {
 		raddr := &net.TCPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1337}
-		token, err := cookieGen.NewToken(raddr, nil)
+		token, err := cookieGen.Generate(raddr, nil)
 		Expect(err).ToNot(HaveOccurred())
-		cookie, err := cookieGen.DecodeToken(token)
+		t, err := cookieGen.Validate(token, raddr)
 		Expect(err).ToNot(HaveOccurred())
-		Expect(cookie.RemoteAddr).To(Equal("192.168.13.37:1337"))
-		// the time resolution of the Cookie is just 1 second
-		// if Cookie generation and this check happen in "different seconds", the difference will be between 1 and 2 seconds
-		Expect(cookie.SentTime).To(BeTemporally("~", time.Now(), 2*time.Second))
+		Expect(t.RemoteAddr).To(Equal("192.168.13.37:1337"))
+		_, err = cookieGen.Validate(token, &net.TCPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 7331})
+		Expect(err).To(MatchError("cookie issued for a different address"))
 	})
 })
@@ -20,8 +20,9 @@ type cookieProtector interface {
 }
 
 const (
-	cookieSecretSize = 32
-	cookieNonceSize  = 32
+	// CookieKeySize is the size of the key used to protect the cookies.
+	CookieKeySize   = 32
+	cookieNonceSize = 32
 )
 
 // cookieProtector is used to create and verify a cookie
@@ -30,12 +31,18 @@ type cookieProtectorImpl struct {
 }
 
 // newCookieProtector creates a source for source address tokens
-func newCookieProtector() (cookieProtector, error) {
-	secret := make([]byte, cookieSecretSize)
-	if _, err := rand.Read(secret); err != nil {
-		return nil, err
+// If no key is given, a random key is used.
+func newCookieProtector(key []byte) (cookieProtector, error) {
+	if key == nil {
+		key = make([]byte, CookieKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	if len(key) != CookieKeySize {
+		return nil, fmt.Errorf("invalid cookie key length: %d bytes, expected %d", len(key), CookieKeySize)
 	}
-	return &cookieProtectorImpl{secret: secret}, nil
+	return &cookieProtectorImpl{secret: key}, nil
 }
 
 // NewToken encodes data into a new token.
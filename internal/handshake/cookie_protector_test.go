@@ -1,6 +1,8 @@
 package handshake
 
 import (
+	"bytes"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -10,7 +12,7 @@ var _ = Describe("Cookie Protector", func() {
 
 	BeforeEach(func() {
 		var err error
-		cp, err = newCookieProtector()
+		cp, err = newCookieProtector(nil)
 		Expect(err).ToNot(HaveOccurred())
 	})
 
@@ -32,6 +34,27 @@ var _ = Describe("Cookie Protector", func() {
 		Expect(err.Error()).To(ContainSubstring("message authentication failed"))
 	})
 
+	It("decodes tokens created by a different cookie protector using the same key", func() {
+		key := bytes.Repeat([]byte{0x42}, CookieKeySize)
+		cp1, err := newCookieProtector(key)
+		Expect(err).ToNot(HaveOccurred())
+		cp2, err := newCookieProtector(key)
+		Expect(err).ToNot(HaveOccurred())
+		token, err := cp1.NewToken([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		decoded, err := cp2.DecodeToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded).To(Equal([]byte("foobar")))
+		// a cookie protector using a random key can't decode the token
+		_, err = cp.DecodeToken(token)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("errors when the key has the wrong length", func() {
+		_, err := newCookieProtector([]byte("foobar"))
+		Expect(err).To(MatchError("invalid cookie key length: 6 bytes, expected 32"))
+	})
+
 	It("errors when decoding too short tokens", func() {
 		_, err := cp.DecodeToken([]byte("foobar"))
 		Expect(err).To(MatchError("Token too short: 6"))
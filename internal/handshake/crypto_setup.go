@@ -1,13 +1,16 @@
 package handshake
 
 import (
-	"crypto/aes"
+	"crypto"
+	"crypto/cipher"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/marten-seemann/qtls"
 )
@@ -56,12 +59,19 @@ type cryptoSetup struct {
 	conn    *qtls.Conn
 
 	messageChan chan []byte
+	// the type of the last message that was passed to qtls
+	// only accessed from the go routine running qtls.Handshake()
+	lastMessageType messageType
 
 	readEncLevel  protocol.EncryptionLevel
 	writeEncLevel protocol.EncryptionLevel
 
 	handleParamsCallback func(*TransportParameters)
 
+	// the transport parameters sent by the peer, for the ConnectionState
+	peerParamsMutex sync.Mutex
+	peerParams      *TransportParameters
+
 	// There are two ways that an error can occur during the handshake:
 	// 1. as a return value from qtls.Handshake()
 	// 2. when new data is passed to the crypto setup via HandleData()
@@ -91,6 +101,11 @@ type cryptoSetup struct {
 	sealer Sealer
 	// TODO: add a 1-RTT stream (used for session tickets)
 
+	// the secret that keying material is exported from, see ExportKeyingMaterial
+	exporterMutex  sync.Mutex
+	exporterHash   crypto.Hash
+	exporterSecret []byte
+
 	receivedWriteKey chan struct{}
 	receivedReadKey  chan struct{}
 
@@ -112,7 +127,7 @@ func NewCryptoSetupClient(
 	handleParams func(*TransportParameters),
 	tlsConf *tls.Config,
 	initialVersion protocol.VersionNumber,
-	supportedVersions []protocol.VersionNumber,
+	chooseVersion func(theirs []protocol.VersionNumber) (protocol.VersionNumber, bool),
 	currentVersion protocol.VersionNumber,
 	logger utils.Logger,
 	perspective protocol.Perspective,
@@ -121,7 +136,7 @@ func NewCryptoSetupClient(
 		params,
 		origConnID,
 		initialVersion,
-		supportedVersions,
+		chooseVersion,
 		currentVersion,
 		logger,
 	)
@@ -215,11 +230,7 @@ func newCryptoSetup(
 		receivedWriteKey:        make(chan struct{}),
 		closeChan:               make(chan struct{}),
 	}
-	qtlsConf := tlsConfigToQtlsConfig(tlsConf)
-	qtlsConf.AlternativeRecordLayer = cs
-	qtlsConf.GetExtensions = extHandler.GetExtensions
-	qtlsConf.ReceivedExtensions = extHandler.ReceivedExtensions
-	cs.tlsConf = qtlsConf
+	cs.tlsConf = tlsConfigToQtlsConfig(tlsConf, cs, extHandler)
 	return cs, cs.clientHelloWrittenChan, nil
 }
 
@@ -230,6 +241,10 @@ func (h *cryptoSetup) RunHandshake() error {
 	go func() {
 		defer close(h.handshakeDone)
 		if err := h.conn.Handshake(); err != nil {
+			handshakeErrChan <- h.handshakeError(err)
+			return
+		}
+		if err := h.checkNegotiatedProtocol(); err != nil {
 			handshakeErrChan <- err
 			return
 		}
@@ -259,6 +274,40 @@ func (h *cryptoSetup) RunHandshake() error {
 	}
 }
 
+// handshakeError converts an error returned by qtls.Handshake() to a QuicError.
+// qtls doesn't send TLS alerts when used with QUIC, so the error code can't be derived from the alert.
+// If the handshake failed while processing the peer's Certificate or CertificateVerify message,
+// the peer's certificate was rejected.
+func (h *cryptoSetup) handshakeError(err error) error {
+	if _, ok := err.(*qerr.QuicError); ok {
+		return err
+	}
+	switch h.lastMessageType {
+	case typeCertificate, typeCertificateVerify:
+		return qerr.Error(qerr.ProofInvalid, err.Error())
+	default:
+		return qerr.Error(qerr.HandshakeFailed, err.Error())
+	}
+}
+
+// checkNegotiatedProtocol checks that the server selected one of the application protocols offered by the client.
+// The server checks the protocols offered by the client when receiving the ClientHello, see checkALPN.
+func (h *cryptoSetup) checkNegotiatedProtocol() error {
+	if h.perspective == protocol.PerspectiveServer || len(h.tlsConf.NextProtos) == 0 {
+		return nil
+	}
+	negotiated := h.conn.ConnectionState().NegotiatedProtocol
+	if negotiated == "" {
+		return qerr.Error(qerr.NoApplicationProtocol, "server didn't select an application protocol")
+	}
+	for _, p := range h.tlsConf.NextProtos {
+		if p == negotiated {
+			return nil
+		}
+	}
+	return qerr.Error(qerr.NoApplicationProtocol, fmt.Sprintf("server selected an application protocol that wasn't offered: %s", negotiated))
+}
+
 func (h *cryptoSetup) Close() error {
 	close(h.closeChan)
 	// wait until qtls.Handshake() actually returned
@@ -313,7 +362,7 @@ func (h *cryptoSetup) handleMessageForServer(msgType messageType) bool {
 	case typeClientHello:
 		select {
 		case params := <-h.receivedTransportParams:
-			h.handleParamsCallback(&params)
+			h.handleTransportParameters(&params)
 		case <-h.handshakeErrChan:
 			return false
 		}
@@ -371,7 +420,7 @@ func (h *cryptoSetup) handleMessageForClient(msgType messageType) bool {
 	case typeEncryptedExtensions:
 		select {
 		case params := <-h.receivedTransportParams:
-			h.handleParamsCallback(&params)
+			h.handleTransportParameters(&params)
 		case <-h.handshakeErrChan:
 			return false
 		}
@@ -408,26 +457,36 @@ func (h *cryptoSetup) ReadHandshakeMessage() ([]byte, error) {
 	if !ok {
 		return nil, errors.New("error while handling the handshake message")
 	}
+	h.lastMessageType = messageType(msg[0])
 	return msg, nil
 }
 
-func (h *cryptoSetup) SetReadKey(suite *qtls.CipherSuite, trafficSecret []byte) {
+// createAEAD derives the packet protection key and IV and the header protection key from the traffic secret.
+func createAEAD(suite *qtls.CipherSuite, trafficSecret []byte) (cipher.AEAD, cipher.Block) {
 	key := qtls.HkdfExpandLabel(suite.Hash(), trafficSecret, []byte{}, "quic key", suite.KeyLen())
 	iv := qtls.HkdfExpandLabel(suite.Hash(), trafficSecret, []byte{}, "quic iv", suite.IVLen())
 	hpKey := qtls.HkdfExpandLabel(suite.Hash(), trafficSecret, []byte{}, "quic hp", suite.KeyLen())
-	hpDecrypter, err := aes.NewCipher(hpKey)
+	hp, err := newHeaderProtector(cipherSuiteID(suite), hpKey)
 	if err != nil {
-		panic(fmt.Sprintf("error creating new AES cipher: %s", err))
+		panic(fmt.Sprintf("error creating header protection cipher: %s", err))
 	}
+	return suite.AEAD(key, iv), hp
+}
+
+func (h *cryptoSetup) SetReadKey(suite *qtls.CipherSuite, trafficSecret []byte) {
+	aead, hpDecrypter := createAEAD(suite, trafficSecret)
 
 	switch h.readEncLevel {
 	case protocol.EncryptionInitial:
 		h.readEncLevel = protocol.EncryptionHandshake
-		h.handshakeOpener = newOpener(suite.AEAD(key, iv), hpDecrypter, false)
+		h.handshakeOpener = newOpener(aead, hpDecrypter, false)
 		h.logger.Debugf("Installed Handshake Read keys")
 	case protocol.EncryptionHandshake:
 		h.readEncLevel = protocol.Encryption1RTT
-		h.opener = newOpener(suite.AEAD(key, iv), hpDecrypter, true)
+		h.opener = newOpener(aead, hpDecrypter, true)
+		if h.perspective == protocol.PerspectiveServer {
+			h.setExporterSecret(suite, trafficSecret)
+		}
 		h.logger.Debugf("Installed 1-RTT Read keys")
 	default:
 		panic("unexpected read encryption level")
@@ -436,22 +495,19 @@ func (h *cryptoSetup) SetReadKey(suite *qtls.CipherSuite, trafficSecret []byte)
 }
 
 func (h *cryptoSetup) SetWriteKey(suite *qtls.CipherSuite, trafficSecret []byte) {
-	key := qtls.HkdfExpandLabel(suite.Hash(), trafficSecret, []byte{}, "quic key", suite.KeyLen())
-	iv := qtls.HkdfExpandLabel(suite.Hash(), trafficSecret, []byte{}, "quic iv", suite.IVLen())
-	hpKey := qtls.HkdfExpandLabel(suite.Hash(), trafficSecret, []byte{}, "quic hp", suite.KeyLen())
-	hpEncrypter, err := aes.NewCipher(hpKey)
-	if err != nil {
-		panic(fmt.Sprintf("error creating new AES cipher: %s", err))
-	}
+	aead, hpEncrypter := createAEAD(suite, trafficSecret)
 
 	switch h.writeEncLevel {
 	case protocol.EncryptionInitial:
 		h.writeEncLevel = protocol.EncryptionHandshake
-		h.handshakeSealer = newSealer(suite.AEAD(key, iv), hpEncrypter, false)
+		h.handshakeSealer = newSealer(aead, hpEncrypter, false)
 		h.logger.Debugf("Installed Handshake Write keys")
 	case protocol.EncryptionHandshake:
 		h.writeEncLevel = protocol.Encryption1RTT
-		h.sealer = newSealer(suite.AEAD(key, iv), hpEncrypter, true)
+		h.sealer = newSealer(aead, hpEncrypter, true)
+		if h.perspective == protocol.PerspectiveClient {
+			h.setExporterSecret(suite, trafficSecret)
+		}
 		h.logger.Debugf("Installed 1-RTT Write keys")
 	default:
 		panic("unexpected write encryption level")
@@ -527,11 +583,58 @@ func (h *cryptoSetup) GetOpener(level protocol.EncryptionLevel) (Opener, error)
 	}
 }
 
+func (h *cryptoSetup) handleTransportParameters(params *TransportParameters) {
+	h.peerParamsMutex.Lock()
+	h.peerParams = params
+	h.peerParamsMutex.Unlock()
+	h.handleParamsCallback(params)
+}
+
+// setExporterSecret derives the exporter secret from the client's 1-RTT traffic secret.
+// It is called by the client when it installs the 1-RTT write key, and by the server when it installs the 1-RTT read key,
+// i.e. as soon as the handshake has been completed.
+func (h *cryptoSetup) setExporterSecret(suite *qtls.CipherSuite, clientTrafficSecret []byte) {
+	hash := suite.Hash()
+	h.exporterMutex.Lock()
+	h.exporterHash = hash
+	h.exporterSecret = qtls.HkdfExpandLabel(hash, clientTrafficSecret, []byte{}, "quic exporter", hash.Size())
+	h.exporterMutex.Unlock()
+}
+
+// ExportKeyingMaterial derives keying material, following the construction of the TLS 1.3 exporter (RFC 8446, section 7.5).
+// qtls doesn't derive the exporter master secret, so the client's 1-RTT traffic secret is used as the base secret instead.
+// The keying material is therefore not the same as the one exported by other QUIC implementations.
+func (h *cryptoSetup) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	h.exporterMutex.Lock()
+	defer h.exporterMutex.Unlock()
+
+	if h.exporterSecret == nil {
+		return nil, errors.New("ExportKeyingMaterial is unavailable before the handshake completes")
+	}
+	emptyHash := h.exporterHash.New().Sum(nil)
+	secret := qtls.HkdfExpandLabel(h.exporterHash, h.exporterSecret, emptyHash, label, h.exporterHash.Size())
+	contextHash := h.exporterHash.New()
+	contextHash.Write(context)
+	return qtls.HkdfExpandLabel(h.exporterHash, secret, contextHash.Sum(nil), "exporter", length), nil
+}
+
 func (h *cryptoSetup) ConnectionState() ConnectionState {
 	connState := h.conn.ConnectionState()
+	var peerParams *TransportParameters
+	h.peerParamsMutex.Lock()
+	if h.peerParams != nil {
+		// return a copy, so that the caller can't modify the parameters used by the session
+		p := *h.peerParams
+		peerParams = &p
+	}
+	h.peerParamsMutex.Unlock()
 	return ConnectionState{
-		HandshakeComplete: connState.HandshakeComplete,
-		ServerName:        connState.ServerName,
-		PeerCertificates:  connState.PeerCertificates,
+		HandshakeComplete:       connState.HandshakeComplete,
+		DidResume:               connState.DidResume,
+		ServerName:              connState.ServerName,
+		NegotiatedProtocol:      connState.NegotiatedProtocol,
+		CipherSuite:             connState.CipherSuite,
+		PeerCertificates:        connState.PeerCertificates,
+		PeerTransportParameters: peerParams,
 	}
 }
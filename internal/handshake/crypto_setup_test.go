@@ -7,10 +7,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
+	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/testdata"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/marten-seemann/qtls"
@@ -85,6 +88,7 @@ var _ = Describe("Crypto Setup TLS", func() {
 			defer GinkgoRecover()
 			err := server.RunHandshake()
 			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.HandshakeFailed))
 			Expect(err.Error()).To(ContainSubstring("received unexpected handshake message"))
 			close(done)
 		}()
@@ -151,7 +155,10 @@ var _ = Describe("Crypto Setup TLS", func() {
 	})
 
 	Context("doing the handshake", func() {
-		generateCert := func() tls.Certificate {
+		generateCert := func(names ...string) tls.Certificate {
+			if len(names) == 0 {
+				names = []string{"localhost"}
+			}
 			priv, err := rsa.GenerateKey(rand.Reader, 2048)
 			Expect(err).ToNot(HaveOccurred())
 			tmpl := &x509.Certificate{
@@ -161,6 +168,7 @@ var _ = Describe("Crypto Setup TLS", func() {
 				NotBefore:             time.Now(),
 				NotAfter:              time.Now().Add(time.Hour), // valid for an hour
 				BasicConstraintsValid: true,
+				DNSNames:              names,
 			}
 			certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
 			Expect(err).ToNot(HaveOccurred())
@@ -203,7 +211,15 @@ var _ = Describe("Crypto Setup TLS", func() {
 			return clientErr, serverErr
 		}
 
-		handshakeWithTLSConf := func(clientConf, serverConf *tls.Config) (error /* client error */, error /* server error */) {
+		certPool := func(cert tls.Certificate) *x509.CertPool {
+			c, err := x509.ParseCertificate(cert.Certificate[0])
+			Expect(err).ToNot(HaveOccurred())
+			pool := x509.NewCertPool()
+			pool.AddCert(c)
+			return pool
+		}
+
+		newClientAndServer := func(clientConf, serverConf *tls.Config) (CryptoSetup /* client */, <-chan chunk, CryptoSetup /* server */, <-chan chunk) {
 			cChunkChan, cInitialStream, cHandshakeStream := initStreams()
 			client, _, err := NewCryptoSetupClient(
 				cInitialStream,
@@ -214,7 +230,7 @@ var _ = Describe("Crypto Setup TLS", func() {
 				func(p *TransportParameters) {},
 				clientConf,
 				protocol.VersionTLS,
-				[]protocol.VersionNumber{protocol.VersionTLS},
+				chooseVersion(protocol.VersionTLS),
 				protocol.VersionTLS,
 				utils.DefaultLogger.WithPrefix("client"),
 				protocol.PerspectiveClient,
@@ -235,13 +251,18 @@ var _ = Describe("Crypto Setup TLS", func() {
 				protocol.PerspectiveServer,
 			)
 			Expect(err).ToNot(HaveOccurred())
+			return client, cChunkChan, server, sChunkChan
+		}
 
-			return handshake(client, cChunkChan, server, sChunkChan)
+		handshakeWithTLSConf := func(clientConf, serverConf *tls.Config) (CryptoSetup /* client */, error /* client error */, CryptoSetup /* server */, error /* server error */) {
+			client, cChunkChan, server, sChunkChan := newClientAndServer(clientConf, serverConf)
+			clientErr, serverErr := handshake(client, cChunkChan, server, sChunkChan)
+			return client, clientErr, server, serverErr
 		}
 
 		It("handshakes", func() {
 			serverConf := testdata.GetTLSConfig()
-			clientErr, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+			_, clientErr, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
 			Expect(clientErr).ToNot(HaveOccurred())
 			Expect(serverErr).ToNot(HaveOccurred())
 		})
@@ -250,11 +271,373 @@ var _ = Describe("Crypto Setup TLS", func() {
 			clientConf.Certificates = []tls.Certificate{generateCert()}
 			serverConf := testdata.GetTLSConfig()
 			serverConf.ClientAuth = qtls.RequireAnyClientCert
-			clientErr, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+			_, clientErr, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
 			Expect(clientErr).ToNot(HaveOccurred())
 			Expect(serverErr).ToNot(HaveOccurred())
 		})
 
+		Context("selecting the certificate", func() {
+			var fooCert, barCert tls.Certificate
+
+			BeforeEach(func() {
+				fooCert = generateCert("foo.example.com")
+				barCert = generateCert("bar.example.com")
+			})
+
+			It("selects the certificate using the server name", func() {
+				serverConf := &tls.Config{Certificates: []tls.Certificate{fooCert, barCert}}
+				clientConf.ServerName = "bar.example.com"
+				clientConf.RootCAs = certPool(barCert)
+				client, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(client.ConnectionState().PeerCertificates[0].Raw).To(Equal(barCert.Certificate[0]))
+				Expect(server.ConnectionState().ServerName).To(Equal("bar.example.com"))
+			})
+
+			It("uses GetConfigForClient", func() {
+				serverConf := &tls.Config{
+					GetConfigForClient: func(ch *tls.ClientHelloInfo) (*tls.Config, error) {
+						if ch.ServerName == "foo.example.com" {
+							return &tls.Config{Certificates: []tls.Certificate{fooCert}, NextProtos: []string{"foo"}}, nil
+						}
+						return &tls.Config{Certificates: []tls.Certificate{barCert}, NextProtos: []string{"bar"}}, nil
+					},
+				}
+				clientConf.ServerName = "foo.example.com"
+				clientConf.RootCAs = certPool(fooCert)
+				clientConf.NextProtos = []string{"bar", "foo"}
+				client, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(client.ConnectionState().PeerCertificates[0].Raw).To(Equal(fooCert.Certificate[0]))
+				Expect(client.ConnectionState().NegotiatedProtocol).To(Equal("foo"))
+				Expect(server.ConnectionState().ServerName).To(Equal("foo.example.com"))
+			})
+
+			It("fails the handshake if no certificate matches the server name", func() {
+				serverConf := &tls.Config{Certificates: []tls.Certificate{fooCert, barCert}}
+				clientConf.ServerName = "unknown.example.com"
+				client, cChunkChan, server, _ := newClientAndServer(clientConf, serverConf)
+
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					err := server.RunHandshake()
+					Expect(err).To(MatchError(qerr.Error(qerr.HandshakeFailed, "tls: no certificate for server name unknown.example.com")))
+					close(done)
+				}()
+				go client.RunHandshake()
+				var ch chunk
+				Eventually(cChunkChan).Should(Receive(&ch))
+				server.HandleMessage(ch.data, ch.encLevel)
+				Eventually(done).Should(BeClosed())
+				client.Close()
+			})
+		})
+
+		Context("negotiating the application protocol", func() {
+			var serverConf *tls.Config
+
+			BeforeEach(func() {
+				serverCert := generateCert()
+				serverConf = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+				clientConf.RootCAs = certPool(serverCert)
+			})
+
+			It("negotiates a protocol", func() {
+				clientConf.NextProtos = []string{"foo", "bar"}
+				serverConf.NextProtos = []string{"bar"}
+				client, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(client.ConnectionState().NegotiatedProtocol).To(Equal("bar"))
+				Expect(server.ConnectionState().NegotiatedProtocol).To(Equal("bar"))
+			})
+
+			It("fails the handshake on the server if there's no protocol in common", func() {
+				clientConf.NextProtos = []string{"foo"}
+				serverConf.NextProtos = []string{"bar"}
+				client, cChunkChan, server, _ := newClientAndServer(clientConf, serverConf)
+
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					err := server.RunHandshake()
+					Expect(err).To(BeAssignableToTypeOf(&qerr.QuicError{}))
+					Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.NoApplicationProtocol))
+					close(done)
+				}()
+				go client.RunHandshake()
+				var ch chunk
+				Eventually(cChunkChan).Should(Receive(&ch))
+				server.HandleMessage(ch.data, ch.encLevel)
+				Eventually(done).Should(BeClosed())
+				client.Close()
+			})
+
+			It("fails the handshake on the client if the server doesn't select a protocol", func() {
+				clientConf.NextProtos = []string{"foo"}
+				_, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).To(MatchError(qerr.Error(qerr.NoApplicationProtocol, "server didn't select an application protocol")))
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(server.ConnectionState().NegotiatedProtocol).To(BeEmpty())
+			})
+
+			It("doesn't require a protocol if the client doesn't use ALPN", func() {
+				serverConf.NextProtos = []string{"bar"}
+				client, clientErr, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(client.ConnectionState().NegotiatedProtocol).To(BeEmpty())
+			})
+		})
+
+		Context("negotiating the cipher suite", func() {
+			var serverConf *tls.Config
+
+			BeforeEach(func() {
+				serverCert := generateCert()
+				serverConf = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+				clientConf.RootCAs = certPool(serverCert)
+			})
+
+			// checkPacketProtection checks that a packet sealed by the client can be opened by the server
+			checkPacketProtection := func(client, server CryptoSetup) {
+				encLevel, sealer := client.GetSealer()
+				Expect(encLevel).To(Equal(protocol.Encryption1RTT))
+				opener, err := server.GetOpener(protocol.Encryption1RTT)
+				Expect(err).ToNot(HaveOccurred())
+				sealed := sealer.Seal(nil, []byte("foobar"), 0x1337, []byte("ad"))
+				opened, err := opener.Open(nil, sealed, 0x1337, []byte("ad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(opened).To(Equal([]byte("foobar")))
+				header := []byte{0xb5, 1, 2, 3, 4}
+				sample := sealed[:16]
+				sealer.EncryptHeader(sample, &header[0], header[1:])
+				Expect(header).ToNot(Equal([]byte{0xb5, 1, 2, 3, 4}))
+				opener.DecryptHeader(sample, &header[0], header[1:])
+				Expect(header).To(Equal([]byte{0xb5, 1, 2, 3, 4}))
+			}
+
+			for _, s := range []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256} {
+				suite := s
+
+				It(fmt.Sprintf("uses the cipher suite %#x, if the client only offers this one", suite), func() {
+					clientConf.CipherSuites = []uint16{suite}
+					client, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+					Expect(clientErr).ToNot(HaveOccurred())
+					Expect(serverErr).ToNot(HaveOccurred())
+					Expect(client.ConnectionState().CipherSuite).To(Equal(suite))
+					Expect(server.ConnectionState().CipherSuite).To(Equal(suite))
+					checkPacketProtection(client, server)
+					checkPacketProtection(server, client)
+				})
+			}
+
+			It("uses the server's preference, if PreferServerCipherSuites is set", func() {
+				serverConf.CipherSuites = []uint16{tls.TLS_CHACHA20_POLY1305_SHA256, tls.TLS_AES_128_GCM_SHA256}
+				serverConf.PreferServerCipherSuites = true
+				client, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(client.ConnectionState().CipherSuite).To(Equal(tls.TLS_CHACHA20_POLY1305_SHA256))
+				Expect(server.ConnectionState().CipherSuite).To(Equal(tls.TLS_CHACHA20_POLY1305_SHA256))
+				checkPacketProtection(client, server)
+			})
+		})
+
+		Context("exporting keying material", func() {
+			var serverConf *tls.Config
+
+			BeforeEach(func() {
+				serverCert := generateCert()
+				serverConf = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+				clientConf.RootCAs = certPool(serverCert)
+			})
+
+			It("derives the same keying material on both endpoints", func() {
+				client, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				clientKey, err := client.ExportKeyingMaterial("label", []byte("context"), 42)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(clientKey).To(HaveLen(42))
+				serverKey, err := server.ExportKeyingMaterial("label", []byte("context"), 42)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(serverKey).To(Equal(clientKey))
+				// different labels and contexts result in different keying material
+				key, err := client.ExportKeyingMaterial("other label", []byte("context"), 42)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(key).ToNot(Equal(clientKey))
+				key, err = client.ExportKeyingMaterial("label", []byte("other context"), 42)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(key).ToNot(Equal(clientKey))
+			})
+
+			It("derives different keying material for different connections", func() {
+				client1, clientErr, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				client2, clientErr, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				key1, err := client1.ExportKeyingMaterial("label", nil, 32)
+				Expect(err).ToNot(HaveOccurred())
+				key2, err := client2.ExportKeyingMaterial("label", nil, 32)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(key1).ToNot(Equal(key2))
+			})
+
+			It("errors before the handshake completes", func() {
+				_, cInitialStream, cHandshakeStream := initStreams()
+				client, _, err := NewCryptoSetupClient(
+					cInitialStream,
+					cHandshakeStream,
+					nil,
+					protocol.ConnectionID{},
+					&TransportParameters{},
+					func(p *TransportParameters) {},
+					clientConf,
+					protocol.VersionTLS,
+					chooseVersion(protocol.VersionTLS),
+					protocol.VersionTLS,
+					utils.DefaultLogger.WithPrefix("client"),
+					protocol.PerspectiveClient,
+				)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = client.ExportKeyingMaterial("label", nil, 32)
+				Expect(err).To(MatchError("ExportKeyingMaterial is unavailable before the handshake completes"))
+			})
+		})
+
+		Context("verifying the server certificate", func() {
+			var serverCert tls.Certificate
+			var serverConf *tls.Config
+
+			BeforeEach(func() {
+				serverCert = generateCert()
+				serverConf = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+			})
+
+			It("calls VerifyPeerCertificate with the certificates and the verified chains", func() {
+				clientConf.RootCAs = certPool(serverCert)
+				var rawCerts [][]byte
+				var verifiedChains [][]*x509.Certificate
+				clientConf.VerifyPeerCertificate = func(raw [][]byte, chains [][]*x509.Certificate) error {
+					rawCerts = raw
+					verifiedChains = chains
+					return nil
+				}
+				_, clientErr, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(rawCerts).To(Equal(serverCert.Certificate))
+				Expect(verifiedChains).To(HaveLen(1))
+				Expect(verifiedChains[0][0].Raw).To(Equal(serverCert.Certificate[0]))
+			})
+
+			It("calls VerifyPeerCertificate if InsecureSkipVerify is set", func() {
+				clientConf.InsecureSkipVerify = true
+				var called bool
+				clientConf.VerifyPeerCertificate = func(raw [][]byte, chains [][]*x509.Certificate) error {
+					called = true
+					Expect(raw).To(Equal(serverCert.Certificate))
+					Expect(chains).To(BeEmpty())
+					return nil
+				}
+				_, clientErr, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(called).To(BeTrue())
+			})
+
+			It("fails the handshake if VerifyPeerCertificate returns an error", func() {
+				clientConf.InsecureSkipVerify = true
+				clientConf.VerifyPeerCertificate = func([][]byte, [][]*x509.Certificate) error {
+					return errors.New("unexpected public key")
+				}
+				client, cChunkChan, server, sChunkChan := newClientAndServer(clientConf, serverConf)
+				done := make(chan struct{})
+				defer close(done)
+				go func() {
+					defer GinkgoRecover()
+					for {
+						select {
+						case c := <-cChunkChan:
+							server.HandleMessage(c.data, c.encLevel)
+						case c := <-sChunkChan:
+							client.HandleMessage(c.data, c.encLevel)
+						case <-done:
+							return
+						}
+					}
+				}()
+				go server.RunHandshake()
+				err := client.RunHandshake()
+				Expect(err).To(MatchError(qerr.Error(qerr.ProofInvalid, "unexpected public key")))
+				Expect(server.Close()).To(Succeed())
+			})
+		})
+
+		Context("verifying client certificates", func() {
+			var serverConf *tls.Config
+
+			BeforeEach(func() {
+				serverCert := generateCert()
+				serverConf = &tls.Config{
+					Certificates: []tls.Certificate{serverCert},
+					ClientAuth:   tls.RequireAndVerifyClientCert,
+				}
+				clientConf.RootCAs = certPool(serverCert)
+			})
+
+			It("exposes the verified client certificate", func() {
+				clientCert := generateCert()
+				clientConf.Certificates = []tls.Certificate{clientCert}
+				serverConf.ClientCAs = certPool(clientCert)
+				client, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				certs := server.ConnectionState().PeerCertificates
+				Expect(certs).To(HaveLen(1))
+				Expect(certs[0].Raw).To(Equal(clientCert.Certificate[0]))
+				Expect(client.ConnectionState().PeerCertificates).To(HaveLen(1))
+			})
+
+			It("uses GetClientCertificate", func() {
+				clientCert := generateCert()
+				var called bool
+				clientConf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					called = true
+					return &clientCert, nil
+				}
+				serverConf.ClientCAs = certPool(clientCert)
+				_, clientErr, server, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(clientErr).ToNot(HaveOccurred())
+				Expect(serverErr).ToNot(HaveOccurred())
+				Expect(called).To(BeTrue())
+				Expect(server.ConnectionState().PeerCertificates).To(HaveLen(1))
+			})
+
+			It("fails the handshake if the client doesn't provide a certificate", func() {
+				serverConf.ClientCAs = x509.NewCertPool()
+				_, _, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(serverErr).To(HaveOccurred())
+				Expect(serverErr.(*qerr.QuicError).ErrorCode).To(Equal(qerr.ProofInvalid))
+				Expect(serverErr.Error()).To(ContainSubstring("client didn't provide a certificate"))
+			})
+
+			It("fails the handshake if the client certificate can't be verified", func() {
+				clientConf.Certificates = []tls.Certificate{generateCert()}
+				serverConf.ClientCAs = certPool(generateCert())
+				_, _, _, serverErr := handshakeWithTLSConf(clientConf, serverConf)
+				Expect(serverErr).To(HaveOccurred())
+				Expect(serverErr.(*qerr.QuicError).ErrorCode).To(Equal(qerr.ProofInvalid))
+				Expect(serverErr.Error()).To(ContainSubstring("failed to verify client's certificate"))
+			})
+		})
+
 		It("signals when it has written the ClientHello", func() {
 			cChunkChan, cInitialStream, cHandshakeStream := initStreams()
 			client, chChan, err := NewCryptoSetupClient(
@@ -266,7 +649,7 @@ var _ = Describe("Crypto Setup TLS", func() {
 				func(p *TransportParameters) {},
 				&tls.Config{InsecureSkipVerify: true},
 				protocol.VersionTLS,
-				[]protocol.VersionNumber{protocol.VersionTLS},
+				chooseVersion(protocol.VersionTLS),
 				protocol.VersionTLS,
 				utils.DefaultLogger.WithPrefix("client"),
 				protocol.PerspectiveClient,
@@ -306,7 +689,7 @@ var _ = Describe("Crypto Setup TLS", func() {
 				func(p *TransportParameters) { sTransportParametersRcvd = p },
 				clientConf,
 				protocol.VersionTLS,
-				[]protocol.VersionNumber{protocol.VersionTLS},
+				chooseVersion(protocol.VersionTLS),
 				protocol.VersionTLS,
 				utils.DefaultLogger.WithPrefix("client"),
 				protocol.PerspectiveClient,
@@ -345,6 +728,9 @@ var _ = Describe("Crypto Setup TLS", func() {
 			Expect(cTransportParametersRcvd.IdleTimeout).To(Equal(cTransportParameters.IdleTimeout))
 			Expect(sTransportParametersRcvd).ToNot(BeNil())
 			Expect(sTransportParametersRcvd.IdleTimeout).To(Equal(sTransportParameters.IdleTimeout))
+			// the transport parameters are exposed in the ConnectionState
+			Expect(client.ConnectionState().PeerTransportParameters.IdleTimeout).To(Equal(sTransportParameters.IdleTimeout))
+			Expect(server.ConnectionState().PeerTransportParameters.IdleTimeout).To(Equal(cTransportParameters.IdleTimeout))
 		})
 	})
 })
@@ -2,6 +2,7 @@ package handshake
 
 import (
 	"github.com/golang/mock/gomock"
+	"github.com/lucas-clemente/quic-go/internal/protocol"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -22,3 +23,10 @@ var _ = BeforeEach(func() {
 var _ = AfterEach(func() {
 	mockCtrl.Finish()
 })
+
+// chooseVersion returns a function that chooses the first of our versions supported by the peer
+func chooseVersion(ours ...protocol.VersionNumber) func([]protocol.VersionNumber) (protocol.VersionNumber, bool) {
+	return func(theirs []protocol.VersionNumber) (protocol.VersionNumber, bool) {
+		return protocol.ChooseSupportedVersion(ours, theirs)
+	}
+}
@@ -0,0 +1,66 @@
+package handshake
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"github.com/marten-seemann/qtls"
+	"golang.org/x/crypto/chacha20"
+)
+
+// cipherSuiteID returns the ID of a TLS 1.3 cipher suite.
+// qtls doesn't export the ID, so it is read from the (unexported) id field of the cipher suite.
+func cipherSuiteID(suite *qtls.CipherSuite) uint16 {
+	return uint16(reflect.ValueOf(suite).Elem().FieldByName("id").Uint())
+}
+
+// newHeaderProtector creates the cipher used for header protection.
+// The AES-GCM cipher suites use AES, ChaCha20-Poly1305 uses the ChaCha20 function.
+func newHeaderProtector(suite uint16, hpKey []byte) (cipher.Block, error) {
+	switch suite {
+	case qtls.TLS_AES_128_GCM_SHA256, qtls.TLS_AES_256_GCM_SHA384:
+		return aes.NewCipher(hpKey)
+	case qtls.TLS_CHACHA20_POLY1305_SHA256:
+		return newChaChaHeaderProtector(hpKey)
+	default:
+		return nil, fmt.Errorf("invalid cipher suite: %#x", suite)
+	}
+}
+
+// The chachaHeaderProtector computes the header protection mask using ChaCha20.
+// It implements the cipher.Block interface, so that it can be used in the same way as AES:
+// The first 4 bytes of the sample are used as the block counter, the remaining 12 bytes as the nonce,
+// and the mask is the first block of the key stream.
+type chachaHeaderProtector struct {
+	key []byte
+}
+
+var _ cipher.Block = &chachaHeaderProtector{}
+
+func newChaChaHeaderProtector(key []byte) (cipher.Block, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid ChaCha20 key length: %d", len(key))
+	}
+	return &chachaHeaderProtector{key: key}, nil
+}
+
+func (p *chachaHeaderProtector) BlockSize() int { return 16 }
+
+func (p *chachaHeaderProtector) Encrypt(dst, src []byte) {
+	c, err := chacha20.NewUnauthenticatedCipher(p.key, src[4:16])
+	if err != nil {
+		panic(err) // can't happen, the key and nonce have the correct length
+	}
+	c.SetCounter(binary.LittleEndian.Uint32(src[:4]))
+	for i := range dst[:16] {
+		dst[i] = 0
+	}
+	c.XORKeyStream(dst[:16], dst[:16])
+}
+
+func (p *chachaHeaderProtector) Decrypt(dst, src []byte) {
+	panic("chachaHeaderProtector: Decrypt not supported")
+}
@@ -0,0 +1,78 @@
+package handshake
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+
+	"github.com/marten-seemann/qtls"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Header Protection", func() {
+	splitHexString := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		Expect(err).ToNot(HaveOccurred())
+		return b
+	}
+
+	It("computes the ChaCha20 block function", func() {
+		// test vector from RFC 8439, section 2.3.2
+		key := splitHexString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+		p, err := newChaChaHeaderProtector(key)
+		Expect(err).ToNot(HaveOccurred())
+		mask := make([]byte, 16)
+		// counter 1, nonce 000000090000004a00000000
+		p.Encrypt(mask, splitHexString("01000000000000090000004a00000000"))
+		Expect(mask).To(Equal(splitHexString("10f1e7e4d13b5915500fdd1fa32071c4")))
+	})
+
+	It("computes the mask in place", func() {
+		key := splitHexString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+		p, err := newChaChaHeaderProtector(key)
+		Expect(err).ToNot(HaveOccurred())
+		b := splitHexString("01000000000000090000004a00000000")
+		p.Encrypt(b, b)
+		Expect(b).To(Equal(splitHexString("10f1e7e4d13b5915500fdd1fa32071c4")))
+	})
+
+	It("rejects cipher suites it doesn't know the ID of", func() {
+		_, err := newHeaderProtector(cipherSuiteID(&qtls.CipherSuite{}), make([]byte, 16))
+		Expect(err).To(MatchError("invalid cipher suite: 0x0"))
+	})
+
+	It("computes the header protection mask for ChaCha20", func() {
+		// test vector from draft-ietf-quic-tls, appendix A (ChaCha20-Poly1305 Short Header Packet)
+		p, err := newHeaderProtector(qtls.TLS_CHACHA20_POLY1305_SHA256, splitHexString("25a282b9e82f06f21f488917a4fc8f1b73573685608597d0efcb076b0ab7a7a4"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.BlockSize()).To(Equal(16))
+		mask := make([]byte, p.BlockSize())
+		p.Encrypt(mask, splitHexString("5e5cd55c41f69080575d7999c25a5bfb"))
+		Expect(mask[:5]).To(Equal(splitHexString("aefefe7d03")))
+	})
+
+	It("uses AES for the AES-GCM cipher suites", func() {
+		for _, suite := range []uint16{qtls.TLS_AES_128_GCM_SHA256, qtls.TLS_AES_256_GCM_SHA384} {
+			key := make([]byte, 16)
+			if suite == qtls.TLS_AES_256_GCM_SHA384 {
+				key = make([]byte, 32)
+			}
+			p, err := newHeaderProtector(suite, key)
+			Expect(err).ToNot(HaveOccurred())
+			block, err := aes.NewCipher(key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal(block))
+		}
+	})
+
+	It("rejects invalid ChaCha20 keys", func() {
+		_, err := newHeaderProtector(qtls.TLS_CHACHA20_POLY1305_SHA256, make([]byte, 16))
+		Expect(err).To(MatchError("invalid ChaCha20 key length: 16"))
+	})
+
+	It("rejects unknown cipher suites", func() {
+		_, err := newHeaderProtector(0x1337, make([]byte, 16))
+		Expect(err).To(MatchError("invalid cipher suite: 0x1337"))
+	})
+})
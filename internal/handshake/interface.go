@@ -34,6 +34,7 @@ type CryptoSetup interface {
 
 	HandleMessage([]byte, protocol.EncryptionLevel) bool
 	ConnectionState() ConnectionState
+	ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error)
 
 	GetSealer() (protocol.EncryptionLevel, Sealer)
 	GetSealerWithEncryptionLevel(protocol.EncryptionLevel) (Sealer, error)
@@ -43,7 +44,12 @@ type CryptoSetup interface {
 // ConnectionState records basic details about the QUIC connection.
 // Warning: This API should not be considered stable and might change soon.
 type ConnectionState struct {
-	HandshakeComplete bool                // handshake is complete
-	ServerName        string              // server name requested by client, if any (server side only)
-	PeerCertificates  []*x509.Certificate // certificate chain presented by remote peer
+	HandshakeComplete       bool                   // handshake is complete
+	DidResume               bool                   // connection resumes a previous TLS session
+	ServerName              string                 // server name requested by client, if any (server side only)
+	NegotiatedProtocol      string                 // application protocol negotiated using ALPN, if any
+	CipherSuite             uint16                 // TLS 1.3 cipher suite used for packet protection (e.g. tls.TLS_CHACHA20_POLY1305_SHA256), set once the handshake is complete
+	PeerCertificates        []*x509.Certificate    // certificate chain presented by remote peer
+	PeerTransportParameters *TransportParameters   // transport parameters sent by the peer, nil until they are received
+	Version                 protocol.VersionNumber // QUIC version in use
 }
@@ -2,11 +2,20 @@ package handshake
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/marten-seemann/qtls"
 )
 
-func tlsConfigToQtlsConfig(c *tls.Config) *qtls.Config {
+func tlsConfigToQtlsConfig(
+	c *tls.Config,
+	recordLayer qtls.RecordLayer,
+	extHandler tlsExtensionHandler,
+) *qtls.Config {
 	if c == nil {
 		c = &tls.Config{}
 	}
@@ -19,26 +28,52 @@ func tlsConfigToQtlsConfig(c *tls.Config) *qtls.Config {
 	if maxVersion < qtls.VersionTLS13 {
 		maxVersion = qtls.VersionTLS13
 	}
+	// GetConfigForClient is only called on the server side.
+	// It is always set, since it is used to check the ALPN protocols offered by the client.
+	getConfigForClient := func(ch *qtls.ClientHelloInfo) (*qtls.Config, error) {
+		var qconf *qtls.Config
+		nextProtos := c.NextProtos
+		if c.GetConfigForClient != nil {
+			tlsConf, err := c.GetConfigForClient(toTLSClientHelloInfo(ch))
+			if err != nil {
+				return nil, err
+			}
+			if tlsConf != nil {
+				// The returned config replaces the config used for the handshake,
+				// so it has to use the same record layer and extension handler.
+				qconf = tlsConfigToQtlsConfig(tlsConf, recordLayer, extHandler)
+				nextProtos = tlsConf.NextProtos
+			}
+		}
+		if err := checkALPN(ch.SupportedProtos, nextProtos); err != nil {
+			return nil, err
+		}
+		return qconf, nil
+	}
+	getCertificate := func(ch *qtls.ClientHelloInfo) (*qtls.Certificate, error) {
+		return getCertificateForClient(c, toTLSClientHelloInfo(ch))
+	}
 	return &qtls.Config{
-		Rand:              c.Rand,
-		Time:              c.Time,
-		Certificates:      c.Certificates,
-		NameToCertificate: c.NameToCertificate,
-		// TODO: make GetCertificate work
-		// GetCertificate:              c.GetCertificate,
-		GetClientCertificate: c.GetClientCertificate,
-		// TODO: make GetConfigForClient work
-		// GetConfigForClient:          c.GetConfigForClient,
-		VerifyPeerCertificate:       c.VerifyPeerCertificate,
-		RootCAs:                     c.RootCAs,
-		NextProtos:                  c.NextProtos,
-		ServerName:                  c.ServerName,
-		ClientAuth:                  c.ClientAuth,
-		ClientCAs:                   c.ClientCAs,
-		InsecureSkipVerify:          c.InsecureSkipVerify,
-		CipherSuites:                c.CipherSuites,
-		PreferServerCipherSuites:    c.PreferServerCipherSuites,
-		SessionTicketsDisabled:      c.SessionTicketsDisabled,
+		Rand:                     c.Rand,
+		Time:                     c.Time,
+		Certificates:             c.Certificates,
+		NameToCertificate:        c.NameToCertificate,
+		GetCertificate:           getCertificate,
+		GetClientCertificate:     c.GetClientCertificate,
+		GetConfigForClient:       getConfigForClient,
+		VerifyPeerCertificate:    c.VerifyPeerCertificate,
+		RootCAs:                  c.RootCAs,
+		NextProtos:               c.NextProtos,
+		ServerName:               c.ServerName,
+		ClientAuth:               c.ClientAuth,
+		ClientCAs:                c.ClientCAs,
+		InsecureSkipVerify:       c.InsecureSkipVerify,
+		CipherSuites:             c.CipherSuites,
+		PreferServerCipherSuites: c.PreferServerCipherSuites,
+		// Session resumption is not supported yet:
+		// qtls can't use session tickets on the client side,
+		// and there's no 1-RTT crypto stream that the server could send the NewSessionTicket messages on.
+		SessionTicketsDisabled:      true,
 		SessionTicketKey:            c.SessionTicketKey,
 		MinVersion:                  minVersion,
 		MaxVersion:                  maxVersion,
@@ -46,5 +81,81 @@ func tlsConfigToQtlsConfig(c *tls.Config) *qtls.Config {
 		DynamicRecordSizingDisabled: c.DynamicRecordSizingDisabled,
 		Renegotiation:               c.Renegotiation,
 		KeyLogWriter:                c.KeyLogWriter,
+		AlternativeRecordLayer:      recordLayer,
+		GetExtensions:               extHandler.GetExtensions,
+		ReceivedExtensions:          extHandler.ReceivedExtensions,
+	}
+}
+
+// checkALPN checks that the client and the server have an application protocol in common.
+// If either of them doesn't use ALPN, no application protocol is negotiated, and the handshake continues.
+func checkALPN(clientProtos, serverProtos []string) error {
+	if len(clientProtos) == 0 || len(serverProtos) == 0 {
+		return nil
+	}
+	for _, s := range serverProtos {
+		for _, c := range clientProtos {
+			if s == c {
+				return nil
+			}
+		}
+	}
+	return qerr.Error(qerr.NoApplicationProtocol, fmt.Sprintf("no application protocol in common (client offered %s)", strings.Join(clientProtos, ", ")))
+}
+
+func toTLSClientHelloInfo(ch *qtls.ClientHelloInfo) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		CipherSuites:      ch.CipherSuites,
+		ServerName:        ch.ServerName,
+		SupportedCurves:   ch.SupportedCurves,
+		SupportedPoints:   ch.SupportedPoints,
+		SignatureSchemes:  ch.SignatureSchemes,
+		SupportedProtos:   ch.SupportedProtos,
+		SupportedVersions: ch.SupportedVersions,
+		Conn:              ch.Conn,
+	}
+}
+
+// getCertificateForClient selects the certificate for the server name requested by the client.
+// Unlike crypto/tls, it doesn't fall back to the first certificate,
+// but returns an error if none of the certificates is valid for the requested server name.
+// The first certificate is only used if the client didn't send a server name.
+func getCertificateForClient(c *tls.Config, ch *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c.GetCertificate != nil {
+		cert, err := c.GetCertificate(ch)
+		if cert != nil || err != nil {
+			return cert, err
+		}
+	}
+	if len(c.Certificates) == 0 {
+		if ch.ServerName != "" {
+			return nil, fmt.Errorf("tls: no certificate for server name %s", ch.ServerName)
+		}
+		return nil, errors.New("tls: no certificates configured")
+	}
+	if ch.ServerName == "" {
+		return &c.Certificates[0], nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(ch.ServerName), ".")
+	if cert, ok := c.NameToCertificate[name]; ok {
+		return cert, nil
+	}
+	for i := range c.Certificates {
+		cert := &c.Certificates[i]
+		leaf := cert.Leaf
+		if leaf == nil {
+			if len(cert.Certificate) == 0 {
+				continue
+			}
+			var err error
+			leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				continue
+			}
+		}
+		if leaf.VerifyHostname(name) == nil {
+			return cert, nil
+		}
 	}
+	return nil, fmt.Errorf("tls: no certificate for server name %s", ch.ServerName)
 }
@@ -0,0 +1,230 @@
+package handshake
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+	"github.com/marten-seemann/qtls"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockExtensionHandler struct{}
+
+var _ tlsExtensionHandler = &mockExtensionHandler{}
+
+func (h *mockExtensionHandler) GetExtensions(msgType uint8) []qtls.Extension { return nil }
+func (h *mockExtensionHandler) ReceivedExtensions(msgType uint8, exts []qtls.Extension) error {
+	return nil
+}
+
+var _ = Describe("qtls.Config", func() {
+	generateCert := func(names ...string) tls.Certificate {
+		priv, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).ToNot(HaveOccurred())
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+			DNSNames:     names,
+		}
+		certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+		Expect(err).ToNot(HaveOccurred())
+		return tls.Certificate{PrivateKey: priv, Certificate: [][]byte{certDER}}
+	}
+
+	It("sets the record layer and the extension handler", func() {
+		conf := tlsConfigToQtlsConfig(&tls.Config{}, &cryptoSetup{}, &mockExtensionHandler{})
+		Expect(conf.AlternativeRecordLayer).ToNot(BeNil())
+		Expect(conf.GetExtensions).ToNot(BeNil())
+		Expect(conf.ReceivedExtensions).ToNot(BeNil())
+	})
+
+	It("requires TLS 1.3", func() {
+		conf := tlsConfigToQtlsConfig(&tls.Config{MinVersion: tls.VersionTLS11, MaxVersion: tls.VersionTLS12}, &cryptoSetup{}, &mockExtensionHandler{})
+		Expect(conf.MinVersion).To(BeEquivalentTo(qtls.VersionTLS13))
+		Expect(conf.MaxVersion).To(BeEquivalentTo(qtls.VersionTLS13))
+	})
+
+	It("disables session tickets", func() {
+		conf := tlsConfigToQtlsConfig(&tls.Config{}, &cryptoSetup{}, &mockExtensionHandler{})
+		Expect(conf.SessionTicketsDisabled).To(BeTrue())
+	})
+
+	Context("GetConfigForClient", func() {
+		It("returns nil if it's not set on the tls.Config", func() {
+			conf := tlsConfigToQtlsConfig(&tls.Config{}, &cryptoSetup{}, &mockExtensionHandler{})
+			qconf, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(qconf).To(BeNil())
+		})
+
+		It("converts the config, using the same record layer and extension handler", func() {
+			cs := &cryptoSetup{}
+			tlsConf := &tls.Config{
+				GetConfigForClient: func(ch *tls.ClientHelloInfo) (*tls.Config, error) {
+					Expect(ch.ServerName).To(Equal("quic.clemente.io"))
+					return &tls.Config{NextProtos: []string{"foo", "bar"}}, nil
+				},
+			}
+			conf := tlsConfigToQtlsConfig(tlsConf, cs, &mockExtensionHandler{})
+			qconf, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{ServerName: "quic.clemente.io"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(qconf.NextProtos).To(Equal([]string{"foo", "bar"}))
+			Expect(qconf.AlternativeRecordLayer).To(Equal(cs))
+			Expect(qconf.GetExtensions).ToNot(BeNil())
+			Expect(qconf.ReceivedExtensions).ToNot(BeNil())
+			Expect(qconf.MinVersion).To(BeEquivalentTo(qtls.VersionTLS13))
+		})
+
+		It("returns nil if the callback returns nil", func() {
+			tlsConf := &tls.Config{
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) { return nil, nil },
+			}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			qconf, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(qconf).To(BeNil())
+		})
+
+		It("returns errors", func() {
+			testErr := errors.New("test error")
+			tlsConf := &tls.Config{
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) { return nil, testErr },
+			}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			_, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{})
+			Expect(err).To(MatchError(testErr))
+		})
+	})
+
+	Context("checking the ALPN protocols", func() {
+		It("accepts a protocol supported by both sides", func() {
+			conf := tlsConfigToQtlsConfig(&tls.Config{NextProtos: []string{"foo", "bar"}}, &cryptoSetup{}, &mockExtensionHandler{})
+			_, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{SupportedProtos: []string{"baz", "bar"}})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("accepts if either side doesn't use ALPN", func() {
+			conf := tlsConfigToQtlsConfig(&tls.Config{NextProtos: []string{"foo"}}, &cryptoSetup{}, &mockExtensionHandler{})
+			_, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{})
+			Expect(err).ToNot(HaveOccurred())
+			conf = tlsConfigToQtlsConfig(&tls.Config{}, &cryptoSetup{}, &mockExtensionHandler{})
+			_, err = conf.GetConfigForClient(&qtls.ClientHelloInfo{SupportedProtos: []string{"foo"}})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("errors if there's no protocol in common", func() {
+			conf := tlsConfigToQtlsConfig(&tls.Config{NextProtos: []string{"foo"}}, &cryptoSetup{}, &mockExtensionHandler{})
+			_, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{SupportedProtos: []string{"bar", "baz"}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.NoApplicationProtocol))
+			Expect(err.Error()).To(ContainSubstring("client offered bar, baz"))
+		})
+
+		It("uses the protocols of the config returned by GetConfigForClient", func() {
+			tlsConf := &tls.Config{
+				NextProtos: []string{"foo"},
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+					return &tls.Config{NextProtos: []string{"bar"}}, nil
+				},
+			}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			_, err := conf.GetConfigForClient(&qtls.ClientHelloInfo{SupportedProtos: []string{"bar"}})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = conf.GetConfigForClient(&qtls.ClientHelloInfo{SupportedProtos: []string{"foo"}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.NoApplicationProtocol))
+		})
+	})
+
+	Context("selecting the certificate", func() {
+		var fooCert, barCert tls.Certificate
+
+		BeforeEach(func() {
+			fooCert = generateCert("foo.example.com")
+			barCert = generateCert("*.bar.example.com")
+		})
+
+		It("errors if a single certificate doesn't match the server name", func() {
+			conf := tlsConfigToQtlsConfig(&tls.Config{Certificates: []tls.Certificate{fooCert}}, &cryptoSetup{}, &mockExtensionHandler{})
+			cert, err := conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "foo.example.com"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate).To(Equal(fooCert.Certificate))
+			_, err = conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "bar.example.com"})
+			Expect(err).To(MatchError("tls: no certificate for server name bar.example.com"))
+		})
+
+		It("uses the first certificate if the client didn't send a server name", func() {
+			tlsConf := &tls.Config{Certificates: []tls.Certificate{fooCert, barCert}}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			cert, err := conf.GetCertificate(&qtls.ClientHelloInfo{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate).To(Equal(fooCert.Certificate))
+		})
+
+		It("selects the certificate matching the server name", func() {
+			tlsConf := &tls.Config{Certificates: []tls.Certificate{fooCert, barCert}}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			cert, err := conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "foo.example.com"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate).To(Equal(fooCert.Certificate))
+			cert, err = conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "www.BAR.example.com."})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate).To(Equal(barCert.Certificate))
+		})
+
+		It("uses the NameToCertificate map", func() {
+			tlsConf := &tls.Config{
+				Certificates:      []tls.Certificate{fooCert, barCert},
+				NameToCertificate: map[string]*tls.Certificate{"other.example.com": &barCert},
+			}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			cert, err := conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "other.example.com"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate).To(Equal(barCert.Certificate))
+		})
+
+		It("errors if no certificate matches the server name", func() {
+			tlsConf := &tls.Config{Certificates: []tls.Certificate{fooCert, barCert}}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			_, err := conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "unknown.example.com"})
+			Expect(err).To(MatchError("tls: no certificate for server name unknown.example.com"))
+		})
+
+		It("uses GetCertificate", func() {
+			tlsConf := &tls.Config{
+				GetCertificate: func(ch *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					if ch.ServerName == "bar.example.com" {
+						return &barCert, nil
+					}
+					return nil, nil
+				},
+			}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			cert, err := conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "bar.example.com"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate).To(Equal(barCert.Certificate))
+			_, err = conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "foo.example.com"})
+			Expect(err).To(MatchError("tls: no certificate for server name foo.example.com"))
+		})
+
+		It("falls back to the certificates if GetCertificate doesn't return a certificate", func() {
+			tlsConf := &tls.Config{
+				Certificates:   []tls.Certificate{fooCert},
+				GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, nil },
+			}
+			conf := tlsConfigToQtlsConfig(tlsConf, &cryptoSetup{}, &mockExtensionHandler{})
+			cert, err := conf.GetCertificate(&qtls.ClientHelloInfo{ServerName: "foo.example.com"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Certificate).To(Equal(fooCert.Certificate))
+		})
+	})
+})
@@ -14,10 +14,12 @@ type extensionHandlerClient struct {
 	ourParams  *TransportParameters
 	paramsChan chan<- TransportParameters
 
-	origConnID        protocol.ConnectionID
-	initialVersion    protocol.VersionNumber
-	supportedVersions []protocol.VersionNumber
-	version           protocol.VersionNumber
+	origConnID     protocol.ConnectionID
+	initialVersion protocol.VersionNumber
+	// chooseVersion chooses a version from the versions supported by the server.
+	// It is used to check that version negotiation wasn't tampered with.
+	chooseVersion func(theirs []protocol.VersionNumber) (protocol.VersionNumber, bool)
+	version       protocol.VersionNumber
 
 	logger utils.Logger
 }
@@ -29,7 +31,7 @@ func newExtensionHandlerClient(
 	params *TransportParameters,
 	origConnID protocol.ConnectionID,
 	initialVersion protocol.VersionNumber,
-	supportedVersions []protocol.VersionNumber,
+	chooseVersion func(theirs []protocol.VersionNumber) (protocol.VersionNumber, bool),
 	version protocol.VersionNumber,
 	logger utils.Logger,
 ) (tlsExtensionHandler, <-chan TransportParameters) {
@@ -38,13 +40,13 @@ func newExtensionHandlerClient(
 	// We have to use an unbuffered channel here to make sure that the session actually processes the transport parameters immediately.
 	paramsChan := make(chan TransportParameters)
 	return &extensionHandlerClient{
-		ourParams:         params,
-		paramsChan:        paramsChan,
-		origConnID:        origConnID,
-		initialVersion:    initialVersion,
-		supportedVersions: supportedVersions,
-		version:           version,
-		logger:            logger,
+		ourParams:      params,
+		paramsChan:     paramsChan,
+		origConnID:     origConnID,
+		initialVersion: initialVersion,
+		chooseVersion:  chooseVersion,
+		version:        version,
+		logger:         logger,
 	}, paramsChan
 }
 
@@ -90,9 +92,10 @@ func (h *extensionHandlerClient) ReceivedExtensions(msgType uint8, exts []qtls.E
 	if !protocol.IsSupportedVersion(eetp.SupportedVersions, h.version) {
 		return qerr.Error(qerr.VersionNegotiationMismatch, "current version not included in the supported versions")
 	}
-	// if version negotiation was performed, check that we would have selected the current version based on the supported versions sent by the server
+	// If version negotiation was performed, check that we would have selected the current version based on the supported versions sent by the server.
+	// Unlike the Version Negotiation packet, this list is authenticated by the handshake, so this detects version downgrade attacks.
 	if h.version != h.initialVersion {
-		negotiatedVersion, ok := protocol.ChooseSupportedVersion(h.supportedVersions, eetp.SupportedVersions)
+		negotiatedVersion, ok := h.chooseVersion(eetp.SupportedVersions)
 		if !ok || h.version != negotiatedVersion {
 			return qerr.Error(qerr.VersionNegotiationMismatch, "would have picked a different version")
 		}
@@ -139,7 +139,7 @@ var _ = Describe("TLS Extension Handler, for the client", func() {
 
 				handler.initialVersion = 13
 				handler.version = 37
-				handler.supportedVersions = []protocol.VersionNumber{13, 37, 42}
+				handler.chooseVersion = chooseVersion(13, 37, 42)
 				ext := qtls.Extension{
 					Type: quicTLSExtensionType,
 					Data: (&encryptedExtensionsTransportParameters{
@@ -156,7 +156,7 @@ var _ = Describe("TLS Extension Handler, for the client", func() {
 			It("errors if the current version doesn't match negotiated_version", func() {
 				handler.initialVersion = 13
 				handler.version = 37
-				handler.supportedVersions = []protocol.VersionNumber{13, 37, 42}
+				handler.chooseVersion = chooseVersion(13, 37, 42)
 				ext := qtls.Extension{
 					Type: quicTLSExtensionType,
 					Data: (&encryptedExtensionsTransportParameters{
@@ -185,10 +185,10 @@ var _ = Describe("TLS Extension Handler, for the client", func() {
 			It("errors if version negotiation was performed, but would have picked a different version based on the supported version list", func() {
 				handler.version = 42
 				handler.initialVersion = 41
-				handler.supportedVersions = []protocol.VersionNumber{43, 42, 41}
+				handler.chooseVersion = chooseVersion(43, 42, 41)
 				serverSupportedVersions := []protocol.VersionNumber{42, 43}
 				// check that version negotiation would have led us to pick version 43
-				ver, ok := protocol.ChooseSupportedVersion(handler.supportedVersions, serverSupportedVersions)
+				ver, ok := handler.chooseVersion(serverSupportedVersions)
 				Expect(ok).To(BeTrue())
 				Expect(ver).To(Equal(protocol.VersionNumber(43)))
 				ext := qtls.Extension{
@@ -202,6 +202,21 @@ var _ = Describe("TLS Extension Handler, for the client", func() {
 				Expect(err).To(MatchError("VersionNegotiationMismatch: would have picked a different version"))
 			})
 
+			It("errors if version negotiation was performed, but no version would have been picked based on the supported version list", func() {
+				handler.version = 42
+				handler.initialVersion = 41
+				handler.chooseVersion = func([]protocol.VersionNumber) (protocol.VersionNumber, bool) { return 0, false }
+				ext := qtls.Extension{
+					Type: quicTLSExtensionType,
+					Data: (&encryptedExtensionsTransportParameters{
+						NegotiatedVersion: 42,
+						SupportedVersions: []protocol.VersionNumber{42, 43},
+					}).Marshal(),
+				}
+				err := handler.ReceivedExtensions(uint8(typeEncryptedExtensions), []qtls.Extension{ext})
+				Expect(err).To(MatchError("VersionNegotiationMismatch: would have picked a different version"))
+			})
+
 			It("doesn't error if it would have picked a different version based on the supported version list, if no version negotiation was performed", func() {
 				done := make(chan struct{})
 				go func() {
@@ -212,10 +227,10 @@ var _ = Describe("TLS Extension Handler, for the client", func() {
 
 				handler.version = 42
 				handler.initialVersion = 42 // version == initialVersion means no version negotiation was performed
-				handler.supportedVersions = []protocol.VersionNumber{43, 42, 41}
+				handler.chooseVersion = chooseVersion(43, 42, 41)
 				serverSupportedVersions := []protocol.VersionNumber{42, 43}
 				// check that version negotiation would have led us to pick version 43
-				ver, ok := protocol.ChooseSupportedVersion(handler.supportedVersions, serverSupportedVersions)
+				ver, ok := handler.chooseVersion(serverSupportedVersions)
 				Expect(ok).To(BeTrue())
 				Expect(ver).To(Equal(protocol.VersionNumber(43)))
 				ext := qtls.Extension{
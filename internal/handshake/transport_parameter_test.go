@@ -22,9 +22,10 @@ var _ = Describe("Transport Parameters", func() {
 			MaxBidiStreams:                 1337,
 			MaxUniStreams:                  7331,
 			IdleTimeout:                    42 * time.Second,
+			MaxAckDelay:                    37 * time.Millisecond,
 			OriginalConnectionID:           protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
 		}
-		Expect(p.String()).To(Equal("&handshake.TransportParameters{OriginalConnectionID: 0xdeadbeef, InitialMaxStreamDataBidiLocal: 0x1234, InitialMaxStreamDataBidiRemote: 0x2345, InitialMaxStreamDataUni: 0x3456, InitialMaxData: 0x4567, MaxBidiStreams: 1337, MaxUniStreams: 7331, IdleTimeout: 42s}"))
+		Expect(p.String()).To(Equal("&handshake.TransportParameters{OriginalConnectionID: 0xdeadbeef, InitialMaxStreamDataBidiLocal: 0x1234, InitialMaxStreamDataBidiRemote: 0x2345, InitialMaxStreamDataUni: 0x3456, InitialMaxData: 0x4567, MaxBidiStreams: 1337, MaxUniStreams: 7331, IdleTimeout: 42s, MaxAckDelay: 37ms}"))
 	})
 
 	getRandomValue := func() uint64 {
@@ -45,6 +46,8 @@ var _ = Describe("Transport Parameters", func() {
 			DisableMigration:               true,
 			StatelessResetToken:            bytes.Repeat([]byte{100}, 16),
 			OriginalConnectionID:           protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+			MaxDatagramFrameSize:           protocol.ByteCount(getRandomValue()),
+			MaxAckDelay:                    42 * time.Millisecond,
 		}
 		b := &bytes.Buffer{}
 		params.marshal(b)
@@ -61,6 +64,38 @@ var _ = Describe("Transport Parameters", func() {
 		Expect(p.DisableMigration).To(Equal(params.DisableMigration))
 		Expect(p.StatelessResetToken).To(Equal(params.StatelessResetToken))
 		Expect(p.OriginalConnectionID).To(Equal(protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef}))
+		Expect(p.MaxDatagramFrameSize).To(Equal(params.MaxDatagramFrameSize))
+		Expect(p.MaxAckDelay).To(Equal(42 * time.Millisecond))
+	})
+
+	It("uses the default max_ack_delay, if the peer didn't send one", func() {
+		b := &bytes.Buffer{}
+		utils.BigEndian.WriteUint16(b, uint16(initialMaxDataParameterID))
+		utils.BigEndian.WriteUint16(b, uint16(utils.VarIntLen(0x1337)))
+		utils.WriteVarInt(b, 0x1337)
+		p := &TransportParameters{}
+		Expect(p.unmarshal(b.Bytes(), protocol.PerspectiveServer)).To(Succeed())
+		Expect(p.MaxAckDelay).To(Equal(protocol.DefaultMaxAckDelay))
+	})
+
+	It("errors when the max_ack_delay is too large", func() {
+		b := &bytes.Buffer{}
+		utils.BigEndian.WriteUint16(b, uint16(maxAckDelayParameterID))
+		utils.BigEndian.WriteUint16(b, uint16(utils.VarIntLen(1<<14)))
+		utils.WriteVarInt(b, 1<<14)
+		p := &TransportParameters{}
+		Expect(p.unmarshal(b.Bytes(), protocol.PerspectiveServer)).To(MatchError("invalid value for max_ack_delay: 16384ms (maximum 16383ms)"))
+	})
+
+	It("doesn't send the max_datagram_frame_size, if DATAGRAM frames are not supported", func() {
+		b := &bytes.Buffer{}
+		(&TransportParameters{}).marshal(b)
+		p := &TransportParameters{}
+		Expect(p.unmarshal(b.Bytes(), protocol.PerspectiveServer)).To(Succeed())
+		Expect(p.MaxDatagramFrameSize).To(BeZero())
+		bWith := &bytes.Buffer{}
+		(&TransportParameters{MaxDatagramFrameSize: 1000}).marshal(bWith)
+		Expect(bWith.Len()).To(BeNumerically(">", b.Len()))
 	})
 
 	It("errors when the stateless_reset_token has the wrong length", func() {
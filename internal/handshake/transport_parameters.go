@@ -25,7 +25,9 @@ const (
 	initialMaxStreamDataUniParameterID        transportParameterID = 0x7
 	initialMaxStreamsBidiParameterID          transportParameterID = 0x8
 	initialMaxStreamsUniParameterID           transportParameterID = 0x9
+	maxAckDelayParameterID                    transportParameterID = 0xb
 	disableMigrationParameterID               transportParameterID = 0xc
+	maxDatagramFrameSizeParameterID           transportParameterID = 0x20
 )
 
 // TransportParameters are parameters sent to the peer during the handshake
@@ -36,12 +38,18 @@ type TransportParameters struct {
 	InitialMaxData                 protocol.ByteCount
 
 	MaxPacketSize protocol.ByteCount
+	// MaxDatagramFrameSize is the maximum size of a DATAGRAM frame the endpoint is willing to receive.
+	// 0 means that DATAGRAM frames are not supported.
+	MaxDatagramFrameSize protocol.ByteCount
 
 	MaxUniStreams  uint64
 	MaxBidiStreams uint64
 
 	IdleTimeout      time.Duration
 	DisableMigration bool
+	// MaxAckDelay is the maximum time by which the endpoint delays sending ACKs.
+	// If not sent by the peer, it defaults to 25ms.
+	MaxAckDelay time.Duration
 
 	StatelessResetToken  []byte
 	OriginalConnectionID protocol.ConnectionID
@@ -51,6 +59,8 @@ func (p *TransportParameters) unmarshal(data []byte, sentBy protocol.Perspective
 	// needed to check that every parameter is only sent at most once
 	var parameterIDs []transportParameterID
 
+	p.MaxAckDelay = protocol.DefaultMaxAckDelay
+
 	r := bytes.NewReader(data)
 	for r.Len() >= 4 {
 		paramIDInt, _ := utils.BigEndian.ReadUint16(r)
@@ -65,7 +75,9 @@ func (p *TransportParameters) unmarshal(data []byte, sentBy protocol.Perspective
 			initialMaxStreamsBidiParameterID,
 			initialMaxStreamsUniParameterID,
 			idleTimeoutParameterID,
-			maxPacketSizeParameterID:
+			maxPacketSizeParameterID,
+			maxAckDelayParameterID,
+			maxDatagramFrameSizeParameterID:
 			if err := p.readNumericTransportParameter(r, paramID, int(paramLen)); err != nil {
 				return err
 			}
@@ -147,6 +159,14 @@ func (p *TransportParameters) readNumericTransportParameter(
 			return fmt.Errorf("invalid value for max_packet_size: %d (minimum 1200)", val)
 		}
 		p.MaxPacketSize = protocol.ByteCount(val)
+	case maxAckDelayParameterID:
+		maxAckDelay := time.Duration(val) * time.Millisecond
+		if maxAckDelay > protocol.MaxMaxAckDelay {
+			return fmt.Errorf("invalid value for max_ack_delay: %dms (maximum %dms)", val, protocol.MaxMaxAckDelay/time.Millisecond)
+		}
+		p.MaxAckDelay = maxAckDelay
+	case maxDatagramFrameSizeParameterID:
+		p.MaxDatagramFrameSize = protocol.ByteCount(val)
 	default:
 		return fmt.Errorf("TransportParameter BUG: transport parameter %d not found", paramID)
 	}
@@ -186,6 +206,16 @@ func (p *TransportParameters) marshal(b *bytes.Buffer) {
 	utils.BigEndian.WriteUint16(b, uint16(maxPacketSizeParameterID))
 	utils.BigEndian.WriteUint16(b, uint16(utils.VarIntLen(uint64(protocol.MaxReceivePacketSize))))
 	utils.WriteVarInt(b, uint64(protocol.MaxReceivePacketSize))
+	// max_ack_delay
+	utils.BigEndian.WriteUint16(b, uint16(maxAckDelayParameterID))
+	utils.BigEndian.WriteUint16(b, uint16(utils.VarIntLen(uint64(p.MaxAckDelay/time.Millisecond))))
+	utils.WriteVarInt(b, uint64(p.MaxAckDelay/time.Millisecond))
+	// max_datagram_frame_size
+	if p.MaxDatagramFrameSize > 0 {
+		utils.BigEndian.WriteUint16(b, uint16(maxDatagramFrameSizeParameterID))
+		utils.BigEndian.WriteUint16(b, uint16(utils.VarIntLen(uint64(p.MaxDatagramFrameSize))))
+		utils.WriteVarInt(b, uint64(p.MaxDatagramFrameSize))
+	}
 	// disable_migration
 	if p.DisableMigration {
 		utils.BigEndian.WriteUint16(b, uint16(disableMigrationParameterID))
@@ -206,5 +236,5 @@ func (p *TransportParameters) marshal(b *bytes.Buffer) {
 
 // String returns a string representation, intended for logging.
 func (p *TransportParameters) String() string {
-	return fmt.Sprintf("&handshake.TransportParameters{OriginalConnectionID: %s, InitialMaxStreamDataBidiLocal: %#x, InitialMaxStreamDataBidiRemote: %#x, InitialMaxStreamDataUni: %#x, InitialMaxData: %#x, MaxBidiStreams: %d, MaxUniStreams: %d, IdleTimeout: %s}", p.OriginalConnectionID, p.InitialMaxStreamDataBidiLocal, p.InitialMaxStreamDataBidiRemote, p.InitialMaxStreamDataUni, p.InitialMaxData, p.MaxBidiStreams, p.MaxUniStreams, p.IdleTimeout)
+	return fmt.Sprintf("&handshake.TransportParameters{OriginalConnectionID: %s, InitialMaxStreamDataBidiLocal: %#x, InitialMaxStreamDataBidiRemote: %#x, InitialMaxStreamDataUni: %#x, InitialMaxData: %#x, MaxBidiStreams: %d, MaxUniStreams: %d, IdleTimeout: %s, MaxAckDelay: %s}", p.OriginalConnectionID, p.InitialMaxStreamDataBidiLocal, p.InitialMaxStreamDataBidiRemote, p.InitialMaxStreamDataUni, p.InitialMaxData, p.MaxBidiStreams, p.MaxUniStreams, p.IdleTimeout, p.MaxAckDelay)
 }
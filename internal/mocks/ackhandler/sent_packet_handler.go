@@ -62,6 +62,16 @@ func (mr *MockSentPacketHandlerMockRecorder) DequeueProbePacket() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DequeueProbePacket", reflect.TypeOf((*MockSentPacketHandler)(nil).DequeueProbePacket))
 }
 
+// EnableECN mocks base method
+func (m *MockSentPacketHandler) EnableECN(arg0 func()) {
+	m.ctrl.Call(m, "EnableECN", arg0)
+}
+
+// EnableECN indicates an expected call of EnableECN
+func (mr *MockSentPacketHandlerMockRecorder) EnableECN(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableECN", reflect.TypeOf((*MockSentPacketHandler)(nil).EnableECN), arg0)
+}
+
 // GetAlarmTimeout mocks base method
 func (m *MockSentPacketHandler) GetAlarmTimeout() time.Time {
 	ret := m.ctrl.Call(m, "GetAlarmTimeout")
@@ -86,6 +96,30 @@ func (mr *MockSentPacketHandlerMockRecorder) GetLowestPacketNotConfirmedAcked()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLowestPacketNotConfirmedAcked", reflect.TypeOf((*MockSentPacketHandler)(nil).GetLowestPacketNotConfirmedAcked))
 }
 
+// GetStats mocks base method
+func (m *MockSentPacketHandler) GetStats() ackhandler.Stats {
+	ret := m.ctrl.Call(m, "GetStats")
+	ret0, _ := ret[0].(ackhandler.Stats)
+	return ret0
+}
+
+// GetStats indicates an expected call of GetStats
+func (mr *MockSentPacketHandlerMockRecorder) GetStats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStats", reflect.TypeOf((*MockSentPacketHandler)(nil).GetStats))
+}
+
+// HasOutstandingData mocks base method
+func (m *MockSentPacketHandler) HasOutstandingData() bool {
+	ret := m.ctrl.Call(m, "HasOutstandingData")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasOutstandingData indicates an expected call of HasOutstandingData
+func (mr *MockSentPacketHandlerMockRecorder) HasOutstandingData() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasOutstandingData", reflect.TypeOf((*MockSentPacketHandler)(nil).HasOutstandingData))
+}
+
 // OnAlarm mocks base method
 func (m *MockSentPacketHandler) OnAlarm() error {
 	ret := m.ctrl.Call(m, "OnAlarm")
@@ -98,6 +132,18 @@ func (mr *MockSentPacketHandlerMockRecorder) OnAlarm() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnAlarm", reflect.TypeOf((*MockSentPacketHandler)(nil).OnAlarm))
 }
 
+// OnConnectionMigration mocks base method
+func (m *MockSentPacketHandler) OnConnectionMigration() error {
+	ret := m.ctrl.Call(m, "OnConnectionMigration")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// OnConnectionMigration indicates an expected call of OnConnectionMigration
+func (mr *MockSentPacketHandlerMockRecorder) OnConnectionMigration() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnConnectionMigration", reflect.TypeOf((*MockSentPacketHandler)(nil).OnConnectionMigration))
+}
+
 // PeekPacketNumber mocks base method
 func (m *MockSentPacketHandler) PeekPacketNumber() (protocol.PacketNumber, protocol.PacketNumberLen) {
 	ret := m.ctrl.Call(m, "PeekPacketNumber")
@@ -135,6 +181,40 @@ func (mr *MockSentPacketHandlerMockRecorder) ReceivedAck(arg0, arg1, arg2, arg3
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceivedAck", reflect.TypeOf((*MockSentPacketHandler)(nil).ReceivedAck), arg0, arg1, arg2, arg3)
 }
 
+// ReceivedBytes mocks base method
+func (m *MockSentPacketHandler) ReceivedBytes(arg0 protocol.ByteCount) {
+	m.ctrl.Call(m, "ReceivedBytes", arg0)
+}
+
+// ReceivedBytes indicates an expected call of ReceivedBytes
+func (mr *MockSentPacketHandlerMockRecorder) ReceivedBytes(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceivedBytes", reflect.TypeOf((*MockSentPacketHandler)(nil).ReceivedBytes), arg0)
+}
+
+// RegisterAckCallback mocks base method
+func (m *MockSentPacketHandler) RegisterAckCallback(arg0 protocol.PacketNumber, arg1 func(time.Duration)) error {
+	ret := m.ctrl.Call(m, "RegisterAckCallback", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RegisterAckCallback indicates an expected call of RegisterAckCallback
+func (mr *MockSentPacketHandlerMockRecorder) RegisterAckCallback(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterAckCallback", reflect.TypeOf((*MockSentPacketHandler)(nil).RegisterAckCallback), arg0, arg1)
+}
+
+// RegisterLossCallback mocks base method
+func (m *MockSentPacketHandler) RegisterLossCallback(arg0 protocol.PacketNumber, arg1 func()) error {
+	ret := m.ctrl.Call(m, "RegisterLossCallback", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RegisterLossCallback indicates an expected call of RegisterLossCallback
+func (mr *MockSentPacketHandlerMockRecorder) RegisterLossCallback(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterLossCallback", reflect.TypeOf((*MockSentPacketHandler)(nil).RegisterLossCallback), arg0, arg1)
+}
+
 // SendMode mocks base method
 func (m *MockSentPacketHandler) SendMode() ackhandler.SendMode {
 	ret := m.ctrl.Call(m, "SendMode")
@@ -177,6 +257,16 @@ func (mr *MockSentPacketHandlerMockRecorder) SetHandshakeComplete() *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHandshakeComplete", reflect.TypeOf((*MockSentPacketHandler)(nil).SetHandshakeComplete))
 }
 
+// SetPeerAddressValidated mocks base method
+func (m *MockSentPacketHandler) SetPeerAddressValidated() {
+	m.ctrl.Call(m, "SetPeerAddressValidated")
+}
+
+// SetPeerAddressValidated indicates an expected call of SetPeerAddressValidated
+func (mr *MockSentPacketHandlerMockRecorder) SetPeerAddressValidated() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPeerAddressValidated", reflect.TypeOf((*MockSentPacketHandler)(nil).SetPeerAddressValidated))
+}
+
 // ShouldSendNumPackets mocks base method
 func (m *MockSentPacketHandler) ShouldSendNumPackets() int {
 	ret := m.ctrl.Call(m, "ShouldSendNumPackets")
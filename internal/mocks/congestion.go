@@ -57,6 +57,16 @@ func (mr *MockSendAlgorithmMockRecorder) MaybeExitSlowStart() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MaybeExitSlowStart", reflect.TypeOf((*MockSendAlgorithm)(nil).MaybeExitSlowStart))
 }
 
+// OnCongestionEvent mocks base method
+func (m *MockSendAlgorithm) OnCongestionEvent(arg0 protocol.PacketNumber, arg1 protocol.ByteCount) {
+	m.ctrl.Call(m, "OnCongestionEvent", arg0, arg1)
+}
+
+// OnCongestionEvent indicates an expected call of OnCongestionEvent
+func (mr *MockSendAlgorithmMockRecorder) OnCongestionEvent(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnCongestionEvent", reflect.TypeOf((*MockSendAlgorithm)(nil).OnCongestionEvent), arg0, arg1)
+}
+
 // OnConnectionMigration mocks base method
 func (m *MockSendAlgorithm) OnConnectionMigration() {
 	m.ctrl.Call(m, "OnConnectionMigration")
@@ -77,6 +87,16 @@ func (mr *MockSendAlgorithmMockRecorder) OnPacketAcked(arg0, arg1, arg2, arg3 in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnPacketAcked", reflect.TypeOf((*MockSendAlgorithm)(nil).OnPacketAcked), arg0, arg1, arg2, arg3)
 }
 
+// OnPacketDiscarded mocks base method
+func (m *MockSendAlgorithm) OnPacketDiscarded(arg0 protocol.PacketNumber, arg1 protocol.ByteCount) {
+	m.ctrl.Call(m, "OnPacketDiscarded", arg0, arg1)
+}
+
+// OnPacketDiscarded indicates an expected call of OnPacketDiscarded
+func (mr *MockSendAlgorithmMockRecorder) OnPacketDiscarded(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnPacketDiscarded", reflect.TypeOf((*MockSendAlgorithm)(nil).OnPacketDiscarded), arg0, arg1)
+}
+
 // OnPacketLost mocks base method
 func (m *MockSendAlgorithm) OnPacketLost(arg0 protocol.PacketNumber, arg1, arg2 protocol.ByteCount) {
 	m.ctrl.Call(m, "OnPacketLost", arg0, arg1, arg2)
@@ -97,34 +117,14 @@ func (mr *MockSendAlgorithmMockRecorder) OnPacketSent(arg0, arg1, arg2, arg3, ar
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnPacketSent", reflect.TypeOf((*MockSendAlgorithm)(nil).OnPacketSent), arg0, arg1, arg2, arg3, arg4)
 }
 
-// OnRetransmissionTimeout mocks base method
-func (m *MockSendAlgorithm) OnRetransmissionTimeout(arg0 bool) {
-	m.ctrl.Call(m, "OnRetransmissionTimeout", arg0)
-}
-
-// OnRetransmissionTimeout indicates an expected call of OnRetransmissionTimeout
-func (mr *MockSendAlgorithmMockRecorder) OnRetransmissionTimeout(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnRetransmissionTimeout", reflect.TypeOf((*MockSendAlgorithm)(nil).OnRetransmissionTimeout), arg0)
-}
-
-// SetNumEmulatedConnections mocks base method
-func (m *MockSendAlgorithm) SetNumEmulatedConnections(arg0 int) {
-	m.ctrl.Call(m, "SetNumEmulatedConnections", arg0)
-}
-
-// SetNumEmulatedConnections indicates an expected call of SetNumEmulatedConnections
-func (mr *MockSendAlgorithmMockRecorder) SetNumEmulatedConnections(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNumEmulatedConnections", reflect.TypeOf((*MockSendAlgorithm)(nil).SetNumEmulatedConnections), arg0)
-}
-
-// SetSlowStartLargeReduction mocks base method
-func (m *MockSendAlgorithm) SetSlowStartLargeReduction(arg0 bool) {
-	m.ctrl.Call(m, "SetSlowStartLargeReduction", arg0)
+// OnPersistentCongestion mocks base method
+func (m *MockSendAlgorithm) OnPersistentCongestion() {
+	m.ctrl.Call(m, "OnPersistentCongestion")
 }
 
-// SetSlowStartLargeReduction indicates an expected call of SetSlowStartLargeReduction
-func (mr *MockSendAlgorithmMockRecorder) SetSlowStartLargeReduction(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSlowStartLargeReduction", reflect.TypeOf((*MockSendAlgorithm)(nil).SetSlowStartLargeReduction), arg0)
+// OnPersistentCongestion indicates an expected call of OnPersistentCongestion
+func (mr *MockSendAlgorithmMockRecorder) OnPersistentCongestion() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnPersistentCongestion", reflect.TypeOf((*MockSendAlgorithm)(nil).OnPersistentCongestion))
 }
 
 // TimeUntilSend mocks base method
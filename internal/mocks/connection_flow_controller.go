@@ -54,6 +54,18 @@ func (mr *MockConnectionFlowControllerMockRecorder) AddBytesSent(arg0 interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBytesSent", reflect.TypeOf((*MockConnectionFlowController)(nil).AddBytesSent), arg0)
 }
 
+// GetCoalescedWindowUpdate mocks base method
+func (m *MockConnectionFlowController) GetCoalescedWindowUpdate() protocol.ByteCount {
+	ret := m.ctrl.Call(m, "GetCoalescedWindowUpdate")
+	ret0, _ := ret[0].(protocol.ByteCount)
+	return ret0
+}
+
+// GetCoalescedWindowUpdate indicates an expected call of GetCoalescedWindowUpdate
+func (mr *MockConnectionFlowControllerMockRecorder) GetCoalescedWindowUpdate() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoalescedWindowUpdate", reflect.TypeOf((*MockConnectionFlowController)(nil).GetCoalescedWindowUpdate))
+}
+
 // GetWindowUpdate mocks base method
 func (m *MockConnectionFlowController) GetWindowUpdate() protocol.ByteCount {
 	ret := m.ctrl.Call(m, "GetWindowUpdate")
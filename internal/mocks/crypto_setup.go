@@ -59,6 +59,19 @@ func (mr *MockCryptoSetupMockRecorder) ConnectionState() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConnectionState", reflect.TypeOf((*MockCryptoSetup)(nil).ConnectionState))
 }
 
+// ExportKeyingMaterial mocks base method
+func (m *MockCryptoSetup) ExportKeyingMaterial(arg0 string, arg1 []byte, arg2 int) ([]byte, error) {
+	ret := m.ctrl.Call(m, "ExportKeyingMaterial", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportKeyingMaterial indicates an expected call of ExportKeyingMaterial
+func (mr *MockCryptoSetupMockRecorder) ExportKeyingMaterial(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportKeyingMaterial", reflect.TypeOf((*MockCryptoSetup)(nil).ExportKeyingMaterial), arg0, arg1, arg2)
+}
+
 // GetOpener mocks base method
 func (m *MockCryptoSetup) GetOpener(arg0 protocol.EncryptionLevel) (handshake.Opener, error) {
 	ret := m.ctrl.Call(m, "GetOpener", arg0)
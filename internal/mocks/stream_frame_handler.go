@@ -0,0 +1,55 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/lucas-clemente/quic-go/internal/ackhandler (interfaces: StreamFrameHandler)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	wire "github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+// MockStreamFrameHandler is a mock of StreamFrameHandler interface
+type MockStreamFrameHandler struct {
+	ctrl     *gomock.Controller
+	recorder *MockStreamFrameHandlerMockRecorder
+}
+
+// MockStreamFrameHandlerMockRecorder is the mock recorder for MockStreamFrameHandler
+type MockStreamFrameHandlerMockRecorder struct {
+	mock *MockStreamFrameHandler
+}
+
+// NewMockStreamFrameHandler creates a new mock instance
+func NewMockStreamFrameHandler(ctrl *gomock.Controller) *MockStreamFrameHandler {
+	mock := &MockStreamFrameHandler{ctrl: ctrl}
+	mock.recorder = &MockStreamFrameHandlerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockStreamFrameHandler) EXPECT() *MockStreamFrameHandlerMockRecorder {
+	return m.recorder
+}
+
+// OnStreamFrameAcked mocks base method
+func (m *MockStreamFrameHandler) OnStreamFrameAcked(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "OnStreamFrameAcked", arg0)
+}
+
+// OnStreamFrameAcked indicates an expected call of OnStreamFrameAcked
+func (mr *MockStreamFrameHandlerMockRecorder) OnStreamFrameAcked(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnStreamFrameAcked", reflect.TypeOf((*MockStreamFrameHandler)(nil).OnStreamFrameAcked), arg0)
+}
+
+// OnStreamFrameLost mocks base method
+func (m *MockStreamFrameHandler) OnStreamFrameLost(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "OnStreamFrameLost", arg0)
+}
+
+// OnStreamFrameLost indicates an expected call of OnStreamFrameLost
+func (mr *MockStreamFrameHandlerMockRecorder) OnStreamFrameLost(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnStreamFrameLost", reflect.TypeOf((*MockStreamFrameHandler)(nil).OnStreamFrameLost), arg0)
+}
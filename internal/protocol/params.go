@@ -16,6 +16,9 @@ const DefaultMaxCongestionWindow ByteCount = defaultMaxCongestionWindowPackets *
 // InitialCongestionWindow is the initial congestion window in QUIC packets
 const InitialCongestionWindow ByteCount = 32 * DefaultTCPMSS
 
+// DefaultMinCongestionWindow is the default for the minimum congestion window
+const DefaultMinCongestionWindow ByteCount = 2 * DefaultTCPMSS
+
 // MaxUndecryptablePackets limits the number of undecryptable packets that are queued in the session.
 const MaxUndecryptablePackets = 10
 
@@ -38,12 +41,32 @@ const DefaultMaxReceiveConnectionFlowControlWindow = 15 * (1 << 20) // 12 MB
 // WindowUpdateThreshold is the fraction of the receive window that has to be consumed before an higher offset is advertised to the client
 const WindowUpdateThreshold = 0.25
 
+// EarlyWindowUpdateThreshold is the fraction of the connection-level receive window that has to be consumed before a window update is sent early.
+// Window updates are sent early if the peer would use up the remaining window before the window update arrives,
+// and when a stream-level window update is sent anyway.
+const EarlyWindowUpdateThreshold = WindowUpdateThreshold / 2
+
 // DefaultMaxIncomingStreams is the maximum number of streams that a peer may open
 const DefaultMaxIncomingStreams = 100
 
 // DefaultMaxIncomingUniStreams is the maximum number of unidirectional streams that a peer may open
 const DefaultMaxIncomingUniStreams = 100
 
+// MaxNonQUICPacketQueueLen is the max number of non-QUIC packets queued for the Config.NonQUICPacketHandler.
+// If the handler doesn't keep up, packets are dropped.
+const MaxNonQUICPacketQueueLen = 32
+
+// MaxPacketBatchSize is the maximum number of packets read or written with a single syscall.
+// It only applies on platforms that support batched packet I/O.
+const MaxPacketBatchSize = 64
+
+// MaxGSOSegments is the maximum number of packets that are passed to the kernel as a single buffer,
+// when using UDP Generic Segmentation Offload (GSO).
+const MaxGSOSegments = 64
+
+// MaxGSOBufferSize is the maximum size of a buffer that is split into multiple packets by GSO.
+const MaxGSOBufferSize = 65507
+
 // MaxSessionUnprocessedPackets is the max number of packets stored in each session that are not yet processed.
 const MaxSessionUnprocessedPackets = defaultMaxCongestionWindowPackets
 
@@ -57,6 +80,14 @@ const MaxTrackedSkippedPackets = 10
 // If the queue is full, new connection attempts will be rejected.
 const MaxAcceptQueueSize = 32
 
+// DefaultMaxRateLimitedAddresses is the default number of client addresses (or address prefixes)
+// that the HandshakeRateLimiter keeps track of.
+const DefaultMaxRateLimitedAddresses = 10000
+
+// DefaultCookieRateFactor is the factor that the HandshakeRateLimiter multiplies the rate and the burst with
+// to get the default budget for connection attempts carrying a Cookie.
+const DefaultCookieRateFactor = 10
+
 // CookieExpiryTime is the valid time of a cookie
 const CookieExpiryTime = 24 * time.Hour
 
@@ -70,9 +101,20 @@ const MaxOutstandingSentPackets = 2 * defaultMaxCongestionWindowPackets
 // This value *must* be larger than MaxOutstandingSentPackets.
 const MaxTrackedSentPackets = MaxOutstandingSentPackets * 5 / 4
 
+// MaxTrackedLostPackets is the maximum number of lost packet numbers the SentPacketHandler keeps track of to detect spurious losses
+const MaxTrackedLostPackets = 100
+
+// PersistentCongestionThreshold is the number of PTOs.
+// If all packets sent over a period longer than this number of PTOs are lost, the path is considered to be persistently congested.
+const PersistentCongestionThreshold = 3
+
 // MaxTrackedReceivedAckRanges is the maximum number of ACK ranges tracked
 const MaxTrackedReceivedAckRanges = defaultMaxCongestionWindowPackets
 
+// MaxNumAckRanges is the maximum number of ACK ranges that we send in an ACK frame.
+// If more ranges are tracked, the ranges with the lowest packet numbers are not reported.
+const MaxNumAckRanges = 32
+
 // MaxNonRetransmittableAcks is the maximum number of packets containing an ACK, but no retransmittable frames, that we send in a row
 const MaxNonRetransmittableAcks = 19
 
@@ -80,6 +122,10 @@ const MaxNonRetransmittableAcks = 19
 // prevents DoS attacks against the streamFrameSorter
 const MaxStreamFrameSorterGaps = 1000
 
+// MinStreamFrameBufferSize is the minimum data length of a received STREAM frame for which the packet buffer is retained.
+// The data of smaller frames is copied, so that a peer can't make us hold on to a full packet buffer for every few bytes of data.
+const MinStreamFrameBufferSize = 128
+
 // MaxCryptoStreamOffset is the maximum offset allowed on any of the crypto streams.
 // This limits the size of the ClientHello and Certificates that can be received.
 const MaxCryptoStreamOffset = 16 * (1 << 10)
@@ -87,12 +133,31 @@ const MaxCryptoStreamOffset = 16 * (1 << 10)
 // MinRemoteIdleTimeout is the minimum value that we accept for the remote idle timeout
 const MinRemoteIdleTimeout = 5 * time.Second
 
+// DefaultMaxAckDelay is the default maximum time by which we delay sending ACKs
+const DefaultMaxAckDelay = 25 * time.Millisecond
+
+// MaxMaxAckDelay is the largest max_ack_delay that can be advertised in the transport parameters
+const MaxMaxAckDelay = (1<<14 - 1) * time.Millisecond
+
+// DefaultAckFrequency is the default number of retransmittable packets received before an ACK is sent
+const DefaultAckFrequency = 2
+
 // DefaultIdleTimeout is the default idle timeout
 const DefaultIdleTimeout = 30 * time.Second
 
 // DefaultHandshakeTimeout is the default timeout for a connection until the crypto handshake succeeds.
 const DefaultHandshakeTimeout = 10 * time.Second
 
+// NetConnCloseTimeout is the time we wait for all data to be delivered,
+// before closing the session when a net.Conn returned by quic.NewConn is closed.
+const NetConnCloseTimeout = 10 * time.Second
+
+// MaxPathValidationTime is the time we wait for the PATH_RESPONSE when validating a new path.
+const MaxPathValidationTime = 3 * time.Second
+
+// AmplificationFactor is the maximum ratio between the bytes sent to and received from an unvalidated address.
+const AmplificationFactor = 3
+
 // RetiredConnectionIDDeleteTimeout is the time we keep closed sessions around in order to retransmit the CONNECTION_CLOSE.
 // after this time all information about the old connection will be deleted
 const RetiredConnectionIDDeleteTimeout = 5 * time.Second
@@ -103,12 +168,23 @@ const RetiredConnectionIDDeleteTimeout = 5 * time.Second
 // 2. it reduces the head-of-line blocking, when a packet is lost
 const MinStreamFrameSize ByteCount = 128
 
+// ReadFromChunkSize is the number of bytes that a send stream reads from an io.Reader at once, when using ReadFrom.
+const ReadFromChunkSize ByteCount = 64 * (1 << 10)
+
+// MinReadFromOwnedSize is the minimum number of bytes returned by a single Read in ReadFrom,
+// for the read buffer to be used for sending STREAM frames directly.
+// Smaller reads are copied, so that the STREAM frames don't keep the whole read buffer alive.
+const MinReadFromOwnedSize ByteCount = ReadFromChunkSize * 3 / 4
+
 // MaxAckFrameSize is the maximum size for an ACK frame that we write
 // Due to the varint encoding, ACK frames can grow (almost) indefinitely large.
 // The MaxAckFrameSize should be large enough to encode many ACK range,
 // but must ensure that a maximum size ACK frame fits into one packet.
 const MaxAckFrameSize ByteCount = 1000
 
+// MaxPacingBurstPackets is the number of packets that can be sent in a burst, before packets are paced.
+const MaxPacingBurstPackets = 10
+
 // MinPacingDelay is the minimum duration that is used for packet pacing
 // If the packet packing frequency is higher, multiple packets might be sent at once.
 // Example: For a packet pacing delay of 20 microseconds, we would send 5 packets at once, wait for 100 microseconds, and so forth.
@@ -117,3 +193,30 @@ const MinPacingDelay time.Duration = 100 * time.Microsecond
 // DefaultConnectionIDLength is the connection ID length that is used for multiplexed connections
 // if no other value is configured.
 const DefaultConnectionIDLength = 4
+
+// DefaultActiveConnectionIDs is the number of connection IDs the server issues to the client,
+// including the connection ID used during the handshake.
+const DefaultActiveConnectionIDs = 4
+
+// MaxActiveConnectionIDs is the maximum number of connection IDs issued by the peer that we keep track of.
+const MaxActiveConnectionIDs = 8
+
+// MaxConnectionIDGenerationAttempts is the number of times we try to generate a connection ID that is not in use yet.
+const MaxConnectionIDGenerationAttempts = 10
+
+// MaxDatagramFrameSize is the maximum size of a DATAGRAM frame.
+// A DATAGRAM frame can't be split across multiple packets, so it has to fit into the smallest packet a QUIC endpoint is required to support,
+// after subtracting the longest short header and the AEAD overhead.
+const MaxDatagramFrameSize ByteCount = MinInitialPacketSize - 1 /* type byte */ - maxConnectionIDLen - 4 /* packet number */ - 16 /* AEAD overhead */
+
+// DatagramRcvQueueLen is the maximum number of received DATAGRAM frames that are queued until they are read by the application.
+// When the queue is full, DATAGRAM frames are dropped.
+const DatagramRcvQueueLen = 128
+
+// DatagramSendQueueLen is the maximum number of DATAGRAM frames that are queued for sending.
+// When the queue is full, sending a message blocks.
+const DatagramSendQueueLen = 16
+
+// SpinBitDisableRatio determines the fraction of connections that don't use the latency spin bit.
+// The spin bit is disabled for 1 in SpinBitDisableRatio connections, so that an observer can't rely on it being used.
+const SpinBitDisableRatio = 16
@@ -36,6 +36,36 @@ func (t PacketType) String() string {
 	}
 }
 
+// The ECN is the ECN codepoint of an IP packet.
+// The values correspond to the two ECN bits in the IP header.
+type ECN uint8
+
+const (
+	// ECNNon is the codepoint of packets not using ECN (Not-ECT)
+	ECNNon ECN = iota
+	// ECT1 is the ECT(1) codepoint
+	ECT1
+	// ECT0 is the ECT(0) codepoint
+	ECT0
+	// ECNCE is the codepoint of packets that experienced congestion (CE)
+	ECNCE
+)
+
+func (e ECN) String() string {
+	switch e {
+	case ECNNon:
+		return "Not-ECT"
+	case ECT1:
+		return "ECT(1)"
+	case ECT0:
+		return "ECT(0)"
+	case ECNCE:
+		return "CE"
+	default:
+		return fmt.Sprintf("invalid ECN value: %d", e)
+	}
+}
+
 // A ByteCount in QUIC
 type ByteCount uint64
 
@@ -74,9 +74,13 @@ func IsSupportedVersion(supported []VersionNumber, v VersionNumber) bool {
 // ChooseSupportedVersion finds the best version in the overlap of ours and theirs
 // ours is a slice of versions that we support, sorted by our preference (descending)
 // theirs is a slice of versions offered by the peer. The order does not matter.
+// Reserved versions are never chosen.
 // The bool returned indicates if a matching version was found.
 func ChooseSupportedVersion(ours, theirs []VersionNumber) (VersionNumber, bool) {
 	for _, ourVer := range ours {
+		if IsReservedVersion(ourVer) {
+			continue
+		}
 		for _, theirVer := range theirs {
 			if ourVer == theirVer {
 				return ourVer, true
@@ -86,8 +90,14 @@ func ChooseSupportedVersion(ours, theirs []VersionNumber) (VersionNumber, bool)
 	return 0, false
 }
 
-// generateReservedVersion generates a reserved version number (v & 0x0f0f0f0f == 0x0a0a0a0a)
-func generateReservedVersion() VersionNumber {
+// IsReservedVersion says if a version is a reserved version (v & 0x0f0f0f0f == 0x0a0a0a0a).
+// Reserved versions are used to exercise version negotiation, and are never negotiated.
+func IsReservedVersion(v VersionNumber) bool {
+	return v&0x0f0f0f0f == 0x0a0a0a0a
+}
+
+// GenerateReservedVersion generates a random reserved version number
+func GenerateReservedVersion() VersionNumber {
 	b := make([]byte, 4)
 	_, _ = rand.Read(b) // ignore the error here. Failure to read random data doesn't break anything
 	return VersionNumber((binary.BigEndian.Uint32(b) | 0x0a0a0a0a) & 0xfafafafa)
@@ -100,7 +110,7 @@ func GetGreasedVersions(supported []VersionNumber) []VersionNumber {
 	randPos := int(b[0]) % (len(supported) + 1)
 	greased := make([]VersionNumber, len(supported)+1)
 	copy(greased, supported[:randPos])
-	greased[randPos] = generateReservedVersion()
+	greased[randPos] = GenerateReservedVersion()
 	copy(greased[randPos+1:], supported[randPos:])
 	return greased
 }
@@ -109,7 +119,7 @@ func GetGreasedVersions(supported []VersionNumber) []VersionNumber {
 func StripGreasedVersions(versions []VersionNumber) []VersionNumber {
 	realVersions := make([]VersionNumber, 0, len(versions))
 	for _, v := range versions {
-		if v&0x0f0f0f0f != 0x0a0a0a0a {
+		if !IsReservedVersion(v) {
 			realVersions = append(realVersions, v)
 		}
 	}
@@ -6,10 +6,6 @@ import (
 )
 
 var _ = Describe("Version", func() {
-	isReservedVersion := func(v VersionNumber) bool {
-		return v&0x0f0f0f0f == 0x0a0a0a0a
-	}
-
 	It("says if a version is valid", func() {
 		Expect(IsValidVersion(VersionTLS)).To(BeTrue())
 		Expect(IsValidVersion(VersionWhatever)).To(BeFalse())
@@ -18,7 +14,7 @@ var _ = Describe("Version", func() {
 	})
 
 	It("versions don't have reserved version numbers", func() {
-		Expect(isReservedVersion(VersionTLS)).To(BeFalse())
+		Expect(IsReservedVersion(VersionTLS)).To(BeFalse())
 	})
 
 	It("has the right string representation", func() {
@@ -67,6 +63,15 @@ var _ = Describe("Version", func() {
 			Expect(ok).To(BeFalse())
 		})
 
+		It("never picks a reserved version", func() {
+			reserved := GenerateReservedVersion()
+			ver, ok := ChooseSupportedVersion([]VersionNumber{reserved, 1}, []VersionNumber{1, reserved})
+			Expect(ok).To(BeTrue())
+			Expect(ver).To(Equal(VersionNumber(1)))
+			_, ok = ChooseSupportedVersion([]VersionNumber{reserved}, []VersionNumber{reserved})
+			Expect(ok).To(BeFalse())
+		})
+
 		It("handles empty inputs", func() {
 			_, ok := ChooseSupportedVersion([]VersionNumber{102, 101}, []VersionNumber{})
 			Expect(ok).To(BeFalse())
@@ -78,10 +83,26 @@ var _ = Describe("Version", func() {
 	})
 
 	Context("reserved versions", func() {
+		It("says if a version is reserved", func() {
+			Expect(IsReservedVersion(0x0a0a0a0a)).To(BeTrue())
+			Expect(IsReservedVersion(0x1a2a3a4a)).To(BeTrue())
+			Expect(IsReservedVersion(0x1a2a3a4b)).To(BeFalse())
+		})
+
+		It("generates random reserved versions", func() {
+			versions := make(map[VersionNumber]struct{})
+			for i := 0; i < 10; i++ {
+				v := GenerateReservedVersion()
+				Expect(IsReservedVersion(v)).To(BeTrue())
+				versions[v] = struct{}{}
+			}
+			Expect(len(versions)).To(BeNumerically(">", 1))
+		})
+
 		It("adds a greased version if passed an empty slice", func() {
 			greased := GetGreasedVersions([]VersionNumber{})
 			Expect(greased).To(HaveLen(1))
-			Expect(isReservedVersion(greased[0])).To(BeTrue())
+			Expect(IsReservedVersion(greased[0])).To(BeTrue())
 		})
 
 		It("strips greased versions", func() {
@@ -96,7 +117,7 @@ var _ = Describe("Version", func() {
 		It("creates greased lists of version numbers", func() {
 			supported := []VersionNumber{10, 18, 29}
 			for _, v := range supported {
-				Expect(isReservedVersion(v)).To(BeFalse())
+				Expect(IsReservedVersion(v)).To(BeFalse())
 			}
 			var greasedVersionFirst, greasedVersionLast, greasedVersionMiddle int
 			// check that
@@ -109,7 +130,7 @@ var _ = Describe("Version", func() {
 				Expect(greased).To(HaveLen(4))
 				var j int
 				for i, v := range greased {
-					if isReservedVersion(v) {
+					if IsReservedVersion(v) {
 						if i == 0 {
 							greasedVersionFirst++
 						}
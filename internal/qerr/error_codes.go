@@ -154,7 +154,7 @@ const (
 	CryptoNoSupport ErrorCode = 40
 	// The server rejected our client hello messages too many times.
 	CryptoTooManyRejects ErrorCode = 41
-	// The client rejected the server's certificate chain or signature.
+	// The peer's certificate chain or signature was rejected.
 	ProofInvalid ErrorCode = 42
 	// A crypto message was received with a duplicate tag.
 	CryptoDuplicateTag ErrorCode = 43
@@ -190,4 +190,9 @@ const (
 	ConnectionMigrationNoNewNetwork ErrorCode = 83
 	// Network changed, but connection had one or more non-migratable streams.
 	ConnectionMigrationNonMigratableStream ErrorCode = 84
+
+	// TLS alerts.
+	// The endpoints couldn't agree on an application protocol using ALPN.
+	// This is the CRYPTO_ERROR for the TLS no_application_protocol alert (0x100 + 120).
+	NoApplicationProtocol ErrorCode = 376
 )
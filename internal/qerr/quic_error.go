@@ -13,8 +13,9 @@ func (e ErrorCode) Error() string {
 
 // A QuicError consists of an error code plus a error reason
 type QuicError struct {
-	ErrorCode    ErrorCode
-	ErrorMessage string
+	ErrorCode          ErrorCode
+	ErrorMessage       string
+	isApplicationError bool
 }
 
 // Error creates a new QuicError instance
@@ -25,10 +26,27 @@ func Error(errorCode ErrorCode, errorMessage string) *QuicError {
 	}
 }
 
+// ApplicationError creates a new QuicError instance for an application error
+func ApplicationError(errorCode ErrorCode, errorMessage string) *QuicError {
+	return &QuicError{
+		ErrorCode:          errorCode,
+		ErrorMessage:       errorMessage,
+		isApplicationError: true,
+	}
+}
+
 func (e *QuicError) Error() string {
+	if e.isApplicationError {
+		return fmt.Sprintf("Application error %#x: %s", uint16(e.ErrorCode), e.ErrorMessage)
+	}
 	return fmt.Sprintf("%s: %s", e.ErrorCode.String(), e.ErrorMessage)
 }
 
+// IsApplicationError says if this error is an application error
+func (e *QuicError) IsApplicationError() bool {
+	return e.isApplicationError
+}
+
 // Timeout says if this error is a timeout.
 func (e *QuicError) Timeout() bool {
 	switch e.ErrorCode {
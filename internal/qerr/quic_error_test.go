@@ -12,6 +12,13 @@ var _ = Describe("Quic error", func() {
 		It("has a string representation", func() {
 			err := Error(DecryptionFailure, "foobar")
 			Expect(err.Error()).To(Equal("DecryptionFailure: foobar"))
+			Expect(err.IsApplicationError()).To(BeFalse())
+		})
+
+		It("has a string representation for application errors", func() {
+			err := ApplicationError(0x42, "foobar")
+			Expect(err.Error()).To(Equal("Application error 0x42: foobar"))
+			Expect(err.IsApplicationError()).To(BeTrue())
 		})
 	})
 
@@ -109,6 +109,68 @@ func (l *defaultLogger) Debug() bool {
 	return l.logLevel == LogLevelDebug
 }
 
+// A BaseLogger only implements the logging functions.
+// It is used for loggers provided by the application.
+type BaseLogger interface {
+	Debug() bool
+
+	Errorf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+}
+
+type baseLoggerWrapper struct {
+	logger BaseLogger
+	prefix string
+}
+
+var _ Logger = &baseLoggerWrapper{}
+
+// NewLogger wraps a logger provided by the application.
+// The log level is controlled by the application, SetLogLevel and SetLogTimeFormat have no effect.
+func NewLogger(l BaseLogger) Logger {
+	return &baseLoggerWrapper{logger: l}
+}
+
+func (l *baseLoggerWrapper) SetLogLevel(LogLevel)    {}
+func (l *baseLoggerWrapper) SetLogTimeFormat(string) {}
+
+func (l *baseLoggerWrapper) WithPrefix(prefix string) Logger {
+	if len(l.prefix) > 0 {
+		prefix = l.prefix + " " + prefix
+	}
+	return &baseLoggerWrapper{logger: l.logger, prefix: prefix}
+}
+
+// Debug returns true if the application logger has debug logging enabled
+func (l *baseLoggerWrapper) Debug() bool {
+	return l.logger.Debug()
+}
+
+// Debugf logs something, if debug logging is enabled
+func (l *baseLoggerWrapper) Debugf(format string, args ...interface{}) {
+	if l.logger.Debug() {
+		l.logger.Debugf(l.withPrefix(format), args...)
+	}
+}
+
+// Infof logs something
+func (l *baseLoggerWrapper) Infof(format string, args ...interface{}) {
+	l.logger.Infof(l.withPrefix(format), args...)
+}
+
+// Errorf logs something
+func (l *baseLoggerWrapper) Errorf(format string, args ...interface{}) {
+	l.logger.Errorf(l.withPrefix(format), args...)
+}
+
+func (l *baseLoggerWrapper) withPrefix(format string) string {
+	if len(l.prefix) == 0 {
+		return format
+	}
+	return l.prefix + " " + format
+}
+
 func init() {
 	DefaultLogger = &defaultLogger{}
 	DefaultLogger.SetLogLevel(readLoggingEnv())
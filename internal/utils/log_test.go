@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -108,6 +109,43 @@ var _ = Describe("Log", func() {
 		Expect(b.String()).To(ContainSubstring("debug"))
 	})
 
+	Context("wrapping an application logger", func() {
+		var l *recordingLogger
+
+		BeforeEach(func() {
+			l = &recordingLogger{}
+		})
+
+		It("logs with a prefix", func() {
+			logger := NewLogger(l).WithPrefix("client").WithPrefix("0xdeadbeef")
+			logger.Infof("info %d", 42)
+			logger.Errorf("error %d", 1337)
+			Expect(l.messages).To(Equal([]string{"client 0xdeadbeef info 42", "client 0xdeadbeef error 1337"}))
+		})
+
+		It("logs without a prefix", func() {
+			NewLogger(l).Infof("info")
+			Expect(l.messages).To(Equal([]string{"info"}))
+		})
+
+		It("only logs debug messages if debug logging is enabled", func() {
+			logger := NewLogger(l)
+			Expect(logger.Debug()).To(BeFalse())
+			logger.Debugf("debug")
+			Expect(l.messages).To(BeEmpty())
+			l.debug = true
+			Expect(logger.Debug()).To(BeTrue())
+			logger.Debugf("debug")
+			Expect(l.messages).To(Equal([]string{"debug"}))
+		})
+
+		It("ignores changes of the log level", func() {
+			logger := NewLogger(l)
+			logger.SetLogLevel(LogLevelDebug)
+			Expect(logger.Debug()).To(BeFalse())
+		})
+	})
+
 	Context("reading from env", func() {
 		BeforeEach(func() {
 			Expect(DefaultLogger.(*defaultLogger).logLevel).To(Equal(LogLevelNothing))
@@ -142,3 +180,24 @@ var _ = Describe("Log", func() {
 		})
 	})
 })
+
+type recordingLogger struct {
+	debug    bool
+	messages []string
+}
+
+var _ BaseLogger = &recordingLogger{}
+
+func (l *recordingLogger) Debug() bool { return l.debug }
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
@@ -19,42 +19,55 @@ var errInvalidAckRanges = errors.New("AckFrame: ACK frame contains invalid ACK r
 type AckFrame struct {
 	AckRanges []AckRange // has to be ordered. The highest ACK range goes first, the lowest ACK range goes last
 	DelayTime time.Duration
+
+	// the ECN counts are only sent in ACK_ECN frames
+	ECT0, ECT1, ECNCE uint64
 }
 
 // parseAckFrame reads an ACK frame
 func parseAckFrame(r *bytes.Reader, version protocol.VersionNumber) (*AckFrame, error) {
+	frame := &AckFrame{}
+	if err := parseAckFrameInto(frame, r, version); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// parseAckFrameInto reads an ACK frame into frame.
+// The underlying array of frame.AckRanges is reused.
+func parseAckFrameInto(frame *AckFrame, r *bytes.Reader, version protocol.VersionNumber) error {
 	typeByte, err := r.ReadByte()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	ecn := typeByte&0x1 > 0
 
-	frame := &AckFrame{}
+	*frame = AckFrame{AckRanges: frame.AckRanges[:0]}
 
 	la, err := utils.ReadVarInt(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	largestAcked := protocol.PacketNumber(la)
 	delay, err := utils.ReadVarInt(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	frame.DelayTime = time.Duration(delay*1<<ackDelayExponent) * time.Microsecond
 
 	numBlocks, err := utils.ReadVarInt(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// read the first ACK range
 	ab, err := utils.ReadVarInt(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	ackBlock := protocol.PacketNumber(ab)
 	if ackBlock > largestAcked {
-		return nil, errors.New("invalid first ACK range")
+		return errors.New("invalid first ACK range")
 	}
 	smallest := largestAcked - ackBlock
 
@@ -63,46 +76,53 @@ func parseAckFrame(r *bytes.Reader, version protocol.VersionNumber) (*AckFrame,
 	for i := uint64(0); i < numBlocks; i++ {
 		g, err := utils.ReadVarInt(r)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		gap := protocol.PacketNumber(g)
 		if smallest < gap+2 {
-			return nil, errInvalidAckRanges
+			return errInvalidAckRanges
 		}
 		largest := smallest - gap - 2
 
 		ab, err := utils.ReadVarInt(r)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		ackBlock := protocol.PacketNumber(ab)
 
 		if ackBlock > largest {
-			return nil, errInvalidAckRanges
+			return errInvalidAckRanges
 		}
 		smallest = largest - ackBlock
 		frame.AckRanges = append(frame.AckRanges, AckRange{Smallest: smallest, Largest: largest})
 	}
 
 	if !frame.validateAckRanges() {
-		return nil, errInvalidAckRanges
+		return errInvalidAckRanges
 	}
 
-	// parse (and skip) the ECN section
+	// parse the ECN section
 	if ecn {
-		for i := 0; i < 3; i++ {
-			if _, err := utils.ReadVarInt(r); err != nil {
-				return nil, err
+		for _, count := range []*uint64{&frame.ECT0, &frame.ECT1, &frame.ECNCE} {
+			c, err := utils.ReadVarInt(r)
+			if err != nil {
+				return err
 			}
+			*count = c
 		}
 	}
 
-	return frame, nil
+	return nil
 }
 
 // Write writes an ACK frame.
 func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
-	b.WriteByte(0x2)
+	hasECN := f.hasECN()
+	if hasECN {
+		b.WriteByte(0x3)
+	} else {
+		b.WriteByte(0x2)
+	}
 	utils.WriteVarInt(b, uint64(f.LargestAcked()))
 	utils.WriteVarInt(b, encodeAckDelay(f.DelayTime))
 
@@ -119,6 +139,12 @@ func (f *AckFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error
 		utils.WriteVarInt(b, gap)
 		utils.WriteVarInt(b, len)
 	}
+
+	if hasECN {
+		utils.WriteVarInt(b, f.ECT0)
+		utils.WriteVarInt(b, f.ECT1)
+		utils.WriteVarInt(b, f.ECNCE)
+	}
 	return nil
 }
 
@@ -138,6 +164,9 @@ func (f *AckFrame) Length(version protocol.VersionNumber) protocol.ByteCount {
 		length += utils.VarIntLen(gap)
 		length += utils.VarIntLen(len)
 	}
+	if f.hasECN() {
+		length += utils.VarIntLen(f.ECT0) + utils.VarIntLen(f.ECT1) + utils.VarIntLen(f.ECNCE)
+	}
 	return length
 }
 
@@ -146,6 +175,9 @@ func (f *AckFrame) Length(version protocol.VersionNumber) protocol.ByteCount {
 func (f *AckFrame) numEncodableAckRanges() int {
 	length := 1 + utils.VarIntLen(uint64(f.LargestAcked())) + utils.VarIntLen(encodeAckDelay(f.DelayTime))
 	length += 2 // assume that the number of ranges will consume 2 bytes
+	if f.hasECN() {
+		length += utils.VarIntLen(f.ECT0) + utils.VarIntLen(f.ECT1) + utils.VarIntLen(f.ECNCE)
+	}
 	for i := 1; i < len(f.AckRanges); i++ {
 		gap, len := f.encodeAckRange(i)
 		rangeLen := utils.VarIntLen(gap) + utils.VarIntLen(len)
@@ -167,6 +199,10 @@ func (f *AckFrame) encodeAckRange(i int) (uint64 /* gap */, uint64 /* length */)
 		uint64(f.AckRanges[i].Largest - f.AckRanges[i].Smallest)
 }
 
+func (f *AckFrame) hasECN() bool {
+	return f.ECT0 > 0 || f.ECT1 > 0 || f.ECNCE > 0
+}
+
 // HasMissingRanges returns if this frame reports any missing packets
 func (f *AckFrame) HasMissingRanges() bool {
 	return len(f.AckRanges) > 1
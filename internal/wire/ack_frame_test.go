@@ -145,6 +145,9 @@ var _ = Describe("ACK Frame (for IETF QUIC)", func() {
 				Expect(frame.LargestAcked()).To(Equal(protocol.PacketNumber(100)))
 				Expect(frame.LowestAcked()).To(Equal(protocol.PacketNumber(90)))
 				Expect(frame.HasMissingRanges()).To(BeFalse())
+				Expect(frame.ECT0).To(BeEquivalentTo(0x42))
+				Expect(frame.ECT1).To(BeEquivalentTo(0x12345))
+				Expect(frame.ECNCE).To(BeEquivalentTo(0x12345678))
 				Expect(b.Len()).To(BeZero())
 			})
 
@@ -186,6 +189,30 @@ var _ = Describe("ACK Frame (for IETF QUIC)", func() {
 			Expect(buf.Bytes()).To(Equal(expected))
 		})
 
+		It("writes a frame with ECN counts", func() {
+			buf := &bytes.Buffer{}
+			f := &AckFrame{
+				AckRanges: []AckRange{{Smallest: 100, Largest: 1337}},
+				ECT0:      0x42,
+				ECNCE:     0x1337,
+			}
+			err := f.Write(buf, versionIETFFrames)
+			Expect(err).ToNot(HaveOccurred())
+			expected := []byte{0x3}
+			expected = append(expected, encodeVarInt(1337)...) // largest acked
+			expected = append(expected, 0)                     // delay
+			expected = append(expected, encodeVarInt(0)...)    // num ranges
+			expected = append(expected, encodeVarInt(1337-100)...)
+			expected = append(expected, encodeVarInt(0x42)...)   // ECT(0)
+			expected = append(expected, encodeVarInt(0)...)      // ECT(1)
+			expected = append(expected, encodeVarInt(0x1337)...) // ECN-CE
+			Expect(buf.Bytes()).To(Equal(expected))
+			Expect(f.Length(versionIETFFrames)).To(BeEquivalentTo(buf.Len()))
+			frame, err := parseAckFrame(bytes.NewReader(buf.Bytes()), versionIETFFrames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame).To(Equal(f))
+		})
+
 		It("writes a frame that acks a single packet", func() {
 			buf := &bytes.Buffer{}
 			f := &AckFrame{
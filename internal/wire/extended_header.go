@@ -20,6 +20,8 @@ type ExtendedHeader struct {
 	PacketNumber    protocol.PacketNumber
 
 	KeyPhase int
+	// SpinBit is the latency spin bit. It is only present in the short header.
+	SpinBit bool
 }
 
 func (h *ExtendedHeader) parse(b *bytes.Reader, v protocol.VersionNumber) (*ExtendedHeader, error) {
@@ -53,6 +55,7 @@ func (h *ExtendedHeader) parseShortHeader(b *bytes.Reader, v protocol.VersionNum
 		return nil, errors.New("4th and 5th bit must be 0")
 	}
 
+	h.SpinBit = h.typeByte&0x20 > 0
 	h.KeyPhase = int(h.typeByte&0x4) >> 2
 
 	if err := h.readPacketNumber(b); err != nil {
@@ -130,6 +133,9 @@ func (h *ExtendedHeader) writeLongHeader(b *bytes.Buffer, v protocol.VersionNumb
 func (h *ExtendedHeader) writeShortHeader(b *bytes.Buffer, v protocol.VersionNumber) error {
 	typeByte := 0x40 | uint8(h.PacketNumberLen-1)
 	typeByte |= byte(h.KeyPhase << 2)
+	if h.SpinBit {
+		typeByte |= 0x20
+	}
 
 	b.WriteByte(typeByte)
 	b.Write(h.DestConnectionID.Bytes())
@@ -176,7 +182,7 @@ func (h *ExtendedHeader) Log(logger utils.Logger) {
 		}
 		logger.Debugf("\tLong Header{Type: %s, DestConnectionID: %s, SrcConnectionID: %s, %sPacketNumber: %#x, PacketNumberLen: %d, Length: %d, Version: %s}", h.Type, h.DestConnectionID, h.SrcConnectionID, token, h.PacketNumber, h.PacketNumberLen, h.Length, h.Version)
 	} else {
-		logger.Debugf("\tShort Header{DestConnectionID: %s, PacketNumber: %#x, PacketNumberLen: %d, KeyPhase: %d}", h.DestConnectionID, h.PacketNumber, h.PacketNumberLen, h.KeyPhase)
+		logger.Debugf("\tShort Header{DestConnectionID: %s, PacketNumber: %#x, PacketNumberLen: %d, KeyPhase: %d, SpinBit: %t}", h.DestConnectionID, h.PacketNumber, h.PacketNumberLen, h.KeyPhase, h.SpinBit)
 	}
 }
 
@@ -11,6 +11,42 @@ import (
 // ParseNextFrame parses the next frame
 // It skips PADDING frames.
 func ParseNextFrame(r *bytes.Reader, v protocol.VersionNumber) (Frame, error) {
+	return parseNextFrame(r, nil, nil, v)
+}
+
+// ParseNextFrameNoCopy parses the next frame, like ParseNextFrame.
+// r must read from data.
+// The data of STREAM frames is not copied, it is a slice of data.
+// The caller must make sure that data is not modified as long as the frame is used.
+func ParseNextFrameNoCopy(r *bytes.Reader, data []byte, v protocol.VersionNumber) (Frame, error) {
+	return parseNextFrame(r, data, nil, v)
+}
+
+// A FrameParser parses frames, reusing the frame structs of STREAM and ACK frames.
+// These are by far the most common frames, and allocating them for every packet is expensive.
+// A STREAM or ACK frame returned by ParseNext is only valid until the next call to ParseNext:
+// the caller must finish handling it before parsing the next frame, and must not retain it.
+// All other frames are allocated, and are owned by the caller.
+type FrameParser struct {
+	version protocol.VersionNumber
+
+	streamFrame StreamFrame
+	ackFrame    AckFrame
+}
+
+// NewFrameParser creates a new frame parser.
+func NewFrameParser(v protocol.VersionNumber) *FrameParser {
+	return &FrameParser{version: v}
+}
+
+// ParseNext parses the next frame, like ParseNextFrameNoCopy.
+// r must read from data.
+// It skips PADDING frames.
+func (p *FrameParser) ParseNext(r *bytes.Reader, data []byte) (Frame, error) {
+	return parseNextFrame(r, data, p, p.version)
+}
+
+func parseNextFrame(r *bytes.Reader, data []byte, fp *FrameParser, v protocol.VersionNumber) (Frame, error) {
 	for r.Len() != 0 {
 		typeByte, _ := r.ReadByte()
 		if typeByte == 0x0 { // PADDING frame
@@ -18,26 +54,40 @@ func ParseNextFrame(r *bytes.Reader, v protocol.VersionNumber) (Frame, error) {
 		}
 		r.UnreadByte()
 
-		return parseFrame(r, typeByte, v)
+		return parseFrame(r, data, fp, typeByte, v)
 	}
 	return nil, nil
 }
 
-func parseFrame(r *bytes.Reader, typeByte byte, v protocol.VersionNumber) (Frame, error) {
+// parseFrame parses a frame.
+// If fp is non-nil, STREAM and ACK frames are parsed into the frame structs of fp.
+func parseFrame(r *bytes.Reader, data []byte, fp *FrameParser, typeByte byte, v protocol.VersionNumber) (Frame, error) {
 	var frame Frame
 	var err error
 	if typeByte&0xf8 == 0x8 {
-		frame, err = parseStreamFrame(r, v)
-		if err != nil {
+		var f *StreamFrame
+		if fp != nil {
+			f = &fp.streamFrame
+		} else {
+			f = &StreamFrame{}
+		}
+		if err := parseStreamFrameInto(f, r, data, v); err != nil {
 			return nil, qerr.Error(qerr.InvalidFrameData, err.Error())
 		}
-		return frame, nil
+		return f, nil
 	}
 	switch typeByte {
 	case 0x1:
 		frame, err = parsePingFrame(r, v)
 	case 0x2, 0x3:
-		frame, err = parseAckFrame(r, v)
+		var f *AckFrame
+		if fp != nil {
+			f = &fp.ackFrame
+		} else {
+			f = &AckFrame{}
+		}
+		err = parseAckFrameInto(f, r, v)
+		frame = f
 	case 0x4:
 		frame, err = parseResetStreamFrame(r, v)
 	case 0x5:
@@ -68,6 +118,8 @@ func parseFrame(r *bytes.Reader, typeByte byte, v protocol.VersionNumber) (Frame
 		frame, err = parsePathResponseFrame(r, v)
 	case 0x1c, 0x1d:
 		frame, err = parseConnectionCloseFrame(r, v)
+	case 0x30, 0x31:
+		frame, err = parseDatagramFrame(r, v)
 	default:
 		err = fmt.Errorf("unknown type byte 0x%x", typeByte)
 	}
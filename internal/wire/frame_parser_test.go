@@ -2,6 +2,7 @@ package wire
 
 import (
 	"bytes"
+	"testing"
 
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/qerr"
@@ -95,6 +96,15 @@ var _ = Describe("Frame parsing", func() {
 		Expect(frame).To(Equal(f))
 	})
 
+	It("unpacks DATAGRAM frames", func() {
+		f := &DatagramFrame{Data: []byte("foobar")}
+		err := f.Write(buf, versionIETFFrames)
+		Expect(err).ToNot(HaveOccurred())
+		frame, err := ParseNextFrame(bytes.NewReader(buf.Bytes()), versionIETFFrames)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(Equal(f))
+	})
+
 	It("unpacks STREAM frames", func() {
 		f := &StreamFrame{
 			StreamID: 0x42,
@@ -110,6 +120,29 @@ var _ = Describe("Frame parsing", func() {
 		Expect(frame).To(Equal(f))
 	})
 
+	It("unpacks STREAM frames without copying the data", func() {
+		f := &StreamFrame{
+			StreamID:       0x42,
+			Offset:         0x1337,
+			DataLenPresent: true,
+			Data:           []byte("foobar"),
+		}
+		Expect(f.Write(buf, versionIETFFrames)).To(Succeed())
+		Expect((&PingFrame{}).Write(buf, versionIETFFrames)).To(Succeed())
+		data := buf.Bytes()
+		r := bytes.NewReader(data)
+		frame, err := ParseNextFrameNoCopy(r, data, versionIETFFrames)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(Equal(f))
+		// modify the underlying data, and check that the frame references it
+		copy(data[len(data)-7:], "raboof")
+		Expect(frame.(*StreamFrame).Data).To(Equal([]byte("raboof")))
+		frame, err = ParseNextFrameNoCopy(r, data, versionIETFFrames)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(Equal(&PingFrame{}))
+		Expect(r.Len()).To(BeZero())
+	})
+
 	It("unpacks MAX_DATA frames", func() {
 		f := &MaxDataFrame{
 			ByteOffset: 0xcafe,
@@ -256,4 +289,122 @@ var _ = Describe("Frame parsing", func() {
 		Expect(err).To(HaveOccurred())
 		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidFrameData))
 	})
+
+	Context("reusing frames", func() {
+		var parser *FrameParser
+
+		BeforeEach(func() {
+			parser = NewFrameParser(versionIETFFrames)
+		})
+
+		It("reuses STREAM frames", func() {
+			f1 := &StreamFrame{StreamID: 1, Offset: 0x1337, FinBit: true, DataLenPresent: true, Data: []byte("foo")}
+			f2 := &StreamFrame{StreamID: 2, DataLenPresent: true, Data: []byte("foobar")}
+			Expect(f1.Write(buf, versionIETFFrames)).To(Succeed())
+			Expect(f2.Write(buf, versionIETFFrames)).To(Succeed())
+			data := buf.Bytes()
+			r := bytes.NewReader(data)
+			frame1, err := parser.ParseNext(r, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame1).To(Equal(f1))
+			frame2, err := parser.ParseNext(r, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame2).To(Equal(f2))
+			Expect(frame2).To(BeIdenticalTo(frame1))
+		})
+
+		It("reuses ACK frames, and retains the capacity of the ACK ranges", func() {
+			f1 := &AckFrame{
+				AckRanges: []AckRange{{Smallest: 10, Largest: 12}, {Smallest: 5, Largest: 7}, {Smallest: 1, Largest: 2}},
+				ECT0:      1,
+			}
+			f2 := &AckFrame{AckRanges: []AckRange{{Smallest: 1, Largest: 5}}}
+			Expect(f1.Write(buf, versionIETFFrames)).To(Succeed())
+			Expect(f2.Write(buf, versionIETFFrames)).To(Succeed())
+			data := buf.Bytes()
+			r := bytes.NewReader(data)
+			frame1, err := parser.ParseNext(r, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame1.(*AckFrame).AckRanges).To(Equal(f1.AckRanges))
+			Expect(frame1.(*AckFrame).ECT0).To(BeEquivalentTo(1))
+			frame2, err := parser.ParseNext(r, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame2).To(BeIdenticalTo(frame1))
+			Expect(frame2.(*AckFrame).AckRanges).To(Equal(f2.AckRanges))
+			Expect(frame2.(*AckFrame).AckRanges).To(HaveCap(cap(frame1.(*AckFrame).AckRanges)))
+			Expect(frame2.(*AckFrame).ECT0).To(BeZero())
+		})
+
+		It("doesn't reuse other frames", func() {
+			f := &MaxDataFrame{ByteOffset: 0x1337}
+			Expect(f.Write(buf, versionIETFFrames)).To(Succeed())
+			Expect(f.Write(buf, versionIETFFrames)).To(Succeed())
+			data := buf.Bytes()
+			r := bytes.NewReader(data)
+			frame1, err := parser.ParseNext(r, data)
+			Expect(err).ToNot(HaveOccurred())
+			frame2, err := parser.ParseNext(r, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame1).To(Equal(f))
+			Expect(frame2).To(Equal(f))
+			Expect(frame2).ToNot(BeIdenticalTo(frame1))
+		})
+
+		It("errors on invalid frames", func() {
+			f := &AckFrame{AckRanges: []AckRange{{Smallest: 1, Largest: 5}}}
+			Expect(f.Write(buf, versionIETFFrames)).To(Succeed())
+			data := buf.Bytes()[:buf.Len()-1]
+			_, err := parser.ParseNext(bytes.NewReader(data), data)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidFrameData))
+		})
+	})
 })
+
+// getPacketWithStreamFrames returns the payload of a packet with 10 small STREAM frames
+func getPacketWithStreamFrames(b *testing.B) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; i < 10; i++ {
+		f := &StreamFrame{
+			StreamID:       protocol.StreamID(4 * i),
+			Offset:         protocol.ByteCount(i * 100),
+			DataLenPresent: true,
+			Data:           make([]byte, 100),
+		}
+		if err := f.Write(buf, versionIETFFrames); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkParseStreamFrames(b *testing.B) {
+	data := getPacketWithStreamFrames(b)
+	r := bytes.NewReader(data)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		for r.Len() > 0 {
+			if _, err := ParseNextFrame(r, versionIETFFrames); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkParseStreamFramesWithFrameParser(b *testing.B) {
+	data := getPacketWithStreamFrames(b)
+	r := bytes.NewReader(data)
+	parser := NewFrameParser(versionIETFFrames)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(data)
+		for r.Len() > 0 {
+			if _, err := parser.ParseNext(r, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -328,6 +328,22 @@ var _ = Describe("Header Parsing", func() {
 			Expect(b.Len()).To(BeZero())
 		})
 
+		It("reads the Spin Bit", func() {
+			data := []byte{
+				0x40 ^ 0x20,
+				0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, // connection ID
+			}
+			data = append(data, 11) // packet number
+			hdr, err := ParseHeader(bytes.NewReader(data), 6)
+			Expect(err).ToNot(HaveOccurred())
+			b := bytes.NewReader(data)
+			extHdr, err := hdr.ParseExtended(b, versionIETFFrames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(extHdr.SpinBit).To(BeTrue())
+			Expect(extHdr.KeyPhase).To(BeZero())
+			Expect(b.Len()).To(BeZero())
+		})
+
 		It("reads a header with a 2 byte packet number", func() {
 			data := []byte{
 				0x40 | 0x1,
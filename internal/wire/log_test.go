@@ -65,6 +65,12 @@ var _ = Describe("Frame logging", func() {
 		Expect(buf.Bytes()).To(ContainSubstring("\t<- &wire.StreamFrame{StreamID: 42, FinBit: false, Offset: 0x1337, Data length: 0x100, Offset + Data length: 0x1437}\n"))
 	})
 
+	It("logs DATAGRAM frames", func() {
+		frame := &DatagramFrame{Data: make([]byte, 0x123)}
+		LogFrame(logger, frame, true)
+		Expect(buf.Bytes()).To(ContainSubstring("\t-> &wire.DatagramFrame{Data length: 0x123}\n"))
+	})
+
 	It("logs ACK frames without missing packets", func() {
 		frame := &AckFrame{
 			AckRanges: []AckRange{{Smallest: 0x42, Largest: 0x1337}},
@@ -20,26 +20,37 @@ type StreamFrame struct {
 }
 
 func parseStreamFrame(r *bytes.Reader, version protocol.VersionNumber) (*StreamFrame, error) {
+	frame := &StreamFrame{}
+	if err := parseStreamFrameInto(frame, r, nil, version); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// parseStreamFrameInto parses a STREAM frame into frame.
+// If data is nil, the frame data is copied.
+// Otherwise, r must read from data, and the frame data is a slice of data.
+func parseStreamFrameInto(frame *StreamFrame, r *bytes.Reader, data []byte, version protocol.VersionNumber) error {
 	typeByte, err := r.ReadByte()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	hasOffset := typeByte&0x4 > 0
-	frame := &StreamFrame{
+	*frame = StreamFrame{
 		FinBit:         typeByte&0x1 > 0,
 		DataLenPresent: typeByte&0x2 > 0,
 	}
 
 	streamID, err := utils.ReadVarInt(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	frame.StreamID = protocol.StreamID(streamID)
 	if hasOffset {
 		offset, err := utils.ReadVarInt(r)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		frame.Offset = protocol.ByteCount(offset)
 	}
@@ -49,29 +60,37 @@ func parseStreamFrame(r *bytes.Reader, version protocol.VersionNumber) (*StreamF
 		var err error
 		dataLen, err = utils.ReadVarInt(r)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		// shortcut to prevent the unnecessary allocation of dataLen bytes
 		// if the dataLen is larger than the remaining length of the packet
 		// reading the packet contents would result in EOF when attempting to READ
 		if dataLen > uint64(r.Len()) {
-			return nil, io.EOF
+			return io.EOF
 		}
 	} else {
 		// The rest of the packet is data
 		dataLen = uint64(r.Len())
 	}
 	if dataLen != 0 {
-		frame.Data = make([]byte, dataLen)
-		if _, err := io.ReadFull(r, frame.Data); err != nil {
-			// this should never happen, since we already checked the dataLen earlier
-			return nil, err
+		if data != nil {
+			start := len(data) - r.Len()
+			frame.Data = data[start : start+int(dataLen)]
+			if _, err := r.Seek(int64(dataLen), io.SeekCurrent); err != nil {
+				return err
+			}
+		} else {
+			frame.Data = make([]byte, dataLen)
+			if _, err := io.ReadFull(r, frame.Data); err != nil {
+				// this should never happen, since we already checked the dataLen earlier
+				return err
+			}
 		}
 	}
 	if frame.Offset+frame.DataLen() > protocol.MaxByteCount {
-		return nil, qerr.Error(qerr.InvalidStreamData, "data overflows maximum offset")
+		return qerr.Error(qerr.InvalidStreamData, "data overflows maximum offset")
 	}
-	return frame, nil
+	return nil
 }
 
 // Write writes a STREAM frame
@@ -0,0 +1,83 @@
+package logging
+
+import "sync/atomic"
+
+// Counts are the event counts recorded by a CountingTracer.
+type Counts struct {
+	PacketsSent       uint64
+	BytesSent         uint64
+	PacketsReceived   uint64
+	BytesReceived     uint64
+	PacketsDropped    uint64
+	PacketsLost       uint64
+	ConnectionsClosed uint64
+}
+
+// A CountingTracer counts the events of all connections it traces.
+// It is safe for concurrent use.
+type CountingTracer struct {
+	// all counters are accessed atomically
+	packetsSent       uint64
+	bytesSent         uint64
+	packetsReceived   uint64
+	bytesReceived     uint64
+	packetsDropped    uint64
+	packetsLost       uint64
+	connectionsClosed uint64
+}
+
+var _ Tracer = &CountingTracer{}
+
+// NewCountingTracer creates a new CountingTracer.
+func NewCountingTracer() *CountingTracer {
+	return &CountingTracer{}
+}
+
+// TracerForConnection returns a tracer that adds the events of the connection to the counts.
+func (t *CountingTracer) TracerForConnection(Perspective, ConnectionID) ConnectionTracer {
+	return (*countingConnectionTracer)(t)
+}
+
+// Counts returns the current counts.
+func (t *CountingTracer) Counts() Counts {
+	return Counts{
+		PacketsSent:       atomic.LoadUint64(&t.packetsSent),
+		BytesSent:         atomic.LoadUint64(&t.bytesSent),
+		PacketsReceived:   atomic.LoadUint64(&t.packetsReceived),
+		BytesReceived:     atomic.LoadUint64(&t.bytesReceived),
+		PacketsDropped:    atomic.LoadUint64(&t.packetsDropped),
+		PacketsLost:       atomic.LoadUint64(&t.packetsLost),
+		ConnectionsClosed: atomic.LoadUint64(&t.connectionsClosed),
+	}
+}
+
+type countingConnectionTracer CountingTracer
+
+var _ ConnectionTracer = &countingConnectionTracer{}
+
+func (t *countingConnectionTracer) SentPacket(_ *ExtendedHeader, size ByteCount, _ []Frame) {
+	atomic.AddUint64(&t.packetsSent, 1)
+	atomic.AddUint64(&t.bytesSent, uint64(size))
+}
+
+func (t *countingConnectionTracer) ReceivedPacket(_ *ExtendedHeader, size ByteCount, _ []Frame) {
+	atomic.AddUint64(&t.packetsReceived, 1)
+	atomic.AddUint64(&t.bytesReceived, uint64(size))
+}
+
+func (t *countingConnectionTracer) DroppedPacket(ByteCount, PacketDropReason) {
+	atomic.AddUint64(&t.packetsDropped, 1)
+}
+
+func (t *countingConnectionTracer) LostPacket(EncryptionLevel, PacketNumber, PacketLossReason) {
+	atomic.AddUint64(&t.packetsLost, 1)
+}
+
+func (t *countingConnectionTracer) UpdatedRTT(RTTSnapshot)                         {}
+func (t *countingConnectionTracer) UpdatedCongestionWindow(ByteCount, ByteCount)   {}
+func (t *countingConnectionTracer) UpdatedStreamReceiveWindow(StreamID, ByteCount) {}
+func (t *countingConnectionTracer) UpdatedStreamSendWindow(StreamID, ByteCount)    {}
+
+func (t *countingConnectionTracer) ClosedConnection(error) {
+	atomic.AddUint64(&t.connectionsClosed, 1)
+}
@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Counting Tracer", func() {
+	It("counts the events of all connections", func() {
+		tracer := NewCountingTracer()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				t := tracer.TracerForConnection(protocol.PerspectiveServer, protocol.ConnectionID{byte(i)})
+				hdr := &wire.ExtendedHeader{}
+				t.SentPacket(hdr, 100, nil)
+				t.SentPacket(hdr, 200, nil)
+				t.ReceivedPacket(hdr, 50, nil)
+				t.DroppedPacket(10, PacketDropDOSPrevention)
+				t.LostPacket(protocol.Encryption1RTT, 1, PacketLossTimeThreshold)
+				t.UpdatedRTT(RTTSnapshot{})
+				t.UpdatedCongestionWindow(1000, 100)
+				t.ClosedConnection(errors.New("done"))
+			}(i)
+		}
+		wg.Wait()
+		Expect(tracer.Counts()).To(Equal(Counts{
+			PacketsSent:       20,
+			BytesSent:         3000,
+			PacketsReceived:   10,
+			BytesReceived:     500,
+			PacketsDropped:    10,
+			PacketsLost:       10,
+			ConnectionsClosed: 10,
+		}))
+	})
+})
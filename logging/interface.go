@@ -0,0 +1,71 @@
+// Package logging defines the interface used to trace the events of QUIC connections.
+package logging
+
+import (
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+type (
+	// A ByteCount is used to count bytes.
+	ByteCount = protocol.ByteCount
+	// A ConnectionID is a QUIC Connection ID.
+	ConnectionID = protocol.ConnectionID
+	// The EncryptionLevel is the encryption level of a packet.
+	EncryptionLevel = protocol.EncryptionLevel
+	// The PacketNumber is the packet number of a packet.
+	PacketNumber = protocol.PacketNumber
+	// The Perspective is the role of a QUIC endpoint (client or server).
+	Perspective = protocol.Perspective
+	// A StreamID is a QUIC stream ID.
+	StreamID = protocol.StreamID
+
+	// The ExtendedHeader is the header of a QUIC packet.
+	ExtendedHeader = wire.ExtendedHeader
+	// A Frame is a QUIC frame.
+	Frame = wire.Frame
+)
+
+const (
+	// PerspectiveServer is used for a QUIC server
+	PerspectiveServer Perspective = protocol.PerspectiveServer
+	// PerspectiveClient is used for a QUIC client
+	PerspectiveClient Perspective = protocol.PerspectiveClient
+)
+
+// RTTSnapshot holds the RTT estimates of a connection.
+type RTTSnapshot struct {
+	LatestRTT     time.Duration
+	SmoothedRTT   time.Duration
+	MinRTT        time.Duration
+	MeanDeviation time.Duration
+}
+
+// A Tracer creates the tracers for new connections.
+type Tracer interface {
+	// TracerForConnection is called when a new connection is created.
+	// The connection is identified by the destination connection ID that the client used on its first Initial packet.
+	// If nil is returned, the connection is not traced.
+	TracerForConnection(p Perspective, origDestConnID ConnectionID) ConnectionTracer
+}
+
+// A ConnectionTracer records the events of a single connection.
+// The methods are called synchronously from the session's run loop, so they should return quickly.
+// Headers and frames must not be used after the method returns.
+type ConnectionTracer interface {
+	SentPacket(hdr *ExtendedHeader, size ByteCount, frames []Frame)
+	ReceivedPacket(hdr *ExtendedHeader, size ByteCount, frames []Frame)
+	DroppedPacket(size ByteCount, reason PacketDropReason)
+	LostPacket(encLevel EncryptionLevel, pn PacketNumber, reason PacketLossReason)
+	UpdatedRTT(rtt RTTSnapshot)
+	UpdatedCongestionWindow(congestionWindow, bytesInFlight ByteCount)
+	// UpdatedStreamReceiveWindow is called when we allow the peer to send more data on a stream, by sending a MAX_STREAM_DATA frame.
+	UpdatedStreamReceiveWindow(id StreamID, offset ByteCount)
+	// UpdatedStreamSendWindow is called when the peer allows us to send more data on a stream, by sending a MAX_STREAM_DATA frame.
+	UpdatedStreamSendWindow(id StreamID, offset ByteCount)
+	// ClosedConnection is called when the connection is closed.
+	// No other methods are called after it.
+	ClosedConnection(err error)
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/internal/utils"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+)
+
+type loggingTracer struct {
+	logf func(format string, v ...interface{})
+}
+
+// NewLoggingTracer creates a tracer that logs all events using logf, e.g. log.Printf.
+// Every line is prefixed with the perspective and the connection ID of the connection.
+func NewLoggingTracer(logf func(format string, v ...interface{})) Tracer {
+	return &loggingTracer{logf: logf}
+}
+
+func (t *loggingTracer) TracerForConnection(p Perspective, origDestConnID ConnectionID) ConnectionTracer {
+	return &loggingConnectionTracer{
+		logger: &tracerLogger{logf: t.logf, prefix: fmt.Sprintf("%s %s: ", p, origDestConnID)},
+	}
+}
+
+type loggingConnectionTracer struct {
+	logger utils.Logger
+}
+
+var _ ConnectionTracer = &loggingConnectionTracer{}
+
+func (t *loggingConnectionTracer) SentPacket(hdr *ExtendedHeader, size ByteCount, frames []Frame) {
+	t.logger.Debugf("-> Sent packet %#x (%d bytes)", hdr.PacketNumber, size)
+	t.logPacket(hdr, frames, true)
+}
+
+func (t *loggingConnectionTracer) ReceivedPacket(hdr *ExtendedHeader, size ByteCount, frames []Frame) {
+	t.logger.Debugf("<- Received packet %#x (%d bytes)", hdr.PacketNumber, size)
+	t.logPacket(hdr, frames, false)
+}
+
+func (t *loggingConnectionTracer) logPacket(hdr *ExtendedHeader, frames []Frame, sent bool) {
+	hdr.Log(t.logger)
+	for _, f := range frames {
+		wire.LogFrame(t.logger, f, sent)
+	}
+}
+
+func (t *loggingConnectionTracer) DroppedPacket(size ByteCount, reason PacketDropReason) {
+	t.logger.Debugf("Dropped packet (%d bytes): %s", size, reason)
+}
+
+func (t *loggingConnectionTracer) LostPacket(encLevel EncryptionLevel, pn PacketNumber, reason PacketLossReason) {
+	t.logger.Debugf("Lost packet %#x (%s): %s", pn, encLevel, reason)
+}
+
+func (t *loggingConnectionTracer) UpdatedRTT(rtt RTTSnapshot) {
+	t.logger.Debugf("Updated RTT: latest %s, smoothed %s, min %s, mean deviation %s", rtt.LatestRTT, rtt.SmoothedRTT, rtt.MinRTT, rtt.MeanDeviation)
+}
+
+func (t *loggingConnectionTracer) UpdatedCongestionWindow(congestionWindow, bytesInFlight ByteCount) {
+	t.logger.Debugf("Updated congestion window: %d bytes (%d bytes in flight)", congestionWindow, bytesInFlight)
+}
+
+func (t *loggingConnectionTracer) UpdatedStreamReceiveWindow(id StreamID, offset ByteCount) {
+	t.logger.Debugf("Updated receive window of stream %d: %d", id, offset)
+}
+
+func (t *loggingConnectionTracer) UpdatedStreamSendWindow(id StreamID, offset ByteCount) {
+	t.logger.Debugf("Updated send window of stream %d: %d", id, offset)
+}
+
+func (t *loggingConnectionTracer) ClosedConnection(err error) {
+	t.logger.Debugf("Closed connection: %v", err)
+}
+
+// tracerLogger implements the utils.Logger, such that the header and frame logging functions can be reused.
+// It logs all messages, independent of their log level.
+type tracerLogger struct {
+	logf   func(format string, v ...interface{})
+	prefix string
+}
+
+var _ utils.Logger = &tracerLogger{}
+
+func (l *tracerLogger) SetLogLevel(utils.LogLevel) {}
+func (l *tracerLogger) SetLogTimeFormat(string)    {}
+func (l *tracerLogger) Debug() bool                { return true }
+
+func (l *tracerLogger) WithPrefix(prefix string) utils.Logger {
+	return &tracerLogger{logf: l.logf, prefix: l.prefix + prefix}
+}
+
+func (l *tracerLogger) Errorf(format string, args ...interface{}) { l.logf(l.prefix+format, args...) }
+func (l *tracerLogger) Infof(format string, args ...interface{})  { l.logf(l.prefix+format, args...) }
+func (l *tracerLogger) Debugf(format string, args ...interface{}) { l.logf(l.prefix+format, args...) }
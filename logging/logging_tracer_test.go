@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging Tracer", func() {
+	var (
+		tracer ConnectionTracer
+		lines  []string
+	)
+
+	BeforeEach(func() {
+		lines = nil
+		logf := func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		}
+		tracer = NewLoggingTracer(logf).TracerForConnection(protocol.PerspectiveClient, protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef})
+	})
+
+	It("prefixes every line with the perspective and the connection ID", func() {
+		tracer.DroppedPacket(1234, PacketDropPayloadDecryptError)
+		Expect(lines).To(Equal([]string{"Client 0xdeadbeef: Dropped packet (1234 bytes): payload decrypt error"}))
+	})
+
+	It("logs sent packets, with the header and the frames", func() {
+		hdr := &wire.ExtendedHeader{
+			Header:          wire.Header{DestConnectionID: protocol.ConnectionID{1, 2, 3, 4}},
+			PacketNumber:    0x42,
+			PacketNumberLen: protocol.PacketNumberLen2,
+		}
+		tracer.SentPacket(hdr, 1000, []Frame{&wire.PingFrame{}, &wire.MaxDataFrame{ByteOffset: 1337}})
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[0]).To(Equal("Client 0xdeadbeef: -> Sent packet 0x42 (1000 bytes)"))
+		Expect(lines[1]).To(ContainSubstring("Short Header{DestConnectionID: 0x01020304, PacketNumber: 0x42"))
+		Expect(lines[2]).To(ContainSubstring("-> &wire.PingFrame{}"))
+		Expect(lines[3]).To(ContainSubstring("-> &wire.MaxDataFrame{ByteOffset:0x539}"))
+	})
+
+	It("logs received packets", func() {
+		hdr := &wire.ExtendedHeader{PacketNumber: 0x1337, PacketNumberLen: protocol.PacketNumberLen2}
+		tracer.ReceivedPacket(hdr, 100, []Frame{&wire.PingFrame{}})
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(Equal("Client 0xdeadbeef: <- Received packet 0x1337 (100 bytes)"))
+		Expect(lines[2]).To(ContainSubstring("<- &wire.PingFrame{}"))
+	})
+
+	It("logs lost packets", func() {
+		tracer.LostPacket(protocol.Encryption1RTT, 0x42, PacketLossTimeThreshold)
+		Expect(lines).To(Equal([]string{"Client 0xdeadbeef: Lost packet 0x42 (1-RTT): time threshold"}))
+	})
+
+	It("logs RTT updates", func() {
+		tracer.UpdatedRTT(RTTSnapshot{
+			LatestRTT:     10 * time.Millisecond,
+			SmoothedRTT:   20 * time.Millisecond,
+			MinRTT:        5 * time.Millisecond,
+			MeanDeviation: time.Millisecond,
+		})
+		Expect(lines).To(Equal([]string{"Client 0xdeadbeef: Updated RTT: latest 10ms, smoothed 20ms, min 5ms, mean deviation 1ms"}))
+	})
+
+	It("logs congestion window updates", func() {
+		tracer.UpdatedCongestionWindow(10000, 5000)
+		Expect(lines).To(Equal([]string{"Client 0xdeadbeef: Updated congestion window: 10000 bytes (5000 bytes in flight)"}))
+	})
+
+	It("logs when the connection is closed", func() {
+		tracer.ClosedConnection(errors.New("foobar"))
+		Expect(lines).To(Equal([]string{"Client 0xdeadbeef: Closed connection: foobar"}))
+	})
+})
@@ -0,0 +1,73 @@
+package logging
+
+type connTracerMultiplexer struct {
+	tracers []ConnectionTracer
+}
+
+var _ ConnectionTracer = &connTracerMultiplexer{}
+
+// NewMultiplexedConnectionTracer creates a connection tracer that passes all events to the tracers.
+// It returns nil if no tracers are passed, and the tracer itself if only a single tracer is passed.
+func NewMultiplexedConnectionTracer(tracers ...ConnectionTracer) ConnectionTracer {
+	switch len(tracers) {
+	case 0:
+		return nil
+	case 1:
+		return tracers[0]
+	}
+	return &connTracerMultiplexer{tracers: tracers}
+}
+
+func (m *connTracerMultiplexer) SentPacket(hdr *ExtendedHeader, size ByteCount, frames []Frame) {
+	for _, t := range m.tracers {
+		t.SentPacket(hdr, size, frames)
+	}
+}
+
+func (m *connTracerMultiplexer) ReceivedPacket(hdr *ExtendedHeader, size ByteCount, frames []Frame) {
+	for _, t := range m.tracers {
+		t.ReceivedPacket(hdr, size, frames)
+	}
+}
+
+func (m *connTracerMultiplexer) DroppedPacket(size ByteCount, reason PacketDropReason) {
+	for _, t := range m.tracers {
+		t.DroppedPacket(size, reason)
+	}
+}
+
+func (m *connTracerMultiplexer) LostPacket(encLevel EncryptionLevel, pn PacketNumber, reason PacketLossReason) {
+	for _, t := range m.tracers {
+		t.LostPacket(encLevel, pn, reason)
+	}
+}
+
+func (m *connTracerMultiplexer) UpdatedRTT(rtt RTTSnapshot) {
+	for _, t := range m.tracers {
+		t.UpdatedRTT(rtt)
+	}
+}
+
+func (m *connTracerMultiplexer) UpdatedCongestionWindow(congestionWindow, bytesInFlight ByteCount) {
+	for _, t := range m.tracers {
+		t.UpdatedCongestionWindow(congestionWindow, bytesInFlight)
+	}
+}
+
+func (m *connTracerMultiplexer) UpdatedStreamReceiveWindow(id StreamID, offset ByteCount) {
+	for _, t := range m.tracers {
+		t.UpdatedStreamReceiveWindow(id, offset)
+	}
+}
+
+func (m *connTracerMultiplexer) UpdatedStreamSendWindow(id StreamID, offset ByteCount) {
+	for _, t := range m.tracers {
+		t.UpdatedStreamSendWindow(id, offset)
+	}
+}
+
+func (m *connTracerMultiplexer) ClosedConnection(err error) {
+	for _, t := range m.tracers {
+		t.ClosedConnection(err)
+	}
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"errors"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracer Multiplexer", func() {
+	It("returns nil if no tracers are passed", func() {
+		Expect(NewMultiplexedConnectionTracer()).To(BeNil())
+	})
+
+	It("returns the tracer itself if a single tracer is passed", func() {
+		t := NewCountingTracer().TracerForConnection(PerspectiveClient, nil)
+		Expect(NewMultiplexedConnectionTracer(t)).To(Equal(t))
+	})
+
+	It("passes all events to all tracers", func() {
+		t1 := NewCountingTracer()
+		t2 := NewCountingTracer()
+		t := NewMultiplexedConnectionTracer(
+			t1.TracerForConnection(PerspectiveServer, nil),
+			t2.TracerForConnection(PerspectiveServer, nil),
+		)
+		hdr := &wire.ExtendedHeader{}
+		t.SentPacket(hdr, 100, nil)
+		t.ReceivedPacket(hdr, 200, nil)
+		t.DroppedPacket(10, PacketDropDOSPrevention)
+		t.LostPacket(protocol.Encryption1RTT, 1, PacketLossTimeThreshold)
+		t.UpdatedRTT(RTTSnapshot{})
+		t.UpdatedCongestionWindow(1000, 100)
+		t.ClosedConnection(errors.New("done"))
+		expected := Counts{
+			PacketsSent:       1,
+			BytesSent:         100,
+			PacketsReceived:   1,
+			BytesReceived:     200,
+			PacketsDropped:    1,
+			PacketsLost:       1,
+			ConnectionsClosed: 1,
+		}
+		Expect(t1.Counts()).To(Equal(expected))
+		Expect(t2.Counts()).To(Equal(expected))
+	})
+})
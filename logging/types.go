@@ -0,0 +1,56 @@
+package logging
+
+// PacketDropReason is the reason why a packet was dropped
+type PacketDropReason uint8
+
+const (
+	// PacketDropUnexpectedSourceConnectionID is used when a long header packet uses a different source connection ID than the previous packets
+	PacketDropUnexpectedSourceConnectionID PacketDropReason = iota
+	// PacketDropUnexpectedPacket is used when a packet of a type that is not processed is received, e.g. a 0-RTT packet
+	PacketDropUnexpectedPacket
+	// PacketDropKeyUnavailable is used when a packet is dropped because the keys to decrypt it are not available
+	PacketDropKeyUnavailable
+	// PacketDropPayloadDecryptError is used when a packet couldn't be decrypted
+	PacketDropPayloadDecryptError
+	// PacketDropDOSPrevention is used when a packet is dropped to limit the resources spent on a connection,
+	// e.g. when too many undecryptable packets are queued
+	PacketDropDOSPrevention
+	// PacketDropBufferFull is used when a packet carries stream data that can't be buffered
+	PacketDropBufferFull
+)
+
+func (r PacketDropReason) String() string {
+	switch r {
+	case PacketDropUnexpectedSourceConnectionID:
+		return "unexpected source connection ID"
+	case PacketDropUnexpectedPacket:
+		return "unexpected packet"
+	case PacketDropKeyUnavailable:
+		return "key unavailable"
+	case PacketDropPayloadDecryptError:
+		return "payload decrypt error"
+	case PacketDropDOSPrevention:
+		return "DoS prevention"
+	case PacketDropBufferFull:
+		return "buffer full"
+	default:
+		return "unknown packet drop reason"
+	}
+}
+
+// PacketLossReason is the reason why a packet was declared lost
+type PacketLossReason uint8
+
+const (
+	// PacketLossTimeThreshold is used when a packet was sent too long before a packet that was acknowledged
+	PacketLossTimeThreshold PacketLossReason = iota
+)
+
+func (r PacketLossReason) String() string {
+	switch r {
+	case PacketLossTimeThreshold:
+		return "time threshold"
+	default:
+		return "unknown packet loss reason"
+	}
+}
@@ -96,6 +96,19 @@ func (mr *MockPackerMockRecorder) PackPacket() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PackPacket", reflect.TypeOf((*MockPacker)(nil).PackPacket))
 }
 
+// PackPathChallenge mocks base method
+func (m *MockPacker) PackPathChallenge(arg0 *wire.PathChallengeFrame) (*packedPacket, error) {
+	ret := m.ctrl.Call(m, "PackPathChallenge", arg0)
+	ret0, _ := ret[0].(*packedPacket)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PackPathChallenge indicates an expected call of PackPathChallenge
+func (mr *MockPackerMockRecorder) PackPathChallenge(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PackPathChallenge", reflect.TypeOf((*MockPacker)(nil).PackPathChallenge), arg0)
+}
+
 // PackRetransmission mocks base method
 func (m *MockPacker) PackRetransmission(arg0 *ackhandler.Packet) ([]*packedPacket, error) {
 	ret := m.ctrl.Call(m, "PackRetransmission", arg0)
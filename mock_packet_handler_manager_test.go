@@ -5,6 +5,7 @@
 package quic
 
 import (
+	net "net"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -44,6 +45,28 @@ func (mr *MockPacketHandlerManagerMockRecorder) Add(arg0, arg1 interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockPacketHandlerManager)(nil).Add), arg0, arg1)
 }
 
+// AddIfNotTaken mocks base method
+func (m *MockPacketHandlerManager) AddIfNotTaken(arg0 protocol.ConnectionID, arg1 packetHandler) bool {
+	ret := m.ctrl.Call(m, "AddIfNotTaken", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// AddIfNotTaken indicates an expected call of AddIfNotTaken
+func (mr *MockPacketHandlerManagerMockRecorder) AddIfNotTaken(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddIfNotTaken", reflect.TypeOf((*MockPacketHandlerManager)(nil).AddIfNotTaken), arg0, arg1)
+}
+
+// AddWithResetToken mocks base method
+func (m *MockPacketHandlerManager) AddWithResetToken(arg0 protocol.ConnectionID, arg1 packetHandler, arg2 [16]byte) {
+	m.ctrl.Call(m, "AddWithResetToken", arg0, arg1, arg2)
+}
+
+// AddWithResetToken indicates an expected call of AddWithResetToken
+func (mr *MockPacketHandlerManagerMockRecorder) AddWithResetToken(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddWithResetToken", reflect.TypeOf((*MockPacketHandlerManager)(nil).AddWithResetToken), arg0, arg1, arg2)
+}
+
 // CloseServer mocks base method
 func (m *MockPacketHandlerManager) CloseServer() {
 	m.ctrl.Call(m, "CloseServer")
@@ -54,6 +77,18 @@ func (mr *MockPacketHandlerManagerMockRecorder) CloseServer() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseServer", reflect.TypeOf((*MockPacketHandlerManager)(nil).CloseServer))
 }
 
+// GetStatelessResetToken mocks base method
+func (m *MockPacketHandlerManager) GetStatelessResetToken(arg0 protocol.ConnectionID) [16]byte {
+	ret := m.ctrl.Call(m, "GetStatelessResetToken", arg0)
+	ret0, _ := ret[0].([16]byte)
+	return ret0
+}
+
+// GetStatelessResetToken indicates an expected call of GetStatelessResetToken
+func (mr *MockPacketHandlerManagerMockRecorder) GetStatelessResetToken(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStatelessResetToken", reflect.TypeOf((*MockPacketHandlerManager)(nil).GetStatelessResetToken), arg0)
+}
+
 // Remove mocks base method
 func (m *MockPacketHandlerManager) Remove(arg0 protocol.ConnectionID) {
 	m.ctrl.Call(m, "Remove", arg0)
@@ -74,6 +109,16 @@ func (mr *MockPacketHandlerManagerMockRecorder) Retire(arg0 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Retire", reflect.TypeOf((*MockPacketHandlerManager)(nil).Retire), arg0)
 }
 
+// SetNonQUICPacketHandler mocks base method
+func (m *MockPacketHandlerManager) SetNonQUICPacketHandler(arg0 func([]byte, net.Addr)) {
+	m.ctrl.Call(m, "SetNonQUICPacketHandler", arg0)
+}
+
+// SetNonQUICPacketHandler indicates an expected call of SetNonQUICPacketHandler
+func (mr *MockPacketHandlerManagerMockRecorder) SetNonQUICPacketHandler(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNonQUICPacketHandler", reflect.TypeOf((*MockPacketHandlerManager)(nil).SetNonQUICPacketHandler), arg0)
+}
+
 // SetServer mocks base method
 func (m *MockPacketHandlerManager) SetServer(arg0 unknownPacketHandler) {
 	m.ctrl.Call(m, "SetServer", arg0)
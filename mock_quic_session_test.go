@@ -8,6 +8,7 @@ import (
 	context "context"
 	net "net"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	handshake "github.com/lucas-clemente/quic-go/internal/handshake"
@@ -38,29 +39,29 @@ func (m *MockQuicSession) EXPECT() *MockQuicSessionMockRecorder {
 }
 
 // AcceptStream mocks base method
-func (m *MockQuicSession) AcceptStream() (Stream, error) {
-	ret := m.ctrl.Call(m, "AcceptStream")
+func (m *MockQuicSession) AcceptStream(arg0 context.Context) (Stream, error) {
+	ret := m.ctrl.Call(m, "AcceptStream", arg0)
 	ret0, _ := ret[0].(Stream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AcceptStream indicates an expected call of AcceptStream
-func (mr *MockQuicSessionMockRecorder) AcceptStream() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptStream", reflect.TypeOf((*MockQuicSession)(nil).AcceptStream))
+func (mr *MockQuicSessionMockRecorder) AcceptStream(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptStream", reflect.TypeOf((*MockQuicSession)(nil).AcceptStream), arg0)
 }
 
 // AcceptUniStream mocks base method
-func (m *MockQuicSession) AcceptUniStream() (ReceiveStream, error) {
-	ret := m.ctrl.Call(m, "AcceptUniStream")
+func (m *MockQuicSession) AcceptUniStream(arg0 context.Context) (ReceiveStream, error) {
+	ret := m.ctrl.Call(m, "AcceptUniStream", arg0)
 	ret0, _ := ret[0].(ReceiveStream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AcceptUniStream indicates an expected call of AcceptUniStream
-func (mr *MockQuicSessionMockRecorder) AcceptUniStream() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptUniStream", reflect.TypeOf((*MockQuicSession)(nil).AcceptUniStream))
+func (mr *MockQuicSessionMockRecorder) AcceptUniStream(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptUniStream", reflect.TypeOf((*MockQuicSession)(nil).AcceptUniStream), arg0)
 }
 
 // Close mocks base method
@@ -75,8 +76,20 @@ func (mr *MockQuicSessionMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockQuicSession)(nil).Close))
 }
 
+// CloseGracefully mocks base method
+func (m *MockQuicSession) CloseGracefully(arg0 time.Duration) error {
+	ret := m.ctrl.Call(m, "CloseGracefully", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CloseGracefully indicates an expected call of CloseGracefully
+func (mr *MockQuicSessionMockRecorder) CloseGracefully(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloseGracefully", reflect.TypeOf((*MockQuicSession)(nil).CloseGracefully), arg0)
+}
+
 // CloseWithError mocks base method
-func (m *MockQuicSession) CloseWithError(arg0 protocol.ApplicationErrorCode, arg1 error) error {
+func (m *MockQuicSession) CloseWithError(arg0 protocol.ApplicationErrorCode, arg1 string) error {
 	ret := m.ctrl.Call(m, "CloseWithError", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
@@ -111,6 +124,19 @@ func (mr *MockQuicSessionMockRecorder) Context() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockQuicSession)(nil).Context))
 }
 
+// ExportKeyingMaterial mocks base method
+func (m *MockQuicSession) ExportKeyingMaterial(arg0 string, arg1 []byte, arg2 int) ([]byte, error) {
+	ret := m.ctrl.Call(m, "ExportKeyingMaterial", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportKeyingMaterial indicates an expected call of ExportKeyingMaterial
+func (mr *MockQuicSessionMockRecorder) ExportKeyingMaterial(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportKeyingMaterial", reflect.TypeOf((*MockQuicSession)(nil).ExportKeyingMaterial), arg0, arg1, arg2)
+}
+
 // GetVersion mocks base method
 func (m *MockQuicSession) GetVersion() protocol.VersionNumber {
 	ret := m.ctrl.Call(m, "GetVersion")
@@ -123,6 +149,18 @@ func (mr *MockQuicSessionMockRecorder) GetVersion() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVersion", reflect.TypeOf((*MockQuicSession)(nil).GetVersion))
 }
 
+// HandshakeComplete mocks base method
+func (m *MockQuicSession) HandshakeComplete() <-chan struct{} {
+	ret := m.ctrl.Call(m, "HandshakeComplete")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// HandshakeComplete indicates an expected call of HandshakeComplete
+func (mr *MockQuicSessionMockRecorder) HandshakeComplete() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandshakeComplete", reflect.TypeOf((*MockQuicSession)(nil).HandshakeComplete))
+}
+
 // LocalAddr mocks base method
 func (m *MockQuicSession) LocalAddr() net.Addr {
 	ret := m.ctrl.Call(m, "LocalAddr")
@@ -135,6 +173,18 @@ func (mr *MockQuicSessionMockRecorder) LocalAddr() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocalAddr", reflect.TypeOf((*MockQuicSession)(nil).LocalAddr))
 }
 
+// Migrate mocks base method
+func (m *MockQuicSession) Migrate(arg0 net.PacketConn) error {
+	ret := m.ctrl.Call(m, "Migrate", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Migrate indicates an expected call of Migrate
+func (mr *MockQuicSessionMockRecorder) Migrate(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Migrate", reflect.TypeOf((*MockQuicSession)(nil).Migrate), arg0)
+}
+
 // OpenStream mocks base method
 func (m *MockQuicSession) OpenStream() (Stream, error) {
 	ret := m.ctrl.Call(m, "OpenStream")
@@ -149,16 +199,16 @@ func (mr *MockQuicSessionMockRecorder) OpenStream() *gomock.Call {
 }
 
 // OpenStreamSync mocks base method
-func (m *MockQuicSession) OpenStreamSync() (Stream, error) {
-	ret := m.ctrl.Call(m, "OpenStreamSync")
+func (m *MockQuicSession) OpenStreamSync(arg0 context.Context) (Stream, error) {
+	ret := m.ctrl.Call(m, "OpenStreamSync", arg0)
 	ret0, _ := ret[0].(Stream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // OpenStreamSync indicates an expected call of OpenStreamSync
-func (mr *MockQuicSessionMockRecorder) OpenStreamSync() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenStreamSync", reflect.TypeOf((*MockQuicSession)(nil).OpenStreamSync))
+func (mr *MockQuicSessionMockRecorder) OpenStreamSync(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenStreamSync", reflect.TypeOf((*MockQuicSession)(nil).OpenStreamSync), arg0)
 }
 
 // OpenUniStream mocks base method
@@ -175,16 +225,55 @@ func (mr *MockQuicSessionMockRecorder) OpenUniStream() *gomock.Call {
 }
 
 // OpenUniStreamSync mocks base method
-func (m *MockQuicSession) OpenUniStreamSync() (SendStream, error) {
-	ret := m.ctrl.Call(m, "OpenUniStreamSync")
+func (m *MockQuicSession) OpenUniStreamSync(arg0 context.Context) (SendStream, error) {
+	ret := m.ctrl.Call(m, "OpenUniStreamSync", arg0)
 	ret0, _ := ret[0].(SendStream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // OpenUniStreamSync indicates an expected call of OpenUniStreamSync
-func (mr *MockQuicSessionMockRecorder) OpenUniStreamSync() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenUniStreamSync", reflect.TypeOf((*MockQuicSession)(nil).OpenUniStreamSync))
+func (mr *MockQuicSessionMockRecorder) OpenUniStreamSync(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenUniStreamSync", reflect.TypeOf((*MockQuicSession)(nil).OpenUniStreamSync), arg0)
+}
+
+// PeerParams mocks base method
+func (m *MockQuicSession) PeerParams() (NegotiatedParams, bool) {
+	ret := m.ctrl.Call(m, "PeerParams")
+	ret0, _ := ret[0].(NegotiatedParams)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// PeerParams indicates an expected call of PeerParams
+func (mr *MockQuicSessionMockRecorder) PeerParams() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PeerParams", reflect.TypeOf((*MockQuicSession)(nil).PeerParams))
+}
+
+// Ping mocks base method
+func (m *MockQuicSession) Ping(arg0 context.Context) (time.Duration, error) {
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockQuicSessionMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockQuicSession)(nil).Ping), arg0)
+}
+
+// ReceiveMessage mocks base method
+func (m *MockQuicSession) ReceiveMessage() ([]byte, error) {
+	ret := m.ctrl.Call(m, "ReceiveMessage")
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReceiveMessage indicates an expected call of ReceiveMessage
+func (mr *MockQuicSessionMockRecorder) ReceiveMessage() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceiveMessage", reflect.TypeOf((*MockQuicSession)(nil).ReceiveMessage))
 }
 
 // RemoteAddr mocks base method
@@ -199,6 +288,30 @@ func (mr *MockQuicSessionMockRecorder) RemoteAddr() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoteAddr", reflect.TypeOf((*MockQuicSession)(nil).RemoteAddr))
 }
 
+// SendMessage mocks base method
+func (m *MockQuicSession) SendMessage(arg0 []byte) error {
+	ret := m.ctrl.Call(m, "SendMessage", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendMessage indicates an expected call of SendMessage
+func (mr *MockQuicSessionMockRecorder) SendMessage(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockQuicSession)(nil).SendMessage), arg0)
+}
+
+// Stats mocks base method
+func (m *MockQuicSession) Stats() SessionStats {
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(SessionStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats
+func (mr *MockQuicSessionMockRecorder) Stats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockQuicSession)(nil).Stats))
+}
+
 // closeForRecreating mocks base method
 func (m *MockQuicSession) closeForRecreating() protocol.PacketNumber {
 	ret := m.ctrl.Call(m, "closeForRecreating")
@@ -231,6 +344,18 @@ func (mr *MockQuicSessionMockRecorder) destroy(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "destroy", reflect.TypeOf((*MockQuicSession)(nil).destroy), arg0)
 }
 
+// earlySessionReady mocks base method
+func (m *MockQuicSession) earlySessionReady() <-chan struct{} {
+	ret := m.ctrl.Call(m, "earlySessionReady")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// earlySessionReady indicates an expected call of earlySessionReady
+func (mr *MockQuicSessionMockRecorder) earlySessionReady() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "earlySessionReady", reflect.TypeOf((*MockQuicSession)(nil).earlySessionReady))
+}
+
 // handlePacket mocks base method
 func (m *MockQuicSession) handlePacket(arg0 *receivedPacket) {
 	m.ctrl.Call(m, "handlePacket", arg0)
@@ -46,6 +46,32 @@ func (mr *MockReceiveStreamIMockRecorder) CancelRead(arg0 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelRead", reflect.TypeOf((*MockReceiveStreamI)(nil).CancelRead), arg0)
 }
 
+// Discard mocks base method
+func (m *MockReceiveStreamI) Discard(arg0 int) (int, error) {
+	ret := m.ctrl.Call(m, "Discard", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Discard indicates an expected call of Discard
+func (mr *MockReceiveStreamIMockRecorder) Discard(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Discard", reflect.TypeOf((*MockReceiveStreamI)(nil).Discard), arg0)
+}
+
+// Peek mocks base method
+func (m *MockReceiveStreamI) Peek(arg0 int) ([]byte, error) {
+	ret := m.ctrl.Call(m, "Peek", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Peek indicates an expected call of Peek
+func (mr *MockReceiveStreamIMockRecorder) Peek(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Peek", reflect.TypeOf((*MockReceiveStreamI)(nil).Peek), arg0)
+}
+
 // Read mocks base method
 func (m *MockReceiveStreamI) Read(arg0 []byte) (int, error) {
 	ret := m.ctrl.Call(m, "Read", arg0)
@@ -59,6 +85,18 @@ func (mr *MockReceiveStreamIMockRecorder) Read(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockReceiveStreamI)(nil).Read), arg0)
 }
 
+// ReceiveStreamState mocks base method
+func (m *MockReceiveStreamI) ReceiveStreamState() ReceiveStreamState {
+	ret := m.ctrl.Call(m, "ReceiveStreamState")
+	ret0, _ := ret[0].(ReceiveStreamState)
+	return ret0
+}
+
+// ReceiveStreamState indicates an expected call of ReceiveStreamState
+func (mr *MockReceiveStreamIMockRecorder) ReceiveStreamState() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceiveStreamState", reflect.TypeOf((*MockReceiveStreamI)(nil).ReceiveStreamState))
+}
+
 // SetReadDeadline mocks base method
 func (m *MockReceiveStreamI) SetReadDeadline(arg0 time.Time) error {
 	ret := m.ctrl.Call(m, "SetReadDeadline", arg0)
@@ -83,6 +121,19 @@ func (mr *MockReceiveStreamIMockRecorder) StreamID() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamID", reflect.TypeOf((*MockReceiveStreamI)(nil).StreamID))
 }
 
+// checkOutOfOrderData mocks base method
+func (m *MockReceiveStreamI) checkOutOfOrderData(arg0 *wire.StreamFrame, arg1 protocol.ByteCount) (protocol.ByteCount, error) {
+	ret := m.ctrl.Call(m, "checkOutOfOrderData", arg0, arg1)
+	ret0, _ := ret[0].(protocol.ByteCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// checkOutOfOrderData indicates an expected call of checkOutOfOrderData
+func (mr *MockReceiveStreamIMockRecorder) checkOutOfOrderData(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "checkOutOfOrderData", reflect.TypeOf((*MockReceiveStreamI)(nil).checkOutOfOrderData), arg0, arg1)
+}
+
 // closeForShutdown mocks base method
 func (m *MockReceiveStreamI) closeForShutdown(arg0 error) {
 	m.ctrl.Call(m, "closeForShutdown", arg0)
@@ -118,13 +169,13 @@ func (mr *MockReceiveStreamIMockRecorder) handleResetStreamFrame(arg0 interface{
 }
 
 // handleStreamFrame mocks base method
-func (m *MockReceiveStreamI) handleStreamFrame(arg0 *wire.StreamFrame) error {
-	ret := m.ctrl.Call(m, "handleStreamFrame", arg0)
+func (m *MockReceiveStreamI) handleStreamFrame(arg0 *wire.StreamFrame, arg1 *packetBuffer) error {
+	ret := m.ctrl.Call(m, "handleStreamFrame", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // handleStreamFrame indicates an expected call of handleStreamFrame
-func (mr *MockReceiveStreamIMockRecorder) handleStreamFrame(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "handleStreamFrame", reflect.TypeOf((*MockReceiveStreamI)(nil).handleStreamFrame), arg0)
+func (mr *MockReceiveStreamIMockRecorder) handleStreamFrame(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "handleStreamFrame", reflect.TypeOf((*MockReceiveStreamI)(nil).handleStreamFrame), arg0, arg1)
 }
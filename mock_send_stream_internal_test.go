@@ -71,6 +71,40 @@ func (mr *MockSendStreamIMockRecorder) Context() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockSendStreamI)(nil).Context))
 }
 
+// SendStreamState mocks base method
+func (m *MockSendStreamI) SendStreamState() SendStreamState {
+	ret := m.ctrl.Call(m, "SendStreamState")
+	ret0, _ := ret[0].(SendStreamState)
+	return ret0
+}
+
+// SendStreamState indicates an expected call of SendStreamState
+func (mr *MockSendStreamIMockRecorder) SendStreamState() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendStreamState", reflect.TypeOf((*MockSendStreamI)(nil).SendStreamState))
+}
+
+// SetPriority mocks base method
+func (m *MockSendStreamI) SetPriority(arg0 int) {
+	m.ctrl.Call(m, "SetPriority", arg0)
+}
+
+// SetPriority indicates an expected call of SetPriority
+func (mr *MockSendStreamIMockRecorder) SetPriority(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPriority", reflect.TypeOf((*MockSendStreamI)(nil).SetPriority), arg0)
+}
+
+// SetWriteBufferOwnership mocks base method
+func (m *MockSendStreamI) SetWriteBufferOwnership(arg0 BufferOwnership, arg1 func([]byte)) error {
+	ret := m.ctrl.Call(m, "SetWriteBufferOwnership", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetWriteBufferOwnership indicates an expected call of SetWriteBufferOwnership
+func (mr *MockSendStreamIMockRecorder) SetWriteBufferOwnership(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWriteBufferOwnership", reflect.TypeOf((*MockSendStreamI)(nil).SetWriteBufferOwnership), arg0, arg1)
+}
+
 // SetWriteDeadline mocks base method
 func (m *MockSendStreamI) SetWriteDeadline(arg0 time.Time) error {
 	ret := m.ctrl.Call(m, "SetWriteDeadline", arg0)
@@ -95,6 +129,19 @@ func (mr *MockSendStreamIMockRecorder) StreamID() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamID", reflect.TypeOf((*MockSendStreamI)(nil).StreamID))
 }
 
+// TryWrite mocks base method
+func (m *MockSendStreamI) TryWrite(arg0 []byte) (int, error) {
+	ret := m.ctrl.Call(m, "TryWrite", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TryWrite indicates an expected call of TryWrite
+func (mr *MockSendStreamIMockRecorder) TryWrite(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TryWrite", reflect.TypeOf((*MockSendStreamI)(nil).TryWrite), arg0)
+}
+
 // Write mocks base method
 func (m *MockSendStreamI) Write(arg0 []byte) (int, error) {
 	ret := m.ctrl.Call(m, "Write", arg0)
@@ -108,6 +155,19 @@ func (mr *MockSendStreamIMockRecorder) Write(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockSendStreamI)(nil).Write), arg0)
 }
 
+// WriteVectors mocks base method
+func (m *MockSendStreamI) WriteVectors(arg0 [][]byte) (int, error) {
+	ret := m.ctrl.Call(m, "WriteVectors", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WriteVectors indicates an expected call of WriteVectors
+func (mr *MockSendStreamIMockRecorder) WriteVectors(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteVectors", reflect.TypeOf((*MockSendStreamI)(nil).WriteVectors), arg0)
+}
+
 // closeForShutdown mocks base method
 func (m *MockSendStreamI) closeForShutdown(arg0 error) {
 	m.ctrl.Call(m, "closeForShutdown", arg0)
@@ -118,6 +178,18 @@ func (mr *MockSendStreamIMockRecorder) closeForShutdown(arg0 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "closeForShutdown", reflect.TypeOf((*MockSendStreamI)(nil).closeForShutdown), arg0)
 }
 
+// getPriority mocks base method
+func (m *MockSendStreamI) getPriority() int {
+	ret := m.ctrl.Call(m, "getPriority")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// getPriority indicates an expected call of getPriority
+func (mr *MockSendStreamIMockRecorder) getPriority() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getPriority", reflect.TypeOf((*MockSendStreamI)(nil).getPriority))
+}
+
 // handleMaxStreamDataFrame mocks base method
 func (m *MockSendStreamI) handleMaxStreamDataFrame(arg0 *wire.MaxStreamDataFrame) {
 	m.ctrl.Call(m, "handleMaxStreamDataFrame", arg0)
@@ -150,6 +222,26 @@ func (mr *MockSendStreamIMockRecorder) hasData() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "hasData", reflect.TypeOf((*MockSendStreamI)(nil).hasData))
 }
 
+// onStreamFrameAcked mocks base method
+func (m *MockSendStreamI) onStreamFrameAcked(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "onStreamFrameAcked", arg0)
+}
+
+// onStreamFrameAcked indicates an expected call of onStreamFrameAcked
+func (mr *MockSendStreamIMockRecorder) onStreamFrameAcked(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "onStreamFrameAcked", reflect.TypeOf((*MockSendStreamI)(nil).onStreamFrameAcked), arg0)
+}
+
+// onStreamFrameLost mocks base method
+func (m *MockSendStreamI) onStreamFrameLost(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "onStreamFrameLost", arg0)
+}
+
+// onStreamFrameLost indicates an expected call of onStreamFrameLost
+func (mr *MockSendStreamIMockRecorder) onStreamFrameLost(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "onStreamFrameLost", reflect.TypeOf((*MockSendStreamI)(nil).onStreamFrameLost), arg0)
+}
+
 // popStreamFrame mocks base method
 func (m *MockSendStreamI) popStreamFrame(arg0 protocol.ByteCount) (*wire.StreamFrame, bool) {
 	ret := m.ctrl.Call(m, "popStreamFrame", arg0)
@@ -162,3 +254,15 @@ func (m *MockSendStreamI) popStreamFrame(arg0 protocol.ByteCount) (*wire.StreamF
 func (mr *MockSendStreamIMockRecorder) popStreamFrame(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "popStreamFrame", reflect.TypeOf((*MockSendStreamI)(nil).popStreamFrame), arg0)
 }
+
+// stats mocks base method
+func (m *MockSendStreamI) stats() StreamStats {
+	ret := m.ctrl.Call(m, "stats")
+	ret0, _ := ret[0].(StreamStats)
+	return ret0
+}
+
+// stats indicates an expected call of stats
+func (mr *MockSendStreamIMockRecorder) stats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "stats", reflect.TypeOf((*MockSendStreamI)(nil).stats))
+}
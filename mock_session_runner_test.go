@@ -5,6 +5,7 @@
 package quic
 
 import (
+	net "net"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,8 +35,78 @@ func (m *MockSessionRunner) EXPECT() *MockSessionRunnerMockRecorder {
 	return m.recorder
 }
 
+// addConnectionID mocks base method
+func (m *MockSessionRunner) addConnectionID(arg0 protocol.ConnectionID, arg1 quicSession) bool {
+	ret := m.ctrl.Call(m, "addConnectionID", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// addConnectionID indicates an expected call of addConnectionID
+func (mr *MockSessionRunnerMockRecorder) addConnectionID(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "addConnectionID", reflect.TypeOf((*MockSessionRunner)(nil).addConnectionID), arg0, arg1)
+}
+
+// addPacketConn mocks base method
+func (m *MockSessionRunner) addPacketConn(arg0 net.PacketConn) (connection, error) {
+	ret := m.ctrl.Call(m, "addPacketConn", arg0)
+	ret0, _ := ret[0].(connection)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// addPacketConn indicates an expected call of addPacketConn
+func (mr *MockSessionRunnerMockRecorder) addPacketConn(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "addPacketConn", reflect.TypeOf((*MockSessionRunner)(nil).addPacketConn), arg0)
+}
+
+// addResetToken mocks base method
+func (m *MockSessionRunner) addResetToken(arg0 [16]byte) {
+	m.ctrl.Call(m, "addResetToken", arg0)
+}
+
+// addResetToken indicates an expected call of addResetToken
+func (mr *MockSessionRunnerMockRecorder) addResetToken(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "addResetToken", reflect.TypeOf((*MockSessionRunner)(nil).addResetToken), arg0)
+}
+
+// addToken mocks base method
+func (m *MockSessionRunner) addToken(arg0 []byte) {
+	m.ctrl.Call(m, "addToken", arg0)
+}
+
+// addToken indicates an expected call of addToken
+func (mr *MockSessionRunnerMockRecorder) addToken(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "addToken", reflect.TypeOf((*MockSessionRunner)(nil).addToken), arg0)
+}
+
+// getStatelessResetToken mocks base method
+func (m *MockSessionRunner) getStatelessResetToken(arg0 protocol.ConnectionID) [16]byte {
+	ret := m.ctrl.Call(m, "getStatelessResetToken", arg0)
+	ret0, _ := ret[0].([16]byte)
+	return ret0
+}
+
+// getStatelessResetToken indicates an expected call of getStatelessResetToken
+func (mr *MockSessionRunnerMockRecorder) getStatelessResetToken(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getStatelessResetToken", reflect.TypeOf((*MockSessionRunner)(nil).getStatelessResetToken), arg0)
+}
+
+// newToken mocks base method
+func (m *MockSessionRunner) newToken(arg0 net.Addr) ([]byte, error) {
+	ret := m.ctrl.Call(m, "newToken", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// newToken indicates an expected call of newToken
+func (mr *MockSessionRunnerMockRecorder) newToken(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "newToken", reflect.TypeOf((*MockSessionRunner)(nil).newToken), arg0)
+}
+
 // onHandshakeComplete mocks base method
-func (m *MockSessionRunner) onHandshakeComplete(arg0 Session) {
+func (m *MockSessionRunner) onHandshakeComplete(arg0 quicSession) {
 	m.ctrl.Call(m, "onHandshakeComplete", arg0)
 }
 
@@ -54,6 +125,16 @@ func (mr *MockSessionRunnerMockRecorder) removeConnectionID(arg0 interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "removeConnectionID", reflect.TypeOf((*MockSessionRunner)(nil).removeConnectionID), arg0)
 }
 
+// removePacketConn mocks base method
+func (m *MockSessionRunner) removePacketConn(arg0 connection) {
+	m.ctrl.Call(m, "removePacketConn", arg0)
+}
+
+// removePacketConn indicates an expected call of removePacketConn
+func (mr *MockSessionRunnerMockRecorder) removePacketConn(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "removePacketConn", reflect.TypeOf((*MockSessionRunner)(nil).removePacketConn), arg0)
+}
+
 // retireConnectionID mocks base method
 func (m *MockSessionRunner) retireConnectionID(arg0 protocol.ConnectionID) {
 	m.ctrl.Call(m, "retireConnectionID", arg0)
@@ -63,3 +144,13 @@ func (m *MockSessionRunner) retireConnectionID(arg0 protocol.ConnectionID) {
 func (mr *MockSessionRunnerMockRecorder) retireConnectionID(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "retireConnectionID", reflect.TypeOf((*MockSessionRunner)(nil).retireConnectionID), arg0)
 }
+
+// switchPacketConn mocks base method
+func (m *MockSessionRunner) switchPacketConn(arg0 connection) {
+	m.ctrl.Call(m, "switchPacketConn", arg0)
+}
+
+// switchPacketConn indicates an expected call of switchPacketConn
+func (mr *MockSessionRunnerMockRecorder) switchPacketConn(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "switchPacketConn", reflect.TypeOf((*MockSessionRunner)(nil).switchPacketConn), arg0)
+}
@@ -6,6 +6,7 @@ package quic
 
 import (
 	context "context"
+	net "net"
 	reflect "reflect"
 	time "time"
 
@@ -81,6 +82,44 @@ func (mr *MockStreamIMockRecorder) Context() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Context", reflect.TypeOf((*MockStreamI)(nil).Context))
 }
 
+// Discard mocks base method
+func (m *MockStreamI) Discard(arg0 int) (int, error) {
+	ret := m.ctrl.Call(m, "Discard", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Discard indicates an expected call of Discard
+func (mr *MockStreamIMockRecorder) Discard(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Discard", reflect.TypeOf((*MockStreamI)(nil).Discard), arg0)
+}
+
+// LocalAddr mocks base method
+func (m *MockStreamI) LocalAddr() net.Addr {
+	ret := m.ctrl.Call(m, "LocalAddr")
+	ret0, _ := ret[0].(net.Addr)
+	return ret0
+}
+
+// LocalAddr indicates an expected call of LocalAddr
+func (mr *MockStreamIMockRecorder) LocalAddr() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocalAddr", reflect.TypeOf((*MockStreamI)(nil).LocalAddr))
+}
+
+// Peek mocks base method
+func (m *MockStreamI) Peek(arg0 int) ([]byte, error) {
+	ret := m.ctrl.Call(m, "Peek", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Peek indicates an expected call of Peek
+func (mr *MockStreamIMockRecorder) Peek(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Peek", reflect.TypeOf((*MockStreamI)(nil).Peek), arg0)
+}
+
 // Read mocks base method
 func (m *MockStreamI) Read(arg0 []byte) (int, error) {
 	ret := m.ctrl.Call(m, "Read", arg0)
@@ -94,6 +133,42 @@ func (mr *MockStreamIMockRecorder) Read(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockStreamI)(nil).Read), arg0)
 }
 
+// ReceiveStreamState mocks base method
+func (m *MockStreamI) ReceiveStreamState() ReceiveStreamState {
+	ret := m.ctrl.Call(m, "ReceiveStreamState")
+	ret0, _ := ret[0].(ReceiveStreamState)
+	return ret0
+}
+
+// ReceiveStreamState indicates an expected call of ReceiveStreamState
+func (mr *MockStreamIMockRecorder) ReceiveStreamState() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceiveStreamState", reflect.TypeOf((*MockStreamI)(nil).ReceiveStreamState))
+}
+
+// RemoteAddr mocks base method
+func (m *MockStreamI) RemoteAddr() net.Addr {
+	ret := m.ctrl.Call(m, "RemoteAddr")
+	ret0, _ := ret[0].(net.Addr)
+	return ret0
+}
+
+// RemoteAddr indicates an expected call of RemoteAddr
+func (mr *MockStreamIMockRecorder) RemoteAddr() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoteAddr", reflect.TypeOf((*MockStreamI)(nil).RemoteAddr))
+}
+
+// SendStreamState mocks base method
+func (m *MockStreamI) SendStreamState() SendStreamState {
+	ret := m.ctrl.Call(m, "SendStreamState")
+	ret0, _ := ret[0].(SendStreamState)
+	return ret0
+}
+
+// SendStreamState indicates an expected call of SendStreamState
+func (mr *MockStreamIMockRecorder) SendStreamState() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendStreamState", reflect.TypeOf((*MockStreamI)(nil).SendStreamState))
+}
+
 // SetDeadline mocks base method
 func (m *MockStreamI) SetDeadline(arg0 time.Time) error {
 	ret := m.ctrl.Call(m, "SetDeadline", arg0)
@@ -106,6 +181,16 @@ func (mr *MockStreamIMockRecorder) SetDeadline(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDeadline", reflect.TypeOf((*MockStreamI)(nil).SetDeadline), arg0)
 }
 
+// SetPriority mocks base method
+func (m *MockStreamI) SetPriority(arg0 int) {
+	m.ctrl.Call(m, "SetPriority", arg0)
+}
+
+// SetPriority indicates an expected call of SetPriority
+func (mr *MockStreamIMockRecorder) SetPriority(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPriority", reflect.TypeOf((*MockStreamI)(nil).SetPriority), arg0)
+}
+
 // SetReadDeadline mocks base method
 func (m *MockStreamI) SetReadDeadline(arg0 time.Time) error {
 	ret := m.ctrl.Call(m, "SetReadDeadline", arg0)
@@ -118,6 +203,18 @@ func (mr *MockStreamIMockRecorder) SetReadDeadline(arg0 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetReadDeadline", reflect.TypeOf((*MockStreamI)(nil).SetReadDeadline), arg0)
 }
 
+// SetWriteBufferOwnership mocks base method
+func (m *MockStreamI) SetWriteBufferOwnership(arg0 BufferOwnership, arg1 func([]byte)) error {
+	ret := m.ctrl.Call(m, "SetWriteBufferOwnership", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetWriteBufferOwnership indicates an expected call of SetWriteBufferOwnership
+func (mr *MockStreamIMockRecorder) SetWriteBufferOwnership(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWriteBufferOwnership", reflect.TypeOf((*MockStreamI)(nil).SetWriteBufferOwnership), arg0, arg1)
+}
+
 // SetWriteDeadline mocks base method
 func (m *MockStreamI) SetWriteDeadline(arg0 time.Time) error {
 	ret := m.ctrl.Call(m, "SetWriteDeadline", arg0)
@@ -130,6 +227,18 @@ func (mr *MockStreamIMockRecorder) SetWriteDeadline(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWriteDeadline", reflect.TypeOf((*MockStreamI)(nil).SetWriteDeadline), arg0)
 }
 
+// Stats mocks base method
+func (m *MockStreamI) Stats() StreamStats {
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(StreamStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats
+func (mr *MockStreamIMockRecorder) Stats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockStreamI)(nil).Stats))
+}
+
 // StreamID mocks base method
 func (m *MockStreamI) StreamID() protocol.StreamID {
 	ret := m.ctrl.Call(m, "StreamID")
@@ -142,6 +251,19 @@ func (mr *MockStreamIMockRecorder) StreamID() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamID", reflect.TypeOf((*MockStreamI)(nil).StreamID))
 }
 
+// TryWrite mocks base method
+func (m *MockStreamI) TryWrite(arg0 []byte) (int, error) {
+	ret := m.ctrl.Call(m, "TryWrite", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TryWrite indicates an expected call of TryWrite
+func (mr *MockStreamIMockRecorder) TryWrite(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TryWrite", reflect.TypeOf((*MockStreamI)(nil).TryWrite), arg0)
+}
+
 // Write mocks base method
 func (m *MockStreamI) Write(arg0 []byte) (int, error) {
 	ret := m.ctrl.Call(m, "Write", arg0)
@@ -155,6 +277,32 @@ func (mr *MockStreamIMockRecorder) Write(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockStreamI)(nil).Write), arg0)
 }
 
+// WriteVectors mocks base method
+func (m *MockStreamI) WriteVectors(arg0 [][]byte) (int, error) {
+	ret := m.ctrl.Call(m, "WriteVectors", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WriteVectors indicates an expected call of WriteVectors
+func (mr *MockStreamIMockRecorder) WriteVectors(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteVectors", reflect.TypeOf((*MockStreamI)(nil).WriteVectors), arg0)
+}
+
+// checkOutOfOrderData mocks base method
+func (m *MockStreamI) checkOutOfOrderData(arg0 *wire.StreamFrame, arg1 protocol.ByteCount) (protocol.ByteCount, error) {
+	ret := m.ctrl.Call(m, "checkOutOfOrderData", arg0, arg1)
+	ret0, _ := ret[0].(protocol.ByteCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// checkOutOfOrderData indicates an expected call of checkOutOfOrderData
+func (mr *MockStreamIMockRecorder) checkOutOfOrderData(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "checkOutOfOrderData", reflect.TypeOf((*MockStreamI)(nil).checkOutOfOrderData), arg0, arg1)
+}
+
 // closeForShutdown mocks base method
 func (m *MockStreamI) closeForShutdown(arg0 error) {
 	m.ctrl.Call(m, "closeForShutdown", arg0)
@@ -165,6 +313,18 @@ func (mr *MockStreamIMockRecorder) closeForShutdown(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "closeForShutdown", reflect.TypeOf((*MockStreamI)(nil).closeForShutdown), arg0)
 }
 
+// getPriority mocks base method
+func (m *MockStreamI) getPriority() int {
+	ret := m.ctrl.Call(m, "getPriority")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// getPriority indicates an expected call of getPriority
+func (mr *MockStreamIMockRecorder) getPriority() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "getPriority", reflect.TypeOf((*MockStreamI)(nil).getPriority))
+}
+
 // getWindowUpdate mocks base method
 func (m *MockStreamI) getWindowUpdate() protocol.ByteCount {
 	ret := m.ctrl.Call(m, "getWindowUpdate")
@@ -210,15 +370,15 @@ func (mr *MockStreamIMockRecorder) handleStopSendingFrame(arg0 interface{}) *gom
 }
 
 // handleStreamFrame mocks base method
-func (m *MockStreamI) handleStreamFrame(arg0 *wire.StreamFrame) error {
-	ret := m.ctrl.Call(m, "handleStreamFrame", arg0)
+func (m *MockStreamI) handleStreamFrame(arg0 *wire.StreamFrame, arg1 *packetBuffer) error {
+	ret := m.ctrl.Call(m, "handleStreamFrame", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // handleStreamFrame indicates an expected call of handleStreamFrame
-func (mr *MockStreamIMockRecorder) handleStreamFrame(arg0 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "handleStreamFrame", reflect.TypeOf((*MockStreamI)(nil).handleStreamFrame), arg0)
+func (mr *MockStreamIMockRecorder) handleStreamFrame(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "handleStreamFrame", reflect.TypeOf((*MockStreamI)(nil).handleStreamFrame), arg0, arg1)
 }
 
 // hasData mocks base method
@@ -233,6 +393,26 @@ func (mr *MockStreamIMockRecorder) hasData() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "hasData", reflect.TypeOf((*MockStreamI)(nil).hasData))
 }
 
+// onStreamFrameAcked mocks base method
+func (m *MockStreamI) onStreamFrameAcked(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "onStreamFrameAcked", arg0)
+}
+
+// onStreamFrameAcked indicates an expected call of onStreamFrameAcked
+func (mr *MockStreamIMockRecorder) onStreamFrameAcked(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "onStreamFrameAcked", reflect.TypeOf((*MockStreamI)(nil).onStreamFrameAcked), arg0)
+}
+
+// onStreamFrameLost mocks base method
+func (m *MockStreamI) onStreamFrameLost(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "onStreamFrameLost", arg0)
+}
+
+// onStreamFrameLost indicates an expected call of onStreamFrameLost
+func (mr *MockStreamIMockRecorder) onStreamFrameLost(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "onStreamFrameLost", reflect.TypeOf((*MockStreamI)(nil).onStreamFrameLost), arg0)
+}
+
 // popStreamFrame mocks base method
 func (m *MockStreamI) popStreamFrame(arg0 protocol.ByteCount) (*wire.StreamFrame, bool) {
 	ret := m.ctrl.Call(m, "popStreamFrame", arg0)
@@ -245,3 +425,15 @@ func (m *MockStreamI) popStreamFrame(arg0 protocol.ByteCount) (*wire.StreamFrame
 func (mr *MockStreamIMockRecorder) popStreamFrame(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "popStreamFrame", reflect.TypeOf((*MockStreamI)(nil).popStreamFrame), arg0)
 }
+
+// stats mocks base method
+func (m *MockStreamI) stats() StreamStats {
+	ret := m.ctrl.Call(m, "stats")
+	ret0, _ := ret[0].(StreamStats)
+	return ret0
+}
+
+// stats indicates an expected call of stats
+func (mr *MockStreamIMockRecorder) stats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "stats", reflect.TypeOf((*MockStreamI)(nil).stats))
+}
@@ -5,6 +5,7 @@
 package quic
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -37,29 +38,29 @@ func (m *MockStreamManager) EXPECT() *MockStreamManagerMockRecorder {
 }
 
 // AcceptStream mocks base method
-func (m *MockStreamManager) AcceptStream() (Stream, error) {
-	ret := m.ctrl.Call(m, "AcceptStream")
+func (m *MockStreamManager) AcceptStream(arg0 context.Context) (Stream, error) {
+	ret := m.ctrl.Call(m, "AcceptStream", arg0)
 	ret0, _ := ret[0].(Stream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AcceptStream indicates an expected call of AcceptStream
-func (mr *MockStreamManagerMockRecorder) AcceptStream() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptStream", reflect.TypeOf((*MockStreamManager)(nil).AcceptStream))
+func (mr *MockStreamManagerMockRecorder) AcceptStream(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptStream", reflect.TypeOf((*MockStreamManager)(nil).AcceptStream), arg0)
 }
 
 // AcceptUniStream mocks base method
-func (m *MockStreamManager) AcceptUniStream() (ReceiveStream, error) {
-	ret := m.ctrl.Call(m, "AcceptUniStream")
+func (m *MockStreamManager) AcceptUniStream(arg0 context.Context) (ReceiveStream, error) {
+	ret := m.ctrl.Call(m, "AcceptUniStream", arg0)
 	ret0, _ := ret[0].(ReceiveStream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AcceptUniStream indicates an expected call of AcceptUniStream
-func (mr *MockStreamManagerMockRecorder) AcceptUniStream() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptUniStream", reflect.TypeOf((*MockStreamManager)(nil).AcceptUniStream))
+func (mr *MockStreamManagerMockRecorder) AcceptUniStream(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptUniStream", reflect.TypeOf((*MockStreamManager)(nil).AcceptUniStream), arg0)
 }
 
 // CloseWithError mocks base method
@@ -122,6 +123,38 @@ func (mr *MockStreamManagerMockRecorder) HandleMaxStreamsFrame(arg0 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleMaxStreamsFrame", reflect.TypeOf((*MockStreamManager)(nil).HandleMaxStreamsFrame), arg0)
 }
 
+// HasUnsentData mocks base method
+func (m *MockStreamManager) HasUnsentData() bool {
+	ret := m.ctrl.Call(m, "HasUnsentData")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// HasUnsentData indicates an expected call of HasUnsentData
+func (mr *MockStreamManagerMockRecorder) HasUnsentData() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasUnsentData", reflect.TypeOf((*MockStreamManager)(nil).HasUnsentData))
+}
+
+// OnStreamFrameAcked mocks base method
+func (m *MockStreamManager) OnStreamFrameAcked(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "OnStreamFrameAcked", arg0)
+}
+
+// OnStreamFrameAcked indicates an expected call of OnStreamFrameAcked
+func (mr *MockStreamManagerMockRecorder) OnStreamFrameAcked(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnStreamFrameAcked", reflect.TypeOf((*MockStreamManager)(nil).OnStreamFrameAcked), arg0)
+}
+
+// OnStreamFrameLost mocks base method
+func (m *MockStreamManager) OnStreamFrameLost(arg0 *wire.StreamFrame) {
+	m.ctrl.Call(m, "OnStreamFrameLost", arg0)
+}
+
+// OnStreamFrameLost indicates an expected call of OnStreamFrameLost
+func (mr *MockStreamManagerMockRecorder) OnStreamFrameLost(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnStreamFrameLost", reflect.TypeOf((*MockStreamManager)(nil).OnStreamFrameLost), arg0)
+}
+
 // OpenStream mocks base method
 func (m *MockStreamManager) OpenStream() (Stream, error) {
 	ret := m.ctrl.Call(m, "OpenStream")
@@ -136,16 +169,16 @@ func (mr *MockStreamManagerMockRecorder) OpenStream() *gomock.Call {
 }
 
 // OpenStreamSync mocks base method
-func (m *MockStreamManager) OpenStreamSync() (Stream, error) {
-	ret := m.ctrl.Call(m, "OpenStreamSync")
+func (m *MockStreamManager) OpenStreamSync(arg0 context.Context) (Stream, error) {
+	ret := m.ctrl.Call(m, "OpenStreamSync", arg0)
 	ret0, _ := ret[0].(Stream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // OpenStreamSync indicates an expected call of OpenStreamSync
-func (mr *MockStreamManagerMockRecorder) OpenStreamSync() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenStreamSync", reflect.TypeOf((*MockStreamManager)(nil).OpenStreamSync))
+func (mr *MockStreamManagerMockRecorder) OpenStreamSync(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenStreamSync", reflect.TypeOf((*MockStreamManager)(nil).OpenStreamSync), arg0)
 }
 
 // OpenUniStream mocks base method
@@ -162,16 +195,16 @@ func (mr *MockStreamManagerMockRecorder) OpenUniStream() *gomock.Call {
 }
 
 // OpenUniStreamSync mocks base method
-func (m *MockStreamManager) OpenUniStreamSync() (SendStream, error) {
-	ret := m.ctrl.Call(m, "OpenUniStreamSync")
+func (m *MockStreamManager) OpenUniStreamSync(arg0 context.Context) (SendStream, error) {
+	ret := m.ctrl.Call(m, "OpenUniStreamSync", arg0)
 	ret0, _ := ret[0].(SendStream)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // OpenUniStreamSync indicates an expected call of OpenUniStreamSync
-func (mr *MockStreamManagerMockRecorder) OpenUniStreamSync() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenUniStreamSync", reflect.TypeOf((*MockStreamManager)(nil).OpenUniStreamSync))
+func (mr *MockStreamManagerMockRecorder) OpenUniStreamSync(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenUniStreamSync", reflect.TypeOf((*MockStreamManager)(nil).OpenUniStreamSync), arg0)
 }
 
 // UpdateLimits mocks base method
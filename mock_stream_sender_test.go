@@ -5,6 +5,7 @@
 package quic
 
 import (
+	net "net"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -35,6 +36,30 @@ func (m *MockStreamSender) EXPECT() *MockStreamSenderMockRecorder {
 	return m.recorder
 }
 
+// LocalAddr mocks base method
+func (m *MockStreamSender) LocalAddr() net.Addr {
+	ret := m.ctrl.Call(m, "LocalAddr")
+	ret0, _ := ret[0].(net.Addr)
+	return ret0
+}
+
+// LocalAddr indicates an expected call of LocalAddr
+func (mr *MockStreamSenderMockRecorder) LocalAddr() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LocalAddr", reflect.TypeOf((*MockStreamSender)(nil).LocalAddr))
+}
+
+// RemoteAddr mocks base method
+func (m *MockStreamSender) RemoteAddr() net.Addr {
+	ret := m.ctrl.Call(m, "RemoteAddr")
+	ret0, _ := ret[0].(net.Addr)
+	return ret0
+}
+
+// RemoteAddr indicates an expected call of RemoteAddr
+func (mr *MockStreamSenderMockRecorder) RemoteAddr() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoteAddr", reflect.TypeOf((*MockStreamSender)(nil).RemoteAddr))
+}
+
 // onHasStreamData mocks base method
 func (m *MockStreamSender) onHasStreamData(arg0 protocol.StreamID) {
 	m.ctrl.Call(m, "onHasStreamData", arg0)
@@ -45,6 +70,16 @@ func (mr *MockStreamSenderMockRecorder) onHasStreamData(arg0 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "onHasStreamData", reflect.TypeOf((*MockStreamSender)(nil).onHasStreamData), arg0)
 }
 
+// onStreamPriorityChanged mocks base method
+func (m *MockStreamSender) onStreamPriorityChanged(arg0 protocol.StreamID) {
+	m.ctrl.Call(m, "onStreamPriorityChanged", arg0)
+}
+
+// onStreamPriorityChanged indicates an expected call of onStreamPriorityChanged
+func (mr *MockStreamSenderMockRecorder) onStreamPriorityChanged(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "onStreamPriorityChanged", reflect.TypeOf((*MockStreamSender)(nil).onStreamPriorityChanged), arg0)
+}
+
 // onStreamCompleted mocks base method
 func (m *MockStreamSender) onStreamCompleted(arg0 protocol.StreamID) {
 	m.ctrl.Call(m, "onStreamCompleted", arg0)
@@ -0,0 +1,263 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
+)
+
+// netConn is a net.Conn that reads from and writes to a single stream.
+type netConn struct {
+	sess         Session
+	str          Stream
+	closeSession bool
+
+	closeOnce sync.Once
+	closed    utils.AtomicBool
+}
+
+var _ net.Conn = &netConn{}
+
+// NewConn returns a net.Conn that reads from and writes to a stream of the session.
+// This allows using QUIC with code that expects a net.Conn.
+// Close closes the stream: data written before is still delivered to the peer, followed by the FIN.
+// Data received after that is discarded, and the peer is asked to stop sending (using a STOP_SENDING frame).
+// Calls to Read and Write that are blocked when Close is called are unblocked.
+// If closeSession is set, Close also closes the session, after all data was delivered (see Session.CloseGracefully).
+// Once the net.Conn is closed, all methods return an error wrapping net.ErrClosed.
+func NewConn(sess Session, str Stream, closeSession bool) net.Conn {
+	return &netConn{
+		sess:         sess,
+		str:          str,
+		closeSession: closeSession,
+	}
+}
+
+func (c *netConn) Read(b []byte) (int, error) {
+	if c.closed.Get() {
+		return 0, c.opError("read", net.ErrClosed)
+	}
+	n, err := c.str.Read(b)
+	if err != nil && c.closed.Get() {
+		return n, c.opError("read", net.ErrClosed)
+	}
+	return n, err
+}
+
+func (c *netConn) Write(b []byte) (int, error) {
+	if c.closed.Get() {
+		return 0, c.opError("write", net.ErrClosed)
+	}
+	n, err := c.str.Write(b)
+	if err != nil && c.closed.Get() {
+		return n, c.opError("write", net.ErrClosed)
+	}
+	return n, err
+}
+
+func (c *netConn) Close() error {
+	var (
+		err    error
+		closed bool
+	)
+	c.closeOnce.Do(func() {
+		closed = true
+		c.closed.Set(true)
+		c.str.CancelRead(0)
+		// Unblock Write. The data that was already sent (or buffered) is still delivered.
+		_ = c.str.SetWriteDeadline(time.Now()) // SetWriteDeadline never errors
+		err = c.str.Close()
+		if c.closeSession {
+			go c.sess.CloseGracefully(protocol.NetConnCloseTimeout)
+		}
+	})
+	if !closed {
+		return c.opError("close", net.ErrClosed)
+	}
+	return err
+}
+
+func (c *netConn) LocalAddr() net.Addr {
+	return c.sess.LocalAddr()
+}
+
+func (c *netConn) RemoteAddr() net.Addr {
+	return c.sess.RemoteAddr()
+}
+
+func (c *netConn) SetDeadline(t time.Time) error {
+	if c.closed.Get() {
+		return c.opError("set", net.ErrClosed)
+	}
+	return c.str.SetDeadline(t)
+}
+
+func (c *netConn) SetReadDeadline(t time.Time) error {
+	if c.closed.Get() {
+		return c.opError("set", net.ErrClosed)
+	}
+	return c.str.SetReadDeadline(t)
+}
+
+func (c *netConn) SetWriteDeadline(t time.Time) error {
+	if c.closed.Get() {
+		return c.opError("set", net.ErrClosed)
+	}
+	return c.str.SetWriteDeadline(t)
+}
+
+func (c *netConn) opError(op string, err error) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    "quic",
+		Source: c.sess.LocalAddr(),
+		Addr:   c.sess.RemoteAddr(),
+		Err:    err,
+	}
+}
+
+// sessionListener is a net.Listener that returns a net.Conn for the first stream of every session.
+type sessionListener struct {
+	ln Listener
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closeChan chan struct{} // closed when the listener is closed
+	closeErr  error         // set before closing the closeChan
+}
+
+var _ net.Listener = &sessionListener{}
+
+// NewSessionListener returns a net.Listener that accepts a net.Conn for every session accepted by ln.
+// The net.Conn uses the first stream opened by the peer, see NewConn.
+// Closing the net.Conn closes the session.
+// Closing the net.Listener closes ln, and therefore all sessions.
+func NewSessionListener(ln Listener) net.Listener {
+	l := &sessionListener{
+		ln:        ln,
+		conns:     make(chan net.Conn),
+		closeChan: make(chan struct{}),
+	}
+	l.ctx, l.ctxCancel = context.WithCancel(context.Background())
+	go l.run()
+	return l
+}
+
+func (l *sessionListener) run() {
+	for {
+		sess, err := l.ln.Accept(l.ctx)
+		if err != nil {
+			l.closeWithError(err)
+			return
+		}
+		// Don't block accepting new sessions while waiting for the peer to open a stream.
+		go l.acceptStream(sess)
+	}
+}
+
+func (l *sessionListener) acceptStream(sess Session) {
+	str, err := sess.AcceptStream(l.ctx)
+	if err != nil {
+		return
+	}
+	conn := NewConn(sess, str, true)
+	select {
+	case l.conns <- conn:
+	case <-l.closeChan:
+		conn.Close()
+	}
+}
+
+func (l *sessionListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, &net.OpError{Op: "accept", Net: "quic", Addr: l.ln.Addr(), Err: l.closeErr}
+	}
+}
+
+func (l *sessionListener) Close() error {
+	closed := l.closeWithError(net.ErrClosed)
+	if !closed {
+		return &net.OpError{Op: "close", Net: "quic", Addr: l.ln.Addr(), Err: net.ErrClosed}
+	}
+	return l.ln.Close()
+}
+
+// closeWithError closes the listener.
+// It returns false if the listener was already closed.
+func (l *sessionListener) closeWithError(err error) bool {
+	var closed bool
+	l.closeOnce.Do(func() {
+		if errors.Is(err, ErrServerClosed) {
+			err = net.ErrClosed
+		}
+		l.closeErr = err
+		close(l.closeChan)
+		l.ctxCancel()
+		closed = true
+	})
+	return closed
+}
+
+func (l *sessionListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// streamListener is a net.Listener that returns a net.Conn for every stream opened by the peer.
+type streamListener struct {
+	sess Session
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	closeOnce sync.Once
+	closed    utils.AtomicBool
+}
+
+var _ net.Listener = &streamListener{}
+
+// NewStreamListener returns a net.Listener that accepts a net.Conn for every stream opened by the peer on sess.
+// Closing the net.Conn doesn't close the session, see NewConn.
+// Closing the net.Listener doesn't close the session either.
+func NewStreamListener(sess Session) net.Listener {
+	l := &streamListener{sess: sess}
+	l.ctx, l.ctxCancel = context.WithCancel(context.Background())
+	return l
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	str, err := l.sess.AcceptStream(l.ctx)
+	if err != nil {
+		if l.closed.Get() {
+			err = net.ErrClosed
+		}
+		return nil, &net.OpError{Op: "accept", Net: "quic", Addr: l.sess.LocalAddr(), Err: err}
+	}
+	return NewConn(l.sess, str, false), nil
+}
+
+func (l *streamListener) Close() error {
+	var closed bool
+	l.closeOnce.Do(func() {
+		closed = true
+		l.closed.Set(true)
+		l.ctxCancel()
+	})
+	if !closed {
+		return &net.OpError{Op: "close", Net: "quic", Addr: l.sess.LocalAddr(), Err: net.ErrClosed}
+	}
+	return nil
+}
+
+func (l *streamListener) Addr() net.Addr {
+	return l.sess.LocalAddr()
+}
@@ -0,0 +1,296 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("net.Conn adapter", func() {
+	var (
+		sess       *MockQuicSession
+		str        *MockStreamI
+		localAddr  = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}
+		remoteAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 4242}
+	)
+
+	BeforeEach(func() {
+		sess = NewMockQuicSession(mockCtrl)
+		str = NewMockStreamI(mockCtrl)
+		sess.EXPECT().LocalAddr().Return(localAddr).AnyTimes()
+		sess.EXPECT().RemoteAddr().Return(remoteAddr).AnyTimes()
+	})
+
+	Context("conn", func() {
+		It("reads from and writes to the stream", func() {
+			conn := NewConn(sess, str, false)
+			str.EXPECT().Read(gomock.Any()).DoAndReturn(func(b []byte) (int, error) {
+				return copy(b, "foobar"), nil
+			})
+			b := make([]byte, 6)
+			n, err := conn.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(b).To(Equal([]byte("foobar")))
+			str.EXPECT().Write([]byte("foobar")).Return(6, nil)
+			n, err = conn.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			str.EXPECT().Read(gomock.Any()).Return(0, io.EOF)
+			_, err = conn.Read(b)
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("returns the addresses of the session", func() {
+			conn := NewConn(sess, str, false)
+			Expect(conn.LocalAddr()).To(Equal(localAddr))
+			Expect(conn.RemoteAddr()).To(Equal(remoteAddr))
+		})
+
+		It("sets the deadlines on the stream", func() {
+			conn := NewConn(sess, str, false)
+			t := time.Now().Add(time.Hour)
+			str.EXPECT().SetDeadline(t)
+			str.EXPECT().SetReadDeadline(t.Add(time.Second))
+			str.EXPECT().SetWriteDeadline(t.Add(2 * time.Second))
+			Expect(conn.SetDeadline(t)).To(Succeed())
+			Expect(conn.SetReadDeadline(t.Add(time.Second))).To(Succeed())
+			Expect(conn.SetWriteDeadline(t.Add(2 * time.Second))).To(Succeed())
+		})
+
+		It("closes the stream, but not the session", func() {
+			conn := NewConn(sess, str, false)
+			gomock.InOrder(
+				str.EXPECT().CancelRead(ErrorCode(0)),
+				str.EXPECT().SetWriteDeadline(gomock.Any()).Do(func(t time.Time) {
+					Expect(t).To(BeTemporally("<=", time.Now()))
+				}),
+				str.EXPECT().Close(),
+			)
+			Expect(conn.Close()).To(Succeed())
+			// make sure the session is not closed
+			time.Sleep(scaleDuration(10 * time.Millisecond))
+		})
+
+		It("closes the session, if requested", func() {
+			conn := NewConn(sess, str, true)
+			str.EXPECT().CancelRead(ErrorCode(0))
+			str.EXPECT().SetWriteDeadline(gomock.Any())
+			str.EXPECT().Close()
+			closed := make(chan struct{})
+			sess.EXPECT().CloseGracefully(protocol.NetConnCloseTimeout).Do(func(time.Duration) { close(closed) })
+			Expect(conn.Close()).To(Succeed())
+			Eventually(closed).Should(BeClosed())
+		})
+
+		It("returns net.ErrClosed after it was closed", func() {
+			conn := NewConn(sess, str, false)
+			str.EXPECT().CancelRead(gomock.Any())
+			str.EXPECT().SetWriteDeadline(gomock.Any())
+			str.EXPECT().Close()
+			Expect(conn.Close()).To(Succeed())
+			_, err := conn.Read([]byte{0})
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+			Expect(err).To(BeAssignableToTypeOf(&net.OpError{}))
+			Expect(err.(*net.OpError).Op).To(Equal("read"))
+			_, err = conn.Write([]byte{0})
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+			Expect(errors.Is(conn.SetDeadline(time.Now()), net.ErrClosed)).To(BeTrue())
+			Expect(errors.Is(conn.SetReadDeadline(time.Now()), net.ErrClosed)).To(BeTrue())
+			Expect(errors.Is(conn.SetWriteDeadline(time.Now()), net.ErrClosed)).To(BeTrue())
+			Expect(errors.Is(conn.Close(), net.ErrClosed)).To(BeTrue())
+		})
+
+		It("unblocks Read and Write when closed", func() {
+			conn := NewConn(sess, str, false)
+			readCanceled := make(chan struct{})
+			writeCanceled := make(chan struct{})
+			str.EXPECT().Read(gomock.Any()).DoAndReturn(func([]byte) (int, error) {
+				<-readCanceled
+				return 0, errors.New("read canceled")
+			})
+			str.EXPECT().Write(gomock.Any()).DoAndReturn(func([]byte) (int, error) {
+				<-writeCanceled
+				return 0, errDeadline
+			})
+			str.EXPECT().CancelRead(gomock.Any()).Do(func(ErrorCode) { close(readCanceled) })
+			str.EXPECT().SetWriteDeadline(gomock.Any()).Do(func(time.Time) { close(writeCanceled) })
+			str.EXPECT().Close()
+			readErr := make(chan error, 1)
+			writeErr := make(chan error, 1)
+			go func() {
+				_, err := conn.Read([]byte{0})
+				readErr <- err
+			}()
+			go func() {
+				_, err := conn.Write([]byte{0})
+				writeErr <- err
+			}()
+			Consistently(readErr).ShouldNot(Receive())
+			Expect(conn.Close()).To(Succeed())
+			var err error
+			Eventually(readErr).Should(Receive(&err))
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+			Eventually(writeErr).Should(Receive(&err))
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+		})
+
+		It("returns deadline errors that net-based code understands", func() {
+			var nerr net.Error
+			Expect(errors.As(errDeadline, &nerr)).To(BeTrue())
+			Expect(nerr.Timeout()).To(BeTrue())
+			Expect(errors.Is(errDeadline, os.ErrDeadlineExceeded)).To(BeTrue())
+		})
+	})
+
+	Context("stream listener", func() {
+		It("accepts a conn for every stream", func() {
+			ln := NewStreamListener(sess)
+			Expect(ln.Addr()).To(Equal(localAddr))
+			sess.EXPECT().AcceptStream(gomock.Any()).Return(str, nil)
+			conn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			str.EXPECT().Write([]byte("foo"))
+			conn.Write([]byte("foo"))
+			// closing the conn doesn't close the session
+			str.EXPECT().CancelRead(gomock.Any())
+			str.EXPECT().SetWriteDeadline(gomock.Any())
+			str.EXPECT().Close()
+			Expect(conn.Close()).To(Succeed())
+		})
+
+		It("unblocks Accept when closed, without closing the session", func() {
+			ln := NewStreamListener(sess)
+			sess.EXPECT().AcceptStream(gomock.Any()).DoAndReturn(func(ctx context.Context) (Stream, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			})
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := ln.Accept()
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(ln.Close()).To(Succeed())
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+			Expect(err.(*net.OpError).Op).To(Equal("accept"))
+			Expect(errors.Is(ln.Close(), net.ErrClosed)).To(BeTrue())
+		})
+
+		It("returns the session's error", func() {
+			ln := NewStreamListener(sess)
+			testErr := errors.New("session closed")
+			sess.EXPECT().AcceptStream(gomock.Any()).Return(nil, testErr)
+			_, err := ln.Accept()
+			Expect(errors.Is(err, testErr)).To(BeTrue())
+		})
+	})
+
+	Context("session listener", func() {
+		var (
+			ln     Listener
+			netLn  net.Listener
+			client *http.Client
+		)
+
+		BeforeEach(func() {
+			var err error
+			ln, err = ListenAddr("localhost:0", testdata.GetTLSConfig(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			netLn = NewSessionListener(ln)
+			client = &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+						sess, err := DialAddrContext(ctx, addr, &tls.Config{InsecureSkipVerify: true}, nil)
+						if err != nil {
+							return nil, err
+						}
+						str, err := sess.OpenStreamSync(ctx)
+						if err != nil {
+							return nil, err
+						}
+						return NewConn(sess, str, true), nil
+					},
+				},
+			}
+		})
+
+		AfterEach(func() {
+			client.CloseIdleConnections()
+			netLn.Close()
+		})
+
+		It("returns the listener's address", func() {
+			Expect(netLn.Addr()).To(Equal(ln.Addr()))
+		})
+
+		It("runs net/http", func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.RemoteAddr).ToNot(BeEmpty())
+				w.Write([]byte("Hello, World!\n"))
+			})
+			mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				w.Write(body)
+			})
+			server := &http.Server{Handler: mux}
+			serveErr := make(chan error, 1)
+			go func() { serveErr <- server.Serve(netLn) }()
+
+			rsp, err := client.Get("http://" + netLn.Addr().String() + "/hello")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.StatusCode).To(Equal(http.StatusOK))
+			body, err := ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("Hello, World!\n"))
+			Expect(rsp.Body.Close()).To(Succeed())
+
+			// the request is sent on the same connection
+			data := strings.Repeat("foobar", 50000)
+			rsp, err = client.Post("http://"+netLn.Addr().String()+"/echo", "text/plain", strings.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.StatusCode).To(Equal(http.StatusOK))
+			body, err = ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal(data))
+			Expect(rsp.Body.Close()).To(Succeed())
+
+			Expect(server.Close()).To(Succeed())
+			Eventually(serveErr).Should(Receive(Equal(http.ErrServerClosed)))
+		})
+
+		It("returns net.ErrClosed from Accept after it was closed", func() {
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := netLn.Accept()
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(netLn.Close()).To(Succeed())
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+			Expect(errors.Is(netLn.Close(), net.ErrClosed)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,41 @@
+package quic
+
+import (
+	"sync"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+)
+
+// The outOfOrderDataLimiter limits the amount of stream data that was received out of order.
+// This data can't be read by the application before the gaps in front of it are filled,
+// so a peer could use it to make us buffer large amounts of data.
+// The data is limited per stream, and for all streams of a connection.
+type outOfOrderDataLimiter struct {
+	mutex sync.Mutex
+
+	maxPerStream     protocol.ByteCount
+	maxPerConnection protocol.ByteCount
+	buffered         protocol.ByteCount
+}
+
+func newOutOfOrderDataLimiter(maxPerStream, maxPerConnection protocol.ByteCount) *outOfOrderDataLimiter {
+	return &outOfOrderDataLimiter{
+		maxPerStream:     maxPerStream,
+		maxPerConnection: maxPerConnection,
+	}
+}
+
+// Allow says if a stream that buffers streamBuffered bytes of out-of-order data may buffer n more bytes.
+func (l *outOfOrderDataLimiter) Allow(streamBuffered, n protocol.ByteCount) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return streamBuffered+n <= l.maxPerStream && l.buffered+n <= l.maxPerConnection
+}
+
+// Update is called when the amount of out-of-order data buffered by a stream changed.
+func (l *outOfOrderDataLimiter) Update(old, new protocol.ByteCount) {
+	l.mutex.Lock()
+	l.buffered = l.buffered - old + new
+	l.mutex.Unlock()
+}
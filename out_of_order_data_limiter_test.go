@@ -0,0 +1,35 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Out-of-order data limiter", func() {
+	var l *outOfOrderDataLimiter
+
+	BeforeEach(func() {
+		l = newOutOfOrderDataLimiter(100, 150)
+	})
+
+	It("enforces the limit per stream", func() {
+		Expect(l.Allow(0, 100)).To(BeTrue())
+		Expect(l.Allow(0, 101)).To(BeFalse())
+		Expect(l.Allow(60, 40)).To(BeTrue())
+		Expect(l.Allow(60, 41)).To(BeFalse())
+	})
+
+	It("enforces the limit per connection", func() {
+		l.Update(0, 80)
+		Expect(l.Allow(0, 70)).To(BeTrue())
+		Expect(l.Allow(0, 71)).To(BeFalse())
+		l.Update(0, 60)
+		Expect(l.buffered).To(Equal(protocol.ByteCount(140)))
+		Expect(l.Allow(0, 11)).To(BeFalse())
+		l.Update(80, 20)
+		Expect(l.buffered).To(Equal(protocol.ByteCount(80)))
+		Expect(l.Allow(0, 70)).To(BeTrue())
+	})
+})
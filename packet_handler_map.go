@@ -2,8 +2,11 @@ package quic
 
 import (
 	"bytes"
-	"errors"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"net"
 	"sync"
 	"time"
@@ -11,6 +14,7 @@ import (
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
+	"golang.org/x/net/ipv4"
 )
 
 type packetHandlerEntry struct {
@@ -32,23 +36,47 @@ type packetHandlerMap struct {
 	resetTokens map[[16]byte] /* stateless reset token */ packetHandler
 	server      unknownPacketHandler
 	closed      bool
+	closeChan   chan struct{}
+
+	// Packets that can't be parsed as QUIC packets are passed to the nonQUICPacketHandler.
+	// It is run on a separate go routine, such that it doesn't block the read loop.
+	nonQUICPacketHandler func([]byte, net.Addr)
+	nonQUICPackets       chan *receivedPacket
 
 	deleteRetiredSessionsAfter time.Duration
 
+	// Stateless resets are only sent if a stateless reset key was configured.
+	// Otherwise, the tokens are derived from a random key, and are only valid as long as this process is running.
+	statelessResetEnabled bool
+	statelessResetMutex   sync.Mutex
+	statelessResetHasher  hash.Hash
+
 	logger utils.Logger
 }
 
 var _ packetHandlerManager = &packetHandlerMap{}
 
-func newPacketHandlerMap(conn net.PacketConn, connIDLen int, logger utils.Logger) packetHandlerManager {
+func newPacketHandlerMap(
+	conn net.PacketConn,
+	connIDLen int,
+	statelessResetKey []byte,
+	logger utils.Logger,
+) packetHandlerManager {
 	m := &packetHandlerMap{
 		conn:                       conn,
 		connIDLen:                  connIDLen,
 		handlers:                   make(map[string]packetHandlerEntry),
 		resetTokens:                make(map[[16]byte]packetHandler),
+		closeChan:                  make(chan struct{}),
 		deleteRetiredSessionsAfter: protocol.RetiredConnectionIDDeleteTimeout,
+		statelessResetEnabled:      len(statelessResetKey) > 0,
 		logger:                     logger,
 	}
+	if !m.statelessResetEnabled {
+		statelessResetKey = make([]byte, 32)
+		rand.Read(statelessResetKey)
+	}
+	m.statelessResetHasher = hmac.New(sha256.New, statelessResetKey)
 	go m.listen()
 	return m
 }
@@ -59,8 +87,25 @@ func (h *packetHandlerMap) Add(id protocol.ConnectionID, handler packetHandler)
 	h.mutex.Unlock()
 }
 
+// AddIfNotTaken adds a new handler, unless the connection ID is already in use.
+// It returns false if the connection ID is already in use.
+func (h *packetHandlerMap) AddIfNotTaken(id protocol.ConnectionID, handler packetHandler) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.handlers[string(id)]; ok {
+		return false
+	}
+	h.handlers[string(id)] = packetHandlerEntry{handler: handler}
+	return true
+}
+
 func (h *packetHandlerMap) AddWithResetToken(id protocol.ConnectionID, handler packetHandler, token [16]byte) {
 	h.mutex.Lock()
+	// The peer might have changed the stateless reset token, e.g. when we switched to a new connection ID.
+	if entry, ok := h.handlers[string(id)]; ok && entry.resetToken != nil {
+		delete(h.resetTokens, *entry.resetToken)
+	}
 	h.handlers[string(id)] = packetHandlerEntry{handler: handler, resetToken: &token}
 	h.resetTokens[token] = handler
 	h.mutex.Unlock()
@@ -97,6 +142,33 @@ func (h *packetHandlerMap) SetServer(s unknownPacketHandler) {
 	h.mutex.Unlock()
 }
 
+// SetNonQUICPacketHandler sets the handler for packets that are not QUIC packets.
+func (h *packetHandlerMap) SetNonQUICPacketHandler(handler func([]byte, net.Addr)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.nonQUICPackets == nil {
+		h.nonQUICPackets = make(chan *receivedPacket, protocol.MaxNonQUICPacketQueueLen)
+		go h.runNonQUICPacketHandler()
+	}
+	h.nonQUICPacketHandler = handler
+}
+
+func (h *packetHandlerMap) runNonQUICPacketHandler() {
+	for {
+		select {
+		case <-h.closeChan:
+			return
+		case p := <-h.nonQUICPackets:
+			h.mutex.RLock()
+			handler := h.nonQUICPacketHandler
+			h.mutex.RUnlock()
+			handler(p.data, p.remoteAddr)
+			p.buffer.Release()
+		}
+	}
+}
+
 func (h *packetHandlerMap) CloseServer() {
 	h.mutex.Lock()
 	h.server = nil
@@ -124,6 +196,7 @@ func (h *packetHandlerMap) close(e error) error {
 		return nil
 	}
 	h.closed = true
+	close(h.closeChan)
 
 	var wg sync.WaitGroup
 	for _, handlerEntry := range h.handlers {
@@ -143,6 +216,10 @@ func (h *packetHandlerMap) close(e error) error {
 }
 
 func (h *packetHandlerMap) listen() {
+	if bc := newBatchConn(h.conn); bc != nil {
+		h.listenBatch(bc)
+		return
+	}
 	for {
 		buffer := getPacketBuffer()
 		data := buffer.Slice
@@ -153,34 +230,86 @@ func (h *packetHandlerMap) listen() {
 			h.close(err)
 			return
 		}
-		h.handlePacket(addr, buffer, data[:n])
+		h.handlePacket(addr, protocol.ECNNon, buffer, data[:n])
+	}
+}
+
+// listenBatch reads up to protocol.MaxPacketBatchSize packets with a single syscall.
+func (h *packetHandlerMap) listenBatch(bc *batchConn) {
+	buffers := make([]*packetBuffer, protocol.MaxPacketBatchSize)
+	ms := make([]ipv4.Message, protocol.MaxPacketBatchSize)
+	for i := range ms {
+		buffers[i] = getPacketBuffer()
+		ms[i].Buffers = [][]byte{buffers[i].Slice}
+		ms[i].OOB = make([]byte, oobBufferSize)
+	}
+	for {
+		n, err := bc.ReadBatch(ms, 0)
+		// Handle the packets that were read before the error occurred.
+		for i := 0; i < n; i++ {
+			buffer := buffers[i]
+			h.handlePacket(ms[i].Addr, parseECN(ms[i].OOB[:ms[i].NN]), buffer, buffer.Slice[:ms[i].N])
+			buffers[i] = getPacketBuffer()
+			ms[i].Buffers[0] = buffers[i].Slice
+			ms[i].OOB = ms[i].OOB[:cap(ms[i].OOB)]
+		}
+		if err != nil {
+			for _, buffer := range buffers {
+				buffer.Release()
+			}
+			h.close(err)
+			return
+		}
 	}
 }
 
 func (h *packetHandlerMap) handlePacket(
 	addr net.Addr,
+	ecn protocol.ECN,
 	buffer *packetBuffer,
 	data []byte,
 ) {
-	packets, err := h.parsePacket(addr, buffer, data)
+	packets, err := h.parsePacket(addr, ecn, buffer, data)
 	if err != nil {
 		h.logger.Debugf("error parsing packets from %s: %s", addr, err)
 		// This is just the error from parsing the last packet.
 		// We still need to process the packets that were successfully parsed before.
 	}
 	if len(packets) == 0 {
-		buffer.Release()
+		if !h.maybeQueueNonQUICPacket(addr, buffer, data) {
+			buffer.Release()
+		}
 		return
 	}
 	h.handleParsedPackets(packets)
 }
 
+// maybeQueueNonQUICPacket queues a packet for the nonQUICPacketHandler.
+// It returns false if no handler is set.
+func (h *packetHandlerMap) maybeQueueNonQUICPacket(addr net.Addr, buffer *packetBuffer, data []byte) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if h.nonQUICPacketHandler == nil {
+		return false
+	}
+	select {
+	case h.nonQUICPackets <- &receivedPacket{remoteAddr: addr, data: data, buffer: buffer}:
+	default:
+		h.logger.Debugf("Dropping non-QUIC packet from %s, since the queue is full.", addr)
+		buffer.Release()
+	}
+	return true
+}
+
 func (h *packetHandlerMap) parsePacket(
 	addr net.Addr,
+	ecn protocol.ECN,
 	buffer *packetBuffer,
 	data []byte,
 ) ([]*receivedPacket, error) {
 	rcvTime := time.Now()
+	datagramSize := protocol.ByteCount(len(data))
 	packets := make([]*receivedPacket, 0, 1)
 
 	var counter int
@@ -211,11 +340,13 @@ func (h *packetHandlerMap) parsePacket(
 		}
 		counter++
 		packets = append(packets, &receivedPacket{
-			remoteAddr: addr,
-			hdr:        hdr,
-			rcvTime:    rcvTime,
-			data:       data,
-			buffer:     buffer,
+			remoteAddr:   addr,
+			hdr:          hdr,
+			rcvTime:      rcvTime,
+			ecn:          ecn,
+			data:         data,
+			datagramSize: datagramSize,
+			buffer:       buffer,
 		})
 
 		// only log if this actually a coalesced packet
@@ -236,23 +367,22 @@ func (h *packetHandlerMap) handleParsedPackets(packets []*receivedPacket) {
 	handlerEntry, handlerFound := h.handlers[string(packets[0].hdr.DestConnectionID)]
 
 	for _, p := range packets {
+		// A stateless reset looks like a short header packet.
+		// If the peer lost the state, it can't know which connection ID we're using,
+		// so we need to check for stateless resets independent of the connection ID.
+		if !p.hdr.IsLongHeader && h.maybeHandleStatelessReset(p.data) {
+			continue
+		}
 		if handlerFound { // existing session
 			handlerEntry.handler.handlePacket(p)
 			continue
 		}
 		// No session found.
-		// This might be a stateless reset.
 		if !p.hdr.IsLongHeader {
-			if len(p.data) >= protocol.MinStatelessResetSize {
-				var token [16]byte
-				copy(token[:], p.data[len(p.data)-16:])
-				if sess, ok := h.resetTokens[token]; ok {
-					sess.destroy(errors.New("received a stateless reset"))
-					continue
-				}
-			}
-			// TODO(#943): send a stateless reset
 			h.logger.Debugf("received a short header packet with an unexpected connection ID %s", p.hdr.DestConnectionID)
+			if h.statelessResetEnabled {
+				h.sendStatelessReset(p)
+			}
 			break // a short header packet is always the last in a coalesced packet
 		}
 		if h.server == nil { // no server set
@@ -262,3 +392,52 @@ func (h *packetHandlerMap) handleParsedPackets(packets []*receivedPacket) {
 		h.server.handlePacket(p)
 	}
 }
+
+func (h *packetHandlerMap) maybeHandleStatelessReset(data []byte) bool {
+	// A packet shorter than the minimum size can't be a stateless reset.
+	// This prevents an attacker from guessing the token from short packets.
+	if len(data) < protocol.MinStatelessResetSize {
+		return false
+	}
+	var token [16]byte
+	copy(token[:], data[len(data)-16:])
+	sess, ok := h.resetTokens[token]
+	if !ok {
+		return false
+	}
+	h.logger.Debugf("Received a stateless reset with token %#x. Closing session.", token)
+	sess.destroy(&StatelessResetError{Token: token})
+	return true
+}
+
+// GetStatelessResetToken derives the stateless reset token for a connection ID.
+// The token only depends on the stateless reset key and the connection ID,
+// so it is the same for a server using the same key after a restart.
+func (h *packetHandlerMap) GetStatelessResetToken(connID protocol.ConnectionID) [16]byte {
+	var token [16]byte
+	h.statelessResetMutex.Lock()
+	h.statelessResetHasher.Write(connID.Bytes())
+	copy(token[:], h.statelessResetHasher.Sum(nil))
+	h.statelessResetHasher.Reset()
+	h.statelessResetMutex.Unlock()
+	return token
+}
+
+// sendStatelessReset sends a stateless reset in response to a short header packet for an unknown connection ID.
+func (h *packetHandlerMap) sendStatelessReset(p *receivedPacket) {
+	// The stateless reset is always smaller than the packet that triggered it.
+	// Otherwise, two endpoints that both lost state could be sending stateless resets to each other forever.
+	if len(p.data) <= protocol.MinStatelessResetSize {
+		return
+	}
+	token := h.GetStatelessResetToken(p.hdr.DestConnectionID)
+	h.logger.Debugf("Sending stateless reset to %s (connection ID: %s). Token: %#x", p.remoteAddr, p.hdr.DestConnectionID, token)
+	data := make([]byte, protocol.MinStatelessResetSize-16, protocol.MinStatelessResetSize)
+	rand.Read(data)
+	// make it look like a short header packet
+	data[0] = (data[0] & 0x3f) | 0x40
+	data = append(data, token[:]...)
+	if _, err := h.conn.WriteTo(data, p.remoteAddr); err != nil {
+		h.logger.Debugf("Error sending stateless reset: %s", err)
+	}
+}
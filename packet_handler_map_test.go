@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/mock/gomock"
@@ -41,7 +42,7 @@ var _ = Describe("Packet Handler Map", func() {
 
 	BeforeEach(func() {
 		conn = newMockPacketConn()
-		handler = newPacketHandlerMap(conn, 5, utils.DefaultLogger).(*packetHandlerMap)
+		handler = newPacketHandlerMap(conn, 5, nil, utils.DefaultLogger).(*packetHandlerMap)
 	})
 
 	It("closes", func() {
@@ -66,6 +67,13 @@ var _ = Describe("Packet Handler Map", func() {
 		handler.close(testErr)
 	})
 
+	It("only adds a handler if the connection ID is not taken yet", func() {
+		connID := protocol.ConnectionID{1, 2, 3, 4}
+		Expect(handler.AddIfNotTaken(connID, NewMockPacketHandler(mockCtrl))).To(BeTrue())
+		Expect(handler.AddIfNotTaken(connID, NewMockPacketHandler(mockCtrl))).To(BeFalse())
+		Expect(handler.AddIfNotTaken(protocol.ConnectionID{4, 3, 2, 1}, NewMockPacketHandler(mockCtrl))).To(BeTrue())
+	})
+
 	Context("handling packets", func() {
 		It("handles packets for different packet handlers on the same packet conn", func() {
 			connID1 := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
@@ -97,7 +105,7 @@ var _ = Describe("Packet Handler Map", func() {
 		})
 
 		It("drops unparseable packets", func() {
-			_, err := handler.parsePacket(nil, nil, []byte{0, 1, 2, 3})
+			_, err := handler.parsePacket(nil, protocol.ECNNon, nil, []byte{0, 1, 2, 3})
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("error parsing header:"))
 		})
@@ -107,7 +115,7 @@ var _ = Describe("Packet Handler Map", func() {
 			connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
 			handler.Add(connID, NewMockPacketHandler(mockCtrl))
 			handler.Remove(connID)
-			handler.handlePacket(nil, nil, getPacket(connID))
+			handler.handlePacket(nil, protocol.ECNNon, nil, getPacket(connID))
 			// don't EXPECT any calls to handlePacket of the MockPacketHandler
 		})
 
@@ -117,7 +125,7 @@ var _ = Describe("Packet Handler Map", func() {
 			handler.Add(connID, NewMockPacketHandler(mockCtrl))
 			handler.Retire(connID)
 			time.Sleep(scaleDuration(30 * time.Millisecond))
-			handler.handlePacket(nil, nil, getPacket(connID))
+			handler.handlePacket(nil, protocol.ECNNon, nil, getPacket(connID))
 			// don't EXPECT any calls to handlePacket of the MockPacketHandler
 		})
 
@@ -128,12 +136,12 @@ var _ = Describe("Packet Handler Map", func() {
 			packetHandler.EXPECT().handlePacket(gomock.Any())
 			handler.Add(connID, packetHandler)
 			handler.Retire(connID)
-			handler.handlePacket(nil, nil, getPacket(connID))
+			handler.handlePacket(nil, protocol.ECNNon, nil, getPacket(connID))
 		})
 
 		It("drops packets for unknown receivers", func() {
 			connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
-			handler.handlePacket(nil, nil, getPacket(connID))
+			handler.handlePacket(nil, protocol.ECNNon, nil, getPacket(connID))
 		})
 
 		It("closes the packet handlers when reading from the conn fails", func() {
@@ -152,14 +160,14 @@ var _ = Describe("Packet Handler Map", func() {
 			It("errors on packets that are smaller than the length in the packet header, for too small packet number", func() {
 				connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
 				data := getPacketWithLength(connID, 3) // gets a packet with a 2 byte packet number
-				_, err := handler.parsePacket(nil, nil, data)
+				_, err := handler.parsePacket(nil, protocol.ECNNon, nil, data)
 				Expect(err).To(MatchError("packet length (2 bytes) is smaller than the expected length (3 bytes)"))
 			})
 
 			It("errors on packets that are smaller than the length in the packet header, for too small payload", func() {
 				connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
 				data := append(getPacketWithLength(connID, 1000), make([]byte, 500-2 /* for packet number length */)...)
-				_, err := handler.parsePacket(nil, nil, data)
+				_, err := handler.parsePacket(nil, protocol.ECNNon, nil, data)
 				Expect(err).To(MatchError("packet length (500 bytes) is smaller than the expected length (1000 bytes)"))
 			})
 
@@ -171,7 +179,7 @@ var _ = Describe("Packet Handler Map", func() {
 					Expect(p.data).To(HaveLen(456 + int(p.hdr.ParsedLen())))
 				})
 				handler.Add(connID, packetHandler)
-				handler.handlePacket(nil, nil, data)
+				handler.handlePacket(nil, protocol.ECNNon, nil, data)
 			})
 
 			It("handles coalesced packets", func() {
@@ -197,8 +205,9 @@ var _ = Describe("Packet Handler Map", func() {
 					Expect(p.hdr.DestConnectionID).To(Equal(connID))
 					Expect(p.hdr.Length).To(BeEquivalentTo(10 * i))
 					Expect(p.data).To(HaveLen(int(p.hdr.ParsedLen() + p.hdr.Length)))
+					Expect(p.datagramSize).To(BeEquivalentTo(len(packet)))
 					Expect(p.rcvTime).To(BeTemporally("~", now, scaleDuration(20*time.Millisecond)))
-					Expect(p.buffer.refCount).To(Equal(3))
+					Expect(p.buffer.refCount).To(BeEquivalentTo(3))
 				}
 
 				// makes the listen go routine return
@@ -216,11 +225,11 @@ var _ = Describe("Packet Handler Map", func() {
 				packet = append(packet, getPacket(connID1)...)
 				packet = append(packet, getPacket(connID2)...)
 
-				packets, err := handler.parsePacket(&net.UDPAddr{}, buffer, packet)
+				packets, err := handler.parsePacket(&net.UDPAddr{}, protocol.ECNNon, buffer, packet)
 				Expect(err).To(MatchError("coalesced packet has different destination connection ID: 0x0807060504030201, expected 0x0102030405060708"))
 				Expect(packets).To(HaveLen(1))
 				Expect(packets[0].hdr.DestConnectionID).To(Equal(connID1))
-				Expect(packets[0].buffer.refCount).To(Equal(1))
+				Expect(packets[0].buffer.refCount).To(BeEquivalentTo(1))
 			})
 		})
 	})
@@ -249,7 +258,7 @@ var _ = Describe("Packet Handler Map", func() {
 			packet := append([]byte{0x40} /* short header packet */, make([]byte, 50)...)
 			packet = append(packet, token[:]...)
 			destroyed := make(chan struct{})
-			packetHandler.EXPECT().destroy(errors.New("received a stateless reset")).Do(func(error) {
+			packetHandler.EXPECT().destroy(&StatelessResetError{Token: token}).Do(func(error) {
 				close(destroyed)
 			})
 			conn.dataToRead <- packet
@@ -267,13 +276,56 @@ var _ = Describe("Packet Handler Map", func() {
 			reset = append(reset, make([]byte, 50)...) // add some "random" data
 			reset = append(reset, token[:]...)
 			destroyed := make(chan struct{})
-			packetHandler.EXPECT().destroy(errors.New("received a stateless reset")).Do(func(error) {
+			packetHandler.EXPECT().destroy(&StatelessResetError{Token: token}).Do(func(error) {
 				close(destroyed)
 			})
 			conn.dataToRead <- append(packet, reset...)
 			Eventually(destroyed).Should(BeClosed())
 		})
 
+		It("detects stateless resets for connections with a different connection ID", func() {
+			packetHandler := NewMockPacketHandler(mockCtrl)
+			connID := protocol.ConnectionID{0xde, 0xca, 0xfb, 0xad, 0x42}
+			token := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+			handler.AddWithResetToken(connID, packetHandler, token)
+			// The stateless reset uses the connection ID of the session.
+			// It still needs to be detected, since this connection ID is random if the peer lost its state.
+			packet := append([]byte{0x40}, connID...)
+			packet = append(packet, make([]byte, 50)...)
+			packet = append(packet, token[:]...)
+			destroyed := make(chan struct{})
+			packetHandler.EXPECT().destroy(&StatelessResetError{Token: token}).Do(func(error) {
+				close(destroyed)
+			})
+			conn.dataToRead <- packet
+			Eventually(destroyed).Should(BeClosed())
+		})
+
+		It("ignores packets that are too short to be a stateless reset", func() {
+			packetHandler := NewMockPacketHandler(mockCtrl)
+			connID := protocol.ConnectionID{0xde, 0xca, 0xfb, 0xad, 0x42}
+			token := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+			handler.AddWithResetToken(connID, packetHandler, token)
+			packet := append([]byte{0x40}, connID...)
+			packet = append(packet, token[:]...)
+			Expect(len(packet)).To(BeNumerically("<", protocol.MinStatelessResetSize))
+			handled := make(chan struct{})
+			packetHandler.EXPECT().handlePacket(gomock.Any()).Do(func(*receivedPacket) { close(handled) })
+			conn.dataToRead <- packet
+			Eventually(handled).Should(BeClosed())
+		})
+
+		It("replaces the reset token when a connection is added again", func() {
+			connID := protocol.ConnectionID{0xde, 0xca, 0xfb, 0xad, 0x42}
+			token1 := [16]byte{1}
+			token2 := [16]byte{2}
+			packetHandler := NewMockPacketHandler(mockCtrl)
+			handler.AddWithResetToken(connID, packetHandler, token1)
+			handler.AddWithResetToken(connID, packetHandler, token2)
+			Expect(handler.resetTokens).To(HaveLen(1))
+			Expect(handler.resetTokens).To(HaveKey(token2))
+		})
+
 		It("deletes reset tokens when the session is retired", func() {
 			handler.deleteRetiredSessionsAfter = scaleDuration(10 * time.Millisecond)
 			connID := protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef, 0x42}
@@ -281,16 +333,138 @@ var _ = Describe("Packet Handler Map", func() {
 			handler.AddWithResetToken(connID, NewMockPacketHandler(mockCtrl), token)
 			handler.Retire(connID)
 			time.Sleep(scaleDuration(30 * time.Millisecond))
-			handler.handlePacket(nil, nil, getPacket(connID))
+			handler.handlePacket(nil, protocol.ECNNon, nil, getPacket(connID))
 			// don't EXPECT any calls to handlePacket of the MockPacketHandler
 			packet := append([]byte{0x40, 0xde, 0xca, 0xfb, 0xad, 0x99} /* short header packet */, make([]byte, 50)...)
 			packet = append(packet, token[:]...)
-			handler.handlePacket(nil, nil, packet)
+			handler.handlePacket(nil, protocol.ECNNon, nil, packet)
 			// don't EXPECT any calls to handlePacket of the MockPacketHandler
 			Expect(handler.resetTokens).To(BeEmpty())
 		})
 	})
 
+	Context("generating stateless reset tokens", func() {
+		It("derives the token from the stateless reset key and the connection ID", func() {
+			key := []byte("foobar")
+			h1 := newPacketHandlerMap(newMockPacketConn(), 5, key, utils.DefaultLogger)
+			h2 := newPacketHandlerMap(newMockPacketConn(), 5, key, utils.DefaultLogger)
+			h3 := newPacketHandlerMap(newMockPacketConn(), 5, []byte("raboof"), utils.DefaultLogger)
+			connID1 := protocol.ConnectionID{1, 2, 3, 4, 5}
+			connID2 := protocol.ConnectionID{5, 4, 3, 2, 1}
+			Expect(h1.GetStatelessResetToken(connID1)).To(Equal(h1.GetStatelessResetToken(connID1)))
+			Expect(h1.GetStatelessResetToken(connID1)).To(Equal(h2.GetStatelessResetToken(connID1)))
+			Expect(h1.GetStatelessResetToken(connID1)).ToNot(Equal(h1.GetStatelessResetToken(connID2)))
+			Expect(h1.GetStatelessResetToken(connID1)).ToNot(Equal(h3.GetStatelessResetToken(connID1)))
+		})
+
+		It("uses a random key if no stateless reset key is set", func() {
+			h := newPacketHandlerMap(newMockPacketConn(), 5, nil, utils.DefaultLogger)
+			connID := protocol.ConnectionID{1, 2, 3, 4, 5}
+			Expect(handler.GetStatelessResetToken(connID)).ToNot(Equal(h.GetStatelessResetToken(connID)))
+		})
+	})
+
+	Context("sending stateless resets", func() {
+		BeforeEach(func() {
+			handler = newPacketHandlerMap(conn, 5, []byte("foobar"), utils.DefaultLogger).(*packetHandlerMap)
+		})
+
+		It("sends a stateless reset for short header packets with an unknown connection ID", func() {
+			connID := protocol.ConnectionID{1, 2, 3, 4, 5}
+			packet := append([]byte{0x40}, connID...)
+			packet = append(packet, make([]byte, 100)...)
+			addr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+			handler.handlePacket(addr, protocol.ECNNon, nil, packet)
+			var write mockPacketConnWrite
+			Eventually(conn.dataWritten).Should(Receive(&write))
+			Expect(write.to).To(Equal(addr))
+			Expect(write.data).To(HaveLen(protocol.MinStatelessResetSize))
+			Expect(write.data[0] & 0xc0).To(Equal(byte(0x40)))
+			token := handler.GetStatelessResetToken(connID)
+			Expect(write.data[len(write.data)-16:]).To(Equal(token[:]))
+		})
+
+		It("doesn't send a stateless reset in response to small packets", func() {
+			connID := protocol.ConnectionID{1, 2, 3, 4, 5}
+			packet := append([]byte{0x40}, connID...)
+			packet = append(packet, make([]byte, protocol.MinStatelessResetSize-len(packet))...)
+			handler.handlePacket(&net.UDPAddr{}, protocol.ECNNon, nil, packet)
+			Consistently(conn.dataWritten).ShouldNot(Receive())
+		})
+
+		It("doesn't send stateless resets if no stateless reset key is set", func() {
+			handler = newPacketHandlerMap(conn, 5, nil, utils.DefaultLogger).(*packetHandlerMap)
+			packet := append([]byte{0x40, 1, 2, 3, 4, 5}, make([]byte, 100)...)
+			handler.handlePacket(&net.UDPAddr{}, protocol.ECNNon, nil, packet)
+			Consistently(conn.dataWritten).ShouldNot(Receive())
+		})
+	})
+
+	Context("handling non-QUIC packets", func() {
+		stunPacket := []byte{0x0, 0x1, 0x0, 0x0, 0x21, 0x12, 0xa4, 0x42} // the start of a STUN Binding Request
+
+		It("passes non-QUIC packets to the handler", func() {
+			type nonQUICPacket struct {
+				data []byte
+				addr net.Addr
+			}
+			received := make(chan nonQUICPacket, 1)
+			handler.SetNonQUICPacketHandler(func(data []byte, addr net.Addr) {
+				received <- nonQUICPacket{data: append([]byte{}, data...), addr: addr}
+			})
+			addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1234}
+			buffer := getPacketBuffer()
+			data := buffer.Slice[:len(stunPacket)]
+			copy(data, stunPacket)
+			handler.handlePacket(addr, protocol.ECNNon, buffer, data)
+			var p nonQUICPacket
+			Eventually(received).Should(Receive(&p))
+			Expect(p.data).To(Equal(stunPacket))
+			Expect(p.addr).To(Equal(addr))
+		})
+
+		It("doesn't pass QUIC packets to the handler", func() {
+			handler.SetNonQUICPacketHandler(func([]byte, net.Addr) { Fail("unexpected call to the non-QUIC packet handler") })
+			connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
+			packetHandler := NewMockPacketHandler(mockCtrl)
+			handled := make(chan struct{})
+			packetHandler.EXPECT().handlePacket(gomock.Any()).Do(func(*receivedPacket) { close(handled) })
+			handler.Add(connID, packetHandler)
+			handler.handlePacket(nil, protocol.ECNNon, getPacketBuffer(), getPacket(connID))
+			Eventually(handled).Should(BeClosed())
+			// packets for unknown connection IDs are QUIC packets as well
+			handler.handlePacket(nil, protocol.ECNNon, getPacketBuffer(), getPacket(protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1}))
+			Consistently(handled).Should(BeClosed())
+		})
+
+		It("drops non-QUIC packets if the handler doesn't keep up", func() {
+			called := make(chan struct{}, 1)
+			unblock := make(chan struct{})
+			var counter int32
+			handler.SetNonQUICPacketHandler(func([]byte, net.Addr) {
+				select {
+				case called <- struct{}{}:
+				default:
+				}
+				<-unblock
+				atomic.AddInt32(&counter, 1)
+			})
+			sendPacket := func() {
+				buffer := getPacketBuffer()
+				handler.handlePacket(nil, protocol.ECNNon, buffer, append(buffer.Slice[:0], stunPacket...))
+			}
+			// the first packet is dequeued by the handler, which then blocks
+			sendPacket()
+			Eventually(called).Should(Receive())
+			for i := 0; i < 2*protocol.MaxNonQUICPacketQueueLen; i++ {
+				sendPacket()
+			}
+			close(unblock)
+			Eventually(func() int32 { return atomic.LoadInt32(&counter) }).Should(BeEquivalentTo(protocol.MaxNonQUICPacketQueueLen + 1))
+			Consistently(func() int32 { return atomic.LoadInt32(&counter) }).Should(BeEquivalentTo(protocol.MaxNonQUICPacketQueueLen + 1))
+		})
+	})
+
 	Context("running a server", func() {
 		It("adds a server", func() {
 			connID := protocol.ConnectionID{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
@@ -300,7 +474,7 @@ var _ = Describe("Packet Handler Map", func() {
 				Expect(p.hdr.DestConnectionID).To(Equal(connID))
 			})
 			handler.SetServer(server)
-			handler.handlePacket(nil, nil, p)
+			handler.handlePacket(nil, protocol.ECNNon, nil, p)
 		})
 
 		It("closes all server sessions", func() {
@@ -322,7 +496,7 @@ var _ = Describe("Packet Handler Map", func() {
 			// don't EXPECT any calls to server.handlePacket
 			handler.SetServer(server)
 			handler.CloseServer()
-			handler.handlePacket(nil, nil, p)
+			handler.handlePacket(nil, protocol.ECNNon, nil, p)
 		})
 	})
 })
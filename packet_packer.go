@@ -19,6 +19,7 @@ type packer interface {
 	MaybePackAckPacket() (*packedPacket, error)
 	PackRetransmission(packet *ackhandler.Packet) ([]*packedPacket, error)
 	PackConnectionClose(*wire.ConnectionCloseFrame) (*packedPacket, error)
+	PackPathChallenge(*wire.PathChallengeFrame) (*packedPacket, error)
 
 	HandleTransportParameters(*handshake.TransportParameters)
 	ChangeDestConnectionID(protocol.ConnectionID)
@@ -46,14 +47,14 @@ func (p *packedPacket) EncryptionLevel() protocol.EncryptionLevel {
 	}
 }
 
-func (p *packedPacket) ToAckHandlerPacket() *ackhandler.Packet {
+func (p *packedPacket) ToAckHandlerPacket(now time.Time) *ackhandler.Packet {
 	return &ackhandler.Packet{
 		PacketNumber:    p.header.PacketNumber,
 		PacketType:      p.header.Type,
 		Frames:          p.frames,
 		Length:          protocol.ByteCount(len(p.raw)),
 		EncryptionLevel: p.EncryptionLevel(),
-		SendTime:        time.Now(),
+		SendTime:        now,
 	}
 }
 
@@ -106,9 +107,11 @@ type packetPacker struct {
 
 	token []byte
 
-	pnManager packetNumberManager
-	framer    frameSource
-	acks      ackFrameSource
+	pnManager     packetNumberManager
+	framer        frameSource
+	acks          ackFrameSource
+	datagramQueue *datagramQueue
+	spinBit       *spinBit
 
 	maxPacketSize             protocol.ByteCount
 	numNonRetransmittableAcks int
@@ -127,6 +130,8 @@ func newPacketPacker(
 	cryptoSetup sealingManager,
 	framer frameSource,
 	acks ackFrameSource,
+	datagramQueue *datagramQueue,
+	spinBit *spinBit,
 	perspective protocol.Perspective,
 	version protocol.VersionNumber,
 ) *packetPacker {
@@ -141,6 +146,8 @@ func newPacketPacker(
 		version:         version,
 		framer:          framer,
 		acks:            acks,
+		datagramQueue:   datagramQueue,
+		spinBit:         spinBit,
 		pnManager:       packetNumberManager,
 		maxPacketSize:   getMaxPacketSize(remoteAddr),
 	}
@@ -154,6 +161,14 @@ func (p *packetPacker) PackConnectionClose(ccf *wire.ConnectionCloseFrame) (*pac
 	return p.writeAndSealPacket(header, frames, sealer)
 }
 
+// PackPathChallenge packs a packet that ONLY contains a PathChallengeFrame
+func (p *packetPacker) PackPathChallenge(pcf *wire.PathChallengeFrame) (*packedPacket, error) {
+	frames := []wire.Frame{pcf}
+	encLevel, sealer := p.cryptoSetup.GetSealer()
+	header := p.getHeader(encLevel)
+	return p.writeAndSealPacket(header, frames, sealer)
+}
+
 func (p *packetPacker) MaybePackAckPacket() (*packedPacket, error) {
 	ack := p.acks.GetAckFrame(protocol.Encryption1RTT)
 	if ack == nil {
@@ -169,28 +184,19 @@ func (p *packetPacker) MaybePackAckPacket() (*packedPacket, error) {
 // PackRetransmission packs a retransmission
 // For packets sent after completion of the handshake, it might happen that 2 packets have to be sent.
 // This can happen e.g. when a longer packet number is used in the header.
+// Retransmissions never contain STREAM frames: lost stream data is retransmitted by the streams themselves.
+// CRYPTO frames are treated like all other frames here.
+// Since we're making sure that the header can never be larger for a retransmission,
+// we never have to split CRYPTO frames.
 func (p *packetPacker) PackRetransmission(packet *ackhandler.Packet) ([]*packedPacket, error) {
-	var controlFrames []wire.Frame
-	var streamFrames []*wire.StreamFrame
-	for _, f := range packet.Frames {
-		// CRYPTO frames are treated as control frames here.
-		// Since we're making sure that the header can never be larger for a retransmission,
-		// we never have to split CRYPTO frames.
-		if sf, ok := f.(*wire.StreamFrame); ok {
-			sf.DataLenPresent = true
-			streamFrames = append(streamFrames, sf)
-		} else {
-			controlFrames = append(controlFrames, f)
-		}
-	}
-
 	var packets []*packedPacket
 	encLevel := packet.EncryptionLevel
 	sealer, err := p.cryptoSetup.GetSealerWithEncryptionLevel(encLevel)
 	if err != nil {
 		return nil, err
 	}
-	for len(controlFrames) > 0 || len(streamFrames) > 0 {
+	controlFrames := packet.Frames
+	for len(controlFrames) > 0 {
 		var frames []wire.Frame
 		var length protocol.ByteCount
 
@@ -208,27 +214,8 @@ func (p *packetPacker) PackRetransmission(packet *ackhandler.Packet) ([]*packedP
 			frames = append(frames, frame)
 			controlFrames = controlFrames[1:]
 		}
-
-		for len(streamFrames) > 0 && length+protocol.MinStreamFrameSize < maxSize {
-			frame := streamFrames[0]
-			frame.DataLenPresent = false
-			frameToAdd := frame
-
-			sf, err := frame.MaybeSplitOffFrame(maxSize-length, p.version)
-			if err != nil {
-				return nil, err
-			}
-			if sf != nil {
-				frameToAdd = sf
-			} else {
-				streamFrames = streamFrames[1:]
-			}
-			frame.DataLenPresent = true
-			length += frameToAdd.Length(p.version)
-			frames = append(frames, frameToAdd)
-		}
-		if sf, ok := frames[len(frames)-1].(*wire.StreamFrame); ok {
-			sf.DataLenPresent = false
+		if len(frames) == 0 {
+			return nil, fmt.Errorf("frame too large for retransmission: %d bytes, maximum %d", controlFrames[0].Length(p.version), maxSize)
 		}
 		p, err := p.writeAndSealPacket(header, frames, sealer)
 		if err != nil {
@@ -337,20 +324,37 @@ func (p *packetPacker) composeNextPacket(maxFrameSize protocol.ByteCount) ([]wir
 	frames, lengthAdded = p.framer.AppendControlFrames(frames, maxFrameSize-length)
 	length += lengthAdded
 
-	// temporarily increase the maxFrameSize by the (minimum) length of the DataLen field
+	// DATAGRAM frames can't be split.
+	// If it doesn't fit into this packet, it is sent in the next one.
+	if f := p.datagramQueue.Peek(); f != nil {
+		if frameLen := f.Length(p.version); length+frameLen <= maxFrameSize {
+			frames = append(frames, f)
+			length += frameLen
+			p.datagramQueue.Pop()
+		}
+	}
+
+	// increase the space for STREAM frames by the (minimum) length of the DataLen field
 	// this leads to a properly sized packet in all cases, since we do all the packet length calculations with STREAM frames that have the DataLen set
 	// however, for the last STREAM frame in the packet, we can omit the DataLen, thus yielding a packet of exactly the correct size
 	// the length is encoded to either 1 or 2 bytes
-	maxFrameSize++
-
-	frames = p.framer.AppendStreamFrames(frames, maxFrameSize-length)
-	if len(frames) > 0 {
-		lastFrame := frames[len(frames)-1]
-		if sf, ok := lastFrame.(*wire.StreamFrame); ok {
-			sf.DataLenPresent = false
-		}
+	streamFrames := p.framer.AppendStreamFrames(nil, maxFrameSize+1-length)
+	if len(streamFrames) == 0 {
+		return frames, nil
+	}
+	// Popping STREAM frames might have queued new control frames (e.g. STREAM_DATA_BLOCKED frames).
+	// Add them to this packet, if there's space left.
+	var streamLength protocol.ByteCount
+	for _, f := range streamFrames {
+		streamLength += f.Length(p.version)
 	}
-	return frames, nil
+	if length+streamLength < maxFrameSize {
+		frames, _ = p.framer.AppendControlFrames(frames, maxFrameSize-length-streamLength)
+	}
+	if sf, ok := streamFrames[len(streamFrames)-1].(*wire.StreamFrame); ok {
+		sf.DataLenPresent = false
+	}
+	return append(frames, streamFrames...), nil
 }
 
 func (p *packetPacker) getHeader(encLevel protocol.EncryptionLevel) *wire.ExtendedHeader {
@@ -377,6 +381,8 @@ func (p *packetPacker) getHeader(encLevel protocol.EncryptionLevel) *wire.Extend
 		case protocol.EncryptionHandshake:
 			header.Type = protocol.PacketTypeHandshake
 		}
+	} else {
+		header.SpinBit = p.spinBit.Value()
 	}
 
 	return header
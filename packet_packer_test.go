@@ -11,6 +11,7 @@ import (
 	"github.com/lucas-clemente/quic-go/internal/mocks"
 	mockackhandler "github.com/lucas-clemente/quic-go/internal/mocks/ackhandler"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -26,6 +27,7 @@ var _ = Describe("Packet packer", func() {
 		handshakeStream *MockCryptoStream
 		sealingManager  *MockSealingManager
 		pnManager       *mockackhandler.MockSentPacketHandler
+		datagramQueue   *datagramQueue
 		token           []byte
 	)
 
@@ -42,6 +44,12 @@ var _ = Describe("Packet packer", func() {
 		framer.EXPECT().AppendStreamFrames(gomock.Any(), gomock.Any()).DoAndReturn(func(fs []wire.Frame, _ protocol.ByteCount) []wire.Frame {
 			return append(fs, frames...)
 		})
+		if len(frames) > 0 {
+			// after popping STREAM frames, the packer checks for newly queued control frames
+			framer.EXPECT().AppendControlFrames(gomock.Any(), gomock.Any()).DoAndReturn(func(fs []wire.Frame, _ protocol.ByteCount) ([]wire.Frame, protocol.ByteCount) {
+				return fs, 0
+			}).MaxTimes(1)
+		}
 	}
 
 	expectAppendControlFrames := func(frames ...wire.Frame) {
@@ -66,6 +74,7 @@ var _ = Describe("Packet packer", func() {
 		ackFramer = NewMockAckFrameSource(mockCtrl)
 		sealingManager = NewMockSealingManager(mockCtrl)
 		pnManager = mockackhandler.NewMockSentPacketHandler(mockCtrl)
+		datagramQueue = newDatagramQueue(func() {}, utils.DefaultLogger)
 
 		packer = newPacketPacker(
 			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
@@ -78,6 +87,8 @@ var _ = Describe("Packet packer", func() {
 			sealingManager,
 			framer,
 			ackFramer,
+			datagramQueue,
+			newSpinBit(protocol.PerspectiveServer, false),
 			protocol.PerspectiveServer,
 			version,
 		)
@@ -147,6 +158,15 @@ var _ = Describe("Packet packer", func() {
 			Expect(h.PacketNumber).To(Equal(protocol.PacketNumber(0x1337)))
 			Expect(h.PacketNumberLen).To(Equal(protocol.PacketNumberLen4))
 		})
+
+		It("sets the spin bit on 1-RTT packets only", func() {
+			pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2).Times(3)
+			packer.spinBit.value = true
+			Expect(packer.getHeader(protocol.Encryption1RTT).SpinBit).To(BeTrue())
+			Expect(packer.getHeader(protocol.EncryptionHandshake).SpinBit).To(BeFalse())
+			packer.spinBit.value = false
+			Expect(packer.getHeader(protocol.Encryption1RTT).SpinBit).To(BeFalse())
+		})
 	})
 
 	Context("encrypting packets", func() {
@@ -245,6 +265,27 @@ var _ = Describe("Packet packer", func() {
 				Expect(p.raw).To(ContainSubstring(b.String()))
 			})
 
+			It("adds control frames that were queued while packing STREAM frames", func() {
+				pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2)
+				pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42))
+				sealingManager.EXPECT().GetSealer().Return(protocol.Encryption1RTT, sealer)
+				ackFramer.EXPECT().GetAckFrame(protocol.Encryption1RTT)
+				f := &wire.StreamFrame{StreamID: 5, Data: []byte("foobar"), DataLenPresent: true}
+				blocked := &wire.StreamDataBlockedFrame{StreamID: 5, DataLimit: 6}
+				gomock.InOrder(
+					framer.EXPECT().AppendControlFrames(gomock.Any(), gomock.Any()),
+					framer.EXPECT().AppendStreamFrames(gomock.Any(), gomock.Any()).Return([]wire.Frame{f}),
+					framer.EXPECT().AppendControlFrames(gomock.Any(), gomock.Any()).DoAndReturn(func(fs []wire.Frame, _ protocol.ByteCount) ([]wire.Frame, protocol.ByteCount) {
+						return append(fs, blocked), blocked.Length(packer.version)
+					}),
+				)
+				p, err := packer.PackPacket()
+				Expect(err).ToNot(HaveOccurred())
+				// the STREAM frame is the last frame in the packet, so it doesn't need a length
+				Expect(p.frames).To(Equal([]wire.Frame{blocked, f}))
+				Expect(f.DataLenPresent).To(BeFalse())
+			})
+
 			It("stores the encryption level a packet was sealed with", func() {
 				pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2)
 				pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42))
@@ -289,6 +330,53 @@ var _ = Describe("Packet packer", func() {
 				Expect(p.frames[0]).To(Equal(&ccf))
 			})
 
+			It("packs a PATH_CHALLENGE", func() {
+				pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2)
+				pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42))
+				// expect no framer.PopStreamFrames
+				pcf := &wire.PathChallengeFrame{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+				sealingManager.EXPECT().GetSealer().Return(protocol.Encryption1RTT, sealer)
+				p, err := packer.PackPathChallenge(pcf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(p.frames).To(Equal([]wire.Frame{pcf}))
+			})
+
+			It("packs DATAGRAM frames", func() {
+				pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2)
+				pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42))
+				sealingManager.EXPECT().GetSealer().Return(protocol.Encryption1RTT, sealer)
+				ackFramer.EXPECT().GetAckFrame(protocol.Encryption1RTT)
+				f := &wire.DatagramFrame{DataLenPresent: true, Data: []byte("foobar")}
+				Expect(datagramQueue.AddAndWait(f)).To(Succeed())
+				expectAppendControlFrames()
+				expectAppendStreamFrames()
+				p, err := packer.PackPacket()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(p).ToNot(BeNil())
+				Expect(p.frames).To(Equal([]wire.Frame{f}))
+				Expect(datagramQueue.Peek()).To(BeNil())
+			})
+
+			It("sends a DATAGRAM frame in the next packet, if it doesn't fit", func() {
+				pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2).Times(2)
+				pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42)).Times(2)
+				sealingManager.EXPECT().GetSealer().Return(protocol.Encryption1RTT, sealer).Times(2)
+				ackFramer.EXPECT().GetAckFrame(protocol.Encryption1RTT).Times(2)
+				f := &wire.DatagramFrame{DataLenPresent: true, Data: make([]byte, 1000)}
+				Expect(datagramQueue.AddAndWait(f)).To(Succeed())
+				cf := &wire.NewTokenFrame{Token: make([]byte, 500)}
+				expectAppendControlFrames(cf)
+				expectAppendStreamFrames()
+				p, err := packer.PackPacket()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(p.frames).To(Equal([]wire.Frame{cf}))
+				expectAppendControlFrames()
+				expectAppendStreamFrames()
+				p, err = packer.PackPacket()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(p.frames).To(Equal([]wire.Frame{f}))
+			})
+
 			It("packs control frames", func() {
 				pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2)
 				pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42))
@@ -489,7 +577,7 @@ var _ = Describe("Packet packer", func() {
 					sealingManager.EXPECT().GetSealerWithEncryptionLevel(protocol.Encryption1RTT).Return(sealer, nil)
 					frames := []wire.Frame{
 						&wire.MaxDataFrame{ByteOffset: 0x1234},
-						&wire.StreamFrame{StreamID: 42, Data: []byte("foobar")},
+						&wire.ResetStreamFrame{StreamID: 42, ByteOffset: 0x1337},
 					}
 					packets, err := packer.PackRetransmission(&ackhandler.Packet{
 						EncryptionLevel: protocol.Encryption1RTT,
@@ -530,124 +618,14 @@ var _ = Describe("Packet packer", func() {
 					Expect(len(packets[0].raw) + int(packets[1].frames[1].Length(packer.version))).To(BeNumerically(">", maxPacketSize))
 				})
 
-				It("splits a STREAM frame that doesn't fit", func() {
-					pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2).Times(2)
-					pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42)).Times(2)
-					sealingManager.EXPECT().GetSealerWithEncryptionLevel(protocol.Encryption1RTT).Return(sealer, nil)
-					packets, err := packer.PackRetransmission(&ackhandler.Packet{
-						EncryptionLevel: protocol.Encryption1RTT,
-						Frames: []wire.Frame{&wire.StreamFrame{
-							StreamID: 42,
-							Offset:   1337,
-							Data:     bytes.Repeat([]byte{'a'}, int(maxPacketSize)*3/2),
-						}},
-					})
-					Expect(err).ToNot(HaveOccurred())
-					Expect(packets).To(HaveLen(2))
-					Expect(packets[0].frames[0]).To(BeAssignableToTypeOf(&wire.StreamFrame{}))
-					Expect(packets[1].frames[0]).To(BeAssignableToTypeOf(&wire.StreamFrame{}))
-					sf1 := packets[0].frames[0].(*wire.StreamFrame)
-					sf2 := packets[1].frames[0].(*wire.StreamFrame)
-					Expect(sf1.StreamID).To(Equal(protocol.StreamID(42)))
-					Expect(sf1.Offset).To(Equal(protocol.ByteCount(1337)))
-					Expect(sf1.DataLenPresent).To(BeFalse())
-					Expect(sf2.StreamID).To(Equal(protocol.StreamID(42)))
-					Expect(sf2.Offset).To(Equal(protocol.ByteCount(1337) + sf1.DataLen()))
-					Expect(sf2.DataLenPresent).To(BeFalse())
-					Expect(sf1.DataLen() + sf2.DataLen()).To(Equal(maxPacketSize * 3 / 2))
-					Expect(packets[0].raw).To(HaveLen(int(maxPacketSize)))
-				})
-
-				It("splits STREAM frames, if necessary", func() {
-					pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2).AnyTimes()
-					pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42)).AnyTimes()
-					for i := 0; i < 100; i++ {
-						sealingManager.EXPECT().GetSealerWithEncryptionLevel(protocol.Encryption1RTT).Return(sealer, nil).MaxTimes(2)
-						sf1 := &wire.StreamFrame{
-							StreamID: 42,
-							Offset:   1337,
-							Data:     bytes.Repeat([]byte{'a'}, 1+int(rand.Int31n(int32(maxPacketSize*4/5)))),
-						}
-						sf2 := &wire.StreamFrame{
-							StreamID: 2,
-							Offset:   42,
-							Data:     bytes.Repeat([]byte{'b'}, 1+int(rand.Int31n(int32(maxPacketSize*4/5)))),
-						}
-						expectedDataLen := sf1.DataLen() + sf2.DataLen()
-						frames := []wire.Frame{sf1, sf2}
-						packets, err := packer.PackRetransmission(&ackhandler.Packet{
-							EncryptionLevel: protocol.Encryption1RTT,
-							Frames:          frames,
-						})
-						Expect(err).ToNot(HaveOccurred())
-
-						if len(packets) > 1 {
-							Expect(packets[0].raw).To(HaveLen(int(maxPacketSize)))
-						}
-
-						var dataLen protocol.ByteCount
-						for _, p := range packets {
-							for _, f := range p.frames {
-								dataLen += f.(*wire.StreamFrame).DataLen()
-							}
-						}
-						Expect(dataLen).To(Equal(expectedDataLen))
-					}
-				})
-
-				It("packs two packets for retransmission if the original packet contained many STREAM frames", func() {
-					pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2).Times(2)
-					pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42)).Times(2)
-					sealingManager.EXPECT().GetSealerWithEncryptionLevel(protocol.Encryption1RTT).Return(sealer, nil)
-					var frames []wire.Frame
-					var totalLen protocol.ByteCount
-					// pack a bunch of control frames, such that the packet is way bigger than a single packet
-					for i := 0; totalLen < maxPacketSize*3/2; i++ {
-						f := &wire.StreamFrame{
-							StreamID:       protocol.StreamID(i),
-							Data:           []byte("foobar"),
-							DataLenPresent: true,
-						}
-						frames = append(frames, f)
-						totalLen += f.Length(packer.version)
-					}
-					packets, err := packer.PackRetransmission(&ackhandler.Packet{
-						EncryptionLevel: protocol.Encryption1RTT,
-						Frames:          frames,
-					})
-					Expect(err).ToNot(HaveOccurred())
-					Expect(packets).To(HaveLen(2))
-					Expect(len(packets[0].frames) + len(packets[1].frames)).To(Equal(len(frames))) // all frames
-					Expect(packets[1].frames).To(Equal(frames[len(packets[0].frames):]))
-					// check that the first packet was filled up as far as possible:
-					// if the first frame was packed into the first packet, it would have overflown the MaxPacketSize
-					Expect(len(packets[0].raw) + int(packets[1].frames[1].Length(packer.version))).To(BeNumerically(">", maxPacketSize-protocol.MinStreamFrameSize))
-				})
-
-				It("correctly sets the DataLenPresent on STREAM frames", func() {
+				It("errors if a frame doesn't fit into a packet", func() {
 					pnManager.EXPECT().PeekPacketNumber().Return(protocol.PacketNumber(0x42), protocol.PacketNumberLen2)
-					pnManager.EXPECT().PopPacketNumber().Return(protocol.PacketNumber(0x42))
 					sealingManager.EXPECT().GetSealerWithEncryptionLevel(protocol.Encryption1RTT).Return(sealer, nil)
-					frames := []wire.Frame{
-						&wire.StreamFrame{StreamID: 4, Data: []byte("foobar"), DataLenPresent: true},
-						&wire.StreamFrame{StreamID: 5, Data: []byte("barfoo")},
-					}
-					packets, err := packer.PackRetransmission(&ackhandler.Packet{
+					_, err := packer.PackRetransmission(&ackhandler.Packet{
 						EncryptionLevel: protocol.Encryption1RTT,
-						Frames:          frames,
+						Frames:          []wire.Frame{&wire.NewTokenFrame{Token: make([]byte, maxPacketSize)}},
 					})
-					Expect(err).ToNot(HaveOccurred())
-					Expect(packets).To(HaveLen(1))
-					p := packets[0]
-					Expect(p.frames).To(HaveLen(2))
-					Expect(p.frames[0]).To(BeAssignableToTypeOf(&wire.StreamFrame{}))
-					Expect(p.frames[1]).To(BeAssignableToTypeOf(&wire.StreamFrame{}))
-					sf1 := p.frames[0].(*wire.StreamFrame)
-					sf2 := p.frames[1].(*wire.StreamFrame)
-					Expect(sf1.StreamID).To(Equal(protocol.StreamID(4)))
-					Expect(sf1.DataLenPresent).To(BeTrue())
-					Expect(sf2.StreamID).To(Equal(protocol.StreamID(5)))
-					Expect(sf2.DataLenPresent).To(BeFalse())
+					Expect(err).To(MatchError(ContainSubstring("frame too large for retransmission")))
 				})
 			})
 
@@ -803,7 +781,7 @@ var _ = Describe("Packet packer", func() {
 				ackFramer.EXPECT().GetAckFrame(protocol.Encryption1RTT)
 				initialStream.EXPECT().HasData()
 				handshakeStream.EXPECT().HasData()
-				framer.EXPECT().AppendControlFrames(gomock.Any(), gomock.Any())
+				framer.EXPECT().AppendControlFrames(gomock.Any(), gomock.Any()).Times(2)
 				framer.EXPECT().AppendStreamFrames(gomock.Any(), gomock.Any()).Return([]wire.Frame{f})
 				packet, err := packer.PackPacket()
 				Expect(err).ToNot(HaveOccurred())
@@ -14,7 +14,8 @@ type unpackedPacket struct {
 	packetNumber    protocol.PacketNumber // the decoded packet number
 	hdr             *wire.ExtendedHeader
 	encryptionLevel protocol.EncryptionLevel
-	data            []byte
+	// The payload is decrypted in place, so data is a slice of the packet buffer.
+	data []byte
 }
 
 // The packetUnpacker unpacks QUIC packets.
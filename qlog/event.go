@@ -0,0 +1,215 @@
+package qlog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/logging"
+)
+
+type topLevel struct {
+	QlogVersion string `json:"qlog_version"`
+	QlogFormat  string `json:"qlog_format"`
+	Title       string `json:"title"`
+	Trace       trace  `json:"trace"`
+}
+
+type trace struct {
+	VantagePoint vantagePointInfo `json:"vantage_point"`
+	CommonFields commonFields     `json:"common_fields"`
+}
+
+type vantagePointInfo struct {
+	Type string `json:"type"`
+}
+
+type commonFields struct {
+	ODCID         connectionID `json:"ODCID"`
+	ReferenceTime float64      `json:"reference_time"`
+	TimeFormat    string       `json:"time_format"`
+}
+
+type connectionID protocol.ConnectionID
+
+func (c connectionID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(c))
+}
+
+// milliseconds converts a duration to (fractional) milliseconds, as used by qlog
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1e6
+}
+
+type eventDetails interface {
+	Category() string
+	Name() string
+}
+
+type event struct {
+	RelativeTime time.Duration
+	eventDetails
+}
+
+func (e event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Time float64      `json:"time"`
+		Name string       `json:"name"`
+		Data eventDetails `json:"data"`
+	}{
+		Time: milliseconds(e.RelativeTime),
+		Name: e.Category() + ":" + e.Name(),
+		Data: e.eventDetails,
+	})
+}
+
+type packetHeader struct {
+	PacketNumber logging.PacketNumber `json:"packet_number"`
+	PacketSize   logging.ByteCount    `json:"packet_size"`
+	DestConnID   connectionID         `json:"dcid"`
+	SrcConnID    connectionID         `json:"scid,omitempty"`
+	Version      string               `json:"version,omitempty"`
+}
+
+func transformHeader(hdr *logging.ExtendedHeader, size logging.ByteCount) packetHeader {
+	h := packetHeader{
+		PacketNumber: hdr.PacketNumber,
+		PacketSize:   size,
+		DestConnID:   connectionID(hdr.DestConnectionID),
+	}
+	if hdr.IsLongHeader {
+		h.SrcConnID = connectionID(hdr.SrcConnectionID)
+		h.Version = hdr.Version.String()
+	}
+	return h
+}
+
+func getPacketType(hdr *logging.ExtendedHeader) string {
+	if !hdr.IsLongHeader {
+		return "1RTT"
+	}
+	switch hdr.Type {
+	case protocol.PacketTypeInitial:
+		return "initial"
+	case protocol.PacketTypeHandshake:
+		return "handshake"
+	case protocol.PacketType0RTT:
+		return "0RTT"
+	case protocol.PacketTypeRetry:
+		return "retry"
+	default:
+		return "unknown"
+	}
+}
+
+func getPacketTypeFromEncryptionLevel(encLevel logging.EncryptionLevel) string {
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		return "initial"
+	case protocol.EncryptionHandshake:
+		return "handshake"
+	case protocol.Encryption1RTT:
+		return "1RTT"
+	default:
+		return "unknown"
+	}
+}
+
+func packetDropReason(reason logging.PacketDropReason) string {
+	switch reason {
+	case logging.PacketDropUnexpectedSourceConnectionID:
+		return "unexpected_source_connection_id"
+	case logging.PacketDropUnexpectedPacket:
+		return "unexpected_packet"
+	case logging.PacketDropKeyUnavailable:
+		return "key_unavailable"
+	case logging.PacketDropPayloadDecryptError:
+		return "payload_decrypt_error"
+	case logging.PacketDropDOSPrevention:
+		return "dos_prevention"
+	case logging.PacketDropBufferFull:
+		return "buffer_full"
+	default:
+		return "unknown"
+	}
+}
+
+func packetLossReason(reason logging.PacketLossReason) string {
+	switch reason {
+	case logging.PacketLossTimeThreshold:
+		return "time_threshold"
+	default:
+		return "unknown"
+	}
+}
+
+type eventPacketSent struct {
+	PacketType string       `json:"packet_type"`
+	Header     packetHeader `json:"header"`
+	Frames     []frame      `json:"frames"`
+}
+
+func (e eventPacketSent) Category() string { return "transport" }
+func (e eventPacketSent) Name() string     { return "packet_sent" }
+
+type eventPacketReceived struct {
+	PacketType string       `json:"packet_type"`
+	Header     packetHeader `json:"header"`
+	Frames     []frame      `json:"frames"`
+}
+
+func (e eventPacketReceived) Category() string { return "transport" }
+func (e eventPacketReceived) Name() string     { return "packet_received" }
+
+type eventPacketDropped struct {
+	PacketSize logging.ByteCount `json:"packet_size"`
+	Trigger    string            `json:"trigger"`
+}
+
+func (e eventPacketDropped) Category() string { return "transport" }
+func (e eventPacketDropped) Name() string     { return "packet_dropped" }
+
+type eventPacketLost struct {
+	PacketType   string               `json:"packet_type"`
+	PacketNumber logging.PacketNumber `json:"packet_number"`
+	Trigger      string               `json:"trigger"`
+}
+
+func (e eventPacketLost) Category() string { return "recovery" }
+func (e eventPacketLost) Name() string     { return "packet_lost" }
+
+type eventRTTUpdated struct {
+	MinRTT      float64 `json:"min_rtt"`
+	SmoothedRTT float64 `json:"smoothed_rtt"`
+	LatestRTT   float64 `json:"latest_rtt"`
+	RTTVariance float64 `json:"rtt_variance"`
+}
+
+func (e eventRTTUpdated) Category() string { return "recovery" }
+func (e eventRTTUpdated) Name() string     { return "metrics_updated" }
+
+type eventCongestionWindowUpdated struct {
+	CongestionWindow logging.ByteCount `json:"congestion_window"`
+	BytesInFlight    logging.ByteCount `json:"bytes_in_flight"`
+}
+
+func (e eventCongestionWindowUpdated) Category() string { return "recovery" }
+func (e eventCongestionWindowUpdated) Name() string     { return "metrics_updated" }
+
+type eventStreamFlowControlUpdated struct {
+	StreamID logging.StreamID  `json:"stream_id"`
+	Owner    string            `json:"owner"`
+	Limit    logging.ByteCount `json:"limit"`
+}
+
+func (e eventStreamFlowControlUpdated) Category() string { return "transport" }
+func (e eventStreamFlowControlUpdated) Name() string     { return "stream_flow_control_updated" }
+
+type eventConnectionClosed struct {
+	New    string `json:"new"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (e eventConnectionClosed) Category() string { return "connectivity" }
+func (e eventConnectionClosed) Name() string     { return "connection_state_updated" }
@@ -0,0 +1,224 @@
+package qlog
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+	"github.com/lucas-clemente/quic-go/logging"
+)
+
+// A frame is the qlog representation of a frame.
+// Frames must not be used after the tracer returns (and STREAM and ACK frames are reused),
+// so they're transformed before the event is queued.
+type frame interface{}
+
+type simpleFrame struct {
+	FrameType string `json:"frame_type"`
+}
+
+type ackFrame struct {
+	FrameType   string                    `json:"frame_type"`
+	AckDelay    float64                   `json:"ack_delay"`
+	AckedRanges [][2]logging.PacketNumber `json:"acked_ranges"`
+	ECT0        uint64                    `json:"ect0,omitempty"`
+	ECT1        uint64                    `json:"ect1,omitempty"`
+	CE          uint64                    `json:"ce,omitempty"`
+}
+
+type streamFrame struct {
+	FrameType string            `json:"frame_type"`
+	StreamID  protocol.StreamID `json:"stream_id"`
+	Offset    logging.ByteCount `json:"offset"`
+	Length    logging.ByteCount `json:"length"`
+	Fin       bool              `json:"fin,omitempty"`
+}
+
+type cryptoFrame struct {
+	FrameType string            `json:"frame_type"`
+	Offset    logging.ByteCount `json:"offset"`
+	Length    logging.ByteCount `json:"length"`
+}
+
+type resetStreamFrame struct {
+	FrameType string                        `json:"frame_type"`
+	StreamID  protocol.StreamID             `json:"stream_id"`
+	ErrorCode protocol.ApplicationErrorCode `json:"error_code"`
+	FinalSize logging.ByteCount             `json:"final_size"`
+}
+
+type stopSendingFrame struct {
+	FrameType string                        `json:"frame_type"`
+	StreamID  protocol.StreamID             `json:"stream_id"`
+	ErrorCode protocol.ApplicationErrorCode `json:"error_code"`
+}
+
+// maxDataFrame is used for MAX_DATA and MAX_STREAM_DATA frames
+type maxDataFrame struct {
+	FrameType string             `json:"frame_type"`
+	StreamID  *protocol.StreamID `json:"stream_id,omitempty"`
+	Maximum   logging.ByteCount  `json:"maximum"`
+}
+
+// dataBlockedFrame is used for DATA_BLOCKED and STREAM_DATA_BLOCKED frames
+type dataBlockedFrame struct {
+	FrameType string             `json:"frame_type"`
+	StreamID  *protocol.StreamID `json:"stream_id,omitempty"`
+	Limit     logging.ByteCount  `json:"limit"`
+}
+
+type maxStreamsFrame struct {
+	FrameType  string `json:"frame_type"`
+	StreamType string `json:"stream_type"`
+	Maximum    uint64 `json:"maximum"`
+}
+
+type streamsBlockedFrame struct {
+	FrameType  string `json:"frame_type"`
+	StreamType string `json:"stream_type"`
+	Limit      uint64 `json:"limit"`
+}
+
+type newConnectionIDFrame struct {
+	FrameType           string       `json:"frame_type"`
+	SequenceNumber      uint64       `json:"sequence_number"`
+	Length              int          `json:"length"`
+	ConnectionID        connectionID `json:"connection_id"`
+	StatelessResetToken string       `json:"stateless_reset_token"`
+}
+
+type retireConnectionIDFrame struct {
+	FrameType      string `json:"frame_type"`
+	SequenceNumber uint64 `json:"sequence_number"`
+}
+
+type pathFrame struct {
+	FrameType string `json:"frame_type"`
+	Data      string `json:"data"`
+}
+
+type newTokenFrame struct {
+	FrameType string `json:"frame_type"`
+	Length    int    `json:"length"`
+	Token     string `json:"token"`
+}
+
+type connectionCloseFrame struct {
+	FrameType  string `json:"frame_type"`
+	ErrorSpace string `json:"error_space"`
+	ErrorCode  uint16 `json:"error_code"`
+	Reason     string `json:"reason"`
+}
+
+type datagramFrame struct {
+	FrameType string `json:"frame_type"`
+	Length    int    `json:"length"`
+}
+
+func transformFrames(frames []logging.Frame) []frame {
+	fs := make([]frame, len(frames))
+	for i, f := range frames {
+		fs[i] = transformFrame(f)
+	}
+	return fs
+}
+
+func transformFrame(f logging.Frame) frame {
+	switch f := f.(type) {
+	case *wire.PingFrame:
+		return &simpleFrame{FrameType: "ping"}
+	case *wire.AckFrame:
+		ranges := make([][2]logging.PacketNumber, len(f.AckRanges))
+		for i, r := range f.AckRanges {
+			ranges[i] = [2]logging.PacketNumber{r.Smallest, r.Largest}
+		}
+		return &ackFrame{
+			FrameType:   "ack",
+			AckDelay:    milliseconds(f.DelayTime),
+			AckedRanges: ranges,
+			ECT0:        f.ECT0,
+			ECT1:        f.ECT1,
+			CE:          f.ECNCE,
+		}
+	case *wire.StreamFrame:
+		return &streamFrame{
+			FrameType: "stream",
+			StreamID:  f.StreamID,
+			Offset:    f.Offset,
+			Length:    protocol.ByteCount(len(f.Data)),
+			Fin:       f.FinBit,
+		}
+	case *wire.CryptoFrame:
+		return &cryptoFrame{
+			FrameType: "crypto",
+			Offset:    f.Offset,
+			Length:    protocol.ByteCount(len(f.Data)),
+		}
+	case *wire.ResetStreamFrame:
+		return &resetStreamFrame{
+			FrameType: "reset_stream",
+			StreamID:  f.StreamID,
+			ErrorCode: f.ErrorCode,
+			FinalSize: f.ByteOffset,
+		}
+	case *wire.StopSendingFrame:
+		return &stopSendingFrame{
+			FrameType: "stop_sending",
+			StreamID:  f.StreamID,
+			ErrorCode: f.ErrorCode,
+		}
+	case *wire.MaxDataFrame:
+		return &maxDataFrame{FrameType: "max_data", Maximum: f.ByteOffset}
+	case *wire.MaxStreamDataFrame:
+		streamID := f.StreamID
+		return &maxDataFrame{FrameType: "max_stream_data", StreamID: &streamID, Maximum: f.ByteOffset}
+	case *wire.DataBlockedFrame:
+		return &dataBlockedFrame{FrameType: "data_blocked", Limit: f.DataLimit}
+	case *wire.StreamDataBlockedFrame:
+		streamID := f.StreamID
+		return &dataBlockedFrame{FrameType: "stream_data_blocked", StreamID: &streamID, Limit: f.DataLimit}
+	case *wire.MaxStreamsFrame:
+		return &maxStreamsFrame{FrameType: "max_streams", StreamType: streamType(f.Type), Maximum: f.MaxStreams}
+	case *wire.StreamsBlockedFrame:
+		return &streamsBlockedFrame{FrameType: "streams_blocked", StreamType: streamType(f.Type), Limit: f.StreamLimit}
+	case *wire.NewConnectionIDFrame:
+		return &newConnectionIDFrame{
+			FrameType:           "new_connection_id",
+			SequenceNumber:      f.SequenceNumber,
+			Length:              f.ConnectionID.Len(),
+			ConnectionID:        connectionID(append(protocol.ConnectionID{}, f.ConnectionID...)),
+			StatelessResetToken: hex.EncodeToString(f.StatelessResetToken[:]),
+		}
+	case *wire.RetireConnectionIDFrame:
+		return &retireConnectionIDFrame{FrameType: "retire_connection_id", SequenceNumber: f.SequenceNumber}
+	case *wire.PathChallengeFrame:
+		return &pathFrame{FrameType: "path_challenge", Data: hex.EncodeToString(f.Data[:])}
+	case *wire.PathResponseFrame:
+		return &pathFrame{FrameType: "path_response", Data: hex.EncodeToString(f.Data[:])}
+	case *wire.NewTokenFrame:
+		return &newTokenFrame{FrameType: "new_token", Length: len(f.Token), Token: hex.EncodeToString(f.Token)}
+	case *wire.ConnectionCloseFrame:
+		errorSpace := "transport"
+		if f.IsApplicationError {
+			errorSpace = "application"
+		}
+		return &connectionCloseFrame{
+			FrameType:  "connection_close",
+			ErrorSpace: errorSpace,
+			ErrorCode:  uint16(f.ErrorCode),
+			Reason:     f.ReasonPhrase,
+		}
+	case *wire.DatagramFrame:
+		return &datagramFrame{FrameType: "datagram", Length: len(f.Data)}
+	default:
+		return &simpleFrame{FrameType: fmt.Sprintf("unknown (%T)", f)}
+	}
+}
+
+func streamType(t protocol.StreamType) string {
+	if t == protocol.StreamTypeUni {
+		return "unidirectional"
+	}
+	return "bidirectional"
+}
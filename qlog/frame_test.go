@@ -0,0 +1,125 @@
+package qlog
+
+import (
+	"encoding/json"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+	"github.com/lucas-clemente/quic-go/logging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Frames", func() {
+	check := func(f logging.Frame, expected map[string]interface{}) {
+		data, err := json.Marshal(transformFrame(f))
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		m := make(map[string]interface{})
+		ExpectWithOffset(1, json.Unmarshal(data, &m)).To(Succeed())
+		ExpectWithOffset(1, m).To(Equal(expected))
+	}
+
+	It("marshals PING frames", func() {
+		check(&wire.PingFrame{}, map[string]interface{}{"frame_type": "ping"})
+	})
+
+	It("marshals ACK frames with ECN counts", func() {
+		check(
+			&wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}, ECT0: 10, ECT1: 11, ECNCE: 12},
+			map[string]interface{}{
+				"frame_type":   "ack",
+				"ack_delay":    float64(0),
+				"acked_ranges": []interface{}{[]interface{}{float64(1), float64(1)}},
+				"ect0":         float64(10),
+				"ect1":         float64(11),
+				"ce":           float64(12),
+			},
+		)
+	})
+
+	It("marshals CRYPTO frames", func() {
+		check(&wire.CryptoFrame{Offset: 1337, Data: []byte("foobar")}, map[string]interface{}{
+			"frame_type": "crypto",
+			"offset":     float64(1337),
+			"length":     float64(6),
+		})
+	})
+
+	It("marshals RESET_STREAM frames", func() {
+		check(&wire.ResetStreamFrame{StreamID: 987, ErrorCode: 4, ByteOffset: 1234}, map[string]interface{}{
+			"frame_type": "reset_stream",
+			"stream_id":  float64(987),
+			"error_code": float64(4),
+			"final_size": float64(1234),
+		})
+	})
+
+	It("marshals MAX_DATA and DATA_BLOCKED frames", func() {
+		check(&wire.MaxDataFrame{ByteOffset: 1337}, map[string]interface{}{
+			"frame_type": "max_data",
+			"maximum":    float64(1337),
+		})
+		check(&wire.DataBlockedFrame{DataLimit: 1337}, map[string]interface{}{
+			"frame_type": "data_blocked",
+			"limit":      float64(1337),
+		})
+	})
+
+	It("marshals MAX_STREAMS and STREAMS_BLOCKED frames", func() {
+		check(&wire.MaxStreamsFrame{Type: protocol.StreamTypeBidi, MaxStreams: 42}, map[string]interface{}{
+			"frame_type":  "max_streams",
+			"stream_type": "bidirectional",
+			"maximum":     float64(42),
+		})
+		check(&wire.StreamsBlockedFrame{Type: protocol.StreamTypeUni, StreamLimit: 42}, map[string]interface{}{
+			"frame_type":  "streams_blocked",
+			"stream_type": "unidirectional",
+			"limit":       float64(42),
+		})
+	})
+
+	It("marshals NEW_CONNECTION_ID frames", func() {
+		check(&wire.NewConnectionIDFrame{
+			SequenceNumber:      42,
+			ConnectionID:        protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+			StatelessResetToken: [16]byte{0: 0x12, 15: 0x34},
+		}, map[string]interface{}{
+			"frame_type":            "new_connection_id",
+			"sequence_number":       float64(42),
+			"length":                float64(4),
+			"connection_id":         "deadbeef",
+			"stateless_reset_token": "12000000000000000000000000000034",
+		})
+	})
+
+	It("marshals PATH_CHALLENGE frames", func() {
+		check(&wire.PathChallengeFrame{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, map[string]interface{}{
+			"frame_type": "path_challenge",
+			"data":       "0102030405060708",
+		})
+	})
+
+	It("marshals CONNECTION_CLOSE frames", func() {
+		check(&wire.ConnectionCloseFrame{ErrorCode: qerr.InternalError, ReasonPhrase: "foobar"}, map[string]interface{}{
+			"frame_type":  "connection_close",
+			"error_space": "transport",
+			"error_code":  float64(qerr.InternalError),
+			"reason":      "foobar",
+		})
+		check(&wire.ConnectionCloseFrame{IsApplicationError: true, ErrorCode: 1337}, map[string]interface{}{
+			"frame_type":  "connection_close",
+			"error_space": "application",
+			"error_code":  float64(1337),
+			"reason":      "",
+		})
+	})
+
+	It("marshals DATAGRAM frames", func() {
+		check(&wire.DatagramFrame{Data: []byte("foobar")}, map[string]interface{}{
+			"frame_type": "datagram",
+			"length":     float64(6),
+		})
+	})
+})
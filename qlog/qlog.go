@@ -0,0 +1,188 @@
+// Package qlog writes qlog traces of QUIC connections, in the JSON-SEQ format (draft-02).
+// The traces can be visualized using qvis (https://qvis.edm.uhasselt.be).
+package qlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/logging"
+)
+
+// the number of events that are buffered before events are dropped
+const eventChanSize = 1000
+
+// The record separator starts every JSON-SEQ record, see RFC 7464.
+const recordSeparator = 0x1e
+
+type connectionTracer struct {
+	w             io.WriteCloser
+	perspective   protocol.Perspective
+	odcid         protocol.ConnectionID
+	referenceTime time.Time
+
+	events     chan event
+	runStopped chan struct{}
+}
+
+var _ logging.ConnectionTracer = &connectionTracer{}
+
+// NewConnectionTracer creates a tracer that writes a qlog trace of the connection to w.
+// Events are serialized on a separate go routine.
+// The trace is flushed and w is closed when the connection is closed.
+func NewConnectionTracer(w io.WriteCloser, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
+	t := &connectionTracer{
+		w:             w,
+		perspective:   p,
+		odcid:         odcid,
+		referenceTime: time.Now(),
+		events:        make(chan event, eventChanSize),
+		runStopped:    make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *connectionTracer) run() {
+	defer close(t.runStopped)
+	buf := bufio.NewWriter(t.w)
+	err := writeRecord(buf, t.header())
+	for ev := range t.events {
+		// If writing fails, keep draining the channel, such that the session doesn't block.
+		if err == nil {
+			err = writeRecord(buf, ev)
+		}
+	}
+	if err == nil {
+		buf.Flush()
+	}
+	t.w.Close()
+}
+
+func writeRecord(w *bufio.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteByte(recordSeparator); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+func (t *connectionTracer) header() *topLevel {
+	vantagePoint := "server"
+	if t.perspective == protocol.PerspectiveClient {
+		vantagePoint = "client"
+	}
+	return &topLevel{
+		QlogVersion: "draft-02",
+		QlogFormat:  "JSON-SEQ",
+		Title:       "quic-go qlog",
+		Trace: trace{
+			VantagePoint: vantagePointInfo{Type: vantagePoint},
+			CommonFields: commonFields{
+				ODCID:         connectionID(t.odcid),
+				ReferenceTime: float64(t.referenceTime.UnixNano()) / 1e6,
+				TimeFormat:    "relative",
+			},
+		},
+	}
+}
+
+// recordEvent must not block the session.
+// If the serialization go routine can't keep up, the event is dropped.
+func (t *connectionTracer) recordEvent(details eventDetails) {
+	select {
+	case t.events <- t.newEvent(details):
+	default:
+	}
+}
+
+func (t *connectionTracer) newEvent(details eventDetails) event {
+	return event{
+		RelativeTime: time.Since(t.referenceTime),
+		eventDetails: details,
+	}
+}
+
+func (t *connectionTracer) SentPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, frames []logging.Frame) {
+	t.recordEvent(&eventPacketSent{
+		PacketType: getPacketType(hdr),
+		Header:     transformHeader(hdr, size),
+		Frames:     transformFrames(frames),
+	})
+}
+
+func (t *connectionTracer) ReceivedPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, frames []logging.Frame) {
+	t.recordEvent(&eventPacketReceived{
+		PacketType: getPacketType(hdr),
+		Header:     transformHeader(hdr, size),
+		Frames:     transformFrames(frames),
+	})
+}
+
+func (t *connectionTracer) DroppedPacket(size logging.ByteCount, reason logging.PacketDropReason) {
+	t.recordEvent(&eventPacketDropped{
+		PacketSize: size,
+		Trigger:    packetDropReason(reason),
+	})
+}
+
+func (t *connectionTracer) LostPacket(encLevel logging.EncryptionLevel, pn logging.PacketNumber, reason logging.PacketLossReason) {
+	t.recordEvent(&eventPacketLost{
+		PacketType:   getPacketTypeFromEncryptionLevel(encLevel),
+		PacketNumber: pn,
+		Trigger:      packetLossReason(reason),
+	})
+}
+
+func (t *connectionTracer) UpdatedRTT(rtt logging.RTTSnapshot) {
+	t.recordEvent(&eventRTTUpdated{
+		MinRTT:      milliseconds(rtt.MinRTT),
+		SmoothedRTT: milliseconds(rtt.SmoothedRTT),
+		LatestRTT:   milliseconds(rtt.LatestRTT),
+		RTTVariance: milliseconds(rtt.MeanDeviation),
+	})
+}
+
+func (t *connectionTracer) UpdatedCongestionWindow(congestionWindow, bytesInFlight logging.ByteCount) {
+	t.recordEvent(&eventCongestionWindowUpdated{
+		CongestionWindow: congestionWindow,
+		BytesInFlight:    bytesInFlight,
+	})
+}
+
+func (t *connectionTracer) UpdatedStreamReceiveWindow(id logging.StreamID, offset logging.ByteCount) {
+	t.recordEvent(&eventStreamFlowControlUpdated{
+		StreamID: id,
+		Owner:    "local",
+		Limit:    offset,
+	})
+}
+
+func (t *connectionTracer) UpdatedStreamSendWindow(id logging.StreamID, offset logging.ByteCount) {
+	t.recordEvent(&eventStreamFlowControlUpdated{
+		StreamID: id,
+		Owner:    "remote",
+		Limit:    offset,
+	})
+}
+
+// ClosedConnection records the event, and waits until the trace has been written and closed.
+func (t *connectionTracer) ClosedConnection(err error) {
+	ev := &eventConnectionClosed{New: "closed"}
+	if err != nil {
+		ev.Reason = err.Error()
+	}
+	// Don't drop the last event, it's needed to determine the final state of the connection.
+	t.events <- t.newEvent(ev)
+	close(t.events)
+	<-t.runStopped
+}
@@ -0,0 +1,296 @@
+package qlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/wire"
+	"github.com/lucas-clemente/quic-go/logging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type bufferWriteCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *bufferWriteCloser) Close() error {
+	w.closed = true
+	return nil
+}
+
+// blockingWriteCloser blocks all writes until unblock is closed
+type blockingWriteCloser struct {
+	bufferWriteCloser
+	unblock chan struct{}
+}
+
+func (w *blockingWriteCloser) Write(p []byte) (int, error) {
+	<-w.unblock
+	return w.bufferWriteCloser.Write(p)
+}
+
+type entry struct {
+	Time  float64
+	Name  string
+	Event map[string]interface{}
+}
+
+var _ = Describe("Tracer", func() {
+	var (
+		tracer logging.ConnectionTracer
+		buf    *bufferWriteCloser
+	)
+
+	BeforeEach(func() {
+		buf = &bufferWriteCloser{}
+		tracer = NewConnectionTracer(buf, logging.PerspectiveServer, protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef})
+	})
+
+	// parseRecords closes the tracer, and parses all the records that were written
+	parseRecords := func() []map[string]interface{} {
+		tracer.ClosedConnection(nil)
+		Expect(buf.closed).To(BeTrue())
+		data := buf.Bytes()
+		Expect(data[0]).To(BeEquivalentTo(recordSeparator))
+		var records []map[string]interface{}
+		for _, record := range bytes.Split(data[1:], []byte{recordSeparator}) {
+			Expect(record[len(record)-1]).To(Equal(byte('\n')))
+			m := make(map[string]interface{})
+			ExpectWithOffset(1, json.Unmarshal(record, &m)).To(Succeed())
+			records = append(records, m)
+		}
+		return records
+	}
+
+	// exportEvents returns all events except for the connection close
+	exportEvents := func() []entry {
+		records := parseRecords()
+		var entries []entry
+		for _, r := range records[1 : len(records)-1] {
+			entries = append(entries, entry{
+				Time:  r["time"].(float64),
+				Name:  r["name"].(string),
+				Event: r["data"].(map[string]interface{}),
+			})
+		}
+		return entries
+	}
+
+	It("writes the header", func() {
+		records := parseRecords()
+		Expect(records).To(HaveLen(2))
+		header := records[0]
+		Expect(header).To(HaveKeyWithValue("qlog_version", "draft-02"))
+		Expect(header).To(HaveKeyWithValue("qlog_format", "JSON-SEQ"))
+		Expect(header).To(HaveKey("trace"))
+		trace := header["trace"].(map[string]interface{})
+		Expect(trace).To(HaveKeyWithValue("vantage_point", map[string]interface{}{"type": "server"}))
+		commonFields := trace["common_fields"].(map[string]interface{})
+		Expect(commonFields).To(HaveKeyWithValue("ODCID", "deadbeef"))
+		Expect(commonFields).To(HaveKeyWithValue("time_format", "relative"))
+		Expect(commonFields).To(HaveKey("reference_time"))
+		Expect(commonFields["reference_time"]).To(BeNumerically("~", float64(time.Now().UnixNano())/1e6, 1000))
+	})
+
+	It("records the connection close", func() {
+		tracer.ClosedConnection(errors.New("foobar"))
+		records := bytes.Split(buf.Bytes()[1:], []byte{recordSeparator})
+		Expect(records).To(HaveLen(2))
+		m := make(map[string]interface{})
+		Expect(json.Unmarshal(records[1], &m)).To(Succeed())
+		Expect(m).To(HaveKeyWithValue("name", "connectivity:connection_state_updated"))
+		Expect(m).To(HaveKeyWithValue("data", map[string]interface{}{"new": "closed", "reason": "foobar"}))
+	})
+
+	It("records sent packets", func() {
+		tracer.SentPacket(
+			&wire.ExtendedHeader{
+				Header: wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketTypeHandshake,
+					DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+					SrcConnectionID:  protocol.ConnectionID{4, 3, 2, 1},
+					Version:          protocol.VersionTLS,
+				},
+				PacketNumber: 1337,
+			},
+			987,
+			[]logging.Frame{
+				&wire.MaxStreamDataFrame{StreamID: 42, ByteOffset: 987},
+				&wire.StreamFrame{StreamID: 123, Offset: 1234, Data: []byte("foobar"), FinBit: true},
+			},
+		)
+		entries := exportEvents()
+		Expect(entries).To(HaveLen(1))
+		ev := entries[0]
+		Expect(ev.Time).To(BeNumerically("~", 0, 1000))
+		Expect(ev.Name).To(Equal("transport:packet_sent"))
+		Expect(ev.Event).To(HaveKeyWithValue("packet_type", "handshake"))
+		hdr := ev.Event["header"].(map[string]interface{})
+		Expect(hdr).To(HaveKeyWithValue("packet_number", float64(1337)))
+		Expect(hdr).To(HaveKeyWithValue("packet_size", float64(987)))
+		Expect(hdr).To(HaveKeyWithValue("dcid", "0102030405060708"))
+		Expect(hdr).To(HaveKeyWithValue("scid", "04030201"))
+		frames := ev.Event["frames"].([]interface{})
+		Expect(frames).To(HaveLen(2))
+		Expect(frames[0]).To(Equal(map[string]interface{}{
+			"frame_type": "max_stream_data",
+			"stream_id":  float64(42),
+			"maximum":    float64(987),
+		}))
+		Expect(frames[1]).To(Equal(map[string]interface{}{
+			"frame_type": "stream",
+			"stream_id":  float64(123),
+			"offset":     float64(1234),
+			"length":     float64(6),
+			"fin":        true,
+		}))
+	})
+
+	It("records received packets", func() {
+		tracer.ReceivedPacket(
+			&wire.ExtendedHeader{
+				Header:       wire.Header{DestConnectionID: protocol.ConnectionID{1, 2, 3, 4}},
+				PacketNumber: 1337,
+			},
+			789,
+			[]logging.Frame{
+				&wire.AckFrame{
+					AckRanges: []wire.AckRange{{Smallest: 10, Largest: 15}, {Smallest: 1, Largest: 5}},
+					DelayTime: 2 * time.Millisecond,
+				},
+				&wire.StreamDataBlockedFrame{StreamID: 4, DataLimit: 1000},
+			},
+		)
+		entries := exportEvents()
+		Expect(entries).To(HaveLen(1))
+		ev := entries[0]
+		Expect(ev.Name).To(Equal("transport:packet_received"))
+		Expect(ev.Event).To(HaveKeyWithValue("packet_type", "1RTT"))
+		hdr := ev.Event["header"].(map[string]interface{})
+		Expect(hdr).To(HaveKeyWithValue("packet_number", float64(1337)))
+		Expect(hdr).ToNot(HaveKey("scid"))
+		frames := ev.Event["frames"].([]interface{})
+		Expect(frames).To(HaveLen(2))
+		Expect(frames[0]).To(Equal(map[string]interface{}{
+			"frame_type":   "ack",
+			"ack_delay":    float64(2),
+			"acked_ranges": []interface{}{[]interface{}{float64(10), float64(15)}, []interface{}{float64(1), float64(5)}},
+		}))
+		Expect(frames[1]).To(Equal(map[string]interface{}{
+			"frame_type": "stream_data_blocked",
+			"stream_id":  float64(4),
+			"limit":      float64(1000),
+		}))
+	})
+
+	It("copies the frames before returning", func() {
+		f := &wire.StreamFrame{StreamID: 4, Data: []byte("foobar")}
+		tracer.SentPacket(&wire.ExtendedHeader{}, 100, []logging.Frame{f})
+		// the STREAM frame might be reused
+		f.StreamID = 8
+		f.Data = f.Data[:0]
+		entries := exportEvents()
+		Expect(entries).To(HaveLen(1))
+		frames := entries[0].Event["frames"].([]interface{})
+		Expect(frames[0]).To(HaveKeyWithValue("stream_id", float64(4)))
+		Expect(frames[0]).To(HaveKeyWithValue("length", float64(6)))
+	})
+
+	It("records dropped packets", func() {
+		tracer.DroppedPacket(1337, logging.PacketDropPayloadDecryptError)
+		entries := exportEvents()
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name).To(Equal("transport:packet_dropped"))
+		Expect(entries[0].Event).To(Equal(map[string]interface{}{
+			"packet_size": float64(1337),
+			"trigger":     "payload_decrypt_error",
+		}))
+	})
+
+	It("records lost packets", func() {
+		tracer.LostPacket(protocol.EncryptionHandshake, 42, logging.PacketLossTimeThreshold)
+		entries := exportEvents()
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name).To(Equal("recovery:packet_lost"))
+		Expect(entries[0].Event).To(Equal(map[string]interface{}{
+			"packet_type":   "handshake",
+			"packet_number": float64(42),
+			"trigger":       "time_threshold",
+		}))
+	})
+
+	It("records RTT and congestion window updates", func() {
+		tracer.UpdatedRTT(logging.RTTSnapshot{
+			LatestRTT:     15 * time.Millisecond,
+			SmoothedRTT:   25 * time.Millisecond,
+			MinRTT:        10 * time.Millisecond,
+			MeanDeviation: 1500 * time.Microsecond,
+		})
+		tracer.UpdatedCongestionWindow(12345, 1234)
+		entries := exportEvents()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Name).To(Equal("recovery:metrics_updated"))
+		Expect(entries[0].Event).To(Equal(map[string]interface{}{
+			"latest_rtt":   float64(15),
+			"smoothed_rtt": float64(25),
+			"min_rtt":      float64(10),
+			"rtt_variance": 1.5,
+		}))
+		Expect(entries[1].Name).To(Equal("recovery:metrics_updated"))
+		Expect(entries[1].Event).To(Equal(map[string]interface{}{
+			"congestion_window": float64(12345),
+			"bytes_in_flight":   float64(1234),
+		}))
+		Expect(entries[1].Time).To(BeNumerically(">=", entries[0].Time))
+	})
+
+	It("records stream flow control updates", func() {
+		tracer.UpdatedStreamReceiveWindow(4, 1000)
+		tracer.UpdatedStreamSendWindow(8, 2000)
+		entries := exportEvents()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Name).To(Equal("transport:stream_flow_control_updated"))
+		Expect(entries[0].Event).To(Equal(map[string]interface{}{
+			"stream_id": float64(4),
+			"owner":     "local",
+			"limit":     float64(1000),
+		}))
+		Expect(entries[1].Name).To(Equal("transport:stream_flow_control_updated"))
+		Expect(entries[1].Event).To(Equal(map[string]interface{}{
+			"stream_id": float64(8),
+			"owner":     "remote",
+			"limit":     float64(2000),
+		}))
+	})
+
+	It("drops events instead of blocking when the writer can't keep up", func() {
+		w := &blockingWriteCloser{unblock: make(chan struct{})}
+		tracer := NewConnectionTracer(w, logging.PerspectiveServer, protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef})
+		const num = 3 * eventChanSize
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			for i := 0; i < num; i++ {
+				tracer.UpdatedCongestionWindow(12345, 1234)
+			}
+		}()
+		Eventually(done).Should(BeClosed())
+		close(w.unblock)
+		tracer.ClosedConnection(nil)
+		Expect(w.closed).To(BeTrue())
+		records := bytes.Split(w.Bytes()[1:], []byte{recordSeparator})
+		// the header, the congestion window updates that were not dropped, and the connection close
+		Expect(len(records)).To(BeNumerically("<", num+2))
+		m := make(map[string]interface{})
+		Expect(json.Unmarshal(records[len(records)-1], &m)).To(Succeed())
+		Expect(m).To(HaveKeyWithValue("name", "connectivity:connection_state_updated"))
+	})
+})
@@ -0,0 +1,162 @@
+// Package quictest provides a simulated network for testing QUIC clients and servers in memory,
+// without using real UDP sockets.
+package quictest
+
+import (
+	"sync"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+)
+
+// A Clock is a clock that can be advanced manually.
+// It runs at the speed of the system clock, and Advance moves it forward, firing all timers that expire on the way.
+// When it is used for the quic.Config of both endpoints and for the network they're communicating on,
+// timeouts (e.g. the idle timeout, or a loss detection timeout) can be tested without waiting for them.
+type Clock struct {
+	mutex  sync.Mutex
+	offset time.Duration
+	timers map[*timer]struct{}
+}
+
+var _ quic.Clock = &Clock{}
+
+// NewClock creates a new Clock, starting at the current time.
+func NewClock() *Clock {
+	return &Clock{timers: make(map[*timer]struct{})}
+}
+
+// Now returns the current time.
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now()
+}
+
+func (c *Clock) now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+// Advance moves the clock forward by d.
+// Timers that expire are fired in the order of their deadlines before Advance returns.
+func (c *Clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if d <= 0 {
+		return
+	}
+	c.offset += d
+	// Firing a timer can reset other timers (or itself), so look for the next timer that expired after every step.
+	for {
+		now := c.now()
+		var next *timer
+		for t := range c.timers {
+			if !t.deadline.After(now) && (next == nil || t.deadline.Before(next.deadline)) {
+				next = t
+			}
+		}
+		if next == nil {
+			return
+		}
+		next.fire(now)
+	}
+}
+
+// NewTimer creates a timer that is not set.
+func (c *Clock) NewTimer() quic.ClockTimer {
+	ct := &clockTimer{c: make(chan time.Time, 1)}
+	ct.timer = c.newTimer(func(now time.Time) {
+		select {
+		case ct.c <- now:
+		default:
+		}
+	})
+	return ct
+}
+
+// newTimer creates a timer that calls f when it fires.
+// f is called while holding the mutex of the clock. It must not call any methods of the clock,
+// but it can reset timers using resetLocked.
+func (c *Clock) newTimer(f func(time.Time)) *timer {
+	return &timer{clock: c, f: f}
+}
+
+// A timer fires when the system clock reaches its deadline, or when the clock is advanced past it.
+// All fields are protected by the mutex of the clock.
+type timer struct {
+	clock *Clock
+	f     func(time.Time)
+
+	deadline    time.Time
+	systemTimer *time.Timer
+	// generation is incremented every time the timer is reset or fired,
+	// so that a system timer that fires after that can be ignored.
+	generation uint64
+}
+
+func (t *timer) reset(deadline time.Time) {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	t.resetLocked(deadline)
+}
+
+func (t *timer) resetLocked(deadline time.Time) {
+	c := t.clock
+	t.generation++
+	if t.systemTimer != nil {
+		t.systemTimer.Stop()
+		t.systemTimer = nil
+	}
+	t.deadline = deadline
+	if deadline.IsZero() {
+		delete(c.timers, t)
+		return
+	}
+	c.timers[t] = struct{}{}
+	generation := t.generation
+	t.systemTimer = time.AfterFunc(deadline.Sub(c.now()), func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		if t.generation == generation {
+			t.fire(c.now())
+		}
+	})
+}
+
+// fire must be called while holding the mutex of the clock.
+func (t *timer) fire(now time.Time) {
+	t.generation++
+	if t.systemTimer != nil {
+		t.systemTimer.Stop()
+		t.systemTimer = nil
+	}
+	delete(t.clock.timers, t)
+	t.f(now)
+}
+
+type clockTimer struct {
+	*timer
+	c chan time.Time
+}
+
+var _ quic.ClockTimer = &clockTimer{}
+
+func (t *clockTimer) Chan() <-chan time.Time {
+	return t.c
+}
+
+func (t *clockTimer) Reset(deadline time.Time) {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	// Discard the value of an earlier deadline that was not received yet.
+	select {
+	case <-t.c:
+	default:
+	}
+	t.resetLocked(deadline)
+}
@@ -0,0 +1,93 @@
+package quictest
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Clock", func() {
+	var clock *Clock
+
+	BeforeEach(func() {
+		clock = NewClock()
+	})
+
+	It("runs at the speed of the system clock", func() {
+		Expect(clock.Now()).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
+		time.Sleep(10 * time.Millisecond)
+		Expect(clock.Now()).To(BeTemporally("~", time.Now(), 10*time.Millisecond))
+	})
+
+	It("advances", func() {
+		clock.Advance(time.Hour)
+		Expect(clock.Now()).To(BeTemporally("~", time.Now().Add(time.Hour), 10*time.Millisecond))
+		clock.Advance(-time.Minute) // the clock never goes back
+		Expect(clock.Now()).To(BeTemporally("~", time.Now().Add(time.Hour), 10*time.Millisecond))
+	})
+
+	Context("timers", func() {
+		It("fires when the deadline is reached", func() {
+			t := clock.NewTimer()
+			deadline := clock.Now().Add(20 * time.Millisecond)
+			t.Reset(deadline)
+			var now time.Time
+			Eventually(t.Chan()).Should(Receive(&now))
+			Expect(now).ToNot(BeTemporally("<", deadline))
+		})
+
+		It("fires when the clock is advanced", func() {
+			t := clock.NewTimer()
+			t.Reset(clock.Now().Add(time.Hour))
+			Consistently(t.Chan()).ShouldNot(Receive())
+			clock.Advance(59 * time.Minute)
+			Expect(t.Chan()).ToNot(Receive())
+			clock.Advance(time.Minute)
+			Expect(t.Chan()).To(Receive())
+		})
+
+		It("doesn't fire when stopped", func() {
+			t := clock.NewTimer()
+			t.Reset(clock.Now().Add(time.Hour))
+			t.Reset(time.Time{})
+			clock.Advance(2 * time.Hour)
+			Consistently(t.Chan()).ShouldNot(Receive())
+		})
+
+		It("discards values that were not received when reset", func() {
+			t := clock.NewTimer()
+			t.Reset(clock.Now().Add(time.Minute))
+			clock.Advance(time.Minute)
+			t.Reset(clock.Now().Add(time.Hour))
+			Expect(t.Chan()).ToNot(Receive())
+			clock.Advance(time.Hour)
+			Expect(t.Chan()).To(Receive())
+		})
+
+		It("fires timers in the order of their deadlines", func() {
+			var order []int
+			now := clock.Now()
+			for i := 3; i > 0; i-- {
+				i := i
+				t := clock.newTimer(func(time.Time) { order = append(order, i) })
+				t.reset(now.Add(time.Duration(i) * time.Minute))
+			}
+			clock.Advance(time.Hour)
+			Expect(order).To(Equal([]int{1, 2, 3}))
+		})
+
+		It("fires timers that are reset while advancing", func() {
+			var fired int
+			now := clock.Now()
+			var t *timer
+			t = clock.newTimer(func(time.Time) {
+				fired++
+				t.resetLocked(now.Add(time.Duration(fired+1) * time.Minute))
+			})
+			t.reset(now.Add(time.Minute))
+			clock.Advance(150 * time.Second)
+			Expect(fired).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,395 @@
+package quictest
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LinkConfig configures one direction of a simulated link.
+type LinkConfig struct {
+	// Delay is the one-way delay of every packet.
+	Delay time.Duration
+	// Jitter is the maximum random variation of the delay.
+	// The delay of every packet is chosen uniformly from [Delay-Jitter, Delay+Jitter],
+	// which can cause packets to be reordered.
+	Jitter time.Duration
+	// LossRate is the probability that a packet is dropped, between 0 and 1.
+	LossRate float64
+	// ReorderRate is the probability that a packet is held back by an additional ReorderDelay, between 0 and 1.
+	ReorderRate float64
+	// ReorderDelay is the additional delay of a reordered packet.
+	// If not set, reordered packets are delayed by an additional Delay.
+	ReorderDelay time.Duration
+	// Bandwidth is the capacity of the link, in bytes per second.
+	// Packets are sent one after the other, and queue up if they are sent faster than the link allows.
+	// If not set, the bandwidth is unlimited.
+	Bandwidth uint64
+	// QueueSize is the maximum number of bytes that are queued while waiting for the link.
+	// Packets that don't fit into the queue are dropped.
+	// It is only used if a Bandwidth is set. If not set, the queue is unlimited.
+	QueueSize uint64
+	// DropPacket is called for every packet sent on the link.
+	// If it returns true, the packet is dropped, making it possible to drop specific packets in tests.
+	// The data must not be modified or used after it returns.
+	DropPacket func(data []byte) bool
+}
+
+// Config configures a simulated network.
+type Config struct {
+	// Clock is the clock used to deliver packets.
+	// It should also be used as the Clock of the quic.Config of both endpoints.
+	// If not set, a new Clock is used.
+	Clock *Clock
+	// Seed is used to seed the random number generator that decides about loss, jitter and reordering.
+	// Using the same seed results in the same decisions for the same sequence of packets.
+	Seed int64
+	// ClientToServer configures the link from the client to the server.
+	ClientToServer LinkConfig
+	// ServerToClient configures the link from the server to the client.
+	ServerToClient LinkConfig
+}
+
+// Stats are the statistics of packets sent on a PacketConn.
+type Stats struct {
+	// PacketsSent is the number of packets sent.
+	PacketsSent uint64
+	// PacketsDropped is the number of packets dropped on the link, including the packets dropped because the queue was full.
+	PacketsDropped uint64
+	// PacketsDelivered is the number of packets delivered to the peer.
+	PacketsDelivered uint64
+}
+
+// NewPacketConnPair creates a pair of connected, in-memory packet conns.
+// They can be used with quic.Listen and quic.Dial, using the address of the server conn as remote address.
+// Packets sent to any other address are dropped.
+func NewPacketConnPair(conf *Config) (client, server *PacketConn) {
+	if conf == nil {
+		conf = &Config{}
+	}
+	clock := conf.Clock
+	if clock == nil {
+		clock = NewClock()
+	}
+	client = newPacketConn(clock, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 42424})
+	server = newPacketConn(clock, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4433})
+	client.link = newLink(clock, conf.ClientToServer, conf.Seed, server)
+	server.link = newLink(clock, conf.ServerToClient, conf.Seed+1, client)
+	return client, server
+}
+
+type inFlightPacket struct {
+	data    []byte
+	arrival time.Time
+}
+
+type link struct {
+	clock  *Clock
+	config LinkConfig
+	peer   *PacketConn
+	timer  *timer
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	// busyUntil is the time when the last packet queued for the link has been sent.
+	busyUntil time.Time
+	// inFlight is sorted by arrival time.
+	// Packets with the same arrival time are delivered in the order they were sent.
+	inFlight []inFlightPacket
+	stats    Stats
+}
+
+func newLink(clock *Clock, config LinkConfig, seed int64, peer *PacketConn) *link {
+	l := &link{
+		clock:  clock,
+		config: config,
+		peer:   peer,
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+	l.timer = clock.newTimer(l.deliverPackets)
+	return l
+}
+
+func (l *link) send(data []byte) {
+	if !l.enqueue(data, l.clock.Now()) {
+		return
+	}
+	// The packet is the next packet to arrive.
+	l.clock.mutex.Lock()
+	l.resetTimerLocked()
+	l.clock.mutex.Unlock()
+}
+
+// enqueue decides if the packet is dropped, and when it arrives.
+// It returns true if the packet is the next packet to arrive.
+func (l *link) enqueue(data []byte, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.stats.PacketsSent++
+	if l.config.DropPacket != nil && l.config.DropPacket(data) {
+		l.stats.PacketsDropped++
+		return false
+	}
+	// Draw all random numbers for every packet, so that the decisions for a packet don't depend on the earlier ones.
+	lost := l.rand.Float64() < l.config.LossRate
+	jitter := time.Duration((2*l.rand.Float64() - 1) * float64(l.config.Jitter))
+	reordered := l.rand.Float64() < l.config.ReorderRate
+	if lost {
+		l.stats.PacketsDropped++
+		return false
+	}
+
+	sent := now
+	if l.config.Bandwidth > 0 {
+		if l.busyUntil.Before(now) {
+			l.busyUntil = now
+		}
+		if l.config.QueueSize > 0 {
+			queued := uint64(l.busyUntil.Sub(now)) * l.config.Bandwidth / uint64(time.Second)
+			if queued+uint64(len(data)) > l.config.QueueSize {
+				l.stats.PacketsDropped++
+				return false
+			}
+		}
+		l.busyUntil = l.busyUntil.Add(time.Duration(uint64(len(data)) * uint64(time.Second) / l.config.Bandwidth))
+		sent = l.busyUntil
+	}
+	delay := l.config.Delay + jitter
+	if delay < 0 {
+		delay = 0
+	}
+	if reordered {
+		if l.config.ReorderDelay > 0 {
+			delay += l.config.ReorderDelay
+		} else {
+			delay += l.config.Delay
+		}
+	}
+	arrival := sent.Add(delay)
+	i := sort.Search(len(l.inFlight), func(i int) bool { return l.inFlight[i].arrival.After(arrival) })
+	l.inFlight = append(l.inFlight, inFlightPacket{})
+	copy(l.inFlight[i+1:], l.inFlight[i:])
+	l.inFlight[i] = inFlightPacket{data: data, arrival: arrival}
+	return i == 0
+}
+
+// deliverPackets delivers all packets that arrived.
+// It is called by the timer, while holding the mutex of the clock.
+func (l *link) deliverPackets(now time.Time) {
+	l.mutex.Lock()
+	var arrived []inFlightPacket
+	for len(l.inFlight) > 0 && !l.inFlight[0].arrival.After(now) {
+		arrived = append(arrived, l.inFlight[0])
+		l.inFlight = l.inFlight[1:]
+	}
+	l.mutex.Unlock()
+
+	var delivered uint64
+	for _, p := range arrived {
+		if l.peer.deliver(p.data) {
+			delivered++
+		}
+	}
+	l.mutex.Lock()
+	l.stats.PacketsDelivered += delivered
+	l.mutex.Unlock()
+	l.resetTimerLocked()
+}
+
+// resetTimerLocked sets the timer to the arrival time of the next packet.
+// It must be called while holding the mutex of the clock.
+func (l *link) resetTimerLocked() {
+	l.mutex.Lock()
+	var next time.Time
+	if len(l.inFlight) > 0 {
+		next = l.inFlight[0].arrival
+	}
+	l.mutex.Unlock()
+	l.timer.resetLocked(next)
+}
+
+func (l *link) getStats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.stats
+}
+
+// maxQueuedPackets is the maximum number of packets that are queued for reading.
+// Packets that arrive when the queue is full are dropped, just like a full UDP receive buffer would.
+const maxQueuedPackets = 1024
+
+// A PacketConn is an in-memory net.PacketConn, connected to its peer by a simulated link.
+type PacketConn struct {
+	clock *Clock
+	addr  net.Addr
+	link  *link
+
+	mutex      sync.Mutex
+	queue      [][]byte
+	closed     bool
+	notifyChan chan struct{}
+
+	deadlineExceeded bool
+	deadlineTimer    *timer
+	writeDeadline    time.Time
+}
+
+var _ net.PacketConn = &PacketConn{}
+
+func newPacketConn(clock *Clock, addr net.Addr) *PacketConn {
+	c := &PacketConn{
+		clock:      clock,
+		addr:       addr,
+		notifyChan: make(chan struct{}, 1),
+	}
+	c.deadlineTimer = clock.newTimer(func(time.Time) {
+		c.mutex.Lock()
+		c.deadlineExceeded = true
+		c.mutex.Unlock()
+		c.notify()
+	})
+	return c
+}
+
+func (c *PacketConn) notify() {
+	select {
+	case c.notifyChan <- struct{}{}:
+	default:
+	}
+}
+
+func (c *PacketConn) deliver(data []byte) bool {
+	c.mutex.Lock()
+	if c.closed || len(c.queue) >= maxQueuedPackets {
+		c.mutex.Unlock()
+		return false
+	}
+	c.queue = append(c.queue, data)
+	c.mutex.Unlock()
+	c.notify()
+	return true
+}
+
+// ReadFrom reads a packet from the conn.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return 0, nil, c.opError("read", net.ErrClosed)
+		}
+		if len(c.queue) > 0 {
+			data := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mutex.Unlock()
+			return copy(p, data), c.link.peer.addr, nil
+		}
+		if c.deadlineExceeded {
+			c.mutex.Unlock()
+			return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+		}
+		c.mutex.Unlock()
+		<-c.notifyChan
+	}
+}
+
+// WriteTo sends a packet to the peer.
+// Packets sent to a different address than the address of the peer are dropped.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	closed := c.closed
+	writeDeadline := c.writeDeadline
+	c.mutex.Unlock()
+	if closed {
+		return 0, c.opError("write", net.ErrClosed)
+	}
+	if !writeDeadline.IsZero() && !c.clock.Now().Before(writeDeadline) {
+		return 0, c.opError("write", os.ErrDeadlineExceeded)
+	}
+	if addr.String() != c.link.peer.addr.String() {
+		return len(p), nil
+	}
+	data := make([]byte, len(p))
+	copy(data, p)
+	c.link.send(data)
+	return len(p), nil
+}
+
+// Close closes the conn.
+// Pending calls to ReadFrom are unblocked, and packets that arrive afterwards are dropped.
+func (c *PacketConn) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	c.closed = true
+	c.queue = nil
+	c.mutex.Unlock()
+	c.deadlineTimer.reset(time.Time{})
+	c.notify()
+	return nil
+}
+
+// LocalAddr returns the address of the conn.
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline sets the read and write deadline.
+// Deadlines are measured using the clock of the network.
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+// It is measured using the clock of the network.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	now := c.clock.Now()
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return c.opError("set", net.ErrClosed)
+	}
+	c.deadlineExceeded = !t.IsZero() && !t.After(now)
+	c.mutex.Unlock()
+	if t.IsZero() || !t.After(now) {
+		c.deadlineTimer.reset(time.Time{})
+	} else {
+		c.deadlineTimer.reset(t)
+	}
+	c.notify()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+// It is measured using the clock of the network.
+// Since writing never blocks, it only makes WriteTo return an error once it expired.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return c.opError("set", net.ErrClosed)
+	}
+	c.writeDeadline = t
+	return nil
+}
+
+// Stats returns the statistics of the packets sent on this conn.
+func (c *PacketConn) Stats() Stats {
+	return c.link.getStats()
+}
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.addr, Err: err}
+}
@@ -0,0 +1,316 @@
+package quictest
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Simulated network", func() {
+	var clock *Clock
+
+	BeforeEach(func() {
+		clock = NewClock()
+	})
+
+	// read reads a packet, making sure that it is available immediately
+	read := func(c *PacketConn) []byte {
+		packetChan := make(chan []byte, 1)
+		go func() {
+			defer GinkgoRecover()
+			b := make([]byte, 1500)
+			n, _, err := c.ReadFrom(b)
+			Expect(err).ToNot(HaveOccurred())
+			packetChan <- b[:n]
+		}()
+		var p []byte
+		Eventually(packetChan, 100*time.Millisecond).Should(Receive(&p))
+		return p
+	}
+
+	expectNoPacket := func(c *PacketConn) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		ExpectWithOffset(1, c.queue).To(BeEmpty())
+	}
+
+	It("sends packets in both directions", func() {
+		client, server := NewPacketConnPair(&Config{Clock: clock})
+		Expect(client.LocalAddr()).ToNot(Equal(server.LocalAddr()))
+		n, err := client.WriteTo([]byte("foobar"), server.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(6))
+		b := make([]byte, 100)
+		n, addr, err := server.ReadFrom(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b[:n])).To(Equal("foobar"))
+		Expect(addr).To(Equal(client.LocalAddr()))
+		_, err = server.WriteTo([]byte("raboof"), addr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read(client)).To(Equal([]byte("raboof")))
+		Expect(client.Stats()).To(Equal(Stats{PacketsSent: 1, PacketsDelivered: 1}))
+		Expect(server.Stats()).To(Equal(Stats{PacketsSent: 1, PacketsDelivered: 1}))
+	})
+
+	It("copies the data", func() {
+		client, server := NewPacketConnPair(&Config{Clock: clock})
+		b := []byte("foobar")
+		_, err := client.WriteTo(b, server.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		copy(b, "raboof")
+		Expect(read(server)).To(Equal([]byte("foobar")))
+	})
+
+	It("drops packets sent to a different address", func() {
+		client, server := NewPacketConnPair(&Config{Clock: clock})
+		n, err := client.WriteTo([]byte("foobar"), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1234})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(6))
+		Consistently(func() Stats { return client.Stats() }).Should(BeZero())
+		expectNoPacket(server)
+	})
+
+	It("delays packets", func() {
+		client, server := NewPacketConnPair(&Config{
+			Clock:          clock,
+			ClientToServer: LinkConfig{Delay: time.Hour},
+		})
+		_, err := client.WriteTo([]byte("foo"), server.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		clock.Advance(30 * time.Minute)
+		_, err = client.WriteTo([]byte("bar"), server.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		clock.Advance(30*time.Minute - time.Second)
+		expectNoPacket(server)
+		clock.Advance(time.Second)
+		Expect(read(server)).To(Equal([]byte("foo")))
+		expectNoPacket(server)
+		clock.Advance(30 * time.Minute)
+		Expect(read(server)).To(Equal([]byte("bar")))
+	})
+
+	It("delivers packets in order", func() {
+		client, server := NewPacketConnPair(&Config{Clock: clock})
+		for i := 0; i < 100; i++ {
+			_, err := client.WriteTo([]byte{byte(i)}, server.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+		}
+		for i := 0; i < 100; i++ {
+			Expect(read(server)).To(Equal([]byte{byte(i)}))
+		}
+	})
+
+	It("limits the bandwidth", func() {
+		client, server := NewPacketConnPair(&Config{
+			Clock:          clock,
+			ClientToServer: LinkConfig{Delay: time.Hour, Bandwidth: 100},
+		})
+		// every packet takes 10 seconds to send
+		for i := 0; i < 3; i++ {
+			_, err := client.WriteTo(make([]byte, 1000), server.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+		}
+		clock.Advance(time.Hour + 10*time.Second - time.Millisecond)
+		expectNoPacket(server)
+		clock.Advance(time.Millisecond)
+		Expect(read(server)).To(HaveLen(1000))
+		expectNoPacket(server)
+		clock.Advance(10 * time.Second)
+		Expect(read(server)).To(HaveLen(1000))
+		clock.Advance(10 * time.Second)
+		Expect(read(server)).To(HaveLen(1000))
+	})
+
+	It("drops packets when the queue is full", func() {
+		client, server := NewPacketConnPair(&Config{
+			Clock:          clock,
+			ClientToServer: LinkConfig{Delay: time.Hour, Bandwidth: 100, QueueSize: 3000},
+		})
+		// the packet that is currently being sent counts towards the queue
+		for i := 0; i < 5; i++ {
+			_, err := client.WriteTo(make([]byte, 1000), server.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(client.Stats()).To(Equal(Stats{PacketsSent: 5, PacketsDropped: 2}))
+		clock.Advance(2 * time.Hour)
+		Expect(read(server)).To(HaveLen(1000))
+		Expect(read(server)).To(HaveLen(1000))
+		Expect(read(server)).To(HaveLen(1000))
+		expectNoPacket(server)
+		Expect(client.Stats()).To(Equal(Stats{PacketsSent: 5, PacketsDropped: 2, PacketsDelivered: 3}))
+	})
+
+	It("drops packets using the callback", func() {
+		client, server := NewPacketConnPair(&Config{
+			Clock: clock,
+			ClientToServer: LinkConfig{
+				DropPacket: func(data []byte) bool { return data[0]%2 == 0 },
+			},
+		})
+		for i := 0; i < 10; i++ {
+			_, err := client.WriteTo([]byte{byte(i)}, server.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+		}
+		for i := 1; i < 10; i += 2 {
+			Expect(read(server)).To(Equal([]byte{byte(i)}))
+		}
+		Expect(client.Stats()).To(Equal(Stats{PacketsSent: 10, PacketsDropped: 5, PacketsDelivered: 5}))
+	})
+
+	Context("randomness", func() {
+		// send sends 1000 packets and returns the order in which they were received
+		send := func(conf *Config) []int {
+			conf.Clock = clock
+			client, server := NewPacketConnPair(conf)
+			for i := 0; i < 1000; i++ {
+				_, err := client.WriteTo([]byte{byte(i >> 8), byte(i)}, server.LocalAddr())
+				Expect(err).ToNot(HaveOccurred())
+			}
+			clock.Advance(time.Hour)
+			var received []int
+			for {
+				server.mutex.Lock()
+				empty := len(server.queue) == 0
+				server.mutex.Unlock()
+				if empty {
+					break
+				}
+				p := make([]byte, 10)
+				_, _, err := server.ReadFrom(p)
+				Expect(err).ToNot(HaveOccurred())
+				received = append(received, int(p[0])<<8+int(p[1]))
+			}
+			Expect(client.Stats().PacketsSent).To(BeEquivalentTo(1000))
+			Expect(client.Stats().PacketsDelivered).To(BeEquivalentTo(len(received)))
+			return received
+		}
+
+		isSorted := func(s []int) bool {
+			for i := 1; i < len(s); i++ {
+				if s[i] < s[i-1] {
+					return false
+				}
+			}
+			return true
+		}
+
+		It("drops packets", func() {
+			received := send(&Config{ClientToServer: LinkConfig{LossRate: 0.2}})
+			Expect(len(received)).To(BeNumerically("~", 800, 50))
+			Expect(isSorted(received)).To(BeTrue())
+		})
+
+		It("reorders packets, using jitter", func() {
+			received := send(&Config{ClientToServer: LinkConfig{Delay: time.Minute, Jitter: 30 * time.Second}})
+			Expect(received).To(HaveLen(1000))
+			Expect(isSorted(received)).To(BeFalse())
+		})
+
+		It("reorders packets", func() {
+			received := send(&Config{ClientToServer: LinkConfig{ReorderRate: 0.1, ReorderDelay: time.Minute}})
+			Expect(received).To(HaveLen(1000))
+			Expect(isSorted(received)).To(BeFalse())
+			// the reordered packets are received last
+			var numReordered int
+			for i := 1; i < len(received); i++ {
+				if received[i] < received[i-1] {
+					numReordered = len(received) - i
+					break
+				}
+			}
+			Expect(numReordered).To(BeNumerically("~", 100, 30))
+		})
+
+		It("is deterministic", func() {
+			conf := &Config{
+				Seed:           1337,
+				ClientToServer: LinkConfig{LossRate: 0.1, Delay: time.Minute, ReorderRate: 0.1},
+			}
+			received := send(conf)
+			Expect(send(conf)).To(Equal(received))
+			conf.Seed++
+			Expect(send(conf)).ToNot(Equal(received))
+		})
+	})
+
+	Context("closing", func() {
+		It("unblocks ReadFrom", func() {
+			client, _ := NewPacketConnPair(&Config{Clock: clock})
+			errChan := make(chan error, 1)
+			go func() {
+				_, _, err := client.ReadFrom(make([]byte, 100))
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(client.Close()).To(Succeed())
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+			_, err = client.WriteTo([]byte("foobar"), client.LocalAddr())
+			Expect(errors.Is(err, net.ErrClosed)).To(BeTrue())
+			Expect(errors.Is(client.Close(), net.ErrClosed)).To(BeTrue())
+		})
+
+		It("drops packets sent to a closed conn", func() {
+			client, server := NewPacketConnPair(&Config{Clock: clock})
+			Expect(server.Close()).To(Succeed())
+			_, err := client.WriteTo([]byte("foobar"), server.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(func() Stats { return client.Stats() }).Should(Equal(Stats{PacketsSent: 1}))
+		})
+	})
+
+	Context("deadlines", func() {
+		It("unblocks ReadFrom when the read deadline expires", func() {
+			client, _ := NewPacketConnPair(&Config{Clock: clock})
+			Expect(client.SetReadDeadline(clock.Now().Add(time.Hour))).To(Succeed())
+			errChan := make(chan error, 1)
+			go func() {
+				_, _, err := client.ReadFrom(make([]byte, 100))
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			clock.Advance(time.Hour)
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			Expect(errors.Is(err, os.ErrDeadlineExceeded)).To(BeTrue())
+			var nerr net.Error
+			Expect(errors.As(err, &nerr)).To(BeTrue())
+			Expect(nerr.Timeout()).To(BeTrue())
+		})
+
+		It("returns immediately if the read deadline is in the past", func() {
+			client, _ := NewPacketConnPair(&Config{Clock: clock})
+			Expect(client.SetReadDeadline(clock.Now().Add(-time.Second))).To(Succeed())
+			_, _, err := client.ReadFrom(make([]byte, 100))
+			Expect(errors.Is(err, os.ErrDeadlineExceeded)).To(BeTrue())
+			// extend the deadline
+			Expect(client.SetReadDeadline(clock.Now().Add(time.Hour))).To(Succeed())
+			errChan := make(chan error, 1)
+			go func() {
+				_, _, err := client.ReadFrom(make([]byte, 100))
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(client.SetReadDeadline(time.Time{})).To(Succeed())
+			clock.Advance(2 * time.Hour)
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(client.Close()).To(Succeed())
+			Eventually(errChan).Should(Receive())
+		})
+
+		It("returns an error from WriteTo when the write deadline expired", func() {
+			client, server := NewPacketConnPair(&Config{Clock: clock})
+			Expect(client.SetDeadline(clock.Now().Add(time.Hour))).To(Succeed())
+			_, err := client.WriteTo([]byte("foo"), server.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+			clock.Advance(time.Hour)
+			_, err = client.WriteTo([]byte("bar"), server.LocalAddr())
+			Expect(errors.Is(err, os.ErrDeadlineExceeded)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package quictest
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestQuicTest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "QUIC Test Utilities")
+}
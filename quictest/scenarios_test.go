@@ -0,0 +1,147 @@
+package quictest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/internal/testdata"
+	"github.com/lucas-clemente/quic-go/quictest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// These tests show how to test QUIC clients and servers on the simulated network.
+var _ = Describe("Scenarios", func() {
+	var (
+		clock      *quictest.Clock
+		clientConn *quictest.PacketConn
+		serverConn *quictest.PacketConn
+		ln         quic.Listener
+	)
+
+	// start creates the network, and starts a server that echoes all data it receives on the first stream
+	start := func(conf *quictest.Config, quicConf *quic.Config) {
+		clock = quictest.NewClock()
+		conf.Clock = clock
+		clientConn, serverConn = quictest.NewPacketConnPair(conf)
+		quicConf.Clock = clock
+		var err error
+		ln, err = quic.Listen(serverConn, testdata.GetTLSConfig(), quicConf)
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			sess, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			str, err := sess.AcceptStream(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+	}
+
+	dial := func(quicConf *quic.Config) quic.Session {
+		quicConf.Clock = clock
+		sess, err := quic.Dial(clientConn, serverConn.LocalAddr(), "localhost:4433", &tls.Config{InsecureSkipVerify: true}, quicConf)
+		Expect(err).ToNot(HaveOccurred())
+		return sess
+	}
+
+	echo := func(sess quic.Session, data []byte) {
+		str, err := sess.OpenStream()
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			_, err := str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+		}()
+		echoed, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bytes.Equal(echoed, data)).To(BeTrue())
+	}
+
+	AfterEach(func() {
+		ln.Close()
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	It("measures the RTT", func() {
+		link := quictest.LinkConfig{Delay: 25 * time.Millisecond}
+		start(&quictest.Config{ClientToServer: link, ServerToClient: link}, &quic.Config{})
+		sess := dial(&quic.Config{})
+		defer sess.Close()
+		echo(sess, []byte("foobar"))
+		rtt, err := sess.Ping(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rtt).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(rtt).To(BeNumerically("<", 100*time.Millisecond))
+	})
+
+	It("completes the handshake when handshake packets are lost", func() {
+		var numClientPackets, numServerPackets int
+		start(&quictest.Config{
+			ClientToServer: quictest.LinkConfig{
+				Delay: 10 * time.Millisecond,
+				// drop the first two packets sent by the client, i.e. the first Initial, and its retransmission
+				DropPacket: func([]byte) bool {
+					numClientPackets++
+					return numClientPackets <= 2
+				},
+			},
+			ServerToClient: quictest.LinkConfig{
+				Delay: 10 * time.Millisecond,
+				// drop the first packet sent by the server
+				DropPacket: func([]byte) bool {
+					numServerPackets++
+					return numServerPackets == 1
+				},
+			},
+		}, &quic.Config{})
+		sess := dial(&quic.Config{})
+		defer sess.Close()
+		echo(sess, []byte("foobar"))
+		Expect(clientConn.Stats().PacketsDropped).To(BeEquivalentTo(2))
+		Expect(serverConn.Stats().PacketsDropped).To(BeEquivalentTo(1))
+	})
+
+	It("recovers from packet loss", func() {
+		link := quictest.LinkConfig{
+			Delay:     5 * time.Millisecond,
+			Jitter:    time.Millisecond,
+			LossRate:  0.05,
+			Bandwidth: 10 << 20, // 10 MB/s
+		}
+		start(&quictest.Config{Seed: 42, ClientToServer: link, ServerToClient: link}, &quic.Config{})
+		sess := dial(&quic.Config{})
+		defer sess.Close()
+		data := make([]byte, 500<<10)
+		rand.Read(data)
+		echo(sess, data)
+		Expect(clientConn.Stats().PacketsDropped).ToNot(BeZero())
+		Expect(serverConn.Stats().PacketsDropped).ToNot(BeZero())
+	})
+
+	It("times out an idle session, without waiting for the idle timeout", func() {
+		start(&quictest.Config{}, &quic.Config{IdleTimeout: time.Hour})
+		sess := dial(&quic.Config{IdleTimeout: time.Hour})
+		echo(sess, []byte("foobar"))
+		Consistently(sess.Context().Done()).ShouldNot(BeClosed())
+		clock.Advance(time.Hour)
+		Eventually(sess.Context().Done()).Should(BeClosed())
+		_, err := sess.OpenStream()
+		Expect(errors.Is(err, quic.ErrIdleTimeout)).To(BeTrue())
+	})
+})
@@ -1,6 +1,8 @@
 package quic
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
@@ -15,12 +17,21 @@ import (
 type receiveStreamI interface {
 	ReceiveStream
 
-	handleStreamFrame(*wire.StreamFrame) error
+	checkOutOfOrderData(*wire.StreamFrame, protocol.ByteCount) (protocol.ByteCount, error)
+	handleStreamFrame(*wire.StreamFrame, *packetBuffer) error
 	handleResetStreamFrame(*wire.ResetStreamFrame) error
 	closeForShutdown(error)
 	getWindowUpdate() protocol.ByteCount
 }
 
+// errTooMuchOutOfOrderData is returned when a STREAM frame can't be buffered,
+// because too much data was received out of order.
+// The packet containing the frame is dropped (and not acknowledged).
+var errTooMuchOutOfOrderData = errors.New("too much out-of-order stream data buffered")
+
+// errNegativeCount is returned when Peek or Discard is called with a negative byte count.
+var errNegativeCount = errors.New("negative count")
+
 type receiveStream struct {
 	mutex sync.Mutex
 
@@ -28,13 +39,22 @@ type receiveStream struct {
 
 	sender streamSender
 
+	limiter *outOfOrderDataLimiter
+	// the amount of out-of-order data reported to the limiter
+	outOfOrderBytes protocol.ByteCount
+	// set when the stream was completed or closed, and the out-of-order data was released
+	outOfOrderDataReleased bool
+
 	frameQueue  *frameSorter
 	readOffset  protocol.ByteCount
 	finalOffset protocol.ByteCount
 
 	currentFrame       []byte
-	currentFrameIsLast bool // is the currentFrame the last frame on this stream
+	currentFrameBuffer *packetBuffer // the packet buffer holding the currentFrame, if any
+	currentFrameIsLast bool          // is the currentFrame the last frame on this stream
 	readPosInFrame     int
+	// set while Read or WriteTo access the currentFrame without holding the mutex
+	readingCurrentFrame bool
 
 	closeForShutdownErr error
 	cancelReadErr       error
@@ -48,23 +68,29 @@ type receiveStream struct {
 	readChan chan struct{}
 	deadline time.Time
 
+	// cancels the context of a bidirectional stream, nil for unidirectional streams
+	ctxCancel context.CancelCauseFunc
+
 	flowController flowcontrol.StreamFlowController
 	version        protocol.VersionNumber
 }
 
 var _ ReceiveStream = &receiveStream{}
 var _ receiveStreamI = &receiveStream{}
+var _ io.WriterTo = &receiveStream{}
 
 func newReceiveStream(
 	streamID protocol.StreamID,
 	sender streamSender,
 	flowController flowcontrol.StreamFlowController,
+	limiter *outOfOrderDataLimiter,
 	version protocol.VersionNumber,
 ) *receiveStream {
 	return &receiveStream{
 		streamID:       streamID,
 		sender:         sender,
 		flowController: flowController,
+		limiter:        limiter,
 		frameQueue:     newFrameSorter(),
 		readChan:       make(chan struct{}, 1),
 		finalOffset:    protocol.MaxByteCount,
@@ -79,7 +105,7 @@ func (s *receiveStream) StreamID() protocol.StreamID {
 // Read implements io.Reader. It is not thread safe!
 func (s *receiveStream) Read(p []byte) (int, error) {
 	s.mutex.Lock()
-	completed, n, err := s.readImpl(p)
+	completed, n, err := s.readImpl(p, len(p))
 	s.mutex.Unlock()
 
 	if completed {
@@ -88,108 +114,313 @@ func (s *receiveStream) Read(p []byte) (int, error) {
 	return n, err
 }
 
-func (s *receiveStream) readImpl(p []byte) (bool /*stream completed */, int, error) {
+// Discard skips the next n bytes, without copying them.
+// It is not thread safe!
+func (s *receiveStream) Discard(n int) (int, error) {
+	if n < 0 {
+		return 0, errNegativeCount
+	}
+	s.mutex.Lock()
+	completed, discarded, err := s.readImpl(nil, n)
+	s.mutex.Unlock()
+
+	if completed {
+		s.streamCompleted()
+	}
+	return discarded, err
+}
+
+// readImpl reads up to n bytes into p, returning as soon as some data was read.
+// If p is nil, the data is discarded, and readImpl only returns once n bytes were discarded.
+func (s *receiveStream) readImpl(p []byte, n int) (bool /*stream completed */, int, error) {
 	if s.finRead {
 		return false, 0, io.EOF
 	}
 	if s.canceledRead {
 		return false, 0, s.cancelReadErr
 	}
-	if s.resetRemotely {
-		return false, 0, s.resetRemotelyErr
-	}
 	if s.closedForShutdown {
 		return false, 0, s.closeForShutdownErr
 	}
 
 	bytesRead := 0
-	for bytesRead < len(p) {
+	for bytesRead < n {
 		if s.currentFrame == nil || s.readPosInFrame >= len(s.currentFrame) {
 			s.dequeueNextFrame()
 		}
-		if s.currentFrame == nil && bytesRead > 0 {
+		if s.currentFrame == nil && bytesRead > 0 && p != nil {
 			return false, bytesRead, s.closeForShutdownErr
 		}
 
-		var deadlineTimer *utils.Timer
-		for {
-			// Stop waiting on errors
-			if s.closedForShutdown {
-				return false, bytesRead, s.closeForShutdownErr
-			}
-			if s.canceledRead {
-				return false, bytesRead, s.cancelReadErr
-			}
-			if s.resetRemotely {
-				return false, bytesRead, s.resetRemotelyErr
-			}
-
-			deadline := s.deadline
-			if !deadline.IsZero() {
-				if !time.Now().Before(deadline) {
-					return false, bytesRead, errDeadline
-				}
-				if deadlineTimer == nil {
-					deadlineTimer = utils.NewTimer()
-				}
-				deadlineTimer.Reset(deadline)
-			}
-
-			if s.currentFrame != nil || s.currentFrameIsLast {
-				break
-			}
-
-			s.mutex.Unlock()
-			if deadline.IsZero() {
-				<-s.readChan
-			} else {
-				select {
-				case <-s.readChan:
-				case <-deadlineTimer.Chan():
-					deadlineTimer.SetRead()
-				}
-			}
-			s.mutex.Lock()
-			if s.currentFrame == nil {
-				s.dequeueNextFrame()
-			}
+		if err := s.waitForFrame(); err != nil {
+			return false, bytesRead, err
 		}
 
-		if bytesRead > len(p) {
-			return false, bytesRead, fmt.Errorf("BUG: bytesRead (%d) > len(p) (%d) in stream.Read", bytesRead, len(p))
+		if bytesRead > n {
+			return false, bytesRead, fmt.Errorf("BUG: bytesRead (%d) > n (%d) in stream.Read", bytesRead, n)
 		}
 		if s.readPosInFrame > len(s.currentFrame) {
 			return false, bytesRead, fmt.Errorf("BUG: readPosInFrame (%d) > frame.DataLen (%d) in stream.Read", s.readPosInFrame, len(s.currentFrame))
 		}
 
+		s.readingCurrentFrame = true
 		s.mutex.Unlock()
 
-		m := copy(p[bytesRead:], s.currentFrame[s.readPosInFrame:])
+		m := utils.Min(n-bytesRead, len(s.currentFrame)-s.readPosInFrame)
+		if p != nil {
+			copy(p[bytesRead:], s.currentFrame[s.readPosInFrame:s.readPosInFrame+m])
+		}
 		s.readPosInFrame += m
 		bytesRead += m
-		s.readOffset += protocol.ByteCount(m)
 
 		s.mutex.Lock()
+		s.readingCurrentFrame = false
+		if s.closedForShutdown {
+			s.discardCurrentFrame()
+			return false, bytesRead, s.closeForShutdownErr
+		}
+		s.readOffset += protocol.ByteCount(m)
 		// when a RESET_STREAM was received, the was already informed about the final byteOffset for this stream
 		if !s.resetRemotely {
 			s.flowController.AddBytesRead(protocol.ByteCount(m))
 		}
 
-		if s.readPosInFrame >= len(s.currentFrame) && s.currentFrameIsLast {
-			s.finRead = true
-			return true, bytesRead, io.EOF
+		if s.readPosInFrame >= len(s.currentFrame) {
+			// release the data as soon as it was read
+			s.releaseCurrentFrame()
+			if s.currentFrameIsLast {
+				if s.resetRemotely {
+					return false, bytesRead, s.resetRemotelyErr
+				}
+				s.finRead = true
+				return true, bytesRead, io.EOF
+			}
 		}
 	}
 	return false, bytesRead, nil
 }
 
+// Peek returns up to n bytes of received data, without consuming it.
+// It is not thread safe!
+func (s *receiveStream) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errNegativeCount
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.finRead {
+		return nil, io.EOF
+	}
+	if s.canceledRead {
+		return nil, s.cancelReadErr
+	}
+	if s.closedForShutdown {
+		return nil, s.closeForShutdownErr
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	if s.currentFrame == nil || s.readPosInFrame >= len(s.currentFrame) {
+		s.dequeueNextFrame()
+	}
+	if err := s.waitForFrame(); err != nil {
+		return nil, err
+	}
+	if len(s.currentFrame)-s.readPosInFrame < n && !s.currentFrameIsLast {
+		s.coalesceFrames(n)
+	}
+	data := s.currentFrame[s.readPosInFrame:]
+	if len(data) >= n {
+		return data[:n], nil
+	}
+	if !s.currentFrameIsLast {
+		return data, nil
+	}
+	// Peek doesn't consume the FIN. It is consumed by the Read reading the last byte.
+	if s.resetRemotely {
+		return data, s.resetRemotelyErr
+	}
+	return data, io.EOF
+}
+
+// coalesceFrames appends the data of the frames following the currentFrame,
+// until at least n bytes can be read from the currentFrame, or no more data is available.
+// It must only be called from the go routine reading from the stream.
+func (s *receiveStream) coalesceFrames(n int) {
+	data := s.currentFrame[s.readPosInFrame:]
+	var coalesced []byte
+	for len(data) < n {
+		_, next, buffer := s.frameQueue.Pop()
+		if next == nil {
+			break
+		}
+		if coalesced == nil {
+			coalesced = make([]byte, 0, len(data)+len(next))
+			coalesced = append(coalesced, data...)
+		}
+		coalesced = append(coalesced, next...)
+		if buffer != nil {
+			buffer.Release()
+		}
+		data = coalesced
+	}
+	if coalesced == nil {
+		return
+	}
+	s.releaseCurrentFrame()
+	s.currentFrame = coalesced
+	s.readPosInFrame = 0
+	s.currentFrameIsLast = s.readOffset+protocol.ByteCount(len(coalesced)) >= s.finalOffset
+}
+
+// WriteTo implements io.WriterTo. It is not thread safe!
+// It writes the received data directly to w, without copying it into an intermediate buffer.
+// It returns when the FIN was read, or when an error occurs.
+func (s *receiveStream) WriteTo(w io.Writer) (int64, error) {
+	s.mutex.Lock()
+	completed, n, err := s.writeToImpl(w)
+	s.mutex.Unlock()
+
+	if completed {
+		s.streamCompleted()
+	}
+	return n, err
+}
+
+func (s *receiveStream) writeToImpl(w io.Writer) (bool /*stream completed */, int64, error) {
+	if s.finRead {
+		return false, 0, nil
+	}
+	if s.canceledRead {
+		return false, 0, s.cancelReadErr
+	}
+	if s.closedForShutdown {
+		return false, 0, s.closeForShutdownErr
+	}
+
+	var bytesWritten int64
+	for {
+		if s.currentFrame == nil || s.readPosInFrame >= len(s.currentFrame) {
+			s.dequeueNextFrame()
+		}
+		if err := s.waitForFrame(); err != nil {
+			return false, bytesWritten, err
+		}
+
+		var m int
+		var err error
+		if data := s.currentFrame[s.readPosInFrame:]; len(data) > 0 {
+			s.readingCurrentFrame = true
+			s.mutex.Unlock()
+			m, err = w.Write(data)
+			s.mutex.Lock()
+			s.readingCurrentFrame = false
+		}
+		if s.closedForShutdown {
+			s.discardCurrentFrame()
+			return false, bytesWritten + int64(m), s.closeForShutdownErr
+		}
+		s.readPosInFrame += m
+		bytesWritten += int64(m)
+		s.readOffset += protocol.ByteCount(m)
+
+		// when a RESET_STREAM was received, the flow controller was already informed about the final byteOffset for this stream
+		if !s.resetRemotely {
+			s.flowController.AddBytesRead(protocol.ByteCount(m))
+		}
+		if err != nil {
+			return false, bytesWritten, err
+		}
+
+		if s.readPosInFrame >= len(s.currentFrame) {
+			s.releaseCurrentFrame()
+			if s.currentFrameIsLast {
+				if s.resetRemotely {
+					return false, bytesWritten, s.resetRemotelyErr
+				}
+				s.finRead = true
+				return true, bytesWritten, nil
+			}
+		}
+	}
+}
+
+// waitForFrame blocks until a frame is available for reading.
+// It returns an error if the stream was canceled, reset or closed, or if the deadline expired.
+func (s *receiveStream) waitForFrame() error {
+	var deadlineTimer *utils.Timer
+	for {
+		// Stop waiting on errors
+		if s.closedForShutdown {
+			return s.closeForShutdownErr
+		}
+		if s.canceledRead {
+			return s.cancelReadErr
+		}
+		// data received before the RESET_STREAM can still be read
+		if s.resetRemotely && s.currentFrame == nil {
+			return s.resetRemotelyErr
+		}
+
+		deadline := s.deadline
+		if !deadline.IsZero() {
+			if !time.Now().Before(deadline) {
+				return errDeadline
+			}
+			if deadlineTimer == nil {
+				deadlineTimer = utils.NewTimer()
+			}
+			deadlineTimer.Reset(deadline)
+		}
+
+		if s.currentFrame != nil || s.currentFrameIsLast {
+			return nil
+		}
+
+		s.mutex.Unlock()
+		if deadline.IsZero() {
+			<-s.readChan
+		} else {
+			select {
+			case <-s.readChan:
+			case <-deadlineTimer.Chan():
+				deadlineTimer.SetRead()
+			}
+		}
+		s.mutex.Lock()
+		if s.currentFrame == nil {
+			s.dequeueNextFrame()
+		}
+	}
+}
+
 func (s *receiveStream) dequeueNextFrame() {
+	s.releaseCurrentFrame()
 	var offset protocol.ByteCount
-	offset, s.currentFrame = s.frameQueue.Pop()
+	offset, s.currentFrame, s.currentFrameBuffer = s.frameQueue.Pop()
 	s.currentFrameIsLast = offset+protocol.ByteCount(len(s.currentFrame)) >= s.finalOffset
 	s.readPosInFrame = 0
 }
 
+// releaseCurrentFrame releases the data of the currentFrame.
+// It must not be called while Read or WriteTo access the data without holding the mutex.
+func (s *receiveStream) releaseCurrentFrame() {
+	if s.currentFrameBuffer != nil {
+		s.currentFrameBuffer.Release()
+		s.currentFrameBuffer = nil
+	}
+}
+
+// discardCurrentFrame releases the currentFrame, without reading the remaining data.
+// It must be called with the mutex held, and not while Read or WriteTo access the currentFrame.
+func (s *receiveStream) discardCurrentFrame() {
+	s.releaseCurrentFrame()
+	s.currentFrame = nil
+	s.readPosInFrame = 0
+}
+
 func (s *receiveStream) CancelRead(errorCode protocol.ApplicationErrorCode) {
 	s.mutex.Lock()
 	completed := s.cancelReadImpl(errorCode)
@@ -205,7 +436,11 @@ func (s *receiveStream) cancelReadImpl(errorCode protocol.ApplicationErrorCode)
 		return false
 	}
 	s.canceledRead = true
-	s.cancelReadErr = fmt.Errorf("Read on stream %d canceled with error code %d", s.streamID, errorCode)
+	s.frameQueue.Discard()
+	s.cancelReadErr = streamCanceledError{
+		errorCode: errorCode,
+		error:     fmt.Errorf("Read on stream %d canceled with error code %d", s.streamID, errorCode),
+	}
 	s.signalRead()
 	s.sender.queueControlFrame(&wire.StopSendingFrame{
 		StreamID:  s.streamID,
@@ -215,9 +450,29 @@ func (s *receiveStream) cancelReadImpl(errorCode protocol.ApplicationErrorCode)
 	return s.finalOffset != protocol.MaxByteCount
 }
 
-func (s *receiveStream) handleStreamFrame(frame *wire.StreamFrame) error {
+// checkOutOfOrderData checks if the data of a STREAM frame can be buffered without exceeding the out-of-order data limit,
+// without buffering it. pending is the amount of out-of-order data that other frames of the same packet will add.
+// It returns the amount of out-of-order data this frame adds.
+func (s *receiveStream) checkOutOfOrderData(frame *wire.StreamFrame, pending protocol.ByteCount) (protocol.ByteCount, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.canceledRead || s.outOfOrderDataReleased {
+		return 0, nil
+	}
+	n := s.frameQueue.NewOutOfOrderBytes(frame.Data, frame.Offset)
+	if n > 0 && !s.limiter.Allow(s.outOfOrderBytes, pending+n) {
+		return 0, errTooMuchOutOfOrderData
+	}
+	return n, nil
+}
+
+// handleStreamFrame handles a STREAM frame.
+// If buffer is non-nil, the frame data references the packet buffer,
+// and the packet buffer is retained until the data was read (or discarded).
+func (s *receiveStream) handleStreamFrame(frame *wire.StreamFrame, buffer *packetBuffer) error {
 	s.mutex.Lock()
-	completed, err := s.handleStreamFrameImpl(frame)
+	completed, err := s.handleStreamFrameImpl(frame, buffer)
 	s.mutex.Unlock()
 
 	if completed {
@@ -226,7 +481,7 @@ func (s *receiveStream) handleStreamFrame(frame *wire.StreamFrame) error {
 	return err
 }
 
-func (s *receiveStream) handleStreamFrameImpl(frame *wire.StreamFrame) (bool /* completed */, error) {
+func (s *receiveStream) handleStreamFrameImpl(frame *wire.StreamFrame, buffer *packetBuffer) (bool /* completed */, error) {
 	maxOffset := frame.Offset + frame.DataLen()
 	if err := s.flowController.UpdateHighestReceived(maxOffset, frame.FinBit); err != nil {
 		return false, err
@@ -237,13 +492,43 @@ func (s *receiveStream) handleStreamFrameImpl(frame *wire.StreamFrame) (bool /*
 	if s.canceledRead {
 		return frame.FinBit, nil
 	}
-	if err := s.frameQueue.Push(frame.Data, frame.Offset); err != nil {
+	if !s.outOfOrderDataReleased {
+		if n := s.frameQueue.NewOutOfOrderBytes(frame.Data, frame.Offset); n > 0 && !s.limiter.Allow(s.outOfOrderBytes, n) {
+			return false, errTooMuchOutOfOrderData
+		}
+	}
+	if err := s.frameQueue.Push(frame.Data, frame.Offset, buffer); err != nil {
 		return false, err
 	}
+	s.updateOutOfOrderBytes()
 	s.signalRead()
 	return false, nil
 }
 
+// updateOutOfOrderBytes reports the amount of out-of-order data to the limiter.
+// It must be called with the mutex held.
+func (s *receiveStream) updateOutOfOrderBytes() {
+	if s.outOfOrderDataReleased {
+		return
+	}
+	n := s.frameQueue.OutOfOrderBytes()
+	s.limiter.Update(s.outOfOrderBytes, n)
+	s.outOfOrderBytes = n
+}
+
+// releaseOutOfOrderData releases the out-of-order data from the limiter.
+// Out-of-order data received after this is not accounted for any more,
+// since the stream is about to be deleted.
+// It must be called with the mutex held.
+func (s *receiveStream) releaseOutOfOrderData() {
+	if s.outOfOrderDataReleased {
+		return
+	}
+	s.limiter.Update(s.outOfOrderBytes, 0)
+	s.outOfOrderBytes = 0
+	s.outOfOrderDataReleased = true
+}
+
 func (s *receiveStream) handleResetStreamFrame(frame *wire.ResetStreamFrame) error {
 	s.mutex.Lock()
 	completed, err := s.handleResetStreamFrameImpl(frame)
@@ -272,13 +557,17 @@ func (s *receiveStream) handleResetStreamFrameImpl(frame *wire.ResetStreamFrame)
 	s.resetRemotelyErr = streamCanceledError{
 		errorCode: frame.ErrorCode,
 		error:     fmt.Errorf("Stream %d was reset with error code %d", s.streamID, frame.ErrorCode),
+		remote:    true,
+	}
+	if s.ctxCancel != nil {
+		s.ctxCancel(s.resetRemotelyErr)
 	}
 	s.signalRead()
 	return true, nil
 }
 
 func (s *receiveStream) CloseRemote(offset protocol.ByteCount) {
-	s.handleStreamFrame(&wire.StreamFrame{FinBit: true, Offset: offset})
+	s.handleStreamFrame(&wire.StreamFrame{FinBit: true, Offset: offset}, nil)
 }
 
 func (s *receiveStream) SetReadDeadline(t time.Time) error {
@@ -296,10 +585,49 @@ func (s *receiveStream) closeForShutdown(err error) {
 	s.mutex.Lock()
 	s.closedForShutdown = true
 	s.closeForShutdownErr = err
+	s.frameQueue.Discard()
+	// If Read or WriteTo is accessing the current frame, it is released as soon as they return.
+	if !s.readingCurrentFrame {
+		s.discardCurrentFrame()
+	}
+	s.releaseOutOfOrderData()
 	s.mutex.Unlock()
 	s.signalRead()
 }
 
+func (s *receiveStream) ReceiveStreamState() ReceiveStreamState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case s.finRead:
+		return ReceiveStreamDataRead
+	case s.resetRemotely:
+		return ReceiveStreamResetReceived
+	case s.finalOffset != protocol.MaxByteCount:
+		return ReceiveStreamFinReceived
+	default:
+		return ReceiveStreamOpen
+	}
+}
+
+func (s *receiveStream) bytesRead() protocol.ByteCount {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.readOffset
+}
+
+// bytesBuffered returns the number of bytes received, that weren't read by the application yet.
+func (s *receiveStream) bytesBuffered() protocol.ByteCount {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	buffered := s.frameQueue.BufferedBytes()
+	if s.readPosInFrame < len(s.currentFrame) {
+		buffered += protocol.ByteCount(len(s.currentFrame) - s.readPosInFrame)
+	}
+	return buffered
+}
+
 func (s *receiveStream) getWindowUpdate() protocol.ByteCount {
 	return s.flowController.GetWindowUpdate()
 }
@@ -307,6 +635,7 @@ func (s *receiveStream) getWindowUpdate() protocol.ByteCount {
 func (s *receiveStream) streamCompleted() {
 	s.mutex.Lock()
 	finRead := s.finRead
+	s.releaseOutOfOrderData()
 	s.mutex.Unlock()
 
 	if !finRead {
@@ -1,14 +1,20 @@
 package quic
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"runtime"
+	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/lucas-clemente/quic-go/internal/congestion"
+	"github.com/lucas-clemente/quic-go/internal/flowcontrol"
 	"github.com/lucas-clemente/quic-go/internal/mocks"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 
 	. "github.com/onsi/ginkgo"
@@ -29,7 +35,7 @@ var _ = Describe("Receive Stream", func() {
 	BeforeEach(func() {
 		mockSender = NewMockStreamSender(mockCtrl)
 		mockFC = mocks.NewMockStreamFlowController(mockCtrl)
-		str = newReceiveStream(streamID, mockSender, mockFC, protocol.VersionWhatever)
+		str = newReceiveStream(streamID, mockSender, mockFC, newOutOfOrderDataLimiter(protocol.MaxByteCount, protocol.MaxByteCount), protocol.VersionWhatever)
 
 		timeout := scaleDuration(250 * time.Millisecond)
 		strWithTimeout = gbytes.TimeoutReader(str, timeout)
@@ -47,7 +53,7 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 0,
 				Data:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
 			}
-			err := str.handleStreamFrame(&frame)
+			err := str.handleStreamFrame(&frame, nil)
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 4)
 			n, err := strWithTimeout.Read(b)
@@ -64,7 +70,7 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 0,
 				Data:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
 			}
-			err := str.handleStreamFrame(&frame)
+			err := str.handleStreamFrame(&frame, nil)
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 2)
 			n, err := strWithTimeout.Read(b)
@@ -89,9 +95,9 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 2,
 				Data:   []byte{0xBE, 0xEF},
 			}
-			err := str.handleStreamFrame(&frame1)
+			err := str.handleStreamFrame(&frame1, nil)
 			Expect(err).ToNot(HaveOccurred())
-			err = str.handleStreamFrame(&frame2)
+			err = str.handleStreamFrame(&frame2, nil)
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 6)
 			n, err := strWithTimeout.Read(b)
@@ -112,9 +118,9 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 2,
 				Data:   []byte{0xBE, 0xEF},
 			}
-			err := str.handleStreamFrame(&frame1)
+			err := str.handleStreamFrame(&frame1, nil)
 			Expect(err).ToNot(HaveOccurred())
-			err = str.handleStreamFrame(&frame2)
+			err = str.handleStreamFrame(&frame2, nil)
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 4)
 			n, err := strWithTimeout.Read(b)
@@ -130,7 +136,7 @@ var _ = Describe("Receive Stream", func() {
 				defer GinkgoRecover()
 				frame := wire.StreamFrame{Data: []byte{0xDE, 0xAD}}
 				time.Sleep(10 * time.Millisecond)
-				err := str.handleStreamFrame(&frame)
+				err := str.handleStreamFrame(&frame, nil)
 				Expect(err).ToNot(HaveOccurred())
 			}()
 			b := make([]byte, 2)
@@ -151,9 +157,9 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 0,
 				Data:   []byte{0xDE, 0xAD},
 			}
-			err := str.handleStreamFrame(&frame1)
+			err := str.handleStreamFrame(&frame1, nil)
 			Expect(err).ToNot(HaveOccurred())
-			err = str.handleStreamFrame(&frame2)
+			err = str.handleStreamFrame(&frame2, nil)
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 4)
 			n, err := strWithTimeout.Read(b)
@@ -179,11 +185,11 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 2,
 				Data:   []byte{0xBE, 0xEF},
 			}
-			err := str.handleStreamFrame(&frame1)
+			err := str.handleStreamFrame(&frame1, nil)
 			Expect(err).ToNot(HaveOccurred())
-			err = str.handleStreamFrame(&frame2)
+			err = str.handleStreamFrame(&frame2, nil)
 			Expect(err).ToNot(HaveOccurred())
-			err = str.handleStreamFrame(&frame3)
+			err = str.handleStreamFrame(&frame3, nil)
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 4)
 			n, err := strWithTimeout.Read(b)
@@ -205,9 +211,9 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 2,
 				Data:   []byte("obar"),
 			}
-			err := str.handleStreamFrame(&frame1)
+			err := str.handleStreamFrame(&frame1, nil)
 			Expect(err).ToNot(HaveOccurred())
-			err = str.handleStreamFrame(&frame2)
+			err = str.handleStreamFrame(&frame2, nil)
 			Expect(err).ToNot(HaveOccurred())
 			b := make([]byte, 6)
 			n, err := strWithTimeout.Read(b)
@@ -226,7 +232,7 @@ var _ = Describe("Receive Stream", func() {
 			It("returns an error when Read is called after the deadline", func() {
 				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), false).AnyTimes()
 				f := &wire.StreamFrame{Data: []byte("foobar")}
-				err := str.handleStreamFrame(f)
+				err := str.handleStreamFrame(f, nil)
 				Expect(err).ToNot(HaveOccurred())
 				str.SetReadDeadline(time.Now().Add(-time.Second))
 				b := make([]byte, 6)
@@ -324,6 +330,279 @@ var _ = Describe("Receive Stream", func() {
 			})
 		})
 
+		Context("writing to an io.Writer", func() {
+			It("writes all data until the FIN", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(3), false)
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), true)
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(3)).Times(2)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foo")}, nil)).To(Succeed())
+				done := make(chan struct{})
+				buf := &bytes.Buffer{}
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.WriteTo(buf)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(BeEquivalentTo(6))
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{
+					Offset: 3,
+					Data:   []byte("bar"),
+					FinBit: true,
+				}, nil)).To(Succeed())
+				Eventually(done).Should(BeClosed())
+				Expect(buf.String()).To(Equal("foobar"))
+				// subsequent calls don't write anything
+				n, err := str.WriteTo(buf)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(BeZero())
+			})
+
+			It("passes the frame data to the io.Writer without copying it", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(4), true)
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(4))
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				data := []byte{0xde, 0xad, 0xbe, 0xef}
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: data, FinBit: true}, nil)).To(Succeed())
+				w := &recordingWriter{}
+				_, err := str.WriteTo(w)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(w.writes).To(HaveLen(1))
+				Expect(&w.writes[0][0]).To(Equal(&data[0]))
+			})
+
+			It("returns the error of the io.Writer", func() {
+				testErr := errors.New("test error")
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(4), false)
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(2))
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foob")}, nil)).To(Succeed())
+				n, err := str.WriteTo(&recordingWriter{maxLen: 2, err: testErr})
+				Expect(err).To(MatchError(testErr))
+				Expect(n).To(BeEquivalentTo(2))
+				// the remaining data can still be read
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(2))
+				b := make([]byte, 2)
+				_, err = strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b).To(Equal([]byte("ob")))
+			})
+
+			It("returns a StreamError when the stream is reset", func() {
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.WriteTo(&bytes.Buffer{})
+					Expect(err).To(MatchError("Stream 1337 was reset with error code 1234"))
+					Expect(err).To(BeAssignableToTypeOf(streamCanceledError{}))
+					Expect(n).To(BeZero())
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(42), true)
+				mockFC.EXPECT().Abandon()
+				Expect(str.handleResetStreamFrame(&wire.ResetStreamFrame{
+					StreamID:   streamID,
+					ByteOffset: 42,
+					ErrorCode:  1234,
+				})).To(Succeed())
+				Eventually(done).Should(BeClosed())
+			})
+		})
+
+		Context("peeking", func() {
+			It("returns data without consuming it", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), false)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foobar")}, nil)).To(Succeed())
+				data, err := str.Peek(3)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foo")))
+				data, err = str.Peek(10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+				// flow control credit is only released when the data is read
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(6))
+				b := make([]byte, 6)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(6))
+				Expect(b).To(Equal([]byte("foobar")))
+			})
+
+			It("returns data spanning multiple STREAM frames", func() {
+				buf := getPacketBuffer()
+				copy(buf.Slice, "foobar")
+				mockFC.EXPECT().UpdateHighestReceived(gomock.Any(), false).Times(3)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: buf.Slice[:3]}, buf)).To(Succeed())
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 3, Data: buf.Slice[3:6]}, buf)).To(Succeed())
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 6, Data: []byte("baz")}, nil)).To(Succeed())
+				buf.Release()
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(1))
+				_, err := strWithTimeout.Read(make([]byte, 1))
+				Expect(err).ToNot(HaveOccurred())
+				data, err := str.Peek(4)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("ooba")))
+				// the data was copied, so the packet buffer was released
+				Expect(buf.refCount).To(BeZero())
+				data, err = str.Peek(100)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("oobarbaz")))
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(8))
+				b := make([]byte, 8)
+				_, err = strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b).To(Equal([]byte("oobarbaz")))
+			})
+
+			It("doesn't return data after a gap", func() {
+				mockFC.EXPECT().UpdateHighestReceived(gomock.Any(), false).Times(2)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foo")}, nil)).To(Succeed())
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 4, Data: []byte("bar")}, nil)).To(Succeed())
+				data, err := str.Peek(10)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foo")))
+			})
+
+			It("blocks until data is received", func() {
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					data, err := str.Peek(3)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(data).To(Equal([]byte("fo")))
+					close(done)
+				}()
+				Consistently(done).ShouldNot(BeClosed())
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(2), false)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("fo")}, nil)).To(Succeed())
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("respects the read deadline", func() {
+				str.SetReadDeadline(time.Now().Add(scaleDuration(20 * time.Millisecond)))
+				_, err := str.Peek(3)
+				Expect(err).To(MatchError(errDeadline))
+			})
+
+			It("returns io.EOF if the stream ends before n bytes, without consuming the FIN", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), true)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foobar"), FinBit: true}, nil)).To(Succeed())
+				data, err := str.Peek(6)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+				data, err = str.Peek(10)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(data).To(Equal([]byte("foobar")))
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(6))
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				b := make([]byte, 10)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(Equal(6))
+				data, err = str.Peek(10)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(data).To(BeEmpty())
+			})
+
+			It("returns the data received before a RESET_STREAM, together with the error", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(3), false)
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(3), true)
+				mockFC.EXPECT().Abandon()
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foo")}, nil)).To(Succeed())
+				Expect(str.handleResetStreamFrame(&wire.ResetStreamFrame{
+					StreamID:   streamID,
+					ByteOffset: 3,
+					ErrorCode:  1234,
+				})).To(Succeed())
+				data, err := str.Peek(10)
+				Expect(err).To(MatchError("Stream 1337 was reset with error code 1234"))
+				Expect(data).To(Equal([]byte("foo")))
+			})
+
+			It("errors when reading was canceled", func() {
+				mockSender.EXPECT().queueControlFrame(gomock.Any())
+				str.CancelRead(1234)
+				_, err := str.Peek(3)
+				Expect(err).To(MatchError("Read on stream 1337 canceled with error code 1234"))
+			})
+
+			It("errors for negative counts", func() {
+				_, err := str.Peek(-1)
+				Expect(err).To(MatchError(errNegativeCount))
+			})
+		})
+
+		Context("discarding", func() {
+			It("discards data, and releases flow control credit for it", func() {
+				mockFC.EXPECT().UpdateHighestReceived(gomock.Any(), false).Times(2)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foo")}, nil)).To(Succeed())
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 3, Data: []byte("bar")}, nil)).To(Succeed())
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(3))
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(1))
+				n, err := str.Discard(4)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(4))
+				Expect(str.bytesRead()).To(Equal(protocol.ByteCount(4)))
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(2))
+				b := make([]byte, 2)
+				_, err = strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b).To(Equal([]byte("ar")))
+			})
+
+			It("blocks until enough data was discarded", func() {
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.Discard(6)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(Equal(6))
+					close(done)
+				}()
+				mockFC.EXPECT().UpdateHighestReceived(gomock.Any(), false).Times(2)
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(3)).Times(2)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foo")}, nil)).To(Succeed())
+				Consistently(done).ShouldNot(BeClosed())
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 3, Data: []byte("bar")}, nil)).To(Succeed())
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("returns io.EOF when discarding the last byte", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), true)
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(6))
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foobar"), FinBit: true}, nil)).To(Succeed())
+				n, err := str.Discard(10)
+				Expect(err).To(MatchError(io.EOF))
+				Expect(n).To(Equal(6))
+			})
+
+			It("discards data after peeking", func() {
+				mockFC.EXPECT().UpdateHighestReceived(gomock.Any(), false).Times(2)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("fo")}, nil)).To(Succeed())
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 2, Data: []byte("obar")}, nil)).To(Succeed())
+				data, err := str.Peek(3)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foo")))
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(3))
+				n, err := str.Discard(3)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(3))
+				data, err = str.Peek(3)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("bar")))
+			})
+
+			It("errors for negative counts", func() {
+				_, err := str.Discard(-1)
+				Expect(err).To(MatchError(errNegativeCount))
+			})
+		})
+
 		Context("closing", func() {
 			Context("with FIN bit", func() {
 				It("returns EOFs", func() {
@@ -333,7 +612,7 @@ var _ = Describe("Receive Stream", func() {
 						Offset: 0,
 						Data:   []byte{0xDE, 0xAD, 0xBE, 0xEF},
 						FinBit: true,
-					})
+					}, nil)
 					mockSender.EXPECT().onStreamCompleted(streamID)
 					b := make([]byte, 4)
 					n, err := strWithTimeout.Read(b)
@@ -358,9 +637,9 @@ var _ = Describe("Receive Stream", func() {
 						Offset: 0,
 						Data:   []byte{0xDE, 0xAD},
 					}
-					err := str.handleStreamFrame(&frame1)
+					err := str.handleStreamFrame(&frame1, nil)
 					Expect(err).ToNot(HaveOccurred())
-					err = str.handleStreamFrame(&frame2)
+					err = str.handleStreamFrame(&frame2, nil)
 					Expect(err).ToNot(HaveOccurred())
 					mockSender.EXPECT().onStreamCompleted(streamID)
 					b := make([]byte, 4)
@@ -380,7 +659,7 @@ var _ = Describe("Receive Stream", func() {
 						Offset: 0,
 						Data:   []byte{0xde, 0xad},
 						FinBit: true,
-					})
+					}, nil)
 					Expect(err).ToNot(HaveOccurred())
 					mockSender.EXPECT().onStreamCompleted(streamID)
 					b := make([]byte, 4)
@@ -396,7 +675,7 @@ var _ = Describe("Receive Stream", func() {
 					err := str.handleStreamFrame(&wire.StreamFrame{
 						Offset: 0,
 						FinBit: true,
-					})
+					}, nil)
 					Expect(err).ToNot(HaveOccurred())
 					mockSender.EXPECT().onStreamCompleted(streamID)
 					b := make([]byte, 4)
@@ -469,6 +748,16 @@ var _ = Describe("Receive Stream", func() {
 				Expect(err).To(MatchError("Read on stream 1337 canceled with error code 1234"))
 			})
 
+			It("returns a StreamError with the error code", func() {
+				mockSender.EXPECT().queueControlFrame(gomock.Any())
+				str.CancelRead(1234)
+				_, err := strWithTimeout.Read([]byte{0})
+				Expect(err).To(BeAssignableToTypeOf(streamCanceledError{}))
+				Expect(err.(StreamError).Canceled()).To(BeTrue())
+				Expect(err.(StreamError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(1234)))
+				Expect(err.(StreamError).Remote()).To(BeFalse())
+			})
+
 			It("does nothing when CancelRead is called twice", func() {
 				mockSender.EXPECT().queueControlFrame(gomock.Any())
 				str.CancelRead(1234)
@@ -493,7 +782,7 @@ var _ = Describe("Receive Stream", func() {
 					StreamID: streamID,
 					Data:     []byte("foobar"),
 					FinBit:   true,
-				})).To(Succeed())
+				}, nil)).To(Succeed())
 				mockSender.EXPECT().onStreamCompleted(streamID)
 				_, err := strWithTimeout.Read(make([]byte, 100))
 				Expect(err).To(MatchError(io.EOF))
@@ -518,7 +807,7 @@ var _ = Describe("Receive Stream", func() {
 				Expect(str.handleStreamFrame(&wire.StreamFrame{
 					Offset: 1000,
 					FinBit: true,
-				})).To(Succeed())
+				}, nil)).To(Succeed())
 				mockFC.EXPECT().Abandon()
 				mockSender.EXPECT().queueControlFrame(gomock.Any())
 				mockSender.EXPECT().onStreamCompleted(streamID)
@@ -536,7 +825,7 @@ var _ = Describe("Receive Stream", func() {
 				Expect(str.handleStreamFrame(&wire.StreamFrame{
 					Offset: 1000,
 					FinBit: true,
-				})).To(Succeed())
+				}, nil)).To(Succeed())
 			})
 		})
 
@@ -580,6 +869,57 @@ var _ = Describe("Receive Stream", func() {
 				Expect(err).To(BeAssignableToTypeOf(streamCanceledError{}))
 				Expect(err.(streamCanceledError).Canceled()).To(BeTrue())
 				Expect(err.(streamCanceledError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(1234)))
+				Expect(err.(streamCanceledError).Remote()).To(BeTrue())
+			})
+
+			It("returns a StreamError that can be extracted with errors.As", func() {
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(42), true)
+				mockFC.EXPECT().Abandon()
+				Expect(str.handleResetStreamFrame(rst)).To(Succeed())
+				_, err := strWithTimeout.Read([]byte{0})
+				var streamErr StreamError
+				Expect(errors.As(fmt.Errorf("wrapped: %w", err), &streamErr)).To(BeTrue())
+				Expect(streamErr.ErrorCode()).To(Equal(protocol.ApplicationErrorCode(1234)))
+				Expect(streamErr.Remote()).To(BeTrue())
+			})
+
+			It("returns data received before the RESET_STREAM, and then the StreamError", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), false)
+				mockFC.EXPECT().AddBytesRead(protocol.ByteCount(2))
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foobar")}, nil)).To(Succeed())
+				b := make([]byte, 2)
+				_, err := strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b).To(Equal([]byte("fo")))
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(42), true)
+				mockFC.EXPECT().Abandon()
+				Expect(str.handleResetStreamFrame(rst)).To(Succeed())
+				// the flow controller was already informed about the final offset, so no call to AddBytesRead
+				b = make([]byte, 10)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(b[:n]).To(Equal([]byte("obar")))
+				_, err = strWithTimeout.Read(b)
+				Expect(err).To(MatchError("Stream 1337 was reset with error code 1234"))
+			})
+
+			It("returns the StreamError when the data up to the final offset was read", func() {
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), false)
+				Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foobar")}, nil)).To(Succeed())
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), true)
+				mockFC.EXPECT().Abandon()
+				Expect(str.handleResetStreamFrame(&wire.ResetStreamFrame{
+					StreamID:   streamID,
+					ByteOffset: 6,
+					ErrorCode:  1234,
+				})).To(Succeed())
+				b := make([]byte, 10)
+				n, err := strWithTimeout.Read(b)
+				Expect(err).To(MatchError("Stream 1337 was reset with error code 1234"))
+				Expect(b[:n]).To(Equal([]byte("foobar")))
 			})
 
 			It("errors when receiving a RESET_STREAM with an inconsistent offset", func() {
@@ -605,6 +945,176 @@ var _ = Describe("Receive Stream", func() {
 		})
 	})
 
+	Context("releasing packet buffers", func() {
+		var buf *packetBuffer
+
+		BeforeEach(func() {
+			buf = getPacketBuffer()
+			copy(buf.Slice, "foobar")
+			mockFC.EXPECT().UpdateHighestReceived(gomock.Any(), gomock.Any()).AnyTimes()
+		})
+
+		It("retains the packet buffer until the data was read", func() {
+			mockFC.EXPECT().AddBytesRead(gomock.Any()).Times(2)
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: buf.Slice[:200]}, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(2))
+			buf.Release() // the session is done processing the packet
+			b := make([]byte, 100)
+			_, err := strWithTimeout.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:6]).To(Equal([]byte("foobar")))
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+			_, err = strWithTimeout.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buf.refCount).To(BeZero())
+		})
+
+		It("retains the packet buffer once for every frame", func() {
+			mockFC.EXPECT().AddBytesRead(gomock.Any()).AnyTimes()
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: buf.Slice[:200]}, buf)).To(Succeed())
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 200, Data: buf.Slice[200:400]}, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(3))
+			buf.Release()
+			b := make([]byte, 400)
+			_, err := io.ReadFull(strWithTimeout, b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:6]).To(Equal([]byte("foobar")))
+			Expect(buf.refCount).To(BeZero())
+		})
+
+		It("copies the data of small frames, instead of retaining the packet buffer", func() {
+			mockFC.EXPECT().AddBytesRead(gomock.Any())
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: buf.Slice[:6]}, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+			buf.Slice[0] = 'x'
+			b := make([]byte, 6)
+			_, err := strWithTimeout.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(Equal([]byte("foobar")))
+		})
+
+		It("doesn't retain the packet buffer for duplicate data", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foobar")}, nil)).To(Succeed())
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: buf.Slice[:6]}, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+		})
+
+		It("releases the packet buffer when reading is canceled", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: buf.Slice[:200]}, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(2))
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			str.CancelRead(1234)
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+		})
+
+		It("releases the packet buffer when the stream is closed for shutdown", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: buf.Slice[:200]}, buf)).To(Succeed())
+			Expect(buf.refCount).To(BeEquivalentTo(2))
+			str.closeForShutdown(errors.New("shutdown"))
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+		})
+
+		It("releases the packet buffer of a partially read frame when the stream is closed for shutdown", func() {
+			mockFC.EXPECT().AddBytesRead(gomock.Any())
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: buf.Slice[:200]}, buf)).To(Succeed())
+			_, err := strWithTimeout.Read(make([]byte, 100))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buf.refCount).To(BeEquivalentTo(2))
+			str.closeForShutdown(errors.New("shutdown"))
+			Expect(buf.refCount).To(BeEquivalentTo(1))
+			Expect(str.currentFrame).To(BeNil())
+		})
+	})
+
+	Context("limiting out-of-order data", func() {
+		var limiter *outOfOrderDataLimiter
+
+		BeforeEach(func() {
+			limiter = newOutOfOrderDataLimiter(10, 15)
+			str.limiter = limiter
+			mockFC.EXPECT().UpdateHighestReceived(gomock.Any(), gomock.Any()).AnyTimes()
+		})
+
+		It("accounts for out-of-order data", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 4, Data: []byte("foobar")}, nil)).To(Succeed())
+			Expect(str.outOfOrderBytes).To(Equal(protocol.ByteCount(6)))
+			Expect(limiter.buffered).To(Equal(protocol.ByteCount(6)))
+			// filling the gap makes the data readable
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("0123")}, nil)).To(Succeed())
+			Expect(str.outOfOrderBytes).To(BeZero())
+			Expect(limiter.buffered).To(BeZero())
+		})
+
+		It("rejects out-of-order data beyond the limit of the stream", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, nil)).To(Succeed())
+			err := str.handleStreamFrame(&wire.StreamFrame{Offset: 20, Data: []byte("raboof")}, nil)
+			Expect(err).To(MatchError(errTooMuchOutOfOrderData))
+			Expect(str.outOfOrderBytes).To(Equal(protocol.ByteCount(6)))
+			Expect(limiter.buffered).To(Equal(protocol.ByteCount(6)))
+		})
+
+		It("rejects out-of-order data beyond the limit of the connection", func() {
+			limiter.Update(0, 10)
+			err := str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, nil)
+			Expect(err).To(MatchError(errTooMuchOutOfOrderData))
+			Expect(str.outOfOrderBytes).To(BeZero())
+		})
+
+		It("checks the limit without buffering the data", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("foo")}, nil)).To(Succeed())
+			n, err := str.checkOutOfOrderData(&wire.StreamFrame{Offset: 20, Data: []byte("bar")}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(protocol.ByteCount(3)))
+			Expect(str.outOfOrderBytes).To(Equal(protocol.ByteCount(3)))
+			Expect(limiter.buffered).To(Equal(protocol.ByteCount(3)))
+			// data that fills the first gap is never out of order
+			n, err = str.checkOutOfOrderData(&wire.StreamFrame{Data: []byte("0123456789")}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(BeZero())
+		})
+
+		It("takes pending out-of-order data of the same packet into account", func() {
+			_, err := str.checkOutOfOrderData(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, 4)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.checkOutOfOrderData(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, 5)
+			Expect(err).To(MatchError(errTooMuchOutOfOrderData))
+		})
+
+		It("only counts data that wasn't received yet", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, nil)).To(Succeed())
+			// a retransmission that overlaps with the data received before
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 8, Data: []byte("xxfoobarxx")}, nil)).To(Succeed())
+			Expect(str.outOfOrderBytes).To(Equal(protocol.ByteCount(10)))
+			Expect(limiter.buffered).To(Equal(protocol.ByteCount(10)))
+		})
+
+		It("always accepts data that fills the first gap", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, nil)).To(Succeed())
+			limiter.Update(0, 100)
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("0123456789")}, nil)).To(Succeed())
+			Expect(str.outOfOrderBytes).To(BeZero())
+			Expect(limiter.buffered).To(Equal(protocol.ByteCount(100)))
+		})
+
+		It("releases the out-of-order data when the stream is closed for shutdown", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, nil)).To(Succeed())
+			Expect(limiter.buffered).To(Equal(protocol.ByteCount(6)))
+			str.closeForShutdown(errors.New("shutdown"))
+			Expect(limiter.buffered).To(BeZero())
+			// data received afterwards isn't accounted for
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 20, Data: []byte("foobar")}, nil)).To(Succeed())
+			Expect(limiter.buffered).To(BeZero())
+		})
+
+		It("releases the out-of-order data when the stream is completed", func() {
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("foobar")}, nil)).To(Succeed())
+			mockFC.EXPECT().Abandon()
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.streamCompleted()
+			Expect(limiter.buffered).To(BeZero())
+		})
+	})
+
 	Context("flow control", func() {
 		It("errors when a STREAM frame causes a flow control violation", func() {
 			testErr := errors.New("flow control violation")
@@ -613,7 +1123,7 @@ var _ = Describe("Receive Stream", func() {
 				Offset: 2,
 				Data:   []byte("foobar"),
 			}
-			err := str.handleStreamFrame(&frame)
+			err := str.handleStreamFrame(&frame, nil)
 			Expect(err).To(MatchError(testErr))
 		})
 
@@ -622,4 +1132,124 @@ var _ = Describe("Receive Stream", func() {
 			Expect(str.getWindowUpdate()).To(Equal(protocol.ByteCount(0x100)))
 		})
 	})
+
+	Context("stream state", func() {
+		It("is open until the FIN is received", func() {
+			Expect(str.ReceiveStreamState()).To(Equal(ReceiveStreamOpen))
+			mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(3), false)
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foo")}, nil)).To(Succeed())
+			Expect(str.ReceiveStreamState()).To(Equal(ReceiveStreamOpen))
+		})
+
+		It("says when the FIN was received, and when all data was read", func() {
+			mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), true)
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 3, Data: []byte("bar"), FinBit: true}, nil)).To(Succeed())
+			Expect(str.ReceiveStreamState()).To(Equal(ReceiveStreamFinReceived))
+			mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(3), false)
+			Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foo")}, nil)).To(Succeed())
+			mockFC.EXPECT().AddBytesRead(protocol.ByteCount(3)).Times(2)
+			b := make([]byte, 3)
+			_, err := strWithTimeout.Read(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.ReceiveStreamState()).To(Equal(ReceiveStreamFinReceived))
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			_, err = strWithTimeout.Read(b)
+			Expect(err).To(MatchError(io.EOF))
+			Expect(str.ReceiveStreamState()).To(Equal(ReceiveStreamDataRead))
+		})
+
+		It("says when the stream was reset", func() {
+			mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(42), true)
+			mockFC.EXPECT().Abandon()
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			Expect(str.handleResetStreamFrame(&wire.ResetStreamFrame{
+				StreamID:   streamID,
+				ByteOffset: 42,
+				ErrorCode:  1234,
+			})).To(Succeed())
+			Expect(str.ReceiveStreamState()).To(Equal(ReceiveStreamResetReceived))
+		})
+
+		It("doesn't change the state when reading is canceled", func() {
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			str.CancelRead(1234)
+			Expect(str.ReceiveStreamState()).To(Equal(ReceiveStreamOpen))
+		})
+	})
 })
+
+type recordingWriter struct {
+	writes [][]byte
+	maxLen int
+	err    error
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.maxLen > 0 && len(p) > w.maxLen {
+		p = p[:w.maxLen]
+	}
+	w.writes = append(w.writes, p)
+	return len(p), w.err
+}
+
+// benchmarkReceivePath measures the receive path for STREAM frames:
+// parsing the frame from the packet, handling it in the receive stream, and reading the data.
+func benchmarkReceivePath(b *testing.B, noCopy bool) {
+	const streamID protocol.StreamID = 4
+	rttStats := &congestion.RTTStats{}
+	connFC := flowcontrol.NewConnectionFlowController(protocol.MaxByteCount, protocol.MaxByteCount, func() {}, congestion.DefaultClock{}, rttStats, utils.DefaultLogger)
+	str := newReceiveStream(
+		streamID,
+		nil,
+		flowcontrol.NewStreamFlowController(streamID, connFC, protocol.MaxByteCount, protocol.MaxByteCount, 0, func(protocol.StreamID) {}, congestion.DefaultClock{}, rttStats, utils.DefaultLogger),
+		newOutOfOrderDataLimiter(protocol.MaxByteCount, protocol.MaxByteCount),
+		protocol.VersionWhatever,
+	)
+	const dataLen = 1200
+	frameBuf := &bytes.Buffer{}
+	p := make([]byte, dataLen)
+	f := &wire.StreamFrame{StreamID: streamID, Data: p}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frameBuf.Reset()
+		f.Offset = protocol.ByteCount(i * dataLen)
+		if err := f.Write(frameBuf, protocol.VersionWhatever); err != nil {
+			b.Fatal(err)
+		}
+		buffer := getPacketBuffer()
+		data := buffer.Slice[:copy(buffer.Slice, frameBuf.Bytes())]
+		r := bytes.NewReader(data)
+		var frame wire.Frame
+		var err error
+		if noCopy {
+			frame, err = wire.ParseNextFrameNoCopy(r, data, protocol.VersionWhatever)
+		} else {
+			frame, err = wire.ParseNextFrame(r, protocol.VersionWhatever)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		if noCopy {
+			err = str.handleStreamFrame(frame.(*wire.StreamFrame), buffer)
+		} else {
+			err = str.handleStreamFrame(frame.(*wire.StreamFrame), nil)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		buffer.Release()
+		if _, err := io.ReadFull(str, p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReceivePathCopy(b *testing.B) {
+	benchmarkReceivePath(b, false)
+}
+
+func BenchmarkReceivePathPacketBuffers(b *testing.B) {
+	benchmarkReceivePath(b, true)
+}
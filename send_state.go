@@ -0,0 +1,50 @@
+package quic
+
+import "time"
+
+func (s SendState) String() string {
+	switch s {
+	case SendStateApplicationLimited:
+		return "application limited"
+	case SendStateFlowControlBlocked:
+		return "flow control blocked"
+	case SendStateCongestionLimited:
+		return "congestion limited"
+	default:
+		return "invalid send state"
+	}
+}
+
+// The sendStateTracker keeps track of the time spent in every SendState.
+// It is not safe for concurrent use.
+type sendStateTracker struct {
+	state     SendState
+	since     time.Time
+	durations [3]time.Duration // indexed by the SendState
+}
+
+func newSendStateTracker(now time.Time) sendStateTracker {
+	return sendStateTracker{since: now}
+}
+
+// Set sets the current state.
+func (t *sendStateTracker) Set(state SendState, now time.Time) {
+	if state == t.state {
+		return
+	}
+	t.durations[t.state] += now.Sub(t.since)
+	t.state = state
+	t.since = now
+}
+
+// State returns the current state.
+func (t *sendStateTracker) State() SendState {
+	return t.state
+}
+
+// Get returns the current state, and the cumulative time spent in every state, indexed by the SendState.
+func (t *sendStateTracker) Get(now time.Time) (SendState, [3]time.Duration) {
+	durations := t.durations
+	durations[t.state] += now.Sub(t.since)
+	return t.state, durations
+}
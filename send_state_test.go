@@ -0,0 +1,42 @@
+package quic
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Send State", func() {
+	It("has a string representation", func() {
+		Expect(SendStateApplicationLimited.String()).To(Equal("application limited"))
+		Expect(SendStateFlowControlBlocked.String()).To(Equal("flow control blocked"))
+		Expect(SendStateCongestionLimited.String()).To(Equal("congestion limited"))
+		Expect(SendState(42).String()).To(Equal("invalid send state"))
+	})
+
+	It("keeps track of the time spent in every state", func() {
+		start := time.Now()
+		t := newSendStateTracker(start)
+		Expect(t.State()).To(Equal(SendStateApplicationLimited))
+		t.Set(SendStateCongestionLimited, start.Add(time.Second))
+		t.Set(SendStateFlowControlBlocked, start.Add(3*time.Second))
+		t.Set(SendStateFlowControlBlocked, start.Add(4*time.Second)) // no change
+		t.Set(SendStateCongestionLimited, start.Add(7*time.Second))
+		t.Set(SendStateApplicationLimited, start.Add(8*time.Second))
+		state, durations := t.Get(start.Add(10 * time.Second))
+		Expect(state).To(Equal(SendStateApplicationLimited))
+		Expect(durations[SendStateApplicationLimited]).To(Equal(3 * time.Second))
+		Expect(durations[SendStateFlowControlBlocked]).To(Equal(4 * time.Second))
+		Expect(durations[SendStateCongestionLimited]).To(Equal(3 * time.Second))
+	})
+
+	It("doesn't modify the durations when getting them", func() {
+		start := time.Now()
+		t := newSendStateTracker(start)
+		_, durations := t.Get(start.Add(time.Second))
+		Expect(durations[SendStateApplicationLimited]).To(Equal(time.Second))
+		_, durations = t.Get(start.Add(2 * time.Second))
+		Expect(durations[SendStateApplicationLimited]).To(Equal(2 * time.Second))
+	})
+})
@@ -2,16 +2,24 @@ package quic
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/flowcontrol"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 )
 
+var (
+	errTryWriteUnbuffered = errors.New("TryWrite requires Config.MaxStreamSendBuffer")
+	errTryWriteRetained   = errors.New("TryWrite can't be used when the stream retains data")
+)
+
 type sendStreamI interface {
 	SendStream
 	handleStopSendingFrame(*wire.StopSendingFrame)
@@ -19,13 +27,17 @@ type sendStreamI interface {
 	popStreamFrame(maxBytes protocol.ByteCount) (*wire.StreamFrame, bool)
 	closeForShutdown(error)
 	handleMaxStreamDataFrame(*wire.MaxStreamDataFrame)
+	getPriority() int
+	onStreamFrameAcked(*wire.StreamFrame)
+	onStreamFrameLost(*wire.StreamFrame)
+	stats() StreamStats
 }
 
 type sendStream struct {
 	mutex sync.Mutex
 
 	ctx       context.Context
-	ctxCancel context.CancelFunc
+	ctxCancel context.CancelCauseFunc
 
 	streamID protocol.StreamID
 	sender   streamSender
@@ -39,34 +51,76 @@ type sendStream struct {
 	finishedWriting   bool // set once Close() is called
 	canceledWrite     bool // set when CancelWrite() is called, or a STOP_SENDING frame is received
 	finSent           bool // set when a STREAM_FRAME with FIN bit has b
+	completed         bool // set when the FIN was sent, and all data was acknowledged
+
+	// the number of STREAM frames that were sent, and are neither acknowledged nor lost
+	numOutstandingFrames int
+	// the data that was declared lost, sorted by offset.
+	// Adjacent ranges are coalesced, such that they can be sent in a single STREAM frame.
+	retransmissionQueue []*wire.StreamFrame
 
 	dataForWriting []byte
+	// the remaining slices passed to WriteVectors, that will be sent after dataForWriting
+	moreDataForWriting [][]byte
+	// set if dataForWriting was allocated by the stream itself (in ReadFrom, or when buffering data).
+	// Then it's not necessary to copy the data when packing STREAM frames.
+	ownsDataForWriting bool
+	// If set, data is copied to dataForWriting (up to maxSendBuffer bytes), and Write returns once all data was copied.
+	// Otherwise, dataForWriting is the slice passed to Write, and Write returns once all data was sent.
+	maxSendBuffer protocol.ByteCount
+
+	// If set, the slices passed to Write are not copied, but referenced until all data sent from them was acknowledged.
+	retainData bool
+	release    func([]byte)
+	// the slices passed to Write that are still referenced, sorted by offset
+	retainedData []*retainedData
 
 	writeChan chan struct{}
 	deadline  time.Time
+	priority  int
+
+	bytesAcked         protocol.ByteCount
+	bytesRetransmitted protocol.ByteCount
+	flowControlBlocked bool // set when there's data for writing, but the stream is blocked by flow control
+	sendState          sendStateTracker
+	clock              congestion.Clock
 
 	flowController flowcontrol.StreamFlowController
 
 	version protocol.VersionNumber
 }
 
+// retainedData is a slice passed to Write, if the stream doesn't copy the data.
+type retainedData struct {
+	data   []byte
+	offset protocol.ByteCount // the stream offset of the first byte
+	length protocol.ByteCount // the number of bytes of data that will be sent
+	acked  protocol.ByteCount
+}
+
 var _ SendStream = &sendStream{}
 var _ sendStreamI = &sendStream{}
+var _ io.ReaderFrom = &sendStream{}
 
 func newSendStream(
 	streamID protocol.StreamID,
 	sender streamSender,
 	flowController flowcontrol.StreamFlowController,
+	maxSendBuffer protocol.ByteCount,
+	clock congestion.Clock,
 	version protocol.VersionNumber,
 ) *sendStream {
 	s := &sendStream{
 		streamID:       streamID,
 		sender:         sender,
 		flowController: flowController,
+		maxSendBuffer:  maxSendBuffer,
 		writeChan:      make(chan struct{}, 1),
+		sendState:      newSendStateTracker(clock.Now()),
+		clock:          clock,
 		version:        version,
 	}
-	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.ctx, s.ctxCancel = context.WithCancelCause(context.Background())
 	return s
 }
 
@@ -75,17 +129,66 @@ func (s *sendStream) StreamID() protocol.StreamID {
 }
 
 func (s *sendStream) Write(p []byte) (int, error) {
+	return s.write(p, false)
+}
+
+// ReadFrom implements io.ReaderFrom.
+// If a Read fills most of the read buffer, the buffer is directly used for sending STREAM frames,
+// saving one copy compared to calling Write. Otherwise, the read buffer is reused for the next Read.
+func (s *sendStream) ReadFrom(r io.Reader) (int64, error) {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	retainData := s.retainData
+	s.mutex.Unlock()
 
-	if s.finishedWriting {
-		return 0, fmt.Errorf("write on closed stream %d", s.streamID)
-	}
-	if s.canceledWrite {
-		return 0, s.cancelWriteErr
+	var (
+		written int64
+		b       []byte
+	)
+	for {
+		if b == nil {
+			b = make([]byte, protocol.ReadFromChunkSize)
+		}
+		n, readErr := r.Read(b)
+		if n > 0 {
+			var (
+				m   int
+				err error
+			)
+			if protocol.ByteCount(n) >= protocol.MinReadFromOwnedSize {
+				m, err = s.write(b[:n], true)
+				b = nil // the stream now owns the buffer
+			} else if retainData {
+				// Retained data is referenced until it is acknowledged, so b can't be reused.
+				m, err = s.write(append([]byte(nil), b[:n]...), true)
+			} else {
+				// The data is copied before write returns.
+				m, err = s.write(b[:n], false)
+			}
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
 	}
-	if s.closeForShutdownErr != nil {
-		return 0, s.closeForShutdownErr
+}
+
+// write writes p to the stream.
+// If owned is set, p must not be modified by the caller after write returns.
+func (s *sendStream) write(p []byte, owned bool) (int, error) {
+	var released [][]byte
+	defer func() { s.releaseRetainedData(released) }() // runs after the mutex was unlocked
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.updateSendState()
+
+	if err := s.checkWritable(); err != nil {
+		return 0, err
 	}
 	if !s.deadline.IsZero() && !time.Now().Before(s.deadline) {
 		return 0, errDeadline
@@ -93,8 +196,116 @@ func (s *sendStream) Write(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
+	if s.isBuffered() {
+		return s.writeBuffered(p)
+	}
+	if s.retainData && !owned {
+		var (
+			n   int
+			err error
+		)
+		n, released, err = s.writeRetained([][]byte{p}, len(p))
+		return n, err
+	}
 
 	s.dataForWriting = p
+	s.ownsDataForWriting = owned
+	return s.waitUntilSent(len(p))
+}
+
+func (s *sendStream) WriteVectors(data [][]byte) (int, error) {
+	var released [][]byte
+	defer func() { s.releaseRetainedData(released) }() // runs after the mutex was unlocked
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.updateSendState()
+
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	if !s.deadline.IsZero() && !time.Now().Before(s.deadline) {
+		return 0, errDeadline
+	}
+	vectors := make([][]byte, 0, len(data))
+	var length int
+	for _, b := range data {
+		if len(b) > 0 {
+			vectors = append(vectors, b)
+			length += len(b)
+		}
+	}
+	if length == 0 {
+		return 0, nil
+	}
+	if s.isBuffered() {
+		var bytesWritten int
+		for _, b := range vectors {
+			n, err := s.writeBuffered(b)
+			bytesWritten += n
+			if err != nil {
+				return bytesWritten, err
+			}
+		}
+		return bytesWritten, nil
+	}
+	if s.retainData {
+		var (
+			n   int
+			err error
+		)
+		n, released, err = s.writeRetained(vectors, length)
+		return n, err
+	}
+
+	s.dataForWriting = vectors[0]
+	s.moreDataForWriting = vectors[1:]
+	s.ownsDataForWriting = false
+	return s.waitUntilSent(length)
+}
+
+// writeRetained sends the data without copying it.
+// Every slice is referenced by the STREAM frames sent from it, until all of them were acknowledged.
+// It returns the slices that are not referenced any more, since none of their data was sent.
+// It must be called with the mutex held.
+func (s *sendStream) writeRetained(vectors [][]byte, length int) (int, [][]byte /* released */, error) {
+	offset := s.writeOffset
+	for _, b := range vectors {
+		s.retainedData = append(s.retainedData, &retainedData{
+			data:   b,
+			offset: offset,
+			length: protocol.ByteCount(len(b)),
+		})
+		offset += protocol.ByteCount(len(b))
+	}
+	s.dataForWriting = vectors[0]
+	s.moreDataForWriting = vectors[1:]
+	s.ownsDataForWriting = true
+	n, err := s.waitUntilSent(length)
+	if n == length {
+		return n, nil, err
+	}
+	// The remaining data won't be sent.
+	var released [][]byte
+	retained := s.retainedData[:0]
+	for _, r := range s.retainedData {
+		if r.offset+r.length > s.writeOffset {
+			r.length = utils.MaxByteCount(r.offset, s.writeOffset) - r.offset
+		}
+		if r.acked == r.length {
+			released = append(released, r.data)
+			continue
+		}
+		retained = append(retained, r)
+	}
+	s.retainedData = retained
+	return n, released, err
+}
+
+// waitUntilSent blocks until the data passed to Write (or WriteVectors) was sent,
+// the deadline expires, or the stream is canceled.
+// It must be called with the mutex held.
+func (s *sendStream) waitUntilSent(length int) (int, error) {
+	s.updateSendState()
 
 	var (
 		deadlineTimer  *utils.Timer
@@ -102,11 +313,12 @@ func (s *sendStream) Write(p []byte) (int, error) {
 		notifiedSender bool
 	)
 	for {
-		bytesWritten = len(p) - len(s.dataForWriting)
+		bytesWritten = length - int(s.bytesQueued())
 		deadline := s.deadline
 		if !deadline.IsZero() {
 			if !time.Now().Before(deadline) {
 				s.dataForWriting = nil
+				s.moreDataForWriting = nil
 				return bytesWritten, errDeadline
 			}
 			if deadlineTimer == nil {
@@ -136,29 +348,181 @@ func (s *sendStream) Write(p []byte) (int, error) {
 	}
 
 	if s.closeForShutdownErr != nil {
+		s.dataForWriting = nil
+		s.moreDataForWriting = nil
 		return bytesWritten, s.closeForShutdownErr
 	} else if s.cancelWriteErr != nil {
+		// Don't retain the application's data after Write returned.
+		// It won't be sent anyway, since the RESET_STREAM was already queued.
+		s.dataForWriting = nil
+		s.moreDataForWriting = nil
 		return bytesWritten, s.cancelWriteErr
 	}
 	return bytesWritten, nil
 }
 
-// popStreamFrame returns the next STREAM frame that is supposed to be sent on this stream
-// maxBytes is the maximum length this frame (including frame header) will have.
-func (s *sendStream) popStreamFrame(maxBytes protocol.ByteCount) (*wire.StreamFrame, bool /* has more data to send */) {
+// bytesQueued returns the number of bytes that were written, but not sent yet.
+// It must be called with the mutex held.
+func (s *sendStream) bytesQueued() protocol.ByteCount {
+	n := protocol.ByteCount(len(s.dataForWriting))
+	for _, b := range s.moreDataForWriting {
+		n += protocol.ByteCount(len(b))
+	}
+	return n
+}
+
+// writeBuffered copies p to the send buffer.
+// If the send buffer is full, it blocks until enough data was sent.
+// It must be called with the mutex held.
+func (s *sendStream) writeBuffered(p []byte) (int, error) {
+	var (
+		deadlineTimer *utils.Timer
+		bytesWritten  int
+	)
+	for {
+		if s.canceledWrite || s.closedForShutdown {
+			break
+		}
+		if n := s.bufferData(p[bytesWritten:]); n > 0 {
+			bytesWritten += n
+			s.updateSendState()
+			s.mutex.Unlock()
+			s.sender.onHasStreamData(s.streamID) // must be called without holding the mutex
+			s.mutex.Lock()
+		}
+		if bytesWritten == len(p) {
+			break
+		}
+		deadline := s.deadline
+		if !deadline.IsZero() {
+			if !time.Now().Before(deadline) {
+				return bytesWritten, errDeadline
+			}
+			if deadlineTimer == nil {
+				deadlineTimer = utils.NewTimer()
+			}
+			deadlineTimer.Reset(deadline)
+		}
+
+		s.mutex.Unlock()
+		if deadline.IsZero() {
+			<-s.writeChan
+		} else {
+			select {
+			case <-s.writeChan:
+			case <-deadlineTimer.Chan():
+				deadlineTimer.SetRead()
+			}
+		}
+		s.mutex.Lock()
+	}
+
+	if s.closeForShutdownErr != nil {
+		return bytesWritten, s.closeForShutdownErr
+	} else if s.cancelWriteErr != nil {
+		return bytesWritten, s.cancelWriteErr
+	}
+	return bytesWritten, nil
+}
+
+func (s *sendStream) TryWrite(p []byte) (int, error) {
 	s.mutex.Lock()
-	completed, frame, hasMoreData := s.popStreamFrameImpl(maxBytes)
+	if err := s.checkWritable(); err != nil {
+		s.mutex.Unlock()
+		return 0, err
+	}
+	if s.retainData {
+		s.mutex.Unlock()
+		return 0, errTryWriteRetained
+	}
+	if !s.isBuffered() {
+		s.mutex.Unlock()
+		return 0, errTryWriteUnbuffered
+	}
+	n := s.bufferData(p)
+	s.updateSendState()
 	s.mutex.Unlock()
 
-	if completed {
-		s.sender.onStreamCompleted(s.streamID)
+	if n > 0 {
+		s.sender.onHasStreamData(s.streamID) // must be called without holding the mutex
+	}
+	return n, nil
+}
+
+func (s *sendStream) SetWriteBufferOwnership(ownership BufferOwnership, release func([]byte)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.writeOffset > 0 || s.dataForWriting != nil || s.finishedWriting {
+		return fmt.Errorf("can't change the buffer ownership of stream %d after writing data", s.streamID)
 	}
-	return frame, hasMoreData
+	switch ownership {
+	case BufferOwnershipCopied:
+		s.retainData = false
+		s.release = nil
+	case BufferOwnershipRetained:
+		if release == nil {
+			return errors.New("retaining data requires a release function")
+		}
+		s.retainData = true
+		s.release = release
+	default:
+		return fmt.Errorf("invalid buffer ownership: %d", ownership)
+	}
+	return nil
 }
 
-func (s *sendStream) popStreamFrameImpl(maxBytes protocol.ByteCount) (bool /* completed */, *wire.StreamFrame, bool /* has more data to send */) {
+// isBuffered says if written data is copied to the send buffer.
+// It must be called with the mutex held.
+func (s *sendStream) isBuffered() bool {
+	return s.maxSendBuffer > 0 && !s.retainData
+}
+
+// checkWritable returns an error if no more data can be written to the stream.
+// It must be called with the mutex held.
+func (s *sendStream) checkWritable() error {
+	if s.finishedWriting {
+		return fmt.Errorf("write on closed stream %d", s.streamID)
+	}
+	if s.canceledWrite {
+		return s.cancelWriteErr
+	}
+	return s.closeForShutdownErr
+}
+
+// bufferData copies as much of p to the send buffer as fits.
+// It returns the number of bytes copied.
+// It must be called with the mutex held.
+func (s *sendStream) bufferData(p []byte) int {
+	buffered := protocol.ByteCount(len(s.dataForWriting))
+	if buffered >= s.maxSendBuffer {
+		return 0
+	}
+	n := int(utils.MinByteCount(protocol.ByteCount(len(p)), s.maxSendBuffer-buffered))
+	s.dataForWriting = append(s.dataForWriting, p[:n]...)
+	s.ownsDataForWriting = true
+	return n
+}
+
+// popStreamFrame returns the next STREAM frame that is supposed to be sent on this stream
+// maxBytes is the maximum length this frame (including frame header) will have.
+func (s *sendStream) popStreamFrame(maxBytes protocol.ByteCount) (*wire.StreamFrame, bool /* has more data to send */) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer s.updateSendState()
+
 	if s.canceledWrite || s.closeForShutdownErr != nil {
-		return false, nil, false
+		return nil, false
+	}
+
+	// Lost data is retransmitted before any new data is sent.
+	if len(s.retransmissionQueue) > 0 {
+		frame := s.getRetransmission(maxBytes)
+		if frame != nil {
+			s.numOutstandingFrames++
+		}
+		hasMoreData := len(s.retransmissionQueue) > 0 || s.dataForWriting != nil || (s.finishedWriting && !s.finSent)
+		return frame, hasMoreData
 	}
 
 	frame := &wire.StreamFrame{
@@ -168,7 +532,7 @@ func (s *sendStream) popStreamFrameImpl(maxBytes protocol.ByteCount) (bool /* co
 	}
 	maxDataLen := frame.MaxDataLen(maxBytes, s.version)
 	if maxDataLen == 0 { // a STREAM frame must have at least one byte of data
-		return false, nil, s.dataForWriting != nil
+		return nil, s.dataForWriting != nil
 	}
 	frame.Data, frame.FinBit = s.getDataForWriting(maxDataLen)
 	if len(frame.Data) == 0 && !frame.FinBit {
@@ -177,26 +541,50 @@ func (s *sendStream) popStreamFrameImpl(maxBytes protocol.ByteCount) (bool /* co
 		// - there's data for writing, but the stream is stream-level flow control blocked
 		// - there's data for writing, but the stream is connection-level flow control blocked
 		if s.dataForWriting == nil {
-			return false, nil, false
+			return nil, false
 		}
+		s.flowControlBlocked = true
 		if isBlocked, offset := s.flowController.IsNewlyBlocked(); isBlocked {
 			s.sender.queueControlFrame(&wire.StreamDataBlockedFrame{
 				StreamID:  s.streamID,
 				DataLimit: offset,
 			})
-			return false, nil, false
+			return nil, false
 		}
-		return false, nil, true
+		return nil, true
 	}
+	s.numOutstandingFrames++
 	if frame.FinBit {
 		s.finSent = true
 	}
-	return frame.FinBit, frame, s.dataForWriting != nil
+	return frame, s.dataForWriting != nil
+}
+
+// getRetransmission returns a STREAM frame containing the first range of lost data.
+// Retransmissions don't consume any flow control credit, since the data was already sent before.
+func (s *sendStream) getRetransmission(maxBytes protocol.ByteCount) *wire.StreamFrame {
+	f := s.retransmissionQueue[0]
+	if f.MaxDataLen(maxBytes, s.version) == 0 {
+		return nil
+	}
+	frame, err := f.MaybeSplitOffFrame(maxBytes, s.version)
+	if err != nil { // can't happen, we checked that at least one byte fits
+		return nil
+	}
+	if frame != nil {
+		return frame
+	}
+	s.retransmissionQueue[0] = nil
+	s.retransmissionQueue = s.retransmissionQueue[1:]
+	if len(s.retransmissionQueue) == 0 {
+		s.retransmissionQueue = nil
+	}
+	return f
 }
 
 func (s *sendStream) hasData() bool {
 	s.mutex.Lock()
-	hasData := len(s.dataForWriting) > 0
+	hasData := len(s.dataForWriting) > 0 || len(s.retransmissionQueue) > 0
 	s.mutex.Unlock()
 	return hasData
 }
@@ -212,21 +600,66 @@ func (s *sendStream) getDataForWriting(maxBytes protocol.ByteCount) ([]byte, boo
 	}
 
 	var ret []byte
-	if protocol.ByteCount(len(s.dataForWriting)) > maxBytes {
-		ret = make([]byte, int(maxBytes))
-		copy(ret, s.dataForWriting[:maxBytes])
+	if len(s.moreDataForWriting) > 0 && !s.retainData {
+		ret = s.getVectoredDataForWriting(maxBytes)
+	} else if protocol.ByteCount(len(s.dataForWriting)) > maxBytes {
+		if s.ownsDataForWriting {
+			ret = s.dataForWriting[:maxBytes:maxBytes]
+		} else {
+			ret = make([]byte, int(maxBytes))
+			copy(ret, s.dataForWriting[:maxBytes])
+		}
 		s.dataForWriting = s.dataForWriting[maxBytes:]
+		if s.isBuffered() { // space in the send buffer was freed
+			s.signalWrite()
+		}
 	} else {
-		ret = make([]byte, len(s.dataForWriting))
-		copy(ret, s.dataForWriting)
+		if s.ownsDataForWriting {
+			ret = s.dataForWriting
+		} else {
+			ret = make([]byte, len(s.dataForWriting))
+			copy(ret, s.dataForWriting)
+		}
 		s.dataForWriting = nil
-		s.signalWrite()
+		if len(s.moreDataForWriting) > 0 {
+			// When retaining data, every STREAM frame references a single slice passed to WriteVectors.
+			s.dataForWriting = s.moreDataForWriting[0]
+			s.moreDataForWriting = s.moreDataForWriting[1:]
+		} else {
+			s.moreDataForWriting = nil
+			s.signalWrite()
+		}
 	}
 	s.writeOffset += protocol.ByteCount(len(ret))
+	s.flowControlBlocked = false
 	s.flowController.AddBytesSent(protocol.ByteCount(len(ret)))
 	return ret, s.finishedWriting && s.dataForWriting == nil && !s.finSent
 }
 
+// getVectoredDataForWriting copies up to maxBytes from the slices passed to WriteVectors.
+// The data returned can span multiple slices.
+func (s *sendStream) getVectoredDataForWriting(maxBytes protocol.ByteCount) []byte {
+	ret := make([]byte, 0, utils.MinByteCount(maxBytes, s.bytesQueued()))
+	for protocol.ByteCount(len(ret)) < maxBytes && s.dataForWriting != nil {
+		n := utils.MinByteCount(maxBytes-protocol.ByteCount(len(ret)), protocol.ByteCount(len(s.dataForWriting)))
+		ret = append(ret, s.dataForWriting[:n]...)
+		s.dataForWriting = s.dataForWriting[n:]
+		if len(s.dataForWriting) > 0 {
+			continue
+		}
+		s.dataForWriting = nil
+		if len(s.moreDataForWriting) > 0 {
+			s.dataForWriting = s.moreDataForWriting[0]
+			s.moreDataForWriting = s.moreDataForWriting[1:]
+		}
+	}
+	if s.dataForWriting == nil {
+		s.moreDataForWriting = nil
+		s.signalWrite()
+	}
+	return ret
+}
+
 func (s *sendStream) Close() error {
 	s.mutex.Lock()
 	if s.canceledWrite {
@@ -234,19 +667,29 @@ func (s *sendStream) Close() error {
 		return fmt.Errorf("Close called for canceled stream %d", s.streamID)
 	}
 	s.finishedWriting = true
+	s.updateSendState()
 	s.mutex.Unlock()
 
 	s.sender.onHasStreamData(s.streamID) // need to send the FIN, must be called without holding the mutex
-	s.ctxCancel()
+	s.ctxCancel(nil)
 	return nil
 }
 
 func (s *sendStream) CancelWrite(errorCode protocol.ApplicationErrorCode) {
 	s.mutex.Lock()
-	completed := s.cancelWriteImpl(errorCode, fmt.Errorf("Write on stream %d canceled with error code %d", s.streamID, errorCode))
+	writeErr := streamCanceledError{
+		errorCode: errorCode,
+		error:     fmt.Errorf("Write on stream %d canceled with error code %d", s.streamID, errorCode),
+	}
+	completed := s.cancelWriteImpl(errorCode, writeErr)
+	var released [][]byte
+	if completed {
+		released = s.popRetainedData()
+	}
 	s.mutex.Unlock()
 
 	if completed {
+		s.releaseRetainedData(released)
 		s.sender.onStreamCompleted(s.streamID) // must be called without holding the mutex
 	}
 }
@@ -258,14 +701,19 @@ func (s *sendStream) cancelWriteImpl(errorCode protocol.ApplicationErrorCode, wr
 	}
 	s.canceledWrite = true
 	s.cancelWriteErr = writeErr
+	// Lost data is not retransmitted after a RESET_STREAM.
+	s.retransmissionQueue = nil
+	if s.isBuffered() { // the buffered data won't be sent anymore
+		s.dataForWriting = nil
+	}
+	s.updateSendState()
 	s.signalWrite()
 	s.sender.queueControlFrame(&wire.ResetStreamFrame{
 		StreamID:   s.streamID,
 		ByteOffset: s.writeOffset,
 		ErrorCode:  errorCode,
 	})
-	// TODO(#991): cancel retransmissions for this stream
-	s.ctxCancel()
+	s.ctxCancel(writeErr)
 	return true
 }
 
@@ -283,9 +731,14 @@ func (s *sendStream) handleMaxStreamDataFrame(frame *wire.MaxStreamDataFrame) {
 func (s *sendStream) handleStopSendingFrame(frame *wire.StopSendingFrame) {
 	s.mutex.Lock()
 	completed := s.handleStopSendingFrameImpl(frame)
+	var released [][]byte
+	if completed {
+		released = s.popRetainedData()
+	}
 	s.mutex.Unlock()
 
 	if completed {
+		s.releaseRetainedData(released)
 		s.sender.onStreamCompleted(s.streamID)
 	}
 }
@@ -295,6 +748,7 @@ func (s *sendStream) handleStopSendingFrameImpl(frame *wire.StopSendingFrame) bo
 	writeErr := streamCanceledError{
 		errorCode: frame.ErrorCode,
 		error:     fmt.Errorf("Stream %d was reset with error code %d", s.streamID, frame.ErrorCode),
+		remote:    true,
 	}
 	return s.cancelWriteImpl(errorCodeStopping, writeErr)
 }
@@ -311,6 +765,219 @@ func (s *sendStream) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
+func (s *sendStream) SetPriority(priority int) {
+	s.mutex.Lock()
+	changed := s.priority != priority
+	s.priority = priority
+	s.mutex.Unlock()
+
+	if changed {
+		s.sender.onStreamPriorityChanged(s.streamID) // must be called without holding the mutex
+	}
+}
+
+func (s *sendStream) getPriority() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.priority
+}
+
+func (s *sendStream) onStreamFrameAcked(f *wire.StreamFrame) {
+	s.mutex.Lock()
+	s.bytesAcked += f.DataLen()
+	s.numOutstandingFrames--
+	released := s.onRetainedDataAcked(f)
+	completed := s.isNewlyCompleted()
+	s.mutex.Unlock()
+
+	if released != nil {
+		s.releaseRetainedData([][]byte{released})
+	}
+	if completed {
+		s.sender.onStreamCompleted(s.streamID) // must be called without holding the mutex
+	}
+}
+
+// onRetainedDataAcked accounts for the acknowledged data of a retained slice.
+// It returns the slice, if all data sent from it was acknowledged.
+// It must be called with the mutex held.
+func (s *sendStream) onRetainedDataAcked(f *wire.StreamFrame) []byte {
+	if f.DataLen() == 0 {
+		return nil
+	}
+	// A STREAM frame never contains data from multiple retained slices.
+	for i, r := range s.retainedData {
+		if f.Offset < r.offset || f.Offset >= r.offset+r.length {
+			continue
+		}
+		r.acked += f.DataLen()
+		if r.acked < r.length {
+			return nil
+		}
+		s.retainedData = append(s.retainedData[:i], s.retainedData[i+1:]...)
+		return r.data
+	}
+	return nil
+}
+
+// popRetainedData returns all retained slices.
+// It is used when the data won't be retransmitted any more.
+// It must be called with the mutex held.
+func (s *sendStream) popRetainedData() [][]byte {
+	released := make([][]byte, 0, len(s.retainedData))
+	for _, r := range s.retainedData {
+		released = append(released, r.data)
+	}
+	s.retainedData = nil
+	return released
+}
+
+// releaseRetainedData passes slices that aren't referenced any more back to the application.
+// It must be called without holding the mutex.
+func (s *sendStream) releaseRetainedData(released [][]byte) {
+	for _, b := range released {
+		s.release(b)
+	}
+}
+
+// onStreamFrameLost queues the data (and the FIN) of a lost STREAM frame for retransmission.
+func (s *sendStream) onStreamFrameLost(f *wire.StreamFrame) {
+	s.mutex.Lock()
+	s.numOutstandingFrames--
+	if s.canceledWrite || s.closeForShutdownErr != nil {
+		s.mutex.Unlock()
+		return
+	}
+	s.bytesRetransmitted += f.DataLen()
+	s.queueRetransmission(f)
+	s.updateSendState()
+	s.mutex.Unlock()
+
+	s.sender.onHasStreamData(s.streamID) // must be called without holding the mutex
+}
+
+// queueRetransmission inserts the lost data into the retransmission queue.
+// It must be called with the mutex held.
+func (s *sendStream) queueRetransmission(f *wire.StreamFrame) {
+	lost := &wire.StreamFrame{
+		StreamID:       s.streamID,
+		Offset:         f.Offset,
+		Data:           f.Data,
+		FinBit:         f.FinBit,
+		DataLenPresent: true,
+	}
+	i := 0
+	for ; i < len(s.retransmissionQueue); i++ {
+		if s.retransmissionQueue[i].Offset > lost.Offset {
+			break
+		}
+	}
+	s.retransmissionQueue = append(s.retransmissionQueue, nil)
+	copy(s.retransmissionQueue[i+1:], s.retransmissionQueue[i:])
+	s.retransmissionQueue[i] = lost
+	// coalesce with the following and with the preceding range
+	if i+1 < len(s.retransmissionQueue) {
+		s.maybeCoalesceRetransmissions(i)
+	}
+	if i > 0 {
+		s.maybeCoalesceRetransmissions(i - 1)
+	}
+}
+
+// maybeCoalesceRetransmissions merges the entries i and i+1 of the retransmission queue,
+// if the data is contiguous.
+func (s *sendStream) maybeCoalesceRetransmissions(i int) {
+	first := s.retransmissionQueue[i]
+	second := s.retransmissionQueue[i+1]
+	// Retransmissions reference the retained slices, so don't copy the data.
+	if s.retainData {
+		return
+	}
+	if first.FinBit || first.Offset+first.DataLen() != second.Offset {
+		return
+	}
+	// Allocate a new slice.
+	// The data of the lost frames might be backed by the same buffer.
+	data := make([]byte, 0, len(first.Data)+len(second.Data))
+	data = append(data, first.Data...)
+	data = append(data, second.Data...)
+	first.Data = data
+	first.FinBit = second.FinBit
+	s.retransmissionQueue = append(s.retransmissionQueue[:i+1], s.retransmissionQueue[i+2:]...)
+}
+
+// isNewlyCompleted says if the stream was completed, i.e. if the FIN was sent and all data was acknowledged.
+// It must be called with the mutex held.
+func (s *sendStream) isNewlyCompleted() bool {
+	if s.completed || !s.finSent || s.numOutstandingFrames > 0 || len(s.retransmissionQueue) > 0 {
+		return false
+	}
+	s.completed = true
+	return true
+}
+
+func (s *sendStream) SendStreamState() SendStreamState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case s.canceledWrite:
+		return SendStreamResetSent
+	case s.completed:
+		return SendStreamFinAcked
+	case s.finSent:
+		return SendStreamFinSent
+	case s.finishedWriting:
+		return SendStreamDataQueued
+	default:
+		return SendStreamOpen
+	}
+}
+
+// updateSendState updates the send state after the data waiting to be sent changed.
+// It must be called with the mutex held.
+func (s *sendStream) updateSendState() {
+	var state SendState
+	switch {
+	case s.canceledWrite || s.closeForShutdownErr != nil:
+		state = SendStateApplicationLimited
+	case len(s.retransmissionQueue) > 0:
+		// Retransmissions don't consume any flow control credit.
+		state = SendStateCongestionLimited
+	case s.dataForWriting != nil && s.flowControlBlocked:
+		state = SendStateFlowControlBlocked
+	case s.dataForWriting != nil || (s.finishedWriting && !s.finSent):
+		state = SendStateCongestionLimited
+	default:
+		state = SendStateApplicationLimited
+	}
+	// avoid calling Now() if the state didn't change
+	if state != s.sendState.State() {
+		s.sendState.Set(state, s.clock.Now())
+	}
+}
+
+func (s *sendStream) stats() StreamStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sendState, durations := s.sendState.Get(s.clock.Now())
+	var bytesQueued uint64
+	if !s.canceledWrite && s.closeForShutdownErr == nil {
+		bytesQueued = uint64(s.bytesQueued())
+	}
+	return StreamStats{
+		BytesWritten:           uint64(s.writeOffset),
+		BytesAcked:             uint64(s.bytesAcked),
+		BytesRetransmitted:     uint64(s.bytesRetransmitted),
+		BytesQueued:            bytesQueued,
+		FlowControlBlocked:     s.flowControlBlocked,
+		SendState:              sendState,
+		TimeApplicationLimited: durations[SendStateApplicationLimited],
+		TimeFlowControlBlocked: durations[SendStateFlowControlBlocked],
+		TimeCongestionLimited:  durations[SendStateCongestionLimited],
+	}
+}
+
 // CloseForShutdown closes a stream abruptly.
 // It makes Write unblock (and return the error) immediately.
 // The peer will NOT be informed about this: the stream is closed without sending a FIN or RST.
@@ -318,9 +985,15 @@ func (s *sendStream) closeForShutdown(err error) {
 	s.mutex.Lock()
 	s.closedForShutdown = true
 	s.closeForShutdownErr = err
+	if s.isBuffered() { // the buffered data won't be sent anymore
+		s.dataForWriting = nil
+	}
+	released := s.popRetainedData()
+	s.updateSendState()
 	s.mutex.Unlock()
+	s.releaseRetainedData(released)
 	s.signalWrite()
-	s.ctxCancel()
+	s.ctxCancel(err)
 }
 
 func (s *sendStream) getWriteOffset() protocol.ByteCount {
@@ -2,12 +2,15 @@ package quic
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"runtime"
+	"testing/iotest"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/mocks"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/wire"
@@ -30,7 +33,7 @@ var _ = Describe("Send Stream", func() {
 	BeforeEach(func() {
 		mockSender = NewMockStreamSender(mockCtrl)
 		mockFC = mocks.NewMockStreamFlowController(mockCtrl)
-		str = newSendStream(streamID, mockSender, mockFC, protocol.VersionWhatever)
+		str = newSendStream(streamID, mockSender, mockFC, 0, congestion.DefaultClock{}, protocol.VersionWhatever)
 
 		timeout := scaleDuration(250 * time.Millisecond)
 		strWithTimeout = gbytes.TimeoutWriter(str, timeout)
@@ -49,6 +52,14 @@ var _ = Describe("Send Stream", func() {
 		Expect(str.StreamID()).To(Equal(protocol.StreamID(1337)))
 	})
 
+	It("notifies the sender when the priority changes", func() {
+		Expect(str.getPriority()).To(BeZero())
+		mockSender.EXPECT().onStreamPriorityChanged(streamID)
+		str.SetPriority(3)
+		Expect(str.getPriority()).To(Equal(3))
+		str.SetPriority(3) // no change, so the sender is not notified
+	})
+
 	Context("writing", func() {
 		It("writes and gets all data at once", func() {
 			mockSender.EXPECT().onHasStreamData(streamID)
@@ -169,11 +180,119 @@ var _ = Describe("Send Stream", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		Context("reading from an io.Reader", func() {
+			It("reads all data until io.EOF", func() {
+				mockSender.EXPECT().onHasStreamData(streamID)
+				mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).AnyTimes()
+				mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.ReadFrom(bytes.NewReader([]byte("foobar")))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(n).To(BeEquivalentTo(6))
+					close(done)
+				}()
+				waitForWrite()
+				f, _ := str.popStreamFrame(1000)
+				Expect(f.Data).To(Equal([]byte("foobar")))
+				Expect(str.writeOffset).To(Equal(protocol.ByteCount(6)))
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("doesn't copy the data when packing STREAM frames, for large reads", func() {
+				mockSender.EXPECT().onHasStreamData(streamID)
+				mockFC.EXPECT().SendWindowSize().Return(protocol.MaxByteCount).AnyTimes()
+				mockFC.EXPECT().AddBytesSent(gomock.Any()).Times(2)
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, err := str.ReadFrom(bytes.NewReader(bytes.Repeat([]byte{'f'}, int(protocol.MinReadFromOwnedSize))))
+					Expect(err).ToNot(HaveOccurred())
+					close(done)
+				}()
+				waitForWrite()
+				str.mutex.Lock()
+				data := str.dataForWriting
+				Expect(str.ownsDataForWriting).To(BeTrue())
+				str.mutex.Unlock()
+				f, _ := str.popStreamFrame(50)
+				Expect(&f.Data[0]).To(BeIdenticalTo(&data[0]))
+				f, _ = str.popStreamFrame(2 * protocol.MinReadFromOwnedSize)
+				Expect(&f.Data[0]).To(BeIdenticalTo(&data[f.Offset]))
+				Expect(f.Offset + f.DataLen()).To(Equal(protocol.MinReadFromOwnedSize))
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("copies the data of small reads, such that the read buffer can be reused", func() {
+				mockSender.EXPECT().onHasStreamData(streamID)
+				mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).AnyTimes()
+				mockFC.EXPECT().AddBytesSent(protocol.ByteCount(100))
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, err := str.ReadFrom(bytes.NewReader(bytes.Repeat([]byte{'f'}, 100)))
+					Expect(err).ToNot(HaveOccurred())
+					close(done)
+				}()
+				waitForWrite()
+				str.mutex.Lock()
+				data := str.dataForWriting
+				Expect(str.ownsDataForWriting).To(BeFalse())
+				str.mutex.Unlock()
+				f, _ := str.popStreamFrame(1000)
+				Expect(f.Data).To(Equal(bytes.Repeat([]byte{'f'}, 100)))
+				Expect(&f.Data[0]).ToNot(BeIdenticalTo(&data[0]))
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("returns the number of bytes written when the io.Reader errors", func() {
+				testErr := errors.New("test error")
+				mockSender.EXPECT().onHasStreamData(streamID)
+				mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).AnyTimes()
+				mockFC.EXPECT().AddBytesSent(protocol.ByteCount(3))
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.ReadFrom(io.MultiReader(bytes.NewReader([]byte("foo")), iotest.ErrReader(testErr)))
+					Expect(err).To(MatchError(testErr))
+					Expect(n).To(BeEquivalentTo(3))
+					close(done)
+				}()
+				waitForWrite()
+				f, _ := str.popStreamFrame(1000)
+				Expect(f.Data).To(Equal([]byte("foo")))
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("unblocks when the stream is canceled", func() {
+				mockSender.EXPECT().onHasStreamData(streamID)
+				mockSender.EXPECT().queueControlFrame(gomock.Any())
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).AnyTimes()
+				mockFC.EXPECT().AddBytesSent(protocol.ByteCount(3))
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.ReadFrom(bytes.NewReader([]byte("foobar")))
+					Expect(err).To(MatchError("Write on stream 1337 canceled with error code 1234"))
+					Expect(n).To(BeEquivalentTo(3))
+					close(done)
+				}()
+				waitForWrite()
+				f, _ := str.popStreamFrame(3 + 4 /* frame header length */)
+				Expect(f.Data).To(Equal([]byte("foo")))
+				str.CancelWrite(1234)
+				Eventually(done).Should(BeClosed())
+			})
+		})
+
 		It("cancels the context when Close is called", func() {
 			mockSender.EXPECT().onHasStreamData(streamID)
 			Expect(str.Context().Done()).ToNot(BeClosed())
 			str.Close()
 			Expect(str.Context().Done()).To(BeClosed())
+			Expect(context.Cause(str.Context())).To(MatchError(context.Canceled))
 		})
 
 		Context("flow control blocking", func() {
@@ -400,13 +519,14 @@ var _ = Describe("Send Stream", func() {
 
 			It("allows FIN", func() {
 				mockSender.EXPECT().onHasStreamData(streamID)
-				mockSender.EXPECT().onStreamCompleted(streamID)
 				str.Close()
 				f, hasMoreData := str.popStreamFrame(1000)
 				Expect(f).ToNot(BeNil())
 				Expect(f.Data).To(BeEmpty())
 				Expect(f.FinBit).To(BeTrue())
 				Expect(hasMoreData).To(BeFalse())
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				str.onStreamFrameAcked(f)
 			})
 
 			It("doesn't send a FIN when there's still data", func() {
@@ -420,10 +540,13 @@ var _ = Describe("Send Stream", func() {
 				Expect(f).ToNot(BeNil())
 				Expect(f.Data).To(Equal([]byte("foo")))
 				Expect(f.FinBit).To(BeFalse())
+				f2, _ := str.popStreamFrame(100)
+				Expect(f2.Data).To(Equal([]byte("bar")))
+				Expect(f2.FinBit).To(BeTrue())
+				// the stream is completed when all frames are acknowledged
+				str.onStreamFrameAcked(f2)
 				mockSender.EXPECT().onStreamCompleted(streamID)
-				f, _ = str.popStreamFrame(100)
-				Expect(f.Data).To(Equal([]byte("bar")))
-				Expect(f.FinBit).To(BeTrue())
+				str.onStreamFrameAcked(f)
 			})
 
 			It("doesn't allow FIN after it is closed for shutdown", func() {
@@ -435,7 +558,6 @@ var _ = Describe("Send Stream", func() {
 
 			It("doesn't allow FIN twice", func() {
 				mockSender.EXPECT().onHasStreamData(streamID)
-				mockSender.EXPECT().onStreamCompleted(streamID)
 				str.Close()
 				f, _ := str.popStreamFrame(1000)
 				Expect(f).ToNot(BeNil())
@@ -483,10 +605,721 @@ var _ = Describe("Send Stream", func() {
 				Expect(str.Context().Done()).ToNot(BeClosed())
 				str.closeForShutdown(testErr)
 				Expect(str.Context().Done()).To(BeClosed())
+				Expect(context.Cause(str.Context())).To(MatchError(testErr))
 			})
 		})
 	})
 
+	Context("retransmissions", func() {
+		const frameHeaderLen = protocol.ByteCount(4)
+
+		BeforeEach(func() {
+			mockSender.EXPECT().onHasStreamData(streamID).AnyTimes()
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).AnyTimes()
+			mockFC.EXPECT().AddBytesSent(gomock.Any()).AnyTimes()
+		})
+
+		It("retransmits lost data before sending new data", func() {
+			str.dataForWriting = []byte("foobar")
+			f, _ := str.popStreamFrame(3 + frameHeaderLen)
+			Expect(f.Data).To(Equal([]byte("foo")))
+			str.onStreamFrameLost(f)
+			Expect(str.hasData()).To(BeTrue())
+			// retransmissions don't consume flow control credit
+			mockFC.EXPECT().AddBytesSent(gomock.Any()).Times(0)
+			f, hasMoreData := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("foo")))
+			Expect(f.Offset).To(BeZero())
+			Expect(f.DataLenPresent).To(BeTrue())
+			Expect(hasMoreData).To(BeTrue())
+		})
+
+		It("splits lost data to fit into the packet", func() {
+			str.dataForWriting = []byte("foobar")
+			f, _ := str.popStreamFrame(1000)
+			str.dataForWriting = nil
+			str.onStreamFrameLost(f)
+			f1, hasMoreData := str.popStreamFrame(2 + frameHeaderLen)
+			Expect(f1.Data).To(Equal([]byte("fo")))
+			Expect(hasMoreData).To(BeTrue())
+			f2, hasMoreData := str.popStreamFrame(1000)
+			Expect(f2.Data).To(Equal([]byte("obar")))
+			Expect(f2.Offset).To(Equal(protocol.ByteCount(2)))
+			Expect(hasMoreData).To(BeFalse())
+		})
+
+		It("coalesces adjacent lost ranges", func() {
+			str.dataForWriting = []byte("foobarbaz")
+			f1, _ := str.popStreamFrame(3 + frameHeaderLen)
+			// for the following frames, the offset takes one additional byte
+			f2, _ := str.popStreamFrame(3 + frameHeaderLen + 1)
+			f3, _ := str.popStreamFrame(3 + frameHeaderLen + 1)
+			frames := []*wire.StreamFrame{f1, f2, f3}
+			Expect(f3.Data).To(Equal([]byte("baz")))
+			str.onStreamFrameLost(frames[2])
+			str.onStreamFrameLost(frames[0])
+			Expect(str.retransmissionQueue).To(HaveLen(2))
+			str.onStreamFrameLost(frames[1])
+			Expect(str.retransmissionQueue).To(HaveLen(1))
+			f, hasMoreData := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("foobarbaz")))
+			Expect(f.Offset).To(BeZero())
+			Expect(hasMoreData).To(BeFalse())
+		})
+
+		It("retransmits the FIN, and completes the stream when all data was acknowledged", func() {
+			str.dataForWriting = []byte("foobar")
+			Expect(str.Close()).To(Succeed())
+			f1, _ := str.popStreamFrame(3 + frameHeaderLen)
+			f2, _ := str.popStreamFrame(1000)
+			Expect(f2.FinBit).To(BeTrue())
+			str.onStreamFrameAcked(f1)
+			str.onStreamFrameLost(f2)
+			f, hasMoreData := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("bar")))
+			Expect(f.FinBit).To(BeTrue())
+			Expect(hasMoreData).To(BeFalse())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.onStreamFrameAcked(f)
+		})
+
+		It("doesn't retransmit data after the stream was canceled", func() {
+			str.dataForWriting = []byte("foobar")
+			f1, _ := str.popStreamFrame(3 + frameHeaderLen)
+			f2, _ := str.popStreamFrame(1000)
+			str.onStreamFrameLost(f1)
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.CancelWrite(1234)
+			Expect(str.hasData()).To(BeFalse())
+			str.onStreamFrameLost(f2)
+			Expect(str.hasData()).To(BeFalse())
+			f, hasMoreData := str.popStreamFrame(1000)
+			Expect(f).To(BeNil())
+			Expect(hasMoreData).To(BeFalse())
+		})
+	})
+
+	Context("vectored writes", func() {
+		It("sends data from multiple slices in a single STREAM frame", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).Times(2)
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(5))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(4))
+			data := [][]byte{[]byte("foo"), nil, []byte("bar"), []byte("baz")}
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.WriteVectors(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(9))
+				close(done)
+			}()
+			waitForWrite()
+			Expect(str.stats().BytesQueued).To(BeEquivalentTo(9))
+			f, hasMoreData := str.popStreamFrame(5 + 4) // STREAM frame header + 5 bytes of data
+			Expect(f.Data).To(Equal([]byte("fooba")))
+			Expect(hasMoreData).To(BeTrue())
+			Expect(str.stats().BytesQueued).To(BeEquivalentTo(4))
+			Consistently(done).ShouldNot(BeClosed())
+			f, hasMoreData = str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("rbaz")))
+			Expect(f.Offset).To(Equal(protocol.ByteCount(5)))
+			Expect(hasMoreData).To(BeFalse())
+			Eventually(done).Should(BeClosed())
+			// the data was copied
+			copy(data[0], "oof")
+			Expect(f.Data).To(Equal([]byte("rbaz")))
+		})
+
+		It("returns immediately if all slices are empty", func() {
+			n, err := str.WriteVectors([][]byte{nil, {}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(BeZero())
+			Expect(str.dataForWriting).To(BeNil())
+		})
+
+		It("returns the number of bytes sent when the deadline expires", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(4))
+			deadline := time.Now().Add(scaleDuration(50 * time.Millisecond))
+			str.SetWriteDeadline(deadline)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.WriteVectors([][]byte{[]byte("foo"), []byte("bar")})
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(Equal(4))
+				close(done)
+			}()
+			waitForWrite()
+			f, _ := str.popStreamFrame(4 + 4)
+			Expect(f.Data).To(Equal([]byte("foob")))
+			Eventually(done).Should(BeClosed())
+			f, hasMoreData := str.popStreamFrame(1000)
+			Expect(f).To(BeNil())
+			Expect(hasMoreData).To(BeFalse())
+			Expect(str.moreDataForWriting).To(BeNil())
+		})
+
+		It("returns the number of bytes sent when writing is canceled", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(4))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.WriteVectors([][]byte{[]byte("foo"), []byte("bar")})
+				Expect(err).To(MatchError("Write on stream 1337 canceled with error code 1234"))
+				Expect(n).To(Equal(4))
+				close(done)
+			}()
+			waitForWrite()
+			f, _ := str.popStreamFrame(4 + 4)
+			Expect(f.Data).To(Equal([]byte("foob")))
+			str.CancelWrite(1234)
+			Eventually(done).Should(BeClosed())
+			f, _ = str.popStreamFrame(1000)
+			Expect(f).To(BeNil())
+			Expect(str.moreDataForWriting).To(BeNil())
+		})
+
+		It("copies the data to the send buffer, if data is buffered", func() {
+			str = newSendStream(streamID, mockSender, mockFC, 100, congestion.DefaultClock{}, protocol.VersionWhatever)
+			mockSender.EXPECT().onHasStreamData(streamID).Times(2)
+			data := [][]byte{[]byte("foo"), []byte("bar")}
+			n, err := str.WriteVectors(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			copy(data[1], "rab")
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			f, _ := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("foobar")))
+		})
+	})
+
+	Context("retaining data", func() {
+		var released chan []byte
+
+		BeforeEach(func() {
+			released = make(chan []byte, 10)
+			Expect(str.SetWriteBufferOwnership(BufferOwnershipRetained, func(b []byte) { released <- b })).To(Succeed())
+		})
+
+		It("refuses to change the buffer ownership after writing data", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			Expect(str.Close()).To(Succeed())
+			Expect(str.SetWriteBufferOwnership(BufferOwnershipCopied, nil)).To(MatchError("can't change the buffer ownership of stream 1337 after writing data"))
+		})
+
+		It("requires a release function", func() {
+			Expect(str.SetWriteBufferOwnership(BufferOwnershipRetained, nil)).To(MatchError("retaining data requires a release function"))
+		})
+
+		It("doesn't copy the data, and releases it when all data was acknowledged", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).Times(2)
+			mockFC.EXPECT().AddBytesSent(gomock.Any()).Times(2)
+			data := []byte("foobar")
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.Write(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(6))
+				close(done)
+			}()
+			waitForWrite()
+			f1, _ := str.popStreamFrame(3 + 4)
+			f2, _ := str.popStreamFrame(1000)
+			Eventually(done).Should(BeClosed())
+			// the STREAM frames reference the slice passed to Write
+			data[0] = 'F'
+			data[3] = 'B'
+			Expect(f1.Data).To(Equal([]byte("Foo")))
+			Expect(f2.Data).To(Equal([]byte("Bar")))
+			str.onStreamFrameAcked(f2)
+			Expect(released).To(BeEmpty())
+			str.onStreamFrameAcked(f1)
+			Expect(released).To(Receive(Equal(data)))
+			Expect(str.retainedData).To(BeEmpty())
+		})
+
+		It("copies small reads in ReadFrom, and doesn't release the copy", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.ReadFrom(bytes.NewReader([]byte("foobar")))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(BeEquivalentTo(6))
+				close(done)
+			}()
+			waitForWrite()
+			f, _ := str.popStreamFrame(1000)
+			Eventually(done).Should(BeClosed())
+			Expect(f.Data).To(Equal([]byte("foobar")))
+			Expect(str.retainedData).To(BeEmpty())
+			str.onStreamFrameAcked(f)
+			Expect(released).To(BeEmpty())
+		})
+
+		It("retransmits data referencing the original slice", func() {
+			mockSender.EXPECT().onHasStreamData(streamID).Times(3)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).Times(2)
+			mockFC.EXPECT().AddBytesSent(gomock.Any()).Times(2)
+			data := []byte("foobar")
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Write(data)
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			f1, _ := str.popStreamFrame(3 + 4)
+			f2, _ := str.popStreamFrame(1000)
+			Eventually(done).Should(BeClosed())
+			str.onStreamFrameLost(f1)
+			str.onStreamFrameLost(f2)
+			// the lost frames are not coalesced, since this would require copying the data
+			Expect(str.retransmissionQueue).To(HaveLen(2))
+			r1, _ := str.popStreamFrame(1000)
+			r2, _ := str.popStreamFrame(1000)
+			data[1] = 'O'
+			data[4] = 'A'
+			Expect(r1.Data).To(Equal([]byte("fOo")))
+			Expect(r2.Data).To(Equal([]byte("bAr")))
+			str.onStreamFrameAcked(r1)
+			Expect(released).To(BeEmpty())
+			str.onStreamFrameAcked(r2)
+			Expect(released).To(Receive(Equal(data)))
+		})
+
+		It("doesn't copy data passed to WriteVectors", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).Times(2)
+			mockFC.EXPECT().AddBytesSent(gomock.Any()).Times(2)
+			data := [][]byte{[]byte("foo"), []byte("bar")}
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.WriteVectors(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(6))
+				close(done)
+			}()
+			waitForWrite()
+			// every STREAM frame references a single slice
+			f1, hasMoreData := str.popStreamFrame(1000)
+			Expect(f1.Data).To(Equal([]byte("foo")))
+			Expect(hasMoreData).To(BeTrue())
+			f2, hasMoreData := str.popStreamFrame(1000)
+			Expect(f2.Data).To(Equal([]byte("bar")))
+			Expect(f2.Offset).To(Equal(protocol.ByteCount(3)))
+			Expect(hasMoreData).To(BeFalse())
+			Eventually(done).Should(BeClosed())
+			data[1][0] = 'B'
+			Expect(f2.Data).To(Equal([]byte("Bar")))
+			str.onStreamFrameAcked(f2)
+			Expect(released).To(Receive(Equal(data[1])))
+			str.onStreamFrameAcked(f1)
+			Expect(released).To(Receive(Equal(data[0])))
+		})
+
+		It("releases the data before Write returns, if none of it was sent", func() {
+			deadline := time.Now().Add(scaleDuration(20 * time.Millisecond))
+			str.SetWriteDeadline(deadline)
+			mockSender.EXPECT().onHasStreamData(streamID)
+			data := []byte("foobar")
+			n, err := str.Write(data)
+			Expect(err).To(MatchError(errDeadline))
+			Expect(n).To(BeZero())
+			Expect(released).To(Receive(Equal(data)))
+		})
+
+		It("releases the data when the data that was sent before the deadline expired is acknowledged", func() {
+			deadline := time.Now().Add(scaleDuration(50 * time.Millisecond))
+			str.SetWriteDeadline(deadline)
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(3))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := str.WriteVectors([][]byte{[]byte("foo"), []byte("bar")})
+				Expect(err).To(MatchError(errDeadline))
+				Expect(n).To(Equal(3))
+				close(done)
+			}()
+			waitForWrite()
+			f, _ := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("foo")))
+			Eventually(done).Should(BeClosed())
+			Expect(released).To(Receive(Equal([]byte("bar"))))
+			Expect(released).To(BeEmpty())
+			str.onStreamFrameAcked(f)
+			Expect(released).To(Receive(Equal([]byte("foo"))))
+		})
+
+		It("releases the data when writing is canceled", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(3))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Write([]byte("foobar"))
+				Expect(err).To(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			f, _ := str.popStreamFrame(3 + 4)
+			Expect(f.Data).To(Equal([]byte("foo")))
+			str.CancelWrite(1234)
+			Eventually(done).Should(BeClosed())
+			Expect(released).To(Receive(Equal([]byte("foobar"))))
+			Expect(released).To(BeEmpty())
+			// the acknowledgement for the frame sent before canceling doesn't release the slice again
+			str.onStreamFrameAcked(f)
+			Expect(released).To(BeEmpty())
+		})
+
+		It("releases the data when the stream is closed for shutdown", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			_, _ = str.popStreamFrame(1000)
+			Eventually(done).Should(BeClosed())
+			str.closeForShutdown(errors.New("shutdown"))
+			Expect(released).To(Receive(Equal([]byte("foobar"))))
+		})
+
+		It("doesn't use the send buffer", func() {
+			str = newSendStream(streamID, mockSender, mockFC, 100, congestion.DefaultClock{}, protocol.VersionWhatever)
+			Expect(str.SetWriteBufferOwnership(BufferOwnershipRetained, func(b []byte) { released <- b })).To(Succeed())
+			n, err := str.TryWrite([]byte("foobar"))
+			Expect(err).To(MatchError(errTryWriteRetained))
+			Expect(n).To(BeZero())
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := str.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			Consistently(done).ShouldNot(BeClosed())
+			f, _ := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("foobar")))
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Context("buffering", func() {
+		BeforeEach(func() {
+			str = newSendStream(streamID, mockSender, mockFC, 10, congestion.DefaultClock{}, protocol.VersionWhatever)
+			strWithTimeout = gbytes.TimeoutWriter(str, scaleDuration(250*time.Millisecond))
+		})
+
+		It("returns once the data was buffered", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			data := []byte("foobar")
+			n, err := strWithTimeout.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			Expect(str.stats().BytesQueued).To(BeEquivalentTo(6))
+			// the data was copied
+			copy(data, "raboof")
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			f, hasMoreData := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("foobar")))
+			Expect(hasMoreData).To(BeFalse())
+			Expect(str.stats().BytesQueued).To(BeZero())
+		})
+
+		It("blocks Write when the send buffer is full", func() {
+			mockSender.EXPECT().onHasStreamData(streamID).Times(2)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).AnyTimes()
+			mockFC.EXPECT().AddBytesSent(gomock.Any()).AnyTimes()
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := strWithTimeout.Write([]byte("foobarfoobar"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(12))
+				close(done)
+			}()
+			Eventually(func() uint64 { return str.stats().BytesQueued }).Should(BeEquivalentTo(10))
+			Consistently(done).ShouldNot(BeClosed())
+			f, _ := str.popStreamFrame(4 + 4) // STREAM frame header + 4 bytes of data
+			Expect(f.Data).To(Equal([]byte("foob")))
+			Eventually(done).Should(BeClosed())
+			Expect(str.stats().BytesQueued).To(BeEquivalentTo(8))
+			f, _ = str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("arfoobar")))
+		})
+
+		It("returns the number of bytes buffered when the deadline expires", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			deadline := time.Now().Add(scaleDuration(50 * time.Millisecond))
+			str.SetWriteDeadline(deadline)
+			n, err := strWithTimeout.Write([]byte("foobarfoobar"))
+			Expect(err).To(MatchError(errDeadline))
+			Expect(n).To(Equal(10))
+			Expect(time.Now()).To(BeTemporally("~", deadline, scaleDuration(20*time.Millisecond)))
+		})
+
+		It("unblocks Write when writing is canceled", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				n, err := strWithTimeout.Write([]byte("foobarfoobar"))
+				Expect(err).To(MatchError("Write on stream 1337 canceled with error code 1234"))
+				Expect(n).To(Equal(10))
+				close(done)
+			}()
+			Eventually(func() uint64 { return str.stats().BytesQueued }).Should(BeEquivalentTo(10))
+			str.CancelWrite(1234)
+			Eventually(done).Should(BeClosed())
+			Expect(str.stats().BytesQueued).To(BeZero())
+			f, _ := str.popStreamFrame(1000)
+			Expect(f).To(BeNil())
+		})
+
+		It("writes as much data as fits using TryWrite", func() {
+			mockSender.EXPECT().onHasStreamData(streamID).Times(2)
+			n, err := str.TryWrite([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(6))
+			n, err = str.TryWrite([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(4))
+			n, err = str.TryWrite([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(BeZero())
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(10))
+			f, _ := str.popStreamFrame(1000)
+			Expect(f.Data).To(Equal([]byte("foobarfoob")))
+		})
+
+		It("errors when calling TryWrite after Close", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			Expect(str.Close()).To(Succeed())
+			n, err := str.TryWrite([]byte("foobar"))
+			Expect(err).To(MatchError("write on closed stream 1337"))
+			Expect(n).To(BeZero())
+		})
+	})
+
+	It("errors when calling TryWrite, if data is not buffered", func() {
+		n, err := str.TryWrite([]byte("foobar"))
+		Expect(err).To(MatchError("TryWrite requires Config.MaxStreamSendBuffer"))
+		Expect(n).To(BeZero())
+	})
+
+	Context("statistics", func() {
+		It("counts the bytes written, acknowledged and retransmitted", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := strWithTimeout.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			Expect(str.stats().BytesWritten).To(BeZero())
+			f, _ := str.popStreamFrame(1000)
+			Eventually(done).Should(BeClosed())
+			mockSender.EXPECT().onHasStreamData(streamID)
+			str.onStreamFrameLost(f)
+			f1, _ := str.popStreamFrame(4 + 4)
+			f2, _ := str.popStreamFrame(1000)
+			str.onStreamFrameAcked(f1)
+			str.onStreamFrameAcked(f2)
+			stats := str.stats()
+			stats.TimeApplicationLimited, stats.TimeCongestionLimited = 0, 0
+			Expect(stats).To(Equal(StreamStats{
+				BytesWritten:       6,
+				BytesAcked:         6,
+				BytesRetransmitted: 6,
+			}))
+		})
+
+		It("says when it is blocked by flow control", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := strWithTimeout.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			Expect(str.stats().FlowControlBlocked).To(BeFalse())
+			mockFC.EXPECT().SendWindowSize()
+			mockFC.EXPECT().IsNewlyBlocked()
+			f, _ := str.popStreamFrame(1000)
+			Expect(f).To(BeNil())
+			Expect(str.stats().FlowControlBlocked).To(BeTrue())
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			f, _ = str.popStreamFrame(1000)
+			Expect(f).ToNot(BeNil())
+			Expect(str.stats().FlowControlBlocked).To(BeFalse())
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("keeps track of the time spent in every send state", func() {
+			Expect(str.stats().SendState).To(Equal(SendStateApplicationLimited))
+			mockSender.EXPECT().onHasStreamData(streamID)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := strWithTimeout.Write([]byte("foobar"))
+				Expect(err).ToNot(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			Expect(str.stats().SendState).To(Equal(SendStateCongestionLimited))
+			time.Sleep(10 * time.Millisecond)
+			mockFC.EXPECT().SendWindowSize()
+			mockFC.EXPECT().IsNewlyBlocked()
+			f, _ := str.popStreamFrame(1000)
+			Expect(f).To(BeNil())
+			Expect(str.stats().SendState).To(Equal(SendStateFlowControlBlocked))
+			time.Sleep(20 * time.Millisecond)
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999))
+			mockFC.EXPECT().AddBytesSent(protocol.ByteCount(6))
+			f, _ = str.popStreamFrame(1000)
+			Expect(f).ToNot(BeNil())
+			Eventually(done).Should(BeClosed())
+			stats := str.stats()
+			Expect(stats.SendState).To(Equal(SendStateApplicationLimited))
+			Expect(stats.TimeCongestionLimited).To(BeNumerically(">=", 10*time.Millisecond))
+			Expect(stats.TimeFlowControlBlocked).To(BeNumerically(">=", 20*time.Millisecond))
+			Expect(stats.TimeApplicationLimited).To(BeNumerically(">", 0))
+			// the current state is accounted for when getting the statistics
+			time.Sleep(10 * time.Millisecond)
+			Expect(str.stats().TimeApplicationLimited).To(BeNumerically(">=", stats.TimeApplicationLimited+10*time.Millisecond))
+		})
+
+		It("uses the clock to keep track of the time spent in every send state", func() {
+			clock := &mockClock{now: time.Now()}
+			str = newSendStream(streamID, mockSender, mockFC, 0, clock, protocol.VersionWhatever)
+			clock.Advance(time.Second)
+			mockSender.EXPECT().onHasStreamData(streamID)
+			str.onStreamFrameLost(&wire.StreamFrame{Data: []byte("foobar")})
+			clock.Advance(2 * time.Second)
+			stats := str.stats()
+			Expect(stats.SendState).To(Equal(SendStateCongestionLimited))
+			Expect(stats.TimeApplicationLimited).To(Equal(time.Second))
+			Expect(stats.TimeCongestionLimited).To(Equal(2 * time.Second))
+		})
+
+		It("is congestion limited when there's data to retransmit", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			str.onStreamFrameLost(&wire.StreamFrame{Data: []byte("foobar")})
+			Expect(str.stats().SendState).To(Equal(SendStateCongestionLimited))
+			f, _ := str.popStreamFrame(1000)
+			Expect(f).ToNot(BeNil())
+			Expect(str.stats().SendState).To(Equal(SendStateApplicationLimited))
+		})
+
+		It("is application limited after writing was canceled", func() {
+			mockSender.EXPECT().onHasStreamData(streamID)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := strWithTimeout.Write([]byte("foobar"))
+				Expect(err).To(HaveOccurred())
+				close(done)
+			}()
+			waitForWrite()
+			Expect(str.stats().SendState).To(Equal(SendStateCongestionLimited))
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.CancelWrite(1234)
+			Expect(str.stats().SendState).To(Equal(SendStateApplicationLimited))
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Context("stream state", func() {
+		It("is open until it is closed", func() {
+			Expect(str.SendStreamState()).To(Equal(SendStreamOpen))
+			str.dataForWriting = []byte("foobar")
+			Expect(str.SendStreamState()).To(Equal(SendStreamOpen))
+			mockSender.EXPECT().onHasStreamData(streamID)
+			Expect(str.Close()).To(Succeed())
+			Expect(str.SendStreamState()).To(Equal(SendStreamDataQueued))
+		})
+
+		It("says when the FIN was sent and acknowledged", func() {
+			mockSender.EXPECT().onHasStreamData(streamID).Times(2) // once for Close, once for the lost frame
+			mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(9999)).Times(2)
+			mockFC.EXPECT().AddBytesSent(gomock.Any()).Times(2)
+			str.dataForWriting = []byte("foobar")
+			Expect(str.Close()).To(Succeed())
+			f1, _ := str.popStreamFrame(3 + 4)
+			Expect(f1.FinBit).To(BeFalse())
+			Expect(str.SendStreamState()).To(Equal(SendStreamDataQueued))
+			f2, _ := str.popStreamFrame(1000)
+			Expect(f2.FinBit).To(BeTrue())
+			Expect(str.SendStreamState()).To(Equal(SendStreamFinSent))
+			str.onStreamFrameAcked(f2)
+			Expect(str.SendStreamState()).To(Equal(SendStreamFinSent))
+			// a lost frame is retransmitted before the stream is completed
+			str.onStreamFrameLost(f1)
+			Expect(str.SendStreamState()).To(Equal(SendStreamFinSent))
+			f1, _ = str.popStreamFrame(1000)
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.onStreamFrameAcked(f1)
+			Expect(str.SendStreamState()).To(Equal(SendStreamFinAcked))
+		})
+
+		It("says when the stream was reset", func() {
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.CancelWrite(1234)
+			Expect(str.SendStreamState()).To(Equal(SendStreamResetSent))
+		})
+
+		It("says when the stream was reset because of a STOP_SENDING frame", func() {
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.handleStopSendingFrame(&wire.StopSendingFrame{StreamID: streamID, ErrorCode: 1234})
+			Expect(str.SendStreamState()).To(Equal(SendStreamResetSent))
+		})
+	})
+
 	Context("handling MAX_STREAM_DATA frames", func() {
 		It("informs the flow controller", func() {
 			mockFC.EXPECT().UpdateSendWindow(protocol.ByteCount(0x1337))
@@ -583,6 +1416,9 @@ var _ = Describe("Send Stream", func() {
 				Expect(str.Context().Done()).ToNot(BeClosed())
 				str.CancelWrite(1234)
 				Expect(str.Context().Done()).To(BeClosed())
+				cause := context.Cause(str.Context())
+				Expect(cause).To(BeAssignableToTypeOf(streamCanceledError{}))
+				Expect(cause.(StreamError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(1234)))
 			})
 
 			It("doesn't allow further calls to Write", func() {
@@ -593,6 +1429,17 @@ var _ = Describe("Send Stream", func() {
 				Expect(err).To(MatchError("Write on stream 1337 canceled with error code 1234"))
 			})
 
+			It("returns a StreamError with the error code", func() {
+				mockSender.EXPECT().queueControlFrame(gomock.Any())
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				str.CancelWrite(1234)
+				_, err := strWithTimeout.Write([]byte("foobar"))
+				Expect(err).To(BeAssignableToTypeOf(streamCanceledError{}))
+				Expect(err.(StreamError).Canceled()).To(BeTrue())
+				Expect(err.(StreamError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(1234)))
+				Expect(err.(StreamError).Remote()).To(BeFalse())
+			})
+
 			It("only cancels once", func() {
 				mockSender.EXPECT().queueControlFrame(&wire.ResetStreamFrame{StreamID: streamID, ErrorCode: 1234})
 				mockSender.EXPECT().onStreamCompleted(streamID)
@@ -632,15 +1479,53 @@ var _ = Describe("Send Stream", func() {
 					Expect(err).To(BeAssignableToTypeOf(streamCanceledError{}))
 					Expect(err.(streamCanceledError).Canceled()).To(BeTrue())
 					Expect(err.(streamCanceledError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(123)))
+					Expect(err.(streamCanceledError).Remote()).To(BeTrue())
+					close(done)
+				}()
+				waitForWrite()
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				str.handleStopSendingFrame(&wire.StopSendingFrame{
+					StreamID:  streamID,
+					ErrorCode: 123,
+				})
+				Eventually(done).Should(BeClosed())
+			})
+
+			It("unblocks a Write that is blocked by flow control, and returns the number of bytes sent", func() {
+				mockSender.EXPECT().onHasStreamData(streamID)
+				mockFC.EXPECT().SendWindowSize().Return(protocol.ByteCount(3))
+				mockFC.EXPECT().AddBytesSent(protocol.ByteCount(3))
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					n, err := str.Write([]byte("foobar"))
+					Expect(err).To(MatchError("Stream 1337 was reset with error code 123"))
+					Expect(err.(StreamError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(123)))
+					Expect(n).To(Equal(3))
 					close(done)
 				}()
 				waitForWrite()
+				f, hasMoreData := str.popStreamFrame(1000)
+				Expect(f.Data).To(Equal([]byte("foo")))
+				Expect(hasMoreData).To(BeTrue())
+				// now the stream is blocked by flow control
+				mockFC.EXPECT().SendWindowSize()
+				mockFC.EXPECT().IsNewlyBlocked()
+				f, _ = str.popStreamFrame(1000)
+				Expect(f).To(BeNil())
+				Consistently(done).ShouldNot(BeClosed())
+				mockSender.EXPECT().queueControlFrame(&wire.ResetStreamFrame{
+					StreamID:   streamID,
+					ByteOffset: 3,
+					ErrorCode:  errorCodeStopping,
+				})
 				mockSender.EXPECT().onStreamCompleted(streamID)
 				str.handleStopSendingFrame(&wire.StopSendingFrame{
 					StreamID:  streamID,
 					ErrorCode: 123,
 				})
 				Eventually(done).Should(BeClosed())
+				Expect(str.hasData()).To(BeFalse())
 			})
 
 			It("doesn't allow further calls to Write", func() {
@@ -656,6 +1541,19 @@ var _ = Describe("Send Stream", func() {
 				Expect(err.(streamCanceledError).Canceled()).To(BeTrue())
 				Expect(err.(streamCanceledError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(123)))
 			})
+
+			It("cancels the context with the StreamError", func() {
+				mockSender.EXPECT().queueControlFrame(gomock.Any())
+				mockSender.EXPECT().onStreamCompleted(streamID)
+				str.handleStopSendingFrame(&wire.StopSendingFrame{
+					StreamID:  streamID,
+					ErrorCode: 123,
+				})
+				Expect(str.Context().Done()).To(BeClosed())
+				cause := context.Cause(str.Context())
+				Expect(cause).To(BeAssignableToTypeOf(streamCanceledError{}))
+				Expect(cause.(StreamError).ErrorCode()).To(Equal(protocol.ApplicationErrorCode(123)))
+			})
 		})
 	})
 })
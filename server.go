@@ -2,14 +2,15 @@ package quic
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/lucas-clemente/quic-go/internal/handshake"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
@@ -33,14 +34,20 @@ type unknownPacketHandler interface {
 
 type packetHandlerManager interface {
 	Add(protocol.ConnectionID, packetHandler)
+	AddIfNotTaken(protocol.ConnectionID, packetHandler) bool
+	AddWithResetToken(protocol.ConnectionID, packetHandler, [16]byte)
+	GetStatelessResetToken(protocol.ConnectionID) [16]byte
 	Retire(protocol.ConnectionID)
 	Remove(protocol.ConnectionID)
 	SetServer(unknownPacketHandler)
+	SetNonQUICPacketHandler(func([]byte, net.Addr))
 	CloseServer()
 }
 
 type quicSession interface {
-	Session
+	EarlySession
+	// earlySessionReady is closed when the server processed the ClientHello
+	earlySessionReady() <-chan struct{}
 	handlePacket(*receivedPacket)
 	GetVersion() protocol.VersionNumber
 	run() error
@@ -50,20 +57,52 @@ type quicSession interface {
 }
 
 type sessionRunner interface {
-	onHandshakeComplete(Session)
+	onHandshakeComplete(quicSession)
+	addConnectionID(protocol.ConnectionID, quicSession) bool
+	getStatelessResetToken(protocol.ConnectionID) [16]byte
 	retireConnectionID(protocol.ConnectionID)
 	removeConnectionID(protocol.ConnectionID)
+	// only used by the server
+	newToken(net.Addr) ([]byte, error)
+	// only used by the client
+	addResetToken([16]byte)
+	addToken([]byte)
+	addPacketConn(net.PacketConn) (connection, error)
+	switchPacketConn(connection)
+	removePacketConn(connection)
 }
 
 type runner struct {
-	onHandshakeCompleteImpl func(Session)
-	retireConnectionIDImpl  func(protocol.ConnectionID)
-	removeConnectionIDImpl  func(protocol.ConnectionID)
+	onHandshakeCompleteImpl    func(quicSession)
+	addConnectionIDImpl        func(protocol.ConnectionID, quicSession) bool
+	getStatelessResetTokenImpl func(protocol.ConnectionID) [16]byte
+	retireConnectionIDImpl     func(protocol.ConnectionID)
+	removeConnectionIDImpl     func(protocol.ConnectionID)
+	newTokenImpl               func(net.Addr) ([]byte, error)
+	addResetTokenImpl          func([16]byte)
+	addTokenImpl               func([]byte)
+	addPacketConnImpl          func(net.PacketConn) (connection, error)
+	switchPacketConnImpl       func(connection)
+	removePacketConnImpl       func(connection)
 }
 
-func (r *runner) onHandshakeComplete(s Session)              { r.onHandshakeCompleteImpl(s) }
+func (r *runner) onHandshakeComplete(s quicSession) { r.onHandshakeCompleteImpl(s) }
+func (r *runner) addConnectionID(c protocol.ConnectionID, s quicSession) bool {
+	return r.addConnectionIDImpl(c, s)
+}
+func (r *runner) getStatelessResetToken(c protocol.ConnectionID) [16]byte {
+	return r.getStatelessResetTokenImpl(c)
+}
 func (r *runner) retireConnectionID(c protocol.ConnectionID) { r.retireConnectionIDImpl(c) }
 func (r *runner) removeConnectionID(c protocol.ConnectionID) { r.removeConnectionIDImpl(c) }
+func (r *runner) newToken(a net.Addr) ([]byte, error)        { return r.newTokenImpl(a) }
+func (r *runner) addResetToken(t [16]byte)                   { r.addResetTokenImpl(t) }
+func (r *runner) addToken(t []byte)                          { r.addTokenImpl(t) }
+func (r *runner) addPacketConn(c net.PacketConn) (connection, error) {
+	return r.addPacketConnImpl(c)
+}
+func (r *runner) switchPacketConn(c connection) { r.switchPacketConnImpl(c) }
+func (r *runner) removePacketConn(c connection) { r.removePacketConnImpl(c) }
 
 var _ sessionRunner = &runner{}
 
@@ -78,19 +117,25 @@ type server struct {
 	// If the server is started with ListenAddr, we create a packet conn.
 	// If it is started with Listen, we take a packet conn as a parameter.
 	createdPacketConn bool
+	// ecnOOB contains the control messages that mark sent packets as ECT(0).
+	// It is only set if we created the packet conn.
+	ecnOOB []byte
+	// If the server is started with ListenEarly or ListenAddrEarly,
+	// sessions are accepted as soon as the ClientHello was processed.
+	acceptEarlySessions bool
 
-	cookieGenerator *handshake.CookieGenerator
+	cookieGenerator CookieGenerator
 
 	sessionHandler packetHandlerManager
 
 	// set as a member, so they can be set in the tests
-	newSession func(connection, sessionRunner, protocol.ConnectionID /* original connection ID */, protocol.ConnectionID /* destination connection ID */, protocol.ConnectionID /* source connection ID */, *Config, *tls.Config, *handshake.TransportParameters, utils.Logger, protocol.VersionNumber) (quicSession, error)
+	newSession func(connection, sessionRunner, protocol.ConnectionID /* original connection ID */, protocol.ConnectionID /* destination connection ID */, protocol.ConnectionID /* source connection ID */, *Config, *tls.Config, *handshake.TransportParameters, bool /* client address validated */, utils.Logger, protocol.VersionNumber) (quicSession, error)
 
 	serverError error
 	errorChan   chan struct{}
 	closed      bool
 
-	sessionQueue    chan Session
+	sessionQueue    chan quicSession
 	sessionQueueLen int32 // to be used as an atomic
 
 	sessionRunner sessionRunner
@@ -101,10 +146,33 @@ type server struct {
 var _ Listener = &server{}
 var _ unknownPacketHandler = &server{}
 
+// An earlyServer is a server that accepts sessions before the handshake completes.
+type earlyServer struct{ *server }
+
+var _ EarlyListener = &earlyServer{}
+
+// Accept returns sessions as soon as the ClientHello was processed
+func (s *earlyServer) Accept(ctx context.Context) (EarlySession, error) {
+	return s.server.accept(ctx)
+}
+
 // ListenAddr creates a QUIC server listening on a given address.
 // The tls.Config must not be nil and must contain a certificate configuration.
 // The quic.Config may be nil, in that case the default values will be used.
 func ListenAddr(addr string, tlsConf *tls.Config, config *Config) (Listener, error) {
+	return listenAddr(addr, tlsConf, config, false)
+}
+
+// ListenAddrEarly works like ListenAddr, but it returns sessions before the handshake completes.
+func ListenAddrEarly(addr string, tlsConf *tls.Config, config *Config) (EarlyListener, error) {
+	s, err := listenAddr(addr, tlsConf, config, true)
+	if err != nil {
+		return nil, err
+	}
+	return &earlyServer{s}, nil
+}
+
+func listenAddr(addr string, tlsConf *tls.Config, config *Config, acceptEarly bool) (*server, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
@@ -113,7 +181,16 @@ func ListenAddr(addr string, tlsConf *tls.Config, config *Config) (Listener, err
 	if err != nil {
 		return nil, err
 	}
-	serv, err := listen(conn, tlsConf, config)
+	var dscp uint8
+	if config != nil {
+		if err := setDiffServCodePoint(conn, config.DiffServCodePoint); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		dscp = config.DiffServCodePoint
+	}
+	// Only use ECN on packet conns that we created, since it changes the socket options.
+	serv, err := listen(conn, enableECN(conn, dscp), tlsConf, config, acceptEarly)
 	if err != nil {
 		return nil, err
 	}
@@ -128,10 +205,21 @@ func ListenAddr(addr string, tlsConf *tls.Config, config *Config) (Listener, err
 // The tls.Config must not be nil and must contain a certificate configuration.
 // The quic.Config may be nil, in that case the default values will be used.
 func Listen(conn net.PacketConn, tlsConf *tls.Config, config *Config) (Listener, error) {
-	return listen(conn, tlsConf, config)
+	return listen(conn, nil, tlsConf, config, false)
+}
+
+// ListenEarly works like Listen, but it returns sessions before the handshake completes.
+func ListenEarly(conn net.PacketConn, tlsConf *tls.Config, config *Config) (EarlyListener, error) {
+	s, err := listen(conn, nil, tlsConf, config, true)
+	if err != nil {
+		return nil, err
+	}
+	return &earlyServer{s}, nil
 }
 
-func listen(conn net.PacketConn, tlsConf *tls.Config, config *Config) (*server, error) {
+// ecnOOB contains the control messages used to send ECN-marked packets.
+// It is nil if ECN is not used.
+func listen(conn net.PacketConn, ecnOOB []byte, tlsConf *tls.Config, config *Config, acceptEarly bool) (*server, error) {
 	// TODO(#1655): only require that tls.Config.Certificates or tls.Config.GetCertificate is set
 	if tlsConf == nil || len(tlsConf.Certificates) == 0 {
 		return nil, errors.New("quic: Certificates not set in tls.Config")
@@ -142,20 +230,37 @@ func listen(conn net.PacketConn, tlsConf *tls.Config, config *Config) (*server,
 			return nil, fmt.Errorf("%s is not a valid QUIC version", v)
 		}
 	}
+	if err := validateFlowControlWindows(config); err != nil {
+		return nil, err
+	}
+	if err := validateCongestionWindows(config); err != nil {
+		return nil, err
+	}
+	if err := validateAckSettings(config); err != nil {
+		return nil, err
+	}
+	if config.ActiveConnectionIDs > protocol.MaxActiveConnectionIDs {
+		return nil, fmt.Errorf("quic: ActiveConnectionIDs (%d) is larger than the maximum (%d)", config.ActiveConnectionIDs, protocol.MaxActiveConnectionIDs)
+	}
 
-	sessionHandler, err := getMultiplexer().AddConn(conn, config.ConnectionIDLength)
+	sessionHandler, err := getMultiplexer().AddConn(conn, config.ConnectionIDLength, config.StatelessResetKey)
 	if err != nil {
 		return nil, err
 	}
+	if config.NonQUICPacketHandler != nil {
+		sessionHandler.SetNonQUICPacketHandler(config.NonQUICPacketHandler)
+	}
 	s := &server{
-		conn:           conn,
-		tlsConf:        tlsConf,
-		config:         config,
-		sessionHandler: sessionHandler,
-		sessionQueue:   make(chan Session),
-		errorChan:      make(chan struct{}),
-		newSession:     newSession,
-		logger:         utils.DefaultLogger.WithPrefix("server"),
+		conn:                conn,
+		ecnOOB:              ecnOOB,
+		tlsConf:             tlsConf,
+		config:              config,
+		sessionHandler:      sessionHandler,
+		sessionQueue:        make(chan quicSession),
+		errorChan:           make(chan struct{}),
+		newSession:          newSession,
+		acceptEarlySessions: acceptEarly,
+		logger:              getLogger(config).WithPrefix("server"),
 	}
 	if err := s.setup(); err != nil {
 		return nil, err
@@ -167,22 +272,30 @@ func listen(conn net.PacketConn, tlsConf *tls.Config, config *Config) (*server,
 
 func (s *server) setup() error {
 	s.sessionRunner = &runner{
-		onHandshakeCompleteImpl: func(sess Session) {
-			go func() {
-				atomic.AddInt32(&s.sessionQueueLen, 1)
-				defer atomic.AddInt32(&s.sessionQueueLen, -1)
-				select {
-				case s.sessionQueue <- sess:
-					// blocks until the session is accepted
-				case <-sess.Context().Done():
-					// don't pass sessions that were already closed to Accept()
-				}
-			}()
+		onHandshakeCompleteImpl: func(sess quicSession) {
+			// Early sessions were already queued when the ClientHello was processed.
+			if !s.acceptEarlySessions {
+				go s.queueSession(sess)
+			}
 		},
-		retireConnectionIDImpl: s.sessionHandler.Retire,
-		removeConnectionIDImpl: s.sessionHandler.Remove,
+		addConnectionIDImpl: func(id protocol.ConnectionID, sess quicSession) bool {
+			return s.sessionHandler.AddIfNotTaken(id, newServerSession(sess, s.config, s.logger))
+		},
+		getStatelessResetTokenImpl: s.sessionHandler.GetStatelessResetToken,
+		retireConnectionIDImpl:     s.sessionHandler.Retire,
+		removeConnectionIDImpl:     s.sessionHandler.Remove,
+		newTokenImpl: func(addr net.Addr) ([]byte, error) {
+			// Tokens sent in NEW_TOKEN frames don't contain any data.
+			// This distinguishes them from tokens sent in Retry packets,
+			// which contain the original destination connection ID.
+			return s.cookieGenerator.Generate(addr, nil)
+		},
+	}
+	if s.config.CookieGenerator != nil {
+		s.cookieGenerator = s.config.CookieGenerator
+		return nil
 	}
-	cookieGenerator, err := handshake.NewCookieGenerator()
+	cookieGenerator, err := NewCookieGenerator(&CookieGeneratorConfig{Clock: s.config.Clock})
 	if err != nil {
 		return err
 	}
@@ -190,20 +303,54 @@ func (s *server) setup() error {
 	return nil
 }
 
-var defaultAcceptCookie = func(clientAddr net.Addr, cookie *Cookie) bool {
-	if cookie == nil {
-		return false
+var defaultAcceptCookie = func(_ net.Addr, cookie *Cookie) bool {
+	return cookie != nil && cookie.Valid
+}
+
+// NewCookieGenerator creates a new CookieGenerator.
+// Cookies are encrypted and authenticated using the key, and bound to the client's IP address.
+// It may be called with nil.
+func NewCookieGenerator(config *CookieGeneratorConfig) (CookieGenerator, error) {
+	if config == nil {
+		config = &CookieGeneratorConfig{}
 	}
-	if time.Now().After(cookie.SentTime.Add(protocol.CookieExpiryTime)) {
-		return false
+	if config.Key != nil && len(config.Key) != handshake.CookieKeySize {
+		return nil, fmt.Errorf("quic: the cookie key must be %d bytes long", handshake.CookieKeySize)
+	}
+	lifetime := protocol.CookieExpiryTime
+	if config.Lifetime != 0 {
+		lifetime = config.Lifetime
+	}
+	ipv4PrefixLen := config.IPv4PrefixLen
+	if ipv4PrefixLen == 0 {
+		ipv4PrefixLen = 8 * net.IPv4len
+	}
+	ipv6PrefixLen := config.IPv6PrefixLen
+	if ipv6PrefixLen == 0 {
+		ipv6PrefixLen = 8 * net.IPv6len
+	}
+	var clock Clock = systemClock{}
+	if config.Clock != nil {
+		clock = config.Clock
+	}
+	g, err := handshake.NewCookieGenerator(config.Key, lifetime, ipv4PrefixLen, ipv6PrefixLen, clock)
+	if err != nil {
+		return nil, err
 	}
-	var sourceAddr string
-	if udpAddr, ok := clientAddr.(*net.UDPAddr); ok {
-		sourceAddr = udpAddr.IP.String()
-	} else {
-		sourceAddr = clientAddr.String()
+	return &defaultCookieGenerator{g}, nil
+}
+
+// defaultCookieGenerator is the CookieGenerator returned by NewCookieGenerator
+type defaultCookieGenerator struct {
+	*handshake.CookieGenerator
+}
+
+func (g *defaultCookieGenerator) Validate(cookie []byte, clientAddr net.Addr) (*Cookie, []byte, error) {
+	t, err := g.CookieGenerator.Validate(cookie, clientAddr)
+	if err != nil {
+		return nil, nil, err
 	}
-	return sourceAddr == cookie.RemoteAddr
+	return &Cookie{RemoteAddr: t.RemoteAddr, SentTime: t.SentTime}, t.Data, nil
 }
 
 // populateServerConfig populates fields in the quic.Config with their default values, if none are set
@@ -251,30 +398,109 @@ func populateServerConfig(config *Config) *Config {
 	} else if maxIncomingUniStreams < 0 {
 		maxIncomingUniStreams = 0
 	}
+	initialCongestionWindow := config.InitialCongestionWindow
+	if initialCongestionWindow == 0 {
+		initialCongestionWindow = uint32(protocol.InitialCongestionWindow / protocol.DefaultTCPMSS)
+	}
+	minCongestionWindow := config.MinCongestionWindow
+	if minCongestionWindow == 0 {
+		minCongestionWindow = uint32(protocol.DefaultMinCongestionWindow / protocol.DefaultTCPMSS)
+		// The default minimum must not exceed a small initial congestion window.
+		if minCongestionWindow > initialCongestionWindow {
+			minCongestionWindow = initialCongestionWindow
+		}
+	}
+	maxAckDelay := config.MaxAckDelay
+	if maxAckDelay == 0 {
+		maxAckDelay = protocol.DefaultMaxAckDelay
+	}
+	ackFrequency := config.AckFrequency
+	if ackFrequency == 0 {
+		ackFrequency = protocol.DefaultAckFrequency
+	}
 	connIDLen := config.ConnectionIDLength
 	if connIDLen == 0 {
 		connIDLen = protocol.DefaultConnectionIDLength
 	}
+	activeConnIDs := config.ActiveConnectionIDs
+	if activeConnIDs == 0 {
+		activeConnIDs = protocol.DefaultActiveConnectionIDs
+	} else if activeConnIDs < 0 {
+		activeConnIDs = 1
+	}
 
 	return &Config{
 		Versions:                              versions,
 		HandshakeTimeout:                      handshakeTimeout,
 		IdleTimeout:                           idleTimeout,
 		AcceptCookie:                          vsa,
+		AcceptConnection:                      config.AcceptConnection,
+		HandshakeRateLimiter:                  config.HandshakeRateLimiter,
+		CookieGenerator:                       config.CookieGenerator,
 		KeepAlive:                             config.KeepAlive,
+		KeepAlivePeriod:                       config.KeepAlivePeriod,
+		EnableDatagrams:                       config.EnableDatagrams,
+		DisableSpinBit:                        config.DisableSpinBit,
+		DisableMigration:                      config.DisableMigration,
+		StatelessResetKey:                     config.StatelessResetKey,
+		ConnectionIDGenerator:                 config.ConnectionIDGenerator,
+		NonQUICPacketHandler:                  config.NonQUICPacketHandler,
+		DiffServCodePoint:                     config.DiffServCodePoint,
+		CongestionControl:                     config.CongestionControl,
+		InitialCongestionWindow:               initialCongestionWindow,
+		MinCongestionWindow:                   minCongestionWindow,
+		MaxAckDelay:                           maxAckDelay,
+		AckFrequency:                          ackFrequency,
 		MaxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
 		MaxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
+		MaxStreamSendBuffer:                   config.MaxStreamSendBuffer,
 		MaxIncomingStreams:                    maxIncomingStreams,
 		MaxIncomingUniStreams:                 maxIncomingUniStreams,
 		ConnectionIDLength:                    connIDLen,
+		ActiveConnectionIDs:                   activeConnIDs,
+		Tracer:                                config.Tracer,
+		GetLogWriter:                          config.GetLogWriter,
+		Logger:                                config.Logger,
+		Clock:                                 config.Clock,
+	}
+}
+
+// queueSession passes a session to Accept.
+// It blocks until the session is accepted, or until it is closed.
+func (s *server) queueSession(sess quicSession) {
+	atomic.AddInt32(&s.sessionQueueLen, 1)
+	defer atomic.AddInt32(&s.sessionQueueLen, -1)
+	select {
+	case s.sessionQueue <- sess:
+		// blocks until the session is accepted
+	case <-sess.Context().Done():
+		// don't pass sessions that were already closed to Accept()
+	}
+}
+
+// queueEarlySession passes a session to Accept as soon as the ClientHello was processed.
+func (s *server) queueEarlySession(sess quicSession) {
+	select {
+	case <-sess.earlySessionReady():
+		s.queueSession(sess)
+	case <-sess.Context().Done():
 	}
 }
 
 // Accept returns newly openend sessions
-func (s *server) Accept() (Session, error) {
-	var sess Session
+func (s *server) Accept(ctx context.Context) (Session, error) {
+	sess, err := s.accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (s *server) accept(ctx context.Context) (quicSession, error) {
 	select {
-	case sess = <-s.sessionQueue:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case sess := <-s.sessionQueue:
 		return sess, nil
 	case <-s.errorChan:
 		return nil, s.serverError
@@ -294,7 +520,7 @@ func (s *server) Close() error {
 func (s *server) closeWithMutex() error {
 	s.sessionHandler.CloseServer()
 	if s.serverError == nil {
-		s.serverError = errors.New("server closed")
+		s.serverError = ErrServerClosed
 	}
 	var err error
 	// If the server was started with ListenAddr, we created the packet conn.
@@ -331,6 +557,9 @@ func (s *server) handlePacket(p *receivedPacket) {
 		return
 	}
 	if hdr.Type == protocol.PacketTypeInitial {
+		if s.config.HandshakeRateLimiter != nil && !s.allowHandshake(p) {
+			return
+		}
 		go s.handleInitial(p)
 		return
 	}
@@ -339,9 +568,45 @@ func (s *server) handlePacket(p *receivedPacket) {
 	p.buffer.Release()
 }
 
+// allowHandshake applies the HandshakeRateLimiter to an Initial packet.
+// If the packet is rate limited, it is dropped or answered with a Retry, and allowHandshake returns false.
+// Initial packets carrying a valid Cookie have a larger budget:
+// The client proved that it owns its address, so the packet can't have been sent by an attacker spoofing the address.
+// The Cookie is only validated if the packet exceeds the rate, and if that budget allows it.
+func (s *server) allowHandshake(p *receivedPacket) bool {
+	hdr := p.hdr
+	var validateCookie func() bool
+	if len(hdr.Token) > 0 {
+		validateCookie = func() bool {
+			cookie, _ := s.validateCookie(hdr.Token, p.remoteAddr)
+			return cookie.Valid
+		}
+	}
+	// Only send a Retry for packets that would have been accepted by handleInitial,
+	// so that the Retry can't be used for amplification.
+	canRetry := hdr.DestConnectionID.Len() >= protocol.MinConnectionIDLenInitial &&
+		p.datagramSize >= protocol.MinInitialPacketSize
+	switch s.config.HandshakeRateLimiter.allow(p.remoteAddr, canRetry, getClock(s.config).Now(), validateCookie) {
+	case Accept:
+		return true
+	case RejectWithRetry:
+		s.logger.Debugf("Rate limiting connection attempt from %s. Sending a Retry.", p.remoteAddr)
+		go func() {
+			defer p.buffer.Release()
+			if err := s.sendRetry(p.remoteAddr, hdr); err != nil {
+				s.logger.Debugf("Error sending Retry: %s", err)
+			}
+		}()
+	default:
+		s.logger.Debugf("Rate limiting connection attempt from %s. Dropping the packet.", p.remoteAddr)
+		p.buffer.Release()
+	}
+	return false
+}
+
 func (s *server) handleInitial(p *receivedPacket) {
 	s.logger.Debugf("<- Received Initial packet.")
-	sess, connID, err := s.handleInitialImpl(p)
+	sess, err := s.handleInitialImpl(p)
 	if err != nil {
 		p.buffer.Release()
 		s.logger.Errorf("Error occurred handling initial packet: %s", err)
@@ -353,61 +618,152 @@ func (s *server) handleInitial(p *receivedPacket) {
 	}
 	// Don't put the packet buffer back if a new session was created.
 	// The session will handle the packet and take of that.
-	serverSession := newServerSession(sess, s.config, s.logger)
-	s.sessionHandler.Add(connID, serverSession)
 }
 
-func (s *server) handleInitialImpl(p *receivedPacket) (quicSession, protocol.ConnectionID, error) {
+func (s *server) handleInitialImpl(p *receivedPacket) (quicSession, error) {
 	hdr := p.hdr
 	if len(hdr.Token) == 0 && hdr.DestConnectionID.Len() < protocol.MinConnectionIDLenInitial {
-		return nil, nil, errors.New("dropping Initial packet with too short connection ID")
+		return nil, errors.New("dropping Initial packet with too short connection ID")
 	}
-	if len(p.data) < protocol.MinInitialPacketSize {
-		return nil, nil, errors.New("dropping too small Initial packet")
+	if p.datagramSize < protocol.MinInitialPacketSize {
+		return nil, errors.New("dropping Initial packet in a too small datagram")
 	}
 
 	var cookie *Cookie
 	var origDestConnectionID protocol.ConnectionID
 	if len(hdr.Token) > 0 {
-		c, err := s.cookieGenerator.DecodeToken(hdr.Token)
-		if err == nil {
-			cookie = &Cookie{
-				RemoteAddr: c.RemoteAddr,
-				SentTime:   c.SentTime,
-			}
-			origDestConnectionID = c.OriginalDestConnectionID
-		}
+		cookie, origDestConnectionID = s.validateCookie(hdr.Token, p.remoteAddr)
 	}
-	if !s.config.AcceptCookie(p.remoteAddr, cookie) {
+	switch s.acceptConnection(p, cookie) {
+	case RejectSilently:
+		s.logger.Debugf("Rejecting connection attempt from %s.", p.remoteAddr)
+		return nil, nil
+	case RejectWithRetry:
 		// Log the Initial packet now.
 		// If no Retry is sent, the packet will be logged by the session.
 		(&wire.ExtendedHeader{Header: *p.hdr}).Log(s.logger)
-		return nil, nil, s.sendRetry(p.remoteAddr, hdr)
+		return nil, s.sendRetry(p.remoteAddr, hdr)
 	}
 
 	if queueLen := atomic.LoadInt32(&s.sessionQueueLen); queueLen >= protocol.MaxAcceptQueueSize {
 		s.logger.Debugf("Rejecting new connection. Server currently busy. Accept queue length: %d (max %d)", queueLen, protocol.MaxAcceptQueueSize)
-		return nil, nil, s.sendServerBusy(p.remoteAddr, hdr)
+		return nil, s.sendServerBusy(p.remoteAddr, hdr)
+	}
+
+	var sess quicSession
+	for i := 0; ; i++ {
+		if i >= protocol.MaxConnectionIDGenerationAttempts {
+			return nil, fmt.Errorf("failed to generate an unused connection ID after %d attempts", i)
+		}
+		connID, err := generateConnectionIDForConfig(s.config)
+		if err != nil {
+			return nil, err
+		}
+		sess, err = s.createNewSession(
+			p.remoteAddr,
+			origDestConnectionID,
+			hdr.DestConnectionID,
+			hdr.SrcConnectionID,
+			connID,
+			cookie != nil && cookie.Valid,
+			hdr.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		// The session hasn't been started yet, so it can be dropped if the connection ID is already in use.
+		if s.sessionHandler.AddIfNotTaken(connID, newServerSession(sess, s.config, s.logger)) {
+			s.logger.Debugf("Changing connection ID to %s.", connID)
+			break
+		}
+		s.logger.Debugf("Connection ID %s is already in use. Generating a new one.", connID)
+	}
+	go sess.run()
+	if s.acceptEarlySessions {
+		go s.queueEarlySession(sess)
 	}
+	sess.handlePacket(p)
+	return sess, nil
+}
 
-	connID, err := protocol.GenerateConnectionID(s.config.ConnectionIDLength)
+// acceptConnection decides about a connection attempt, using the AcceptConnection callback if it is set.
+// Otherwise, a Retry is sent if the AcceptCookie callback doesn't accept the cookie.
+func (s *server) acceptConnection(p *receivedPacket, cookie *Cookie) Decision {
+	if s.config.AcceptConnection == nil {
+		if s.config.AcceptCookie(p.remoteAddr, cookie) {
+			return Accept
+		}
+		return RejectWithRetry
+	}
+	return s.config.AcceptConnection(ConnectionInfo{
+		RemoteAddr: p.remoteAddr,
+		Cookie:     cookie,
+		Version:    p.hdr.Version,
+		ServerName: getServerName(p),
+	})
+}
+
+// getServerName reads the server name from the ClientHello in an Initial packet.
+// The packet is decrypted into a copy, so that it can still be processed by the session.
+// It returns an empty string if the server name can't be read,
+// e.g. because the ClientHello doesn't fit into the first packet.
+func getServerName(p *receivedPacket) string {
+	hdr := p.hdr
+	hdrLen := int(hdr.ParsedLen())
+	if len(p.data) < hdrLen+4+16 {
+		return ""
+	}
+	_, opener, err := handshake.NewInitialAEAD(hdr.DestConnectionID, protocol.PerspectiveServer)
 	if err != nil {
-		return nil, nil, err
+		return ""
+	}
+	data := make([]byte, len(p.data))
+	copy(data, p.data)
+	origPNBytes := make([]byte, 4)
+	copy(origPNBytes, data[hdrLen:hdrLen+4])
+	opener.DecryptHeader(data[hdrLen+4:hdrLen+4+16], &data[0], data[hdrLen:hdrLen+4])
+	extHdr, err := hdr.ParseExtended(bytes.NewReader(data), hdr.Version)
+	if err != nil {
+		return ""
 	}
-	s.logger.Debugf("Changing connection ID to %s.", connID)
-	sess, err := s.createNewSession(
-		p.remoteAddr,
-		origDestConnectionID,
-		hdr.DestConnectionID,
-		hdr.SrcConnectionID,
-		connID,
-		hdr.Version,
-	)
+	extHdrLen := hdrLen + int(extHdr.PacketNumberLen)
+	copy(data[extHdrLen:hdrLen+4], origPNBytes[int(extHdr.PacketNumberLen):])
+	// The packet number of the first Initial packet is small, so it doesn't need to be decoded.
+	payload, err := opener.Open(data[extHdrLen:extHdrLen], data[extHdrLen:], extHdr.PacketNumber, data[:extHdrLen])
 	if err != nil {
-		return nil, nil, err
+		return ""
+	}
+	// Reassemble the beginning of the ClientHello from the CRYPTO frames.
+	var frames []*wire.CryptoFrame
+	parser := wire.NewFrameParser(hdr.Version)
+	r := bytes.NewReader(payload)
+	for {
+		frame, err := parser.ParseNext(r, payload)
+		if err != nil {
+			return ""
+		}
+		if frame == nil {
+			break
+		}
+		if f, ok := frame.(*wire.CryptoFrame); ok {
+			frames = append(frames, f)
+		}
 	}
-	sess.handlePacket(p)
-	return sess, connID, nil
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Offset < frames[j].Offset })
+	var clientHello []byte
+	for _, f := range frames {
+		if f.Offset > protocol.ByteCount(len(clientHello)) {
+			break
+		}
+		if end := f.Offset + protocol.ByteCount(len(f.Data)); end > protocol.ByteCount(len(clientHello)) {
+			clientHello = append(clientHello, f.Data[protocol.ByteCount(len(clientHello))-f.Offset:]...)
+		}
+	}
+	serverName, err := handshake.ParseServerName(clientHello)
+	if err != nil {
+		return ""
+	}
+	return serverName
 }
 
 func (s *server) createNewSession(
@@ -416,23 +772,26 @@ func (s *server) createNewSession(
 	clientDestConnID protocol.ConnectionID,
 	destConnID protocol.ConnectionID,
 	srcConnID protocol.ConnectionID,
+	clientAddressValidated bool,
 	version protocol.VersionNumber,
 ) (quicSession, error) {
+	token := s.sessionHandler.GetStatelessResetToken(srcConnID)
 	params := &handshake.TransportParameters{
-		InitialMaxStreamDataBidiLocal:  protocol.InitialMaxStreamData,
-		InitialMaxStreamDataBidiRemote: protocol.InitialMaxStreamData,
-		InitialMaxStreamDataUni:        protocol.InitialMaxStreamData,
-		InitialMaxData:                 protocol.InitialMaxData,
+		InitialMaxStreamDataBidiLocal:  initialMaxStreamData(s.config),
+		InitialMaxStreamDataBidiRemote: initialMaxStreamData(s.config),
+		InitialMaxStreamDataUni:        initialMaxStreamData(s.config),
+		InitialMaxData:                 initialMaxData(s.config),
 		IdleTimeout:                    s.config.IdleTimeout,
 		MaxBidiStreams:                 uint64(s.config.MaxIncomingStreams),
 		MaxUniStreams:                  uint64(s.config.MaxIncomingUniStreams),
-		DisableMigration:               true,
-		// TODO(#855): generate a real token
-		StatelessResetToken:  bytes.Repeat([]byte{42}, 16),
-		OriginalConnectionID: origDestConnID,
+		DisableMigration:               s.config.DisableMigration,
+		MaxDatagramFrameSize:           maxDatagramFrameSize(s.config),
+		MaxAckDelay:                    s.config.MaxAckDelay,
+		StatelessResetToken:            token[:],
+		OriginalConnectionID:           origDestConnID,
 	}
 	sess, err := s.newSession(
-		&conn{pconn: s.conn, currentAddr: remoteAddr},
+		&conn{pconn: s.conn, currentAddr: remoteAddr, ecnOOB: s.ecnOOB},
 		s.sessionRunner,
 		clientDestConnID,
 		destConnID,
@@ -440,22 +799,40 @@ func (s *server) createNewSession(
 		s.config,
 		s.tlsConf,
 		params,
+		clientAddressValidated,
 		s.logger,
 		version,
 	)
 	if err != nil {
 		return nil, err
 	}
-	go sess.run()
 	return sess, nil
 }
 
+// validateCookie validates a Cookie sent by the client.
+// If the Cookie is valid, it also returns the original destination connection ID saved in the Cookie.
+func (s *server) validateCookie(token []byte, remoteAddr net.Addr) (*Cookie, protocol.ConnectionID) {
+	cookie, data, err := s.cookieGenerator.Validate(token, remoteAddr)
+	if err != nil {
+		s.logger.Debugf("Received an invalid Cookie: %s", err)
+		return &Cookie{}, nil
+	}
+	if cookie == nil {
+		cookie = &Cookie{}
+	}
+	cookie.Valid = true
+	if len(data) == 0 {
+		return cookie, nil
+	}
+	return cookie, protocol.ConnectionID(data)
+}
+
 func (s *server) sendRetry(remoteAddr net.Addr, hdr *wire.Header) error {
-	token, err := s.cookieGenerator.NewToken(remoteAddr, hdr.DestConnectionID)
+	token, err := s.cookieGenerator.Generate(remoteAddr, hdr.DestConnectionID)
 	if err != nil {
 		return err
 	}
-	connID, err := protocol.GenerateConnectionID(s.config.ConnectionIDLength)
+	connID, err := generateConnectionIDForConfig(s.config)
 	if err != nil {
 		return err
 	}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"reflect"
 	"sync"
@@ -50,6 +52,31 @@ var _ = Describe("Server", func() {
 		Expect(err).To(MatchError("0x1234 is not a valid QUIC version"))
 	})
 
+	It("errors when the stream flow control window is larger than the connection flow control window", func() {
+		_, err := Listen(nil, tlsConf, &Config{MaxReceiveStreamFlowControlWindow: 1 << 25})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("MaxReceiveStreamFlowControlWindow"))
+	})
+
+	It("errors when the initial congestion window is too large", func() {
+		_, err := Listen(nil, tlsConf, &Config{InitialCongestionWindow: 5000})
+		Expect(err).To(MatchError("quic: InitialCongestionWindow (5000) is larger than the maximum congestion window (1000)"))
+	})
+
+	It("errors when the max ACK delay is too large", func() {
+		_, err := Listen(nil, tlsConf, &Config{MaxAckDelay: time.Minute})
+		Expect(err).To(MatchError("quic: MaxAckDelay (1m0s) is larger than the maximum (16.383s)"))
+	})
+
+	It("errors when too many active connection IDs are configured", func() {
+		_, err := Listen(nil, tlsConf, &Config{ActiveConnectionIDs: protocol.MaxActiveConnectionIDs + 1})
+		Expect(err).To(MatchError("quic: ActiveConnectionIDs (9) is larger than the maximum (8)"))
+	})
+
+	It("doesn't issue additional connection IDs if ActiveConnectionIDs is negative", func() {
+		Expect(populateServerConfig(&Config{ActiveConnectionIDs: -1}).ActiveConnectionIDs).To(Equal(1))
+	})
+
 	It("fills in default values if options are not set in the Config", func() {
 		ln, err := Listen(conn, tlsConf, &Config{})
 		Expect(err).ToNot(HaveOccurred())
@@ -59,6 +86,9 @@ var _ = Describe("Server", func() {
 		Expect(server.config.IdleTimeout).To(Equal(protocol.DefaultIdleTimeout))
 		Expect(reflect.ValueOf(server.config.AcceptCookie)).To(Equal(reflect.ValueOf(defaultAcceptCookie)))
 		Expect(server.config.KeepAlive).To(BeFalse())
+		Expect(server.config.ActiveConnectionIDs).To(Equal(protocol.DefaultActiveConnectionIDs))
+		Expect(server.config.InitialCongestionWindow).To(BeEquivalentTo(protocol.InitialCongestionWindow / protocol.DefaultTCPMSS))
+		Expect(server.config.MinCongestionWindow).To(BeEquivalentTo(protocol.DefaultMinCongestionWindow / protocol.DefaultTCPMSS))
 		// stop the listener
 		Expect(ln.Close()).To(Succeed())
 	})
@@ -66,12 +96,21 @@ var _ = Describe("Server", func() {
 	It("setups with the right values", func() {
 		supportedVersions := []protocol.VersionNumber{protocol.VersionTLS}
 		acceptCookie := func(_ net.Addr, _ *Cookie) bool { return true }
+		acceptConnection := func(ConnectionInfo) Decision { return Accept }
+		rateLimiter, err := NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 10})
+		Expect(err).ToNot(HaveOccurred())
 		config := Config{
-			Versions:         supportedVersions,
-			AcceptCookie:     acceptCookie,
-			HandshakeTimeout: 1337 * time.Hour,
-			IdleTimeout:      42 * time.Minute,
-			KeepAlive:        true,
+			Versions:             supportedVersions,
+			AcceptCookie:         acceptCookie,
+			AcceptConnection:     acceptConnection,
+			HandshakeRateLimiter: rateLimiter,
+			HandshakeTimeout:     1337 * time.Hour,
+			IdleTimeout:          42 * time.Minute,
+			KeepAlive:            true,
+			KeepAlivePeriod:      5 * time.Second,
+			EnableDatagrams:      true,
+			StatelessResetKey:    []byte("foobar"),
+			MaxStreamSendBuffer:  1 << 16,
 		}
 		ln, err := Listen(conn, tlsConf, &config)
 		Expect(err).ToNot(HaveOccurred())
@@ -81,11 +120,35 @@ var _ = Describe("Server", func() {
 		Expect(server.config.HandshakeTimeout).To(Equal(1337 * time.Hour))
 		Expect(server.config.IdleTimeout).To(Equal(42 * time.Minute))
 		Expect(reflect.ValueOf(server.config.AcceptCookie)).To(Equal(reflect.ValueOf(acceptCookie)))
+		Expect(reflect.ValueOf(server.config.AcceptConnection)).To(Equal(reflect.ValueOf(acceptConnection)))
+		Expect(server.config.HandshakeRateLimiter).To(Equal(rateLimiter))
 		Expect(server.config.KeepAlive).To(BeTrue())
+		Expect(server.config.KeepAlivePeriod).To(Equal(5 * time.Second))
+		Expect(server.config.EnableDatagrams).To(BeTrue())
+		Expect(server.config.StatelessResetKey).To(Equal([]byte("foobar")))
+		Expect(server.config.MaxStreamSendBuffer).To(BeEquivalentTo(1 << 16))
 		// stop the listener
 		Expect(ln.Close()).To(Succeed())
 	})
 
+	It("uses the Logger from the config", func() {
+		logger := &recordingLogger{}
+		ln, err := Listen(conn, tlsConf, &Config{Logger: logger})
+		Expect(err).ToNot(HaveOccurred())
+		ln.(*server).logger.Infof("foobar")
+		Expect(logger.Messages()).To(ContainElement("server foobar"))
+		Expect(ln.Close()).To(Succeed())
+	})
+
+	It("uses the CookieGenerator from the config", func() {
+		cookieGen, err := NewCookieGenerator(nil)
+		Expect(err).ToNot(HaveOccurred())
+		ln, err := Listen(conn, tlsConf, &Config{CookieGenerator: cookieGen})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ln.(*server).cookieGenerator).To(Equal(cookieGen))
+		Expect(ln.Close()).To(Succeed())
+	})
+
 	It("listens on a given address", func() {
 		addr := "127.0.0.1:13579"
 		ln, err := ListenAddr(addr, tlsConf, &Config{})
@@ -96,6 +159,11 @@ var _ = Describe("Server", func() {
 		Expect(ln.Close()).To(Succeed())
 	})
 
+	It("errors if the DiffServCodePoint can't be applied", func() {
+		_, err := ListenAddr("127.0.0.1:0", tlsConf, &Config{DiffServCodePoint: 64})
+		Expect(err).To(MatchError("quic: invalid DiffServCodePoint (64), must be smaller than 64"))
+	})
+
 	It("errors if given an invalid address", func() {
 		addr := "127.0.0.1"
 		_, err := ListenAddr(addr, tlsConf, &Config{})
@@ -143,7 +211,8 @@ var _ = Describe("Server", func() {
 					DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
 					Version:          serv.config.Versions[0],
 				},
-				data: bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize-100),
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize-100),
+				datagramSize: protocol.MinInitialPacketSize - 100,
 			}))
 			Consistently(conn.dataWritten).ShouldNot(Receive())
 		})
@@ -157,7 +226,8 @@ var _ = Describe("Server", func() {
 					DestConnectionID: protocol.ConnectionID{1, 2, 3, 4},
 					Version:          serv.config.Versions[0],
 				},
-				data: bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
 			}))
 			Consistently(conn.dataWritten).ShouldNot(Receive())
 		})
@@ -182,10 +252,13 @@ var _ = Describe("Server", func() {
 			serv.config.AcceptCookie = func(addr net.Addr, cookie *Cookie) bool {
 				Expect(addr).To(Equal(raddr))
 				Expect(cookie).ToNot(BeNil())
+				Expect(cookie.Valid).To(BeTrue())
+				Expect(cookie.RemoteAddr).To(Equal("192.168.13.37"))
+				Expect(cookie.SentTime).To(BeTemporally("~", time.Now(), time.Second))
 				close(done)
 				return false
 			}
-			token, err := serv.cookieGenerator.NewToken(raddr, nil)
+			token, err := serv.cookieGenerator.Generate(raddr, nil)
 			Expect(err).ToNot(HaveOccurred())
 			serv.handlePacket(insertPacketBuffer(&receivedPacket{
 				remoteAddr: raddr,
@@ -194,12 +267,60 @@ var _ = Describe("Server", func() {
 					Token:   token,
 					Version: serv.config.Versions[0],
 				},
-				data: bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
 			}))
 			Eventually(done).Should(BeClosed())
 		})
 
-		It("passes an empty cookie to the callback, if decoding fails", func() {
+		It("accepts tokens issued for NEW_TOKEN frames", func() {
+			raddr := &net.UDPAddr{
+				IP:   net.IPv4(192, 168, 13, 37),
+				Port: 1337,
+			}
+			token, err := serv.sessionRunner.newToken(raddr)
+			Expect(err).ToNot(HaveOccurred())
+			hdr := &wire.Header{
+				Type:             protocol.PacketTypeInitial,
+				SrcConnectionID:  protocol.ConnectionID{5, 4, 3, 2, 1},
+				DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+				Token:            token,
+				Version:          protocol.VersionTLS,
+			}
+			p := &receivedPacket{
+				remoteAddr:   raddr,
+				hdr:          hdr,
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
+			}
+			run := make(chan struct{})
+			serv.newSession = func(
+				_ connection,
+				_ sessionRunner,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ protocol.ConnectionID,
+				_ *Config,
+				_ *tls.Config,
+				params *handshake.TransportParameters,
+				clientAddressValidated bool,
+				_ utils.Logger,
+				_ protocol.VersionNumber,
+			) (quicSession, error) {
+				// the token doesn't contain an original destination connection ID, since no Retry was sent
+				Expect(params.OriginalConnectionID).To(BeEmpty())
+				Expect(clientAddressValidated).To(BeTrue())
+				sess := NewMockQuicSession(mockCtrl)
+				sess.EXPECT().handlePacket(p)
+				sess.EXPECT().run().Do(func() { close(run) })
+				return sess, nil
+			}
+			serv.handlePacket(insertPacketBuffer(p))
+			Eventually(run).Should(BeClosed())
+			Consistently(conn.dataWritten).ShouldNot(Receive())
+		})
+
+		It("passes an invalid cookie to the callback, if validation fails", func() {
 			raddr := &net.UDPAddr{
 				IP:   net.IPv4(192, 168, 13, 37),
 				Port: 1337,
@@ -207,7 +328,10 @@ var _ = Describe("Server", func() {
 			done := make(chan struct{})
 			serv.config.AcceptCookie = func(addr net.Addr, cookie *Cookie) bool {
 				Expect(addr).To(Equal(raddr))
-				Expect(cookie).To(BeNil())
+				Expect(cookie).ToNot(BeNil())
+				Expect(cookie.Valid).To(BeFalse())
+				Expect(cookie.RemoteAddr).To(BeEmpty())
+				Expect(cookie.SentTime).To(BeZero())
 				close(done)
 				return false
 			}
@@ -218,7 +342,8 @@ var _ = Describe("Server", func() {
 					Token:   []byte("foobar"),
 					Version: serv.config.Versions[0],
 				},
-				data: bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
 			}))
 			Eventually(done).Should(BeClosed())
 		})
@@ -256,9 +381,10 @@ var _ = Describe("Server", func() {
 				Version:          protocol.VersionTLS,
 			}
 			serv.handleInitial(insertPacketBuffer(&receivedPacket{
-				remoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337},
-				hdr:        hdr,
-				data:       bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				remoteAddr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337},
+				hdr:          hdr,
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
 			}))
 			var write mockPacketConnWrite
 			Eventually(conn.dataWritten).Should(Receive(&write))
@@ -280,8 +406,9 @@ var _ = Describe("Server", func() {
 				Version:          protocol.VersionTLS,
 			}
 			p := &receivedPacket{
-				hdr:  hdr,
-				data: bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				hdr:          hdr,
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
 			}
 			run := make(chan struct{})
 			serv.newSession = func(
@@ -293,10 +420,13 @@ var _ = Describe("Server", func() {
 				_ *Config,
 				_ *tls.Config,
 				_ *handshake.TransportParameters,
+				clientAddressValidated bool,
 				_ utils.Logger,
 				_ protocol.VersionNumber,
 			) (quicSession, error) {
 				Expect(origConnID).To(Equal(hdr.DestConnectionID))
+				// no token was sent, so the client's address wasn't validated
+				Expect(clientAddressValidated).To(BeFalse())
 				Expect(destConnID).To(Equal(hdr.SrcConnectionID))
 				// make sure we're using a server-generated connection ID
 				Expect(srcConnID).ToNot(Equal(hdr.DestConnectionID))
@@ -320,6 +450,399 @@ var _ = Describe("Server", func() {
 			Eventually(done).Should(BeClosed())
 		})
 
+		Context("accepting connections", func() {
+			var hdr *wire.Header
+
+			// getClientHello returns the ClientHello sent by a crypto/tls client
+			getClientHello := func(serverName string) []byte {
+				client, server := net.Pipe()
+				defer server.Close()
+				go tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+				recordHdr := make([]byte, 5)
+				_, err := io.ReadFull(server, recordHdr)
+				Expect(err).ToNot(HaveOccurred())
+				data := make([]byte, int(recordHdr[3])<<8+int(recordHdr[4]))
+				_, err = io.ReadFull(server, data)
+				Expect(err).ToNot(HaveOccurred())
+				client.Close()
+				return data
+			}
+
+			// composeInitial composes an Initial packet, sending the ClientHello in two CRYPTO frames
+			composeInitial := func(clientHello []byte) *receivedPacket {
+				sealer, _, err := handshake.NewInitialAEAD(hdr.DestConnectionID, protocol.PerspectiveClient)
+				Expect(err).ToNot(HaveOccurred())
+				frames := []*wire.CryptoFrame{
+					{Offset: 10, Data: clientHello[10:]},
+					{Data: clientHello[:10]},
+				}
+				extHdr := &wire.ExtendedHeader{
+					Header:          *hdr,
+					PacketNumber:    0,
+					PacketNumberLen: protocol.PacketNumberLen4,
+				}
+				extHdr.IsLongHeader = true
+				payload := &bytes.Buffer{}
+				for _, f := range frames {
+					Expect(f.Write(payload, hdr.Version)).To(Succeed())
+				}
+				// pad the payload, so that the packet is large enough
+				payload.Write(make([]byte, protocol.MinInitialPacketSize-payload.Len()))
+				extHdr.Length = protocol.ByteCount(int(extHdr.PacketNumberLen) + payload.Len() + sealer.Overhead())
+				buf := &bytes.Buffer{}
+				Expect(extHdr.Write(buf, hdr.Version)).To(Succeed())
+				payloadOffset := buf.Len()
+				buf.Write(payload.Bytes())
+				raw := buf.Bytes()
+				raw = sealer.Seal(raw[payloadOffset:payloadOffset], raw[payloadOffset:], extHdr.PacketNumber, raw[:payloadOffset])
+				raw = buf.Bytes()[:payloadOffset+len(raw)]
+				pnOffset := payloadOffset - int(extHdr.PacketNumberLen)
+				sealer.EncryptHeader(raw[pnOffset+4:pnOffset+4+16], &raw[0], raw[pnOffset:payloadOffset])
+				parsedHdr, err := wire.ParseHeader(bytes.NewReader(raw), 0)
+				Expect(err).ToNot(HaveOccurred())
+				return &receivedPacket{
+					remoteAddr:   &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1337},
+					hdr:          parsedHdr,
+					data:         raw,
+					datagramSize: protocol.ByteCount(len(raw)),
+				}
+			}
+
+			BeforeEach(func() {
+				serv.config.AcceptCookie = func(_ net.Addr, _ *Cookie) bool {
+					Fail("AcceptCookie should not be called")
+					return false
+				}
+				hdr = &wire.Header{
+					Type:             protocol.PacketTypeInitial,
+					SrcConnectionID:  protocol.ConnectionID{5, 4, 3, 2, 1},
+					DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+					Version:          protocol.VersionTLS,
+				}
+			})
+
+			It("passes the connection info to the callback, and rejects connections silently", func() {
+				p := composeInitial(getClientHello("quic.clemente.io"))
+				Expect(len(p.data)).To(BeNumerically(">", protocol.MinInitialPacketSize))
+				infoChan := make(chan ConnectionInfo, 1)
+				serv.config.AcceptConnection = func(info ConnectionInfo) Decision {
+					infoChan <- info
+					return RejectSilently
+				}
+				serv.newSession = func(connection, sessionRunner, protocol.ConnectionID, protocol.ConnectionID, protocol.ConnectionID, *Config, *tls.Config, *handshake.TransportParameters, bool, utils.Logger, protocol.VersionNumber) (quicSession, error) {
+					Fail("no session should be created")
+					return nil, nil
+				}
+				serv.handlePacket(insertPacketBuffer(p))
+				var info ConnectionInfo
+				Eventually(infoChan).Should(Receive(&info))
+				Expect(info.RemoteAddr).To(Equal(p.remoteAddr))
+				Expect(info.Cookie).To(BeNil())
+				Expect(info.Version).To(Equal(protocol.VersionTLS))
+				Expect(info.ServerName).To(Equal("quic.clemente.io"))
+				Consistently(conn.dataWritten).ShouldNot(Receive())
+			})
+
+			It("passes the cookie to the callback", func() {
+				sentTime := time.Now()
+				token, err := serv.cookieGenerator.Generate(&net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1337}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				hdr.Token = token
+				infoChan := make(chan ConnectionInfo, 1)
+				serv.config.AcceptConnection = func(info ConnectionInfo) Decision {
+					infoChan <- info
+					return RejectSilently
+				}
+				serv.handlePacket(insertPacketBuffer(composeInitial(getClientHello("quic.clemente.io"))))
+				var info ConnectionInfo
+				Eventually(infoChan).Should(Receive(&info))
+				Expect(info.Cookie).ToNot(BeNil())
+				Expect(info.Cookie.Valid).To(BeTrue())
+				Expect(info.Cookie.RemoteAddr).To(Equal("192.168.13.37"))
+				Expect(info.Cookie.SentTime).To(BeTemporally("~", sentTime, time.Second))
+				Expect(info.ServerName).To(Equal("quic.clemente.io"))
+			})
+
+			It("passes an empty server name if the packet can't be decrypted", func() {
+				infoChan := make(chan ConnectionInfo, 1)
+				serv.config.AcceptConnection = func(info ConnectionInfo) Decision {
+					infoChan <- info
+					return RejectSilently
+				}
+				p := composeInitial(getClientHello("quic.clemente.io"))
+				p.data[len(p.data)-1] ^= 0xff // corrupt the AEAD tag
+				serv.handlePacket(insertPacketBuffer(p))
+				var info ConnectionInfo
+				Eventually(infoChan).Should(Receive(&info))
+				Expect(info.ServerName).To(BeEmpty())
+			})
+
+			It("sends a Retry", func() {
+				serv.config.AcceptConnection = func(ConnectionInfo) Decision { return RejectWithRetry }
+				serv.handlePacket(insertPacketBuffer(composeInitial(getClientHello("quic.clemente.io"))))
+				var write mockPacketConnWrite
+				Eventually(conn.dataWritten).Should(Receive(&write))
+				replyHdr := parseHeader(write.data)
+				Expect(replyHdr.Type).To(Equal(protocol.PacketTypeRetry))
+				Expect(replyHdr.OrigDestConnectionID).To(Equal(hdr.DestConnectionID))
+				Expect(replyHdr.Token).ToNot(BeEmpty())
+			})
+
+			It("creates a session, without requiring a cookie", func() {
+				serv.config.AcceptConnection = func(ConnectionInfo) Decision { return Accept }
+				p := composeInitial(getClientHello("quic.clemente.io"))
+				run := make(chan struct{})
+				serv.newSession = func(_ connection, _ sessionRunner, _ protocol.ConnectionID, _ protocol.ConnectionID, _ protocol.ConnectionID, _ *Config, _ *tls.Config, _ *handshake.TransportParameters, clientAddressValidated bool, _ utils.Logger, _ protocol.VersionNumber) (quicSession, error) {
+					Expect(clientAddressValidated).To(BeFalse())
+					sess := NewMockQuicSession(mockCtrl)
+					sess.EXPECT().handlePacket(p)
+					sess.EXPECT().run().Do(func() { close(run) })
+					return sess, nil
+				}
+				serv.handlePacket(insertPacketBuffer(p))
+				Eventually(run).Should(BeClosed())
+				Consistently(conn.dataWritten).ShouldNot(Receive())
+			})
+		})
+
+		Context("rate limiting handshakes", func() {
+			var hdr *wire.Header
+			var attempts chan struct{}
+
+			newInitialPacket := func(ip net.IP) *receivedPacket {
+				return &receivedPacket{
+					remoteAddr:   &net.UDPAddr{IP: ip, Port: 1337},
+					hdr:          hdr,
+					data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+					datagramSize: protocol.MinInitialPacketSize,
+				}
+			}
+
+			BeforeEach(func() {
+				attempts = make(chan struct{}, 10)
+				serv.config.AcceptConnection = func(ConnectionInfo) Decision {
+					attempts <- struct{}{}
+					return RejectSilently
+				}
+				hdr = &wire.Header{
+					Type:             protocol.PacketTypeInitial,
+					SrcConnectionID:  protocol.ConnectionID{5, 4, 3, 2, 1},
+					DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+					Version:          protocol.VersionTLS,
+				}
+			})
+
+			It("drops Initial packets exceeding the rate", func() {
+				rateLimiter, err := NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 0.001, Burst: 2})
+				Expect(err).ToNot(HaveOccurred())
+				serv.config.HandshakeRateLimiter = rateLimiter
+				for i := 0; i < 3; i++ {
+					serv.handlePacket(insertPacketBuffer(newInitialPacket(net.IPv4(192, 168, 13, 37))))
+				}
+				Eventually(attempts).Should(HaveLen(2))
+				Consistently(attempts).Should(HaveLen(2))
+				Expect(rateLimiter.Stats()).To(Equal(HandshakeRateLimiterStats{PacketsDropped: 1}))
+				Expect(conn.dataWritten).ToNot(Receive())
+				// other clients are not affected
+				serv.handlePacket(insertPacketBuffer(newInitialPacket(net.IPv4(192, 168, 13, 38))))
+				Eventually(attempts).Should(HaveLen(3))
+			})
+
+			It("sends a Retry for Initial packets exceeding the rate", func() {
+				rateLimiter, err := NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 0.001, SendRetry: true})
+				Expect(err).ToNot(HaveOccurred())
+				serv.config.HandshakeRateLimiter = rateLimiter
+				serv.handlePacket(insertPacketBuffer(newInitialPacket(net.IPv4(192, 168, 13, 37))))
+				Eventually(attempts).Should(HaveLen(1))
+				serv.handlePacket(insertPacketBuffer(newInitialPacket(net.IPv4(192, 168, 13, 37))))
+				var write mockPacketConnWrite
+				Eventually(conn.dataWritten).Should(Receive(&write))
+				replyHdr := parseHeader(write.data)
+				Expect(replyHdr.Type).To(Equal(protocol.PacketTypeRetry))
+				Expect(replyHdr.OrigDestConnectionID).To(Equal(hdr.DestConnectionID))
+				Expect(attempts).To(HaveLen(1))
+				Expect(rateLimiter.Stats()).To(Equal(HandshakeRateLimiterStats{RetriesSent: 1}))
+			})
+
+			It("applies the rate limit to Initial packets carrying an invalid Cookie", func() {
+				rateLimiter, err := NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 0.001, CookieBurst: 2})
+				Expect(err).ToNot(HaveOccurred())
+				serv.config.HandshakeRateLimiter = rateLimiter
+				cookieGen := &countingCookieGenerator{CookieGenerator: serv.cookieGenerator}
+				serv.cookieGenerator = cookieGen
+				hdr.Token = []byte("foobar")
+				for i := 0; i < 5; i++ {
+					serv.handlePacket(insertPacketBuffer(newInitialPacket(net.IPv4(192, 168, 13, 37))))
+				}
+				Eventually(attempts).Should(HaveLen(1))
+				Consistently(conn.dataWritten).ShouldNot(Receive())
+				Expect(rateLimiter.Stats()).To(Equal(HandshakeRateLimiterStats{PacketsDropped: 4}))
+				// The Cookie is validated when processing the accepted packet,
+				// and for the first 2 packets exceeding the rate.
+				Expect(cookieGen.Validations()).To(Equal(3))
+			})
+
+			It("uses a larger budget for Initial packets carrying a valid Cookie", func() {
+				rateLimiter, err := NewHandshakeRateLimiter(&HandshakeRateLimiterConfig{Rate: 0.001, Burst: 2, CookieBurst: 2})
+				Expect(err).ToNot(HaveOccurred())
+				serv.config.HandshakeRateLimiter = rateLimiter
+				// an attacker spoofing the client's address drains the bucket
+				for i := 0; i < 5; i++ {
+					serv.handlePacket(insertPacketBuffer(newInitialPacket(net.IPv4(192, 168, 13, 37))))
+				}
+				Eventually(attempts).Should(HaveLen(2))
+				Expect(rateLimiter.Stats()).To(Equal(HandshakeRateLimiterStats{PacketsDropped: 3}))
+				// the client owning the address can still connect
+				token, err := serv.cookieGenerator.Generate(&net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1337}, nil)
+				Expect(err).ToNot(HaveOccurred())
+				tokenHdr := *hdr
+				tokenHdr.Token = token
+				for i := 0; i < 3; i++ {
+					p := newInitialPacket(net.IPv4(192, 168, 13, 37))
+					p.hdr = &tokenHdr
+					serv.handlePacket(insertPacketBuffer(p))
+				}
+				Eventually(attempts).Should(HaveLen(4))
+				Consistently(attempts).Should(HaveLen(4))
+				// the budget for packets carrying a valid Cookie is limited as well
+				Expect(rateLimiter.Stats()).To(Equal(HandshakeRateLimiterStats{PacketsDropped: 4}))
+			})
+		})
+
+		Context("generating connection IDs", func() {
+			var hdr *wire.Header
+
+			newInitialPacket := func() *receivedPacket {
+				return &receivedPacket{
+					hdr:          hdr,
+					data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+					datagramSize: protocol.MinInitialPacketSize,
+				}
+			}
+
+			BeforeEach(func() {
+				serv.config.AcceptCookie = func(_ net.Addr, _ *Cookie) bool { return true }
+				hdr = &wire.Header{
+					Type:             protocol.PacketTypeInitial,
+					SrcConnectionID:  protocol.ConnectionID{5, 4, 3, 2, 1},
+					DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+					Version:          protocol.VersionTLS,
+				}
+			})
+
+			It("uses the ConnectionIDGenerator", func() {
+				serv.config.ConnectionIDGenerator = func(l int) ([]byte, error) {
+					Expect(l).To(Equal(serv.config.ConnectionIDLength))
+					return bytes.Repeat([]byte{0x42}, l), nil
+				}
+				p := newInitialPacket()
+				run := make(chan struct{})
+				serv.newSession = func(
+					_ connection,
+					_ sessionRunner,
+					_ protocol.ConnectionID,
+					_ protocol.ConnectionID,
+					srcConnID protocol.ConnectionID,
+					_ *Config,
+					_ *tls.Config,
+					_ *handshake.TransportParameters,
+					_ bool,
+					_ utils.Logger,
+					_ protocol.VersionNumber,
+				) (quicSession, error) {
+					Expect(srcConnID).To(Equal(protocol.ConnectionID(bytes.Repeat([]byte{0x42}, serv.config.ConnectionIDLength))))
+					sess := NewMockQuicSession(mockCtrl)
+					sess.EXPECT().handlePacket(p)
+					sess.EXPECT().run().Do(func() { close(run) })
+					return sess, nil
+				}
+				sess, err := serv.handleInitialImpl(p)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sess).ToNot(BeNil())
+				Eventually(run).Should(BeClosed())
+			})
+
+			It("generates a new connection ID if the generated one is already in use", func() {
+				takenConnID := protocol.ConnectionID(bytes.Repeat([]byte{1}, serv.config.ConnectionIDLength))
+				unusedConnID := protocol.ConnectionID(bytes.Repeat([]byte{2}, serv.config.ConnectionIDLength))
+				serv.sessionHandler.Add(takenConnID, NewMockPacketHandler(mockCtrl))
+				connIDs := []protocol.ConnectionID{takenConnID, unusedConnID}
+				serv.config.ConnectionIDGenerator = func(int) ([]byte, error) {
+					c := connIDs[0]
+					connIDs = connIDs[1:]
+					return c, nil
+				}
+				p := newInitialPacket()
+				var srcConnIDs []protocol.ConnectionID
+				run := make(chan struct{})
+				serv.newSession = func(
+					_ connection,
+					_ sessionRunner,
+					_ protocol.ConnectionID,
+					_ protocol.ConnectionID,
+					srcConnID protocol.ConnectionID,
+					_ *Config,
+					_ *tls.Config,
+					_ *handshake.TransportParameters,
+					_ bool,
+					_ utils.Logger,
+					_ protocol.VersionNumber,
+				) (quicSession, error) {
+					srcConnIDs = append(srcConnIDs, srcConnID)
+					sess := NewMockQuicSession(mockCtrl)
+					if srcConnID.Equal(unusedConnID) {
+						sess.EXPECT().handlePacket(p)
+						sess.EXPECT().run().Do(func() { close(run) })
+					}
+					return sess, nil
+				}
+				_, err := serv.handleInitialImpl(p)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(srcConnIDs).To(Equal([]protocol.ConnectionID{takenConnID, unusedConnID}))
+				Eventually(run).Should(BeClosed())
+			})
+
+			It("errors if it can't generate an unused connection ID", func() {
+				takenConnID := protocol.ConnectionID(bytes.Repeat([]byte{1}, serv.config.ConnectionIDLength))
+				serv.sessionHandler.Add(takenConnID, NewMockPacketHandler(mockCtrl))
+				serv.config.ConnectionIDGenerator = func(int) ([]byte, error) { return takenConnID, nil }
+				serv.newSession = func(
+					_ connection,
+					_ sessionRunner,
+					_ protocol.ConnectionID,
+					_ protocol.ConnectionID,
+					_ protocol.ConnectionID,
+					_ *Config,
+					_ *tls.Config,
+					_ *handshake.TransportParameters,
+					_ bool,
+					_ utils.Logger,
+					_ protocol.VersionNumber,
+				) (quicSession, error) {
+					return NewMockQuicSession(mockCtrl), nil
+				}
+				_, err := serv.handleInitialImpl(newInitialPacket())
+				Expect(err).To(MatchError(fmt.Sprintf("failed to generate an unused connection ID after %d attempts", protocol.MaxConnectionIDGenerationAttempts)))
+			})
+
+			It("errors if the ConnectionIDGenerator returns a connection ID of the wrong length", func() {
+				serv.config.ConnectionIDGenerator = func(l int) ([]byte, error) { return make([]byte, l+1), nil }
+				_, err := serv.handleInitialImpl(newInitialPacket())
+				Expect(err).To(MatchError(fmt.Sprintf("quic: ConnectionIDGenerator returned a connection ID of length %d, expected %d", serv.config.ConnectionIDLength+1, serv.config.ConnectionIDLength)))
+			})
+
+			It("uses the ConnectionIDGenerator for Retry packets", func() {
+				serv.config.AcceptCookie = func(_ net.Addr, _ *Cookie) bool { return false }
+				serv.config.ConnectionIDGenerator = func(l int) ([]byte, error) { return bytes.Repeat([]byte{0x42}, l), nil }
+				Expect(serv.sendRetry(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}, hdr)).To(Succeed())
+				var write mockPacketConnWrite
+				Eventually(conn.dataWritten).Should(Receive(&write))
+				replyHdr := parseHeader(write.data)
+				Expect(replyHdr.Type).To(Equal(protocol.PacketTypeRetry))
+				Expect(replyHdr.SrcConnectionID).To(Equal(protocol.ConnectionID(bytes.Repeat([]byte{0x42}, serv.config.ConnectionIDLength))))
+			})
+		})
+
 		It("rejects new connection attempts if the accept queue is full", func() {
 			serv.config.AcceptCookie = func(_ net.Addr, _ *Cookie) bool { return true }
 			senderAddr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 42}
@@ -331,9 +854,10 @@ var _ = Describe("Server", func() {
 				Version:          protocol.VersionTLS,
 			}
 			p := &receivedPacket{
-				remoteAddr: senderAddr,
-				hdr:        hdr,
-				data:       bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				remoteAddr:   senderAddr,
+				hdr:          hdr,
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
 			}
 			serv.newSession = func(
 				_ connection,
@@ -344,6 +868,7 @@ var _ = Describe("Server", func() {
 				_ *Config,
 				_ *tls.Config,
 				_ *handshake.TransportParameters,
+				_ bool,
 				_ utils.Logger,
 				_ protocol.VersionNumber,
 			) (quicSession, error) {
@@ -389,9 +914,10 @@ var _ = Describe("Server", func() {
 				Version:          protocol.VersionTLS,
 			}
 			p := &receivedPacket{
-				remoteAddr: senderAddr,
-				hdr:        hdr,
-				data:       bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				remoteAddr:   senderAddr,
+				hdr:          hdr,
+				data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+				datagramSize: protocol.MinInitialPacketSize,
 			}
 			ctx, cancel := context.WithCancel(context.Background())
 			sessionCreated := make(chan struct{})
@@ -405,6 +931,7 @@ var _ = Describe("Server", func() {
 				_ *Config,
 				_ *tls.Config,
 				_ *handshake.TransportParameters,
+				_ bool,
 				_ utils.Logger,
 				_ protocol.VersionNumber,
 			) (quicSession, error) {
@@ -425,7 +952,7 @@ var _ = Describe("Server", func() {
 			done := make(chan struct{})
 			go func() {
 				defer GinkgoRecover()
-				serv.Accept()
+				serv.Accept(context.Background())
 				close(done)
 			}()
 			Consistently(done).ShouldNot(BeClosed())
@@ -452,7 +979,7 @@ var _ = Describe("Server", func() {
 			done := make(chan struct{})
 			go func() {
 				defer GinkgoRecover()
-				_, err := serv.Accept()
+				_, err := serv.Accept(context.Background())
 				Expect(err).To(MatchError(testErr))
 				close(done)
 			}()
@@ -461,11 +988,44 @@ var _ = Describe("Server", func() {
 			Eventually(done).Should(BeClosed())
 		})
 
+		It("returns ErrServerClosed when the server is closed", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := serv.Accept(context.Background())
+				Expect(err).To(MatchError(ErrServerClosed))
+				Expect(errors.Is(err, ErrServerClosed)).To(BeTrue())
+				close(done)
+			}()
+
+			Consistently(done).ShouldNot(BeClosed())
+			Expect(serv.Close()).To(Succeed())
+			Eventually(done).Should(BeClosed())
+			// subsequent calls return immediately
+			_, err := serv.Accept(context.Background())
+			Expect(err).To(MatchError(ErrServerClosed))
+		})
+
+		It("returns when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := serv.Accept(ctx)
+				Expect(err).To(MatchError(context.Canceled))
+				close(done)
+			}()
+
+			Consistently(done).ShouldNot(BeClosed())
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+
 		It("returns immediately, if an error occurred before", func() {
 			testErr := errors.New("test err")
 			Expect(serv.closeWithError(testErr)).To(Succeed())
 			for i := 0; i < 3; i++ {
-				_, err := serv.Accept()
+				_, err := serv.Accept(context.Background())
 				Expect(err).To(MatchError(testErr))
 			}
 		})
@@ -476,7 +1036,7 @@ var _ = Describe("Server", func() {
 			done := make(chan struct{})
 			go func() {
 				defer GinkgoRecover()
-				s, err := serv.Accept()
+				s, err := serv.Accept(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(s).To(Equal(sess))
 				close(done)
@@ -492,6 +1052,7 @@ var _ = Describe("Server", func() {
 				_ *Config,
 				_ *tls.Config,
 				_ *handshake.TransportParameters,
+				_ bool,
 				_ utils.Logger,
 				_ protocol.VersionNumber,
 			) (quicSession, error) {
@@ -499,11 +1060,10 @@ var _ = Describe("Server", func() {
 					<-completeHandshake
 					runner.onHandshakeComplete(sess)
 				}()
-				sess.EXPECT().run().Do(func() {})
 				sess.EXPECT().Context().Return(context.Background())
 				return sess, nil
 			}
-			_, err := serv.createNewSession(&net.UDPAddr{}, nil, nil, nil, nil, protocol.VersionWhatever)
+			_, err := serv.createNewSession(&net.UDPAddr{}, nil, nil, nil, nil, false, protocol.VersionWhatever)
 			Expect(err).ToNot(HaveOccurred())
 			Consistently(done).ShouldNot(BeClosed())
 			close(completeHandshake)
@@ -523,11 +1083,11 @@ var _ = Describe("Server", func() {
 				_ *Config,
 				_ *tls.Config,
 				_ *handshake.TransportParameters,
+				_ bool,
 				_ utils.Logger,
 				_ protocol.VersionNumber,
 			) (quicSession, error) {
 				sess := NewMockQuicSession(mockCtrl)
-				sess.EXPECT().run().Do(func() {})
 				sess.EXPECT().Context().Return(context.Background())
 				runner.onHandshakeComplete(sess)
 				done <- struct{}{}
@@ -536,7 +1096,7 @@ var _ = Describe("Server", func() {
 
 			go func() {
 				for i := 0; i < num; i++ {
-					_, err := serv.createNewSession(&net.UDPAddr{}, nil, nil, nil, nil, protocol.VersionWhatever)
+					_, err := serv.createNewSession(&net.UDPAddr{}, nil, nil, nil, nil, false, protocol.VersionWhatever)
 					Expect(err).ToNot(HaveOccurred())
 				}
 			}()
@@ -545,14 +1105,112 @@ var _ = Describe("Server", func() {
 	})
 })
 
+var _ = Describe("Early Server", func() {
+	var (
+		conn *mockPacketConn
+		ln   EarlyListener
+		serv *server
+		sess *MockQuicSession
+		p    *receivedPacket
+	)
+
+	BeforeEach(func() {
+		conn = newMockPacketConn()
+		conn.addr = &net.UDPAddr{}
+		var err error
+		ln, err = ListenEarly(conn, testdata.GetTLSConfig(), &Config{AcceptCookie: func(net.Addr, *Cookie) bool { return true }})
+		Expect(err).ToNot(HaveOccurred())
+		serv = ln.(*earlyServer).server
+		Expect(serv.acceptEarlySessions).To(BeTrue())
+		sess = NewMockQuicSession(mockCtrl)
+		p = &receivedPacket{
+			remoteAddr: &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 42},
+			hdr: &wire.Header{
+				Type:             protocol.PacketTypeInitial,
+				SrcConnectionID:  protocol.ConnectionID{5, 4, 3, 2, 1},
+				DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+				Version:          protocol.VersionTLS,
+			},
+			data:         bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+			datagramSize: protocol.MinInitialPacketSize,
+		}
+	})
+
+	// createSession creates a new session by passing an Initial packet to the server
+	createSession := func(ctx context.Context, ready <-chan struct{}) {
+		serv.newSession = func(
+			_ connection,
+			_ sessionRunner,
+			_ protocol.ConnectionID,
+			_ protocol.ConnectionID,
+			_ protocol.ConnectionID,
+			_ *Config,
+			_ *tls.Config,
+			_ *handshake.TransportParameters,
+			_ bool,
+			_ utils.Logger,
+			_ protocol.VersionNumber,
+		) (quicSession, error) {
+			sess.EXPECT().handlePacket(p)
+			sess.EXPECT().run()
+			sess.EXPECT().earlySessionReady().Return(ready)
+			sess.EXPECT().Context().Return(ctx).AnyTimes()
+			return sess, nil
+		}
+		_, err := serv.handleInitialImpl(p)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("accepts sessions as soon as the ClientHello was processed", func() {
+		ready := make(chan struct{})
+		createSession(context.Background(), ready)
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			s, err := ln.Accept(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(Equal(sess))
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+		close(ready)
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("doesn't accept the session again when the handshake completes", func() {
+		ready := make(chan struct{})
+		close(ready)
+		createSession(context.Background(), ready)
+		_, err := ln.Accept(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		serv.sessionRunner.onHandshakeComplete(sess)
+		ctx, cancel := context.WithTimeout(context.Background(), scaleDuration(50*time.Millisecond))
+		defer cancel()
+		_, err = ln.Accept(ctx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("doesn't accept sessions that were closed before the ClientHello was processed", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		createSession(ctx, make(chan struct{}))
+		cancel()
+		acceptCtx, acceptCancel := context.WithTimeout(context.Background(), scaleDuration(50*time.Millisecond))
+		defer acceptCancel()
+		_, err := ln.Accept(acceptCtx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("returns ErrServerClosed when the server is closed", func() {
+		Expect(ln.Close()).To(Succeed())
+		_, err := ln.Accept(context.Background())
+		Expect(err).To(MatchError(ErrServerClosed))
+	})
+})
+
 var _ = Describe("default source address verification", func() {
-	It("accepts a token", func() {
+	It("accepts a valid token", func() {
 		remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1)}
-		cookie := &Cookie{
-			RemoteAddr: "192.168.0.1",
-			SentTime:   time.Now().Add(-protocol.CookieExpiryTime).Add(time.Second), // will expire in 1 second
-		}
-		Expect(defaultAcceptCookie(remoteAddr, cookie)).To(BeTrue())
+		Expect(defaultAcceptCookie(remoteAddr, &Cookie{Valid: true})).To(BeTrue())
 	})
 
 	It("requests verification if no token is provided", func() {
@@ -560,39 +1218,103 @@ var _ = Describe("default source address verification", func() {
 		Expect(defaultAcceptCookie(remoteAddr, nil)).To(BeFalse())
 	})
 
-	It("rejects a token if the address doesn't match", func() {
+	It("rejects an invalid token", func() {
 		remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1)}
-		cookie := &Cookie{
-			RemoteAddr: "127.0.0.1",
-			SentTime:   time.Now(),
-		}
-		Expect(defaultAcceptCookie(remoteAddr, cookie)).To(BeFalse())
+		Expect(defaultAcceptCookie(remoteAddr, &Cookie{Valid: false})).To(BeFalse())
 	})
+})
 
-	It("accepts a token for a remote address is not a UDP address", func() {
-		remoteAddr := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
-		cookie := &Cookie{
-			RemoteAddr: "192.168.0.1:1337",
-			SentTime:   time.Now(),
-		}
-		Expect(defaultAcceptCookie(remoteAddr, cookie)).To(BeTrue())
+var _ = Describe("Cookie Generator", func() {
+	It("uses a static key", func() {
+		key := bytes.Repeat([]byte{0x42}, 32)
+		gen1, err := NewCookieGenerator(&CookieGeneratorConfig{Key: key})
+		Expect(err).ToNot(HaveOccurred())
+		gen2, err := NewCookieGenerator(&CookieGeneratorConfig{Key: key})
+		Expect(err).ToNot(HaveOccurred())
+		raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		cookie, err := gen1.Generate(raddr, []byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		c, data, err := gen2.Validate(cookie, raddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.RemoteAddr).To(Equal("192.168.0.1"))
+		Expect(c.SentTime).To(BeTemporally("~", time.Now(), time.Second))
+		Expect(data).To(Equal([]byte("foobar")))
 	})
 
-	It("rejects an invalid token for a remote address is not a UDP address", func() {
-		remoteAddr := &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
-		cookie := &Cookie{
-			RemoteAddr: "192.168.0.1:7331", // mismatching port
-			SentTime:   time.Now(),
-		}
-		Expect(defaultAcceptCookie(remoteAddr, cookie)).To(BeFalse())
+	It("errors if the key has the wrong length", func() {
+		_, err := NewCookieGenerator(&CookieGeneratorConfig{Key: []byte("foobar")})
+		Expect(err).To(MatchError("quic: the cookie key must be 32 bytes long"))
 	})
 
-	It("rejects an expired token", func() {
-		remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1)}
-		cookie := &Cookie{
-			RemoteAddr: "192.168.0.1",
-			SentTime:   time.Now().Add(-protocol.CookieExpiryTime).Add(-time.Second), // expired 1 second ago
-		}
-		Expect(defaultAcceptCookie(remoteAddr, cookie)).To(BeFalse())
+	It("binds cookies to the configured network", func() {
+		gen, err := NewCookieGenerator(&CookieGeneratorConfig{IPv4PrefixLen: 24})
+		Expect(err).ToNot(HaveOccurred())
+		cookie, err := gen.Generate(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		c, _, err := gen.Validate(cookie, &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 1337})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.RemoteAddr).To(Equal("192.168.0.0"))
+		_, _, err = gen.Validate(cookie, &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 1337})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("uses the configured clock", func() {
+		clock := &mockClock{now: time.Now().Add(-time.Hour)}
+		gen, err := NewCookieGenerator(&CookieGeneratorConfig{Lifetime: time.Minute, Clock: clock})
+		Expect(err).ToNot(HaveOccurred())
+		raddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+		cookie, err := gen.Generate(raddr, nil)
+		Expect(err).ToNot(HaveOccurred())
+		c, _, err := gen.Validate(cookie, raddr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.SentTime).To(BeTemporally("~", clock.Now(), time.Second))
+		clock.Advance(2 * time.Minute)
+		_, _, err = gen.Validate(cookie, raddr)
+		Expect(err).To(MatchError("cookie expired"))
 	})
 })
+
+type countingCookieGenerator struct {
+	CookieGenerator
+
+	mutex       sync.Mutex
+	validations int
+}
+
+func (g *countingCookieGenerator) Validate(cookie []byte, clientAddr net.Addr) (*Cookie, []byte, error) {
+	g.mutex.Lock()
+	g.validations++
+	g.mutex.Unlock()
+	return g.CookieGenerator.Validate(cookie, clientAddr)
+}
+
+func (g *countingCookieGenerator) Validations() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.validations
+}
+
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+var _ Logger = &recordingLogger{}
+
+func (l *recordingLogger) Debug() bool { return false }
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) { l.log(format, args...) }
+func (l *recordingLogger) Infof(format string, args ...interface{})  { l.log(format, args...) }
+func (l *recordingLogger) Debugf(format string, args ...interface{}) { l.log(format, args...) }
+
+func (l *recordingLogger) log(format string, args ...interface{}) {
+	l.mutex.Lock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+	l.mutex.Unlock()
+}
+
+func (l *recordingLogger) Messages() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string{}, l.messages...)
+}
@@ -3,6 +3,7 @@ package quic
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -20,6 +21,8 @@ import (
 	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/lucas-clemente/quic-go/qlog"
 )
 
 type unpacker interface {
@@ -36,27 +39,41 @@ type streamManager interface {
 	GetOrOpenReceiveStream(protocol.StreamID) (receiveStreamI, error)
 	OpenStream() (Stream, error)
 	OpenUniStream() (SendStream, error)
-	OpenStreamSync() (Stream, error)
-	OpenUniStreamSync() (SendStream, error)
-	AcceptStream() (Stream, error)
-	AcceptUniStream() (ReceiveStream, error)
+	OpenStreamSync(context.Context) (Stream, error)
+	OpenUniStreamSync(context.Context) (SendStream, error)
+	AcceptStream(context.Context) (Stream, error)
+	AcceptUniStream(context.Context) (ReceiveStream, error)
 	DeleteStream(protocol.StreamID) error
 	UpdateLimits(*handshake.TransportParameters)
 	HandleMaxStreamsFrame(*wire.MaxStreamsFrame) error
+	HasUnsentData() bool
 	CloseWithError(error)
+	ackhandler.StreamFrameHandler
 }
 
 type cryptoStreamHandler interface {
 	RunHandshake() error
 	io.Closer
 	ConnectionState() handshake.ConnectionState
+	ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error)
+}
+
+// sessionTimer is implemented by the utils.Timer.
+// It is an interface, so that it can be replaced in tests.
+type sessionTimer interface {
+	Chan() <-chan time.Time
+	SetRead()
+	Reset(time.Time)
 }
 
 type receivedPacket struct {
 	remoteAddr net.Addr
 	hdr        *wire.Header
 	rcvTime    time.Time
+	ecn        protocol.ECN
 	data       []byte
+	// the size of the UDP datagram, which might contain multiple coalesced packets
+	datagramSize protocol.ByteCount
 
 	buffer *packetBuffer
 }
@@ -67,8 +84,30 @@ type closeError struct {
 	sendClose bool
 }
 
+// quicError converts the close error to a QuicError.
+// A nil error means that the session was closed without an error.
+func (e closeError) quicError() *qerr.QuicError {
+	if e.err == nil {
+		return qerr.ToQuicError(qerr.PeerGoingAway)
+	}
+	return qerr.ToQuicError(e.err)
+}
+
+// connectionError is the error returned to the application, e.g. by stream operations.
+func (e closeError) connectionError() *ConnectionError {
+	if e.err == nil {
+		return newConnectionError(qerr.PeerGoingAway, e.remote)
+	}
+	return newConnectionError(e.err, e.remote)
+}
+
 var errCloseForRecreating = errors.New("closing session in order to recreate it")
 
+var (
+	errSessionDraining      = errors.New("session is being closed gracefully")
+	errGracefulCloseTimeout = errors.New("timeout while waiting for stream data to be delivered")
+)
+
 // A Session is a QUIC session
 type session struct {
 	sessionRunner sessionRunner
@@ -76,11 +115,17 @@ type session struct {
 	destConnID protocol.ConnectionID
 	srcConnID  protocol.ConnectionID
 
+	connIDManager   *connIDManager   // the connection IDs issued by the peer
+	connIDGenerator *connIDGenerator // the connection IDs issued to the peer
+
 	perspective protocol.Perspective
 	version     protocol.VersionNumber
 	config      *Config
 
-	conn connection
+	// connMutex protects conn, which is replaced when migrating to a new packet conn.
+	// The run loop is the only one replacing it, so it doesn't need to acquire the mutex for reading.
+	connMutex sync.RWMutex
+	conn      connection
 
 	streamsMap streamManager
 
@@ -95,6 +140,9 @@ type session struct {
 
 	unpacker unpacker
 	packer   packer
+	// The frameParser reuses STREAM and ACK frames.
+	// Every frame must be handled completely before the next frame is parsed.
+	frameParser *wire.FrameParser
 
 	cryptoStreamHandler cryptoStreamHandler
 
@@ -108,14 +156,52 @@ type session struct {
 	connectionClosePacket     *packedPacket
 	packetsReceivedAfterClose int
 
+	// gracefulCloseChan is used to notify the run loop that it should close the session,
+	// as soon as all data written to the streams has been delivered.
+	gracefulCloseChan chan time.Duration
+	gracefulCloseOnce sync.Once
+	draining          utils.AtomicBool
+	// drainDeadline is the time when a graceful close is aborted, only used by the run loop
+	drainDeadline time.Time
+	drainTimedOut bool
+
+	datagramQueue *datagramQueue
+	spinBit       *spinBit
+
+	// pingRequests is used to pass PINGs requested by the application to the run loop
+	pingRequests chan *pingRequest
+	// pendingPings are the PINGs that were queued, but not sent yet, only used by the run loop
+	pendingPings []*pingRequest
+
+	// migrationRequests is used to pass connection migrations requested by the application to the run loop
+	migrationRequests chan *migrationRequest
+	// pendingMigration is the migration waiting for the new path to be validated, only used by the run loop
+	pendingMigration *migrationRequest
+	// pathValidation is the validation of a new address of the peer, only used by the run loop
+	pathValidation *pathValidation
+
+	// stats is a snapshot of the statistics, updated by the run loop
+	statsMutex sync.Mutex
+	stats      SessionStats
+	// sendState is protected by the statsMutex, and only modified by the run loop
+	sendState sendStateTracker
+	// congestionLimited is set by sendPackets if sending was stopped by the congestion controller or the pacer
+	congestionLimited bool
+	// connFlowControlBlocked is set when connection-level flow control blocked sending, until a MAX_DATA frame is received
+	connFlowControlBlocked bool
+
 	ctx       context.Context
-	ctxCancel context.CancelFunc
+	ctxCancel context.CancelCauseFunc
 
 	undecryptablePackets []*receivedPacket
 
 	clientHelloWritten    <-chan struct{}
 	handshakeCompleteChan chan struct{} // is closed when the handshake completes
 	handshakeComplete     bool
+	// handshakeDone is closed by the run loop when the handshake completes, see HandshakeComplete
+	handshakeDone chan struct{}
+	// earlySessionReadyChan is closed by the server's run loop when the ClientHello was processed
+	earlySessionReadyChan chan struct{}
 
 	receivedFirstPacket              bool
 	receivedFirstForwardSecurePacket bool
@@ -125,13 +211,29 @@ type session struct {
 	// pacingDeadline is the time when the next packet should be sent
 	pacingDeadline time.Time
 
-	peerParams *handshake.TransportParameters
+	// Packets sent from sendPackets are collected and written with a single call to WriteBatch.
+	batchPackets bool
+	packetBatch  []*packedPacket
 
-	timer *utils.Timer
+	peerParams *handshake.TransportParameters
+	// localParams are the transport parameters we sent, used for PeerParams
+	localParams *handshake.TransportParameters
+	// negotiatedParams is the snapshot returned by PeerParams, nil until the peer's transport parameters were received
+	negotiatedParamsMutex sync.Mutex
+	negotiatedParams      *NegotiatedParams
+
+	// clock is used for all timer-related calculations of the run loop.
+	// It can be replaced in tests.
+	clock congestion.Clock
+	timer sessionTimer
+	// timerDeadline is the deadline that the timer is currently set to.
+	// It is the zero value if the timer needs to be reset.
+	timerDeadline time.Time
 	// keepAlivePingSent stores whether a Ping frame was sent to the peer or not
 	// it is reset as soon as we receive a packet from the peer
 	keepAlivePingSent bool
 
+	tracer logging.ConnectionTracer
 	logger utils.Logger
 }
 
@@ -147,6 +249,7 @@ var newSession = func(
 	conf *Config,
 	tlsConf *tls.Config,
 	params *handshake.TransportParameters,
+	clientAddressValidated bool,
 	logger utils.Logger,
 	v protocol.VersionNumber,
 ) (quicSession, error) {
@@ -158,21 +261,33 @@ var newSession = func(
 		destConnID:            destConnID,
 		perspective:           protocol.PerspectiveServer,
 		handshakeCompleteChan: make(chan struct{}),
-		logger:                logger,
+		localParams:           params,
+		logger:                logger.WithPrefix(clientDestConnID.String()),
 		version:               v,
 	}
+	s.tracer = newConnectionTracer(conf, s.perspective, clientDestConnID)
 	s.preSetup()
-	s.sentPacketHandler = ackhandler.NewSentPacketHandler(0, s.rttStats, s.logger)
-	initialStream := newCryptoStream()
-	handshakeStream := newCryptoStream()
 	s.streamsMap = newStreamsMap(
 		s,
 		s.newFlowController,
+		s.newOutOfOrderDataLimiter(),
+		protocol.ByteCount(s.config.MaxStreamSendBuffer),
+		s.clock,
 		uint64(s.config.MaxIncomingStreams),
 		uint64(s.config.MaxIncomingUniStreams),
 		s.perspective,
 		s.version,
 	)
+	cong, err := newCongestionControl(s.config, s.rttStats)
+	if err != nil {
+		return nil, err
+	}
+	s.sentPacketHandler = ackhandler.NewSentPacketHandler(0, clientAddressValidated, s.perspective, s.clock, s.rttStats, cong, s.streamsMap, s.tracer, s.logger)
+	if conn.ECNEnabled() {
+		s.sentPacketHandler.EnableECN(conn.DisableECN)
+	}
+	initialStream := newCryptoStream()
+	handshakeStream := newCryptoStream()
 	s.framer = newFramer(s.streamsMap, s.version)
 	cs, err := handshake.NewCryptoSetupServer(
 		initialStream,
@@ -183,7 +298,7 @@ var newSession = func(
 		tlsConf,
 		conf.Versions,
 		v,
-		logger,
+		s.logger,
 		protocol.PerspectiveServer,
 	)
 	if err != nil {
@@ -201,6 +316,8 @@ var newSession = func(
 		cs,
 		s.framer,
 		s.receivedPacketHandler,
+		s.datagramQueue,
+		s.spinBit,
 		s.perspective,
 		s.version,
 	)
@@ -237,11 +354,36 @@ var newClientSession = func(
 		destConnID:            destConnID,
 		perspective:           protocol.PerspectiveClient,
 		handshakeCompleteChan: make(chan struct{}),
-		logger:                logger,
+		localParams:           params,
 		version:               v,
 	}
+	// origDestConnID is only set if the server sent a Retry
+	connID := origDestConnID
+	if connID == nil {
+		connID = destConnID
+	}
+	s.logger = logger.WithPrefix(connID.String())
+	s.tracer = newConnectionTracer(conf, s.perspective, connID)
 	s.preSetup()
-	s.sentPacketHandler = ackhandler.NewSentPacketHandler(initialPacketNumber, s.rttStats, s.logger)
+	s.streamsMap = newStreamsMap(
+		s,
+		s.newFlowController,
+		s.newOutOfOrderDataLimiter(),
+		protocol.ByteCount(s.config.MaxStreamSendBuffer),
+		s.clock,
+		uint64(s.config.MaxIncomingStreams),
+		uint64(s.config.MaxIncomingUniStreams),
+		s.perspective,
+		s.version,
+	)
+	cong, err := newCongestionControl(s.config, s.rttStats)
+	if err != nil {
+		return nil, err
+	}
+	s.sentPacketHandler = ackhandler.NewSentPacketHandler(initialPacketNumber, true, s.perspective, s.clock, s.rttStats, cong, s.streamsMap, s.tracer, s.logger)
+	if conn.ECNEnabled() {
+		s.sentPacketHandler.EnableECN(conn.DisableECN)
+	}
 	initialStream := newCryptoStream()
 	handshakeStream := newCryptoStream()
 	cs, clientHelloWritten, err := handshake.NewCryptoSetupClient(
@@ -253,9 +395,11 @@ var newClientSession = func(
 		s.processTransportParameters,
 		tlsConf,
 		initialVersion,
-		conf.Versions,
+		func(theirs []protocol.VersionNumber) (protocol.VersionNumber, bool) {
+			return chooseVersion(conf, theirs)
+		},
 		v,
-		logger,
+		s.logger,
 		protocol.PerspectiveClient,
 	)
 	if err != nil {
@@ -265,14 +409,6 @@ var newClientSession = func(
 	s.cryptoStreamHandler = cs
 	s.cryptoStreamManager = newCryptoStreamManager(cs, initialStream, handshakeStream)
 	s.unpacker = newPacketUnpacker(cs, s.version)
-	s.streamsMap = newStreamsMap(
-		s,
-		s.newFlowController,
-		uint64(s.config.MaxIncomingStreams),
-		uint64(s.config.MaxIncomingUniStreams),
-		s.perspective,
-		s.version,
-	)
 	s.framer = newFramer(s.streamsMap, s.version)
 	s.packer = newPacketPacker(
 		s.destConnID,
@@ -285,43 +421,89 @@ var newClientSession = func(
 		cs,
 		s.framer,
 		s.receivedPacketHandler,
+		s.datagramQueue,
+		s.spinBit,
 		s.perspective,
 		s.version,
 	)
 	return s, s.postSetup()
 }
 
+// newConnectionTracer creates the tracer for a new session.
+// It returns nil if the session is not traced.
+func newConnectionTracer(conf *Config, p protocol.Perspective, origDestConnID protocol.ConnectionID) logging.ConnectionTracer {
+	var tracers []logging.ConnectionTracer
+	if conf.Tracer != nil {
+		if t := conf.Tracer.TracerForConnection(p, origDestConnID); t != nil {
+			tracers = append(tracers, t)
+		}
+	}
+	if conf.GetLogWriter != nil {
+		if w := conf.GetLogWriter(p, origDestConnID); w != nil {
+			tracers = append(tracers, qlog.NewConnectionTracer(w, p, origDestConnID))
+		}
+	}
+	return logging.NewMultiplexedConnectionTracer(tracers...)
+}
+
 func (s *session) preSetup() {
+	s.clock = getClock(s.config)
+	s.sendState = newSendStateTracker(s.clock.Now())
 	s.rttStats = &congestion.RTTStats{}
-	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.rttStats, s.logger, s.version)
+	s.frameParser = wire.NewFrameParser(s.version)
+	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.clock, s.rttStats, s.config.MaxAckDelay, s.config.AckFrequency, s.logger, s.version)
 	s.connFlowController = flowcontrol.NewConnectionFlowController(
-		protocol.InitialMaxData,
+		initialMaxData(s.config),
 		protocol.ByteCount(s.config.MaxReceiveConnectionFlowControlWindow),
 		s.onHasConnectionWindowUpdate,
+		s.clock,
 		s.rttStats,
 		s.logger,
 	)
+	s.datagramQueue = newDatagramQueue(s.scheduleSending, s.logger)
+	s.spinBit = newSpinBit(s.perspective, s.config.DisableSpinBit)
+	s.connIDManager = newConnIDManager(
+		s.destConnID,
+		func(token [16]byte) { s.sessionRunner.addResetToken(token) },
+		s.queueControlFrame,
+	)
+	s.connIDGenerator = newConnIDGenerator(
+		s.srcConnID,
+		func() (protocol.ConnectionID, error) { return generateConnectionIDForConfig(s.config) },
+		func(connID protocol.ConnectionID) bool { return s.sessionRunner.addConnectionID(connID, s) },
+		s.sessionRunner.getStatelessResetToken,
+		s.sessionRunner.retireConnectionID,
+		s.sessionRunner.removeConnectionID,
+		s.queueControlFrame,
+	)
 }
 
 func (s *session) postSetup() error {
 	s.receivedPackets = make(chan *receivedPacket, protocol.MaxSessionUnprocessedPackets)
 	s.closeChan = make(chan closeError, 1)
+	s.gracefulCloseChan = make(chan time.Duration, 1)
+	s.pingRequests = make(chan *pingRequest)
+	s.migrationRequests = make(chan *migrationRequest)
 	s.sendingScheduled = make(chan struct{}, 1)
+	s.handshakeDone = make(chan struct{})
+	s.earlySessionReadyChan = make(chan struct{})
 	s.undecryptablePackets = make([]*receivedPacket, 0, protocol.MaxUndecryptablePackets)
-	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	s.ctx, s.ctxCancel = context.WithCancelCause(context.Background())
 
-	s.timer = utils.NewTimer()
-	now := time.Now()
+	s.timer = newSessionTimer(getClock(s.config))
+	now := s.clock.Now()
 	s.lastNetworkActivityTime = now
 	s.sessionCreationTime = now
 
-	s.windowUpdateQueue = newWindowUpdateQueue(s.streamsMap, s.connFlowController, s.framer.QueueControlFrame)
+	s.windowUpdateQueue = newWindowUpdateQueue(s.streamsMap, s.connFlowController, s.queueWindowUpdate)
 	return nil
 }
 
 // run the session main loop
 func (s *session) run() error {
-	defer s.ctxCancel()
+	var closeErr closeError
+	var connErr *ConnectionError
+	defer func() { s.ctxCancel(connErr) }()
 
 	go func() {
 		if err := s.cryptoStreamHandler.RunHandshake(); err != nil {
@@ -340,8 +522,6 @@ func (s *session) run() error {
 		}
 	}
 
-	var closeErr closeError
-
 runLoop:
 	for {
 		// Close immediately if requested
@@ -354,12 +534,14 @@ runLoop:
 		}
 
 		s.maybeResetTimer()
+		s.updateStats()
 
 		select {
 		case closeErr = <-s.closeChan:
 			break runLoop
 		case <-s.timer.Chan():
 			s.timer.SetRead()
+			s.timerDeadline = time.Time{}
 			// We do all the interesting stuff after the switch statement, so
 			// nothing to see here.
 		case <-s.sendingScheduled:
@@ -374,9 +556,35 @@ runLoop:
 			}
 		case <-s.handshakeCompleteChan:
 			s.handleHandshakeComplete()
+		case timeout := <-s.gracefulCloseChan:
+			s.drainDeadline = s.clock.Now().Add(timeout)
+		case req := <-s.pingRequests:
+			s.queuePing(req)
+		case req := <-s.migrationRequests:
+			s.startMigration(req)
 		}
 
-		now := time.Now()
+		now := s.clock.Now()
+		if s.pendingMigration != nil {
+			if !now.Before(s.pendingMigration.deadline) {
+				s.abortMigration(errors.New("path validation timed out"))
+			} else if !now.Before(s.pendingMigration.nextChallenge) {
+				if err := s.sendPathChallenge(); err != nil {
+					s.abortMigration(err)
+				}
+			}
+		}
+		if s.pathValidation != nil {
+			if !now.Before(s.pathValidation.deadline) {
+				s.logger.Debugf("Validating remote address %s timed out.", s.pathValidation.remoteAddr)
+				s.pathValidation = nil
+			} else if !s.pathValidation.nextChallenge.IsZero() && !now.Before(s.pathValidation.nextChallenge) {
+				if err := s.sendPathValidationChallenge(); err != nil {
+					s.closeLocal(err)
+					continue
+				}
+			}
+		}
 		if timeout := s.sentPacketHandler.GetAlarmTimeout(); !timeout.IsZero() && timeout.Before(now) {
 			// This could cause packets to be retransmitted.
 			// Check it before trying to send packets.
@@ -389,7 +597,7 @@ runLoop:
 		if s.pacingDeadline.IsZero() { // the timer didn't have a pacing deadline set
 			pacingDeadline = s.sentPacketHandler.TimeUntilSend()
 		}
-		if s.config.KeepAlive && !s.keepAlivePingSent && s.handshakeComplete && time.Since(s.lastNetworkActivityTime) >= s.peerParams.IdleTimeout/2 {
+		if s.config.KeepAlive && !s.keepAlivePingSent && s.handshakeComplete && s.drainDeadline.IsZero() && now.Sub(s.lastNetworkActivityTime) >= s.keepAlivePeriod() {
 			// send a PING frame since there is no activity in the session
 			s.logger.Debugf("Sending a keep-alive ping to keep the connection alive.")
 			s.framer.QueueControlFrame(&wire.PingFrame{})
@@ -399,6 +607,12 @@ runLoop:
 			// This can happen when scheduleSending is called, or a packet is received.
 			// Set the timer and restart the run loop.
 			s.pacingDeadline = pacingDeadline
+			// ACK-only packets are not paced.
+			if err := s.maybeSendAckOnlyPacket(); err != nil {
+				s.closeLocal(err)
+			}
+			s.congestionLimited = true
+			s.updateSendState(now)
 			continue
 		}
 
@@ -406,39 +620,129 @@ runLoop:
 			s.closeLocal(qerr.Error(qerr.HandshakeTimeout, "Crypto handshake did not complete in time."))
 			continue
 		}
-		if s.handshakeComplete && now.Sub(s.lastNetworkActivityTime) >= s.config.IdleTimeout {
+		// While draining, the session is kept alive until all data was delivered or the drain deadline is reached.
+		if s.handshakeComplete && s.drainDeadline.IsZero() && now.Sub(s.lastNetworkActivityTime) >= s.idleTimeout() {
 			s.closeLocal(qerr.Error(qerr.NetworkIdleTimeout, "No recent network activity."))
 			continue
 		}
 
 		if err := s.sendPackets(); err != nil {
 			s.closeLocal(err)
+			continue
+		}
+		s.updateSendState(now)
+		if !s.drainDeadline.IsZero() {
+			s.maybeFinishDraining(now)
 		}
 	}
 
-	if err := s.handleCloseError(closeErr); err != nil {
+	// Ping returns as soon as the session is closed.
+	s.pendingPings = nil
+
+	connErr = closeErr.connectionError()
+	if err := s.handleCloseError(closeErr, connErr); err != nil {
 		s.logger.Infof("Handling close error failed: %s", err)
 	}
+	if s.tracer != nil {
+		s.tracer.ClosedConnection(closeErr.err)
+	}
 	s.closed.Set(true)
 	s.logger.Infof("Connection %s closed.", s.srcConnID)
 	s.cryptoStreamHandler.Close()
-	return closeErr.err
+	if closeErr.err == nil || closeErr.err == errCloseForRecreating {
+		return closeErr.err
+	}
+	return connErr
 }
 
 func (s *session) Context() context.Context {
 	return s.ctx
 }
 
+func (s *session) HandshakeComplete() <-chan struct{} {
+	return s.handshakeDone
+}
+
+func (s *session) earlySessionReady() <-chan struct{} {
+	return s.earlySessionReadyChan
+}
+
+func (s *session) Stats() SessionStats {
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+	stats := s.stats
+	var durations [3]time.Duration
+	stats.SendState, durations = s.sendState.Get(s.clock.Now())
+	stats.TimeApplicationLimited = durations[SendStateApplicationLimited]
+	stats.TimeFlowControlBlocked = durations[SendStateFlowControlBlocked]
+	stats.TimeCongestionLimited = durations[SendStateCongestionLimited]
+	return stats
+}
+
+// updateSendState updates the send state after trying to send packets.
+// It must only be called from the run loop.
+func (s *session) updateSendState(now time.Time) {
+	var state SendState
+	switch {
+	case s.congestionLimited && s.framer.HasData():
+		state = SendStateCongestionLimited
+	case s.connFlowControlBlocked:
+		state = SendStateFlowControlBlocked
+	default:
+		state = SendStateApplicationLimited
+	}
+	if state == s.sendState.State() {
+		return
+	}
+	s.statsMutex.Lock()
+	s.sendState.Set(state, now)
+	s.statsMutex.Unlock()
+}
+
+// updateStats updates the snapshot of the statistics returned by Stats.
+// It must only be called from the run loop.
+func (s *session) updateStats() {
+	sentStats := s.sentPacketHandler.GetStats()
+	s.statsMutex.Lock()
+	s.stats = SessionStats{
+		SmoothedRTT:           s.rttStats.SmoothedRTT(),
+		RTTVariance:           s.rttStats.MeanDeviation(),
+		MinRTT:                s.rttStats.MinRTT(),
+		LatestRTT:             s.rttStats.LatestRTT(),
+		PacketsSent:           sentStats.PacketsSent,
+		PacketsLost:           sentStats.PacketsLost,
+		SpuriousLosses:        sentStats.SpuriousLosses,
+		PTOs:                  sentStats.PTOs,
+		PersistentCongestions: sentStats.PersistentCongestions,
+		BytesRetransmitted:    uint64(sentStats.BytesRetransmitted),
+		CongestionWindow:      uint64(sentStats.CongestionWindow),
+		BytesInFlight:         uint64(sentStats.BytesInFlight),
+		PacingRate:            uint64(sentStats.PacingRate),
+	}
+	s.statsMutex.Unlock()
+}
+
 func (s *session) ConnectionState() ConnectionState {
-	return s.cryptoStreamHandler.ConnectionState()
+	state := s.cryptoStreamHandler.ConnectionState()
+	state.Version = s.version
+	return state
+}
+
+func (s *session) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	return s.cryptoStreamHandler.ExportKeyingMaterial(label, context, length)
 }
 
 func (s *session) maybeResetTimer() {
 	var deadline time.Time
-	if s.config.KeepAlive && s.handshakeComplete && !s.keepAlivePingSent {
-		deadline = s.lastNetworkActivityTime.Add(s.peerParams.IdleTimeout / 2)
+	if !s.drainDeadline.IsZero() {
+		deadline = s.drainDeadline
+	} else if !s.handshakeComplete {
+		// the idle timeout only applies after the handshake completed
+		deadline = s.sessionCreationTime.Add(s.config.HandshakeTimeout)
+	} else if s.config.KeepAlive && !s.keepAlivePingSent {
+		deadline = s.lastNetworkActivityTime.Add(s.keepAlivePeriod())
 	} else {
-		deadline = s.lastNetworkActivityTime.Add(s.config.IdleTimeout)
+		deadline = s.lastNetworkActivityTime.Add(s.idleTimeout())
 	}
 
 	if ackAlarm := s.receivedPacketHandler.GetAlarmTimeout(); !ackAlarm.IsZero() {
@@ -454,13 +758,52 @@ func (s *session) maybeResetTimer() {
 	if !s.pacingDeadline.IsZero() {
 		deadline = utils.MinTime(deadline, s.pacingDeadline)
 	}
+	if s.pendingMigration != nil {
+		deadline = utils.MinTime(deadline, s.pendingMigration.deadline)
+		deadline = utils.MinTime(deadline, s.pendingMigration.nextChallenge)
+	}
+	if s.pathValidation != nil {
+		deadline = utils.MinTime(deadline, s.pathValidation.deadline)
+		if !s.pathValidation.nextChallenge.IsZero() {
+			deadline = utils.MinTime(deadline, s.pathValidation.nextChallenge)
+		}
+	}
 
+	// Most deadlines only ever move to a later time, e.g. the idle timeout is pushed back by every packet received.
+	// Resetting the timer every time this happens is expensive.
+	// Instead, the timer is only reset when the deadline moves to an earlier time.
+	// Otherwise it fires early, and the timer is reset to the actual deadline in the next iteration of the run loop.
+	if !s.timerDeadline.IsZero() && !deadline.Before(s.timerDeadline) {
+		return
+	}
+	s.timerDeadline = deadline
 	s.timer.Reset(deadline)
 }
 
+// keepAlivePeriod is the time after which a PING frame is sent, if no packet was received from the peer.
+// Unless configured otherwise, it is half the negotiated idle timeout.
+func (s *session) keepAlivePeriod() time.Duration {
+	if s.config.KeepAlivePeriod != 0 {
+		return s.config.KeepAlivePeriod
+	}
+	return s.idleTimeout() / 2
+}
+
+// idleTimeout is the negotiated idle timeout, i.e. the minimum of our and the peer's idle timeout.
+// The peer closes the connection after its idle timeout, so the connection is dead after the shorter of the two.
+func (s *session) idleTimeout() time.Duration {
+	if s.peerParams != nil && s.peerParams.IdleTimeout != 0 {
+		return utils.MinDuration(s.config.IdleTimeout, s.peerParams.IdleTimeout)
+	}
+	return s.config.IdleTimeout
+}
+
 func (s *session) handleHandshakeComplete() {
 	s.handshakeComplete = true
 	s.handshakeCompleteChan = nil // prevent this case from ever being selected again
+	// The idle timeout starts counting when the handshake completes.
+	s.lastNetworkActivityTime = s.clock.Now()
+	close(s.handshakeDone)
 	s.sessionRunner.onHandshakeComplete(s)
 
 	// The client completes the handshake first (after sending the CFIN).
@@ -472,15 +815,37 @@ func (s *session) handleHandshakeComplete() {
 	if s.perspective == protocol.PerspectiveServer {
 		s.queueControlFrame(&wire.PingFrame{})
 		s.sentPacketHandler.SetHandshakeComplete()
+		if err := s.connIDGenerator.SetNumConnIDs(s.config.ActiveConnectionIDs); err != nil {
+			s.closeLocal(err)
+		}
+		// Send a token that the client can use to skip the address validation on the next connection.
+		token, err := s.sessionRunner.newToken(s.conn.RemoteAddr())
+		if err != nil {
+			s.logger.Errorf("Generating a token failed: %s", err)
+			return
+		}
+		s.queueControlFrame(&wire.NewTokenFrame{Token: token})
 	}
 }
 
 func (s *session) handlePacketImpl(p *receivedPacket) bool /* was the packet successfully processed */ {
-	var wasQueued bool
+	// Clients are required to pad UDP datagrams containing Initial packets to at least MinInitialPacketSize bytes.
+	// Datagrams that are too small are discarded, and not counted for the amplification limit.
+	if s.perspective == protocol.PerspectiveServer && p.hdr.Type == protocol.PacketTypeInitial && p.datagramSize < protocol.MinInitialPacketSize {
+		s.logger.Debugf("Dropping Initial packet, since the datagram is too small (%d bytes)", p.datagramSize)
+		if s.tracer != nil {
+			s.tracer.DroppedPacket(protocol.ByteCount(len(p.data)), logging.PacketDropUnexpectedPacket)
+		}
+		p.buffer.Release()
+		return false
+	}
 
+	var wasQueued bool
 	defer func() {
 		// Put back the packet buffer if the packet wasn't queued for later decryption.
+		// Queued packets are counted for the amplification limit when they're handled again.
 		if !wasQueued {
+			s.sentPacketHandler.ReceivedBytes(protocol.ByteCount(len(p.data)))
 			p.buffer.Release()
 		}
 	}()
@@ -489,10 +854,16 @@ func (s *session) handlePacketImpl(p *receivedPacket) bool /* was the packet suc
 	// After this, all packets with a different source connection have to be ignored.
 	if s.receivedFirstPacket && p.hdr.IsLongHeader && !p.hdr.SrcConnectionID.Equal(s.destConnID) {
 		s.logger.Debugf("Dropping packet with unexpected source connection ID: %s (expected %s)", p.hdr.SrcConnectionID, s.destConnID)
+		if s.tracer != nil {
+			s.tracer.DroppedPacket(protocol.ByteCount(len(p.data)), logging.PacketDropUnexpectedSourceConnectionID)
+		}
 		return false
 	}
 	// drop 0-RTT packets
 	if p.hdr.Type == protocol.PacketType0RTT {
+		if s.tracer != nil {
+			s.tracer.DroppedPacket(protocol.ByteCount(len(p.data)), logging.PacketDropUnexpectedPacket)
+		}
 		return false
 	}
 
@@ -508,6 +879,9 @@ func (s *session) handlePacketImpl(p *receivedPacket) bool /* was the packet suc
 		// This might be a packet injected by an attacker.
 		// Drop it.
 		s.logger.Debugf("Dropping packet that could not be unpacked. Unpack error: %s", err)
+		if s.tracer != nil {
+			s.tracer.DroppedPacket(protocol.ByteCount(len(p.data)), logging.PacketDropPayloadDecryptError)
+		}
 		return false
 	}
 
@@ -516,27 +890,54 @@ func (s *session) handlePacketImpl(p *receivedPacket) bool /* was the packet suc
 		packet.hdr.Log(s.logger)
 	}
 
-	if err := s.handleUnpackedPacket(packet, p.rcvTime); err != nil {
+	if err := s.handleUnpackedPacket(packet, p); err != nil {
 		s.closeLocal(err)
 		return false
 	}
+	if s.perspective == protocol.PerspectiveServer && !s.config.DisableMigration && p.remoteAddr != nil && !equalAddr(p.remoteAddr, s.conn.RemoteAddr()) {
+		if err := s.handlePacketFromNewAddr(p); err != nil {
+			s.closeLocal(err)
+			return false
+		}
+	}
 	return true
 }
 
-func (s *session) handleUnpackedPacket(packet *unpackedPacket, rcvTime time.Time) error {
+// handleUnpackedPacket handles the frames of a packet.
+// The packet data lives in p.buffer. STREAM frames reference the data, instead of copying it.
+func (s *session) handleUnpackedPacket(packet *unpackedPacket, p *receivedPacket) error {
 	if len(packet.data) == 0 {
 		return qerr.MissingPayload
 	}
 
+	// Check the out-of-order data limit before handling any frame,
+	// so that a packet is either processed completely, or dropped without any side effects.
+	if packet.encryptionLevel == protocol.Encryption1RTT {
+		if err := s.checkOutOfOrderData(packet.data); err != nil {
+			if err == errTooMuchOutOfOrderData {
+				s.dropPacketBufferFull(packet, p, err)
+				return nil
+			}
+			return err
+		}
+	}
+
 	// The server can change the source connection ID with the first Handshake packet.
 	if s.perspective == protocol.PerspectiveClient && !s.receivedFirstPacket && packet.hdr.IsLongHeader && !packet.hdr.SrcConnectionID.Equal(s.destConnID) {
 		s.logger.Debugf("Received first packet. Switching destination connection ID to: %s", packet.hdr.SrcConnectionID)
 		s.destConnID = packet.hdr.SrcConnectionID
-		s.packer.ChangeDestConnectionID(s.destConnID)
+		s.changeDestConnectionID(s.destConnID)
+		s.connIDManager.ChangeInitialConnID(s.destConnID)
+	}
+
+	// Decrypting a Handshake packet proves that the client received our Initial packets,
+	// so the client's address is validated.
+	if s.perspective == protocol.PerspectiveServer && packet.encryptionLevel != protocol.EncryptionInitial {
+		s.sentPacketHandler.SetPeerAddressValidated()
 	}
 
 	s.receivedFirstPacket = true
-	s.lastNetworkActivityTime = rcvTime
+	s.lastNetworkActivityTime = p.rcvTime
 	s.keepAlivePingSent = false
 
 	// The client completes the handshake first (after sending the CFIN).
@@ -550,40 +951,109 @@ func (s *session) handleUnpackedPacket(packet *unpackedPacket, rcvTime time.Time
 
 	r := bytes.NewReader(packet.data)
 	var isRetransmittable bool
+	var frames []wire.Frame // only used when tracing
 	for {
-		frame, err := wire.ParseNextFrame(r, s.version)
+		var frame wire.Frame
+		var err error
+		if s.tracer != nil {
+			// The frame parser reuses STREAM and ACK frames,
+			// so it can't be used if all frames of the packet are passed to the tracer.
+			frame, err = wire.ParseNextFrameNoCopy(r, packet.data, s.version)
+		} else {
+			frame, err = s.frameParser.ParseNext(r, packet.data)
+		}
 		if err != nil {
 			return err
 		}
 		if frame == nil {
 			break
 		}
+		if s.tracer != nil {
+			frames = append(frames, frame)
+		}
 		if ackhandler.IsFrameRetransmittable(frame) {
 			isRetransmittable = true
 		}
-		if err := s.handleFrame(frame, packet.packetNumber, packet.encryptionLevel); err != nil {
+		if err := s.handleFrame(frame, packet.packetNumber, packet.encryptionLevel, p.buffer); err != nil {
+			if err == errTooMuchOutOfOrderData {
+				// Only happens if the limit changed since the check.
+				s.dropPacketBufferFull(packet, p, err)
+				return nil
+			}
 			return err
 		}
 	}
 
-	if err := s.receivedPacketHandler.ReceivedPacket(packet.packetNumber, packet.encryptionLevel, rcvTime, isRetransmittable); err != nil {
+	if err := s.receivedPacketHandler.ReceivedPacket(packet.packetNumber, p.ecn, packet.encryptionLevel, p.rcvTime, isRetransmittable); err != nil {
 		return err
 	}
+	if packet.encryptionLevel == protocol.Encryption1RTT {
+		s.spinBit.ReceivedPacket(packet.packetNumber, packet.hdr.SpinBit)
+	}
+	if s.tracer != nil {
+		s.tracer.ReceivedPacket(packet.hdr, protocol.ByteCount(len(p.data)), frames)
+	}
 	return nil
 }
 
-func (s *session) handleFrame(f wire.Frame, pn protocol.PacketNumber, encLevel protocol.EncryptionLevel) error {
+// checkOutOfOrderData checks that the STREAM frames of a packet don't exceed the out-of-order data limit.
+// Since the packet contains multiple STREAM frames, their out-of-order data is added up.
+func (s *session) checkOutOfOrderData(data []byte) error {
+	r := bytes.NewReader(data)
+	var pending protocol.ByteCount
+	for {
+		frame, err := s.frameParser.ParseNext(r, data)
+		if err != nil {
+			return err
+		}
+		if frame == nil {
+			return nil
+		}
+		f, ok := frame.(*wire.StreamFrame)
+		if !ok {
+			continue
+		}
+		str, err := s.streamsMap.GetOrOpenReceiveStream(f.StreamID)
+		if err != nil {
+			return err
+		}
+		if str == nil { // stream already closed
+			continue
+		}
+		n, err := str.checkOutOfOrderData(f, pending)
+		if err != nil {
+			return err
+		}
+		pending += n
+	}
+}
+
+// dropPacketBufferFull drops a packet with a STREAM frame that would exceed the out-of-order data limit.
+// The packet is not acknowledged.
+// The peer will retransmit the data, and hopefully fill the gaps first.
+func (s *session) dropPacketBufferFull(packet *unpackedPacket, p *receivedPacket, err error) {
+	s.logger.Debugf("Dropping packet %#x: %s", packet.packetNumber, err)
+	if s.tracer != nil {
+		s.tracer.DroppedPacket(protocol.ByteCount(len(p.data)), logging.PacketDropBufferFull)
+	}
+}
+
+func (s *session) handleFrame(f wire.Frame, pn protocol.PacketNumber, encLevel protocol.EncryptionLevel, buffer *packetBuffer) error {
 	var err error
 	wire.LogFrame(s.logger, f, false)
 	switch frame := f.(type) {
 	case *wire.CryptoFrame:
 		err = s.handleCryptoFrame(frame, encLevel)
 	case *wire.StreamFrame:
-		err = s.handleStreamFrame(frame, encLevel)
+		err = s.handleStreamFrame(frame, encLevel, buffer)
 	case *wire.AckFrame:
 		err = s.handleAckFrame(frame, pn, encLevel)
 	case *wire.ConnectionCloseFrame:
-		s.closeRemote(qerr.Error(frame.ErrorCode, frame.ReasonPhrase))
+		if frame.IsApplicationError {
+			s.closeRemote(qerr.ApplicationError(frame.ErrorCode, frame.ReasonPhrase))
+		} else {
+			s.closeRemote(qerr.Error(frame.ErrorCode, frame.ReasonPhrase))
+		}
 	case *wire.ResetStreamFrame:
 		err = s.handleResetStreamFrame(frame)
 	case *wire.MaxDataFrame:
@@ -601,13 +1071,15 @@ func (s *session) handleFrame(f wire.Frame, pn protocol.PacketNumber, encLevel p
 	case *wire.PathChallengeFrame:
 		s.handlePathChallengeFrame(frame)
 	case *wire.PathResponseFrame:
-		// since we don't send PATH_CHALLENGEs, we don't expect PATH_RESPONSEs
-		err = errors.New("unexpected PATH_RESPONSE frame")
+		err = s.handlePathResponseFrame(frame)
+	case *wire.DatagramFrame:
+		err = s.handleDatagramFrame(frame)
 	case *wire.NewTokenFrame:
+		err = s.handleNewTokenFrame(frame)
 	case *wire.NewConnectionIDFrame:
+		err = s.handleNewConnectionIDFrame(frame)
 	case *wire.RetireConnectionIDFrame:
-		// since we don't send new connection IDs, we don't expect retirements
-		err = errors.New("unexpected RETIRE_CONNECTION_ID frame")
+		err = s.connIDGenerator.Retire(frame.SequenceNumber)
 	default:
 		err = fmt.Errorf("unexpected frame type: %s", reflect.ValueOf(&frame).Elem().Type().Name())
 	}
@@ -616,6 +1088,10 @@ func (s *session) handleFrame(f wire.Frame, pn protocol.PacketNumber, encLevel p
 
 // handlePacket is called by the server with a new packet
 func (s *session) handlePacket(p *receivedPacket) {
+	if s.config.Clock != nil {
+		// The packet conn timestamps packets using the system clock.
+		p.rcvTime = s.clock.Now()
+	}
 	if s.closed.Get() {
 		s.handlePacketAfterClosed(p)
 	}
@@ -640,7 +1116,10 @@ func (s *session) handlePacketAfterClosed(p *receivedPacket) {
 		}
 	}
 	s.logger.Debugf("Received %d packets after sending CONNECTION_CLOSE. Retransmitting.", s.packetsReceivedAfterClose)
-	if err := s.conn.Write(s.connectionClosePacket.raw); err != nil {
+	s.connMutex.RLock()
+	conn := s.conn
+	s.connMutex.RUnlock()
+	if err := conn.Write(s.connectionClosePacket.raw); err != nil {
 		s.logger.Debugf("Error retransmitting CONNECTION_CLOSE: %s", err)
 	}
 }
@@ -651,12 +1130,17 @@ func (s *session) handleCryptoFrame(frame *wire.CryptoFrame, encLevel protocol.E
 		return err
 	}
 	if encLevelChanged {
+		// The server derives the Handshake keys when it processes the ClientHello.
+		// From now on, the session can be accepted by an EarlyListener.
+		if s.perspective == protocol.PerspectiveServer && encLevel == protocol.EncryptionInitial {
+			close(s.earlySessionReadyChan)
+		}
 		s.tryDecryptingQueuedPackets()
 	}
 	return nil
 }
 
-func (s *session) handleStreamFrame(frame *wire.StreamFrame, encLevel protocol.EncryptionLevel) error {
+func (s *session) handleStreamFrame(frame *wire.StreamFrame, encLevel protocol.EncryptionLevel, buffer *packetBuffer) error {
 	if encLevel < protocol.Encryption1RTT {
 		return qerr.Error(qerr.UnencryptedStreamData, fmt.Sprintf("received unencrypted stream data on stream %d", frame.StreamID))
 	}
@@ -669,11 +1153,12 @@ func (s *session) handleStreamFrame(frame *wire.StreamFrame, encLevel protocol.E
 		// ignore this StreamFrame
 		return nil
 	}
-	return str.handleStreamFrame(frame)
+	return str.handleStreamFrame(frame, buffer)
 }
 
 func (s *session) handleMaxDataFrame(frame *wire.MaxDataFrame) {
 	s.connFlowController.UpdateSendWindow(frame.ByteOffset)
+	s.connFlowControlBlocked = false
 }
 
 func (s *session) handleMaxStreamDataFrame(frame *wire.MaxStreamDataFrame) error {
@@ -686,13 +1171,40 @@ func (s *session) handleMaxStreamDataFrame(frame *wire.MaxStreamDataFrame) error
 		return nil
 	}
 	str.handleMaxStreamDataFrame(frame)
+	if s.tracer != nil {
+		s.tracer.UpdatedStreamSendWindow(frame.StreamID, frame.ByteOffset)
+	}
 	return nil
 }
 
+func (s *session) queueWindowUpdate(f wire.Frame) {
+	if s.tracer != nil {
+		if frame, ok := f.(*wire.MaxStreamDataFrame); ok {
+			s.tracer.UpdatedStreamReceiveWindow(frame.StreamID, frame.ByteOffset)
+		}
+	}
+	s.framer.QueueControlFrame(f)
+}
+
 func (s *session) handleMaxStreamsFrame(frame *wire.MaxStreamsFrame) error {
 	return s.streamsMap.HandleMaxStreamsFrame(frame)
 }
 
+func (s *session) handleNewConnectionIDFrame(frame *wire.NewConnectionIDFrame) error {
+	if s.destConnID.Len() == 0 {
+		return qerr.Error(qerr.InvalidFrameData, "received a NEW_CONNECTION_ID frame, but the peer uses zero-length connection IDs")
+	}
+	return s.connIDManager.Add(frame)
+}
+
+func (s *session) handleNewTokenFrame(frame *wire.NewTokenFrame) error {
+	if s.perspective == protocol.PerspectiveServer {
+		return qerr.Error(qerr.InvalidFrameData, "received a NEW_TOKEN frame from the client")
+	}
+	s.sessionRunner.addToken(frame.Token)
+	return nil
+}
+
 func (s *session) handleResetStreamFrame(frame *wire.ResetStreamFrame) error {
 	str, err := s.streamsMap.GetOrOpenReceiveStream(frame.StreamID)
 	if err != nil {
@@ -722,6 +1234,29 @@ func (s *session) handlePathChallengeFrame(frame *wire.PathChallengeFrame) {
 	s.queueControlFrame(&wire.PathResponseFrame{Data: frame.Data})
 }
 
+func (s *session) handlePathResponseFrame(frame *wire.PathResponseFrame) error {
+	if s.pendingMigration != nil && frame.Data == s.pendingMigration.challenge {
+		return s.completeMigration()
+	}
+	if s.pathValidation != nil && s.pathValidation.challengesSent > 0 && frame.Data == s.pathValidation.challenge {
+		return s.completePathValidation()
+	}
+	// PATH_RESPONSEs might arrive after the path validation timed out, or be duplicated.
+	s.logger.Debugf("Ignoring PATH_RESPONSE that doesn't match the current PATH_CHALLENGE.")
+	return nil
+}
+
+func (s *session) handleDatagramFrame(frame *wire.DatagramFrame) error {
+	if !s.config.EnableDatagrams {
+		return qerr.Error(qerr.InvalidFrameData, "received a DATAGRAM frame, but DATAGRAM support is disabled")
+	}
+	if frame.Length(s.version) > protocol.MaxDatagramFrameSize {
+		return qerr.Error(qerr.InvalidFrameData, "DATAGRAM frame too large")
+	}
+	s.datagramQueue.HandleDatagramFrame(frame)
+	return nil
+}
+
 func (s *session) handleAckFrame(frame *wire.AckFrame, pn protocol.PacketNumber, encLevel protocol.EncryptionLevel) error {
 	if err := s.sentPacketHandler.ReceivedAck(frame, pn, encLevel, s.lastNetworkActivityTime); err != nil {
 		return err
@@ -735,7 +1270,7 @@ func (s *session) handleAckFrame(frame *wire.AckFrame, pn protocol.PacketNumber,
 // closeLocal closes the session and send a CONNECTION_CLOSE containing the error
 func (s *session) closeLocal(e error) {
 	s.closeOnce.Do(func() {
-		s.sessionRunner.retireConnectionID(s.srcConnID)
+		s.connIDGenerator.RetireAll()
 		s.closeChan <- closeError{err: e, sendClose: true, remote: false}
 	})
 }
@@ -743,7 +1278,7 @@ func (s *session) closeLocal(e error) {
 // destroy closes the session without sending the error on the wire
 func (s *session) destroy(e error) {
 	s.closeOnce.Do(func() {
-		s.sessionRunner.removeConnectionID(s.srcConnID)
+		s.connIDGenerator.RemoveAll()
 		s.closeChan <- closeError{err: e, sendClose: false, remote: false}
 	})
 }
@@ -758,7 +1293,7 @@ func (s *session) closeForRecreating() protocol.PacketNumber {
 
 func (s *session) closeRemote(e error) {
 	s.closeOnce.Do(func() {
-		s.sessionRunner.removeConnectionID(s.srcConnID)
+		s.connIDGenerator.RemoveAll()
 		s.closeChan <- closeError{err: e, remote: true}
 	})
 }
@@ -771,22 +1306,353 @@ func (s *session) Close() error {
 	return nil
 }
 
-func (s *session) CloseWithError(code protocol.ApplicationErrorCode, e error) error {
-	s.closeLocal(qerr.Error(qerr.ErrorCode(code), e.Error()))
+// CloseWithError closes the connection with an application error.
+// It sends a CONNECTION_CLOSE frame with the error code and the reason phrase.
+func (s *session) CloseWithError(code protocol.ApplicationErrorCode, reason string) error {
+	s.closeLocal(qerr.ApplicationError(qerr.ErrorCode(code), reason))
 	<-s.ctx.Done()
 	return nil
 }
 
-func (s *session) handleCloseError(closeErr closeError) error {
-	if closeErr.err == nil {
-		closeErr.err = qerr.PeerGoingAway
+type pingRequest struct {
+	ctx     context.Context
+	rttChan chan time.Duration
+}
+
+// resolve is called when the packet containing the PING is acknowledged.
+// It might be called multiple times, if a packet that was declared lost is acknowledged late.
+func (r *pingRequest) resolve(rtt time.Duration) {
+	select {
+	case r.rttChan <- rtt:
+	default:
+	}
+}
+
+// Ping sends a PING frame and waits until the packet carrying it is acknowledged.
+// It returns the time that passed between sending the packet and receiving the acknowledgement.
+func (s *session) Ping(ctx context.Context) (time.Duration, error) {
+	req := &pingRequest{
+		ctx:     ctx,
+		rttChan: make(chan time.Duration, 1),
+	}
+	select {
+	case s.pingRequests <- req:
+	case <-s.ctx.Done():
+		return 0, context.Cause(s.ctx)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	select {
+	case rtt := <-req.rttChan:
+		return rtt, nil
+	case <-s.ctx.Done():
+		return 0, context.Cause(s.ctx)
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+type migrationRequest struct {
+	pconn net.PacketConn
+	// conn is the connection using the new packet conn, set by the run loop
+	conn           connection
+	challenge      [8]byte
+	challengesSent int
+	// nextChallenge is the time when the PATH_CHALLENGE is sent again
+	nextChallenge time.Time
+	// deadline is the time when the path validation is aborted
+	deadline time.Time
+	errChan  chan error
+}
+
+// Migrate migrates the session to a new packet conn.
+// It sends a PATH_CHALLENGE from the new packet conn, and waits for the PATH_RESPONSE.
+// Only when the path was validated, it switches to the new packet conn,
+// and retransmits all data that wasn't acknowledged yet.
+func (s *session) Migrate(pconn net.PacketConn) error {
+	if s.perspective == protocol.PerspectiveServer {
+		return errors.New("only clients can migrate")
+	}
+	req := &migrationRequest{
+		pconn:   pconn,
+		errChan: make(chan error, 1),
+	}
+	select {
+	case s.migrationRequests <- req:
+	case <-s.ctx.Done():
+		return context.Cause(s.ctx)
+	}
+	select {
+	case err := <-req.errChan:
+		return err
+	case <-s.ctx.Done():
+		return context.Cause(s.ctx)
+	}
+}
+
+func (s *session) startMigration(req *migrationRequest) {
+	if s.pendingMigration != nil {
+		req.errChan <- errors.New("already migrating to a different packet conn")
+		return
+	}
+	if !s.handshakeComplete {
+		req.errChan <- errors.New("cannot migrate before the handshake is complete")
+		return
+	}
+	if s.config.DisableMigration {
+		req.errChan <- errors.New("connection migration disabled")
+		return
+	}
+	if s.peerParams.DisableMigration {
+		req.errChan <- errors.New("the peer disabled connection migration")
+		return
+	}
+	conn, err := s.sessionRunner.addPacketConn(req.pconn)
+	if err != nil {
+		req.errChan <- err
+		return
+	}
+	req.conn = conn
+	if _, err := rand.Read(req.challenge[:]); err != nil {
+		s.sessionRunner.removePacketConn(conn)
+		req.errChan <- err
+		return
+	}
+	req.deadline = s.clock.Now().Add(protocol.MaxPathValidationTime)
+	s.pendingMigration = req
+	// Use a new connection ID on the new path, so that an observer can't link the paths.
+	// If the server didn't issue any additional connection IDs, we have to keep using the current one.
+	if connID, ok := s.connIDManager.SwitchToNext(); ok {
+		s.logger.Debugf("Switching to connection ID %s for the new path.", connID)
+		s.changeDestConnectionID(connID)
+	}
+	if err := s.sendPathChallenge(); err != nil {
+		s.abortMigration(err)
+	}
+}
+
+// changeDestConnectionID switches to a new destination connection ID.
+// Every change of the connection ID resets the spin bit.
+func (s *session) changeDestConnectionID(connID protocol.ConnectionID) {
+	s.packer.ChangeDestConnectionID(connID)
+	s.spinBit.Reset()
+}
+
+// sendPathChallenge sends a PATH_CHALLENGE on the path we're migrating to.
+// It is sent again after a PTO, until the path validation times out.
+func (s *session) sendPathChallenge() error {
+	m := s.pendingMigration
+	packet, err := s.packer.PackPathChallenge(&wire.PathChallengeFrame{Data: m.challenge})
+	if err != nil {
+		return err
+	}
+	defer packet.buffer.Release()
+	now := s.clock.Now()
+	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket(now))
+	s.logPacket(packet)
+	m.nextChallenge = now.Add(s.pathChallengeTimeout(m.challengesSent))
+	m.challengesSent++
+	return m.conn.Write(packet.raw)
+}
+
+// pathChallengeTimeout is the time after which a PATH_CHALLENGE is sent again.
+// Like the PTO, it is doubled with every PATH_CHALLENGE that was already sent.
+// The RTT of the new path is not known yet, so the RTT of the current path is used.
+func (s *session) pathChallengeTimeout(challengesSent int) time.Duration {
+	pto := s.rttStats.SmoothedOrInitialRTT() + 4*s.rttStats.MeanDeviation() + s.rttStats.MaxAckDelay()
+	return pto << uint(challengesSent)
+}
+
+// completeMigration switches to the new path, after it was validated.
+func (s *session) completeMigration() error {
+	m := s.pendingMigration
+	s.pendingMigration = nil
+	s.logger.Infof("Migrating connection %s to %s.", s.srcConnID, m.conn.LocalAddr())
+	s.connMutex.Lock()
+	s.conn = m.conn
+	s.connMutex.Unlock()
+	s.sessionRunner.switchPacketConn(m.conn)
+	if err := s.sentPacketHandler.OnConnectionMigration(); err != nil {
+		return err
+	}
+	m.errChan <- nil
+	return nil
+}
+
+func (s *session) abortMigration(err error) {
+	m := s.pendingMigration
+	s.pendingMigration = nil
+	s.logger.Debugf("Migrating to %s failed: %s", m.conn.LocalAddr(), err)
+	s.sessionRunner.removePacketConn(m.conn)
+	m.errChan <- err
+}
+
+type pathValidation struct {
+	remoteAddr     net.Addr
+	challenge      [8]byte
+	challengesSent int
+	// nextChallenge is the time when the PATH_CHALLENGE is sent again.
+	// It is zero if sending was blocked by the amplification limit, until more bytes are received from the address.
+	nextChallenge time.Time
+	// the bytes received from and sent to the address, used to limit the amplification
+	bytesReceived protocol.ByteCount
+	bytesSent     protocol.ByteCount
+	// deadline is the time when the path validation is aborted
+	deadline time.Time
+}
+
+// handlePacketFromNewAddr is called when the client sent a packet from a new address.
+// This happens when the client migrated, or when a NAT rebinding changed its address.
+// We keep sending to the old address, until the new address has been validated.
+func (s *session) handlePacketFromNewAddr(p *receivedPacket) error {
+	if !s.handshakeComplete {
+		return nil
+	}
+	v := s.pathValidation
+	if v == nil {
+		s.logger.Debugf("Received a packet from a new remote address %s. Validating it.", p.remoteAddr)
+		v = &pathValidation{
+			remoteAddr: p.remoteAddr,
+			deadline:   s.clock.Now().Add(protocol.MaxPathValidationTime),
+		}
+		if _, err := rand.Read(v.challenge[:]); err != nil {
+			return err
+		}
+		s.pathValidation = v
+	} else if !equalAddr(v.remoteAddr, p.remoteAddr) {
+		// Only validate one address at a time.
+		// Since packets can be spoofed, this prevents an attacker from making us send PATH_CHALLENGEs to many addresses.
+		return nil
+	}
+	v.bytesReceived += protocol.ByteCount(len(p.data))
+	if !v.nextChallenge.IsZero() {
+		return nil
+	}
+	return s.sendPathValidationChallenge()
+}
+
+// sendPathValidationChallenge sends a PATH_CHALLENGE to the address that is being validated.
+// It is sent again after a PTO, until the path validation times out.
+// The address might have been spoofed, so all packets sent to it, including these retransmissions,
+// never exceed AmplificationFactor times the bytes received from it.
+func (s *session) sendPathValidationChallenge() error {
+	v := s.pathValidation
+	packet, err := s.packer.PackPathChallenge(&wire.PathChallengeFrame{Data: v.challenge})
+	if err != nil {
+		return err
+	}
+	defer packet.buffer.Release()
+	size := protocol.ByteCount(len(packet.raw))
+	if v.bytesSent+size > protocol.AmplificationFactor*v.bytesReceived {
+		s.logger.Debugf("Not sending PATH_CHALLENGE to %s, since it would exceed the amplification limit.", v.remoteAddr)
+		v.nextChallenge = time.Time{}
+		return nil
+	}
+	now := s.clock.Now()
+	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket(now))
+	s.logPacket(packet)
+	v.bytesSent += size
+	v.nextChallenge = now.Add(s.pathChallengeTimeout(v.challengesSent))
+	v.challengesSent++
+	return s.conn.WriteTo(packet.raw, v.remoteAddr)
+}
+
+// completePathValidation switches to the new address of the client, after it was validated.
+func (s *session) completePathValidation() error {
+	v := s.pathValidation
+	s.pathValidation = nil
+	oldAddr := s.conn.RemoteAddr()
+	s.logger.Infof("Validated new remote address %s. Switching from %s.", v.remoteAddr, oldAddr)
+	s.conn.SetCurrentRemoteAddr(v.remoteAddr)
+	// If only the port changed, this is most likely a NAT rebinding, and the path didn't change.
+	if isNATRebinding(oldAddr, v.remoteAddr) {
+		return nil
+	}
+	return s.sentPacketHandler.OnConnectionMigration()
+}
+
+// equalAddr says if two addresses are equal.
+// It is called for every packet received by the server, so it avoids formatting UDP addresses.
+func equalAddr(a, b net.Addr) bool {
+	aUDPAddr, ok := a.(*net.UDPAddr)
+	if !ok {
+		return a.String() == b.String()
+	}
+	bUDPAddr, ok := b.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	return aUDPAddr.Port == bUDPAddr.Port && aUDPAddr.IP.Equal(bUDPAddr.IP) && aUDPAddr.Zone == bUDPAddr.Zone
+}
+
+func isNATRebinding(oldAddr, newAddr net.Addr) bool {
+	oldUDPAddr, ok := oldAddr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	newUDPAddr, ok := newAddr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	return oldUDPAddr.IP.Equal(newUDPAddr.IP)
+}
+
+func (s *session) SendMessage(p []byte) error {
+	if !s.config.EnableDatagrams {
+		return errors.New("datagram support disabled")
+	}
+	maxFrameSize := s.datagramQueue.MaxFrameSize()
+	if maxFrameSize == 0 {
+		return errors.New("datagram support not negotiated with the peer")
+	}
+	f := &wire.DatagramFrame{DataLenPresent: true}
+	if maxDataLen := f.MaxDataLen(maxFrameSize, s.version); protocol.ByteCount(len(p)) > maxDataLen {
+		return &DatagramTooLargeError{MaxDataLen: int64(maxDataLen)}
+	}
+	f.Data = make([]byte, len(p))
+	copy(f.Data, p)
+	return s.datagramQueue.AddAndWait(f)
+}
+
+func (s *session) ReceiveMessage() ([]byte, error) {
+	if !s.config.EnableDatagrams {
+		return nil, errors.New("datagram support disabled")
 	}
+	return s.datagramQueue.Receive()
+}
 
-	var quicErr *qerr.QuicError
-	var ok bool
-	if quicErr, ok = closeErr.err.(*qerr.QuicError); !ok {
-		quicErr = qerr.ToQuicError(closeErr.err)
+// CloseGracefully stops accepting and opening new streams,
+// and closes the session as soon as all data written to the streams has been delivered.
+// If this doesn't happen within the timeout, the session is closed anyway.
+// It waits until the run loop has stopped before returning.
+func (s *session) CloseGracefully(timeout time.Duration) error {
+	s.gracefulCloseOnce.Do(func() {
+		s.draining.Set(true)
+		s.gracefulCloseChan <- timeout
+	})
+	<-s.ctx.Done()
+	if s.drainTimedOut {
+		return errGracefulCloseTimeout
+	}
+	return nil
+}
+
+// maybeFinishDraining closes the session when all stream data has been sent and acknowledged,
+// or when the drain deadline is reached.
+func (s *session) maybeFinishDraining(now time.Time) {
+	if !s.framer.HasData() && !s.streamsMap.HasUnsentData() && !s.sentPacketHandler.HasOutstandingData() {
+		s.closeLocal(nil)
+		return
+	}
+	if !now.Before(s.drainDeadline) {
+		s.logger.Debugf("Graceful close timed out.")
+		s.drainTimedOut = true
+		s.closeLocal(nil)
 	}
+}
+
+func (s *session) handleCloseError(closeErr closeError, connErr *ConnectionError) error {
+	quicErr := closeErr.quicError()
 	// Don't log 'normal' reasons
 	if quicErr.ErrorCode == qerr.PeerGoingAway || quicErr.ErrorCode == qerr.NetworkIdleTimeout {
 		s.logger.Infof("Closing connection %s.", s.srcConnID)
@@ -794,7 +1660,11 @@ func (s *session) handleCloseError(closeErr closeError) error {
 		s.logger.Errorf("Closing session with error: %s", closeErr.err.Error())
 	}
 
-	s.streamsMap.CloseWithError(quicErr)
+	s.streamsMap.CloseWithError(connErr)
+	s.datagramQueue.CloseWithError(connErr)
+	if s.pendingMigration != nil {
+		s.abortMigration(connErr)
+	}
 
 	if !closeErr.sendClose {
 		return nil
@@ -810,18 +1680,79 @@ func (s *session) handleCloseError(closeErr closeError) error {
 
 func (s *session) processTransportParameters(params *handshake.TransportParameters) {
 	s.peerParams = params
+	s.negotiatedParamsMutex.Lock()
+	s.negotiatedParams = &NegotiatedParams{
+		Local:       exportTransportParameters(s.localParams, true),
+		Peer:        exportTransportParameters(params, false),
+		IdleTimeout: s.idleTimeout(),
+	}
+	s.negotiatedParamsMutex.Unlock()
+	s.rttStats.SetMaxAckDelay(params.MaxAckDelay)
 	s.streamsMap.UpdateLimits(params)
 	s.packer.HandleTransportParameters(params)
 	s.connFlowController.UpdateSendWindow(params.InitialMaxData)
+	if s.config.EnableDatagrams && params.MaxDatagramFrameSize > 0 {
+		s.datagramQueue.SetMaxFrameSize(utils.MinByteCount(params.MaxDatagramFrameSize, protocol.MaxDatagramFrameSize))
+	}
+	if s.perspective == protocol.PerspectiveClient && len(params.StatelessResetToken) == 16 {
+		var token [16]byte
+		copy(token[:], params.StatelessResetToken)
+		s.connIDManager.SetStatelessResetToken(token)
+	}
 	// the crypto stream is the only open stream at this moment
 	// so we don't need to update stream flow control windows
 }
 
+func (s *session) PeerParams() (NegotiatedParams, bool) {
+	s.negotiatedParamsMutex.Lock()
+	defer s.negotiatedParamsMutex.Unlock()
+	if s.negotiatedParams == nil {
+		return NegotiatedParams{}, false
+	}
+	return *s.negotiatedParams, true
+}
+
+// exportTransportParameters converts transport parameters to the exported TransportParameters.
+// For our own transport parameters, the values are rounded the same way as when they are sent.
+func exportTransportParameters(p *handshake.TransportParameters, local bool) TransportParameters {
+	params := TransportParameters{
+		IdleTimeout:                    p.IdleTimeout,
+		MaxPacketSize:                  p.MaxPacketSize,
+		InitialMaxData:                 p.InitialMaxData,
+		InitialMaxStreamDataBidiLocal:  p.InitialMaxStreamDataBidiLocal,
+		InitialMaxStreamDataBidiRemote: p.InitialMaxStreamDataBidiRemote,
+		InitialMaxStreamDataUni:        p.InitialMaxStreamDataUni,
+		MaxBidiStreams:                 p.MaxBidiStreams,
+		MaxUniStreams:                  p.MaxUniStreams,
+		MaxAckDelay:                    p.MaxAckDelay,
+		MaxDatagramFrameSize:           p.MaxDatagramFrameSize,
+		DisableMigration:               p.DisableMigration,
+	}
+	if local {
+		params.IdleTimeout = params.IdleTimeout.Truncate(time.Second)
+		params.MaxAckDelay = params.MaxAckDelay.Truncate(time.Millisecond)
+		params.MaxPacketSize = protocol.MaxReceivePacketSize
+	}
+	return params
+}
+
 func (s *session) sendPackets() error {
+	s.batchPackets = true
+	err := s.sendPacketsImpl()
+	s.batchPackets = false
+	if flushErr := s.flushPacketBatch(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (s *session) sendPacketsImpl() error {
 	s.pacingDeadline = time.Time{}
+	s.congestionLimited = false
 
 	sendMode := s.sentPacketHandler.SendMode()
 	if sendMode == ackhandler.SendNone { // shortcut: return immediately if there's nothing to send
+		s.congestionLimited = true
 		return nil
 	}
 
@@ -831,8 +1762,10 @@ sendLoop:
 	for {
 		switch sendMode {
 		case ackhandler.SendNone:
+			s.congestionLimited = true
 			break sendLoop
 		case ackhandler.SendAck:
+			s.congestionLimited = true
 			// If we already sent packets, and the send mode switches to SendAck,
 			// we've just become congestion limited.
 			// There's no need to try to send an ACK at this moment.
@@ -879,6 +1812,7 @@ sendLoop:
 	// There will probably be more to send when calling sendPacket again.
 	if numPacketsSent == numPackets {
 		s.pacingDeadline = s.sentPacketHandler.TimeUntilSend()
+		s.congestionLimited = true
 	}
 	return nil
 }
@@ -891,7 +1825,7 @@ func (s *session) maybeSendAckOnlyPacket() error {
 	if packet == nil {
 		return nil
 	}
-	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket())
+	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket(s.clock.Now()))
 	return s.sendPackedPacket(packet)
 }
 
@@ -922,7 +1856,7 @@ func (s *session) maybeSendRetransmission() (bool, error) {
 	}
 	ackhandlerPackets := make([]*ackhandler.Packet, len(packets))
 	for i, packet := range packets {
-		ackhandlerPackets[i] = packet.ToAckHandlerPacket()
+		ackhandlerPackets[i] = packet.ToAckHandlerPacket(s.clock.Now())
 	}
 	s.sentPacketHandler.SentPacketsAsRetransmission(ackhandlerPackets, retransmitPacket.PacketNumber)
 	for _, packet := range packets {
@@ -933,11 +1867,35 @@ func (s *session) maybeSendRetransmission() (bool, error) {
 	return true, nil
 }
 
+// sendProbePacket sends a probe packet when the PTO fires.
+// New data is sent if available, otherwise unacknowledged data is retransmitted.
 func (s *session) sendProbePacket() error {
+	if s.handshakeComplete && s.framer.HasData() {
+		packet, err := s.packAndSendPacket()
+		if err != nil {
+			return err
+		}
+		// If the packet didn't contain any retransmittable frames, it doesn't count as a probe packet.
+		if packet != nil && ackhandler.HasRetransmittableFrames(packet.frames) {
+			s.logger.Debugf("Sending new data as a probe packet.")
+			return nil
+		}
+	}
 	p, err := s.sentPacketHandler.DequeueProbePacket()
 	if err != nil {
 		return err
 	}
+	if p == nil {
+		// The packet only contained STREAM frames, which are retransmitted by the streams,
+		// or DATAGRAM frames, which are never retransmitted.
+		// For the client, it might also be that no packets are outstanding, but the handshake isn't complete yet.
+		// Send a PING instead, to elicit an ACK from the peer.
+		// It is packed together with the lost stream data.
+		s.logger.Debugf("Sending a PING as a probe packet.")
+		s.framer.QueueControlFrame(&wire.PingFrame{})
+		_, err := s.sendPacket()
+		return err
+	}
 	s.logger.Debugf("Sending a retransmission for %#x as a probe packet.", p.PacketNumber)
 
 	packets, err := s.packer.PackRetransmission(p)
@@ -946,7 +1904,7 @@ func (s *session) sendProbePacket() error {
 	}
 	ackhandlerPackets := make([]*ackhandler.Packet, len(packets))
 	for i, packet := range packets {
-		ackhandlerPackets[i] = packet.ToAckHandlerPacket()
+		ackhandlerPackets[i] = packet.ToAckHandlerPacket(s.clock.Now())
 	}
 	s.sentPacketHandler.SentPacketsAsRetransmission(ackhandlerPackets, p.PacketNumber)
 	for _, packet := range packets {
@@ -958,32 +1916,112 @@ func (s *session) sendProbePacket() error {
 }
 
 func (s *session) sendPacket() (bool, error) {
+	packet, err := s.packAndSendPacket()
+	return packet != nil, err
+}
+
+// packAndSendPacket packs a packet containing new data, and sends it.
+// It returns nil if there was nothing to send.
+func (s *session) packAndSendPacket() (*packedPacket, error) {
 	if isBlocked, offset := s.connFlowController.IsNewlyBlocked(); isBlocked {
 		s.framer.QueueControlFrame(&wire.DataBlockedFrame{DataLimit: offset})
+		s.connFlowControlBlocked = true
 	}
 	s.windowUpdateQueue.QueueAll()
 
 	packet, err := s.packer.PackPacket()
 	if err != nil || packet == nil {
-		return false, err
+		return nil, err
+	}
+	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket(s.clock.Now()))
+	if len(s.pendingPings) > 0 {
+		if err := s.registerPings(packet); err != nil {
+			return nil, err
+		}
 	}
-	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket())
 	if err := s.sendPackedPacket(packet); err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, nil
+	return packet, nil
+}
+
+// registerPings makes sure that the PINGs requested by the application are resolved
+// as soon as the first packet carrying a PING frame is acknowledged.
+// PING frames don't carry any data, so they can't be told apart:
+// any PING frame sent after the request measures the round-trip time equally well.
+// If the packet is lost, the PING is queued again.
+func (s *session) registerPings(packet *packedPacket) error {
+	var hasPing bool
+	for _, f := range packet.frames {
+		if _, ok := f.(*wire.PingFrame); ok {
+			hasPing = true
+			break
+		}
+	}
+	if !hasPing {
+		return nil
+	}
+	for i, req := range s.pendingPings {
+		req := req
+		if err := s.sentPacketHandler.RegisterAckCallback(packet.header.PacketNumber, req.resolve); err != nil {
+			return err
+		}
+		if err := s.sentPacketHandler.RegisterLossCallback(packet.header.PacketNumber, func() { s.requeuePing(req) }); err != nil {
+			return err
+		}
+		s.pendingPings[i] = nil
+	}
+	s.pendingPings = s.pendingPings[:0]
+	return nil
+}
+
+func (s *session) queuePing(req *pingRequest) {
+	s.pendingPings = append(s.pendingPings, req)
+	s.framer.QueueControlFrame(&wire.PingFrame{})
+}
+
+// requeuePing queues a PING again after the packet carrying it was lost.
+func (s *session) requeuePing(req *pingRequest) {
+	if req.ctx.Err() != nil { // Ping already returned
+		return
+	}
+	s.queuePing(req)
 }
 
 func (s *session) sendPackedPacket(packet *packedPacket) error {
-	defer packet.buffer.Release()
 	s.logPacket(packet)
+	if s.batchPackets {
+		s.packetBatch = append(s.packetBatch, packet)
+		if len(s.packetBatch) >= protocol.MaxPacketBatchSize {
+			return s.flushPacketBatch()
+		}
+		return nil
+	}
+	defer packet.buffer.Release()
 	return s.conn.Write(packet.raw)
 }
 
+func (s *session) flushPacketBatch() error {
+	if len(s.packetBatch) == 0 {
+		return nil
+	}
+	raw := make([][]byte, len(s.packetBatch))
+	for i, packet := range s.packetBatch {
+		raw[i] = packet.raw
+	}
+	err := s.conn.WriteBatch(raw)
+	for _, packet := range s.packetBatch {
+		packet.buffer.Release()
+	}
+	s.packetBatch = s.packetBatch[:0]
+	return err
+}
+
 func (s *session) sendConnectionClose(quicErr *qerr.QuicError) error {
 	packet, err := s.packer.PackConnectionClose(&wire.ConnectionCloseFrame{
-		ErrorCode:    quicErr.ErrorCode,
-		ReasonPhrase: quicErr.ErrorMessage,
+		IsApplicationError: quicErr.IsApplicationError(),
+		ErrorCode:          quicErr.ErrorCode,
+		ReasonPhrase:       quicErr.ErrorMessage,
 	})
 	if err != nil {
 		return err
@@ -994,6 +2032,9 @@ func (s *session) sendConnectionClose(quicErr *qerr.QuicError) error {
 }
 
 func (s *session) logPacket(packet *packedPacket) {
+	if s.tracer != nil {
+		s.tracer.SentPacket(packet.header, protocol.ByteCount(len(packet.raw)), packet.frames)
+	}
 	if !s.logger.Debug() {
 		// We don't need to allocate the slices for calling the format functions
 		return
@@ -1021,29 +2062,56 @@ func (s *session) GetOrOpenStream(id protocol.StreamID) (Stream, error) {
 }
 
 // AcceptStream returns the next stream openend by the peer
-func (s *session) AcceptStream() (Stream, error) {
-	return s.streamsMap.AcceptStream()
+func (s *session) AcceptStream(ctx context.Context) (Stream, error) {
+	if s.draining.Get() {
+		return nil, errSessionDraining
+	}
+	return s.streamsMap.AcceptStream(ctx)
 }
 
-func (s *session) AcceptUniStream() (ReceiveStream, error) {
-	return s.streamsMap.AcceptUniStream()
+func (s *session) AcceptUniStream(ctx context.Context) (ReceiveStream, error) {
+	if s.draining.Get() {
+		return nil, errSessionDraining
+	}
+	return s.streamsMap.AcceptUniStream(ctx)
 }
 
 // OpenStream opens a stream
 func (s *session) OpenStream() (Stream, error) {
+	if s.draining.Get() {
+		return nil, errSessionDraining
+	}
 	return s.streamsMap.OpenStream()
 }
 
-func (s *session) OpenStreamSync() (Stream, error) {
-	return s.streamsMap.OpenStreamSync()
+func (s *session) OpenStreamSync(ctx context.Context) (Stream, error) {
+	if s.draining.Get() {
+		return nil, errSessionDraining
+	}
+	return s.streamsMap.OpenStreamSync(ctx)
 }
 
 func (s *session) OpenUniStream() (SendStream, error) {
+	if s.draining.Get() {
+		return nil, errSessionDraining
+	}
 	return s.streamsMap.OpenUniStream()
 }
 
-func (s *session) OpenUniStreamSync() (SendStream, error) {
-	return s.streamsMap.OpenUniStreamSync()
+func (s *session) OpenUniStreamSync(ctx context.Context) (SendStream, error) {
+	if s.draining.Get() {
+		return nil, errSessionDraining
+	}
+	return s.streamsMap.OpenUniStreamSync(ctx)
+}
+
+// newOutOfOrderDataLimiter creates the limiter for stream data received out of order.
+// At most half of the receive windows may be used by data that can't be read yet.
+func (s *session) newOutOfOrderDataLimiter() *outOfOrderDataLimiter {
+	return newOutOfOrderDataLimiter(
+		protocol.ByteCount(s.config.MaxReceiveStreamFlowControlWindow/2),
+		protocol.ByteCount(s.config.MaxReceiveConnectionFlowControlWindow/2),
+	)
 }
 
 func (s *session) newFlowController(id protocol.StreamID) flowcontrol.StreamFlowController {
@@ -1062,10 +2130,11 @@ func (s *session) newFlowController(id protocol.StreamID) flowcontrol.StreamFlow
 	return flowcontrol.NewStreamFlowController(
 		id,
 		s.connFlowController,
-		protocol.InitialMaxStreamData,
+		initialMaxStreamData(s.config),
 		protocol.ByteCount(s.config.MaxReceiveStreamFlowControlWindow),
 		initialSendWindow,
 		s.onHasStreamWindowUpdate,
+		s.clock,
 		s.rttStats,
 		s.logger,
 	)
@@ -1082,10 +2151,16 @@ func (s *session) scheduleSending() {
 func (s *session) tryQueueingUndecryptablePacket(p *receivedPacket) {
 	if s.handshakeComplete {
 		s.logger.Debugf("Received undecryptable packet from %s after the handshake (%d bytes)", p.remoteAddr.String(), len(p.data))
+		if s.tracer != nil {
+			s.tracer.DroppedPacket(protocol.ByteCount(len(p.data)), logging.PacketDropKeyUnavailable)
+		}
 		return
 	}
 	if len(s.undecryptablePackets)+1 > protocol.MaxUndecryptablePackets {
 		s.logger.Infof("Dropping undecrytable packet (%d bytes). Undecryptable packet queue full.", len(p.data))
+		if s.tracer != nil {
+			s.tracer.DroppedPacket(protocol.ByteCount(len(p.data)), logging.PacketDropDOSPrevention)
+		}
 		return
 	}
 	s.logger.Infof("Queueing packet (%d bytes) for later decryption", len(p.data))
@@ -1119,6 +2194,10 @@ func (s *session) onHasStreamData(id protocol.StreamID) {
 	s.scheduleSending()
 }
 
+func (s *session) onStreamPriorityChanged(id protocol.StreamID) {
+	s.framer.StreamPriorityChanged(id)
+}
+
 func (s *session) onStreamCompleted(id protocol.StreamID) {
 	if err := s.streamsMap.DeleteStream(id); err != nil {
 		s.closeLocal(err)
@@ -1126,10 +2205,14 @@ func (s *session) onStreamCompleted(id protocol.StreamID) {
 }
 
 func (s *session) LocalAddr() net.Addr {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
 	return s.conn.LocalAddr()
 }
 
 func (s *session) RemoteAddr() net.Addr {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
 	return s.conn.RemoteAddr()
 }
 
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"net"
 	"runtime/pprof"
 	"strings"
+	"sync"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -17,22 +19,33 @@ import (
 	"github.com/lucas-clemente/quic-go/internal/handshake"
 	"github.com/lucas-clemente/quic-go/internal/mocks"
 	mockackhandler "github.com/lucas-clemente/quic-go/internal/mocks/ackhandler"
+	mocklogging "github.com/lucas-clemente/quic-go/internal/mocks/logging"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/utils"
 	"github.com/lucas-clemente/quic-go/internal/wire"
+	"github.com/lucas-clemente/quic-go/logging"
 )
 
+type mockConnectionWrite struct {
+	data []byte
+	to   net.Addr
+}
+
 type mockConnection struct {
 	remoteAddr net.Addr
 	localAddr  net.Addr
 	written    chan []byte
+	writtenTo  chan mockConnectionWrite
+	batchSizes []int
+	ecn        bool
 }
 
 func newMockConnection() *mockConnection {
 	return &mockConnection{
 		remoteAddr: &net.UDPAddr{},
 		written:    make(chan []byte, 100),
+		writtenTo:  make(chan mockConnectionWrite, 100),
 	}
 }
 
@@ -46,6 +59,25 @@ func (m *mockConnection) Write(p []byte) error {
 	}
 	return nil
 }
+func (m *mockConnection) WriteTo(p []byte, addr net.Addr) error {
+	b := make([]byte, len(p))
+	copy(b, p)
+	select {
+	case m.writtenTo <- mockConnectionWrite{data: b, to: addr}:
+	default:
+		panic("mockConnection channel full")
+	}
+	return nil
+}
+func (m *mockConnection) WriteBatch(packets [][]byte) error {
+	m.batchSizes = append(m.batchSizes, len(packets))
+	for _, p := range packets {
+		if err := m.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 func (m *mockConnection) Read([]byte) (int, net.Addr, error) { panic("not implemented") }
 
 func (m *mockConnection) SetCurrentRemoteAddr(addr net.Addr) {
@@ -53,8 +85,52 @@ func (m *mockConnection) SetCurrentRemoteAddr(addr net.Addr) {
 }
 func (m *mockConnection) LocalAddr() net.Addr  { return m.localAddr }
 func (m *mockConnection) RemoteAddr() net.Addr { return m.remoteAddr }
+func (m *mockConnection) ECNEnabled() bool     { return m.ecn }
+func (m *mockConnection) DisableECN()          { m.ecn = false }
 func (*mockConnection) Close() error           { panic("not implemented") }
 
+type mockClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *mockClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	c.mutex.Unlock()
+}
+
+func (c *mockClock) NewTimer() ClockTimer { return &mockClockTimer{c: make(chan time.Time, 1)} }
+
+// mockTimer records the deadlines the timer is reset to.
+// It only fires when the test sends a value on its channel.
+type mockTimer struct {
+	c chan time.Time
+
+	mutex  sync.Mutex
+	resets []time.Time
+}
+
+func (t *mockTimer) Chan() <-chan time.Time { return t.c }
+func (t *mockTimer) SetRead()               {}
+func (t *mockTimer) Reset(deadline time.Time) {
+	t.mutex.Lock()
+	t.resets = append(t.resets, deadline)
+	t.mutex.Unlock()
+}
+
+func (t *mockTimer) Resets() []time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]time.Time{}, t.resets...)
+}
+
 func areSessionsRunning() bool {
 	var b bytes.Buffer
 	pprof.Lookup("goroutine").WriteTo(&b, 1)
@@ -89,9 +165,10 @@ var _ = Describe("Session", func() {
 			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
 			protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1},
 			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
-			populateServerConfig(&Config{}),
+			populateServerConfig(&Config{ActiveConnectionIDs: 1}),
 			nil, // tls.Config
-			nil, // handshake.TransportParameters,
+			&handshake.TransportParameters{},
+			true, // client address validated
 			utils.DefaultLogger,
 			protocol.VersionTLS,
 		)
@@ -109,6 +186,43 @@ var _ = Describe("Session", func() {
 		Eventually(areSessionsRunning).Should(BeFalse())
 	})
 
+	It("errors if the congestion controller can't be created", func() {
+		_, err := newSession(
+			mconn,
+			sessionRunner,
+			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1},
+			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			populateServerConfig(&Config{CongestionControl: func(*RTTStats) CongestionControl { return nil }}),
+			nil,  // tls.Config
+			nil,  // handshake.TransportParameters,
+			true, // client address validated
+			utils.DefaultLogger,
+			protocol.VersionTLS,
+		)
+		Expect(err).To(MatchError("quic: CongestionControl returned nil"))
+	})
+
+	It("prefixes log messages with the original destination connection ID", func() {
+		logger := &recordingLogger{}
+		s, err := newSession(
+			mconn,
+			sessionRunner,
+			protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+			protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1},
+			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			populateServerConfig(&Config{}),
+			nil,  // tls.Config
+			nil,  // handshake.TransportParameters,
+			true, // client address validated
+			utils.NewLogger(logger).WithPrefix("server"),
+			protocol.VersionTLS,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		s.(*session).logger.Infof("foobar")
+		Expect(logger.Messages()).To(Equal([]string{"server 0xdeadbeef foobar"}))
+	})
+
 	Context("frame handling", func() {
 		Context("handling STREAM frames", func() {
 			It("passes STREAM frames to the stream", func() {
@@ -117,9 +231,9 @@ var _ = Describe("Session", func() {
 					Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 				}
 				str := NewMockReceiveStreamI(mockCtrl)
-				str.EXPECT().handleStreamFrame(f)
+				str.EXPECT().handleStreamFrame(f, nil)
 				streamManager.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(5)).Return(str, nil)
-				err := sess.handleStreamFrame(f, protocol.Encryption1RTT)
+				err := sess.handleStreamFrame(f, protocol.Encryption1RTT, nil)
 				Expect(err).ToNot(HaveOccurred())
 			})
 
@@ -130,9 +244,9 @@ var _ = Describe("Session", func() {
 					Data:     []byte{0xde, 0xca, 0xfb, 0xad},
 				}
 				str := NewMockReceiveStreamI(mockCtrl)
-				str.EXPECT().handleStreamFrame(f).Return(testErr)
+				str.EXPECT().handleStreamFrame(f, nil).Return(testErr)
 				streamManager.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(5)).Return(str, nil)
-				err := sess.handleStreamFrame(f, protocol.Encryption1RTT)
+				err := sess.handleStreamFrame(f, protocol.Encryption1RTT, nil)
 				Expect(err).To(MatchError(testErr))
 			})
 
@@ -141,7 +255,7 @@ var _ = Describe("Session", func() {
 				err := sess.handleStreamFrame(&wire.StreamFrame{
 					StreamID: 5,
 					Data:     []byte("foobar"),
-				}, protocol.Encryption1RTT)
+				}, protocol.Encryption1RTT, nil)
 				Expect(err).ToNot(HaveOccurred())
 			})
 
@@ -149,7 +263,7 @@ var _ = Describe("Session", func() {
 				err := sess.handleStreamFrame(&wire.StreamFrame{
 					StreamID: 3,
 					Data:     []byte("foobar"),
-				}, protocol.EncryptionHandshake)
+				}, protocol.EncryptionHandshake, nil)
 				Expect(err).To(MatchError(qerr.Error(qerr.UnencryptedStreamData, "received unencrypted stream data on stream 3")))
 			})
 		})
@@ -209,7 +323,7 @@ var _ = Describe("Session", func() {
 				Expect(sess.handleFrame(&wire.ResetStreamFrame{
 					StreamID:  3,
 					ErrorCode: 42,
-				}, 0, protocol.EncryptionUnspecified)).To(Succeed())
+				}, 0, protocol.EncryptionUnspecified, nil)).To(Succeed())
 			})
 		})
 
@@ -233,6 +347,31 @@ var _ = Describe("Session", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 
+			It("traces updates of the flow control window of a stream", func() {
+				tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+				sess.tracer = tracer
+				f := &wire.MaxStreamDataFrame{
+					StreamID:   12345,
+					ByteOffset: 0x1337,
+				}
+				str := NewMockSendStreamI(mockCtrl)
+				streamManager.EXPECT().GetOrOpenSendStream(protocol.StreamID(12345)).Return(str, nil)
+				str.EXPECT().handleMaxStreamDataFrame(f)
+				tracer.EXPECT().UpdatedStreamSendWindow(protocol.StreamID(12345), protocol.ByteCount(0x1337))
+				Expect(sess.handleMaxStreamDataFrame(f)).To(Succeed())
+			})
+
+			It("traces window updates it sends for a stream", func() {
+				tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+				sess.tracer = tracer
+				tracer.EXPECT().UpdatedStreamReceiveWindow(protocol.StreamID(4), protocol.ByteCount(0x42))
+				f := &wire.MaxStreamDataFrame{StreamID: 4, ByteOffset: 0x42}
+				sess.queueWindowUpdate(f)
+				frames, _ := sess.framer.AppendControlFrames(nil, 1000)
+				Expect(frames).To(HaveLen(1))
+				Expect(frames[0]).To(Equal(f))
+			})
+
 			It("updates the flow control window of the connection", func() {
 				offset := protocol.ByteCount(0x800000)
 				connFC.EXPECT().UpdateSendWindow(offset)
@@ -244,7 +383,7 @@ var _ = Describe("Session", func() {
 				Expect(sess.handleFrame(&wire.MaxStreamDataFrame{
 					StreamID:   10,
 					ByteOffset: 1337,
-				}, 0, protocol.EncryptionUnspecified)).To(Succeed())
+				}, 0, protocol.EncryptionUnspecified, nil)).To(Succeed())
 			})
 		})
 
@@ -286,45 +425,104 @@ var _ = Describe("Session", func() {
 				Expect(sess.handleFrame(&wire.StopSendingFrame{
 					StreamID:  3,
 					ErrorCode: 1337,
-				}, 0, protocol.EncryptionUnspecified)).To(Succeed())
+				}, 0, protocol.EncryptionUnspecified, nil)).To(Succeed())
 			})
 		})
 
 		It("handles PING frames", func() {
-			err := sess.handleFrame(&wire.PingFrame{}, 0, protocol.EncryptionUnspecified)
+			err := sess.handleFrame(&wire.PingFrame{}, 0, protocol.EncryptionUnspecified, nil)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
-		It("rejects PATH_RESPONSE frames", func() {
-			err := sess.handleFrame(&wire.PathResponseFrame{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, 0, protocol.EncryptionUnspecified)
-			Expect(err).To(MatchError("unexpected PATH_RESPONSE frame"))
+		It("ignores PATH_RESPONSE frames that don't belong to a path validation", func() {
+			err := sess.handleFrame(&wire.PathResponseFrame{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, 0, protocol.EncryptionUnspecified, nil)
+			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("handles PATH_CHALLENGE frames", func() {
 			data := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
-			err := sess.handleFrame(&wire.PathChallengeFrame{Data: data}, 0, protocol.EncryptionUnspecified)
+			err := sess.handleFrame(&wire.PathChallengeFrame{Data: data}, 0, protocol.EncryptionUnspecified, nil)
 			Expect(err).ToNot(HaveOccurred())
 			frames, _ := sess.framer.AppendControlFrames(nil, 1000)
 			Expect(frames).To(Equal([]wire.Frame{&wire.PathResponseFrame{Data: data}}))
 		})
 
+		It("handles NEW_CONNECTION_ID frames", func() {
+			err := sess.handleFrame(&wire.NewConnectionIDFrame{
+				SequenceNumber: 1,
+				ConnectionID:   protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+			}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sess.connIDManager.queue).To(HaveLen(1))
+		})
+
+		It("errors when receiving a NEW_CONNECTION_ID frame from a peer that uses zero-length connection IDs", func() {
+			sess.destConnID = nil
+			err := sess.handleFrame(&wire.NewConnectionIDFrame{
+				SequenceNumber: 1,
+				ConnectionID:   protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+			}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).To(MatchError("InvalidFrameData: received a NEW_CONNECTION_ID frame, but the peer uses zero-length connection IDs"))
+		})
+
+		It("errors when receiving a NEW_TOKEN frame from the client", func() {
+			err := sess.handleFrame(&wire.NewTokenFrame{Token: []byte("foobar")}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).To(MatchError("InvalidFrameData: received a NEW_TOKEN frame from the client"))
+		})
+
+		It("handles RETIRE_CONNECTION_ID frames", func() {
+			sessionRunner.EXPECT().getStatelessResetToken(gomock.Any())
+			sessionRunner.EXPECT().addConnectionID(gomock.Any(), sess).Return(true)
+			sessionRunner.EXPECT().retireConnectionID(sess.srcConnID)
+			err := sess.handleFrame(&wire.RetireConnectionIDFrame{SequenceNumber: 0}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).ToNot(HaveOccurred())
+			frames, _ := sess.framer.AppendControlFrames(nil, 1000)
+			Expect(frames).To(HaveLen(1))
+			Expect(frames[0]).To(BeAssignableToTypeOf(&wire.NewConnectionIDFrame{}))
+		})
+
+		It("errors when the peer retires a connection ID that wasn't issued yet", func() {
+			err := sess.handleFrame(&wire.RetireConnectionIDFrame{SequenceNumber: 1}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).To(MatchError("InvalidFrameData: tried to retire connection ID 1, but highest issued is 0"))
+		})
+
+		It("handles DATAGRAM frames", func() {
+			sess.config.EnableDatagrams = true
+			err := sess.handleFrame(&wire.DatagramFrame{Data: []byte("foobar")}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).ToNot(HaveOccurred())
+			data, err := sess.ReceiveMessage()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
+		It("rejects DATAGRAM frames if DATAGRAM support is disabled", func() {
+			err := sess.handleFrame(&wire.DatagramFrame{Data: []byte("foobar")}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidFrameData, "received a DATAGRAM frame, but DATAGRAM support is disabled")))
+		})
+
+		It("rejects DATAGRAM frames that are larger than the maximum size", func() {
+			sess.config.EnableDatagrams = true
+			err := sess.handleFrame(&wire.DatagramFrame{Data: make([]byte, protocol.MaxDatagramFrameSize)}, 0, protocol.Encryption1RTT, nil)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidFrameData, "DATAGRAM frame too large")))
+		})
+
 		It("handles BLOCKED frames", func() {
-			err := sess.handleFrame(&wire.DataBlockedFrame{}, 0, protocol.EncryptionUnspecified)
+			err := sess.handleFrame(&wire.DataBlockedFrame{}, 0, protocol.EncryptionUnspecified, nil)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
 		It("handles STREAM_BLOCKED frames", func() {
-			err := sess.handleFrame(&wire.StreamDataBlockedFrame{}, 0, protocol.EncryptionUnspecified)
+			err := sess.handleFrame(&wire.StreamDataBlockedFrame{}, 0, protocol.EncryptionUnspecified, nil)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
 		It("handles STREAM_ID_BLOCKED frames", func() {
-			err := sess.handleFrame(&wire.StreamsBlockedFrame{}, 0, protocol.EncryptionUnspecified)
+			err := sess.handleFrame(&wire.StreamsBlockedFrame{}, 0, protocol.EncryptionUnspecified, nil)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
 		It("handles CONNECTION_CLOSE frames", func() {
-			testErr := qerr.Error(qerr.ProofInvalid, "foobar")
+			testErr := newConnectionError(qerr.Error(qerr.ProofInvalid, "foobar"), true)
 			streamManager.EXPECT().CloseWithError(testErr)
 			sessionRunner.EXPECT().removeConnectionID(gomock.Any())
 			cryptoSetup.EXPECT().Close()
@@ -335,7 +533,7 @@ var _ = Describe("Session", func() {
 				err := sess.run()
 				Expect(err).To(MatchError(testErr))
 			}()
-			err := sess.handleFrame(&wire.ConnectionCloseFrame{ErrorCode: qerr.ProofInvalid, ReasonPhrase: "foobar"}, 0, protocol.EncryptionUnspecified)
+			err := sess.handleFrame(&wire.ConnectionCloseFrame{ErrorCode: qerr.ProofInvalid, ReasonPhrase: "foobar"}, 0, protocol.EncryptionUnspecified, nil)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(sess.Context().Done()).Should(BeClosed())
 		})
@@ -346,10 +544,62 @@ var _ = Describe("Session", func() {
 		Expect(sess.GetVersion()).To(Equal(protocol.VersionNumber(4242)))
 	})
 
+	It("returns statistics", func() {
+		sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+		sph.EXPECT().GetStats().Return(ackhandler.Stats{
+			PacketsSent:           10,
+			PacketsLost:           2,
+			SpuriousLosses:        1,
+			PTOs:                  3,
+			PersistentCongestions: 4,
+			BytesRetransmitted:    1337,
+			BytesInFlight:         1000,
+			CongestionWindow:      5000,
+			PacingRate:            1e6,
+		})
+		sess.sentPacketHandler = sph
+		sess.rttStats.UpdateRTT(50*time.Millisecond, 0, time.Now())
+		Expect(sess.Stats().SmoothedRTT).To(BeZero())
+		sess.updateStats()
+		stats := sess.Stats()
+		Expect(stats.SmoothedRTT).To(Equal(50 * time.Millisecond))
+		Expect(stats.MinRTT).To(Equal(50 * time.Millisecond))
+		Expect(stats.LatestRTT).To(Equal(50 * time.Millisecond))
+		Expect(stats.RTTVariance).To(Equal(25 * time.Millisecond))
+		Expect(stats.PacketsSent).To(BeEquivalentTo(10))
+		Expect(stats.PacketsLost).To(BeEquivalentTo(2))
+		Expect(stats.BytesRetransmitted).To(BeEquivalentTo(1337))
+		Expect(stats.BytesInFlight).To(BeEquivalentTo(1000))
+		Expect(stats.CongestionWindow).To(BeEquivalentTo(5000))
+		Expect(stats.SpuriousLosses).To(BeEquivalentTo(1))
+		Expect(stats.PTOs).To(BeEquivalentTo(3))
+		Expect(stats.PersistentCongestions).To(BeEquivalentTo(4))
+		Expect(stats.PacingRate).To(BeEquivalentTo(1e6))
+	})
+
+	It("returns the connection state", func() {
+		sess.version = 4242
+		cryptoSetup.EXPECT().ConnectionState().Return(handshake.ConnectionState{
+			HandshakeComplete:  true,
+			NegotiatedProtocol: "h3",
+		})
+		state := sess.ConnectionState()
+		Expect(state.HandshakeComplete).To(BeTrue())
+		Expect(state.NegotiatedProtocol).To(Equal("h3"))
+		Expect(state.Version).To(Equal(protocol.VersionNumber(4242)))
+	})
+
+	It("exports keying material", func() {
+		cryptoSetup.EXPECT().ExportKeyingMaterial("label", []byte("context"), 42).Return([]byte("foobar"), nil)
+		key, err := sess.ExportKeyingMaterial("label", []byte("context"), 42)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key).To(Equal([]byte("foobar")))
+	})
+
 	It("accepts new streams", func() {
 		mstr := NewMockStreamI(mockCtrl)
-		streamManager.EXPECT().AcceptStream().Return(mstr, nil)
-		str, err := sess.AcceptStream()
+		streamManager.EXPECT().AcceptStream(gomock.Any()).Return(mstr, nil)
+		str, err := sess.AcceptStream(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(str).To(Equal(mstr))
 	})
@@ -378,7 +628,7 @@ var _ = Describe("Session", func() {
 		})
 
 		It("shuts down without error", func() {
-			streamManager.EXPECT().CloseWithError(qerr.Error(qerr.PeerGoingAway, ""))
+			streamManager.EXPECT().CloseWithError(newConnectionError(qerr.PeerGoingAway, false))
 			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
 			cryptoSetup.EXPECT().Close()
 			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{raw: []byte("connection close")}, nil)
@@ -389,8 +639,27 @@ var _ = Describe("Session", func() {
 			Expect(sess.Context().Done()).To(BeClosed())
 		})
 
+		It("traces when the session is closed", func() {
+			tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+			sess.tracer = tracer
+			streamManager.EXPECT().CloseWithError(newConnectionError(qerr.ApplicationError(0x1337, "test error"), false))
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			hdr := &wire.ExtendedHeader{PacketNumber: 42}
+			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{
+				header: hdr,
+				raw:    []byte("connection close"),
+			}, nil)
+			gomock.InOrder(
+				tracer.EXPECT().SentPacket(hdr, protocol.ByteCount(16), gomock.Any()),
+				tracer.EXPECT().ClosedConnection(qerr.ApplicationError(0x1337, "test error")),
+			)
+			sess.CloseWithError(0x1337, "test error")
+			Eventually(areSessionsRunning).Should(BeFalse())
+		})
+
 		It("only closes once", func() {
-			streamManager.EXPECT().CloseWithError(qerr.Error(qerr.PeerGoingAway, ""))
+			streamManager.EXPECT().CloseWithError(newConnectionError(qerr.PeerGoingAway, false))
 			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
 			cryptoSetup.EXPECT().Close()
 			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
@@ -402,16 +671,84 @@ var _ = Describe("Session", func() {
 		})
 
 		It("closes streams with proper error", func() {
-			testErr := errors.New("test error")
-			streamManager.EXPECT().CloseWithError(qerr.Error(0x1337, testErr.Error()))
+			streamManager.EXPECT().CloseWithError(newConnectionError(qerr.ApplicationError(0x1337, "test error"), false))
 			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
 			cryptoSetup.EXPECT().Close()
 			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
-			sess.CloseWithError(0x1337, testErr)
+			sess.CloseWithError(0x1337, "test error")
 			Eventually(areSessionsRunning).Should(BeFalse())
 			Expect(sess.Context().Done()).To(BeClosed())
 		})
 
+		It("sends an application CONNECTION_CLOSE when closing with an error", func() {
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			packer.EXPECT().PackConnectionClose(gomock.Any()).DoAndReturn(func(f *wire.ConnectionCloseFrame) (*packedPacket, error) {
+				Expect(f.IsApplicationError).To(BeTrue())
+				Expect(f.ErrorCode).To(BeEquivalentTo(0x1337))
+				Expect(f.ReasonPhrase).To(Equal("test error"))
+				return &packedPacket{}, nil
+			})
+			sess.CloseWithError(0x1337, "test error")
+			Eventually(areSessionsRunning).Should(BeFalse())
+		})
+
+		It("cancels the context with the close error", func() {
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+			sess.CloseWithError(0x1337, "test error")
+			Eventually(areSessionsRunning).Should(BeFalse())
+			Expect(context.Cause(sess.Context())).To(Equal(&ConnectionError{
+				IsApplicationError: true,
+				ErrorCode:          0x1337,
+				ReasonPhrase:       "test error",
+				err:                qerr.ApplicationError(0x1337, "test error"),
+			}))
+			Expect(IsApplicationError(context.Cause(sess.Context()))).To(BeTrue())
+			Expect(IsRemoteClose(context.Cause(sess.Context()))).To(BeFalse())
+		})
+
+		It("cancels the context with the error code of a remote CONNECTION_CLOSE", func() {
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			sessionRunner.EXPECT().removeConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			Expect(sess.handleFrame(&wire.ConnectionCloseFrame{
+				ErrorCode:    qerr.ProofInvalid,
+				ReasonPhrase: "foobar",
+			}, 0, protocol.Encryption1RTT, nil)).To(Succeed())
+			Eventually(areSessionsRunning).Should(BeFalse())
+			expectedErr := &ConnectionError{
+				Remote:       true,
+				ErrorCode:    uint16(qerr.ProofInvalid),
+				ReasonPhrase: "foobar",
+				err:          qerr.Error(qerr.ProofInvalid, "foobar"),
+			}
+			Expect(context.Cause(sess.Context())).To(Equal(expectedErr))
+			Expect(IsApplicationError(context.Cause(sess.Context()))).To(BeFalse())
+			Expect(IsRemoteClose(context.Cause(sess.Context()))).To(BeTrue())
+			expectedRunErr = expectedErr
+		})
+
+		It("closes with an application error when receiving an application CONNECTION_CLOSE", func() {
+			streamManager.EXPECT().CloseWithError(newConnectionError(qerr.ApplicationError(0x1337, "foobar"), true))
+			sessionRunner.EXPECT().removeConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			Expect(sess.handleFrame(&wire.ConnectionCloseFrame{
+				IsApplicationError: true,
+				ErrorCode:          0x1337,
+				ReasonPhrase:       "foobar",
+			}, 0, protocol.Encryption1RTT, nil)).To(Succeed())
+			Eventually(areSessionsRunning).Should(BeFalse())
+			expectedErr := newConnectionError(qerr.ApplicationError(0x1337, "foobar"), true)
+			Expect(context.Cause(sess.Context())).To(Equal(expectedErr))
+			Expect(IsApplicationError(context.Cause(sess.Context()))).To(BeTrue())
+			Expect(IsRemoteClose(context.Cause(sess.Context()))).To(BeTrue())
+			expectedRunErr = expectedErr
+		})
+
 		It("closes the session in order to recreate it", func() {
 			streamManager.EXPECT().CloseWithError(gomock.Any())
 			sessionRunner.EXPECT().removeConnectionID(gomock.Any())
@@ -430,7 +767,7 @@ var _ = Describe("Session", func() {
 			sess.destroy(testErr)
 			Eventually(areSessionsRunning).Should(BeFalse())
 			Expect(mconn.written).To(BeEmpty()) // no CONNECTION_CLOSE or PUBLIC_RESET sent
-			expectedRunErr = testErr
+			expectedRunErr = newConnectionError(testErr, false)
 		})
 
 		It("cancels the context when the run loop exists", func() {
@@ -501,7 +838,7 @@ var _ = Describe("Session", func() {
 				data:            []byte{0}, // one PADDING frame
 			}, nil)
 			rph := mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
-			rph.EXPECT().ReceivedPacket(protocol.PacketNumber(0x1337), protocol.EncryptionInitial, rcvTime, false)
+			rph.EXPECT().ReceivedPacket(protocol.PacketNumber(0x1337), protocol.ECNNon, protocol.EncryptionInitial, rcvTime, false)
 			sess.receivedPacketHandler = rph
 			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
 				rcvTime: rcvTime,
@@ -525,13 +862,203 @@ var _ = Describe("Session", func() {
 				data:            buf.Bytes(),
 			}, nil)
 			rph := mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
-			rph.EXPECT().ReceivedPacket(protocol.PacketNumber(0x1337), protocol.EncryptionHandshake, rcvTime, true)
+			rph.EXPECT().ReceivedPacket(protocol.PacketNumber(0x1337), protocol.ECNNon, protocol.EncryptionHandshake, rcvTime, true)
+			sess.receivedPacketHandler = rph
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				rcvTime: rcvTime,
+				hdr:     &hdr.Header,
+				data:    getData(hdr),
+			}))).To(BeTrue())
+		})
+
+		It("passes the ECN codepoint to the ReceivedPacketHandler", func() {
+			hdr := &wire.ExtendedHeader{
+				PacketNumber:    0x37,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			rcvTime := time.Now().Add(-10 * time.Second)
+			buf := &bytes.Buffer{}
+			Expect((&wire.PingFrame{}).Write(buf, sess.version)).To(Succeed())
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				packetNumber:    0x1337,
+				encryptionLevel: protocol.Encryption1RTT,
+				hdr:             hdr,
+				data:            buf.Bytes(),
+			}, nil)
+			rph := mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
+			rph.EXPECT().ReceivedPacket(protocol.PacketNumber(0x1337), protocol.ECNCE, protocol.Encryption1RTT, rcvTime, true)
 			sess.receivedPacketHandler = rph
 			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
 				rcvTime: rcvTime,
+				ecn:     protocol.ECNCE,
+				hdr:     &hdr.Header,
+				data:    getData(hdr),
+			}))).To(BeTrue())
+		})
+
+		It("passes STREAM frames referencing the packet buffer to the stream", func() {
+			hdr := &wire.ExtendedHeader{
+				PacketNumber:    0x37,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			buf := &bytes.Buffer{}
+			Expect((&wire.StreamFrame{StreamID: 5, Data: []byte("foobar")}).Write(buf, sess.version)).To(Succeed())
+			data := buf.Bytes()
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				packetNumber:    0x1337,
+				encryptionLevel: protocol.Encryption1RTT,
+				hdr:             hdr,
+				data:            data,
+			}, nil)
+			p := insertPacketBuffer(&receivedPacket{
+				rcvTime: time.Now(),
+				hdr:     &hdr.Header,
+				data:    getData(hdr),
+			})
+			str := NewMockReceiveStreamI(mockCtrl)
+			streamManager.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(5)).Return(str, nil).Times(2)
+			str.EXPECT().checkOutOfOrderData(gomock.Any(), protocol.ByteCount(0))
+			str.EXPECT().handleStreamFrame(gomock.Any(), p.buffer).Do(func(f *wire.StreamFrame, _ *packetBuffer) {
+				Expect(f.Data).To(Equal([]byte("foobar")))
+				// the data is not copied
+				Expect(&f.Data[0]).To(BeIdenticalTo(&data[len(data)-6]))
+			})
+			rph := mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
+			rph.EXPECT().ReceivedPacket(protocol.PacketNumber(0x1337), protocol.ECNNon, protocol.Encryption1RTT, gomock.Any(), true)
+			sess.receivedPacketHandler = rph
+			Expect(sess.handlePacketImpl(p)).To(BeTrue())
+		})
+
+		It("doesn't acknowledge a packet when there's too much out-of-order stream data", func() {
+			hdr := &wire.ExtendedHeader{
+				PacketNumber:    0x37,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			f := &wire.StreamFrame{StreamID: 5, Offset: 0x1000, Data: []byte("foobar")}
+			buf := &bytes.Buffer{}
+			Expect(f.Write(buf, sess.version)).To(Succeed())
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				packetNumber:    0x1337,
+				encryptionLevel: protocol.Encryption1RTT,
+				hdr:             hdr,
+				data:            buf.Bytes(),
+			}, nil)
+			str := NewMockReceiveStreamI(mockCtrl)
+			streamManager.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(5)).Return(str, nil)
+			str.EXPECT().checkOutOfOrderData(gomock.Any(), gomock.Any()).Return(protocol.ByteCount(0), errTooMuchOutOfOrderData)
+			rph := mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
+			// don't EXPECT any calls to ReceivedPacket
+			sess.receivedPacketHandler = rph
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				rcvTime: time.Now(),
+				hdr:     &hdr.Header,
+				data:    getData(hdr),
+			}))).To(BeTrue())
+		})
+
+		It("doesn't handle any frame of a packet when there's too much out-of-order stream data", func() {
+			hdr := &wire.ExtendedHeader{
+				PacketNumber:    0x37,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			buf := &bytes.Buffer{}
+			Expect((&wire.MaxDataFrame{ByteOffset: 0x1337}).Write(buf, sess.version)).To(Succeed())
+			Expect((&wire.StreamFrame{StreamID: 5, Offset: 0x1000, Data: []byte("foo"), DataLenPresent: true}).Write(buf, sess.version)).To(Succeed())
+			Expect((&wire.StreamFrame{StreamID: 9, Offset: 0x1000, Data: []byte("bar")}).Write(buf, sess.version)).To(Succeed())
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				packetNumber:    0x1337,
+				encryptionLevel: protocol.Encryption1RTT,
+				hdr:             hdr,
+				data:            buf.Bytes(),
+			}, nil)
+			str1 := NewMockReceiveStreamI(mockCtrl)
+			str2 := NewMockReceiveStreamI(mockCtrl)
+			streamManager.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(5)).Return(str1, nil)
+			streamManager.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(9)).Return(str2, nil)
+			str1.EXPECT().checkOutOfOrderData(gomock.Any(), protocol.ByteCount(0)).Return(protocol.ByteCount(3), nil)
+			// the out-of-order data of the first frame is taken into account
+			str2.EXPECT().checkOutOfOrderData(gomock.Any(), protocol.ByteCount(3)).Return(protocol.ByteCount(0), errTooMuchOutOfOrderData)
+			// don't EXPECT any calls to handleStreamFrame, or to the ReceivedPacketHandler
+			sess.receivedPacketHandler = mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				rcvTime: time.Now(),
 				hdr:     &hdr.Header,
 				data:    getData(hdr),
 			}))).To(BeTrue())
+			// the MAX_DATA frame wasn't handled
+			Expect(sess.connFlowController.SendWindowSize()).ToNot(BeEquivalentTo(0x1337))
+		})
+
+		It("traces received packets", func() {
+			tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+			sess.tracer = tracer
+			hdr := &wire.ExtendedHeader{
+				PacketNumber:    0x37,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			buf := &bytes.Buffer{}
+			Expect((&wire.PingFrame{}).Write(buf, sess.version)).To(Succeed())
+			Expect((&wire.MaxDataFrame{ByteOffset: 1337}).Write(buf, sess.version)).To(Succeed())
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				packetNumber:    0x1337,
+				encryptionLevel: protocol.Encryption1RTT,
+				hdr:             hdr,
+				data:            buf.Bytes(),
+			}, nil)
+			data := getData(hdr)
+			rph := mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
+			rph.EXPECT().ReceivedPacket(protocol.PacketNumber(0x1337), protocol.ECNNon, protocol.Encryption1RTT, gomock.Any(), true)
+			sess.receivedPacketHandler = rph
+			tracer.EXPECT().ReceivedPacket(hdr, protocol.ByteCount(len(data)), []wire.Frame{
+				&wire.PingFrame{},
+				&wire.MaxDataFrame{ByteOffset: 1337},
+			})
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				rcvTime: time.Now(),
+				hdr:     &hdr.Header,
+				data:    data,
+			}))).To(BeTrue())
+		})
+
+		It("traces packets that are dropped because there's too much out-of-order stream data", func() {
+			tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+			sess.tracer = tracer
+			hdr := &wire.ExtendedHeader{
+				PacketNumber:    0x37,
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			buf := &bytes.Buffer{}
+			Expect((&wire.StreamFrame{StreamID: 5, Offset: 0x1000, Data: []byte("foobar")}).Write(buf, sess.version)).To(Succeed())
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				packetNumber:    0x1337,
+				encryptionLevel: protocol.Encryption1RTT,
+				hdr:             hdr,
+				data:            buf.Bytes(),
+			}, nil)
+			str := NewMockReceiveStreamI(mockCtrl)
+			streamManager.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(5)).Return(str, nil)
+			str.EXPECT().checkOutOfOrderData(gomock.Any(), gomock.Any()).Return(protocol.ByteCount(0), errTooMuchOutOfOrderData)
+			data := getData(hdr)
+			tracer.EXPECT().DroppedPacket(protocol.ByteCount(len(data)), logging.PacketDropBufferFull)
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				rcvTime: time.Now(),
+				hdr:     &hdr.Header,
+				data:    data,
+			}))).To(BeTrue())
+		})
+
+		It("traces 0-RTT packets as dropped", func() {
+			tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+			sess.tracer = tracer
+			tracer.EXPECT().DroppedPacket(protocol.ByteCount(6), logging.PacketDropUnexpectedPacket)
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				hdr: &wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketType0RTT,
+					DestConnectionID: sess.srcConnID,
+				},
+				data: []byte("foobar"),
+			}))).To(BeFalse())
 		})
 
 		It("drops a packet when unpacking fails", func() {
@@ -568,7 +1095,7 @@ var _ = Describe("Session", func() {
 				defer GinkgoRecover()
 				cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
 				err := sess.run()
-				Expect(err).To(MatchError(qerr.MissingPayload))
+				Expect(errors.Is(err, qerr.MissingPayload)).To(BeTrue())
 				close(done)
 			}()
 			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
@@ -593,16 +1120,142 @@ var _ = Describe("Session", func() {
 			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{hdr: &hdr.Header, data: getData(hdr)}))).To(BeTrue())
 		})
 
-		It("ignores 0-RTT packets", func() {
-			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
-				hdr: &wire.Header{
-					IsLongHeader:     true,
-					Type:             protocol.PacketType0RTT,
+		It("updates the spin bit when receiving 1-RTT packets", func() {
+			sess.spinBit.enabled = true
+			hdr := &wire.ExtendedHeader{
+				PacketNumber:    5,
+				PacketNumberLen: protocol.PacketNumberLen1,
+				SpinBit:         true,
+			}
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				encryptionLevel: protocol.Encryption1RTT,
+				hdr:             hdr,
+				data:            []byte{0}, // one PADDING frame
+			}, nil)
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{hdr: &hdr.Header, data: getData(hdr)}))).To(BeTrue())
+			Expect(sess.spinBit.Value()).To(BeTrue())
+		})
+
+		It("ignores 0-RTT packets", func() {
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				hdr: &wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketType0RTT,
+					DestConnectionID: sess.srcConnID,
+				},
+			}))).To(BeFalse())
+		})
+
+		It("drops Initial packets that are too small", func() {
+			tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+			sess.tracer = tracer
+			tracer.EXPECT().DroppedPacket(protocol.ByteCount(protocol.MinInitialPacketSize-1), logging.PacketDropUnexpectedPacket)
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				hdr: &wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketTypeInitial,
+					DestConnectionID: sess.srcConnID,
+				},
+				data:         make([]byte, protocol.MinInitialPacketSize-1),
+				datagramSize: protocol.MinInitialPacketSize - 1,
+			}))).To(BeFalse())
+		})
+
+		It("doesn't count the bytes of Initial packets that are too small", func() {
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sess.sentPacketHandler = sph
+			// no call to ReceivedBytes
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				hdr: &wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketTypeInitial,
 					DestConnectionID: sess.srcConnID,
 				},
+				data:         make([]byte, 100),
+				datagramSize: 100,
 			}))).To(BeFalse())
 		})
 
+		It("accepts small Initial packets that are coalesced into a large enough datagram", func() {
+			hdr := &wire.ExtendedHeader{
+				Header:          wire.Header{IsLongHeader: true, Type: protocol.PacketTypeInitial, DestConnectionID: sess.srcConnID},
+				PacketNumberLen: protocol.PacketNumberLen1,
+			}
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				encryptionLevel: protocol.EncryptionInitial,
+				hdr:             hdr,
+				data:            []byte{0}, // one PADDING frame
+			}, nil)
+			Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+				hdr:          &hdr.Header,
+				data:         make([]byte, 100),
+				datagramSize: protocol.MinInitialPacketSize,
+			}))).To(BeTrue())
+		})
+
+		Context("validating the client's address", func() {
+			var sph *mockackhandler.MockSentPacketHandler
+
+			BeforeEach(func() {
+				sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
+				sess.sentPacketHandler = sph
+			})
+
+			It("counts the bytes received", func() {
+				sph.EXPECT().ReceivedBytes(protocol.ByteCount(6))
+				Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+					hdr: &wire.Header{
+						IsLongHeader:     true,
+						Type:             protocol.PacketType0RTT,
+						DestConnectionID: sess.srcConnID,
+					},
+					data: []byte("foobar"),
+				}))).To(BeFalse())
+			})
+
+			It("doesn't count packets that are queued for later decryption", func() {
+				unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(nil, handshake.ErrOpenerNotYetAvailable)
+				Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+					hdr:  &wire.Header{IsLongHeader: true, Type: protocol.PacketTypeHandshake, DestConnectionID: sess.srcConnID},
+					data: []byte("foobar"),
+				}))).To(BeFalse())
+				Expect(sess.undecryptablePackets).To(HaveLen(1))
+			})
+
+			It("validates the address when receiving a Handshake packet", func() {
+				hdr := &wire.ExtendedHeader{
+					Header:          wire.Header{IsLongHeader: true, Type: protocol.PacketTypeHandshake, DestConnectionID: sess.srcConnID},
+					PacketNumberLen: protocol.PacketNumberLen1,
+				}
+				unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+					encryptionLevel: protocol.EncryptionHandshake,
+					hdr:             hdr,
+					data:            []byte{0}, // one PADDING frame
+				}, nil)
+				sph.EXPECT().ReceivedBytes(protocol.ByteCount(6))
+				sph.EXPECT().SetPeerAddressValidated()
+				Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{hdr: &hdr.Header, data: []byte("foobar")}))).To(BeTrue())
+			})
+
+			It("doesn't validate the address when receiving an Initial packet", func() {
+				hdr := &wire.ExtendedHeader{
+					Header:          wire.Header{IsLongHeader: true, Type: protocol.PacketTypeInitial, DestConnectionID: sess.srcConnID},
+					PacketNumberLen: protocol.PacketNumberLen1,
+				}
+				unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+					encryptionLevel: protocol.EncryptionInitial,
+					hdr:             hdr,
+					data:            []byte{0}, // one PADDING frame
+				}, nil)
+				sph.EXPECT().ReceivedBytes(protocol.ByteCount(protocol.MinInitialPacketSize))
+				Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+					hdr:          &hdr.Header,
+					data:         make([]byte, protocol.MinInitialPacketSize),
+					datagramSize: protocol.MinInitialPacketSize,
+				}))).To(BeTrue())
+			})
+		})
+
 		It("ignores packets with a different source connection ID", func() {
 			hdr := &wire.Header{
 				IsLongHeader:     true,
@@ -634,7 +1287,7 @@ var _ = Describe("Session", func() {
 		})
 
 		Context("updating the remote address", func() {
-			It("doesn't support connection migration", func() {
+			It("doesn't change the remote address before the handshake completes", func() {
 				unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
 					encryptionLevel: protocol.Encryption1RTT,
 					hdr:             &wire.ExtendedHeader{},
@@ -649,6 +1302,156 @@ var _ = Describe("Session", func() {
 					data:       getData(&wire.ExtendedHeader{PacketNumberLen: protocol.PacketNumberLen1}),
 				}))).To(BeTrue())
 				Expect(sess.conn.(*mockConnection).remoteAddr).To(Equal(origAddr))
+				Expect(mconn.writtenTo).To(BeEmpty())
+			})
+
+			Context("after the handshake completed", func() {
+				var (
+					sph      *mockackhandler.MockSentPacketHandler
+					origAddr *net.UDPAddr
+				)
+
+				BeforeEach(func() {
+					sess.handshakeComplete = true
+					sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
+					sph.EXPECT().ReceivedBytes(gomock.Any()).AnyTimes()
+					sph.EXPECT().SetPeerAddressValidated().AnyTimes()
+					sess.sentPacketHandler = sph
+					origAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1234}
+					mconn.remoteAddr = origAddr
+				})
+
+				receivePacketFrom := func(addr net.Addr, dataLen int) {
+					unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+						encryptionLevel: protocol.Encryption1RTT,
+						hdr:             &wire.ExtendedHeader{},
+						data:            []byte{0}, // one PADDING frame
+					}, nil)
+					ExpectWithOffset(1, sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
+						remoteAddr: addr,
+						hdr:        &wire.Header{},
+						data:       make([]byte, dataLen),
+					}))).To(BeTrue())
+				}
+
+				expectPathChallenge := func() *[8]byte {
+					challenge := &[8]byte{}
+					packer.EXPECT().PackPathChallenge(gomock.Any()).DoAndReturn(func(f *wire.PathChallengeFrame) (*packedPacket, error) {
+						*challenge = f.Data
+						buffer := getPacketBuffer()
+						return &packedPacket{
+							raw:    append(buffer.Slice[:0], []byte("challenge")...),
+							buffer: buffer,
+							header: &wire.ExtendedHeader{PacketNumber: 10},
+							frames: []wire.Frame{f},
+						}, nil
+					})
+					return challenge
+				}
+
+				It("validates the new address before switching to it", func() {
+					newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 100), Port: 4321}
+					challenge := expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					receivePacketFrom(newAddr, 100)
+					var write mockConnectionWrite
+					Expect(mconn.writtenTo).To(Receive(&write))
+					Expect(write.to).To(Equal(newAddr))
+					Expect(write.data).To(Equal([]byte("challenge")))
+					Expect(sess.RemoteAddr()).To(Equal(origAddr))
+					// more packets from the new address don't trigger a new PATH_CHALLENGE
+					receivePacketFrom(newAddr, 100)
+					Expect(mconn.writtenTo).To(BeEmpty())
+					// a PATH_RESPONSE with the wrong data is ignored
+					Expect(sess.handleFrame(&wire.PathResponseFrame{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, 0, protocol.Encryption1RTT, nil)).To(Succeed())
+					Expect(sess.RemoteAddr()).To(Equal(origAddr))
+					sph.EXPECT().OnConnectionMigration()
+					Expect(sess.handleFrame(&wire.PathResponseFrame{Data: *challenge}, 0, protocol.Encryption1RTT, nil)).To(Succeed())
+					Expect(sess.RemoteAddr()).To(Equal(newAddr))
+				})
+
+				It("doesn't reset the congestion controller if only the port changed", func() {
+					newAddr := &net.UDPAddr{IP: origAddr.IP, Port: 4321}
+					challenge := expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					receivePacketFrom(newAddr, 100)
+					Expect(mconn.writtenTo).To(Receive())
+					// no call to OnConnectionMigration
+					Expect(sess.handleFrame(&wire.PathResponseFrame{Data: *challenge}, 0, protocol.Encryption1RTT, nil)).To(Succeed())
+					Expect(sess.RemoteAddr()).To(Equal(newAddr))
+				})
+
+				It("only validates one address at a time", func() {
+					expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					receivePacketFrom(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 100), Port: 4321}, 100)
+					Expect(mconn.writtenTo).To(Receive())
+					receivePacketFrom(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 200), Port: 4321}, 100)
+					Expect(mconn.writtenTo).To(BeEmpty())
+				})
+
+				It("limits the amplification when sending to an unvalidated address", func() {
+					newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 100), Port: 4321}
+					// The PATH_CHALLENGE packet is 9 bytes long.
+					// Receiving 2 bytes only allows us to send 6 bytes.
+					expectPathChallenge()
+					receivePacketFrom(newAddr, 2)
+					Expect(mconn.writtenTo).To(BeEmpty())
+					// After receiving 3 bytes (2 + 1), we can send 9 bytes.
+					expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					receivePacketFrom(newAddr, 1)
+					Expect(mconn.writtenTo).To(Receive())
+				})
+
+				It("retransmits the PATH_CHALLENGE after a PTO", func() {
+					newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 100), Port: 4321}
+					challenge := expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					receivePacketFrom(newAddr, 100)
+					Expect(mconn.writtenTo).To(Receive())
+					firstChallenge := *challenge
+					Expect(sess.pathValidation.nextChallenge).To(BeTemporally(">", time.Now()))
+					timeout := sess.pathValidation.nextChallenge
+					challenge = expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					Expect(sess.sendPathValidationChallenge()).To(Succeed())
+					Expect(mconn.writtenTo).To(Receive())
+					Expect(*challenge).To(Equal(firstChallenge))
+					// the timeout is doubled
+					Expect(sess.pathValidation.nextChallenge).To(BeTemporally(">", timeout))
+				})
+
+				It("counts retransmissions of the PATH_CHALLENGE against the amplification limit", func() {
+					newAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 100), Port: 4321}
+					expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					receivePacketFrom(newAddr, 3)
+					Expect(mconn.writtenTo).To(Receive())
+					// Another 9 bytes would exceed the limit.
+					expectPathChallenge()
+					Expect(sess.sendPathValidationChallenge()).To(Succeed())
+					Expect(mconn.writtenTo).To(BeEmpty())
+					Expect(sess.pathValidation.nextChallenge.IsZero()).To(BeTrue())
+					// Receiving more bytes allows us to retransmit.
+					expectPathChallenge()
+					sph.EXPECT().SentPacket(gomock.Any())
+					receivePacketFrom(newAddr, 3)
+					Expect(mconn.writtenTo).To(Receive())
+				})
+
+				It("doesn't validate an address that is equal to the current address", func() {
+					receivePacketFrom(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1234}, 100)
+					Expect(mconn.writtenTo).To(BeEmpty())
+					Expect(sess.pathValidation).To(BeNil())
+				})
+
+				It("doesn't validate new addresses if migration is disabled", func() {
+					sess.config.DisableMigration = true
+					receivePacketFrom(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 100), Port: 4321}, 100)
+					Expect(mconn.writtenTo).To(BeEmpty())
+					Expect(sess.RemoteAddr()).To(Equal(origAddr))
+				})
 			})
 		})
 	})
@@ -667,7 +1470,19 @@ var _ = Describe("Session", func() {
 
 		It("sends packets", func() {
 			packer.EXPECT().PackPacket().Return(getPacket(1), nil)
-			Expect(sess.receivedPacketHandler.ReceivedPacket(0x035e, protocol.Encryption1RTT, time.Now(), true)).To(Succeed())
+			Expect(sess.receivedPacketHandler.ReceivedPacket(0x035e, protocol.ECNNon, protocol.Encryption1RTT, time.Now(), true)).To(Succeed())
+			sent, err := sess.sendPacket()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sent).To(BeTrue())
+		})
+
+		It("traces sent packets", func() {
+			tracer := mocklogging.NewMockConnectionTracer(mockCtrl)
+			sess.tracer = tracer
+			p := getPacket(1)
+			p.frames = []wire.Frame{&wire.PingFrame{}}
+			packer.EXPECT().PackPacket().Return(p, nil)
+			tracer.EXPECT().SentPacket(p.header, protocol.ByteCount(6), []wire.Frame{&wire.PingFrame{}})
 			sent, err := sess.sendPacket()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(sent).To(BeTrue())
@@ -675,7 +1490,7 @@ var _ = Describe("Session", func() {
 
 		It("doesn't send packets if there's nothing to send", func() {
 			packer.EXPECT().PackPacket().Return(getPacket(2), nil)
-			Expect(sess.receivedPacketHandler.ReceivedPacket(0x035e, protocol.Encryption1RTT, time.Now(), true)).To(Succeed())
+			Expect(sess.receivedPacketHandler.ReceivedPacket(0x035e, protocol.ECNNon, protocol.Encryption1RTT, time.Now(), true)).To(Succeed())
 			sent, err := sess.sendPacket()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(sent).To(BeTrue())
@@ -684,6 +1499,7 @@ var _ = Describe("Session", func() {
 		It("sends ACK only packets", func() {
 			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
 			sph.EXPECT().GetAlarmTimeout().AnyTimes()
+			sph.EXPECT().GetStats().AnyTimes()
 			sph.EXPECT().SendMode().Return(ackhandler.SendAck)
 			sph.EXPECT().ShouldSendNumPackets().Return(1000)
 			packer.EXPECT().MaybePackAckPacket()
@@ -703,6 +1519,64 @@ var _ = Describe("Session", func() {
 			Expect(frames).To(Equal([]wire.Frame{&wire.DataBlockedFrame{DataLimit: 1337}}))
 		})
 
+		Context("send state", func() {
+			It("is application limited if there's nothing to send", func() {
+				sess.framer.QueueControlFrame(&wire.PingFrame{})
+				packer.EXPECT().PackPacket().Return(getPacket(1), nil).Do(func() {
+					sess.framer.AppendControlFrames(nil, 1000)
+				})
+				packer.EXPECT().PackPacket()
+				Expect(sess.sendPackets()).To(Succeed())
+				sess.updateSendState(time.Now())
+				Expect(sess.Stats().SendState).To(Equal(SendStateApplicationLimited))
+			})
+
+			It("is congestion limited if there's data to send, but the congestion controller doesn't allow sending it", func() {
+				sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+				sph.EXPECT().SendMode().Return(ackhandler.SendAck)
+				sph.EXPECT().ShouldSendNumPackets().Return(1000)
+				packer.EXPECT().MaybePackAckPacket()
+				sess.sentPacketHandler = sph
+				sess.framer.QueueControlFrame(&wire.PingFrame{})
+				Expect(sess.sendPackets()).To(Succeed())
+				sess.updateSendState(time.Now())
+				Expect(sess.Stats().SendState).To(Equal(SendStateCongestionLimited))
+			})
+
+			It("is flow control blocked until a MAX_DATA frame is received", func() {
+				fc := mocks.NewMockConnectionFlowController(mockCtrl)
+				fc.EXPECT().IsNewlyBlocked().Return(true, protocol.ByteCount(1337))
+				packer.EXPECT().PackPacket()
+				sess.connFlowController = fc
+				sent, err := sess.sendPacket()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(sent).To(BeFalse())
+				sess.updateSendState(time.Now())
+				Expect(sess.Stats().SendState).To(Equal(SendStateFlowControlBlocked))
+				fc.EXPECT().UpdateSendWindow(protocol.ByteCount(2000))
+				sess.handleMaxDataFrame(&wire.MaxDataFrame{ByteOffset: 2000})
+				sess.updateSendState(time.Now())
+				Expect(sess.Stats().SendState).To(Equal(SendStateApplicationLimited))
+			})
+
+			It("keeps track of the time spent in every send state", func() {
+				start := time.Now()
+				clock := &mockClock{now: start}
+				sess.clock = clock
+				sess.sendState = newSendStateTracker(start)
+				sess.connFlowControlBlocked = true
+				sess.updateSendState(start.Add(time.Second))
+				sess.connFlowControlBlocked = false
+				sess.updateSendState(start.Add(3 * time.Second))
+				clock.Advance(10 * time.Second)
+				stats := sess.Stats()
+				Expect(stats.SendState).To(Equal(SendStateApplicationLimited))
+				Expect(stats.TimeApplicationLimited).To(Equal(8 * time.Second))
+				Expect(stats.TimeFlowControlBlocked).To(Equal(2 * time.Second))
+				Expect(stats.TimeCongestionLimited).To(BeZero())
+			})
+		})
+
 		It("sends a retransmission and a regular packet in the same run", func() {
 			packetToRetransmit := &ackhandler.Packet{
 				PacketNumber: 10,
@@ -757,6 +1631,53 @@ var _ = Describe("Session", func() {
 			Expect(mconn.written).To(HaveLen(2))
 		})
 
+		It("queues a PING again when the packet carrying it is lost", func() {
+			req := &pingRequest{ctx: context.Background(), rttChan: make(chan time.Duration, 1)}
+			sess.pendingPings = []*pingRequest{req}
+			packet := getPacket(42)
+			packet.frames = []wire.Frame{&wire.PingFrame{}}
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			var ackCb func(time.Duration)
+			var lossCb func()
+			sph.EXPECT().RegisterAckCallback(protocol.PacketNumber(42), gomock.Any()).Do(func(_ protocol.PacketNumber, f func(time.Duration)) { ackCb = f })
+			sph.EXPECT().RegisterLossCallback(protocol.PacketNumber(42), gomock.Any()).Do(func(_ protocol.PacketNumber, f func()) { lossCb = f })
+			sess.sentPacketHandler = sph
+			Expect(sess.registerPings(packet)).To(Succeed())
+			Expect(sess.pendingPings).To(BeEmpty())
+			Expect(ackCb).ToNot(BeNil())
+			Expect(lossCb).ToNot(BeNil())
+			lossCb()
+			Expect(sess.pendingPings).To(Equal([]*pingRequest{req}))
+			frames, _ := sess.framer.AppendControlFrames(nil, 1000)
+			Expect(frames).To(Equal([]wire.Frame{&wire.PingFrame{}}))
+		})
+
+		It("doesn't register PINGs for packets that don't carry a PING frame", func() {
+			sess.pendingPings = []*pingRequest{{ctx: context.Background(), rttChan: make(chan time.Duration, 1)}}
+			packet := getPacket(42)
+			packet.frames = []wire.Frame{&wire.MaxDataFrame{}}
+			sess.sentPacketHandler = mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			Expect(sess.registerPings(packet)).To(Succeed())
+			Expect(sess.pendingPings).To(HaveLen(1))
+		})
+
+		It("doesn't queue a PING again if Ping already returned", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			sess.pendingPings = []*pingRequest{{ctx: ctx, rttChan: make(chan time.Duration, 1)}}
+			packet := getPacket(42)
+			packet.frames = []wire.Frame{&wire.PingFrame{}}
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			var lossCb func()
+			sph.EXPECT().RegisterAckCallback(protocol.PacketNumber(42), gomock.Any())
+			sph.EXPECT().RegisterLossCallback(protocol.PacketNumber(42), gomock.Any()).Do(func(_ protocol.PacketNumber, f func()) { lossCb = f })
+			sess.sentPacketHandler = sph
+			Expect(sess.registerPings(packet)).To(Succeed())
+			lossCb()
+			Expect(sess.pendingPings).To(BeEmpty())
+			Expect(sess.framer.HasData()).To(BeFalse())
+		})
+
 		It("sends a probe packet", func() {
 			packetToRetransmit := &ackhandler.Packet{
 				PacketNumber: 0x42,
@@ -777,6 +1698,69 @@ var _ = Describe("Session", func() {
 			Expect(sess.sendPackets()).To(Succeed())
 		})
 
+		It("sends a PING as a probe packet, if the packet only contained DATAGRAM frames", func() {
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().TimeUntilSend()
+			sph.EXPECT().SendMode().Return(ackhandler.SendPTO)
+			sph.EXPECT().ShouldSendNumPackets().Return(1)
+			sph.EXPECT().DequeueProbePacket()
+			packer.EXPECT().PackPacket().DoAndReturn(func() (*packedPacket, error) {
+				frames, _ := sess.framer.AppendControlFrames(nil, 1000)
+				Expect(frames).To(Equal([]wire.Frame{&wire.PingFrame{}}))
+				p := getPacket(123)
+				p.frames = frames
+				return p, nil
+			})
+			sph.EXPECT().SentPacket(gomock.Any())
+			sess.sentPacketHandler = sph
+			Expect(sess.sendPackets()).To(Succeed())
+			Expect(mconn.written).To(HaveLen(1))
+		})
+
+		It("sends new data as a probe packet", func() {
+			sess.handshakeComplete = true
+			sess.framer.QueueControlFrame(&wire.MaxDataFrame{ByteOffset: 1337})
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().TimeUntilSend()
+			sph.EXPECT().SendMode().Return(ackhandler.SendPTO)
+			sph.EXPECT().ShouldSendNumPackets().Return(1)
+			packer.EXPECT().PackPacket().DoAndReturn(func() (*packedPacket, error) {
+				p := getPacket(123)
+				p.frames, _ = sess.framer.AppendControlFrames(nil, 1000)
+				return p, nil
+			})
+			sph.EXPECT().SentPacket(gomock.Any()).Do(func(p *ackhandler.Packet) {
+				Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(123)))
+			})
+			sess.sentPacketHandler = sph
+			Expect(sess.sendPackets()).To(Succeed())
+			Expect(mconn.written).To(HaveLen(1))
+		})
+
+		It("retransmits data as a probe packet, if the new packet doesn't contain any retransmittable frames", func() {
+			sess.handshakeComplete = true
+			sess.framer.QueueControlFrame(&wire.MaxDataFrame{ByteOffset: 1337})
+			packetToRetransmit := &ackhandler.Packet{PacketNumber: 0x42}
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().TimeUntilSend()
+			sph.EXPECT().SendMode().Return(ackhandler.SendPTO)
+			sph.EXPECT().ShouldSendNumPackets().Return(1)
+			gomock.InOrder(
+				packer.EXPECT().PackPacket().DoAndReturn(func() (*packedPacket, error) {
+					p := getPacket(123)
+					p.frames = []wire.Frame{&wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 1, Largest: 1}}}}
+					return p, nil
+				}),
+				sph.EXPECT().SentPacket(gomock.Any()),
+				sph.EXPECT().DequeueProbePacket().Return(packetToRetransmit, nil),
+				packer.EXPECT().PackRetransmission(packetToRetransmit).Return([]*packedPacket{getPacket(124)}, nil),
+				sph.EXPECT().SentPacketsAsRetransmission(gomock.Any(), protocol.PacketNumber(0x42)),
+			)
+			sess.sentPacketHandler = sph
+			Expect(sess.sendPackets()).To(Succeed())
+			Expect(mconn.written).To(HaveLen(2))
+		})
+
 		It("doesn't send when the SentPacketHandler doesn't allow it", func() {
 			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
 			sph.EXPECT().SendMode().Return(ackhandler.SendNone)
@@ -785,12 +1769,29 @@ var _ = Describe("Session", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		It("writes the packets as a single batch", func() {
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().ShouldSendNumPackets().Return(3)
+			sph.EXPECT().SendMode().Return(ackhandler.SendAny).Times(3)
+			sph.EXPECT().SentPacket(gomock.Any()).Times(3)
+			sph.EXPECT().TimeUntilSend()
+			packer.EXPECT().PackPacket().Return(getPacket(10), nil)
+			packer.EXPECT().PackPacket().Return(getPacket(11), nil)
+			packer.EXPECT().PackPacket().Return(getPacket(12), nil)
+			sess.sentPacketHandler = sph
+			Expect(sess.sendPackets()).To(Succeed())
+			Expect(mconn.written).To(HaveLen(3))
+			Expect(mconn.batchSizes).To(Equal([]int{3}))
+			Expect(sess.packetBatch).To(BeEmpty())
+		})
+
 		Context("packet pacing", func() {
 			var sph *mockackhandler.MockSentPacketHandler
 
 			BeforeEach(func() {
 				sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
 				sph.EXPECT().GetAlarmTimeout().AnyTimes()
+				sph.EXPECT().GetStats().AnyTimes()
 				sph.EXPECT().DequeuePacketForRetransmission().AnyTimes()
 				sess.sentPacketHandler = sph
 				streamManager.EXPECT().CloseWithError(gomock.Any())
@@ -878,6 +1879,29 @@ var _ = Describe("Session", func() {
 				Eventually(done).Should(BeClosed())
 			})
 
+			It("sends ACK-only packets while waiting for the pacing deadline", func() {
+				sph.EXPECT().TimeUntilSend().Return(time.Now().Add(time.Hour)).AnyTimes()
+				sph.EXPECT().SentPacket(gomock.Any())
+				packer.EXPECT().MaybePackAckPacket().Return(getPacket(10), nil)
+				packer.EXPECT().MaybePackAckPacket().AnyTimes()
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
+					sess.run()
+					close(done)
+				}()
+				sess.scheduleSending()
+				Eventually(mconn.written).Should(HaveLen(1))
+				Consistently(mconn.written).Should(HaveLen(1))
+				// make the go routine return
+				packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+				sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+				cryptoSetup.EXPECT().Close()
+				sess.Close()
+				Eventually(done).Should(BeClosed())
+			})
+
 			It("sends multiple packets at once", func() {
 				sph.EXPECT().SentPacket(gomock.Any()).Times(3)
 				sph.EXPECT().ShouldSendNumPackets().Return(3)
@@ -931,6 +1955,7 @@ var _ = Describe("Session", func() {
 			It("sends when scheduleSending is called", func() {
 				sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
 				sph.EXPECT().GetAlarmTimeout().AnyTimes()
+				sph.EXPECT().GetStats().AnyTimes()
 				sph.EXPECT().TimeUntilSend().AnyTimes()
 				sph.EXPECT().SendMode().Return(ackhandler.SendAny).AnyTimes()
 				sph.EXPECT().ShouldSendNumPackets().AnyTimes().Return(1)
@@ -961,6 +1986,7 @@ var _ = Describe("Session", func() {
 				sph.EXPECT().TimeUntilSend().Return(time.Now())
 				sph.EXPECT().TimeUntilSend().Return(time.Now().Add(time.Hour))
 				sph.EXPECT().GetAlarmTimeout().AnyTimes()
+				sph.EXPECT().GetStats().AnyTimes()
 				sph.EXPECT().SendMode().Return(ackhandler.SendAny).AnyTimes()
 				sph.EXPECT().ShouldSendNumPackets().Return(1)
 				sph.EXPECT().SentPacket(gomock.Any()).Do(func(p *ackhandler.Packet) {
@@ -992,7 +2018,7 @@ var _ = Describe("Session", func() {
 
 	It("closes when RunHandshake() errors", func() {
 		testErr := errors.New("crypto setup error")
-		streamManager.EXPECT().CloseWithError(qerr.Error(qerr.InternalError, testErr.Error()))
+		streamManager.EXPECT().CloseWithError(newConnectionError(testErr, false))
 		sessionRunner.EXPECT().retireConnectionID(gomock.Any())
 		cryptoSetup.EXPECT().Close()
 		packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
@@ -1000,19 +2026,24 @@ var _ = Describe("Session", func() {
 			defer GinkgoRecover()
 			cryptoSetup.EXPECT().RunHandshake().Return(testErr)
 			err := sess.run()
-			Expect(err).To(MatchError(testErr))
+			Expect(errors.Is(err, testErr)).To(BeTrue())
 		}()
 		Eventually(sess.Context().Done()).Should(BeClosed())
 	})
 
 	It("calls the onHandshakeComplete callback when the handshake completes", func() {
+		sessionRunner.EXPECT().newToken(gomock.Any()).Return([]byte("token"), nil)
 		packer.EXPECT().PackPacket().AnyTimes()
+		Expect(sess.HandshakeComplete()).ToNot(BeClosed())
 		go func() {
 			defer GinkgoRecover()
-			sessionRunner.EXPECT().onHandshakeComplete(gomock.Any())
+			sessionRunner.EXPECT().onHandshakeComplete(gomock.Any()).Do(func(quicSession) {
+				Expect(sess.HandshakeComplete()).To(BeClosed())
+			})
 			cryptoSetup.EXPECT().RunHandshake()
 			sess.run()
 		}()
+		Eventually(sess.HandshakeComplete()).Should(BeClosed())
 		Consistently(sess.Context().Done()).ShouldNot(BeClosed())
 		// make sure the go routine returns
 		sessionRunner.EXPECT().retireConnectionID(gomock.Any())
@@ -1023,7 +2054,84 @@ var _ = Describe("Session", func() {
 		Eventually(sess.Context().Done()).Should(BeClosed())
 	})
 
+	It("is ready to be accepted early when the ClientHello was processed", func() {
+		sess.cryptoStreamManager = newCryptoStreamManager(cryptoSetup, newCryptoStream(), newCryptoStream())
+		clientHello := []byte{1, 0, 0, 3, 'f', 'o', 'o'} // message type, length, message
+		cryptoSetup.EXPECT().HandleMessage(clientHello, protocol.EncryptionInitial).Return(true)
+		Expect(sess.earlySessionReady()).ToNot(BeClosed())
+		Expect(sess.handleCryptoFrame(&wire.CryptoFrame{Data: clientHello}, protocol.EncryptionInitial)).To(Succeed())
+		Expect(sess.earlySessionReady()).To(BeClosed())
+		// the client's Finished message changes the encryption level again
+		finished := []byte{20, 0, 0, 3, 'b', 'a', 'r'}
+		cryptoSetup.EXPECT().HandleMessage(finished, protocol.EncryptionHandshake).Return(true)
+		Expect(sess.handleCryptoFrame(&wire.CryptoFrame{Data: finished}, protocol.EncryptionHandshake)).To(Succeed())
+	})
+
+	It("issues new connection IDs when the handshake completes", func() {
+		sessionRunner.EXPECT().newToken(gomock.Any()).Return([]byte("token"), nil)
+		sess.config.ActiveConnectionIDs = 3
+		packer.EXPECT().PackPacket().AnyTimes()
+		connIDs := make(chan protocol.ConnectionID, 2)
+		sessionRunner.EXPECT().getStatelessResetToken(gomock.Any()).Return([16]byte{1, 2, 3}).Times(2)
+		sessionRunner.EXPECT().addConnectionID(gomock.Any(), sess).Do(func(c protocol.ConnectionID, _ quicSession) {
+			connIDs <- c
+		}).Return(true).Times(2)
+		go func() {
+			defer GinkgoRecover()
+			sessionRunner.EXPECT().onHandshakeComplete(gomock.Any())
+			cryptoSetup.EXPECT().RunHandshake()
+			sess.run()
+		}()
+		Eventually(connIDs).Should(HaveLen(2))
+		// make sure the go routine returns
+		sessionRunner.EXPECT().retireConnectionID(gomock.Any()).Times(3)
+		streamManager.EXPECT().CloseWithError(gomock.Any())
+		packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+		cryptoSetup.EXPECT().Close()
+		Expect(sess.Close()).To(Succeed())
+		Eventually(sess.Context().Done()).Should(BeClosed())
+		frames, _ := sess.framer.AppendControlFrames(nil, protocol.MaxByteCount)
+		newConnIDFrames := make(map[uint64]*wire.NewConnectionIDFrame)
+		for _, f := range frames {
+			if f, ok := f.(*wire.NewConnectionIDFrame); ok {
+				newConnIDFrames[f.SequenceNumber] = f
+			}
+		}
+		Expect(newConnIDFrames).To(HaveLen(2))
+		Expect(newConnIDFrames).To(HaveKey(uint64(1)))
+		Expect(newConnIDFrames[1].ConnectionID).To(Equal(<-connIDs))
+		Expect(newConnIDFrames[1].StatelessResetToken).To(Equal([16]byte{1, 2, 3}))
+		Expect(newConnIDFrames).To(HaveKey(uint64(2)))
+		Expect(newConnIDFrames[2].ConnectionID).To(Equal(<-connIDs))
+	})
+
+	It("sends a NEW_TOKEN frame when the handshake completes", func() {
+		packer.EXPECT().PackPacket().AnyTimes()
+		tokenChan := make(chan struct{})
+		sessionRunner.EXPECT().newToken(sess.RemoteAddr()).DoAndReturn(func(net.Addr) ([]byte, error) {
+			close(tokenChan)
+			return []byte("token"), nil
+		})
+		go func() {
+			defer GinkgoRecover()
+			sessionRunner.EXPECT().onHandshakeComplete(gomock.Any())
+			cryptoSetup.EXPECT().RunHandshake()
+			sess.run()
+		}()
+		Eventually(tokenChan).Should(BeClosed())
+		// make sure the go routine returns
+		sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+		streamManager.EXPECT().CloseWithError(gomock.Any())
+		packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+		cryptoSetup.EXPECT().Close()
+		Expect(sess.Close()).To(Succeed())
+		Eventually(sess.Context().Done()).Should(BeClosed())
+		frames, _ := sess.framer.AppendControlFrames(nil, protocol.MaxByteCount)
+		Expect(frames).To(ContainElement(&wire.NewTokenFrame{Token: []byte("token")}))
+	})
+
 	It("sends a forward-secure packet when the handshake completes", func() {
+		sessionRunner.EXPECT().newToken(gomock.Any()).Return([]byte("token"), nil)
 		done := make(chan struct{})
 		gomock.InOrder(
 			sessionRunner.EXPECT().onHandshakeComplete(gomock.Any()),
@@ -1074,17 +2182,35 @@ var _ = Describe("Session", func() {
 			defer GinkgoRecover()
 			cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
 			err := sess.run()
-			Expect(err).To(MatchError(qerr.Error(0x1337, testErr.Error())))
+			Expect(err).To(Equal(newConnectionError(qerr.ApplicationError(0x1337, testErr.Error()), false)))
 			close(done)
 		}()
 		streamManager.EXPECT().CloseWithError(gomock.Any())
 		sessionRunner.EXPECT().retireConnectionID(gomock.Any())
 		packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
 		cryptoSetup.EXPECT().Close()
-		Expect(sess.CloseWithError(0x1337, testErr)).To(Succeed())
+		Expect(sess.CloseWithError(0x1337, testErr.Error())).To(Succeed())
 		Eventually(done).Should(BeClosed())
 	})
 
+	It("exports the transport parameters it sent, as they are sent", func() {
+		params := &handshake.TransportParameters{
+			IdleTimeout:          1500 * time.Millisecond,
+			MaxAckDelay:          2500 * time.Microsecond,
+			MaxBidiStreams:       10,
+			MaxDatagramFrameSize: 1000,
+			DisableMigration:     true,
+		}
+		Expect(exportTransportParameters(params, true)).To(Equal(TransportParameters{
+			IdleTimeout:          time.Second,
+			MaxAckDelay:          2 * time.Millisecond,
+			MaxPacketSize:        protocol.MaxReceivePacketSize,
+			MaxBidiStreams:       10,
+			MaxDatagramFrameSize: 1000,
+			DisableMigration:     true,
+		}))
+	})
+
 	It("process transport parameters received from the peer", func() {
 		go func() {
 			defer GinkgoRecover()
@@ -1096,10 +2222,28 @@ var _ = Describe("Session", func() {
 			InitialMaxStreamDataBidiLocal: 0x5000,
 			InitialMaxData:                0x5000,
 			MaxPacketSize:                 0x42,
+			MaxAckDelay:                   37 * time.Millisecond,
 		}
 		streamManager.EXPECT().UpdateLimits(params)
 		packer.EXPECT().HandleTransportParameters(params)
+		_, ok := sess.PeerParams()
+		Expect(ok).To(BeFalse())
 		sess.processTransportParameters(params)
+		Expect(sess.rttStats.MaxAckDelay()).To(Equal(37 * time.Millisecond))
+		negotiated, ok := sess.PeerParams()
+		Expect(ok).To(BeTrue())
+		Expect(negotiated.Peer).To(Equal(TransportParameters{
+			IdleTimeout:                   90 * time.Second,
+			InitialMaxStreamDataBidiLocal: 0x5000,
+			InitialMaxData:                0x5000,
+			MaxPacketSize:                 0x42,
+			MaxAckDelay:                   37 * time.Millisecond,
+		}))
+		Expect(negotiated.IdleTimeout).To(Equal(sess.config.IdleTimeout)) // the local idle timeout is shorter
+		// the snapshot can't be modified by changing the transport parameters
+		params.InitialMaxData = 0x1337
+		negotiated, _ = sess.PeerParams()
+		Expect(negotiated.Peer.InitialMaxData).To(BeEquivalentTo(0x5000))
 		// make the go routine return
 		streamManager.EXPECT().CloseWithError(gomock.Any())
 		sessionRunner.EXPECT().retireConnectionID(gomock.Any())
@@ -1144,10 +2288,16 @@ var _ = Describe("Session", func() {
 			Eventually(done).Should(BeClosed())
 		})
 
-		It("doesn't send a PING packet if keep-alive is disabled", func() {
+		It("sends a PING after the configured keep-alive period", func() {
 			sess.handshakeComplete = true
-			sess.config.KeepAlive = false
-			sess.lastNetworkActivityTime = time.Now().Add(-remoteIdleTimeout / 2)
+			sess.config.KeepAlive = true
+			sess.config.KeepAlivePeriod = time.Second
+			sess.lastNetworkActivityTime = time.Now().Add(-time.Second)
+			sent := make(chan struct{})
+			packer.EXPECT().PackPacket().Do(func() (*packedPacket, error) {
+				close(sent)
+				return nil, nil
+			})
 			done := make(chan struct{})
 			go func() {
 				defer GinkgoRecover()
@@ -1155,7 +2305,7 @@ var _ = Describe("Session", func() {
 				sess.run()
 				close(done)
 			}()
-			Consistently(mconn.written).ShouldNot(Receive())
+			Eventually(sent).Should(BeClosed())
 			// make the go routine return
 			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
 			streamManager.EXPECT().CloseWithError(gomock.Any())
@@ -1165,13 +2315,41 @@ var _ = Describe("Session", func() {
 			Eventually(done).Should(BeClosed())
 		})
 
-		It("doesn't send a PING if the handshake isn't completed yet", func() {
-			sess.handshakeComplete = false
-			sess.config.KeepAlive = true
-			sess.lastNetworkActivityTime = time.Now().Add(-remoteIdleTimeout / 2)
-			done := make(chan struct{})
-			go func() {
-				defer GinkgoRecover()
+		It("uses half the negotiated idle timeout as the default keep-alive period", func() {
+			sess.config.IdleTimeout = 10 * time.Second
+			Expect(sess.keepAlivePeriod()).To(Equal(5 * time.Second))
+			sess.config.IdleTimeout = time.Minute
+			Expect(sess.keepAlivePeriod()).To(Equal(remoteIdleTimeout / 2))
+		})
+
+		It("doesn't send a PING packet if keep-alive is disabled", func() {
+			sess.handshakeComplete = true
+			sess.config.KeepAlive = false
+			sess.lastNetworkActivityTime = time.Now().Add(-remoteIdleTimeout / 2)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
+				sess.run()
+				close(done)
+			}()
+			Consistently(mconn.written).ShouldNot(Receive())
+			// make the go routine return
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+			cryptoSetup.EXPECT().Close()
+			sess.Close()
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("doesn't send a PING if the handshake isn't completed yet", func() {
+			sess.handshakeComplete = false
+			sess.config.KeepAlive = true
+			sess.lastNetworkActivityTime = time.Now().Add(-remoteIdleTimeout / 2)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
 				cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
 				sess.run()
 				close(done)
@@ -1187,6 +2365,16 @@ var _ = Describe("Session", func() {
 		})
 	})
 
+	It("uses the local idle timeout, if it is shorter, or if the peer didn't send an idle timeout", func() {
+		sess.config.IdleTimeout = time.Minute
+		sess.peerParams = &handshake.TransportParameters{IdleTimeout: time.Hour}
+		Expect(sess.idleTimeout()).To(Equal(time.Minute))
+		sess.peerParams = &handshake.TransportParameters{}
+		Expect(sess.idleTimeout()).To(Equal(time.Minute))
+		sess.peerParams = nil
+		Expect(sess.idleTimeout()).To(Equal(time.Minute))
+	})
+
 	Context("timeouts", func() {
 		BeforeEach(func() {
 			streamManager.EXPECT().CloseWithError(gomock.Any())
@@ -1206,7 +2394,31 @@ var _ = Describe("Session", func() {
 				defer GinkgoRecover()
 				cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
 				err := sess.run()
-				Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.NetworkIdleTimeout))
+				Expect(errors.Is(err, ErrIdleTimeout)).To(BeTrue())
+				Expect(err.(*ConnectionError).Timeout()).To(BeTrue())
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("uses the peer's idle timeout, if it is shorter", func() {
+			sess.config.IdleTimeout = time.Hour
+			sess.peerParams = &handshake.TransportParameters{IdleTimeout: 30 * time.Second}
+			Expect(sess.idleTimeout()).To(Equal(30 * time.Second))
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			sess.handshakeComplete = true
+			sess.lastNetworkActivityTime = time.Now().Add(-time.Minute)
+			done := make(chan struct{})
+			cryptoSetup.EXPECT().Close()
+			packer.EXPECT().PackConnectionClose(gomock.Any()).DoAndReturn(func(f *wire.ConnectionCloseFrame) (*packedPacket, error) {
+				Expect(f.ErrorCode).To(Equal(qerr.NetworkIdleTimeout))
+				return &packedPacket{}, nil
+			})
+			go func() {
+				defer GinkgoRecover()
+				cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
+				err := sess.run()
+				Expect(errors.Is(err, ErrIdleTimeout)).To(BeTrue())
 				close(done)
 			}()
 			Eventually(done).Should(BeClosed())
@@ -1225,7 +2437,8 @@ var _ = Describe("Session", func() {
 				defer GinkgoRecover()
 				cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
 				err := sess.run()
-				Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.HandshakeTimeout))
+				Expect(errors.Is(err, ErrHandshakeTimeout)).To(BeTrue())
+				Expect(errors.Is(err, ErrIdleTimeout)).To(BeFalse())
 				close(done)
 			}()
 			Eventually(done).Should(BeClosed())
@@ -1253,7 +2466,32 @@ var _ = Describe("Session", func() {
 			Eventually(sess.Context().Done()).Should(BeClosed())
 		})
 
+		It("starts the idle timeout when the handshake completes", func() {
+			sessionRunner.EXPECT().newToken(gomock.Any()).Return([]byte("token"), nil)
+			packer.EXPECT().PackPacket().AnyTimes()
+			sess.config.IdleTimeout = scaleDuration(100 * time.Millisecond)
+			sess.lastNetworkActivityTime = time.Now().Add(-time.Hour)
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				sessionRunner.EXPECT().onHandshakeComplete(sess)
+				cryptoSetup.EXPECT().RunHandshake()
+				sess.run()
+				close(done)
+			}()
+			Consistently(done, scaleDuration(50*time.Millisecond)).ShouldNot(BeClosed())
+			// make the go routine return
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			packer.EXPECT().PackConnectionClose(gomock.Any()).DoAndReturn(func(f *wire.ConnectionCloseFrame) (*packedPacket, error) {
+				Expect(f.ErrorCode).To(Equal(qerr.NetworkIdleTimeout))
+				return &packedPacket{}, nil
+			})
+			Eventually(done).Should(BeClosed())
+		})
+
 		It("closes the session due to the idle timeout after handshake", func() {
+			sessionRunner.EXPECT().newToken(gomock.Any()).Return([]byte("token"), nil)
 			packer.EXPECT().PackPacket().AnyTimes()
 			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
 			cryptoSetup.EXPECT().Close()
@@ -1268,13 +2506,392 @@ var _ = Describe("Session", func() {
 				sessionRunner.EXPECT().onHandshakeComplete(sess)
 				cryptoSetup.EXPECT().RunHandshake()
 				err := sess.run()
-				Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.NetworkIdleTimeout))
+				Expect(errors.Is(err, ErrIdleTimeout)).To(BeTrue())
+				Expect(err.(*ConnectionError).Timeout()).To(BeTrue())
 				close(done)
 			}()
 			Eventually(done).Should(BeClosed())
 		})
 	})
 
+	Context("resetting the timer", func() {
+		It("only resets the timer when the deadline moves to an earlier time", func() {
+			const idleTimeout = time.Minute
+			start := time.Now()
+			clock := &mockClock{now: start}
+			timer := &mockTimer{c: make(chan time.Time)}
+			sess.clock = clock
+			sess.timer = timer
+			sess.handshakeComplete = true
+			sess.config.IdleTimeout = idleTimeout
+			sess.lastNetworkActivityTime = start
+			unpacker := NewMockUnpacker(mockCtrl)
+			sess.unpacker = unpacker
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().GetAlarmTimeout().AnyTimes()
+			sph.EXPECT().GetStats().AnyTimes()
+			sph.EXPECT().TimeUntilSend().AnyTimes()
+			sph.EXPECT().SendMode().Return(ackhandler.SendAny).AnyTimes()
+			sph.EXPECT().ShouldSendNumPackets().Return(1000).AnyTimes()
+			sph.EXPECT().ReceivedBytes(gomock.Any()).AnyTimes()
+			sph.EXPECT().SetPeerAddressValidated().AnyTimes()
+			sess.sentPacketHandler = sph
+			// the ACK alarm is only accessed from the run loop
+			var ackAlarm time.Time
+			rph := mockackhandler.NewMockReceivedPacketHandler(mockCtrl)
+			rph.EXPECT().GetAlarmTimeout().DoAndReturn(func() time.Time { return ackAlarm }).AnyTimes()
+			sess.receivedPacketHandler = rph
+
+			receivePacket := func(pn protocol.PacketNumber) {
+				received := make(chan struct{})
+				unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+					packetNumber:    pn,
+					encryptionLevel: protocol.Encryption1RTT,
+					hdr:             &wire.ExtendedHeader{PacketNumber: pn},
+					data:            []byte{0x1}, // a PING frame
+				}, nil)
+				rph.EXPECT().ReceivedPacket(pn, protocol.ECNNon, protocol.Encryption1RTT, clock.Now(), true).Do(func(protocol.PacketNumber, protocol.ECN, protocol.EncryptionLevel, time.Time, bool) {
+					if pn == 1 { // only the request is acknowledged
+						ackAlarm = clock.Now().Add(25 * time.Millisecond)
+					}
+					close(received)
+				})
+				packer.EXPECT().PackPacket()
+				sess.handlePacket(insertPacketBuffer(&receivedPacket{
+					rcvTime: clock.Now(),
+					hdr:     &wire.Header{},
+					data:    []byte{0x1},
+				}))
+				Eventually(received).Should(BeClosed())
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				cryptoSetup.EXPECT().RunHandshake().Do(func() { <-sess.Context().Done() })
+				sess.run()
+				close(done)
+			}()
+			Eventually(timer.Resets).Should(Equal([]time.Time{start.Add(idleTimeout)}))
+			// receive a request
+			clock.Advance(10 * time.Millisecond)
+			receivePacket(1)
+			Eventually(timer.Resets).Should(HaveLen(2))
+			Expect(timer.Resets()[1]).To(Equal(start.Add(35 * time.Millisecond)))
+			// send the response, including the ACK for the request
+			clock.Advance(10 * time.Millisecond)
+			sph.EXPECT().SentPacket(gomock.Any())
+			packer.EXPECT().PackPacket().DoAndReturn(func() (*packedPacket, error) {
+				ackAlarm = time.Time{}
+				buffer := getPacketBuffer()
+				return &packedPacket{
+					raw:    append(buffer.Slice[:0], []byte("response")...),
+					buffer: buffer,
+					header: &wire.ExtendedHeader{PacketNumber: 1},
+				}, nil
+			})
+			packer.EXPECT().PackPacket()
+			sess.scheduleSending()
+			Eventually(mconn.written).Should(Receive())
+			// receive the ACK for the response
+			clock.Advance(30 * time.Millisecond)
+			receivePacket(2)
+			// The idle timeout was pushed back twice, but the timer wasn't reset.
+			// It fires at the original ACK alarm, and is then reset to the idle timeout.
+			Consistently(timer.Resets).Should(HaveLen(2))
+			packer.EXPECT().PackPacket()
+			timer.c <- clock.Now()
+			Eventually(timer.Resets).Should(HaveLen(3))
+			Expect(timer.Resets()[2]).To(Equal(start.Add(50 * time.Millisecond).Add(idleTimeout)))
+			// make the go routine return
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			sess.Close()
+			Eventually(done).Should(BeClosed())
+		})
+	})
+
+	Context("closing gracefully", func() {
+		var sph *mockackhandler.MockSentPacketHandler
+
+		BeforeEach(func() {
+			sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().GetAlarmTimeout().AnyTimes()
+			sph.EXPECT().GetStats().AnyTimes()
+			sph.EXPECT().TimeUntilSend().AnyTimes()
+			sph.EXPECT().SendMode().Return(ackhandler.SendNone).AnyTimes()
+			sess.sentPacketHandler = sph
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+		})
+
+		runSession := func() {
+			ctx := sess.Context()
+			cryptoSetup.EXPECT().RunHandshake().Do(func() { <-ctx.Done() })
+			go func() {
+				defer GinkgoRecover()
+				sess.run()
+			}()
+		}
+
+		expectConnectionClose := func() {
+			packer.EXPECT().PackConnectionClose(gomock.Any()).DoAndReturn(func(f *wire.ConnectionCloseFrame) (*packedPacket, error) {
+				Expect(f.ErrorCode).To(Equal(qerr.PeerGoingAway))
+				return &packedPacket{}, nil
+			})
+		}
+
+		It("doesn't open or accept new streams", func() {
+			sess.draining.Set(true)
+			_, err := sess.OpenStream()
+			Expect(err).To(MatchError(errSessionDraining))
+			_, err = sess.OpenUniStreamSync(context.Background())
+			Expect(err).To(MatchError(errSessionDraining))
+			_, err = sess.AcceptStream(context.Background())
+			Expect(err).To(MatchError(errSessionDraining))
+			_, err = sess.AcceptUniStream(context.Background())
+			Expect(err).To(MatchError(errSessionDraining))
+			// make the go routine return
+			expectConnectionClose()
+			runSession()
+			Expect(sess.Close()).To(Succeed())
+		})
+
+		It("closes as soon as all data has been acknowledged", func() {
+			streamManager.EXPECT().HasUnsentData().AnyTimes()
+			gomock.InOrder(
+				sph.EXPECT().HasOutstandingData().Return(true),
+				sph.EXPECT().HasOutstandingData().Return(false),
+			)
+			expectConnectionClose()
+			runSession()
+			errChan := make(chan error, 1)
+			go func() { errChan <- sess.CloseGracefully(time.Hour) }()
+			Consistently(errChan).ShouldNot(Receive())
+			// trigger another iteration of the run loop, as if an ACK was received
+			sess.scheduleSending()
+			Eventually(errChan).Should(Receive(BeNil()))
+			Expect(sess.Context().Done()).To(BeClosed())
+		})
+
+		It("waits until the streams have sent all their data", func() {
+			sph.EXPECT().HasOutstandingData().AnyTimes()
+			gomock.InOrder(
+				streamManager.EXPECT().HasUnsentData().Return(true),
+				streamManager.EXPECT().HasUnsentData().Return(false),
+			)
+			expectConnectionClose()
+			runSession()
+			errChan := make(chan error, 1)
+			go func() { errChan <- sess.CloseGracefully(time.Hour) }()
+			Consistently(errChan).ShouldNot(Receive())
+			sess.scheduleSending()
+			Eventually(errChan).Should(Receive(BeNil()))
+		})
+
+		It("closes when the timeout is reached, without running into the idle timeout", func() {
+			sess.handshakeComplete = true
+			// the idle timeout would fire during the graceful close
+			sess.lastNetworkActivityTime = time.Now().Add(-sess.config.IdleTimeout).Add(scaleDuration(50 * time.Millisecond))
+			streamManager.EXPECT().HasUnsentData().AnyTimes()
+			sph.EXPECT().HasOutstandingData().Return(true).AnyTimes()
+			expectConnectionClose()
+			runSession()
+			errChan := make(chan error, 1)
+			go func() { errChan <- sess.CloseGracefully(scaleDuration(200 * time.Millisecond)) }()
+			Consistently(errChan, scaleDuration(150*time.Millisecond)).ShouldNot(Receive())
+			Eventually(errChan).Should(Receive(MatchError(errGracefulCloseTimeout)))
+		})
+	})
+
+	Context("sending and receiving messages", func() {
+		It("doesn't send messages if DATAGRAM support is disabled", func() {
+			Expect(sess.SendMessage([]byte("foobar"))).To(MatchError("datagram support disabled"))
+			_, err := sess.ReceiveMessage()
+			Expect(err).To(MatchError("datagram support disabled"))
+		})
+
+		It("doesn't send messages if the peer didn't enable DATAGRAM support", func() {
+			sess.config.EnableDatagrams = true
+			Expect(sess.SendMessage([]byte("foobar"))).To(MatchError("datagram support not negotiated with the peer"))
+		})
+
+		Context("with DATAGRAM support", func() {
+			BeforeEach(func() {
+				sess.config.EnableDatagrams = true
+				streamManager.EXPECT().UpdateLimits(gomock.Any())
+				packer.EXPECT().HandleTransportParameters(gomock.Any())
+			})
+
+			It("sends messages", func() {
+				sess.processTransportParameters(&handshake.TransportParameters{MaxDatagramFrameSize: 1000})
+				Expect(sess.SendMessage([]byte("foobar"))).To(Succeed())
+				Expect(sess.sendingScheduled).To(Receive())
+				f := sess.datagramQueue.Peek()
+				Expect(f).ToNot(BeNil())
+				Expect(f.Data).To(Equal([]byte("foobar")))
+			})
+
+			It("rejects messages that are too large", func() {
+				sess.processTransportParameters(&handshake.TransportParameters{MaxDatagramFrameSize: 1000})
+				err := sess.SendMessage(make([]byte, 1000))
+				Expect(err).To(BeAssignableToTypeOf(&DatagramTooLargeError{}))
+				Expect(err.(*DatagramTooLargeError).MaxDataLen).To(BeEquivalentTo(1000 - 1 - 2))
+				Expect(sess.SendMessage(make([]byte, 1000-1-2))).To(Succeed())
+			})
+
+			It("limits the message size such that it fits into a single packet", func() {
+				sess.processTransportParameters(&handshake.TransportParameters{MaxDatagramFrameSize: protocol.MaxByteCount})
+				err := sess.SendMessage(make([]byte, 2000))
+				Expect(err).To(BeAssignableToTypeOf(&DatagramTooLargeError{}))
+				Expect(err.(*DatagramTooLargeError).MaxDataLen).To(BeNumerically("<", protocol.MaxDatagramFrameSize))
+			})
+		})
+
+		It("returns the close error when receiving messages on a closed session", func() {
+			sess.config.EnableDatagrams = true
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+			ctx := sess.Context()
+			cryptoSetup.EXPECT().RunHandshake().Do(func() { <-ctx.Done() })
+			go func() {
+				defer GinkgoRecover()
+				sess.run()
+			}()
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := sess.ReceiveMessage()
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(sess.CloseWithError(0x1337, "foobar")).To(Succeed())
+			Eventually(errChan).Should(Receive(Equal(newConnectionError(qerr.ApplicationError(0x1337, "foobar"), false))))
+		})
+	})
+
+	Context("pinging", func() {
+		var (
+			sph           *mockackhandler.MockSentPacketHandler
+			ackCallbacks  chan func(time.Duration)
+			lossCallbacks chan func()
+		)
+
+		BeforeEach(func() {
+			sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().GetAlarmTimeout().AnyTimes()
+			sph.EXPECT().GetStats().AnyTimes()
+			sph.EXPECT().TimeUntilSend().AnyTimes()
+			sph.EXPECT().SendMode().Return(ackhandler.SendAny).AnyTimes()
+			sph.EXPECT().ShouldSendNumPackets().Return(1000).AnyTimes()
+			sph.EXPECT().SentPacket(gomock.Any()).AnyTimes()
+			sess.sentPacketHandler = sph
+			ackCallbacks = make(chan func(time.Duration), 10)
+			sph.EXPECT().RegisterAckCallback(gomock.Any(), gomock.Any()).Do(func(_ protocol.PacketNumber, cb func(time.Duration)) {
+				ackCallbacks <- cb
+			}).AnyTimes()
+			lossCallbacks = make(chan func(), 10)
+			sph.EXPECT().RegisterLossCallback(gomock.Any(), gomock.Any()).Do(func(_ protocol.PacketNumber, cb func()) {
+				lossCallbacks <- cb
+			}).AnyTimes()
+			var pn protocol.PacketNumber
+			// pack every control frame into a separate packet
+			packer.EXPECT().PackPacket().DoAndReturn(func() (*packedPacket, error) {
+				frames, _ := sess.framer.AppendControlFrames(nil, 1)
+				if len(frames) == 0 {
+					return nil, nil
+				}
+				pn++
+				buffer := getPacketBuffer()
+				return &packedPacket{
+					raw:    append(buffer.Slice[:0], []byte("foobar")...),
+					buffer: buffer,
+					header: &wire.ExtendedHeader{PacketNumber: pn},
+					frames: frames,
+				}, nil
+			}).AnyTimes()
+			streamManager.EXPECT().CloseWithError(gomock.Any())
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+			ctx := sess.Context()
+			cryptoSetup.EXPECT().RunHandshake().Do(func() { <-ctx.Done() })
+			go func() {
+				defer GinkgoRecover()
+				sess.run()
+			}()
+		})
+
+		AfterEach(func() {
+			Expect(sess.Close()).To(Succeed())
+		})
+
+		It("returns the RTT when the PING is acknowledged", func() {
+			rttChan := make(chan time.Duration, 1)
+			go func() {
+				defer GinkgoRecover()
+				rtt, err := sess.Ping(context.Background())
+				Expect(err).ToNot(HaveOccurred())
+				rttChan <- rtt
+			}()
+			var cb func(time.Duration)
+			Eventually(ackCallbacks).Should(Receive(&cb))
+			Consistently(rttChan).ShouldNot(Receive())
+			cb(1337 * time.Millisecond)
+			Eventually(rttChan).Should(Receive(Equal(1337 * time.Millisecond)))
+		})
+
+		It("resolves concurrent PINGs independently", func() {
+			rttChan := make(chan time.Duration, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					defer GinkgoRecover()
+					rtt, err := sess.Ping(context.Background())
+					Expect(err).ToNot(HaveOccurred())
+					rttChan <- rtt
+				}()
+			}
+			var cb1, cb2 func(time.Duration)
+			Eventually(ackCallbacks).Should(Receive(&cb1))
+			Eventually(ackCallbacks).Should(Receive(&cb2))
+			cb1(time.Second)
+			Eventually(rttChan).Should(Receive(Equal(time.Second)))
+			Consistently(rttChan).ShouldNot(Receive())
+			cb2(2 * time.Second)
+			Eventually(rttChan).Should(Receive(Equal(2 * time.Second)))
+		})
+
+		It("returns when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			errChan := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				_, err := sess.Ping(ctx)
+				errChan <- err
+			}()
+			Eventually(ackCallbacks).Should(Receive())
+			Consistently(errChan).ShouldNot(Receive())
+			cancel()
+			Eventually(errChan).Should(Receive(MatchError(context.Canceled)))
+		})
+
+		It("fails pending PINGs when the session is closed", func() {
+			errChan := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				_, err := sess.Ping(context.Background())
+				errChan <- err
+			}()
+			Eventually(ackCallbacks).Should(Receive())
+			Expect(sess.CloseWithError(0x42, "foobar")).To(Succeed())
+			Eventually(errChan).Should(Receive(Equal(newConnectionError(qerr.ApplicationError(0x42, "foobar"), false))))
+		})
+	})
+
 	It("stores up to MaxSessionUnprocessedPackets packets", func(done Done) {
 		// Nothing here should block
 		for i := protocol.PacketNumber(0); i < protocol.MaxSessionUnprocessedPackets+10; i++ {
@@ -1283,6 +2900,10 @@ var _ = Describe("Session", func() {
 		close(done)
 	}, 0.5)
 
+	It("doesn't allow servers to migrate", func() {
+		Expect(sess.Migrate(&net.UDPConn{})).To(MatchError("only clients can migrate"))
+	})
+
 	Context("getting streams", func() {
 		It("returns a new stream", func() {
 			mstr := NewMockStreamI(mockCtrl)
@@ -1318,8 +2939,8 @@ var _ = Describe("Session", func() {
 
 		It("opens streams synchronously", func() {
 			mstr := NewMockStreamI(mockCtrl)
-			streamManager.EXPECT().OpenStreamSync().Return(mstr, nil)
-			str, err := sess.OpenStreamSync()
+			streamManager.EXPECT().OpenStreamSync(gomock.Any()).Return(mstr, nil)
+			str, err := sess.OpenStreamSync(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(str).To(Equal(mstr))
 		})
@@ -1334,24 +2955,24 @@ var _ = Describe("Session", func() {
 
 		It("opens unidirectional streams synchronously", func() {
 			mstr := NewMockSendStreamI(mockCtrl)
-			streamManager.EXPECT().OpenUniStreamSync().Return(mstr, nil)
-			str, err := sess.OpenUniStreamSync()
+			streamManager.EXPECT().OpenUniStreamSync(gomock.Any()).Return(mstr, nil)
+			str, err := sess.OpenUniStreamSync(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(str).To(Equal(mstr))
 		})
 
 		It("accepts streams", func() {
 			mstr := NewMockStreamI(mockCtrl)
-			streamManager.EXPECT().AcceptStream().Return(mstr, nil)
-			str, err := sess.AcceptStream()
+			streamManager.EXPECT().AcceptStream(gomock.Any()).Return(mstr, nil)
+			str, err := sess.AcceptStream(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(str).To(Equal(mstr))
 		})
 
 		It("accepts unidirectional streams", func() {
 			mstr := NewMockReceiveStreamI(mockCtrl)
-			streamManager.EXPECT().AcceptUniStream().Return(mstr, nil)
-			str, err := sess.AcceptUniStream()
+			streamManager.EXPECT().AcceptUniStream(gomock.Any()).Return(mstr, nil)
+			str, err := sess.AcceptUniStream(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			Expect(str).To(Equal(mstr))
 		})
@@ -1394,7 +3015,7 @@ var _ = Describe("Client Session", func() {
 			populateClientConfig(&Config{}, true),
 			nil, // tls.Config
 			42,  // initial packet number
-			nil, // transport parameters
+			&handshake.TransportParameters{},
 			protocol.VersionWhatever,
 			utils.DefaultLogger,
 			protocol.VersionWhatever,
@@ -1424,6 +3045,11 @@ var _ = Describe("Client Session", func() {
 		}()
 		newConnID := protocol.ConnectionID{1, 3, 3, 7, 1, 3, 3, 7}
 		packer.EXPECT().ChangeDestConnectionID(newConnID)
+		sess.spinBit.enabled = true
+		sess.spinBit.value = true
+		// make sure the spin bit isn't updated by the packet
+		sess.spinBit.receivedPacket = true
+		sess.spinBit.largestRcvdPacketNumber = 1000
 		Expect(sess.handlePacketImpl(insertPacketBuffer(&receivedPacket{
 			hdr: &wire.Header{
 				IsLongHeader:     true,
@@ -1434,6 +3060,8 @@ var _ = Describe("Client Session", func() {
 			},
 			data: []byte{0},
 		}))).To(BeTrue())
+		// the spin bit is reset when the connection ID changes
+		Expect(sess.spinBit.Value()).To(BeFalse())
 		// make sure the go routine returns
 		packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
 		sessionRunner.EXPECT().retireConnectionID(gomock.Any())
@@ -1441,4 +3069,278 @@ var _ = Describe("Client Session", func() {
 		Expect(sess.Close()).To(Succeed())
 		Eventually(sess.Context().Done()).Should(BeClosed())
 	})
+
+	It("registers the stateless reset token of the server", func() {
+		packer.EXPECT().HandleTransportParameters(gomock.Any())
+		token := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		sessionRunner.EXPECT().addResetToken(token)
+		sess.processTransportParameters(&handshake.TransportParameters{StatelessResetToken: token[:]})
+	})
+
+	It("passes tokens received in NEW_TOKEN frames to the session runner", func() {
+		sessionRunner.EXPECT().addToken([]byte("foobar"))
+		Expect(sess.handleFrame(&wire.NewTokenFrame{Token: []byte("foobar")}, 0, protocol.Encryption1RTT, nil)).To(Succeed())
+	})
+
+	It("returns a StatelessResetError when it receives a stateless reset", func() {
+		token := [16]byte{0xde, 0xca, 0xfb, 0xad}
+		clientHelloWritten := make(chan struct{})
+		close(clientHelloWritten)
+		sess.clientHelloWritten = clientHelloWritten
+		ctx := sess.Context()
+		cryptoSetup.EXPECT().RunHandshake().Do(func() { <-ctx.Done() }).AnyTimes()
+		cryptoSetup.EXPECT().Close()
+		packer.EXPECT().PackPacket().AnyTimes()
+		errChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			errChan <- sess.run()
+		}()
+		acceptErrChan := make(chan error, 1)
+		go func() {
+			_, err := sess.AcceptStream(context.Background())
+			acceptErrChan <- err
+		}()
+		sessionRunner.EXPECT().removeConnectionID(gomock.Any())
+		sess.destroy(&StatelessResetError{Token: token})
+		expectedErr := &ConnectionError{Remote: true, err: &StatelessResetError{Token: token}}
+		Eventually(errChan).Should(Receive(Equal(expectedErr)))
+		Expect(context.Cause(ctx)).To(Equal(expectedErr))
+		var err error
+		Eventually(acceptErrChan).Should(Receive(&err))
+		Expect(err).To(Equal(expectedErr))
+		var resetErr *StatelessResetError
+		Expect(errors.As(err, &resetErr)).To(BeTrue())
+		Expect(resetErr.Token).To(Equal(token))
+	})
+
+	Context("migrating", func() {
+		var (
+			sph      *mockackhandler.MockSentPacketHandler
+			unpacker *MockUnpacker
+			newConn  *mockConnection
+			pconn    net.PacketConn
+			pn       protocol.PacketNumber
+		)
+
+		BeforeEach(func() {
+			sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().GetAlarmTimeout().AnyTimes()
+			sph.EXPECT().GetStats().AnyTimes()
+			sph.EXPECT().TimeUntilSend().AnyTimes()
+			sph.EXPECT().SendMode().Return(ackhandler.SendAny).AnyTimes()
+			sph.EXPECT().ShouldSendNumPackets().Return(1000).AnyTimes()
+			sph.EXPECT().SetHandshakeComplete().AnyTimes()
+			sph.EXPECT().ReceivedBytes(gomock.Any()).AnyTimes()
+			sph.EXPECT().SentPacket(gomock.Any()).AnyTimes()
+			sess.sentPacketHandler = sph
+			unpacker = NewMockUnpacker(mockCtrl)
+			sess.unpacker = unpacker
+			packer.EXPECT().PackPacket().AnyTimes()
+			mconn.localAddr = &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 42}
+			newConn = newMockConnection()
+			newConn.localAddr = &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}
+			pconn = &net.UDPConn{}
+			sess.handshakeComplete = true
+			sess.peerParams = &handshake.TransportParameters{}
+		})
+
+		JustBeforeEach(func() {
+			clientHelloWritten := make(chan struct{})
+			close(clientHelloWritten)
+			sess.clientHelloWritten = clientHelloWritten
+			ctx := sess.Context()
+			cryptoSetup.EXPECT().RunHandshake().Do(func() { <-ctx.Done() }).AnyTimes()
+			go func() {
+				defer GinkgoRecover()
+				sess.run()
+			}()
+			packer.EXPECT().PackConnectionClose(gomock.Any()).Return(&packedPacket{}, nil)
+			sessionRunner.EXPECT().retireConnectionID(gomock.Any())
+			cryptoSetup.EXPECT().Close()
+		})
+
+		AfterEach(func() {
+			Expect(sess.Close()).To(Succeed())
+		})
+
+		expectPathChallenge := func() chan [8]byte {
+			challengeChan := make(chan [8]byte, 100)
+			// the PATH_CHALLENGE is retransmitted after a PTO
+			packer.EXPECT().PackPathChallenge(gomock.Any()).DoAndReturn(func(f *wire.PathChallengeFrame) (*packedPacket, error) {
+				challengeChan <- f.Data
+				buffer := getPacketBuffer()
+				return &packedPacket{
+					raw:    append(buffer.Slice[:0], []byte("challenge")...),
+					buffer: buffer,
+					header: &wire.ExtendedHeader{PacketNumber: 10},
+					frames: []wire.Frame{f},
+				}, nil
+			}).MinTimes(1)
+			return challengeChan
+		}
+
+		receivePathResponse := func(data [8]byte) {
+			buf := &bytes.Buffer{}
+			Expect((&wire.PathResponseFrame{Data: data}).Write(buf, sess.version)).To(Succeed())
+			pn++
+			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any()).Return(&unpackedPacket{
+				packetNumber:    pn,
+				hdr:             &wire.ExtendedHeader{PacketNumber: pn},
+				encryptionLevel: protocol.Encryption1RTT,
+				data:            buf.Bytes(),
+			}, nil)
+			sess.handlePacket(insertPacketBuffer(&receivedPacket{hdr: &wire.Header{}, rcvTime: time.Now()}))
+		}
+
+		It("switches to the new packet conn after validating the path", func() {
+			sessionRunner.EXPECT().addPacketConn(pconn).Return(newConn, nil)
+			challengeChan := expectPathChallenge()
+			errChan := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				errChan <- sess.Migrate(pconn)
+			}()
+			var challenge [8]byte
+			Eventually(challengeChan).Should(Receive(&challenge))
+			Eventually(newConn.written).Should(Receive(Equal([]byte("challenge"))))
+			Expect(mconn.written).ToNot(Receive())
+			// a PATH_RESPONSE with the wrong data is ignored
+			receivePathResponse([8]byte{1, 2, 3, 4, 5, 6, 7, 8})
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(sess.LocalAddr()).To(Equal(mconn.localAddr))
+			sph.EXPECT().OnConnectionMigration()
+			sessionRunner.EXPECT().switchPacketConn(newConn)
+			receivePathResponse(challenge)
+			Eventually(errChan).Should(Receive(BeNil()))
+			Expect(sess.LocalAddr()).To(Equal(newConn.localAddr))
+		})
+
+		It("retransmits the PATH_CHALLENGE", func() {
+			sess.rttStats.UpdateRTT(10*time.Millisecond, 0, time.Now())
+			sessionRunner.EXPECT().addPacketConn(pconn).Return(newConn, nil)
+			challengeChan := expectPathChallenge()
+			go func() {
+				defer GinkgoRecover()
+				sess.Migrate(pconn)
+			}()
+			var challenge1, challenge2 [8]byte
+			Eventually(challengeChan).Should(Receive(&challenge1))
+			Eventually(challengeChan).Should(Receive(&challenge2))
+			Expect(challenge2).To(Equal(challenge1))
+			Eventually(newConn.written).Should(Receive(Equal([]byte("challenge"))))
+			Eventually(newConn.written).Should(Receive(Equal([]byte("challenge"))))
+			sessionRunner.EXPECT().removePacketConn(newConn)
+		})
+
+		It("uses a new connection ID on the new path", func() {
+			newConnID := protocol.ConnectionID{0xde, 0xca, 0xfb, 0xad}
+			Expect(sess.connIDManager.Add(&wire.NewConnectionIDFrame{
+				SequenceNumber:      1,
+				ConnectionID:        newConnID,
+				StatelessResetToken: [16]byte{0x13, 0x37},
+			})).To(Succeed())
+			sessionRunner.EXPECT().addPacketConn(pconn).Return(newConn, nil)
+			sessionRunner.EXPECT().addResetToken([16]byte{0x13, 0x37})
+			packer.EXPECT().ChangeDestConnectionID(newConnID)
+			challengeChan := expectPathChallenge()
+			go func() {
+				defer GinkgoRecover()
+				sess.Migrate(pconn)
+			}()
+			Eventually(challengeChan).Should(Receive())
+			sessionRunner.EXPECT().removePacketConn(newConn)
+		})
+
+		It("aborts the migration when the session is closed", func() {
+			sessionRunner.EXPECT().addPacketConn(pconn).Return(newConn, nil)
+			challengeChan := expectPathChallenge()
+			errChan := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				errChan <- sess.Migrate(pconn)
+			}()
+			Eventually(challengeChan).Should(Receive())
+			sessionRunner.EXPECT().removePacketConn(newConn)
+			Expect(sess.Close()).To(Succeed())
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			var connErr *ConnectionError
+			Expect(errors.As(err, &connErr)).To(BeTrue())
+			Expect(connErr.ErrorCode).To(BeEquivalentTo(qerr.PeerGoingAway))
+		})
+
+		It("errors when the packet conn can't be added", func() {
+			testErr := errors.New("test error")
+			sessionRunner.EXPECT().addPacketConn(pconn).Return(nil, testErr)
+			Expect(sess.Migrate(pconn)).To(MatchError(testErr))
+		})
+
+		It("errors when connection migration is disabled", func() {
+			sess.config.DisableMigration = true
+			Expect(sess.Migrate(pconn)).To(MatchError("connection migration disabled"))
+		})
+
+		Context("the peer disabled connection migration", func() {
+			BeforeEach(func() {
+				sess.peerParams = &handshake.TransportParameters{DisableMigration: true}
+			})
+
+			It("errors", func() {
+				Expect(sess.Migrate(pconn)).To(MatchError("the peer disabled connection migration"))
+			})
+		})
+	})
+})
+
+type nopWriteCloser struct {
+	bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+var _ = Describe("Connection Tracer", func() {
+	It("doesn't create a tracer if neither a tracer nor a qlog writer is configured", func() {
+		Expect(newConnectionTracer(populateServerConfig(&Config{}), protocol.PerspectiveServer, protocol.ConnectionID{1, 2, 3, 4})).To(BeNil())
+	})
+
+	It("uses the configured tracer", func() {
+		tracer := logging.NewCountingTracer()
+		t := newConnectionTracer(populateServerConfig(&Config{Tracer: tracer}), protocol.PerspectiveServer, protocol.ConnectionID{1, 2, 3, 4})
+		Expect(t).ToNot(BeNil())
+		t.ClosedConnection(nil)
+		Expect(tracer.Counts().ConnectionsClosed).To(BeEquivalentTo(1))
+	})
+
+	It("writes a qlog", func() {
+		w := &nopWriteCloser{}
+		var perspective logging.Perspective
+		var connID []byte
+		conf := &Config{
+			GetLogWriter: func(p logging.Perspective, c []byte) io.WriteCloser {
+				perspective = p
+				connID = c
+				return w
+			},
+		}
+		t := newConnectionTracer(populateClientConfig(conf, true), protocol.PerspectiveClient, protocol.ConnectionID{1, 2, 3, 4})
+		Expect(perspective).To(Equal(logging.PerspectiveClient))
+		Expect(connID).To(Equal([]byte{1, 2, 3, 4}))
+		t.ClosedConnection(nil)
+		Expect(w.String()).To(ContainSubstring(`"qlog_format":"JSON-SEQ"`))
+		Expect(w.String()).To(ContainSubstring("connection_state_updated"))
+	})
+
+	It("uses both the tracer and the qlog writer", func() {
+		tracer := logging.NewCountingTracer()
+		w := &nopWriteCloser{}
+		conf := &Config{
+			Tracer:       tracer,
+			GetLogWriter: func(logging.Perspective, []byte) io.WriteCloser { return w },
+		}
+		t := newConnectionTracer(populateServerConfig(conf), protocol.PerspectiveServer, protocol.ConnectionID{1, 2, 3, 4})
+		t.ClosedConnection(nil)
+		Expect(tracer.Counts().ConnectionsClosed).To(BeEquivalentTo(1))
+		Expect(w.String()).To(ContainSubstring("connection_state_updated"))
+	})
 })
@@ -0,0 +1,68 @@
+package quic
+
+import (
+	"crypto/rand"
+
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+)
+
+// The spinBit implements the latency spin bit, allowing on-path observers to measure the RTT of a connection.
+// The server sets the spin bit to the value received in the packet with the highest packet number,
+// the client sends the inverted value.
+// The spin bit is only used in short header packets, i.e. in the application data packet number space.
+// Since the packet number space is shared by all key phases, the spin bit doesn't need to be reset on a key update.
+type spinBit struct {
+	perspective protocol.Perspective
+
+	enabled bool
+	value   bool
+
+	receivedPacket          bool
+	largestRcvdPacketNumber protocol.PacketNumber
+}
+
+func newSpinBit(pers protocol.Perspective, disable bool) *spinBit {
+	s := &spinBit{
+		perspective: pers,
+		enabled:     !disable && randomByte()%protocol.SpinBitDisableRatio != 0,
+	}
+	s.Reset()
+	return s
+}
+
+// ReceivedPacket is called for every 1-RTT packet that was received.
+func (s *spinBit) ReceivedPacket(pn protocol.PacketNumber, spin bool) {
+	if !s.enabled || (s.receivedPacket && pn <= s.largestRcvdPacketNumber) {
+		return
+	}
+	s.receivedPacket = true
+	s.largestRcvdPacketNumber = pn
+	if s.perspective == protocol.PerspectiveServer {
+		s.value = spin
+	} else {
+		s.value = !spin
+	}
+}
+
+// Value returns the value of the spin bit for the next packet sent.
+func (s *spinBit) Value() bool {
+	return s.value
+}
+
+// Reset is called on every change of the destination connection ID.
+// An observer must not be able to link the paths using the spin bit, so we start from zero.
+// If the spin bit is disabled, a random value is chosen for every connection ID.
+// The packet numbers continue on the new path, so reordered packets from the old path are still ignored.
+func (s *spinBit) Reset() {
+	if s.enabled {
+		s.value = false
+	} else {
+		s.value = randomByte()&1 > 0
+	}
+}
+
+func randomByte() uint8 {
+	b := make([]byte, 1)
+	rand.Read(b) // ignore the error here. It is not critical to have perfect random here.
+	return b[0]
+}
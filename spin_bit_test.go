@@ -0,0 +1,78 @@
+package quic
+
+import (
+	"github.com/lucas-clemente/quic-go/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Spin Bit", func() {
+	newEnabledSpinBit := func(pers protocol.Perspective) *spinBit {
+		s := newSpinBit(pers, false)
+		s.enabled = true
+		s.Reset()
+		return s
+	}
+
+	It("starts with the spin bit set to zero", func() {
+		Expect(newEnabledSpinBit(protocol.PerspectiveClient).Value()).To(BeFalse())
+		Expect(newEnabledSpinBit(protocol.PerspectiveServer).Value()).To(BeFalse())
+	})
+
+	It("reflects the spin bit on the server side", func() {
+		s := newEnabledSpinBit(protocol.PerspectiveServer)
+		s.ReceivedPacket(1, true)
+		Expect(s.Value()).To(BeTrue())
+		s.ReceivedPacket(2, false)
+		Expect(s.Value()).To(BeFalse())
+	})
+
+	It("inverts the spin bit on the client side", func() {
+		s := newEnabledSpinBit(protocol.PerspectiveClient)
+		s.ReceivedPacket(1, false)
+		Expect(s.Value()).To(BeTrue())
+		s.ReceivedPacket(2, true)
+		Expect(s.Value()).To(BeFalse())
+	})
+
+	It("only uses the packet with the highest packet number", func() {
+		s := newEnabledSpinBit(protocol.PerspectiveServer)
+		s.ReceivedPacket(10, true)
+		s.ReceivedPacket(9, false) // reordered
+		Expect(s.Value()).To(BeTrue())
+		s.ReceivedPacket(10, false) // duplicate
+		Expect(s.Value()).To(BeTrue())
+	})
+
+	It("resets the spin bit when the connection ID changes", func() {
+		s := newEnabledSpinBit(protocol.PerspectiveServer)
+		s.ReceivedPacket(10, true)
+		s.Reset()
+		Expect(s.Value()).To(BeFalse())
+		s.ReceivedPacket(9, true) // reordered packet from the old path
+		Expect(s.Value()).To(BeFalse())
+		s.ReceivedPacket(11, true)
+		Expect(s.Value()).To(BeTrue())
+	})
+
+	It("doesn't spin if disabled", func() {
+		s := newSpinBit(protocol.PerspectiveClient, true)
+		Expect(s.enabled).To(BeFalse())
+		val := s.Value()
+		for i := 1; i < 10; i++ {
+			s.ReceivedPacket(protocol.PacketNumber(i), i%2 == 0)
+			Expect(s.Value()).To(Equal(val))
+		}
+	})
+
+	It("disables the spin bit for a fraction of connections", func() {
+		var disabled int
+		for i := 0; i < 16*100; i++ {
+			if !newSpinBit(protocol.PerspectiveClient, false).enabled {
+				disabled++
+			}
+		}
+		Expect(disabled).To(And(BeNumerically(">", 50), BeNumerically("<", 150)))
+	})
+})
@@ -2,9 +2,11 @@ package quic
 
 import (
 	"net"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/flowcontrol"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/wire"
@@ -16,8 +18,13 @@ const errorCodeStopping protocol.ApplicationErrorCode = 0
 type streamSender interface {
 	queueControlFrame(wire.Frame)
 	onHasStreamData(protocol.StreamID)
+	// must be called without holding the mutex of the stream
+	onStreamPriorityChanged(protocol.StreamID)
 	// must be called without holding the mutex that is acquired by closeForShutdown
 	onStreamCompleted(protocol.StreamID)
+	// the addresses of the session, they change when the session migrates to a new path
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
 }
 
 // Each of the both stream halves gets its own uniStreamSender.
@@ -35,6 +42,10 @@ func (s *uniStreamSender) onHasStreamData(id protocol.StreamID) {
 	s.streamSender.onHasStreamData(id)
 }
 
+func (s *uniStreamSender) onStreamPriorityChanged(id protocol.StreamID) {
+	s.streamSender.onStreamPriorityChanged(id)
+}
+
 func (s *uniStreamSender) onStreamCompleted(protocol.StreamID) {
 	s.onStreamCompletedImpl()
 }
@@ -45,7 +56,8 @@ type streamI interface {
 	Stream
 	closeForShutdown(error)
 	// for receiving
-	handleStreamFrame(*wire.StreamFrame) error
+	checkOutOfOrderData(*wire.StreamFrame, protocol.ByteCount) (protocol.ByteCount, error)
+	handleStreamFrame(*wire.StreamFrame, *packetBuffer) error
 	handleResetStreamFrame(*wire.ResetStreamFrame) error
 	getWindowUpdate() protocol.ByteCount
 	// for sending
@@ -53,6 +65,10 @@ type streamI interface {
 	handleStopSendingFrame(*wire.StopSendingFrame)
 	popStreamFrame(maxBytes protocol.ByteCount) (*wire.StreamFrame, bool)
 	handleMaxStreamDataFrame(*wire.MaxStreamDataFrame)
+	getPriority() int
+	onStreamFrameAcked(*wire.StreamFrame)
+	onStreamFrameLost(*wire.StreamFrame)
+	stats() StreamStats
 }
 
 var _ receiveStreamI = (streamI)(nil)
@@ -74,22 +90,26 @@ type stream struct {
 }
 
 var _ Stream = &stream{}
+var _ net.Conn = &stream{}
 
 type deadlineError struct{}
 
 func (deadlineError) Error() string   { return "deadline exceeded" }
 func (deadlineError) Temporary() bool { return true }
 func (deadlineError) Timeout() bool   { return true }
+func (deadlineError) Unwrap() error   { return os.ErrDeadlineExceeded }
 
 var errDeadline net.Error = &deadlineError{}
 
 type streamCanceledError struct {
 	error
 	errorCode protocol.ApplicationErrorCode
+	remote    bool
 }
 
 func (streamCanceledError) Canceled() bool                             { return true }
 func (e streamCanceledError) ErrorCode() protocol.ApplicationErrorCode { return e.errorCode }
+func (e streamCanceledError) Remote() bool                             { return e.remote }
 
 var _ StreamError = &streamCanceledError{}
 
@@ -97,6 +117,9 @@ var _ StreamError = &streamCanceledError{}
 func newStream(streamID protocol.StreamID,
 	sender streamSender,
 	flowController flowcontrol.StreamFlowController,
+	limiter *outOfOrderDataLimiter,
+	maxSendBuffer protocol.ByteCount,
+	clock congestion.Clock,
 	version protocol.VersionNumber,
 ) *stream {
 	s := &stream{sender: sender, version: version}
@@ -109,7 +132,7 @@ func newStream(streamID protocol.StreamID,
 			s.completedMutex.Unlock()
 		},
 	}
-	s.sendStream = *newSendStream(streamID, senderForSendStream, flowController, version)
+	s.sendStream = *newSendStream(streamID, senderForSendStream, flowController, maxSendBuffer, clock, version)
 	senderForReceiveStream := &uniStreamSender{
 		streamSender: sender,
 		onStreamCompletedImpl: func() {
@@ -119,7 +142,8 @@ func newStream(streamID protocol.StreamID,
 			s.completedMutex.Unlock()
 		},
 	}
-	s.receiveStream = *newReceiveStream(streamID, senderForReceiveStream, flowController, version)
+	s.receiveStream = *newReceiveStream(streamID, senderForReceiveStream, flowController, limiter, version)
+	s.receiveStream.ctxCancel = s.sendStream.ctxCancel
 	return s
 }
 
@@ -136,12 +160,27 @@ func (s *stream) Close() error {
 	return nil
 }
 
+func (s *stream) LocalAddr() net.Addr {
+	return s.sender.LocalAddr()
+}
+
+func (s *stream) RemoteAddr() net.Addr {
+	return s.sender.RemoteAddr()
+}
+
 func (s *stream) SetDeadline(t time.Time) error {
 	_ = s.SetReadDeadline(t)  // SetReadDeadline never errors
 	_ = s.SetWriteDeadline(t) // SetWriteDeadline never errors
 	return nil
 }
 
+func (s *stream) Stats() StreamStats {
+	stats := s.sendStream.stats()
+	stats.BytesRead = uint64(s.receiveStream.bytesRead())
+	stats.BytesBuffered = uint64(s.receiveStream.bytesBuffered())
+	return stats
+}
+
 // CloseForShutdown closes a stream abruptly.
 // It makes Read and Write unblock (and return the error) immediately.
 // The peer will NOT be informed about this: the stream is closed without sending a FIN or RST.
@@ -158,6 +197,7 @@ func (s *stream) handleResetStreamFrame(frame *wire.ResetStreamFrame) error {
 // It makes sure that the onStreamCompleted callback is only called if both receive and send side have completed.
 func (s *stream) checkIfCompleted() {
 	if s.sendStreamCompleted && s.receiveStreamCompleted {
+		s.sendStream.ctxCancel(nil)
 		s.sender.onStreamCompleted(s.StreamID())
 	}
 }
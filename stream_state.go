@@ -0,0 +1,33 @@
+package quic
+
+func (s SendStreamState) String() string {
+	switch s {
+	case SendStreamOpen:
+		return "open"
+	case SendStreamDataQueued:
+		return "data queued"
+	case SendStreamFinSent:
+		return "FIN sent"
+	case SendStreamFinAcked:
+		return "FIN acknowledged"
+	case SendStreamResetSent:
+		return "reset sent"
+	default:
+		return "invalid send stream state"
+	}
+}
+
+func (s ReceiveStreamState) String() string {
+	switch s {
+	case ReceiveStreamOpen:
+		return "open"
+	case ReceiveStreamFinReceived:
+		return "FIN received"
+	case ReceiveStreamDataRead:
+		return "all data read"
+	case ReceiveStreamResetReceived:
+		return "reset received"
+	default:
+		return "invalid receive stream state"
+	}
+}
@@ -0,0 +1,25 @@
+package quic
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream State", func() {
+	It("has a string representation for the send state", func() {
+		Expect(SendStreamOpen.String()).To(Equal("open"))
+		Expect(SendStreamDataQueued.String()).To(Equal("data queued"))
+		Expect(SendStreamFinSent.String()).To(Equal("FIN sent"))
+		Expect(SendStreamFinAcked.String()).To(Equal("FIN acknowledged"))
+		Expect(SendStreamResetSent.String()).To(Equal("reset sent"))
+		Expect(SendStreamState(42).String()).To(Equal("invalid send stream state"))
+	})
+
+	It("has a string representation for the receive state", func() {
+		Expect(ReceiveStreamOpen.String()).To(Equal("open"))
+		Expect(ReceiveStreamFinReceived.String()).To(Equal("FIN received"))
+		Expect(ReceiveStreamDataRead.String()).To(Equal("all data read"))
+		Expect(ReceiveStreamResetReceived.String()).To(Equal("reset received"))
+		Expect(ReceiveStreamState(42).String()).To(Equal("invalid receive stream state"))
+	})
+})
@@ -1,11 +1,16 @@
 package quic
 
 import (
+	"context"
+	"errors"
 	"io"
+	"net"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/golang/mock/gomock"
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/mocks"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/wire"
@@ -39,7 +44,7 @@ var _ = Describe("Stream", func() {
 	BeforeEach(func() {
 		mockSender = NewMockStreamSender(mockCtrl)
 		mockFC = mocks.NewMockStreamFlowController(mockCtrl)
-		str = newStream(streamID, mockSender, mockFC, protocol.VersionWhatever)
+		str = newStream(streamID, mockSender, mockFC, newOutOfOrderDataLimiter(protocol.MaxByteCount, protocol.MaxByteCount), 0, congestion.DefaultClock{}, protocol.VersionWhatever)
 
 		timeout := scaleDuration(250 * time.Millisecond)
 		strWithTimeout = struct {
@@ -55,6 +60,21 @@ var _ = Describe("Stream", func() {
 		Expect(str.StreamID()).To(Equal(protocol.StreamID(1337)))
 	})
 
+	It("gets the addresses of the session", func() {
+		localAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337}
+		remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 4242}
+		migratedAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 2), Port: 4242}
+		mockSender.EXPECT().LocalAddr().Return(localAddr)
+		gomock.InOrder(
+			mockSender.EXPECT().RemoteAddr().Return(remoteAddr),
+			mockSender.EXPECT().RemoteAddr().Return(migratedAddr),
+		)
+		Expect(str.LocalAddr()).To(Equal(localAddr))
+		Expect(str.RemoteAddr()).To(Equal(remoteAddr))
+		// the address is not cached, so it changes when the session migrates
+		Expect(str.RemoteAddr()).To(Equal(migratedAddr))
+	})
+
 	Context("deadlines", func() {
 		It("sets a write deadline, when SetDeadline is called", func() {
 			str.SetDeadline(time.Now().Add(-time.Second))
@@ -66,7 +86,7 @@ var _ = Describe("Stream", func() {
 		It("sets a read deadline, when SetDeadline is called", func() {
 			mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), false).AnyTimes()
 			f := &wire.StreamFrame{Data: []byte("foobar")}
-			err := str.handleStreamFrame(f)
+			err := str.handleStreamFrame(f, nil)
 			Expect(err).ToNot(HaveOccurred())
 			str.SetDeadline(time.Now().Add(-time.Second))
 			b := make([]byte, 6)
@@ -76,6 +96,28 @@ var _ = Describe("Stream", func() {
 		})
 	})
 
+	It("gets statistics from both stream halves", func() {
+		mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(6), false)
+		mockFC.EXPECT().AddBytesRead(protocol.ByteCount(6))
+		Expect(str.handleStreamFrame(&wire.StreamFrame{Data: []byte("foobar")}, nil)).To(Succeed())
+		_, err := strWithTimeout.Read(make([]byte, 6))
+		Expect(err).ToNot(HaveOccurred())
+		mockSender.EXPECT().onHasStreamData(streamID)
+		str.onStreamFrameAcked(&wire.StreamFrame{Data: []byte("foo")})
+		str.onStreamFrameLost(&wire.StreamFrame{Data: []byte("ba")})
+		mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(16), false)
+		Expect(str.handleStreamFrame(&wire.StreamFrame{Offset: 10, Data: []byte("raboof")}, nil)).To(Succeed())
+		stats := str.Stats()
+		stats.TimeApplicationLimited, stats.TimeCongestionLimited = 0, 0
+		Expect(stats).To(Equal(StreamStats{
+			BytesAcked:         3,
+			BytesRetransmitted: 2,
+			BytesRead:          6,
+			BytesBuffered:      6,
+			SendState:          SendStateCongestionLimited, // the lost data wasn't retransmitted yet
+		}))
+	})
+
 	Context("completing", func() {
 		It("is not completed when only the receive side is completed", func() {
 			// don't EXPECT a call to mockSender.onStreamCompleted()
@@ -92,5 +134,33 @@ var _ = Describe("Stream", func() {
 			str.sendStream.sender.onStreamCompleted(streamID)
 			str.receiveStream.sender.onStreamCompleted(streamID)
 		})
+
+		It("cancels the context when both sides are completed", func() {
+			mockSender.EXPECT().onStreamCompleted(streamID)
+			str.sendStream.sender.onStreamCompleted(streamID)
+			Expect(str.Context().Done()).ToNot(BeClosed())
+			str.receiveStream.sender.onStreamCompleted(streamID)
+			Expect(str.Context().Done()).To(BeClosed())
+		})
+	})
+
+	Context("the context", func() {
+		It("is canceled when the peer resets the stream", func() {
+			mockFC.EXPECT().UpdateHighestReceived(protocol.ByteCount(42), true)
+			mockFC.EXPECT().Abandon()
+			Expect(str.Context().Done()).ToNot(BeClosed())
+			Expect(str.handleResetStreamFrame(&wire.ResetStreamFrame{
+				StreamID:   streamID,
+				ByteOffset: 42,
+				ErrorCode:  1234,
+			})).To(Succeed())
+			Expect(str.Context().Done()).To(BeClosed())
+			cause := context.Cause(str.Context())
+			Expect(cause).To(MatchError("Stream 1337 was reset with error code 1234"))
+			var streamErr StreamError
+			Expect(errors.As(cause, &streamErr)).To(BeTrue())
+			Expect(streamErr.ErrorCode()).To(BeEquivalentTo(1234))
+			Expect(streamErr.Remote()).To(BeTrue())
+		})
 	})
 })
@@ -1,13 +1,15 @@
 package quic
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net"
 
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/flowcontrol"
 	"github.com/lucas-clemente/quic-go/internal/handshake"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 )
 
@@ -15,11 +17,9 @@ type streamOpenErr struct{ error }
 
 var _ net.Error = &streamOpenErr{}
 
-func (e streamOpenErr) Temporary() bool { return e.error == errTooManyOpenStreams }
+func (e streamOpenErr) Temporary() bool { return e.error == ErrTooManyOpenStreams }
 func (streamOpenErr) Timeout() bool     { return false }
-
-// errTooManyOpenStreams is used internally by the outgoing streams maps.
-var errTooManyOpenStreams = errors.New("too many open streams")
+func (e streamOpenErr) Unwrap() error   { return e.error }
 
 type streamsMap struct {
 	perspective protocol.Perspective
@@ -38,6 +38,9 @@ var _ streamManager = &streamsMap{}
 func newStreamsMap(
 	sender streamSender,
 	newFlowController func(protocol.StreamID) flowcontrol.StreamFlowController,
+	outOfOrderDataLimiter *outOfOrderDataLimiter,
+	maxSendBuffer protocol.ByteCount,
+	clock congestion.Clock,
 	maxIncomingStreams uint64,
 	maxIncomingUniStreams uint64,
 	perspective protocol.Perspective,
@@ -49,13 +52,13 @@ func newStreamsMap(
 		sender:            sender,
 	}
 	newBidiStream := func(id protocol.StreamID) streamI {
-		return newStream(id, m.sender, m.newFlowController(id), version)
+		return newStream(id, m.sender, m.newFlowController(id), outOfOrderDataLimiter, maxSendBuffer, clock, version)
 	}
 	newUniSendStream := func(id protocol.StreamID) sendStreamI {
-		return newSendStream(id, m.sender, m.newFlowController(id), version)
+		return newSendStream(id, m.sender, m.newFlowController(id), maxSendBuffer, clock, version)
 	}
 	newUniReceiveStream := func(id protocol.StreamID) receiveStreamI {
-		return newReceiveStream(id, m.sender, m.newFlowController(id), version)
+		return newReceiveStream(id, m.sender, m.newFlowController(id), outOfOrderDataLimiter, version)
 	}
 	m.outgoingBidiStreams = newOutgoingBidiStreamsMap(
 		protocol.FirstStream(protocol.StreamTypeBidi, perspective),
@@ -88,24 +91,24 @@ func (m *streamsMap) OpenStream() (Stream, error) {
 	return m.outgoingBidiStreams.OpenStream()
 }
 
-func (m *streamsMap) OpenStreamSync() (Stream, error) {
-	return m.outgoingBidiStreams.OpenStreamSync()
+func (m *streamsMap) OpenStreamSync(ctx context.Context) (Stream, error) {
+	return m.outgoingBidiStreams.OpenStreamSync(ctx)
 }
 
 func (m *streamsMap) OpenUniStream() (SendStream, error) {
 	return m.outgoingUniStreams.OpenStream()
 }
 
-func (m *streamsMap) OpenUniStreamSync() (SendStream, error) {
-	return m.outgoingUniStreams.OpenStreamSync()
+func (m *streamsMap) OpenUniStreamSync(ctx context.Context) (SendStream, error) {
+	return m.outgoingUniStreams.OpenStreamSync(ctx)
 }
 
-func (m *streamsMap) AcceptStream() (Stream, error) {
-	return m.incomingBidiStreams.AcceptStream()
+func (m *streamsMap) AcceptStream(ctx context.Context) (Stream, error) {
+	return m.incomingBidiStreams.AcceptStream(ctx)
 }
 
-func (m *streamsMap) AcceptUniStream() (ReceiveStream, error) {
-	return m.incomingUniStreams.AcceptStream()
+func (m *streamsMap) AcceptUniStream(ctx context.Context) (ReceiveStream, error) {
+	return m.incomingUniStreams.AcceptStream(ctx)
 }
 
 func (m *streamsMap) DeleteStream(id protocol.StreamID) error {
@@ -129,7 +132,7 @@ func (m *streamsMap) GetOrOpenReceiveStream(id protocol.StreamID) (receiveStream
 	case protocol.StreamTypeUni:
 		if id.InitiatedBy() == m.perspective {
 			// an outgoing unidirectional stream is a send stream, not a receive stream
-			return nil, fmt.Errorf("peer attempted to open receive stream %d", id)
+			return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("peer attempted to open receive stream %d", id))
 		}
 		return m.incomingUniStreams.GetOrOpenStream(id)
 	case protocol.StreamTypeBidi:
@@ -148,7 +151,7 @@ func (m *streamsMap) GetOrOpenSendStream(id protocol.StreamID) (sendStreamI, err
 			return m.outgoingUniStreams.GetStream(id)
 		}
 		// an incoming unidirectional stream is a receive stream, not a send stream
-		return nil, fmt.Errorf("peer attempted to open send stream %d", id)
+		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("peer attempted to open send stream %d", id))
 	case protocol.StreamTypeBidi:
 		if id.InitiatedBy() == m.perspective {
 			return m.outgoingBidiStreams.GetStream(id)
@@ -158,13 +161,26 @@ func (m *streamsMap) GetOrOpenSendStream(id protocol.StreamID) (sendStreamI, err
 	panic("")
 }
 
+func (m *streamsMap) OnStreamFrameAcked(f *wire.StreamFrame) {
+	// The stream might already have been completed.
+	if str, err := m.GetOrOpenSendStream(f.StreamID); err == nil && str != nil {
+		str.onStreamFrameAcked(f)
+	}
+}
+
+func (m *streamsMap) OnStreamFrameLost(f *wire.StreamFrame) {
+	// The stream might already have been canceled.
+	if str, err := m.GetOrOpenSendStream(f.StreamID); err == nil && str != nil {
+		str.onStreamFrameLost(f)
+	}
+}
+
 func (m *streamsMap) HandleMaxStreamsFrame(f *wire.MaxStreamsFrame) error {
 	id := protocol.MaxStreamID(f.Type, f.MaxStreams, m.perspective)
 	switch id.Type() {
 	case protocol.StreamTypeUni:
 		m.outgoingUniStreams.SetMaxStream(id)
 	case protocol.StreamTypeBidi:
-		fmt.Printf("")
 		m.outgoingBidiStreams.SetMaxStream(id)
 	}
 	return nil
@@ -176,6 +192,15 @@ func (m *streamsMap) UpdateLimits(p *handshake.TransportParameters) {
 	m.outgoingUniStreams.SetMaxStream(protocol.MaxStreamID(protocol.StreamTypeUni, p.MaxUniStreams, m.perspective))
 }
 
+// HasUnsentData says if any of the send streams has data that was written, but not yet sent.
+func (m *streamsMap) HasUnsentData() bool {
+	var hasData bool
+	m.outgoingBidiStreams.Iterate(func(str streamI) { hasData = hasData || str.hasData() })
+	m.outgoingUniStreams.Iterate(func(str sendStreamI) { hasData = hasData || str.hasData() })
+	m.incomingBidiStreams.Iterate(func(str streamI) { hasData = hasData || str.hasData() })
+	return hasData
+}
+
 func (m *streamsMap) CloseWithError(err error) {
 	m.outgoingBidiStreams.CloseWithError(err)
 	m.outgoingUniStreams.CloseWithError(err)
@@ -15,3 +15,12 @@ type item interface {
 }
 
 const streamTypeGeneric protocol.StreamType = protocol.StreamTypeUni
+
+// The streams maps shard their streams by stream ID.
+// This way, looking up a stream (which happens for every STREAM frame received)
+// doesn't contend with opening and deleting other streams.
+const numStreamsMapShards = 16
+
+func streamsMapShard(id protocol.StreamID) uint64 {
+	return id.StreamNum() % numStreamsMapShards
+}
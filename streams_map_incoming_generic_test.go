@@ -1,11 +1,13 @@
 package quic
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
 	"github.com/golang/mock/gomock"
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 
 	. "github.com/onsi/ginkgo"
@@ -67,10 +69,10 @@ var _ = Describe("Streams Map (incoming)", func() {
 	It("accepts streams in the right order", func() {
 		_, err := m.GetOrOpenStream(firstNewStream + 4) // open stream 20 and 24
 		Expect(err).ToNot(HaveOccurred())
-		str, err := m.AcceptStream()
+		str, err := m.AcceptStream(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream))
-		str, err = m.AcceptStream()
+		str, err = m.AcceptStream(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream + 4))
 	})
@@ -83,14 +85,14 @@ var _ = Describe("Streams Map (incoming)", func() {
 
 	It("errors when trying to get a stream ID higher than the maximum", func() {
 		_, err := m.GetOrOpenStream(initialMaxStream + 4)
-		Expect(err).To(MatchError(fmt.Errorf("peer tried to open stream %d (current limit: %d)", initialMaxStream+4, initialMaxStream)))
+		Expect(err).To(MatchError(qerr.Error(qerr.TooManyOpenStreams, fmt.Sprintf("peer tried to open stream %d (current limit: %d)", initialMaxStream+4, initialMaxStream))))
 	})
 
 	It("blocks AcceptStream until a new stream is available", func() {
 		strChan := make(chan item)
 		go func() {
 			defer GinkgoRecover()
-			str, err := m.AcceptStream()
+			str, err := m.AcceptStream(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			strChan <- str
 		}()
@@ -108,7 +110,7 @@ var _ = Describe("Streams Map (incoming)", func() {
 		strChan := make(chan item)
 		go func() {
 			defer GinkgoRecover()
-			str, err := m.AcceptStream()
+			str, err := m.AcceptStream(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 			strChan <- str
 		}()
@@ -126,7 +128,7 @@ var _ = Describe("Streams Map (incoming)", func() {
 		done := make(chan struct{})
 		go func() {
 			defer GinkgoRecover()
-			_, err := m.AcceptStream()
+			_, err := m.AcceptStream(context.Background())
 			Expect(err).To(MatchError(testErr))
 			close(done)
 		}()
@@ -135,10 +137,36 @@ var _ = Describe("Streams Map (incoming)", func() {
 		Eventually(done).Should(BeClosed())
 	})
 
+	It("unblocks AcceptStream when the context is canceled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := m.AcceptStream(ctx)
+			Expect(err).To(MatchError(context.Canceled))
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("accepts a stream after a previous call to AcceptStream was canceled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := m.AcceptStream(ctx)
+		Expect(err).To(MatchError(context.Canceled))
+		_, err = m.GetOrOpenStream(firstNewStream)
+		Expect(err).ToNot(HaveOccurred())
+		str, err := m.AcceptStream(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream))
+	})
+
 	It("errors AcceptStream immediately if it is closed", func() {
 		testErr := errors.New("test error")
 		m.CloseWithError(testErr)
-		_, err := m.AcceptStream()
+		_, err := m.AcceptStream(context.Background())
 		Expect(err).To(MatchError(testErr))
 	})
 
@@ -159,7 +187,7 @@ var _ = Describe("Streams Map (incoming)", func() {
 		mockSender.EXPECT().queueControlFrame(gomock.Any())
 		_, err := m.GetOrOpenStream(firstNewStream)
 		Expect(err).ToNot(HaveOccurred())
-		str, err := m.AcceptStream()
+		str, err := m.AcceptStream(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream))
 		Expect(m.DeleteStream(firstNewStream)).To(Succeed())
@@ -172,12 +200,12 @@ var _ = Describe("Streams Map (incoming)", func() {
 		_, err := m.GetOrOpenStream(firstNewStream + 4)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(m.DeleteStream(firstNewStream + 4)).To(Succeed())
-		str, err := m.AcceptStream()
+		str, err := m.AcceptStream(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream))
 		// when accepting this stream, it will get deleted, and a MAX_STREAMS frame is queued
 		mockSender.EXPECT().queueControlFrame(gomock.Any())
-		str, err = m.AcceptStream()
+		str, err = m.AcceptStream(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream + 4))
 	})
@@ -192,7 +220,7 @@ var _ = Describe("Streams Map (incoming)", func() {
 		Expect(str).To(BeNil())
 		// when accepting this stream, it will get deleted, and a MAX_STREAMS frame is queued
 		mockSender.EXPECT().queueControlFrame(gomock.Any())
-		str, err = m.AcceptStream()
+		str, err = m.AcceptStream(context.Background())
 		Expect(err).ToNot(HaveOccurred())
 		Expect(str).ToNot(BeNil())
 	})
@@ -208,7 +236,7 @@ var _ = Describe("Streams Map (incoming)", func() {
 		Expect(err).ToNot(HaveOccurred())
 		// accept all streams
 		for i := 0; i < 5; i++ {
-			_, err := m.AcceptStream()
+			_, err := m.AcceptStream(context.Background())
 			Expect(err).ToNot(HaveOccurred())
 		}
 		mockSender.EXPECT().queueControlFrame(gomock.Any()).Do(func(f wire.Frame) {
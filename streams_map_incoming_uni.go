@@ -5,10 +5,13 @@
 package quic
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lucas-clemente/quic-go/internal/protocol"
+	"github.com/lucas-clemente/quic-go/internal/qerr"
 	"github.com/lucas-clemente/quic-go/internal/wire"
 )
 
@@ -16,13 +19,13 @@ type incomingUniStreamsMap struct {
 	mutex sync.RWMutex
 	cond  sync.Cond
 
-	streams map[protocol.StreamID]receiveStreamI
-	// When a stream is deleted before it was accepted, we can't delete it immediately.
-	// We need to wait until the application accepts it, and delete it immediately then.
-	streamsToDelete map[protocol.StreamID]struct{} // used as a set
+	// The streams are sharded by stream ID.
+	// Looking up a stream that was already opened only acquires the lock of a single shard.
+	shards     [numStreamsMapShards]incomingUniStreamsMapShard
+	numStreams uint64 // number of streams in all shards
 
 	nextStreamToAccept protocol.StreamID // the next stream that will be returned by AcceptStream()
-	nextStreamToOpen   protocol.StreamID // the highest stream that the peer openend
+	nextStreamToOpen   protocol.StreamID // the highest stream that the peer openend. Accessed atomically.
 	maxStream          protocol.StreamID // the highest stream that the peer is allowed to open
 	maxNumStreams      uint64            // maximum number of streams
 
@@ -40,8 +43,6 @@ func newIncomingUniStreamsMap(
 	newStream func(protocol.StreamID) receiveStreamI,
 ) *incomingUniStreamsMap {
 	m := &incomingUniStreamsMap{
-		streams:            make(map[protocol.StreamID]receiveStreamI),
-		streamsToDelete:    make(map[protocol.StreamID]struct{}),
 		nextStreamToAccept: nextStreamToAccept,
 		nextStreamToOpen:   nextStreamToAccept,
 		maxStream:          initialMaxStreamID,
@@ -49,23 +50,56 @@ func newIncomingUniStreamsMap(
 		newStream:          newStream,
 		queueMaxStreamID:   func(f *wire.MaxStreamsFrame) { queueControlFrame(f) },
 	}
+	for i := range m.shards {
+		m.shards[i].streams = make(map[protocol.StreamID]incomingUniStreamsMapEntry)
+	}
 	m.cond.L = &m.mutex
 	return m
 }
 
-func (m *incomingUniStreamsMap) AcceptStream() (receiveStreamI, error) {
+type incomingUniStreamsMapShard struct {
+	mutex   sync.RWMutex
+	streams map[protocol.StreamID]incomingUniStreamsMapEntry
+}
+
+type incomingUniStreamsMapEntry struct {
+	stream receiveStreamI
+	// When a stream is deleted before it was accepted, we can't delete it immediately.
+	// We need to wait until the application accepts it, and delete it immediately then.
+	shouldDelete bool
+}
+
+func (m *incomingUniStreamsMap) shard(id protocol.StreamID) *incomingUniStreamsMapShard {
+	return &m.shards[streamsMapShard(id)]
+}
+
+func (m *incomingUniStreamsMap) AcceptStream(ctx context.Context) (receiveStreamI, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	// wake up the call to cond.Wait when the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		m.mutex.Lock()
+		m.cond.Broadcast()
+		m.mutex.Unlock()
+	})
+	defer stop()
+
 	var id protocol.StreamID
-	var str receiveStreamI
+	var entry incomingUniStreamsMapEntry
 	for {
 		id = m.nextStreamToAccept
 		var ok bool
 		if m.closeErr != nil {
 			return nil, m.closeErr
 		}
-		str, ok = m.streams[id]
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		shard := m.shard(id)
+		shard.mutex.RLock()
+		entry, ok = shard.streams[id]
+		shard.mutex.RUnlock()
 		if ok {
 			break
 		}
@@ -73,47 +107,58 @@ func (m *incomingUniStreamsMap) AcceptStream() (receiveStreamI, error) {
 	}
 	m.nextStreamToAccept += 4
 	// If this stream was completed before being accepted, we can delete it now.
-	if _, ok := m.streamsToDelete[id]; ok {
-		delete(m.streamsToDelete, id)
+	if entry.shouldDelete {
 		if err := m.deleteStream(id); err != nil {
 			return nil, err
 		}
 	}
-	return str, nil
+	return entry.stream, nil
 }
 
 func (m *incomingUniStreamsMap) GetOrOpenStream(id protocol.StreamID) (receiveStreamI, error) {
-	m.mutex.RLock()
-	if id > m.maxStream {
-		m.mutex.RUnlock()
-		return nil, fmt.Errorf("peer tried to open stream %d (current limit: %d)", id, m.maxStream)
-	}
-	// if the id is smaller than the highest we accepted
+	// If the id is smaller than the highest we accepted
 	// * this stream exists in the map, and we can return it, or
-	// * this stream was already closed, then we can return the nil
-	if id < m.nextStreamToOpen {
-		var s receiveStreamI
-		// If the stream was already queued for deletion, and is just waiting to be accepted, don't return it.
-		if _, ok := m.streamsToDelete[id]; !ok {
-			s = m.streams[id]
-		}
-		m.mutex.RUnlock()
-		return s, nil
+	// * this stream was already closed, then we can return the nil.
+	// This is the common case, and doesn't require acquiring the mutex.
+	if id < protocol.StreamID(atomic.LoadUint64((*uint64)(&m.nextStreamToOpen))) {
+		return m.getStream(id), nil
 	}
-	m.mutex.RUnlock()
 
 	m.mutex.Lock()
-	// no need to check the two error conditions from above again
-	// * maxStream can only increase, so if the id was valid before, it definitely is valid now
-	// * highestStream is only modified by this function
+	defer m.mutex.Unlock()
+
+	if id > m.maxStream {
+		return nil, qerr.Error(qerr.TooManyOpenStreams, fmt.Sprintf("peer tried to open stream %d (current limit: %d)", id, m.maxStream))
+	}
+	// Another call to GetOrOpenStream might have opened this stream in the mean time.
+	if id < m.nextStreamToOpen {
+		return m.getStream(id), nil
+	}
 	for newID := m.nextStreamToOpen; newID <= id; newID += 4 {
-		m.streams[newID] = m.newStream(newID)
+		shard := m.shard(newID)
+		shard.mutex.Lock()
+		shard.streams[newID] = incomingUniStreamsMapEntry{stream: m.newStream(newID)}
+		shard.mutex.Unlock()
+		m.numStreams++
 		m.cond.Signal()
 	}
-	m.nextStreamToOpen = id + 4
-	s := m.streams[id]
-	m.mutex.Unlock()
-	return s, nil
+	// Only publish the new stream ID after the streams were inserted into their shards.
+	// Otherwise, the lookup above might consider the stream to be already deleted.
+	atomic.StoreUint64((*uint64)(&m.nextStreamToOpen), uint64(id+4))
+	return m.getStream(id), nil
+}
+
+func (m *incomingUniStreamsMap) getStream(id protocol.StreamID) receiveStreamI {
+	shard := m.shard(id)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	// If the stream was already queued for deletion, and is just waiting to be accepted, don't return it.
+	entry := shard.streams[id]
+	if entry.shouldDelete {
+		return nil
+	}
+	return entry.stream
 }
 
 func (m *incomingUniStreamsMap) DeleteStream(id protocol.StreamID) error {
@@ -124,24 +169,31 @@ func (m *incomingUniStreamsMap) DeleteStream(id protocol.StreamID) error {
 }
 
 func (m *incomingUniStreamsMap) deleteStream(id protocol.StreamID) error {
-	if _, ok := m.streams[id]; !ok {
+	shard := m.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	entry, ok := shard.streams[id]
+	if !ok {
 		return fmt.Errorf("Tried to delete unknown stream %d", id)
 	}
 
 	// Don't delete this stream yet, if it was not yet accepted.
-	// Just save it to streamsToDelete map, to make sure it is deleted as soon as it gets accepted.
+	// Just mark it, to make sure it is deleted as soon as it gets accepted.
 	if id >= m.nextStreamToAccept {
-		if _, ok := m.streamsToDelete[id]; ok {
+		if entry.shouldDelete {
 			return fmt.Errorf("Tried to delete stream %d multiple times", id)
 		}
-		m.streamsToDelete[id] = struct{}{}
+		entry.shouldDelete = true
+		shard.streams[id] = entry
 		return nil
 	}
 
-	delete(m.streams, id)
+	delete(shard.streams, id)
+	m.numStreams--
 	// queue a MAX_STREAM_ID frame, giving the peer the option to open a new stream
-	if m.maxNumStreams > uint64(len(m.streams)) {
-		numNewStreams := m.maxNumStreams - uint64(len(m.streams))
+	if m.maxNumStreams > m.numStreams {
+		numNewStreams := m.maxNumStreams - m.numStreams
 		m.maxStream = m.nextStreamToOpen + protocol.StreamID((numNewStreams-1)*4)
 		m.queueMaxStreamID(&wire.MaxStreamsFrame{
 			Type:       protocol.StreamTypeUni,
@@ -151,12 +203,22 @@ func (m *incomingUniStreamsMap) deleteStream(id protocol.StreamID) error {
 	return nil
 }
 
+// Iterate calls the callback for every open stream.
+func (m *incomingUniStreamsMap) Iterate(cb func(receiveStreamI)) {
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mutex.RLock()
+		for _, entry := range shard.streams {
+			cb(entry.stream)
+		}
+		shard.mutex.RUnlock()
+	}
+}
+
 func (m *incomingUniStreamsMap) CloseWithError(err error) {
 	m.mutex.Lock()
 	m.closeErr = err
-	for _, str := range m.streams {
-		str.closeForShutdown(err)
-	}
+	m.Iterate(func(str receiveStreamI) { str.closeForShutdown(err) })
 	m.mutex.Unlock()
 	m.cond.Broadcast()
 }
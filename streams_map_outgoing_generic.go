@@ -1,8 +1,10 @@
 package quic
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/qerr"
@@ -15,9 +17,11 @@ type outgoingItemsMap struct {
 	mutex sync.RWMutex
 	cond  sync.Cond
 
-	streams map[protocol.StreamID]item
+	// The streams are sharded by stream ID.
+	// GetStream only acquires the lock of a single shard, and never blocks on the mutex.
+	shards [numStreamsMapShards]outgoingItemsMapShard
 
-	nextStream   protocol.StreamID // stream ID of the stream returned by OpenStream(Sync)
+	nextStream   protocol.StreamID // stream ID of the stream returned by OpenStream(Sync). Accessed atomically.
 	maxStream    protocol.StreamID // the maximum stream ID we're allowed to open
 	maxStreamSet bool              // was maxStream set. If not, it's not possible to any stream (also works for stream 0)
 	blockedSent  bool              // was a STREAMS_BLOCKED sent for the current maxStream
@@ -34,15 +38,26 @@ func newOutgoingItemsMap(
 	queueControlFrame func(wire.Frame),
 ) *outgoingItemsMap {
 	m := &outgoingItemsMap{
-		streams:              make(map[protocol.StreamID]item),
 		nextStream:           nextStream,
 		newStream:            newStream,
 		queueStreamIDBlocked: func(f *wire.StreamsBlockedFrame) { queueControlFrame(f) },
 	}
+	for i := range m.shards {
+		m.shards[i].streams = make(map[protocol.StreamID]item)
+	}
 	m.cond.L = &m.mutex
 	return m
 }
 
+type outgoingItemsMapShard struct {
+	mutex   sync.RWMutex
+	streams map[protocol.StreamID]item
+}
+
+func (m *outgoingItemsMap) shard(id protocol.StreamID) *outgoingItemsMapShard {
+	return &m.shards[streamsMapShard(id)]
+}
+
 func (m *outgoingItemsMap) OpenStream() (item, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -54,16 +69,27 @@ func (m *outgoingItemsMap) OpenStream() (item, error) {
 	return str, nil
 }
 
-func (m *outgoingItemsMap) OpenStreamSync() (item, error) {
+func (m *outgoingItemsMap) OpenStreamSync(ctx context.Context) (item, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	// wake up the call to cond.Wait when the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		m.mutex.Lock()
+		m.cond.Broadcast()
+		m.mutex.Unlock()
+	})
+	defer stop()
+
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		str, err := m.openStreamImpl()
 		if err == nil {
 			return str, nil
 		}
-		if err != nil && err != errTooManyOpenStreams {
+		if err != nil && err != ErrTooManyOpenStreams {
 			return nil, streamOpenErr{err}
 		}
 		m.cond.Wait()
@@ -89,33 +115,40 @@ func (m *outgoingItemsMap) openStreamImpl() (item, error) {
 			}
 			m.blockedSent = true
 		}
-		return nil, errTooManyOpenStreams
+		return nil, ErrTooManyOpenStreams
 	}
-	s := m.newStream(m.nextStream)
-	m.streams[m.nextStream] = s
-	m.nextStream += 4
+	id := m.nextStream
+	s := m.newStream(id)
+	shard := m.shard(id)
+	shard.mutex.Lock()
+	shard.streams[id] = s
+	shard.mutex.Unlock()
+	// Only publish the new stream ID after the stream was inserted into its shard.
+	// Otherwise, GetStream might consider the stream to be already deleted.
+	atomic.StoreUint64((*uint64)(&m.nextStream), uint64(id+4))
 	return s, nil
 }
 
 func (m *outgoingItemsMap) GetStream(id protocol.StreamID) (item, error) {
-	m.mutex.RLock()
-	if id >= m.nextStream {
-		m.mutex.RUnlock()
+	if id >= protocol.StreamID(atomic.LoadUint64((*uint64)(&m.nextStream))) {
 		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("peer attempted to open stream %d", id))
 	}
-	s := m.streams[id]
-	m.mutex.RUnlock()
+	shard := m.shard(id)
+	shard.mutex.RLock()
+	s := shard.streams[id]
+	shard.mutex.RUnlock()
 	return s, nil
 }
 
 func (m *outgoingItemsMap) DeleteStream(id protocol.StreamID) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	shard := m.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	if _, ok := m.streams[id]; !ok {
+	if _, ok := shard.streams[id]; !ok {
 		return fmt.Errorf("Tried to delete unknown stream %d", id)
 	}
-	delete(m.streams, id)
+	delete(shard.streams, id)
 	return nil
 }
 
@@ -130,12 +163,22 @@ func (m *outgoingItemsMap) SetMaxStream(id protocol.StreamID) {
 	m.mutex.Unlock()
 }
 
+// Iterate calls the callback for every open stream.
+func (m *outgoingItemsMap) Iterate(cb func(item)) {
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mutex.RLock()
+		for _, str := range shard.streams {
+			cb(str)
+		}
+		shard.mutex.RUnlock()
+	}
+}
+
 func (m *outgoingItemsMap) CloseWithError(err error) {
 	m.mutex.Lock()
 	m.closeErr = err
-	for _, str := range m.streams {
-		str.closeForShutdown(err)
-	}
+	m.Iterate(func(str item) { str.closeForShutdown(err) })
 	m.cond.Broadcast()
 	m.mutex.Unlock()
 }
@@ -1,6 +1,7 @@
 package quic
 
 import (
+	"context"
 	"errors"
 	"net"
 
@@ -118,7 +119,7 @@ var _ = Describe("Streams Map (outgoing)", func() {
 			done := make(chan struct{})
 			go func() {
 				defer GinkgoRecover()
-				str, err := m.OpenStreamSync()
+				str, err := m.OpenStreamSync(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream))
 				close(done)
@@ -137,7 +138,7 @@ var _ = Describe("Streams Map (outgoing)", func() {
 			done := make(chan struct{})
 			go func() {
 				defer GinkgoRecover()
-				str, err := m.OpenStreamSync()
+				str, err := m.OpenStreamSync(context.Background())
 				Expect(err).ToNot(HaveOccurred())
 				Expect(str.(*mockGenericStream).id).To(BeZero())
 				close(done)
@@ -154,7 +155,7 @@ var _ = Describe("Streams Map (outgoing)", func() {
 			done := make(chan struct{})
 			go func() {
 				defer GinkgoRecover()
-				_, err := m.OpenStreamSync()
+				_, err := m.OpenStreamSync(context.Background())
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal(testErr.Error()))
 				close(done)
@@ -165,6 +166,27 @@ var _ = Describe("Streams Map (outgoing)", func() {
 			Eventually(done).Should(BeClosed())
 		})
 
+		It("stops opening synchronously when the context is canceled", func() {
+			mockSender.EXPECT().queueControlFrame(gomock.Any())
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := m.OpenStreamSync(ctx)
+				Expect(err).To(MatchError(context.Canceled))
+				close(done)
+			}()
+
+			Consistently(done).ShouldNot(BeClosed())
+			cancel()
+			Eventually(done).Should(BeClosed())
+			// the canceled call didn't consume a stream ID
+			m.SetMaxStream(firstNewStream)
+			str, err := m.OpenStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.(*mockGenericStream).id).To(Equal(firstNewStream))
+		})
+
 		It("doesn't reduce the stream limit", func() {
 			m.SetMaxStream(firstNewStream + 4)
 			m.SetMaxStream(firstNewStream)
@@ -188,7 +210,7 @@ var _ = Describe("Streams Map (outgoing)", func() {
 			})
 			_, err := m.OpenStream()
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal(errTooManyOpenStreams.Error()))
+			Expect(err.Error()).To(Equal(ErrTooManyOpenStreams.Error()))
 		})
 
 		It("only sends one STREAM_ID_BLOCKED frame for one stream ID", func() {
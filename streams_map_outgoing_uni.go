@@ -5,8 +5,10 @@
 package quic
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lucas-clemente/quic-go/internal/protocol"
 	"github.com/lucas-clemente/quic-go/internal/qerr"
@@ -17,9 +19,11 @@ type outgoingUniStreamsMap struct {
 	mutex sync.RWMutex
 	cond  sync.Cond
 
-	streams map[protocol.StreamID]sendStreamI
+	// The streams are sharded by stream ID.
+	// GetStream only acquires the lock of a single shard, and never blocks on the mutex.
+	shards [numStreamsMapShards]outgoingUniStreamsMapShard
 
-	nextStream   protocol.StreamID // stream ID of the stream returned by OpenStream(Sync)
+	nextStream   protocol.StreamID // stream ID of the stream returned by OpenStream(Sync). Accessed atomically.
 	maxStream    protocol.StreamID // the maximum stream ID we're allowed to open
 	maxStreamSet bool              // was maxStream set. If not, it's not possible to any stream (also works for stream 0)
 	blockedSent  bool              // was a STREAMS_BLOCKED sent for the current maxStream
@@ -36,15 +40,26 @@ func newOutgoingUniStreamsMap(
 	queueControlFrame func(wire.Frame),
 ) *outgoingUniStreamsMap {
 	m := &outgoingUniStreamsMap{
-		streams:              make(map[protocol.StreamID]sendStreamI),
 		nextStream:           nextStream,
 		newStream:            newStream,
 		queueStreamIDBlocked: func(f *wire.StreamsBlockedFrame) { queueControlFrame(f) },
 	}
+	for i := range m.shards {
+		m.shards[i].streams = make(map[protocol.StreamID]sendStreamI)
+	}
 	m.cond.L = &m.mutex
 	return m
 }
 
+type outgoingUniStreamsMapShard struct {
+	mutex   sync.RWMutex
+	streams map[protocol.StreamID]sendStreamI
+}
+
+func (m *outgoingUniStreamsMap) shard(id protocol.StreamID) *outgoingUniStreamsMapShard {
+	return &m.shards[streamsMapShard(id)]
+}
+
 func (m *outgoingUniStreamsMap) OpenStream() (sendStreamI, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -56,16 +71,27 @@ func (m *outgoingUniStreamsMap) OpenStream() (sendStreamI, error) {
 	return str, nil
 }
 
-func (m *outgoingUniStreamsMap) OpenStreamSync() (sendStreamI, error) {
+func (m *outgoingUniStreamsMap) OpenStreamSync(ctx context.Context) (sendStreamI, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	// wake up the call to cond.Wait when the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		m.mutex.Lock()
+		m.cond.Broadcast()
+		m.mutex.Unlock()
+	})
+	defer stop()
+
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		str, err := m.openStreamImpl()
 		if err == nil {
 			return str, nil
 		}
-		if err != nil && err != errTooManyOpenStreams {
+		if err != nil && err != ErrTooManyOpenStreams {
 			return nil, streamOpenErr{err}
 		}
 		m.cond.Wait()
@@ -91,33 +117,40 @@ func (m *outgoingUniStreamsMap) openStreamImpl() (sendStreamI, error) {
 			}
 			m.blockedSent = true
 		}
-		return nil, errTooManyOpenStreams
+		return nil, ErrTooManyOpenStreams
 	}
-	s := m.newStream(m.nextStream)
-	m.streams[m.nextStream] = s
-	m.nextStream += 4
+	id := m.nextStream
+	s := m.newStream(id)
+	shard := m.shard(id)
+	shard.mutex.Lock()
+	shard.streams[id] = s
+	shard.mutex.Unlock()
+	// Only publish the new stream ID after the stream was inserted into its shard.
+	// Otherwise, GetStream might consider the stream to be already deleted.
+	atomic.StoreUint64((*uint64)(&m.nextStream), uint64(id+4))
 	return s, nil
 }
 
 func (m *outgoingUniStreamsMap) GetStream(id protocol.StreamID) (sendStreamI, error) {
-	m.mutex.RLock()
-	if id >= m.nextStream {
-		m.mutex.RUnlock()
+	if id >= protocol.StreamID(atomic.LoadUint64((*uint64)(&m.nextStream))) {
 		return nil, qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("peer attempted to open stream %d", id))
 	}
-	s := m.streams[id]
-	m.mutex.RUnlock()
+	shard := m.shard(id)
+	shard.mutex.RLock()
+	s := shard.streams[id]
+	shard.mutex.RUnlock()
 	return s, nil
 }
 
 func (m *outgoingUniStreamsMap) DeleteStream(id protocol.StreamID) error {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	shard := m.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	if _, ok := m.streams[id]; !ok {
+	if _, ok := shard.streams[id]; !ok {
 		return fmt.Errorf("Tried to delete unknown stream %d", id)
 	}
-	delete(m.streams, id)
+	delete(shard.streams, id)
 	return nil
 }
 
@@ -132,12 +165,22 @@ func (m *outgoingUniStreamsMap) SetMaxStream(id protocol.StreamID) {
 	m.mutex.Unlock()
 }
 
+// Iterate calls the callback for every open stream.
+func (m *outgoingUniStreamsMap) Iterate(cb func(sendStreamI)) {
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mutex.RLock()
+		for _, str := range shard.streams {
+			cb(str)
+		}
+		shard.mutex.RUnlock()
+	}
+}
+
 func (m *outgoingUniStreamsMap) CloseWithError(err error) {
 	m.mutex.Lock()
 	m.closeErr = err
-	for _, str := range m.streams {
-		str.closeForShutdown(err)
-	}
+	m.Iterate(func(str sendStreamI) { str.closeForShutdown(err) })
 	m.cond.Broadcast()
 	m.mutex.Unlock()
 }
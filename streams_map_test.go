@@ -1,12 +1,15 @@
 package quic
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
 	"net"
+	"sync"
 
 	"github.com/golang/mock/gomock"
+	"github.com/lucas-clemente/quic-go/internal/congestion"
 	"github.com/lucas-clemente/quic-go/internal/flowcontrol"
 	"github.com/lucas-clemente/quic-go/internal/handshake"
 	"github.com/lucas-clemente/quic-go/internal/mocks"
@@ -27,7 +30,8 @@ type streamMapping struct {
 
 func expectTooManyStreamsError(err error) {
 	ExpectWithOffset(1, err).To(HaveOccurred())
-	ExpectWithOffset(1, err.Error()).To(Equal(errTooManyOpenStreams.Error()))
+	ExpectWithOffset(1, err.Error()).To(Equal(ErrTooManyOpenStreams.Error()))
+	ExpectWithOffset(1, errors.Is(err, ErrTooManyOpenStreams)).To(BeTrue())
 	nerr, ok := err.(net.Error)
 	ExpectWithOffset(1, ok).To(BeTrue())
 	ExpectWithOffset(1, nerr.Temporary()).To(BeTrue())
@@ -81,7 +85,7 @@ var _ = Describe("Streams Map", func() {
 
 			BeforeEach(func() {
 				mockSender = NewMockStreamSender(mockCtrl)
-				m = newStreamsMap(mockSender, newFlowController, maxBidiStreams, maxUniStreams, perspective, protocol.VersionWhatever).(*streamsMap)
+				m = newStreamsMap(mockSender, newFlowController, newOutOfOrderDataLimiter(protocol.MaxByteCount, protocol.MaxByteCount), 0, congestion.DefaultClock{}, maxBidiStreams, maxUniStreams, perspective, protocol.VersionWhatever).(*streamsMap)
 			})
 
 			Context("opening", func() {
@@ -114,7 +118,7 @@ var _ = Describe("Streams Map", func() {
 				It("accepts bidirectional streams", func() {
 					_, err := m.GetOrOpenReceiveStream(ids.firstIncomingBidiStream)
 					Expect(err).ToNot(HaveOccurred())
-					str, err := m.AcceptStream()
+					str, err := m.AcceptStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(str).To(BeAssignableToTypeOf(&stream{}))
 					Expect(str.StreamID()).To(Equal(ids.firstIncomingBidiStream))
@@ -123,7 +127,7 @@ var _ = Describe("Streams Map", func() {
 				It("accepts unidirectional streams", func() {
 					_, err := m.GetOrOpenReceiveStream(ids.firstIncomingUniStream)
 					Expect(err).ToNot(HaveOccurred())
-					str, err := m.AcceptUniStream()
+					str, err := m.AcceptUniStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(str).To(BeAssignableToTypeOf(&receiveStream{}))
 					Expect(str.StreamID()).To(Equal(ids.firstIncomingUniStream))
@@ -163,7 +167,7 @@ var _ = Describe("Streams Map", func() {
 					_, err := m.GetOrOpenReceiveStream(id)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(m.DeleteStream(id)).To(Succeed())
-					str, err := m.AcceptStream()
+					str, err := m.AcceptStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(str).ToNot(BeNil())
 					Expect(str.StreamID()).To(Equal(id))
@@ -196,13 +200,31 @@ var _ = Describe("Streams Map", func() {
 					_, err := m.GetOrOpenReceiveStream(id)
 					Expect(err).ToNot(HaveOccurred())
 					Expect(m.DeleteStream(id)).To(Succeed())
-					str, err := m.AcceptUniStream()
+					str, err := m.AcceptUniStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(str).ToNot(BeNil())
 					Expect(str.StreamID()).To(Equal(id))
 				})
 			})
 
+			Context("enforcing the incoming stream limits", func() {
+				It("errors when the peer opens too many bidirectional streams", func() {
+					lastAllowed := ids.firstIncomingBidiStream + 4*(maxBidiStreams-1)
+					_, err := m.GetOrOpenReceiveStream(lastAllowed)
+					Expect(err).ToNot(HaveOccurred())
+					_, err = m.GetOrOpenReceiveStream(lastAllowed + 4)
+					Expect(err).To(MatchError(qerr.Error(qerr.TooManyOpenStreams, fmt.Sprintf("peer tried to open stream %d (current limit: %d)", lastAllowed+4, lastAllowed))))
+				})
+
+				It("errors when the peer opens too many unidirectional streams", func() {
+					lastAllowed := ids.firstIncomingUniStream + 4*(maxUniStreams-1)
+					_, err := m.GetOrOpenReceiveStream(lastAllowed)
+					Expect(err).ToNot(HaveOccurred())
+					_, err = m.GetOrOpenReceiveStream(lastAllowed + 4)
+					Expect(err).To(MatchError(qerr.Error(qerr.TooManyOpenStreams, fmt.Sprintf("peer tried to open stream %d (current limit: %d)", lastAllowed+4, lastAllowed))))
+				})
+			})
+
 			Context("getting streams", func() {
 				BeforeEach(func() {
 					allowUnlimitedStreams()
@@ -251,7 +273,7 @@ var _ = Describe("Streams Map", func() {
 					It("errors when trying to get an incoming unidirectional stream", func() {
 						id := ids.firstIncomingUniStream
 						_, err := m.GetOrOpenSendStream(id)
-						Expect(err).To(MatchError(fmt.Errorf("peer attempted to open send stream %d", id)))
+						Expect(err).To(MatchError(qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("peer attempted to open send stream %d", id))))
 					})
 				})
 
@@ -289,7 +311,7 @@ var _ = Describe("Streams Map", func() {
 					It("errors when trying to get an outgoing unidirectional stream", func() {
 						id := ids.firstOutgoingUniStream
 						_, err := m.GetOrOpenReceiveStream(id)
-						Expect(err).To(MatchError(fmt.Errorf("peer attempted to open receive stream %d", id)))
+						Expect(err).To(MatchError(qerr.Error(qerr.InvalidStreamID, fmt.Sprintf("peer attempted to open receive stream %d", id))))
 					})
 				})
 			})
@@ -324,6 +346,56 @@ var _ = Describe("Streams Map", func() {
 				})
 			})
 
+			Context("reporting STREAM frames", func() {
+				BeforeEach(func() {
+					allowUnlimitedStreams()
+				})
+
+				It("reports acknowledged STREAM frames to the stream", func() {
+					str, err := m.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					m.OnStreamFrameAcked(&wire.StreamFrame{StreamID: str.StreamID(), Data: []byte("foobar")})
+					Expect(str.Stats().BytesAcked).To(BeEquivalentTo(6))
+				})
+
+				It("reports lost STREAM frames to the stream", func() {
+					str, err := m.OpenUniStream()
+					Expect(err).ToNot(HaveOccurred())
+					mockSender.EXPECT().onHasStreamData(str.StreamID())
+					m.OnStreamFrameLost(&wire.StreamFrame{StreamID: str.StreamID(), Data: []byte("foobar")})
+					Expect(str.(sendStreamI).stats().BytesRetransmitted).To(BeEquivalentTo(6))
+					Expect(str.(sendStreamI).hasData()).To(BeTrue())
+				})
+
+				It("ignores STREAM frames for deleted streams", func() {
+					str, err := m.OpenStream()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(m.DeleteStream(str.StreamID())).To(Succeed())
+					m.OnStreamFrameAcked(&wire.StreamFrame{StreamID: str.StreamID(), Data: []byte("foobar")})
+					m.OnStreamFrameLost(&wire.StreamFrame{StreamID: str.StreamID(), Data: []byte("foobar")})
+				})
+			})
+
+			It("says if a stream has unsent data", func() {
+				allowUnlimitedStreams()
+				_, err := m.OpenStream()
+				Expect(err).ToNot(HaveOccurred())
+				str, err := m.OpenUniStream()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(m.HasUnsentData()).To(BeFalse())
+				mockSender.EXPECT().onHasStreamData(str.StreamID())
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					str.Write([]byte("foobar"))
+					close(done)
+				}()
+				Eventually(m.HasUnsentData).Should(BeTrue())
+				// make the go routine return
+				m.CloseWithError(errors.New("shutdown"))
+				Eventually(done).Should(BeClosed())
+			})
+
 			Context("handling MAX_STREAMS frames", func() {
 				BeforeEach(func() {
 					mockSender.EXPECT().queueControlFrame(gomock.Any()).AnyTimes()
@@ -362,7 +434,7 @@ var _ = Describe("Streams Map", func() {
 				It("sends a MAX_STREAMS frame for bidirectional streams", func() {
 					_, err := m.GetOrOpenReceiveStream(ids.firstIncomingBidiStream)
 					Expect(err).ToNot(HaveOccurred())
-					_, err = m.AcceptStream()
+					_, err = m.AcceptStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					mockSender.EXPECT().queueControlFrame(&wire.MaxStreamsFrame{
 						Type:       protocol.StreamTypeBidi,
@@ -374,7 +446,7 @@ var _ = Describe("Streams Map", func() {
 				It("sends a MAX_STREAMS frame for unidirectional streams", func() {
 					_, err := m.GetOrOpenReceiveStream(ids.firstIncomingUniStream)
 					Expect(err).ToNot(HaveOccurred())
-					_, err = m.AcceptUniStream()
+					_, err = m.AcceptUniStream(context.Background())
 					Expect(err).ToNot(HaveOccurred())
 					mockSender.EXPECT().queueControlFrame(&wire.MaxStreamsFrame{
 						Type:       protocol.StreamTypeUni,
@@ -384,6 +456,98 @@ var _ = Describe("Streams Map", func() {
 				})
 			})
 
+			It("handles concurrent opening, accepting, deleting and getting of streams", func() {
+				const num = 100 // less than maxBidiStreams, so we don't need to wait for MAX_STREAMS frames
+				mockSender.EXPECT().queueControlFrame(gomock.Any()).AnyTimes()
+				allowUnlimitedStreams()
+
+				var wg sync.WaitGroup
+				done := make(chan struct{})
+				// open outgoing streams, and delete them right away
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for i := 0; i < num; i++ {
+						str, err := m.OpenStream()
+						Expect(err).ToNot(HaveOccurred())
+						Expect(m.DeleteStream(str.StreamID())).To(Succeed())
+					}
+				}()
+				// the peer opens the incoming streams, from two go routines
+				for j := 0; j < 2; j++ {
+					deleteStreams := j == 0
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						defer wg.Done()
+						for i := 0; i < num; i++ {
+							id := ids.firstIncomingBidiStream + protocol.StreamID(4*i)
+							str, err := m.GetOrOpenReceiveStream(id)
+							Expect(err).ToNot(HaveOccurred())
+							// Streams with an even stream number are completed before they are accepted.
+							// The stream might have been deleted by the other go routine in the mean time.
+							if deleteStreams && i%2 == 0 {
+								Expect(str).ToNot(BeNil())
+								Expect(m.DeleteStream(id)).To(Succeed())
+							}
+						}
+					}()
+				}
+				// accept the incoming streams
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for i := 0; i < num; i++ {
+						str, err := m.AcceptStream(context.Background())
+						Expect(err).ToNot(HaveOccurred())
+						Expect(str.StreamID()).To(Equal(ids.firstIncomingBidiStream + protocol.StreamID(4*i)))
+						if i%2 == 1 {
+							Expect(m.DeleteStream(str.StreamID())).To(Succeed())
+						}
+					}
+				}()
+				// look up streams, as if we were receiving frames for them
+				var lookupWg sync.WaitGroup
+				lookupWg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer lookupWg.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						for i := 0; i < num; i++ {
+							id := ids.firstOutgoingBidiStream + protocol.StreamID(4*i)
+							// the stream might not have been opened yet, or might already have been deleted
+							if str, err := m.GetOrOpenSendStream(id); err == nil && str != nil {
+								Expect(str.StreamID()).To(Equal(id))
+							}
+							id = ids.firstIncomingBidiStream + protocol.StreamID(4*i)
+							if str, err := m.GetOrOpenSendStream(id); err == nil && str != nil {
+								Expect(str.StreamID()).To(Equal(id))
+							}
+						}
+						m.HasUnsentData()
+					}
+				}()
+
+				wg.Wait()
+				close(done)
+				lookupWg.Wait()
+				for i := 0; i < num; i++ {
+					str, err := m.GetOrOpenSendStream(ids.firstOutgoingBidiStream + protocol.StreamID(4*i))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str).To(BeNil())
+					str, err = m.GetOrOpenSendStream(ids.firstIncomingBidiStream + protocol.StreamID(4*i))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(str).To(BeNil())
+				}
+			})
+
 			It("closes", func() {
 				testErr := errors.New("test error")
 				m.CloseWithError(testErr)
@@ -393,10 +557,10 @@ var _ = Describe("Streams Map", func() {
 				_, err = m.OpenUniStream()
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal(testErr.Error()))
-				_, err = m.AcceptStream()
+				_, err = m.AcceptStream(context.Background())
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal(testErr.Error()))
-				_, err = m.AcceptUniStream()
+				_, err = m.AcceptUniStream(context.Background())
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(Equal(testErr.Error()))
 			})
@@ -0,0 +1,84 @@
+package quic
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+type lruTokenStoreEntry struct {
+	key    string
+	tokens [][]byte
+}
+
+type lruTokenStore struct {
+	mutex sync.Mutex
+
+	m               map[string]*list.Element
+	q               *list.List
+	maxOrigins      int
+	tokensPerOrigin int
+}
+
+var _ TokenStore = &lruTokenStore{}
+
+// NewLRUTokenStore creates a new TokenStore.
+// It stores up to tokensPerOrigin tokens for up to maxOrigins servers.
+// If more tokens are stored for a server, the oldest token is dropped,
+// and if tokens are stored for more servers, the tokens of the least recently used server are dropped.
+// Both maxOrigins and tokensPerOrigin must be positive.
+func NewLRUTokenStore(maxOrigins, tokensPerOrigin int) (TokenStore, error) {
+	if maxOrigins <= 0 {
+		return nil, errors.New("quic: maxOrigins must be positive")
+	}
+	if tokensPerOrigin <= 0 {
+		return nil, errors.New("quic: tokensPerOrigin must be positive")
+	}
+	return &lruTokenStore{
+		m:               make(map[string]*list.Element),
+		q:               list.New(),
+		maxOrigins:      maxOrigins,
+		tokensPerOrigin: tokensPerOrigin,
+	}, nil
+}
+
+func (s *lruTokenStore) Put(key string, token []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if el, ok := s.m[key]; ok {
+		entry := el.Value.(*lruTokenStoreEntry)
+		entry.tokens = append(entry.tokens, token)
+		if len(entry.tokens) > s.tokensPerOrigin {
+			entry.tokens = entry.tokens[1:]
+		}
+		s.q.MoveToFront(el)
+		return
+	}
+
+	if s.q.Len() >= s.maxOrigins {
+		el := s.q.Back()
+		delete(s.m, el.Value.(*lruTokenStoreEntry).key)
+		s.q.Remove(el)
+	}
+	s.m[key] = s.q.PushFront(&lruTokenStoreEntry{key: key, tokens: [][]byte{token}})
+}
+
+// Pop returns the most recently stored token for the key.
+func (s *lruTokenStore) Pop(key string) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	el, ok := s.m[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*lruTokenStoreEntry)
+	token := entry.tokens[len(entry.tokens)-1]
+	entry.tokens = entry.tokens[:len(entry.tokens)-1]
+	if len(entry.tokens) == 0 {
+		delete(s.m, key)
+		s.q.Remove(el)
+	}
+	return token
+}
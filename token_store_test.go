@@ -0,0 +1,65 @@
+package quic
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LRU Token Store", func() {
+	It("errors if maxOrigins is not positive", func() {
+		_, err := NewLRUTokenStore(0, 2)
+		Expect(err).To(MatchError("quic: maxOrigins must be positive"))
+	})
+
+	It("errors if tokensPerOrigin is not positive", func() {
+		_, err := NewLRUTokenStore(2, -1)
+		Expect(err).To(MatchError("quic: tokensPerOrigin must be positive"))
+	})
+
+	It("returns nil if no token is stored", func() {
+		s, err := NewLRUTokenStore(2, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Pop("quic.clemente.io")).To(BeNil())
+	})
+
+	It("returns each token only once", func() {
+		s, err := NewLRUTokenStore(2, 2)
+		Expect(err).ToNot(HaveOccurred())
+		s.Put("quic.clemente.io", []byte("foobar"))
+		Expect(s.Pop("quic.clemente.io")).To(Equal([]byte("foobar")))
+		Expect(s.Pop("quic.clemente.io")).To(BeNil())
+	})
+
+	It("returns the most recent token first", func() {
+		s, err := NewLRUTokenStore(2, 2)
+		Expect(err).ToNot(HaveOccurred())
+		s.Put("quic.clemente.io", []byte("foo"))
+		s.Put("quic.clemente.io", []byte("bar"))
+		Expect(s.Pop("quic.clemente.io")).To(Equal([]byte("bar")))
+		Expect(s.Pop("quic.clemente.io")).To(Equal([]byte("foo")))
+		Expect(s.Pop("quic.clemente.io")).To(BeNil())
+	})
+
+	It("drops the oldest token if too many tokens are stored for a server", func() {
+		s, err := NewLRUTokenStore(2, 2)
+		Expect(err).ToNot(HaveOccurred())
+		s.Put("quic.clemente.io", []byte("foo"))
+		s.Put("quic.clemente.io", []byte("bar"))
+		s.Put("quic.clemente.io", []byte("baz"))
+		Expect(s.Pop("quic.clemente.io")).To(Equal([]byte("baz")))
+		Expect(s.Pop("quic.clemente.io")).To(Equal([]byte("bar")))
+		Expect(s.Pop("quic.clemente.io")).To(BeNil())
+	})
+
+	It("drops the tokens of the least recently used server", func() {
+		s, err := NewLRUTokenStore(2, 2)
+		Expect(err).ToNot(HaveOccurred())
+		s.Put("foo.example.com", []byte("foo"))
+		s.Put("bar.example.com", []byte("bar"))
+		s.Put("foo.example.com", []byte("foo2"))
+		s.Put("baz.example.com", []byte("baz"))
+		Expect(s.Pop("bar.example.com")).To(BeNil())
+		Expect(s.Pop("foo.example.com")).To(Equal([]byte("foo2")))
+		Expect(s.Pop("baz.example.com")).To(Equal([]byte("baz")))
+	})
+})
@@ -50,6 +50,12 @@ func (q *windowUpdateQueue) QueueAll() {
 	if q.queuedConn {
 		q.callback(&wire.MaxDataFrame{ByteOffset: q.connFlowController.GetWindowUpdate()})
 		q.queuedConn = false
+	} else if len(q.queue) > 0 {
+		// We're sending stream-level window updates anyway.
+		// Send the connection-level window update in the same packet, if it will be needed soon.
+		if offset := q.connFlowController.GetCoalescedWindowUpdate(); offset != 0 {
+			q.callback(&wire.MaxDataFrame{ByteOffset: offset})
+		}
 	}
 	// queue all stream-level window updates
 	for id := range q.queue {
@@ -27,6 +27,7 @@ var _ = Describe("Window Update Queue", func() {
 	})
 
 	It("adds stream offsets and gets MAX_STREAM_DATA frames", func() {
+		connFC.EXPECT().GetCoalescedWindowUpdate()
 		stream1 := NewMockStreamI(mockCtrl)
 		stream1.EXPECT().getWindowUpdate().Return(protocol.ByteCount(10))
 		stream3 := NewMockStreamI(mockCtrl)
@@ -41,6 +42,7 @@ var _ = Describe("Window Update Queue", func() {
 	})
 
 	It("deletes the entry after getting the MAX_STREAM_DATA frame", func() {
+		connFC.EXPECT().GetCoalescedWindowUpdate()
 		stream10 := NewMockStreamI(mockCtrl)
 		stream10.EXPECT().getWindowUpdate().Return(protocol.ByteCount(100))
 		streamGetter.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(10)).Return(stream10, nil)
@@ -52,6 +54,7 @@ var _ = Describe("Window Update Queue", func() {
 	})
 
 	It("doesn't queue a MAX_STREAM_DATA for a closed stream", func() {
+		connFC.EXPECT().GetCoalescedWindowUpdate()
 		streamGetter.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(12)).Return(nil, nil)
 		q.AddStream(12)
 		q.QueueAll()
@@ -59,6 +62,7 @@ var _ = Describe("Window Update Queue", func() {
 	})
 
 	It("doesn't queue a MAX_STREAM_DATA if the flow controller returns an offset of 0", func() {
+		connFC.EXPECT().GetCoalescedWindowUpdate()
 		stream5 := NewMockStreamI(mockCtrl)
 		stream5.EXPECT().getWindowUpdate().Return(protocol.ByteCount(0))
 		streamGetter.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(5)).Return(stream5, nil)
@@ -77,6 +81,7 @@ var _ = Describe("Window Update Queue", func() {
 	})
 
 	It("deduplicates", func() {
+		connFC.EXPECT().GetCoalescedWindowUpdate()
 		stream10 := NewMockStreamI(mockCtrl)
 		stream10.EXPECT().getWindowUpdate().Return(protocol.ByteCount(200))
 		streamGetter.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(10)).Return(stream10, nil)
@@ -87,4 +92,31 @@ var _ = Describe("Window Update Queue", func() {
 			&wire.MaxStreamDataFrame{StreamID: 10, ByteOffset: 200},
 		}))
 	})
+
+	It("queues a MAX_DATA frame together with MAX_STREAM_DATA frames, if the connection-level window will be exhausted soon", func() {
+		stream1 := NewMockStreamI(mockCtrl)
+		stream1.EXPECT().getWindowUpdate().Return(protocol.ByteCount(10))
+		streamGetter.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(1)).Return(stream1, nil)
+		connFC.EXPECT().GetCoalescedWindowUpdate().Return(protocol.ByteCount(0x1337))
+		q.AddStream(1)
+		q.QueueAll()
+		Expect(queuedFrames).To(Equal([]wire.Frame{
+			&wire.MaxDataFrame{ByteOffset: 0x1337},
+			&wire.MaxStreamDataFrame{StreamID: 1, ByteOffset: 10},
+		}))
+	})
+
+	It("doesn't ask for a coalesced MAX_DATA frame if a MAX_DATA frame was queued anyway", func() {
+		stream1 := NewMockStreamI(mockCtrl)
+		stream1.EXPECT().getWindowUpdate().Return(protocol.ByteCount(10))
+		streamGetter.EXPECT().GetOrOpenReceiveStream(protocol.StreamID(1)).Return(stream1, nil)
+		connFC.EXPECT().GetWindowUpdate().Return(protocol.ByteCount(0x42))
+		q.AddConnection()
+		q.AddStream(1)
+		q.QueueAll()
+		Expect(queuedFrames).To(Equal([]wire.Frame{
+			&wire.MaxDataFrame{ByteOffset: 0x42},
+			&wire.MaxStreamDataFrame{StreamID: 1, ByteOffset: 10},
+		}))
+	})
 })